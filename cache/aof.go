@@ -0,0 +1,409 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fsync policies for the append-only file, set in
+// StorageConfig.AppendFsync. They match Redis's appendfsync options.
+const (
+	// FsyncAlways syncs after every write command before it is acknowledged
+	FsyncAlways = "always"
+	// FsyncEverySec syncs once every StorageConfig.SyncInterval, a second
+	// by default, on a dedicated goroutine, so at most about that much of
+	// the writes is lost on power failure
+	FsyncEverySec = "everysec"
+	// FsyncNo leaves flushing to the operating system
+	FsyncNo = "no"
+)
+
+const (
+	// aofFileName is the name of the append-only file in the storage path
+	aofFileName = "appendonly.aof"
+	// defaultAOFFsyncInterval is how often the everysec policy syncs when
+	// no interval is configured
+	defaultAOFFsyncInterval = time.Second
+)
+
+// ParseFsyncPolicy validates an AppendFsync value, defaulting to everysec
+func ParseFsyncPolicy(name string) (string, error) {
+	switch policy := strings.ToLower(name); policy {
+	case "", FsyncEverySec:
+		return FsyncEverySec, nil
+	case FsyncAlways, FsyncNo:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown append fsync policy: %s", name)
+	}
+}
+
+// AOFObserver receives append-only file I/O timings, typically for metrics
+type AOFObserver interface {
+	// ObserveAOFWrite records a command of n bytes appended in d, including
+	// the fsync under the always policy
+	ObserveAOFWrite(policy string, n int, d time.Duration)
+	// ObserveAOFFsync records an fsync. delayed is set for an everysec
+	// fsync that took longer than its interval, so writes were not synced
+	// on schedule.
+	ObserveAOFFsync(d time.Duration, delayed bool)
+}
+
+// AOFStats describes the state of the append-only file
+type AOFStats struct {
+	Policy string `json:"policy"`
+	Size   int64  `json:"size"`
+	// TruncatedBytes were cut from a corrupt tail when the file was loaded
+	TruncatedBytes int64 `json:"truncated_bytes,omitempty"`
+	// LoadedBytes were replayed when the file was loaded
+	LoadedBytes int64 `json:"loaded_bytes"`
+	// WrittenBytes were appended since the file was opened, and
+	// PendingBytes of them are not yet synced under the everysec policy
+	WrittenBytes  int64     `json:"written_bytes"`
+	PendingBytes  int64     `json:"pending_bytes"`
+	LastFsync     time.Time `json:"last_fsync"`
+	DelayedFsyncs int64     `json:"delayed_fsyncs"`
+	LastError     string    `json:"last_error,omitempty"`
+	// Rewriting is set while a rewrite is in progress
+	Rewriting        bool      `json:"rewriting"`
+	Rewrites         int64     `json:"rewrites"`
+	LastRewrite      time.Time `json:"last_rewrite"`
+	LastRewriteError string    `json:"last_rewrite_error,omitempty"`
+}
+
+// AOF is an append-only log of the write commands applied to a cache, in
+// the same RESP encoding clients send with a checksum per command.
+// Replaying it rebuilds the keyspace.
+type AOF struct {
+	mu        sync.Mutex
+	file      *os.File
+	path      string
+	policy    string
+	interval  time.Duration
+	size      int64
+	truncated int64
+	loaded    int64
+	written   int64
+	pending   int64
+	dirty     bool
+	lastErr   error
+	logger    *log.Logger
+	observer  AOFObserver
+
+	lastFsync     atomic.Value // time.Time
+	delayedFsyncs int64
+
+	// rewrite holds the state of background rewrites; see aof_rewrite.go
+	rewrite aofRewriteState
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// OpenAOF opens or creates the append-only file at path for appending.
+// Under the everysec policy it is synced every interval, or every second
+// if interval is zero.
+func OpenAOF(path, policy string, interval time.Duration, logger *log.Logger) (*AOF, error) {
+	policy, err := ParseFsyncPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	if interval <= 0 {
+		interval = defaultAOFFsyncInterval
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	a := &AOF{
+		file:     file,
+		path:     path,
+		policy:   policy,
+		interval: interval,
+		size:     info.Size(),
+		logger:   logger,
+		done:     make(chan struct{}),
+	}
+	a.lastFsync.Store(time.Now())
+	if policy == FsyncEverySec {
+		a.wg.Add(1)
+		go a.fsyncLoop()
+	}
+	return a, nil
+}
+
+// SetObserver sets the receiver of write timings
+func (a *AOF) SetObserver(observer AOFObserver) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.observer = observer
+}
+
+// appendRecord appends a record logged by the WAL. The command has
+// already been applied and answered, so a failure can only be reported
+// through the logs and AOFStats.
+func (a *AOF) appendRecord(rec WALRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	start := time.Now()
+	n, err := a.appendLocked(rec.Args)
+	if err != nil {
+		a.lastErr = err
+		a.logger.Printf("AOF write failed: %v", err)
+		return
+	}
+	a.lastErr = nil
+	if a.observer != nil {
+		a.observer.ObserveAOFWrite(a.policy, n, time.Since(start))
+	}
+}
+
+// appendLocked writes one command record and returns its size. Callers
+// hold a.mu.
+func (a *AOF) appendLocked(args [][]byte) (int, error) {
+	record := encodeAOFRecord(args)
+	n, err := a.file.Write(record)
+	a.size += int64(n)
+	a.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+	a.rewrite.buffer(record)
+	a.rewrite.checkSize(a.size)
+
+	switch a.policy {
+	case FsyncAlways:
+		start := time.Now()
+		if err := a.file.Sync(); err != nil {
+			return n, err
+		}
+		a.lastFsync.Store(time.Now())
+		if a.observer != nil {
+			a.observer.ObserveAOFFsync(time.Since(start), false)
+		}
+	case FsyncEverySec:
+		a.dirty = true
+		a.pending += int64(n)
+	}
+	return n, nil
+}
+
+// fsyncLoop syncs the file every interval while there are unsynced
+// writes. The sync itself runs without a.mu so writers are not blocked by
+// the disk.
+func (a *AOF) fsyncLoop() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+
+		a.mu.Lock()
+		file := a.file
+		dirty := a.dirty
+		a.dirty = false
+		pending := a.pending
+		observer := a.observer
+		a.mu.Unlock()
+		if !dirty {
+			continue
+		}
+
+		start := time.Now()
+		if err := file.Sync(); errors.Is(err, os.ErrClosed) {
+			// A rewrite replaced the file, syncing everything first
+			continue
+		} else if err != nil {
+			a.logger.Printf("AOF fsync failed: %v", err)
+			a.mu.Lock()
+			a.lastErr = err
+			a.dirty = true
+			a.mu.Unlock()
+			continue
+		}
+		a.lastFsync.Store(time.Now())
+		a.mu.Lock()
+		// Sync may have cleared pending meanwhile
+		if a.pending -= pending; a.pending < 0 {
+			a.pending = 0
+		}
+		a.mu.Unlock()
+
+		took := time.Since(start)
+		delayed := took > a.interval
+		if delayed {
+			atomic.AddInt64(&a.delayedFsyncs, 1)
+		}
+		if observer != nil {
+			observer.ObserveAOFFsync(took, delayed)
+		}
+	}
+}
+
+// Sync flushes outstanding writes to disk now rather than on the next
+// everysec tick
+func (a *AOF) Sync() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.file.Sync(); err != nil {
+		a.lastErr = err
+		return err
+	}
+	a.lastFsync.Store(time.Now())
+	a.dirty = false
+	a.pending = 0
+	return nil
+}
+
+// Stats returns the current state of the file
+func (a *AOF) Stats() AOFStats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := AOFStats{
+		Policy:         a.policy,
+		Size:           a.size,
+		TruncatedBytes: a.truncated,
+		LoadedBytes:    a.loaded,
+		WrittenBytes:   a.written,
+		PendingBytes:   a.pending,
+		LastFsync:      a.lastFsync.Load().(time.Time),
+		DelayedFsyncs:  atomic.LoadInt64(&a.delayedFsyncs),
+	}
+	if a.lastErr != nil {
+		stats.LastError = a.lastErr.Error()
+	}
+	a.rewrite.stats(&stats)
+	return stats
+}
+
+// Close stops the fsync goroutine, syncs outstanding writes and closes the
+// file
+func (a *AOF) Close() error {
+	close(a.done)
+	a.wg.Wait()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.file.Sync(); err != nil {
+		a.file.Close()
+		return err
+	}
+	return a.file.Close()
+}
+
+// ReplayAOF applies the commands in the file at path to c and returns how
+// many were applied. A missing file is not an error. If the file has a
+// damaged record, the commands before it are applied and an
+// *AOFCorruptError is returned. Replay stops with ctx's error once ctx is
+// done.
+func ReplayAOF(ctx context.Context, c *Cache, path string) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	// The commands are in the file already, so they are not logged again
+	ctx = context.WithValue(ctx, commandLoggedKey{}, true)
+	out := NewRESPWriter(io.Discard)
+	applied, _, err := scanAOF(file, func(args [][]byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		cmd := LookupCommand(string(args[0]))
+		if cmd == nil {
+			return fmt.Errorf("unknown command %q in AOF", args[0])
+		}
+		return cmd.Handler(&CommandContext{Context: ctx, Cache: c, Args: args, Out: out})
+	})
+	if err != nil {
+		return applied, fmt.Errorf("replaying AOF: %w", err)
+	}
+	return applied, nil
+}
+
+// EnableAOF replays the append-only file in cfg.Path and then appends every
+// write command passing through the WAL to it. A corrupt tail is cut off when cfg.AOFLoadTruncated
+// is set, and otherwise fails startup. The file is rewritten in the
+// background whenever it grows past cfg.MaxFileSize.
+func (c *Cache) EnableAOF(ctx context.Context, cfg StorageConfig, logger *log.Logger) (*AOF, error) {
+	path := filepath.Join(cfg.Path, aofFileName)
+	applied, err := ReplayAOF(ctx, c, path)
+
+	var corrupt *AOFCorruptError
+	var truncated int64
+	if errors.As(err, &corrupt) && cfg.AOFLoadTruncated {
+		info, statErr := os.Stat(path)
+		if statErr != nil {
+			return nil, statErr
+		}
+		truncated = info.Size() - corrupt.Offset
+		logger.Printf("!!! %v; truncating %s to %d bytes, discarding %d bytes. "+
+			"Run aof-check on a backup copy to inspect them.", corrupt, path, corrupt.Offset, truncated)
+		if err := truncateAOF(path, corrupt.Offset); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if applied > 0 {
+		logger.Printf("Loaded %d commands from %s", applied, path)
+	}
+
+	aof, err := OpenAOF(path, cfg.AppendFsync, cfg.SyncInterval, logger)
+	if err != nil {
+		return nil, err
+	}
+	aof.truncated = truncated
+	aof.loaded = aof.size
+	aof.rewrite.autoRewrite(cfg.MaxFileSize, func() {
+		if err := c.RewriteAOF(context.Background()); err != nil && err != errAOFRewriteInProgress {
+			logger.Printf("AOF rewrite failed: %v", err)
+		}
+	})
+	c.mutex.Lock()
+	c.aof = aof
+	c.mutex.Unlock()
+	c.EnableWAL(0).addSink(aof)
+	return aof, nil
+}
+
+// AOF returns the AOF write commands are logged to, if any
+func (c *Cache) AOF() *AOF {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.aof
+}
@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Every AOF record is a checksum line followed by the command as a RESP
+// array:
+//
+//	#<crc32c of the array, 8 hex digits>\r\n*<n>\r\n$<len>\r\n<arg>\r\n...
+//
+// Records without a checksum line, as written before checksums were added,
+// are still accepted.
+const aofChecksumPrefix = '#'
+
+var aofCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrAOFCorrupt is returned when the AOF has a truncated or damaged record
+var ErrAOFCorrupt = errors.New("AOF is corrupt")
+
+// AOFCorruptError locates the first bad record of an AOF
+type AOFCorruptError struct {
+	// Offset is the end of the last valid record, where the file can be
+	// truncated
+	Offset int64
+	// Record is the 1-based index of the bad record
+	Record int
+	Reason string
+}
+
+func (e *AOFCorruptError) Error() string {
+	return fmt.Sprintf("AOF is corrupt at record %d (offset %d): %s", e.Record, e.Offset, e.Reason)
+}
+
+func (e *AOFCorruptError) Unwrap() error {
+	return ErrAOFCorrupt
+}
+
+// encodeAOFCommand encodes args as a RESP array
+func encodeAOFCommand(args [][]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte('*')
+	buf.WriteString(strconv.Itoa(len(args)))
+	buf.WriteString("\r\n")
+	for _, arg := range args {
+		buf.WriteByte('$')
+		buf.WriteString(strconv.Itoa(len(arg)))
+		buf.WriteString("\r\n")
+		buf.Write(arg)
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes()
+}
+
+// encodeAOFRecord encodes args with their checksum line
+func encodeAOFRecord(args [][]byte) []byte {
+	command := encodeAOFCommand(args)
+	record := make([]byte, 0, len(command)+11)
+	record = append(record, aofChecksumPrefix)
+	record = append(record, fmt.Sprintf("%08x\r\n", crc32.Checksum(command, aofCRCTable))...)
+	return append(record, command...)
+}
+
+// scanAOF reads records from r, calling fn for each valid command, and
+// returns the number of records and the offset after the last one. A
+// damaged or incomplete record stops the scan with an *AOFCorruptError.
+func scanAOF(r io.Reader, fn func(args [][]byte) error) (int, int64, error) {
+	br := bufio.NewReader(r)
+	reader := &RESPReader{r: br}
+	records := 0
+	var offset int64
+
+	corrupt := func(reason string) (int, int64, error) {
+		return records, offset, &AOFCorruptError{Offset: offset, Record: records + 1, Reason: reason}
+	}
+
+	for {
+		first, err := br.Peek(1)
+		if err == io.EOF {
+			return records, offset, nil
+		}
+		if err != nil {
+			return records, offset, err
+		}
+
+		var checksum uint32
+		var checked bool
+		var headerLen int64
+		if first[0] == aofChecksumPrefix {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return corrupt("truncated checksum")
+			}
+			value, err := strconv.ParseUint(string(bytes.TrimRight([]byte(line[1:]), "\r\n")), 16, 32)
+			if err != nil {
+				return corrupt("invalid checksum line")
+			}
+			checksum, checked, headerLen = uint32(value), true, int64(len(line))
+		} else if first[0] != '*' {
+			return corrupt("expected a command")
+		}
+
+		args, err := reader.ReadCommand()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return corrupt("truncated command")
+		}
+		if err != nil || len(args) == 0 {
+			return corrupt("malformed command")
+		}
+		command := encodeAOFCommand(args)
+		if checked && crc32.Checksum(command, aofCRCTable) != checksum {
+			return corrupt("checksum mismatch")
+		}
+
+		if fn != nil {
+			if err := fn(args); err != nil {
+				return records, offset, err
+			}
+		}
+		records++
+		offset += headerLen + int64(len(command))
+	}
+}
+
+// truncateAOF cuts the file at path to size bytes
+func truncateAOF(path string, size int64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// RunAOFCheck implements the aof-check subcommand, which verifies an AOF
+// and with -fix truncates it to its last valid record. It returns the
+// process exit code.
+func RunAOFCheck(args []string) int {
+	flags := flag.NewFlagSet("aof-check", flag.ContinueOnError)
+	fix := flags.Bool("fix", false, "Truncate the file to its last valid record")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: aof-check [-fix] <file.aof>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	path := flags.Arg(0)
+
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot open %s: %v\n", path, err)
+		return 1
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		fmt.Fprintf(os.Stderr, "Cannot stat %s: %v\n", path, err)
+		return 1
+	}
+	records, offset, err := scanAOF(file, nil)
+	file.Close()
+
+	var corrupt *AOFCorruptError
+	if err != nil && !errors.As(err, &corrupt) {
+		fmt.Fprintf(os.Stderr, "Cannot read %s: %v\n", path, err)
+		return 1
+	}
+	fmt.Printf("AOF analyzed: size=%d, records=%d, ok_up_to=%d, diff=%d\n",
+		info.Size(), records, offset, info.Size()-offset)
+	if corrupt == nil {
+		fmt.Println("AOF is valid")
+		return 0
+	}
+
+	fmt.Println(corrupt.Error())
+	if !*fix {
+		fmt.Println("Run with -fix to truncate the file to its last valid record")
+		return 1
+	}
+	if err := truncateAOF(path, offset); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to truncate %s: %v\n", path, err)
+		return 1
+	}
+	fmt.Printf("Successfully truncated AOF to %d bytes, discarding %d\n", offset, info.Size()-offset)
+	return 0
+}
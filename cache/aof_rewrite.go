@@ -0,0 +1,345 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// aofRewriteBatch is how many members one SADD or ZADD of a rewrite adds
+const aofRewriteBatch = 64
+
+// Errors returned by RewriteAOF
+var (
+	errAOFDisabled          = errors.New("ERR append only file is not enabled")
+	errAOFRewriteInProgress = errors.New("ERR Background append only file rewriting already in progress")
+)
+
+// aofRewriter is implemented by objects that can be written back as
+// commands. A rewrite fails rather than drop an object without it. An
+// object written as no commands, such as an empty queue, is dropped, as a
+// missing key reads the same.
+type aofRewriter interface {
+	rewriteCommands(key string) [][][]byte
+}
+
+// aofLinkRewriter is implemented by objects whose state names other keys,
+// such as the destinations of time series rules. Their links are written
+// after every key, so the keys they name exist when they are replayed.
+type aofLinkRewriter interface {
+	rewriteLinks(key string) [][][]byte
+}
+
+// aofRewriteState tracks background rewrites of an AOF. It is guarded by
+// the AOF's mutex.
+type aofRewriteState struct {
+	running   bool
+	requested bool
+	// capturing is set once the keyspace is captured; records appended
+	// from then on are buffered for the new file
+	capturing bool
+	buffered  [][]byte
+
+	// maxSize is the size that triggers a rewrite, zero for never, and
+	// next the size the next automatic rewrite waits for
+	maxSize int64
+	next    int64
+	trigger func()
+
+	count   int64
+	last    time.Time
+	lastErr error
+}
+
+// autoRewrite calls trigger whenever the file grows past maxSize
+func (s *aofRewriteState) autoRewrite(maxSize int64, trigger func()) {
+	s.maxSize, s.next, s.trigger = maxSize, maxSize, trigger
+}
+
+// buffer keeps a record appended during a rewrite
+func (s *aofRewriteState) buffer(record []byte) {
+	if s.capturing {
+		s.buffered = append(s.buffered, record)
+	}
+}
+
+// checkSize starts an automatic rewrite once the file has reached the size
+// it waits for
+func (s *aofRewriteState) checkSize(size int64) {
+	if s.trigger == nil || s.maxSize <= 0 || s.running || s.requested || size <= s.next {
+		return
+	}
+	s.requested = true
+	go s.trigger()
+}
+
+// stats fills in the rewrite fields of stats
+func (s *aofRewriteState) stats(stats *AOFStats) {
+	stats.Rewriting = s.running
+	stats.Rewrites = s.count
+	stats.LastRewrite = s.last
+	if s.lastErr != nil {
+		stats.LastRewriteError = s.lastErr.Error()
+	}
+}
+
+// beginRewrite claims the rewrite, reporting false if one is running
+func (a *AOF) beginRewrite() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s := &a.rewrite
+	s.requested = false
+	if s.running {
+		return false
+	}
+	s.running = true
+	return true
+}
+
+// startCapture buffers every record appended from now on. Callers hold the
+// WAL's lock, so the captured keyspace and the buffer meet exactly.
+func (a *AOF) startCapture() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.rewrite.capturing = true
+	a.rewrite.buffered = nil
+}
+
+// writeRewrite writes commands to a new file next to the AOF and swaps it
+// in, with the records buffered meanwhile appended
+func (a *AOF) writeRewrite(ctx context.Context, commands [][][]byte) error {
+	tmpPath := a.path + ".rewrite"
+	file, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		file.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	size := int64(0)
+	for i, args := range commands {
+		if i%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return fail(err)
+			}
+		}
+		n, err := w.Write(encodeAOFRecord(args))
+		if err != nil {
+			return fail(err)
+		}
+		size += int64(n)
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := file.Sync(); err != nil {
+		return fail(err)
+	}
+
+	// Writers wait from here until the new file is in place
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, record := range a.rewrite.buffered {
+		n, err := file.Write(record)
+		if err != nil {
+			return fail(err)
+		}
+		size += int64(n)
+	}
+	if err := file.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmpPath, a.path); err != nil {
+		return fail(err)
+	}
+	a.file.Close()
+	a.file = file
+	a.size = size
+	a.dirty = false
+	a.pending = 0
+	a.lastFsync.Store(time.Now())
+	return nil
+}
+
+// endRewrite records the outcome of a rewrite and when the next automatic
+// one is due: once the file doubles, and not before it exceeds maxSize
+// again
+func (a *AOF) endRewrite(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s := &a.rewrite
+	s.running, s.capturing, s.buffered = false, false, nil
+	s.last, s.lastErr = time.Now(), err
+	s.next = max(s.maxSize, 2*a.size)
+	if err == nil {
+		s.count++
+		a.logger.Printf("AOF rewritten, %d bytes", a.size)
+	}
+}
+
+// RewriteAOF rewrites the append-only file as the commands recreating the
+// current keyspace. Writes are held only while the keyspace is captured;
+// those made while the new file is written are buffered and appended to it.
+// It fails without touching the file if a key holds an object that cannot
+// be written as commands, which no built-in type does.
+func (c *Cache) RewriteAOF(ctx context.Context) error {
+	aof := c.AOF()
+	if aof == nil {
+		return errAOFDisabled
+	}
+	if !aof.beginRewrite() {
+		return errAOFRewriteInProgress
+	}
+
+	var commands [][][]byte
+	var err error
+	c.replicationLog().barrier(func() {
+		c.rlockAll()
+		commands, err = c.rewriteCommands(c.now())
+		c.runlockAll()
+		if err == nil {
+			aof.startCapture()
+		}
+	})
+	if err == nil {
+		err = aof.writeRewrite(ctx, commands)
+	}
+	aof.endRewrite(err)
+	return err
+}
+
+// rewriteCommands returns the commands recreating the entries live at now.
+// Callers hold every shard's lock.
+func (c *Cache) rewriteCommands(now time.Time) ([][][]byte, error) {
+	commands := make([][][]byte, 0, c.currentSize.Load())
+	var links [][][]byte
+	for _, s := range c.shards {
+		var err error
+		if commands, links, err = s.rewriteCommands(now, commands, links); err != nil {
+			return nil, err
+		}
+	}
+	return append(commands, links...), nil
+}
+
+// rewriteCommands appends the commands recreating the shard's entries live
+// at now to commands, and those recreating their links to links
+func (s *cacheShard) rewriteCommands(now time.Time, commands, links [][][]byte) ([][][]byte, [][][]byte, error) {
+	for key, entry := range s.data {
+		if entry.isExpired(now) {
+			continue
+		}
+		if entry.Object == nil {
+			commands = append(commands, setRecord(entry))
+			continue
+		}
+
+		rewriter, ok := entry.Object.(aofRewriter)
+		if !ok {
+			return nil, nil, fmt.Errorf("key %q holds a %s, which cannot be rewritten", key, entry.typeName())
+		}
+		objectCommands := rewriter.rewriteCommands(key)
+		if len(objectCommands) == 0 {
+			continue
+		}
+		commands = append(commands, objectCommands...)
+		commands = append(commands, objectMetaRecords(entry)...)
+		if linker, ok := entry.Object.(aofLinkRewriter); ok {
+			links = append(links, linker.rewriteLinks(key)...)
+		}
+	}
+	return commands, links, nil
+}
+
+// objectMetaRecords returns the commands giving a typed object the expiry,
+// tags and pin that SET carries as options for strings
+func objectMetaRecords(entry *CacheEntry) [][][]byte {
+	var commands [][][]byte
+	key := []byte(entry.Key)
+	if entry.ExpiresAt != nil {
+		commands = append(commands, [][]byte{[]byte("PEXPIREAT"), key, []byte(strconv.FormatInt(entry.ExpiresAt.UnixMilli(), 10))})
+	}
+	if len(entry.Tags) > 0 {
+		commands = append(commands, [][]byte{[]byte("SETTAGS"), key, []byte(strings.Join(entry.Tags, ","))})
+	}
+	if entry.Pinned {
+		commands = append(commands, [][]byte{[]byte("PIN"), key})
+	}
+	return commands
+}
+
+// sortedKeys returns the keys of m in order, so rewritten commands do not
+// depend on map iteration
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// setRecord returns the SET recreating a string entry with its options.
+// The expiry is absolute, so replaying the command later does not extend
+// it, except for sliding TTLs, which only reads extend and are written as
+// their duration.
+func setRecord(entry *CacheEntry) [][]byte {
+	args := [][]byte{[]byte("SET"), []byte(entry.Key), entry.Value}
+	if entry.SlidingTTL > 0 {
+		args = append(args, []byte("PX"), []byte(strconv.FormatInt(entry.SlidingTTL.Milliseconds(), 10)), []byte("SLIDING"))
+	} else if entry.ExpiresAt != nil {
+		args = append(args, []byte("PXAT"), []byte(strconv.FormatInt(entry.ExpiresAt.UnixMilli(), 10)))
+	}
+	if entry.Pinned {
+		args = append(args, []byte("PIN"))
+	}
+	if entry.Cost > 0 {
+		args = append(args, []byte("COST"), []byte(strconv.FormatInt(entry.Cost, 10)))
+	}
+	if len(entry.Tags) > 0 {
+		args = append(args, []byte("TAGS"), []byte(strings.Join(entry.Tags, ",")))
+	}
+	if entry.Origin != "" {
+		args = append(args, []byte("ORIGIN"), []byte(entry.Origin))
+	}
+	return args
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "BGREWRITEAOF", Arity: 1, Flags: FlagAdmin, Handler: bgRewriteAOFCommand},
+	)
+}
+
+// bgRewriteAOFCommand implements BGREWRITEAOF, starting a rewrite of the
+// append-only file in the background
+func bgRewriteAOFCommand(ctx *CommandContext) error {
+	aof := ctx.Cache.AOF()
+	if aof == nil {
+		return errAOFDisabled
+	}
+	if aof.Stats().Rewriting {
+		return errAOFRewriteInProgress
+	}
+	go func() {
+		if err := ctx.Cache.RewriteAOF(context.Background()); err != nil && err != errAOFRewriteInProgress {
+			aof.logger.Printf("AOF rewrite failed: %v", err)
+		}
+	}()
+	ctx.Out.WriteSimpleString("Background append only file rewriting started")
+	return nil
+}
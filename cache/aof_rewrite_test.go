@@ -0,0 +1,174 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+// rewriteRecords returns the commands rewriting c, quoted and sorted so
+// caches holding the same keyspace compare equal. Set members come in map
+// order, so they are sorted too.
+func rewriteRecords(t *testing.T, c *Cache) []string {
+	t.Helper()
+	c.rlockAll()
+	commands, err := c.rewriteCommands(c.now())
+	c.runlockAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]string, len(commands))
+	for i, args := range commands {
+		if string(args[0]) == "SADD" {
+			members := args[2:]
+			sort.Slice(members, func(i, j int) bool { return bytes.Compare(members[i], members[j]) < 0 })
+		}
+		records[i] = fmt.Sprintf("%q", args)
+	}
+	sort.Strings(records)
+	return records
+}
+
+func mustDo(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newMixedKeyspace returns a cache holding a key of every type, typed
+// objects among them with an expiry, tags and a pin
+func newMixedKeyspace(t *testing.T) *Cache {
+	t.Helper()
+	ctx := context.Background()
+	c, err := NewCacheFromConfig(DefaultConfig())
+	mustDo(t, err)
+	now := time.Now()
+	hour := time.Hour
+	at := now.Add(hour).Truncate(time.Millisecond)
+
+	mustDo(t, c.SetWithOptions(ctx, "string", []byte("value"), SetOptions{TTL: &hour, Tags: []string{"a", "b"}, Pin: true}))
+	_, err = c.SetAdd("set", "x", "y", "z")
+	mustDo(t, err)
+	_, _, err = c.ZAdd(ctx, "zset", []ScoredMember{{Member: "m", Score: 1.5}, {Member: "n", Score: -2}}, ZAddOptions{})
+	mustDo(t, err)
+
+	for i, payload := range []string{"late", "first", "second"} {
+		due := now.Add(time.Minute)
+		if i == 0 {
+			due = due.Add(time.Minute)
+		}
+		_, err = c.EnqueueDelayed("delayqueue", DelayedItem{Payload: []byte(payload), Due: due})
+		mustDo(t, err)
+	}
+	for _, item := range []PriorityItem{{Priority: 1, Payload: []byte("low")}, {Priority: 5, Payload: []byte("high")}, {Priority: 1, Payload: []byte("low2")}} {
+		_, err = c.PushPriority("priorityqueue", item)
+		mustDo(t, err)
+	}
+	for _, id := range []string{"lease1", "lease2"} {
+		_, ok, err := c.AcquireSemaphore("semaphore", 3, 1, at, id)
+		mustDo(t, err)
+		if !ok {
+			t.Fatalf("could not acquire %s", id)
+		}
+	}
+
+	mustDo(t, c.CreateTimeSeries("series", TimeSeriesOptions{Retention: time.Hour, Labels: map[string]string{"host": "a"}}))
+	mustDo(t, c.CreateTimeSeries("series:1s", TimeSeriesOptions{}))
+	mustDo(t, c.CreateCompactionRule("series", "series:1s", TSAggregation{Type: TSAvg, Bucket: time.Second}))
+	for ts := int64(0); ts < 5000; ts += 400 {
+		mustDo(t, c.AddSample("series", TSSample{Timestamp: ts, Value: float64(ts) / 3}, TimeSeriesOptions{}))
+	}
+
+	mustDo(t, c.CreateVectorIndex("vectors", VectorOptions{Dim: 3, Metric: VectorL2}))
+	mustDo(t, c.AddVector("vectors", "v1", []float32{1, 2, 3}, map[string]string{"kind": "a"}))
+	mustDo(t, c.AddVector("vectors", "v2", []float32{0.1, -4, 2.5}, nil))
+
+	// Typed objects with an expiry, tags and a pin
+	if !c.ExpireAt("set", at) || !c.SetTags("zset", []string{"objects"}) {
+		t.Fatal("could not set metadata of a typed object")
+	}
+	if _, err := c.Pin("priorityqueue"); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// checkSameKeyspace fails unless got rewrites to the same commands as want
+func checkSameKeyspace(t *testing.T, got, want *Cache) {
+	t.Helper()
+	wantRecords, gotRecords := rewriteRecords(t, want), rewriteRecords(t, got)
+	if len(gotRecords) != len(wantRecords) {
+		t.Fatalf("keyspace rewrites to %d commands, want %d", len(gotRecords), len(wantRecords))
+	}
+	for i := range wantRecords {
+		if gotRecords[i] != wantRecords[i] {
+			t.Errorf("keyspace rewrites to %s, want %s", gotRecords[i], wantRecords[i])
+		}
+	}
+}
+
+// TestRewriteAOFReplaysMixedKeyspace rewrites a keyspace holding every type,
+// replays the commands into an empty cache and checks it holds the same
+// keyspace
+func TestRewriteAOFReplaysMixedKeyspace(t *testing.T) {
+	ctx := context.Background()
+	c := newMixedKeyspace(t)
+
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	c.rlockAll()
+	commands, err := c.rewriteCommands(c.now())
+	c.runlockAll()
+	mustDo(t, err)
+	var file bytes.Buffer
+	for _, args := range commands {
+		file.Write(encodeAOFRecord(args))
+	}
+	mustDo(t, os.WriteFile(path, file.Bytes(), 0644))
+
+	replayed, err := NewCacheFromConfig(DefaultConfig())
+	mustDo(t, err)
+	if _, err := ReplayAOF(ctx, replayed, path); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSameKeyspace(t, replayed, c)
+
+	if meta, ok := replayed.Metadata("set"); !ok || meta.TTLMillis <= 0 {
+		t.Errorf("set has metadata %+v, %v after replay, want a TTL", meta, ok)
+	}
+	if n := replayed.TagCount("objects"); n != 1 {
+		t.Errorf("TagCount(objects) = %d after replay, want 1", n)
+	}
+	if item, ok, err := replayed.PopPriority("priorityqueue"); err != nil || !ok || string(item.Payload) != "high" {
+		t.Errorf("PopPriority = %q, %v, %v after replay, want high", item.Payload, ok, err)
+	}
+	if item, ok, err := replayed.PopPriority("priorityqueue"); err != nil || !ok || string(item.Payload) != "low" {
+		t.Errorf("PopPriority = %q, %v, %v after replay, want low", item.Payload, ok, err)
+	}
+	info, err := replayed.SemaphoreInfo("semaphore")
+	if err != nil || info.Limit != 3 || info.InUse != 2 {
+		t.Errorf("SemaphoreInfo = %+v, %v after replay, want 2 of 3 permits in use", info, err)
+	}
+}
+
+// TestRewriteAOFDropsEmptyQueues checks that objects written as no commands
+// leave no expiry or tags behind to fail the replay
+func TestRewriteAOFDropsEmptyQueues(t *testing.T) {
+	c, err := NewCacheFromConfig(DefaultConfig())
+	mustDo(t, err)
+	_, err = c.PushPriority("queue", PriorityItem{Payload: []byte("x")})
+	mustDo(t, err)
+	_, _, err = c.PopPriority("queue")
+	mustDo(t, err)
+	c.ExpireAt("queue", time.Now().Add(time.Hour))
+
+	if records := rewriteRecords(t, c); len(records) != 0 {
+		t.Errorf("empty queue rewrites to %v, want nothing", records)
+	}
+}
@@ -0,0 +1,151 @@
+package cache
+
+import "container/list"
+
+// ARC lists an entry can be in
+const (
+	arcRecent = iota
+	arcFrequent
+)
+
+// arcPolicy is the Adaptive Replacement Cache of Megiddo and Modha. Entries
+// seen once sit on a recency list and entries seen again on a frequency
+// list. The keys of entries recently evicted from each list are remembered
+// on ghost lists, and a miss that hits a ghost list shifts the target size
+// of the recency list towards the list the key was evicted from, so the
+// policy adapts between recency and frequency as the workload changes.
+type arcPolicy struct {
+	capacity int
+	// target is the size the recency list is kept to when evicting
+	target   int
+	recent   *list.List
+	frequent *list.List
+	// ghostRecent and ghostFrequent hold evicted keys, newest first
+	ghostRecent   *list.List
+	ghostFrequent *list.List
+	ghosts        map[string]*list.Element
+	// evicting is the last victim picked, remembered on a ghost list when
+	// the cache removes it
+	evicting *CacheEntry
+}
+
+func newARCPolicy(capacity int) *arcPolicy {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &arcPolicy{
+		capacity:      capacity,
+		recent:        list.New(),
+		frequent:      list.New(),
+		ghostRecent:   list.New(),
+		ghostFrequent: list.New(),
+		ghosts:        make(map[string]*list.Element),
+	}
+}
+
+func (p *arcPolicy) Add(entry *CacheEntry) {
+	ghost, seen := p.ghosts[entry.Key]
+	if !seen {
+		entry.segment = arcRecent
+		entry.element = p.recent.PushFront(entry)
+		return
+	}
+
+	// A ghost hit means the list it was evicted from was too small
+	recentGhosts, frequentGhosts := p.ghostRecent.Len(), p.ghostFrequent.Len()
+	if ghost.Value.(arcGhost).list == arcRecent {
+		p.target = min(p.capacity, p.target+max(frequentGhosts/max(recentGhosts, 1), 1))
+	} else {
+		p.target = max(0, p.target-max(recentGhosts/max(frequentGhosts, 1), 1))
+	}
+	p.forget(ghost)
+	entry.segment = arcFrequent
+	entry.element = p.frequent.PushFront(entry)
+}
+
+func (p *arcPolicy) Access(entry *CacheEntry) {
+	if entry.segment == arcFrequent {
+		p.frequent.MoveToFront(entry.element)
+		return
+	}
+	p.recent.Remove(entry.element)
+	entry.segment = arcFrequent
+	entry.element = p.frequent.PushFront(entry)
+}
+
+func (p *arcPolicy) Remove(entry *CacheEntry) {
+	p.list(entry.segment).Remove(entry.element)
+	entry.element = nil
+	if entry != p.evicting {
+		return
+	}
+	p.evicting = nil
+
+	ghosts := p.ghostRecent
+	if entry.segment == arcFrequent {
+		ghosts = p.ghostFrequent
+	}
+	if ghost, ok := p.ghosts[entry.Key]; ok {
+		p.forget(ghost)
+	}
+	p.ghosts[entry.Key] = ghosts.PushFront(arcGhost{key: entry.Key, list: entry.segment})
+	for p.ghostRecent.Len()+p.ghostFrequent.Len() > p.capacity {
+		oldest := p.ghostFrequent
+		if p.ghostRecent.Len() > p.ghostFrequent.Len() {
+			oldest = p.ghostRecent
+		}
+		p.forget(oldest.Back())
+	}
+}
+
+func (p *arcPolicy) Victim() *CacheEntry {
+	from := p.frequent
+	if p.recent.Len() > 0 && (p.recent.Len() > p.target || p.frequent.Len() == 0) {
+		from = p.recent
+	}
+	back := from.Back()
+	if back == nil {
+		return nil
+	}
+	p.evicting = back.Value.(*CacheEntry)
+	return p.evicting
+}
+
+func (p *arcPolicy) Len() int {
+	return p.recent.Len() + p.frequent.Len()
+}
+
+func (p *arcPolicy) Reset() {
+	p.target = 0
+	p.recent.Init()
+	p.frequent.Init()
+	p.ghostRecent.Init()
+	p.ghostFrequent.Init()
+	p.ghosts = make(map[string]*list.Element)
+	p.evicting = nil
+}
+
+// arcGhost is an evicted key and the list it was evicted from
+type arcGhost struct {
+	key  string
+	list int
+}
+
+// list returns the list of entries in segment
+func (p *arcPolicy) list(segment int) *list.List {
+	if segment == arcFrequent {
+		return p.frequent
+	}
+	return p.recent
+}
+
+// forget drops a ghost
+func (p *arcPolicy) forget(ghost *list.Element) {
+	g := ghost.Value.(arcGhost)
+	delete(p.ghosts, g.key)
+	if g.list == arcFrequent {
+		p.ghostFrequent.Remove(ghost)
+	} else {
+		p.ghostRecent.Remove(ghost)
+	}
+}
@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SAVE, BGSAVE and the snapshot schedule write the snapshots configured
+// with SetSnapshotStorage. Collecting a snapshot copies the entry headers
+// under the lock; values are never modified in place, so the copy shares
+// them and the file is written afterwards without holding the lock, which
+// is what a forked child's copy-on-write gives Redis.
+
+// errSaveInProgress is returned when a save is asked for while another runs
+var errSaveInProgress = errors.New("ERR Background save already in progress")
+
+// errNoSnapshotStorage is returned when saving without snapshot storage
+var errNoSnapshotStorage = errors.New("ERR snapshot storage is not configured")
+
+// snapshotSaves tracks the changes since the last save and its outcome.
+// All but mu and changes are guarded by the cache's mutex.
+type snapshotSaves struct {
+	// mu is held while a snapshot is written, so saves never overlap
+	mu         sync.Mutex
+	background bool
+
+	// changes is counted by writes under their shard lock alone
+	changes  atomic.Int64
+	lastSave time.Time
+	lastErr  error
+}
+
+// SaveStats describes the changes awaiting a snapshot and the last save
+type SaveStats struct {
+	ChangesSinceSave int64     `json:"changes_since_save"`
+	Saving           bool      `json:"saving"`
+	LastSave         time.Time `json:"last_save"`
+	LastError        string    `json:"last_error,omitempty"`
+}
+
+// Save writes a snapshot where SetSnapshotStorage said, failing with
+// errSaveInProgress if one is already being written
+func (c *Cache) Save(ctx context.Context) (SnapshotInfo, error) {
+	if !c.saves.mu.TryLock() {
+		return SnapshotInfo{}, errSaveInProgress
+	}
+	defer c.saves.mu.Unlock()
+
+	return c.saveLocked(ctx)
+}
+
+// BackgroundSave starts writing a snapshot and returns at once. The
+// outcome is reported by SaveStats.
+func (c *Cache) BackgroundSave() error {
+	c.mutex.RLock()
+	configured := c.snapshotStorage != nil
+	c.mutex.RUnlock()
+	if !configured {
+		return errNoSnapshotStorage
+	}
+	if !c.saves.mu.TryLock() {
+		return errSaveInProgress
+	}
+	c.setBackgroundSave(true)
+
+	go func() {
+		defer c.saves.mu.Unlock()
+		c.saveLocked(context.Background())
+		c.setBackgroundSave(false)
+	}()
+	return nil
+}
+
+// setBackgroundSave records whether BGSAVE is writing a snapshot
+func (c *Cache) setBackgroundSave(running bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.saves.background = running
+}
+
+// saveLocked writes a snapshot and records the outcome. Callers hold
+// c.saves.mu.
+func (c *Cache) saveLocked(ctx context.Context) (SnapshotInfo, error) {
+	c.mutex.RLock()
+	storage := c.snapshotStorage
+	c.mutex.RUnlock()
+	changes := c.saves.changes.Load()
+	if storage == nil {
+		return SnapshotInfo{}, errNoSnapshotStorage
+	}
+
+	info, err := c.SaveSnapshots(ctx, *storage)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.saves.lastErr = err
+	if err == nil {
+		c.saves.changes.Add(-changes)
+		c.saves.lastSave = time.Now()
+	}
+	return info, err
+}
+
+// SaveStats returns the changes since the last snapshot and how it went
+func (c *Cache) SaveStats() SaveStats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	stats := SaveStats{
+		ChangesSinceSave: c.saves.changes.Load(),
+		Saving:           c.saves.background,
+		LastSave:         c.saves.lastSave,
+	}
+	if c.saves.lastErr != nil {
+		stats.LastError = c.saves.lastErr.Error()
+	}
+	return stats
+}
+
+// StartSnapshotSchedule saves a snapshot every interval if at least
+// minChanges writes were made since the last one
+func (c *Cache) StartSnapshotSchedule(interval time.Duration, minChanges int64) {
+	minChanges = max(minChanges, 1)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if c.SaveStats().ChangesSinceSave >= minChanges {
+				c.BackgroundSave()
+			}
+		}
+	}()
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "SAVE", Arity: 1, Flags: FlagAdmin, Handler: saveCommand},
+		&Command{Name: "BGSAVE", Arity: -1, Flags: FlagAdmin, Handler: bgSaveCommand},
+		&Command{Name: "LASTSAVE", Arity: 1, Flags: FlagReadOnly, Handler: lastSaveCommand},
+	)
+}
+
+// saveCommand implements SAVE, writing a snapshot before replying
+func saveCommand(ctx *CommandContext) error {
+	if _, err := ctx.Cache.Save(ctx.Context); err != nil {
+		if err == errSaveInProgress || err == errNoSnapshotStorage {
+			return err
+		}
+		return errors.New("ERR " + err.Error())
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// bgSaveCommand implements BGSAVE [SCHEDULE]. SCHEDULE is accepted for
+// compatibility; a save already running is reported as an error either way.
+func bgSaveCommand(ctx *CommandContext) error {
+	if len(ctx.Args) > 2 || (len(ctx.Args) == 2 && !strings.EqualFold(string(ctx.Args[1]), "SCHEDULE")) {
+		return ErrSyntax
+	}
+	if err := ctx.Cache.BackgroundSave(); err != nil {
+		return err
+	}
+	ctx.Out.WriteSimpleString("Background saving started")
+	return nil
+}
+
+// lastSaveCommand implements LASTSAVE, the Unix time of the last
+// successful save, or zero if there was none
+func lastSaveCommand(ctx *CommandContext) error {
+	last := ctx.Cache.SaveStats().LastSave
+	if last.IsZero() {
+		ctx.Out.WriteInteger(0)
+		return nil
+	}
+	ctx.Out.WriteInteger(last.Unix())
+	return nil
+}
@@ -0,0 +1,254 @@
+package cache
+
+import (
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// BITFIELD treats a string as an array of integers of any width up to 64
+// bits at arbitrary bit offsets, as in Redis. Bits are numbered from the
+// most significant bit of the first byte, and the string grows with zero
+// bytes as fields past its end are written.
+
+// maxBitfieldBits bounds the bit offsets BITFIELD writes, so a string
+// cannot grow past 512MB
+const maxBitfieldBits = 512 << 20 * 8
+
+// Overflow behaviours of BITFIELD SET and INCRBY
+const (
+	overflowWrap = "WRAP"
+	overflowSat  = "SAT"
+	overflowFail = "FAIL"
+)
+
+var (
+	errBitfieldType   = errors.New("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	errBitfieldOffset = errors.New("ERR bit offset is not an integer or out of range")
+)
+
+// bitfieldType is the signedness and width of a field
+type bitfieldType struct {
+	signed bool
+	bits   uint
+}
+
+// bitfieldOp is one GET, SET or INCRBY of a BITFIELD command
+type bitfieldOp struct {
+	op       string
+	typ      bitfieldType
+	offset   uint64
+	value    int64
+	overflow string
+}
+
+// parseBitfieldType parses a type such as i16 or u8
+func parseBitfieldType(arg []byte) (bitfieldType, error) {
+	s := strings.ToLower(string(arg))
+	if len(s) < 2 || (s[0] != 'i' && s[0] != 'u') {
+		return bitfieldType{}, errBitfieldType
+	}
+	bits, err := strconv.Atoi(s[1:])
+	t := bitfieldType{signed: s[0] == 'i', bits: uint(bits)}
+	if err != nil || bits < 1 || bits > 64 || (!t.signed && bits == 64) {
+		return bitfieldType{}, errBitfieldType
+	}
+	return t, nil
+}
+
+// parseBitfieldOffset parses a bit offset, or #n for the nth field of
+// type t
+func parseBitfieldOffset(arg []byte, t bitfieldType) (uint64, error) {
+	s, scale := string(arg), uint64(1)
+	if strings.HasPrefix(s, "#") {
+		s, scale = s[1:], uint64(t.bits)
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n > maxBitfieldBits/scale || n*scale+uint64(t.bits) > maxBitfieldBits {
+		return 0, errBitfieldOffset
+	}
+	return n * scale, nil
+}
+
+// decode interprets the low bits of raw as a field of type t
+func (t bitfieldType) decode(raw uint64) int64 {
+	if t.signed && t.bits < 64 && raw&(1<<(t.bits-1)) != 0 {
+		raw |= ^uint64(0) << t.bits
+	}
+	return int64(raw)
+}
+
+// fit brings v into the range of t by overflow, reporting false if it is
+// out of range and overflow is FAIL
+func (t bitfieldType) fit(v *big.Int, overflow string) (int64, bool) {
+	lo, hi := new(big.Int), new(big.Int).Lsh(big.NewInt(1), t.bits)
+	if t.signed {
+		hi.Rsh(hi, 1)
+		lo.Neg(hi)
+	}
+	hi.Sub(hi, big.NewInt(1))
+	if v.Cmp(lo) >= 0 && v.Cmp(hi) <= 0 {
+		return v.Int64(), true
+	}
+
+	switch overflow {
+	case overflowSat:
+		if v.Sign() < 0 {
+			return lo.Int64(), true
+		}
+		return hi.Int64(), true
+	case overflowFail:
+		return 0, false
+	default:
+		span := new(big.Int).Lsh(big.NewInt(1), t.bits)
+		wrapped := new(big.Int).Mod(v, span)
+		if wrapped.Cmp(hi) > 0 {
+			wrapped.Sub(wrapped, span)
+		}
+		return wrapped.Int64(), true
+	}
+}
+
+// getBits reads width bits at offset of buf, as zeros past its end
+func getBits(buf []byte, offset uint64, width uint) uint64 {
+	var v uint64
+	for i := uint64(0); i < uint64(width); i++ {
+		bit, byteIdx := offset+i, (offset+i)/8
+		v <<= 1
+		if byteIdx < uint64(len(buf)) && buf[byteIdx]&(0x80>>(bit%8)) != 0 {
+			v |= 1
+		}
+	}
+	return v
+}
+
+// setBits writes the low width bits of v at offset of buf, which is long
+// enough
+func setBits(buf []byte, offset uint64, width uint, v uint64) {
+	for i := uint64(0); i < uint64(width); i++ {
+		bit := offset + i
+		mask := byte(0x80 >> (bit % 8))
+		if v&(1<<(uint64(width)-1-i)) != 0 {
+			buf[bit/8] |= mask
+		} else {
+			buf[bit/8] &^= mask
+		}
+	}
+}
+
+// Bitfield runs ops on the string at key, returning the result of each:
+// the value of a GET, the old value of a SET, the new value of an INCRBY,
+// or nil where overflow is FAIL and the field would overflow
+func (c *Cache) Bitfield(key string, ops []bitfieldOp) ([]*int64, error) {
+	results := make([]*int64, len(ops))
+	err := c.updateValue(key, func(value []byte) ([]byte, error) {
+		buf, written := value, false
+		for i, op := range ops {
+			old := op.typ.decode(getBits(buf, op.offset, op.typ.bits))
+			if op.op == "GET" {
+				results[i] = &old
+				continue
+			}
+
+			v := big.NewInt(op.value)
+			if op.op == "INCRBY" {
+				v.Add(v, big.NewInt(old))
+			}
+			updated, ok := op.typ.fit(v, op.overflow)
+			if !ok {
+				continue
+			}
+			if !written {
+				buf, written = append([]byte(nil), value...), true
+			}
+			if end := (op.offset + uint64(op.typ.bits) + 7) / 8; end > uint64(len(buf)) {
+				buf = append(buf, make([]byte, end-uint64(len(buf)))...)
+			}
+			setBits(buf, op.offset, op.typ.bits, uint64(updated))
+			if op.op == "SET" {
+				results[i] = &old
+			} else {
+				results[i] = &updated
+			}
+		}
+		if !written {
+			return nil, nil
+		}
+		return buf, nil
+	})
+	return results, err
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "BITFIELD", Arity: -2, Flags: FlagWrite, Handler: bitfieldCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "BITFIELD_RO", Arity: -2, Flags: FlagReadOnly, Handler: bitfieldCommand, KeyArgs: firstKeyArg},
+	)
+}
+
+// bitfieldCommand implements
+// BITFIELD key [GET type offset] [SET type offset value]
+// [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL] ...
+// and BITFIELD_RO key [GET type offset] ..., replying with an array of the
+// results of each GET, SET and INCRBY. OVERFLOW applies to the SET and
+// INCRBY operations after it; the default is WRAP.
+func bitfieldCommand(ctx *CommandContext) error {
+	readOnly := strings.EqualFold(string(ctx.Args[0]), "BITFIELD_RO")
+	var ops []bitfieldOp
+	overflow := overflowWrap
+	for i := 2; i < len(ctx.Args); {
+		op := strings.ToUpper(string(ctx.Args[i]))
+		switch {
+		case op == "OVERFLOW" && i+1 < len(ctx.Args):
+			overflow = strings.ToUpper(string(ctx.Args[i+1]))
+			if overflow != overflowWrap && overflow != overflowSat && overflow != overflowFail {
+				return errors.New("ERR Invalid OVERFLOW type specified")
+			}
+			i += 2
+			continue
+		case op == "GET" && i+2 < len(ctx.Args):
+		case (op == "SET" || op == "INCRBY") && i+3 < len(ctx.Args):
+			if readOnly {
+				return errors.New("ERR BITFIELD_RO only supports the GET subcommand")
+			}
+		default:
+			return ErrSyntax
+		}
+
+		t, err := parseBitfieldType(ctx.Args[i+1])
+		if err != nil {
+			return err
+		}
+		offset, err := parseBitfieldOffset(ctx.Args[i+2], t)
+		if err != nil {
+			return err
+		}
+		field := bitfieldOp{op: op, typ: t, offset: offset, overflow: overflow}
+		i += 3
+		if op != "GET" {
+			if field.value, err = ParseInt(ctx.Args[i]); err != nil {
+				return err
+			}
+			i++
+		}
+		ops = append(ops, field)
+	}
+
+	results, err := ctx.Cache.Bitfield(string(ctx.Args[1]), ops)
+	if err != nil {
+		if err == ErrWrongType || err == ErrOOM {
+			return err
+		}
+		return errors.New("ERR " + err.Error())
+	}
+	ctx.Out.WriteArrayHeader(len(results))
+	for _, result := range results {
+		if result == nil {
+			ctx.Out.WriteNull()
+		} else {
+			ctx.Out.WriteInteger(*result)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// keySignals wakes clients blocked on keys, such as consumers waiting for
+// queue items, when the keys are written
+type keySignals struct {
+	mu      sync.Mutex
+	waiters map[string]chan struct{}
+}
+
+func newKeySignals() *keySignals {
+	return &keySignals{waiters: make(map[string]chan struct{})}
+}
+
+// wait returns a channel closed at the next notify of key
+func (s *keySignals) wait(key string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ch, ok := s.waiters[key]
+	if !ok {
+		ch = make(chan struct{})
+		s.waiters[key] = ch
+	}
+	return ch
+}
+
+// notify wakes the clients waiting on key
+func (s *keySignals) notify(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ch, ok := s.waiters[key]; ok {
+		close(ch)
+		delete(s.waiters, key)
+	}
+}
+
+// blockOnKey calls try until it is done, fails or ctx is done. Between
+// calls it waits for key to be written, or until the retry time try
+// returned if it is not zero.
+func (c *Cache) blockOnKey(ctx context.Context, key string, try func() (done bool, retryAt time.Time, err error)) error {
+	for {
+		// Ask to be woken before trying, so a write in between is not missed
+		written := c.keySignals.wait(key)
+		done, retryAt, err := try()
+		if err != nil || done {
+			return err
+		}
+
+		var retry <-chan time.Time
+		var timer *time.Timer
+		if !retryAt.IsZero() {
+			timer = time.NewTimer(retryAt.Sub(c.now()))
+			retry = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+		case <-written:
+		case <-retry:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
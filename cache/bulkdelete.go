@@ -0,0 +1,369 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bulk delete tuning
+const (
+	bulkDeleteBatchSize = 1000
+	// bulkDeleteRetention is how long finished jobs stay queryable
+	bulkDeleteRetention = time.Hour
+)
+
+// ErrBulkDeleteNotFound is returned for an unknown bulk delete job
+var ErrBulkDeleteNotFound = errors.New("no such bulk delete job")
+
+// BulkDeleteState describes the progress of a bulk delete job
+type BulkDeleteState string
+
+const (
+	BulkDeleteRunning   BulkDeleteState = "running"
+	BulkDeleteDone      BulkDeleteState = "done"
+	BulkDeleteCancelled BulkDeleteState = "cancelled"
+)
+
+// BulkDeleteStatus is a snapshot of a bulk delete job
+type BulkDeleteStatus struct {
+	ID      string          `json:"id"`
+	Pattern string          `json:"pattern"`
+	Prefix  bool            `json:"prefix"`
+	Rate    int             `json:"rate"`
+	State   BulkDeleteState `json:"state"`
+	Matched int64           `json:"matched"`
+	Deleted int64           `json:"deleted"`
+	Started time.Time       `json:"started"`
+	Elapsed time.Duration   `json:"elapsed"`
+}
+
+// BulkDeleteJob removes every key matching a glob pattern or prefix in the
+// background, a batch at a time, so large deletions neither block the cache
+// nor require clients to enumerate keys themselves
+type BulkDeleteJob struct {
+	id      string
+	pattern string
+	prefix  bool
+	rate    int
+	started time.Time
+	cancel  context.CancelFunc
+
+	matched int64
+	deleted int64
+
+	mu       sync.Mutex
+	state    BulkDeleteState
+	finished time.Time
+}
+
+// Status returns the job's current progress
+func (j *BulkDeleteJob) Status() BulkDeleteStatus {
+	j.mu.Lock()
+	state, finished := j.state, j.finished
+	j.mu.Unlock()
+
+	if finished.IsZero() {
+		finished = time.Now()
+	}
+	return BulkDeleteStatus{
+		ID:      j.id,
+		Pattern: j.pattern,
+		Prefix:  j.prefix,
+		Rate:    j.rate,
+		State:   state,
+		Matched: atomic.LoadInt64(&j.matched),
+		Deleted: atomic.LoadInt64(&j.deleted),
+		Started: j.started,
+		Elapsed: finished.Sub(j.started),
+	}
+}
+
+// Cancel stops the job after the batch in progress
+func (j *BulkDeleteJob) Cancel() {
+	j.cancel()
+}
+
+func (j *BulkDeleteJob) finish(state BulkDeleteState) {
+	j.mu.Lock()
+	j.state = state
+	j.finished = time.Now()
+	j.mu.Unlock()
+}
+
+// bulkDeleteRegistry tracks running and recently finished jobs
+type bulkDeleteRegistry struct {
+	mu     sync.Mutex
+	nextID int64
+	jobs   map[string]*BulkDeleteJob
+}
+
+func newBulkDeleteRegistry() *bulkDeleteRegistry {
+	return &bulkDeleteRegistry{jobs: make(map[string]*BulkDeleteJob)}
+}
+
+func (r *bulkDeleteRegistry) add(job *BulkDeleteJob) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Forget jobs that finished long ago
+	for id, old := range r.jobs {
+		old.mu.Lock()
+		expired := !old.finished.IsZero() && time.Since(old.finished) > bulkDeleteRetention
+		old.mu.Unlock()
+		if expired {
+			delete(r.jobs, id)
+		}
+	}
+
+	r.nextID++
+	job.id = strconv.FormatInt(r.nextID, 10)
+	r.jobs[job.id] = job
+}
+
+// StartBulkDelete starts deleting every key matching pattern, a glob unless
+// prefix is set, in which case pattern is a literal key prefix. A positive
+// rate caps deletions per second. Keys are matched when the job starts, so
+// keys created afterwards survive, while matching keys rewritten during the
+// job are still deleted. Each batch is logged as a DEL of the keys it
+// deleted, unless ctx is that of a command logged as sent or replayed.
+func (c *Cache) StartBulkDelete(ctx context.Context, pattern string, prefix bool, rate int) *BulkDeleteJob {
+	logCtx := context.WithoutCancel(ctx)
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &BulkDeleteJob{
+		pattern: pattern,
+		prefix:  prefix,
+		rate:    rate,
+		started: time.Now(),
+		cancel:  cancel,
+		state:   BulkDeleteRunning,
+	}
+	c.bulkDeletes.add(job)
+
+	go c.runBulkDelete(ctx, logCtx, job)
+	return job
+}
+
+// BulkDelete returns a running or recently finished job by id
+func (c *Cache) BulkDelete(id string) (*BulkDeleteJob, error) {
+	c.bulkDeletes.mu.Lock()
+	defer c.bulkDeletes.mu.Unlock()
+
+	job, ok := c.bulkDeletes.jobs[id]
+	if !ok {
+		return nil, ErrBulkDeleteNotFound
+	}
+	return job, nil
+}
+
+// BulkDeletes returns the status of every known job, oldest first
+func (c *Cache) BulkDeletes() []BulkDeleteStatus {
+	c.bulkDeletes.mu.Lock()
+	jobs := make([]*BulkDeleteJob, 0, len(c.bulkDeletes.jobs))
+	for _, job := range c.bulkDeletes.jobs {
+		jobs = append(jobs, job)
+	}
+	c.bulkDeletes.mu.Unlock()
+
+	statuses := make([]BulkDeleteStatus, len(jobs))
+	for i, job := range jobs {
+		statuses[i] = job.Status()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Started.Before(statuses[j].Started) })
+	return statuses
+}
+
+func (c *Cache) runBulkDelete(ctx, logCtx context.Context, job *BulkDeleteJob) {
+	defer job.cancel()
+
+	keys := c.matchKeys(job.pattern, job.prefix)
+	atomic.StoreInt64(&job.matched, int64(len(keys)))
+
+	batch := bulkDeleteBatchSize
+	var interval time.Duration
+	if job.rate > 0 {
+		if job.rate < batch {
+			batch = job.rate
+		}
+		interval = time.Duration(batch) * time.Second / time.Duration(job.rate)
+	}
+
+	for len(keys) > 0 {
+		start := time.Now()
+		n := batch
+		if n > len(keys) {
+			n = len(keys)
+		}
+
+		c.deleteBatch(logCtx, job, keys[:n])
+		keys = keys[n:]
+
+		wait := time.Duration(0)
+		if len(keys) > 0 {
+			wait = interval - time.Since(start)
+		}
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+		if ctx.Err() != nil {
+			job.finish(BulkDeleteCancelled)
+			return
+		}
+	}
+	job.finish(BulkDeleteDone)
+}
+
+// deleteBatch deletes the keys still present and logs them as one DEL
+func (c *Cache) deleteBatch(ctx context.Context, job *BulkDeleteJob, keys []string) {
+	c.logWrite(ctx, keys, func() ([][]byte, error) {
+		shards := c.lockKeys(keys...)
+		defer unlockShards(shards)
+
+		args := [][]byte{[]byte("DEL")}
+		for _, key := range keys {
+			if entry := c.storedEntry(key); entry != nil {
+				c.shardFor(key).dropEntry(entry, RemovalDeleted)
+				atomic.AddInt64(&job.deleted, 1)
+				args = append(args, []byte(key))
+			}
+		}
+		if len(args) == 1 {
+			return nil, nil
+		}
+		return args, nil
+	})
+}
+
+// matchKeys returns every key matching a glob pattern or literal prefix.
+// The scan is restricted to the pattern's literal prefix, which uses the
+// prefix index when it is enabled.
+func (c *Cache) matchKeys(pattern string, prefix bool) []string {
+	c.rlockAll()
+	defer c.runlockAll()
+
+	keys := make([]string, 0)
+	if prefix {
+		c.walkPrefix(pattern, func(key string) bool {
+			keys = append(keys, key)
+			return true
+		})
+		return keys
+	}
+
+	c.walkPrefix(globPrefix(pattern), func(key string) bool {
+		if GlobMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+	return keys
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "DELPATTERN", Arity: -2, Flags: FlagWrite | FlagSelfLogged, Handler: delPatternCommand},
+		&Command{Name: "UNLINKPREFIX", Arity: -2, Flags: FlagWrite | FlagSelfLogged, Handler: unlinkPrefixCommand},
+		&Command{Name: "BULKDEL.STATUS", Arity: 2, Flags: FlagReadOnly, Handler: bulkDelStatusCommand},
+		&Command{Name: "BULKDEL.LIST", Arity: 1, Flags: FlagReadOnly, Handler: bulkDelListCommand},
+		// Cancelling is logged as nothing: replicas replay the batches the
+		// job deleted before it stopped, not the job itself
+		&Command{Name: "BULKDEL.CANCEL", Arity: 2, Flags: FlagWrite | FlagSelfLogged, Handler: bulkDelCancelCommand},
+	)
+}
+
+// delPatternCommand implements DELPATTERN pattern [RATE keysPerSecond],
+// replying with the id of the background job
+func delPatternCommand(ctx *CommandContext) error {
+	return startBulkDelete(ctx, false)
+}
+
+// unlinkPrefixCommand implements UNLINKPREFIX prefix [RATE keysPerSecond],
+// replying with the id of the background job
+func unlinkPrefixCommand(ctx *CommandContext) error {
+	return startBulkDelete(ctx, true)
+}
+
+func startBulkDelete(ctx *CommandContext, prefix bool) error {
+	rate := 0
+	switch len(ctx.Args) {
+	case 2:
+	case 4:
+		if !strings.EqualFold(string(ctx.Args[2]), "RATE") {
+			return ErrSyntax
+		}
+		n, err := ParseInt(ctx.Args[3])
+		if err != nil || n < 0 {
+			return ErrNotInteger
+		}
+		rate = int(n)
+	default:
+		return ErrSyntax
+	}
+
+	job := ctx.Cache.StartBulkDelete(ctx.Context, string(ctx.Args[1]), prefix, rate)
+	ctx.Out.WriteBulkString(job.id)
+	return nil
+}
+
+// writeBulkDeleteStatus replies with a flat field/value array
+func writeBulkDeleteStatus(out *RESPWriter, status BulkDeleteStatus) {
+	kind := "pattern"
+	if status.Prefix {
+		kind = "prefix"
+	}
+	out.WriteArrayHeader(14)
+	out.WriteBulkString("id")
+	out.WriteBulkString(status.ID)
+	out.WriteBulkString(kind)
+	out.WriteBulkString(status.Pattern)
+	out.WriteBulkString("state")
+	out.WriteBulkString(string(status.State))
+	out.WriteBulkString("matched")
+	out.WriteInteger(status.Matched)
+	out.WriteBulkString("deleted")
+	out.WriteInteger(status.Deleted)
+	out.WriteBulkString("rate")
+	out.WriteInteger(int64(status.Rate))
+	out.WriteBulkString("elapsed_ms")
+	out.WriteInteger(status.Elapsed.Milliseconds())
+}
+
+// bulkDelStatusCommand implements BULKDEL.STATUS id
+func bulkDelStatusCommand(ctx *CommandContext) error {
+	job, err := ctx.Cache.BulkDelete(string(ctx.Args[1]))
+	if err != nil {
+		return fmt.Errorf("ERR %v", err)
+	}
+	writeBulkDeleteStatus(ctx.Out, job.Status())
+	return nil
+}
+
+// bulkDelListCommand implements BULKDEL.LIST
+func bulkDelListCommand(ctx *CommandContext) error {
+	statuses := ctx.Cache.BulkDeletes()
+	ctx.Out.WriteArrayHeader(len(statuses))
+	for _, status := range statuses {
+		writeBulkDeleteStatus(ctx.Out, status)
+	}
+	return nil
+}
+
+// bulkDelCancelCommand implements BULKDEL.CANCEL id
+func bulkDelCancelCommand(ctx *CommandContext) error {
+	job, err := ctx.Cache.BulkDelete(string(ctx.Args[1]))
+	if err != nil {
+		return fmt.Errorf("ERR %v", err)
+	}
+	job.Cancel()
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitBulkDelete waits for a bulk delete job to stop running
+func waitBulkDelete(t *testing.T, c *Cache, id string) BulkDeleteStatus {
+	t.Helper()
+	job, err := c.BulkDelete(id)
+	mustDo(t, err)
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if status := job.Status(); status.State != BulkDeleteRunning {
+			return status
+		}
+	}
+	t.Fatalf("bulk delete %s is still running", id)
+	return BulkDeleteStatus{}
+}
+
+// TestBulkDeleteLogsDeletedBatches checks that a bulk delete reaches the
+// WAL as DELs of the keys it removed rather than as the pattern, which
+// would match different keys on a replica
+func TestBulkDeleteLogsDeletedBatches(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewCacheFromConfig(DefaultConfig())
+	mustDo(t, err)
+	wal := c.EnableWAL(1 << 20)
+	for i := 0; i < bulkDeleteBatchSize+10; i++ {
+		mustDo(t, c.SetWithOptions(ctx, fmt.Sprintf("doomed:%d", i), []byte("v"), SetOptions{}))
+	}
+	mustDo(t, c.SetWithOptions(ctx, "kept", []byte("v"), SetOptions{}))
+	start := wal.Stats().Seq
+
+	reply := runCommand(t, c, "DELPATTERN", "doomed:*")
+	id := strings.Split(reply, "\r\n")[1]
+	if status := waitBulkDelete(t, c, id); status.State != BulkDeleteDone || status.Deleted != bulkDeleteBatchSize+10 {
+		t.Fatalf("bulk delete finished as %+v", status)
+	}
+	runCommand(t, c, "BULKDEL.CANCEL", id)
+
+	records, err := wal.ReadFrom(start, 100)
+	mustDo(t, err)
+	if len(records) != 2 {
+		t.Fatalf("bulk delete logged %d records, want a DEL per batch", len(records))
+	}
+	deleted := 0
+	for _, record := range records {
+		if string(record.Args[0]) != "DEL" {
+			t.Fatalf("bulk delete logged %s, want DEL", record.Args[0])
+		}
+		for _, key := range record.Args[1:] {
+			if !strings.HasPrefix(string(key), "doomed:") {
+				t.Errorf("bulk delete logged a DEL of %s", key)
+			}
+		}
+		deleted += len(record.Args) - 1
+	}
+	if deleted != bulkDeleteBatchSize+10 {
+		t.Errorf("bulk delete logged %d deleted keys, want %d", deleted, bulkDeleteBatchSize+10)
+	}
+	if _, ok := c.Get(ctx, "kept"); !ok {
+		t.Error("bulk delete removed kept")
+	}
+}
@@ -0,0 +1,686 @@
+// Package cache is the cache engine: the keyspace, its commands,
+// persistence and clustering
+package cache
+
+import (
+	"container/heap"
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheEntry represents a cache entry with TTL
+type CacheEntry struct {
+	Key        string
+	Value      []byte
+	Object     cacheObject
+	Tags       []string
+	ExpiresAt  *time.Time
+	// SlidingTTL, when set, extends ExpiresAt by this much on every read
+	SlidingTTL time.Duration
+	// Pinned entries are never evicted, only deleted or expired
+	Pinned     bool
+	// Cost, when set, counts against the cache's cost budget in place of
+	// the entry's memory usage
+	Cost       int64
+	// Origin is a free-form note on where the value came from, set by the
+	// writer for debugging
+	Origin     string
+	CreatedAt  time.Time
+	AccessCount int64
+	LastAccessed time.Time
+	// element, segment, slot and referenced hold the eviction policy's
+	// bookkeeping
+	element    *list.Element
+	segment    int
+	slot       int
+	referenced bool
+	expiryAt   time.Time
+	expiryPos  int
+	// memory is the estimated memory charged for the entry
+	memory     int64
+	// version changes whenever the value is written; see CHECKMSET
+	version    uint64
+}
+
+// SetOptions controls how a value is stored
+type SetOptions struct {
+	TTL *time.Duration
+	// ExpiresAt, when set instead of TTL, is when the entry expires. A
+	// time already past deletes the key.
+	ExpiresAt *time.Time
+	// Tags allow the entry to be removed with InvalidateTag
+	Tags []string
+	// Sliding makes every read extend the TTL by its original duration
+	Sliding bool
+	// Pin excludes the entry from eviction. Overwriting a pinned key keeps
+	// it pinned while it fits within the pinned memory limit.
+	Pin bool
+	// Cost overrides the entry's default cost, its memory usage in bytes
+	Cost int64
+	// Origin records where the value came from; see METADATA
+	Origin string
+}
+
+// Cache implements an LRU cache with TTL support. The keyspace is split
+// into shards, each under its own lock; mutex guards the settings and
+// statistics that are neither atomic nor per shard, and is taken after
+// any shard lock, never before.
+type Cache struct {
+	shards   []*cacheShard
+	// shardHash picks the shard of a key when there is more than one
+	shardHash func(key string) uint64
+	maxSize  int
+	currentSize atomic.Int64
+	mutex    sync.RWMutex
+	notifier *keyspaceNotifier
+	bulkDeletes *bulkDeleteRegistry
+	// namespaces is replaced as a whole when a namespace is configured, so
+	// writes can read it under their shard lock alone
+	namespaces  atomic.Pointer[map[string]NamespaceOptions]
+	origins     *originFetches
+	defaultTTL  time.Duration
+	// now is the clock expiries are set and checked against
+	now         func() time.Time
+	pinnedBytes atomic.Int64
+	maxPinnedBytes int64
+	removals    *eventDispatcher[RemovalEvent]
+	totalCost   atomic.Int64
+	maxCost     int64
+	usedMemory  atomic.Int64
+	softCost    int64
+	memoryLimitAction string
+	pressure    atomic.Int32
+	pressureSignal chan struct{}
+	pressureEvents *eventDispatcher[MemoryPressureEvent]
+	defragging  bool
+	defragStats DefragStats
+	idleStats   IdleReapStats
+	// trace samples accesses for the eviction simulator
+	trace       atomic.Pointer[accessTrace]
+	// normalizing is set once any namespace normalizes its keys
+	normalizing atomic.Bool
+	// policyName is the eviction policy's name
+	policyName  string
+	// hits counts the hits and misses of Get
+	hits        *keyspaceHits
+	// refreshMarks are the keys refreshed ahead of expiry, and
+	// refreshingAhead is set once any key or namespace is
+	refreshMarks    refreshAheadMarks
+	refreshingAhead atomic.Bool
+	refreshStats    refreshAheadStats
+	nodeID      string
+	// cluster is the membership table this node reports in NODE.STATUS
+	cluster     *Cluster
+	// scheduler runs the configured jobs SCHEDULE controls
+	scheduler   *Scheduler
+	// snapshotStorage is where SHUTDOWN saves its final snapshot
+	snapshotStorage *StorageConfig
+	// saves tracks SAVE, BGSAVE and scheduled snapshots
+	saves       snapshotSaves
+	// versions issues entry versions. It starts from the clock so versions
+	// from before a restart are not issued again.
+	versions    atomic.Uint64
+	// keySignals wakes clients blocked on keys
+	keySignals  *keySignals
+	// ids issues the IDs of IDGEN
+	ids         *IDGenerator
+	// witness refuses keyspace commands on a node that only votes
+	witness     bool
+	// replica is the lag last reported by a primary replicating here
+	replica     replicaState
+	aof         *AOF
+	wal         atomic.Pointer[WAL]
+	// snapshotBase and incrementals describe the full snapshot changes are
+	// tracked against. They are written with every shard locked.
+	snapshotBase time.Time
+	incrementals int
+	// warmup is the running or last warm-up from snapshots
+	warmup       atomic.Pointer[warmup]
+	// txnLocks are the keys pending distributed transactions hold
+	txnLocks     txnLocks
+}
+
+// NewCache creates a new cache with the specified maximum size, in a
+// single shard
+func NewCache(maxSize int) *Cache {
+	c := &Cache{
+		maxSize: maxSize,
+		notifier: newKeyspaceNotifier(),
+		bulkDeletes: newBulkDeleteRegistry(),
+		removals:    newEventDispatcher[RemovalEvent](removalQueueSize),
+		origins:     newOriginFetches(),
+		keySignals:  newKeySignals(),
+		ids:         &IDGenerator{},
+		memoryLimitAction: MemoryLimitEvict,
+		pressureSignal:    make(chan struct{}, 1),
+		pressureEvents:    newEventDispatcher[MemoryPressureEvent](pressureQueueSize),
+		policyName:        EvictionLRU,
+		hits:              newKeyspaceHits(1, nil),
+		now:               time.Now,
+	}
+	c.shards = []*cacheShard{newCacheShard(c, 0, newLRUPolicy())}
+	c.namespaces.Store(&map[string]NamespaceOptions{})
+	c.versions.Store(uint64(time.Now().UnixNano()))
+	return c
+}
+
+// NewCacheFromConfig creates a cache sized and configured by cfg, with
+// cfg.ShardCount shards
+func NewCacheFromConfig(cfg Config) (*Cache, error) {
+	shards := max(cfg.ShardCount, 1)
+	// Each shard's policy is sized for its share of the keys
+	shardCfg := cfg
+	shardCfg.MaxKeys = (cfg.MaxKeys + shards - 1) / shards
+	policies := make([]evictionPolicy, shards)
+	for i := range policies {
+		policy, err := newEvictionPolicy(shardCfg)
+		if err != nil {
+			return nil, err
+		}
+		policies[i] = policy
+	}
+	action, err := ParseMemoryLimitAction(cfg.MemoryLimitAction)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewCache(cfg.MaxKeys)
+	if cfg.EvictionPolicy != "" {
+		c.policyName = strings.ToLower(cfg.EvictionPolicy)
+	}
+	c.defaultTTL = cfg.DefaultTTL
+	c.maxPinnedBytes = cfg.MaxPinnedBytes
+	c.maxCost = cfg.MaxMemory
+	c.softCost = cfg.MaxMemory * int64(cfg.MemorySoftLimitPercent) / 100
+	c.memoryLimitAction = action
+	if shards > 1 {
+		hash, err := shardHash(cfg.ShardHash)
+		if err != nil {
+			return nil, err
+		}
+		c.shardHash = hash
+		c.hits = newKeyspaceHits(shards, hash)
+	}
+	c.shards = make([]*cacheShard, shards)
+	for i, policy := range policies {
+		c.shards[i] = newCacheShard(c, i, policy)
+	}
+	namespaces := make(map[string]NamespaceOptions, len(cfg.Namespaces))
+	for ns, opts := range cfg.Namespaces {
+		namespaces[ns] = opts
+		c.hits.countNamespace(ns)
+		if opts.Keys.enabled() {
+			c.normalizing.Store(true)
+		}
+		if opts.RefreshAhead > 0 {
+			c.refreshingAhead.Store(true)
+		}
+	}
+	c.namespaces.Store(&namespaces)
+	if err := c.SetAccessTrace(cfg.AccessTraceSampleRate, cfg.AccessTraceSize); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Get retrieves a value from the cache. Lookups never block, so ctx is
+// only carried for the caller's trace; see GetOrFetch for read-through.
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, bool) {
+	s := c.shardFor(key)
+	if len(c.shards) > 1 {
+		if value, ok, done := s.getShared(key); done {
+			return value, ok
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry, exists := s.data[key]
+	if !exists {
+		c.traceAccess(key, 0, false)
+		c.hits.miss(key, false)
+		return nil, false
+	}
+
+	// Check if expired
+	if entry.ExpiresAt != nil && c.now().After(*entry.ExpiresAt) {
+		s.dropEntry(entry, RemovalExpired)
+		c.hits.miss(key, true)
+		return nil, false
+	}
+
+	// Only string values can be read as bytes
+	if entry.Object != nil {
+		return nil, false
+	}
+
+	// Update access statistics and move to front (most recently used)
+	s.touchEntry(entry)
+	c.hits.hit(key)
+	if c.dueForRefresh(entry, c.now()) {
+		c.refreshAhead(key)
+	}
+
+	return entry.Value, true
+}
+
+// Set stores a value in the cache with optional TTL. It fails as
+// SetWithOptions does.
+func (c *Cache) Set(ctx context.Context, key string, value []byte, ttl *time.Duration) error {
+	return c.SetWithOptions(ctx, key, value, SetOptions{TTL: ttl})
+}
+
+// SetWithOptions stores a value in the cache. It fails when opts.Pin is set
+// and the value does not fit within the pinned memory limit, or with ErrOOM
+// when the cache rejects writes at its memory limit. A write whose ctx is
+// already done is not applied and returns ctx's error. Like the other
+// exported writes, it is logged to the WAL as it was stored.
+func (c *Cache) SetWithOptions(ctx context.Context, key string, value []byte, opts SetOptions) error {
+	return c.logWrite(ctx, []string{key}, func() ([][]byte, error) {
+		return c.set(ctx, key, value, opts)
+	})
+}
+
+// set stores a value as SetWithOptions does, returning the command that
+// recreates the stored entry, or that deletes the key if opts.ExpiresAt
+// has passed, for the WAL. It returns nil if nothing changed.
+func (c *Cache) set(ctx context.Context, key string, value []byte, opts SetOptions) ([][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s := c.shardFor(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.setLocked(key, value, opts)
+}
+
+// setLocked stores a value as set does
+func (s *cacheShard) setLocked(key string, value []byte, opts SetOptions) ([][]byte, error) {
+	c := s.cache
+	now := s.cache.now()
+	ttl := opts.TTL
+	if opts.ExpiresAt != nil {
+		if !opts.ExpiresAt.After(now) {
+			old, exists := s.data[key]
+			if !exists {
+				return nil, nil
+			}
+			s.dropEntry(old, RemovalExpired)
+			return [][]byte{[]byte("DEL"), []byte(key)}, nil
+		}
+		left := opts.ExpiresAt.Sub(now)
+		ttl = &left
+	}
+	entry := &CacheEntry{
+		Key:          key,
+		Value:        value,
+		Tags:         opts.Tags,
+		Cost:         opts.Cost,
+		Origin:       opts.Origin,
+		CreatedAt:    now,
+		LastAccessed: now,
+	}
+	if ttl := c.effectiveTTL(key, ttl); ttl != nil {
+		expiresAt := now.Add(*ttl)
+		entry.ExpiresAt = &expiresAt
+		if c.slidingFor(key, opts.Sliding) {
+			entry.SlidingTTL = *ttl
+		}
+	}
+	size := entry.memoryUsage()
+
+	cost := size
+	if opts.Cost > 0 {
+		cost = opts.Cost
+	}
+
+	pin := opts.Pin
+	old, exists := s.data[key]
+	if !exists {
+		old = &CacheEntry{}
+	}
+	if err := c.admitWrite(cost - old.cost()); err != nil {
+		return nil, err
+	}
+	if exists {
+		pin = pin || old.Pinned
+		if opts.Pin && !c.pinFits(size-old.pinnedSize()) {
+			return nil, ErrPinLimit
+		}
+		// Remove existing entry
+		s.removeEntry(old)
+	} else if opts.Pin && !c.pinFits(size) {
+		return nil, ErrPinLimit
+	}
+	entry.Pinned = pin && c.pinFits(size)
+
+	s.insertEntry(entry)
+	return setRecord(entry), nil
+}
+
+// updateValue replaces the string value at key with the result of fn,
+// which gets nil for a missing key. The entry keeps its TTL, tags, cost and
+// pin. fn must not modify the value it is given; returning nil leaves the
+// key untouched.
+func (c *Cache) updateValue(key string, fn func(value []byte) ([]byte, error)) error {
+	s := c.shardFor(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	old := s.lookupLive(key)
+	if old != nil && old.Object != nil {
+		return ErrWrongType
+	}
+	var value []byte
+	if old != nil {
+		value = old.Value
+	}
+	updated, err := fn(value)
+	if err != nil || updated == nil {
+		return err
+	}
+
+	now := c.now()
+	entry := &CacheEntry{Key: key, Value: updated, CreatedAt: now, LastAccessed: now}
+	if old == nil {
+		if ttl := c.effectiveTTL(key, nil); ttl != nil {
+			expiresAt := now.Add(*ttl)
+			entry.ExpiresAt = &expiresAt
+			if c.slidingFor(key, false) {
+				entry.SlidingTTL = *ttl
+			}
+		}
+		old = &CacheEntry{}
+	} else {
+		entry.Tags, entry.ExpiresAt, entry.SlidingTTL = old.Tags, old.ExpiresAt, old.SlidingTTL
+		entry.Cost, entry.CreatedAt, entry.AccessCount = old.Cost, old.CreatedAt, old.AccessCount+1
+		entry.Origin = old.Origin
+	}
+	size := entry.memoryUsage()
+	cost := size
+	if entry.Cost > 0 {
+		cost = entry.Cost
+	}
+	if err := c.admitWrite(cost - old.cost()); err != nil {
+		return err
+	}
+	if old.Key != "" {
+		s.removeEntry(old)
+	}
+	entry.Pinned = old.Pinned && c.pinFits(size)
+	s.insertEntry(entry)
+	return nil
+}
+
+// insertEntry adds a new entry to the shard, evicting as needed. Callers
+// have removed any previous entry for the key.
+func (s *cacheShard) insertEntry(entry *CacheEntry) {
+	c := s.cache
+	key := entry.Key
+
+	entry.memory = entry.memoryUsage()
+	c.usedMemory.Add(entry.memory)
+	entry.version = c.versions.Add(1)
+
+	// Pinned entries stay out of the eviction policy so they are never evicted
+	if entry.Pinned {
+		c.pinnedBytes.Add(entry.memory)
+	} else {
+		s.policy.Add(entry)
+	}
+	s.data[key] = entry
+	if s.rebuilding != nil {
+		s.rebuilding[key] = entry
+	}
+	s.markDirty(key)
+	c.traceAccess(key, entry.cost(), true)
+	c.currentSize.Add(1)
+	c.totalCost.Add(entry.cost())
+	s.indexEntry(entry)
+	s.addToPrefixIndex(key)
+	s.addToTagIndex(entry)
+	s.trackExpiry(entry)
+	c.notifier.publish(KeyEventSet, key)
+
+	// Evict if over capacity
+	s.evictOverflow(0)
+	c.updatePressure()
+}
+
+// Delete removes a key from the cache, reporting whether it existed. A
+// delete whose ctx is already done is not applied and returns ctx's error.
+func (c *Cache) Delete(ctx context.Context, key string) (bool, error) {
+	deleted := false
+	err := c.logWrite(ctx, []string{key}, func() ([][]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		s := c.shardFor(key)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		entry, exists := s.data[key]
+		if !exists {
+			return nil, nil
+		}
+		s.dropEntry(entry, RemovalDeleted)
+		deleted = true
+		return [][]byte{[]byte("DEL"), []byte(key)}, nil
+	})
+	return deleted, err
+}
+
+// Exists checks if a key exists in the cache
+func (c *Cache) Exists(ctx context.Context, key string) bool {
+	s := c.shardFor(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entry, exists := s.data[key]
+	if !exists {
+		return false
+	}
+
+	// Check expiration
+	if entry.ExpiresAt != nil && c.now().After(*entry.ExpiresAt) {
+		// Note: We don't remove here to avoid write lock in read operation
+		// The entry will be cleaned up on next access
+		return false
+	}
+
+	return true
+}
+
+// Clear removes all entries from the cache
+func (c *Cache) Clear() {
+	c.lockAll()
+	defer c.unlockAll()
+
+	for _, s := range c.shards {
+		s.data = make(map[string]*CacheEntry)
+		s.rebuilding = nil
+		s.dirtyAll = s.dirtyKeys != nil
+		s.policy.Reset()
+		for _, idx := range s.indexes {
+			idx.reset()
+		}
+		if s.prefixIndex != nil {
+			s.prefixIndex = make(map[string]*radixTree)
+		}
+		s.tags = nil
+		s.expirations = nil
+	}
+	c.currentSize.Store(0)
+	c.pinnedBytes.Store(0)
+	c.totalCost.Store(0)
+	c.usedMemory.Store(0)
+	c.updatePressure()
+	c.notifier.publish(KeyEventFlush, "")
+}
+
+// Stats returns cache statistics
+func (c *Cache) Stats() map[string]interface{} {
+	c.rlockAll()
+	defer c.runlockAll()
+
+	totalAccesses := int64(0)
+	totalSize := 0
+	totalKeys := 0
+	hits := c.hits.total()
+
+	for _, s := range c.shards {
+		for _, entry := range s.data {
+			totalAccesses += entry.AccessCount
+			totalSize += len(entry.Value)
+		}
+		totalKeys += len(s.data)
+	}
+
+	c.mutex.RLock()
+	defragStats := c.defragStats
+	c.mutex.RUnlock()
+
+	return map[string]interface{}{
+		"total_keys":     totalKeys,
+		"max_size":       c.maxSize,
+		"current_size":   int(c.currentSize.Load()),
+		"total_accesses": totalAccesses,
+		"total_size_bytes": totalSize,
+		"hit_rate":       hits.HitRate(),
+		"hits":           hits.Hits,
+		"misses":         hits.Misses,
+		"expired_misses": hits.ExpiredMisses,
+		"pinned_bytes":   c.pinnedBytes.Load(),
+		"used_memory":    c.usedMemory.Load(),
+		"max_memory":     c.maxCost,
+		"total_cost":     c.totalCost.Load(),
+		"max_cost":       c.maxCost,
+		"soft_cost":      c.softCost,
+		"memory_pressure": c.MemoryPressure().String(),
+		"fragmentation":  defragStats.Fragmentation,
+		"defrag_runs":    defragStats.Runs,
+		"shard_count":    len(c.shards),
+	}
+}
+
+// Cleanup removes expired entries, locking one shard at a time
+func (c *Cache) Cleanup() int {
+	expired := 0
+	for _, s := range c.shards {
+		s.mutex.Lock()
+		expired += s.cleanup(c.now())
+		s.mutex.Unlock()
+	}
+	return expired
+}
+
+// cleanup removes the shard's entries expired at now
+func (s *cacheShard) cleanup(now time.Time) int {
+	expired := 0
+	for len(s.expirations) > 0 && now.After(s.expirations[0].expiryAt) {
+		entry := s.expirations[0]
+
+		// The TTL was extended since the entry was queued
+		if !now.After(*entry.ExpiresAt) {
+			entry.expiryAt = *entry.ExpiresAt
+			heap.Fix(&s.expirations, 0)
+			continue
+		}
+
+		s.dropEntry(entry, RemovalExpired)
+		expired++
+	}
+	return expired
+}
+
+func (s *cacheShard) removeEntry(entry *CacheEntry) {
+	c := s.cache
+	if entry.Pinned {
+		c.pinnedBytes.Add(-entry.memory)
+	} else {
+		s.policy.Remove(entry)
+	}
+	delete(s.data, entry.Key)
+	if s.rebuilding != nil {
+		delete(s.rebuilding, entry.Key)
+	}
+	s.markDirty(entry.Key)
+	c.currentSize.Add(-1)
+	c.usedMemory.Add(-entry.memory)
+	c.totalCost.Add(-entry.cost())
+	s.unindexEntry(entry)
+	s.removeFromPrefixIndex(entry.Key)
+	s.removeFromTagIndex(entry)
+	s.untrackExpiry(entry)
+}
+
+// overflow returns why the cache must evict while it is over its entry
+// limit or cost budget, or "" when it is within both
+func (c *Cache) overflow() RemovalReason {
+	switch {
+	case c.currentSize.Load() > int64(c.maxSize):
+		return RemovalEvictedLRU
+	case c.maxCost > 0 && c.totalCost.Load() > c.maxCost && !c.rejectsWrites():
+		return RemovalEvictedMemory
+	default:
+		return ""
+	}
+}
+
+// evictOverflow evicts entries of the shard until the cache is within its
+// entry limit and cost budget, leaving at least keep entries for the
+// policy. What the shard cannot cover is left to the relief routine,
+// which evicts from the other shards.
+func (s *cacheShard) evictOverflow(keep int) {
+	flushed := false
+	for {
+		reason := s.cache.overflow()
+		if reason == "" {
+			return
+		}
+		if s.policy.Len() <= keep {
+			if len(s.cache.shards) > 1 {
+				s.cache.wakeRelief()
+			}
+			return
+		}
+
+		// Pick victims by up to date recency
+		if !flushed {
+			s.flushReads()
+			flushed = true
+		}
+		victim := s.policy.Victim()
+		if victim == nil {
+			return
+		}
+		s.dropEntry(victim, reason)
+	}
+}
+
+// StartCleanupRoutine starts a background cleanup routine
+func (c *Cache) StartCleanupRoutine(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				expired := c.Cleanup()
+				if expired > 0 {
+					// Could add logging here
+				}
+			case <-c.pressureSignal:
+			}
+			c.relievePressure()
+		}
+	}()
+}
\ No newline at end of file
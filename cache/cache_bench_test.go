@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+// The keyspace benchmarks compare one shard, every key under a single
+// lock, with the shard count a server on this machine picks. Run them at
+// several GOMAXPROCS to see throughput scale with the sharded keyspace:
+//
+//	go test -run '^$' -bench Keyspace -cpu 1,2,4,8
+
+// benchKeys is how many keys the benchmarks spread their operations over
+const benchKeys = 1 << 16
+
+// benchValue is the value every benchmark write stores
+var benchValue = make([]byte, 64)
+
+// benchShardCounts returns the shard counts to compare. The automatic one
+// follows the CPUs rather than GOMAXPROCS, which -cpu changes only once
+// the sub-benchmarks run.
+func benchShardCounts() []int {
+	auto := autoShardCount(runtime.NumCPU())
+	if auto == 1 {
+		return []int{1}
+	}
+	return []int{1, auto}
+}
+
+// newBenchCache returns a cache of shards shards holding every benchmark
+// key, and the keys
+func newBenchCache(b *testing.B, shards int) (*Cache, []string) {
+	cfg := DefaultConfig()
+	cfg.ShardCount = shards
+	cfg.MaxKeys = 2 * benchKeys
+	c, err := NewCacheFromConfig(cfg)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	keys := make([]string, benchKeys)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("bench:%d", i)
+		c.Set(context.Background(), keys[i], benchValue, nil)
+	}
+	return c, keys
+}
+
+// runKeyspaceBenchmark runs op in parallel on random keys at each shard
+// count
+func runKeyspaceBenchmark(b *testing.B, op func(c *Cache, key string, n int)) {
+	for _, shards := range benchShardCounts() {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			c, keys := newBenchCache(b, shards)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for n := 0; pb.Next(); n++ {
+					op(c, keys[rand.Intn(len(keys))], n)
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkKeyspaceGet(b *testing.B) {
+	runKeyspaceBenchmark(b, func(c *Cache, key string, _ int) {
+		c.Get(context.Background(), key)
+	})
+}
+
+func BenchmarkKeyspaceSet(b *testing.B) {
+	runKeyspaceBenchmark(b, func(c *Cache, key string, _ int) {
+		c.Set(context.Background(), key, benchValue, nil)
+	})
+}
+
+// BenchmarkKeyspaceMixed reads nine times for every write, a typical
+// cache workload
+func BenchmarkKeyspaceMixed(b *testing.B) {
+	runKeyspaceBenchmark(b, func(c *Cache, key string, n int) {
+		if n%10 == 0 {
+			c.Set(context.Background(), key, benchValue, nil)
+		} else {
+			c.Get(context.Background(), key)
+		}
+	})
+}
@@ -0,0 +1,29 @@
+package cache
+
+import (
+	"context"
+	"testing"
+)
+
+// TestWritesReportCanceledContext checks that Set and Delete return the
+// error of a write that was not applied, rather than dropping it
+func TestWritesReportCanceledContext(t *testing.T) {
+	c, err := NewCacheFromConfig(DefaultConfig())
+	mustDo(t, err)
+	mustDo(t, c.Set(context.Background(), "k", []byte("v"), nil))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.Set(ctx, "other", []byte("v"), nil); err != context.Canceled {
+		t.Errorf("Set with a canceled context = %v, want %v", err, context.Canceled)
+	}
+	if deleted, err := c.Delete(ctx, "k"); deleted || err != context.Canceled {
+		t.Errorf("Delete with a canceled context = %v, %v, want false, %v", deleted, err, context.Canceled)
+	}
+	if _, ok := c.Get(context.Background(), "k"); !ok {
+		t.Error("a canceled Delete removed the key")
+	}
+	if deleted, err := c.Delete(context.Background(), "k"); !deleted || err != nil {
+		t.Errorf("Delete = %v, %v, want true, nil", deleted, err)
+	}
+}
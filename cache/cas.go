@@ -0,0 +1,53 @@
+package cache
+
+import "context"
+
+// GetVersioned reads the string value at key like Get, also returning its
+// version, which memcached clients pass back to cas
+func (c *Cache) GetVersioned(key string) ([]byte, uint64, bool) {
+	s := c.shardFor(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := s.lookupLive(key)
+	if entry == nil {
+		c.hits.miss(key, false)
+		return nil, 0, false
+	}
+	if entry.Object != nil {
+		return nil, 0, false
+	}
+	s.touchEntry(entry)
+	c.hits.hit(key)
+	return entry.Value, entry.version, true
+}
+
+// StoreIf stores the string value update returns for the live entry at
+// key, nil if there is none, unless update returns an error. The write is
+// logged as a SET of the stored entry.
+func (c *Cache) StoreIf(ctx context.Context, key string, update func(old *CacheEntry) ([]byte, SetOptions, error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return c.logWrite(ctx, []string{key}, func() ([][]byte, error) {
+		s := c.shardFor(key)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		old := s.lookupLive(key)
+		if old != nil && old.Object != nil {
+			return nil, ErrWrongType
+		}
+		value, opts, err := update(old)
+		if err != nil {
+			return nil, err
+		}
+		return s.setLocked(key, value, opts)
+	})
+}
+
+// Version returns the entry's version, which changes whenever its value is
+// written
+func (e *CacheEntry) Version() uint64 {
+	return e.version
+}
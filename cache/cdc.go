@@ -0,0 +1,414 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+// CDC backends, set in CDCConfig.Backend
+const (
+	CDCKafka = "kafka"
+	CDCNATS  = "nats"
+)
+
+// CDC publisher tuning
+const (
+	cdcDefaultBatchSize  = 100
+	cdcDefaultBufferSize = 10000
+	cdcFlushInterval     = 100 * time.Millisecond
+	cdcRetryMin          = 100 * time.Millisecond
+	cdcRetryMax          = 10 * time.Second
+	cdcPublishTimeout    = 10 * time.Second
+)
+
+// ParseCDCBackend validates a CDCConfig.Backend value
+func ParseCDCBackend(name string) (string, error) {
+	switch backend := strings.ToLower(name); backend {
+	case CDCKafka, CDCNATS:
+		return backend, nil
+	default:
+		return "", fmt.Errorf("unknown CDC backend: %s", name)
+	}
+}
+
+// CDCEvent is one committed mutation as published downstream. Value or
+// ValueHash is filled in for set events from the value the key holds when
+// the event is published, so a consumer may see a later value than the one
+// the set wrote, never an earlier one.
+type CDCEvent struct {
+	Seq    uint64        `json:"seq"`
+	Op     KeyEventType  `json:"op"`
+	Key    string        `json:"key,omitempty"`
+	Time   time.Time     `json:"time"`
+	Reason RemovalReason `json:"reason,omitempty"`
+	Value  []byte        `json:"value,omitempty"`
+	// ValueHash is the hex SHA-256 of the value
+	ValueHash string `json:"value_hash,omitempty"`
+}
+
+// CDCSink delivers events to an external system. Publish returns once the
+// system has acknowledged every event in the batch; on error the whole batch
+// is sent again, so sinks see each event at least once.
+type CDCSink interface {
+	Publish(ctx context.Context, events []CDCEvent) error
+	Close() error
+}
+
+// CDCStats describes a publisher's progress
+type CDCStats struct {
+	// AckedSeq is the keyspace event sequence number of the last event
+	// acknowledged by the sink
+	AckedSeq  uint64 `json:"acked_seq"`
+	Published int64  `json:"published"`
+	Failures  int64  `json:"failures"`
+	// Gaps counts the times the publisher fell so far behind that events
+	// had left the keyspace event history and were lost
+	Gaps int64 `json:"gaps"`
+}
+
+// CDCPublisher streams keyspace events from a cache to a CDCSink. Events
+// are read from the keyspace notifier and held in a bounded buffer while
+// the sink is unavailable. If the buffer fills, the publisher stops reading
+// and later resumes from the notifier's history after the last acknowledged
+// event, so nothing is lost unless the outage outlasts that history.
+type CDCPublisher struct {
+	cache         *Cache
+	sink          CDCSink
+	prefix        string
+	includeValues bool
+	batchSize     int
+	bufferSize    int
+	logger        *log.Logger
+
+	acked     uint64
+	published int64
+	failures  int64
+	gaps      int64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartCDC starts publishing changes to keys starting with cfg.Prefix to sink
+func StartCDC(c *Cache, sink CDCSink, cfg CDCConfig, logger *log.Logger) *CDCPublisher {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &CDCPublisher{
+		cache:         c,
+		sink:          sink,
+		prefix:        cfg.Prefix,
+		includeValues: cfg.IncludeValues,
+		batchSize:     cfg.BatchSize,
+		bufferSize:    cfg.BufferSize,
+		logger:        logger,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+	if p.batchSize <= 0 {
+		p.batchSize = cdcDefaultBatchSize
+	}
+	if p.bufferSize < p.batchSize {
+		p.bufferSize = cdcDefaultBufferSize
+	}
+
+	go p.run(ctx)
+	return p
+}
+
+// Stats returns the publisher's progress
+func (p *CDCPublisher) Stats() CDCStats {
+	return CDCStats{
+		AckedSeq:  atomic.LoadUint64(&p.acked),
+		Published: atomic.LoadInt64(&p.published),
+		Failures:  atomic.LoadInt64(&p.failures),
+		Gaps:      atomic.LoadInt64(&p.gaps),
+	}
+}
+
+// Close stops the publisher and closes the sink. Buffered events that have
+// not been acknowledged are discarded.
+func (p *CDCPublisher) Close() error {
+	p.cancel()
+	<-p.done
+	return p.sink.Close()
+}
+
+func (p *CDCPublisher) run(ctx context.Context) {
+	defer close(p.done)
+
+	// Start from the current position; anything emitted before the
+	// subscription is registered shows up as a gap and is replayed
+	last := p.cache.notifier.position()
+	atomic.StoreUint64(&p.acked, last)
+	sub := p.cache.Subscribe("")
+	for {
+		err := p.pump(ctx, sub, last)
+		sub.Close()
+		if ctx.Err() != nil {
+			return
+		}
+		p.logger.Printf("CDC publisher fell behind, resuming after event %d: %v", atomic.LoadUint64(&p.acked), err)
+		sub, last = p.resubscribe()
+	}
+}
+
+// resubscribe picks up after the last acknowledged event, recording a gap
+// when the events since have left the history. It returns the sequence
+// number the next event must follow.
+func (p *CDCPublisher) resubscribe() (*KeyEventSubscription, uint64) {
+	acked := atomic.LoadUint64(&p.acked)
+	sub, err := p.cache.SubscribeFrom("", acked)
+	if err == nil {
+		return sub, acked
+	}
+	atomic.AddInt64(&p.gaps, 1)
+	p.logger.Printf("!!! CDC events after %d are no longer available; downstream systems are missing changes", acked)
+	acked = p.cache.notifier.position()
+	atomic.StoreUint64(&p.acked, acked)
+	return p.cache.Subscribe(""), acked
+}
+
+// pump publishes events from sub until ctx is done or an event is missing.
+// The subscription covers every key so sequence numbers are contiguous and
+// a dropped event shows up as a jump; the events before it are published
+// and the error returned so run can resubscribe from the history.
+func (p *CDCPublisher) pump(ctx context.Context, sub *KeyEventSubscription, last uint64) error {
+	ticker := time.NewTicker(cdcFlushInterval)
+	defer ticker.Stop()
+
+	buffer := make([]KeyEvent, 0, p.batchSize)
+	var gap error
+	for {
+		// Stop reading while the buffer is full; the subscription drops
+		// events and they are replayed from the history afterwards
+		events := sub.C
+		if len(buffer) >= p.bufferSize || gap != nil {
+			events = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event := <-events:
+			if event.Seq != last+1 {
+				gap = fmt.Errorf("missed events %d to %d", last+1, event.Seq-1)
+				break
+			}
+			last = event.Seq
+			if event.Type != KeyEventFlush && !strings.HasPrefix(event.Key, p.prefix) {
+				if len(buffer) == 0 {
+					atomic.StoreUint64(&p.acked, event.Seq)
+				}
+				continue
+			}
+			buffer = append(buffer, event)
+			if len(buffer) < p.batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		buffer = p.flush(ctx, buffer)
+		if gap != nil && len(buffer) == 0 {
+			return gap
+		}
+	}
+}
+
+// flush publishes buffered events a batch at a time, backing off between
+// failed attempts while the buffer is full, and returns what is left
+func (p *CDCPublisher) flush(ctx context.Context, buffer []KeyEvent) []KeyEvent {
+	backoff := cdcRetryMin
+	for len(buffer) > 0 {
+		n := len(buffer)
+		if n > p.batchSize {
+			n = p.batchSize
+		}
+		if err := p.publish(ctx, buffer[:n]); err != nil {
+			atomic.AddInt64(&p.failures, 1)
+			p.logger.Printf("CDC publish of %d events failed: %v", n, err)
+			if len(buffer) < p.bufferSize {
+				return buffer
+			}
+			select {
+			case <-ctx.Done():
+				return buffer
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > cdcRetryMax {
+				backoff = cdcRetryMax
+			}
+			continue
+		}
+		backoff = cdcRetryMin
+		atomic.StoreUint64(&p.acked, buffer[n-1].Seq)
+		atomic.AddInt64(&p.published, int64(n))
+		buffer = append(buffer[:0], buffer[n:]...)
+	}
+	return buffer
+}
+
+func (p *CDCPublisher) publish(ctx context.Context, batch []KeyEvent) error {
+	events := make([]CDCEvent, len(batch))
+	for i, event := range batch {
+		events[i] = p.eventOf(event)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cdcPublishTimeout)
+	defer cancel()
+	return p.sink.Publish(ctx, events)
+}
+
+// eventOf converts a keyspace event, attaching the current value for sets
+func (p *CDCPublisher) eventOf(event KeyEvent) CDCEvent {
+	out := CDCEvent{
+		Seq:    event.Seq,
+		Op:     event.Type,
+		Key:    event.Key,
+		Time:   event.Time,
+		Reason: event.Reason,
+	}
+	if event.Type != KeyEventSet {
+		return out
+	}
+	value, ok := p.cache.peekValue(event.Key)
+	if !ok {
+		return out
+	}
+	if p.includeValues {
+		out.Value = value
+	} else {
+		sum := sha256.Sum256(value)
+		out.ValueHash = hex.EncodeToString(sum[:])
+	}
+	return out
+}
+
+// peekValue returns key's string value without updating access statistics
+func (c *Cache) peekValue(key string) ([]byte, bool) {
+	s := c.shardFor(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entry, exists := s.data[key]
+	if !exists || entry.Object != nil || entry.isExpired(c.now()) {
+		return nil, false
+	}
+	return entry.Value, true
+}
+
+// NewCDCSink connects to the backend named in cfg
+func NewCDCSink(cfg CDCConfig) (CDCSink, error) {
+	backend, err := ParseCDCBackend(cfg.Backend)
+	if err != nil {
+		return nil, err
+	}
+	switch backend {
+	case CDCKafka:
+		return newKafkaSink(cfg.Brokers, cfg.Topic), nil
+	default:
+		return newNATSSink(cfg.Brokers, cfg.Topic)
+	}
+}
+
+// kafkaSink writes events to a Kafka topic keyed by cache key, so changes
+// to one key stay ordered within its partition
+type kafkaSink struct {
+	writer *kafka.Writer
+}
+
+func newKafkaSink(brokers []string, topic string) *kafkaSink {
+	return &kafkaSink{writer: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}}
+}
+
+func (s *kafkaSink) Publish(ctx context.Context, events []CDCEvent) error {
+	messages := make([]kafka.Message, len(events))
+	for i, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		messages[i] = kafka.Message{
+			Key:     []byte(event.Key),
+			Value:   value,
+			Headers: []kafka.Header{{Key: "seq", Value: []byte(strconv.FormatUint(event.Seq, 10))}},
+			Time:    event.Time,
+		}
+	}
+	return s.writer.WriteMessages(ctx, messages...)
+}
+
+func (s *kafkaSink) Close() error {
+	return s.writer.Close()
+}
+
+// natsSink publishes events to a JetStream subject. The sequence number is
+// the message ID, so the stream discards redelivered events within its
+// duplicate window.
+type natsSink struct {
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+	subject string
+	mu      sync.Mutex
+}
+
+func newNATSSink(servers []string, subject string) (*natsSink, error) {
+	conn, err := nats.Connect(strings.Join(servers, ","))
+	if err != nil {
+		return nil, err
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &natsSink{conn: conn, js: js, subject: subject}, nil
+}
+
+func (s *natsSink) Publish(ctx context.Context, events []CDCEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	acks := make([]nats.PubAckFuture, len(events))
+	for i, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		acks[i], err = s.js.PublishAsync(s.subject, data, nats.MsgId(strconv.FormatUint(event.Seq, 10)))
+		if err != nil {
+			return err
+		}
+	}
+	for _, ack := range acks {
+		select {
+		case <-ack.Ok():
+		case err := <-ack.Err():
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (s *natsSink) Close() error {
+	s.conn.Close()
+	return nil
+}
@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupUnlimited is the smallest cgroup v1 limit treated as "no limit".
+// v1 reports an unset limit as the largest page-aligned int64.
+const cgroupUnlimited = 1 << 62
+
+// MaxMemoryAuto in CACHE_MAX_MEMORY sizes the cache from the container's
+// memory limit, the same as a MaxMemory of zero
+const MaxMemoryAuto = "auto"
+
+// errNoMemoryLimit is returned when neither a cgroup limit nor the host's
+// total memory can be read
+var errNoMemoryLimit = errors.New("no memory limit found")
+
+// detectMemoryLimit returns the memory limit of the cgroup the process runs
+// in, falling back to the host's total memory when it is unlimited
+func detectMemoryLimit() (int64, error) {
+	if limit, ok := cgroupMemoryLimit(); ok {
+		return limit, nil
+	}
+	return hostMemory()
+}
+
+// cgroupMemoryLimit reads the cgroup v2 limit, then the v1 limit. It reports
+// false when neither is set.
+func cgroupMemoryLimit() (int64, bool) {
+	v2, v1 := cgroupPaths()
+	for _, dir := range []string{v2, ""} {
+		if limit, ok := readCgroupLimit(filepath.Join(cgroupRoot, dir, "memory.max")); ok {
+			return limit, true
+		}
+	}
+	for _, dir := range []string{v1, ""} {
+		if limit, ok := readCgroupLimit(filepath.Join(cgroupRoot, "memory", dir, "memory.limit_in_bytes")); ok {
+			return limit, true
+		}
+	}
+	return 0, false
+}
+
+// cgroupPaths returns the process's v2 and v1 memory cgroup paths from
+// /proc/self/cgroup. Inside a container with its own cgroup namespace both
+// are usually "/", which the callers also try as a fallback.
+func cgroupPaths() (v2, v1 string) {
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Lines are "hierarchy-ID:controller-list:path"
+		parts := strings.SplitN(scanner.Text(), ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		switch {
+		case parts[0] == "0" && parts[1] == "":
+			v2 = parts[2]
+		case containsController(parts[1], "memory"):
+			v1 = parts[2]
+		}
+	}
+	return v2, v1
+}
+
+func containsController(list, name string) bool {
+	for _, controller := range strings.Split(list, ",") {
+		if controller == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readCgroupLimit parses a memory.max or memory.limit_in_bytes file
+func readCgroupLimit(path string) (int64, bool) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	value := strings.TrimSpace(string(data))
+	if value == "max" {
+		return 0, false
+	}
+	limit, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || limit <= 0 || limit >= cgroupUnlimited {
+		return 0, false
+	}
+	return limit, true
+}
+
+// hostMemory returns MemTotal from /proc/meminfo
+func hostMemory() (int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, errNoMemoryLimit
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid MemTotal: %w", err)
+		}
+		return kb * 1024, nil
+	}
+	return 0, errNoMemoryLimit
+}
+
+// ResolveMaxMemory replaces a zero MaxMemory with MaxMemoryFraction of the
+// detected memory limit
+func (c *Config) ResolveMaxMemory() error {
+	if c.MaxMemory != 0 {
+		return nil
+	}
+	if c.MaxMemoryFraction <= 0 || c.MaxMemoryFraction > 1 {
+		return fmt.Errorf("max memory fraction must be in (0, 1]")
+	}
+	limit, err := detectMemoryLimit()
+	if err != nil {
+		return fmt.Errorf("detecting max memory: %w", err)
+	}
+	c.MaxMemory = int64(float64(limit) * c.MaxMemoryFraction)
+	return nil
+}
+
+// cgroupCPUQuota returns the number of CPUs the cgroup may use, from the v2
+// cpu.max or the v1 CFS quota. It reports false when there is no quota.
+func cgroupCPUQuota() (float64, bool) {
+	v2, _ := cgroupPaths()
+	for _, dir := range []string{v2, ""} {
+		data, err := ioutil.ReadFile(filepath.Join(cgroupRoot, dir, "cpu.max"))
+		if err != nil {
+			continue
+		}
+		// "max 100000" or "<quota> <period>"
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0, false
+		}
+		return cpuQuota(fields[0], fields[1])
+	}
+
+	quota, err := ioutil.ReadFile(filepath.Join(cgroupRoot, "cpu", "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, false
+	}
+	period, err := ioutil.ReadFile(filepath.Join(cgroupRoot, "cpu", "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, false
+	}
+	return cpuQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period)))
+}
+
+func cpuQuota(quota, period string) (float64, bool) {
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseInt(period, 10, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return float64(q) / float64(p), true
+}
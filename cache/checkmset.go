@@ -0,0 +1,205 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// CHECKMSET applies several writes only if none of a set of keys changed
+// since the client read them, for structured updates that MULTI/WATCH
+// would otherwise be needed for. Every value write gives its entry a new
+// version, which METADATA reports; a missing key has version zero. The
+// shards of all the keys stay locked for the check and the writes, so
+// they are atomic whichever shards the keys hash to.
+
+// VersionCheck expects the entry at Key to have Version
+type VersionCheck struct {
+	Key     string
+	Version uint64
+}
+
+// KeyValue is a write of Value to Key
+type KeyValue struct {
+	Key   string
+	Value []byte
+}
+
+// CheckAndMSet stores writes as MSET would, replacing any TTL, tags and
+// cost, if every check passes. Of several writes to one key the last one
+// wins. It reports false, writing nothing, if any check fails. Writes that
+// together do not fit under the memory limit fail with ErrOOM, also
+// writing nothing. Versions differ between nodes and across restarts, so
+// the write is logged as the MSET it resolved to, and a failed check is
+// not logged.
+func (c *Cache) CheckAndMSet(ctx context.Context, checks []VersionCheck, writes []KeyValue) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	writes = lastWrites(writes)
+	keys := make([]string, 0, len(checks)+len(writes))
+	for _, check := range checks {
+		keys = append(keys, check.Key)
+	}
+	for _, write := range writes {
+		keys = append(keys, write.Key)
+	}
+
+	applied := false
+	err := c.logWrite(ctx, keys, func() ([][]byte, error) {
+		var err error
+		applied, err = c.checkAndMSet(checks, writes)
+		if err != nil || !applied {
+			return nil, err
+		}
+		args := make([][]byte, 1, 1+2*len(writes))
+		args[0] = []byte("MSET")
+		for _, write := range writes {
+			args = append(args, []byte(write.Key), write.Value)
+		}
+		return args, nil
+	})
+	return applied, err
+}
+
+// lastWrites drops writes that a later write to the same key replaces
+func lastWrites(writes []KeyValue) []KeyValue {
+	last := make(map[string]int, len(writes))
+	for i, write := range writes {
+		last[write.Key] = i
+	}
+	if len(last) == len(writes) {
+		return writes
+	}
+	unique := make([]KeyValue, 0, len(last))
+	for i, write := range writes {
+		if last[write.Key] == i {
+			unique = append(unique, write)
+		}
+	}
+	return unique
+}
+
+// checkAndMSet applies CheckAndMSet without logging it. The keys of
+// writes are distinct.
+func (c *Cache) checkAndMSet(checks []VersionCheck, writes []KeyValue) (bool, error) {
+	keys := make([]string, 0, len(checks)+len(writes))
+	for _, check := range checks {
+		keys = append(keys, check.Key)
+	}
+	for _, write := range writes {
+		keys = append(keys, write.Key)
+	}
+	defer unlockShards(c.lockKeys(keys...))
+
+	for _, check := range checks {
+		version := uint64(0)
+		if entry := c.shardFor(check.Key).lookupLive(check.Key); entry != nil {
+			version = entry.version
+		}
+		if version != check.Version {
+			return false, nil
+		}
+	}
+
+	// Admit the writes as a whole, so none can be refused once some are
+	// applied
+	now := c.now()
+	entries := make([]*CacheEntry, len(writes))
+	delta := int64(0)
+	for i, write := range writes {
+		entry := &CacheEntry{Key: write.Key, Value: write.Value, CreatedAt: now, LastAccessed: now}
+		if ttl := c.effectiveTTL(write.Key, nil); ttl != nil {
+			expiresAt := now.Add(*ttl)
+			entry.ExpiresAt = &expiresAt
+			if c.slidingFor(write.Key, false) {
+				entry.SlidingTTL = *ttl
+			}
+		}
+		entries[i] = entry
+		delta += entry.memoryUsage()
+		if old := c.storedEntry(write.Key); old != nil {
+			delta -= old.cost()
+		}
+	}
+	if err := c.admitWrite(delta); err != nil {
+		return false, err
+	}
+
+	for _, entry := range entries {
+		s := c.shardFor(entry.Key)
+		pinned := false
+		if old := s.data[entry.Key]; old != nil {
+			pinned = old.Pinned
+			s.removeEntry(old)
+		}
+		entry.Pinned = pinned && c.pinFits(entry.memoryUsage())
+		s.insertEntry(entry)
+	}
+	return true, nil
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "CHECKMSET", Arity: -6, Flags: FlagWrite | FlagSelfLogged, Handler: checkMSetCommand, KeyArgs: checkMSetKeyArgs},
+	)
+}
+
+// parseCheckMSet splits the arguments of CHECKMSET numchecks key version
+// [key version ...] key value [key value ...]
+func parseCheckMSet(args [][]byte) ([]VersionCheck, []KeyValue, error) {
+	n, err := strconv.Atoi(string(args[1]))
+	if err != nil || n < 1 {
+		return nil, nil, errors.New("ERR numchecks must be a positive integer")
+	}
+	rest := args[2:]
+	if len(rest) < 2*n+2 || (len(rest)-2*n)%2 != 0 {
+		return nil, nil, ErrSyntax
+	}
+	checks := make([]VersionCheck, n)
+	for i := range checks {
+		version, err := strconv.ParseUint(string(rest[2*i+1]), 10, 64)
+		if err != nil {
+			return nil, nil, ErrNotInteger
+		}
+		checks[i] = VersionCheck{Key: string(rest[2*i]), Version: version}
+	}
+	rest = rest[2*n:]
+	writes := make([]KeyValue, len(rest)/2)
+	for i := range writes {
+		writes[i] = KeyValue{Key: string(rest[2*i]), Value: rest[2*i+1]}
+	}
+	return checks, writes, nil
+}
+
+// checkMSetKeyArgs is Command.KeyArgs for CHECKMSET: the checked keys and
+// the written ones
+func checkMSetKeyArgs(args [][]byte) []int {
+	checks, writes, err := parseCheckMSet(args)
+	if err != nil {
+		return nil
+	}
+	positions := make([]int, 0, len(checks)+len(writes))
+	for i := range checks {
+		positions = append(positions, 2+2*i)
+	}
+	for i := range writes {
+		positions = append(positions, 2+2*len(checks)+2*i)
+	}
+	return positions
+}
+
+// checkMSetCommand implements CHECKMSET, replying 1 if the writes were
+// applied and 0 if a check failed
+func checkMSetCommand(ctx *CommandContext) error {
+	checks, writes, err := parseCheckMSet(ctx.Args)
+	if err != nil {
+		return err
+	}
+	applied, err := ctx.Cache.CheckAndMSet(ctx.Context, checks, writes)
+	if err != nil {
+		return err
+	}
+	ctx.Out.WriteInteger(BoolInt(applied))
+	return nil
+}
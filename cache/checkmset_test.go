@@ -0,0 +1,106 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+)
+
+// runCommand runs a command as a client would, returning its reply
+func runCommand(t *testing.T, c *Cache, args ...string) string {
+	t.Helper()
+	argv := make([][]byte, len(args))
+	for i, arg := range args {
+		argv[i] = []byte(arg)
+	}
+	cmd := LookupCommand(args[0])
+	if cmd == nil {
+		t.Fatalf("unknown command %s", args[0])
+	}
+	var out bytes.Buffer
+	w := NewRESPWriter(&out)
+	if err := RunCommand(&CommandContext{Context: context.Background(), Cache: c, Command: cmd, Args: argv, Out: w}); err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	w.Flush()
+	return out.String()
+}
+
+// walCommands returns the logged commands after seq, quoted
+func walCommands(t *testing.T, wal *WAL, seq uint64) []string {
+	t.Helper()
+	records, err := wal.ReadFrom(seq, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := make([]string, len(records))
+	for i, record := range records {
+		commands[i] = fmt.Sprintf("%q", record.Args)
+	}
+	return commands
+}
+
+func TestCheckMSetLogsResolvedMSet(t *testing.T) {
+	c, err := NewCacheFromConfig(DefaultConfig())
+	mustDo(t, err)
+	wal := c.EnableWAL(1 << 20)
+	ctx := context.Background()
+	mustDo(t, c.SetWithOptions(ctx, "checked", []byte("v1"), SetOptions{}))
+	meta, _ := c.Metadata("checked")
+	start := wal.Stats().Seq
+
+	stale := fmt.Sprint(meta.Version + 1)
+	if got := runCommand(t, c, "CHECKMSET", "1", "checked", stale, "a", "1"); got != ":0\r\n" {
+		t.Errorf("CHECKMSET with a stale version replied %q, want 0", got)
+	}
+	if logged := walCommands(t, wal, start); len(logged) != 0 {
+		t.Errorf("failed CHECKMSET logged %v", logged)
+	}
+
+	current := fmt.Sprint(meta.Version)
+	if got := runCommand(t, c, "CHECKMSET", "1", "checked", current, "a", "1", "b", "2", "a", "3"); got != ":1\r\n" {
+		t.Errorf("CHECKMSET with the current version replied %q, want 1", got)
+	}
+	want := fmt.Sprintf("%q", [][]byte{[]byte("MSET"), []byte("b"), []byte("2"), []byte("a"), []byte("3")})
+	if logged := walCommands(t, wal, start); len(logged) != 1 || logged[0] != want {
+		t.Errorf("CHECKMSET logged %v, want %s", logged, want)
+	}
+	if value, _ := c.Get(ctx, "a"); string(value) != "3" {
+		t.Errorf("a = %q, want the last write 3", value)
+	}
+}
+
+func TestCheckAndMSetLogsForAPICallers(t *testing.T) {
+	c, err := NewCacheFromConfig(DefaultConfig())
+	mustDo(t, err)
+	wal := c.EnableWAL(1 << 20)
+
+	applied, err := c.CheckAndMSet(context.Background(), []VersionCheck{{Key: "missing"}}, []KeyValue{{Key: "k", Value: []byte("v")}})
+	if err != nil || !applied {
+		t.Fatalf("CheckAndMSet = %v, %v, want applied", applied, err)
+	}
+	if logged := walCommands(t, wal, 0); len(logged) != 1 {
+		t.Errorf("CheckAndMSet logged %v, want one MSET", logged)
+	}
+}
+
+// TestCheckAndMSetChargesRepeatedKeyOnce writes a key twice with a value
+// that fits the memory limit once, but would not if both writes counted
+func TestCheckAndMSetChargesRepeatedKeyOnce(t *testing.T) {
+	ctx := context.Background()
+	cfg := DefaultConfig()
+	cfg.MaxMemory = 1 << 20
+	cfg.MemoryLimitAction = MemoryLimitReject
+	c, err := NewCacheFromConfig(cfg)
+	mustDo(t, err)
+	mustDo(t, c.SetWithOptions(ctx, "k", make([]byte, 1000), SetOptions{}))
+
+	headroom := c.maxCost - c.totalCost.Load()
+	value := make([]byte, 1000+3*headroom/4)
+	writes := []KeyValue{{Key: "k", Value: value}, {Key: "k", Value: value}}
+	applied, err := c.CheckAndMSet(ctx, []VersionCheck{{Key: "other"}}, writes)
+	if err != nil || !applied {
+		t.Errorf("CheckAndMSet = %v, %v, want the write admitted", applied, err)
+	}
+}
@@ -0,0 +1,78 @@
+package cache
+
+import "container/list"
+
+// clockPolicy is the CLOCK (second chance) approximation of LRU. Entries
+// sit on a circular list swept by a hand; reads only set a reference bit,
+// and the hand clears bits until it finds an entry not referenced since its
+// last pass. Neither reads nor writes reorder the list.
+type clockPolicy struct {
+	ring *list.List
+	hand *list.Element
+}
+
+func newClockPolicy() *clockPolicy {
+	return &clockPolicy{ring: list.New()}
+}
+
+func (p *clockPolicy) Add(entry *CacheEntry) {
+	entry.referenced = false
+	// New entries go just behind the hand, so they are examined last
+	if p.hand == nil {
+		entry.element = p.ring.PushBack(entry)
+	} else {
+		entry.element = p.ring.InsertBefore(entry, p.hand)
+	}
+}
+
+func (p *clockPolicy) Access(entry *CacheEntry) {
+	entry.referenced = true
+}
+
+func (p *clockPolicy) Remove(entry *CacheEntry) {
+	if p.hand == entry.element {
+		p.advance()
+		if p.hand == entry.element {
+			p.hand = nil
+		}
+	}
+	p.ring.Remove(entry.element)
+	entry.element = nil
+}
+
+// advance moves the hand to the next entry, wrapping around
+func (p *clockPolicy) advance() {
+	if p.hand != nil {
+		p.hand = p.hand.Next()
+	}
+	if p.hand == nil {
+		p.hand = p.ring.Front()
+	}
+}
+
+func (p *clockPolicy) Victim() *CacheEntry {
+	if p.ring.Len() == 0 {
+		return nil
+	}
+	if p.hand == nil {
+		p.hand = p.ring.Front()
+	}
+	// At most one full sweep clears every bit, so this terminates
+	for {
+		entry := p.hand.Value.(*CacheEntry)
+		if !entry.referenced {
+			return entry
+		}
+		entry.referenced = false
+		p.advance()
+	}
+}
+
+func (p *clockPolicy) Len() int {
+	return p.ring.Len()
+}
+
+func (p *clockPolicy) Reset() {
+	p.ring.Init()
+	p.hand = nil
+}
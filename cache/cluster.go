@@ -0,0 +1,592 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/client"
+)
+
+// Node roles
+const (
+	RolePrimary = "primary"
+	RoleReplica = "replica"
+	// RoleWitness is a node holding no data that takes part in failure
+	// detection and quorum, so two data centers can have a tie-breaking
+	// third vote without a third copy of the data
+	RoleWitness = "witness"
+)
+
+// Node health states. A node is suspect after a failed probe and offline
+// after ClusterConfig.SuspicionMult failures in a row, unless other nodes
+// still gossip fresh heartbeats from it.
+const (
+	NodeHandshake = "handshake"
+	NodeOnline    = "online"
+	NodeSuspect   = "suspect"
+	NodeOffline   = "offline"
+	// NodeDraining is a node refusing new connections ahead of maintenance
+	NodeDraining = "draining"
+	// NodeIncompatible is a node whose protocol version is unsupported
+	NodeIncompatible = "incompatible"
+	// NodeFailed is an offline node that a majority of voters cannot
+	// reach either
+	NodeFailed = "failed"
+)
+
+// Cluster admin errors
+var (
+	ErrUnknownNode = errors.New("unknown node")
+	ErrForgetSelf  = errors.New("a node cannot forget itself")
+)
+
+// ClusterNode describes one member of the cluster as this node sees it.
+// Peers are identified by address until they report an ID in the version
+// handshake.
+type ClusterNode struct {
+	ID    string `json:"id"`
+	Addr  string `json:"addr"`
+	Role  string `json:"role"`
+	State string `json:"state"`
+	// Version is the protocol version negotiated with the node
+	Version  int       `json:"version,omitempty"`
+	Features []string  `json:"features,omitempty"`
+	Self     bool      `json:"self,omitempty"`
+	LastSeen time.Time `json:"last_seen,omitempty"`
+	// Failures counts consecutive failed probes
+	Failures int `json:"failures,omitempty"`
+	// Heartbeat is the last heartbeat the node is known to have sent,
+	// directly or through gossip
+	Heartbeat uint64 `json:"heartbeat,omitempty"`
+	// ReplOffset is the node's WAL position
+	ReplOffset uint64 `json:"repl_offset"`
+	// LagRecords is how far a replica is behind this node
+	LagRecords uint64 `json:"lag_records,omitempty"`
+	// LagSeconds is how long a replica's oldest missing write has waited
+	LagSeconds float64 `json:"lag_seconds,omitempty"`
+	// unreachable are the IDs of the nodes this node reported offline in
+	// its last probe
+	unreachable []string
+	// heartbeatAt is when Heartbeat last advanced
+	heartbeatAt time.Time
+}
+
+// Topology is the cluster as seen from one node
+type Topology struct {
+	NodeID string        `json:"node_id"`
+	Nodes  []ClusterNode `json:"nodes"`
+	// Voters counts the nodes taking part in quorum: this node, its
+	// peers and the witnesses, but not mirrors
+	Voters int `json:"voters"`
+	// Quorum is the majority of Voters
+	Quorum int `json:"quorum"`
+	// HasQuorum is set while this node reaches a majority of voters
+	HasQuorum bool `json:"has_quorum"`
+}
+
+// Cluster tracks the members of the cluster and probes their health. The
+// members are the configured seeds and the nodes learned of through
+// gossip, minus forgotten ones, and the mirrors receiving this node's
+// writes.
+type Cluster struct {
+	cache  *Cache
+	cfg    ClusterConfig
+	logger *log.Logger
+
+	mu      sync.RWMutex
+	self    ClusterNode
+	nodes   map[string]*ClusterNode
+	clients map[string]*client.Client
+	mirrors []*Mirror
+	// forgotten are the addresses of forgotten nodes, which gossip must
+	// not bring back
+	forgotten map[string]struct{}
+	// ring partitions keys between the members, nil when membership has
+	// changed since it was built; forwarders send commands to their owners
+	ring       *hashRing
+	forwarders map[string]*client.Client
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewCluster creates the membership table for the node at addr, seeded
+// from cfg.Seeds. With cfg.Witness set, c stops serving keyspace commands.
+func NewCluster(c *Cache, cfg ClusterConfig, addr string, logger *log.Logger) *Cluster {
+	id := cfg.NodeID
+	if id == "" {
+		id, _ = os.Hostname()
+	}
+	role := RolePrimary
+	if cfg.Witness {
+		role = RoleWitness
+	}
+	features := nodeFeatures
+	if cfg.Partition {
+		features = append(features[:len(features):len(features)], "partition")
+	}
+	cl := &Cluster{
+		cache:  c,
+		cfg:    cfg,
+		logger: logger,
+		self: ClusterNode{
+			ID:       id,
+			Addr:     addr,
+			Role:     role,
+			State:    NodeOnline,
+			Self:     true,
+			Version:  ProtocolVersion,
+			Features: features,
+			// Heartbeats start from the clock so a restarted node's are
+			// newer than those gossiped before the restart
+			Heartbeat: uint64(time.Now().UnixMilli()),
+		},
+		nodes:      make(map[string]*ClusterNode),
+		clients:    make(map[string]*client.Client),
+		forgotten:  make(map[string]struct{}),
+		forwarders: make(map[string]*client.Client),
+	}
+	c.SetNodeID(id)
+	c.SetIDNode(idNodeFor(cfg.IDGenNode, id))
+	c.joinCluster(cl)
+	for _, seed := range cfg.Seeds {
+		if seed != addr {
+			cl.addNode(seed)
+		}
+	}
+	return cl
+}
+
+// addNode starts tracking the peer at addr. Callers hold cl.mu or have
+// not yet shared cl.
+func (cl *Cluster) addNode(addr string) {
+	if _, exists := cl.nodes[addr]; exists {
+		return
+	}
+	c, err := client.NewClient(&client.Options{
+		Addresses:   []string{addr},
+		DialTimeout: cl.cfg.ProbeTimeout,
+		ReadTimeout: cl.cfg.ProbeTimeout,
+		PoolSize:    1,
+		Username:    cl.cfg.Username,
+		Password:    cl.cfg.Password,
+	})
+	if err != nil {
+		cl.logger.Printf("Cannot track cluster node %s: %v", addr, err)
+		return
+	}
+	cl.nodes[addr] = &ClusterNode{ID: addr, Addr: addr, Role: RolePrimary, State: NodeHandshake}
+	cl.clients[addr] = c
+	cl.ring = nil
+}
+
+// AttachMirror reports m's target as a replica of this node
+func (cl *Cluster) AttachMirror(m *Mirror) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.mirrors = append(cl.mirrors, m)
+}
+
+// Start probes every node each ProbeInterval, and gossips with one each
+// GossipInterval, until Stop is called
+func (cl *Cluster) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cl.cancel = cancel
+
+	cl.every(ctx, cl.cfg.ProbeInterval, cl.probeAll)
+	if cl.cfg.GossipInterval > 0 {
+		cl.every(ctx, cl.cfg.GossipInterval, cl.gossipRound)
+	}
+}
+
+// every runs fn at once and then each interval until ctx is done
+func (cl *Cluster) every(ctx context.Context, interval time.Duration, fn func(ctx context.Context)) {
+	cl.wg.Add(1)
+	go func() {
+		defer cl.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			fn(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends probing and gossip and closes the connections to peers
+func (cl *Cluster) Stop() {
+	if cl.cancel != nil {
+		cl.cancel()
+		cl.wg.Wait()
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	for _, c := range cl.clients {
+		c.Close()
+	}
+	for _, c := range cl.forwarders {
+		c.Close()
+	}
+}
+
+// probeAll probes the nodes concurrently and waits for the results
+func (cl *Cluster) probeAll(ctx context.Context) {
+	cl.mu.RLock()
+	targets := make(map[string]*client.Client, len(cl.clients))
+	for addr, c := range cl.clients {
+		targets[addr] = c
+	}
+	cl.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for addr, c := range targets {
+		wg.Add(1)
+		go func(addr string, c *client.Client) {
+			defer wg.Done()
+			peer, status, err := cl.probeNode(ctx, c)
+			cl.recordProbe(addr, peer, status, err)
+		}(addr, c)
+	}
+	wg.Wait()
+}
+
+// probeNode negotiates the protocol version with a peer, which may have
+// been upgraded since the last probe, and asks for its status. Peers older
+// than messageProtocolVersion only report their offset, in INFO.
+func (cl *Cluster) probeNode(ctx context.Context, c *client.Client) (PeerVersion, nodeStatusMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, cl.cfg.ProbeTimeout)
+	defer cancel()
+
+	var status nodeStatusMessage
+	peer, err := negotiateVersion(ctx, c, cl.self.ID)
+	if err != nil {
+		return peer, status, err
+	}
+	if peer.Version < messageProtocolVersion {
+		status.ReplOffset, err = probeInfoOffset(ctx, c)
+		return peer, status, err
+	}
+	reply, err := c.Do(ctx, "NODE.STATUS")
+	if err != nil {
+		return peer, status, err
+	}
+	data, _ := reply.([]byte)
+	if err := unmarshalMessage(data, &status); err != nil {
+		return peer, status, fmt.Errorf("NODE.STATUS reply: %w", err)
+	}
+	return peer, status, nil
+}
+
+// probeInfoOffset reads a peer's replication offset from INFO
+func probeInfoOffset(ctx context.Context, c *client.Client) (uint64, error) {
+	reply, err := c.Do(ctx, "INFO", "replication")
+	if err != nil {
+		return 0, err
+	}
+	info, _ := reply.([]byte)
+	scanner := bufio.NewScanner(strings.NewReader(string(info)))
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "master_repl_offset:"); ok {
+			return strconv.ParseUint(value, 10, 64)
+		}
+	}
+	return 0, nil
+}
+
+// recordProbe updates a node's health after a probe
+func (cl *Cluster) recordProbe(addr string, peer PeerVersion, status nodeStatusMessage, err error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	node, exists := cl.nodes[addr]
+	if !exists {
+		// Forgotten while the probe was running
+		return
+	}
+	defer func(state, role string) {
+		if node.State != state || node.Role != role {
+			cl.ring = nil
+		}
+	}(node.State, node.Role)
+	if peer.Version != 0 {
+		node.Version = peer.Version
+		node.Features = peer.Features
+		if peer.NodeID != "" {
+			node.ID = peer.NodeID
+		}
+	}
+	if errors.Is(err, ErrIncompatibleVersion) {
+		if node.State != NodeIncompatible {
+			cl.logger.Printf("!!! Refusing cluster node %s: %v", addr, err)
+		}
+		node.State = NodeIncompatible
+		return
+	}
+	if err == nil {
+		if node.State != NodeOnline && node.State != NodeHandshake {
+			cl.logger.Printf("Cluster node %s is back online", addr)
+		}
+		node.State = NodeOnline
+		node.Failures = 0
+		node.LastSeen = time.Now()
+		node.ReplOffset = status.ReplOffset
+		node.unreachable = status.Unreachable
+		if status.Role != "" {
+			node.Role = status.Role
+		}
+		return
+	}
+
+	node.Failures++
+	switch {
+	case node.Failures >= cl.cfg.SuspicionMult && !cl.heardOfLocked(node):
+		if node.State != NodeOffline {
+			cl.logger.Printf("Cluster node %s is offline after %d failed probes: %v", addr, node.Failures, err)
+		}
+		node.State = NodeOffline
+	case node.State != NodeHandshake:
+		node.State = NodeSuspect
+	}
+}
+
+// Topology returns this node followed by its peers and replicas, sorted
+// by ID
+func (cl *Cluster) Topology() Topology {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	self := cl.self
+	if wal := cl.cache.replicationLog(); wal != nil {
+		self.ReplOffset = wal.Stats().Seq
+	}
+	nodes := make([]ClusterNode, 0, len(cl.nodes)+len(cl.mirrors))
+	for _, node := range cl.nodes {
+		nodes = append(nodes, *node)
+	}
+	for _, m := range cl.mirrors {
+		stats := m.Stats()
+		nodes = append(nodes, ClusterNode{
+			ID:         stats.Target,
+			Addr:       stats.Target,
+			Role:       RoleReplica,
+			State:      mirrorState(stats),
+			Version:    stats.Version,
+			ReplOffset: stats.Seq,
+			LagRecords: stats.LagRecords,
+			LagSeconds: stats.LagSeconds,
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+
+	topology := Topology{
+		NodeID:    self.ID,
+		Nodes:     append([]ClusterNode{self}, nodes...),
+		Voters:    cl.votersLocked(),
+		HasQuorum: cl.hasQuorumLocked(),
+	}
+	topology.Quorum = topology.Voters/2 + 1
+	for i := range topology.Nodes {
+		if node := &topology.Nodes[i]; node.State == NodeOffline && cl.failedLocked(node.ID) {
+			node.State = NodeFailed
+		}
+	}
+	return topology
+}
+
+// votersLocked counts this node and its peers. Callers hold cl.mu.
+func (cl *Cluster) votersLocked() int {
+	return 1 + len(cl.nodes)
+}
+
+// HasQuorum reports whether this node reaches a majority of voters. A node
+// on the minority side of a partition must not act on its own view of the
+// cluster.
+func (cl *Cluster) HasQuorum() bool {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	return cl.hasQuorumLocked()
+}
+
+func (cl *Cluster) hasQuorumLocked() bool {
+	reachable := 1
+	for _, node := range cl.nodes {
+		if node.State == NodeOnline {
+			reachable++
+		}
+	}
+	return reachable > cl.votersLocked()/2
+}
+
+// failedLocked reports whether a majority of voters, counting this node,
+// report the node with the given ID unreachable. Callers hold cl.mu and
+// have seen the node offline.
+func (cl *Cluster) failedLocked(id string) bool {
+	votes := 1
+	for _, node := range cl.nodes {
+		if node.State != NodeOnline {
+			continue
+		}
+		for _, unreachable := range node.unreachable {
+			if unreachable == id {
+				votes++
+				break
+			}
+		}
+	}
+	return votes > cl.votersLocked()/2
+}
+
+// unreachable returns the IDs of the peers this node sees offline, which
+// it reports to the others as its failure detection vote
+func (cl *Cluster) unreachable() []string {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	var ids []string
+	for _, node := range cl.nodes {
+		if node.State == NodeOffline {
+			ids = append(ids, node.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Role returns the role this node reports for itself
+func (cl *Cluster) Role() string {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	return cl.self.Role
+}
+
+// mirrorState derives a health state from a mirror's progress
+func mirrorState(stats MirrorStats) string {
+	switch {
+	case stats.Stopped != "":
+		return NodeIncompatible
+	case stats.Backfilling:
+		return NodeHandshake
+	case stats.LagRecords > 0 && stats.Errors > 0:
+		return NodeSuspect
+	default:
+		return NodeOnline
+	}
+}
+
+// SetNodeID sets the ID this node reports in handshakes
+func (c *Cache) SetNodeID(id string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.nodeID = id
+}
+
+// NodeID returns the ID this node reports in handshakes
+func (c *Cache) NodeID() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.nodeID
+}
+
+// joinCluster makes c report cl's view in NODE.STATUS, and stop serving
+// keys if this node is a witness
+func (c *Cache) joinCluster(cl *Cluster) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.cluster = cl
+	c.witness = cl.self.Role == RoleWitness
+}
+
+// clusterMembership returns the cluster c has joined, if any
+func (c *Cache) clusterMembership() *Cluster {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.cluster
+}
+
+// isWitness reports whether this node only votes
+func (c *Cache) isWitness() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.witness
+}
+
+// SetSelfState sets the state this node reports for itself
+func (cl *Cluster) SetSelfState(state string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.self.State = state
+}
+
+// MaxReplicaLag returns the most writes any replica is missing
+func (cl *Cluster) MaxReplicaLag() uint64 {
+	cl.mu.RLock()
+	mirrors := cl.mirrors
+	cl.mu.RUnlock()
+
+	var max uint64
+	for _, m := range mirrors {
+		stats := m.Stats()
+		lag := stats.LagRecords
+		// A replica being backfilled is behind even with no writes queued
+		if stats.Backfilling && lag == 0 {
+			lag = 1
+		}
+		if lag > max {
+			max = lag
+		}
+	}
+	return max
+}
+
+// Forget stops tracking the node with the given ID
+func (cl *Cluster) Forget(id string) error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if id == cl.self.ID {
+		return ErrForgetSelf
+	}
+	for addr, node := range cl.nodes {
+		if node.ID != id {
+			continue
+		}
+		delete(cl.nodes, addr)
+		cl.forgotten[addr] = struct{}{}
+		if c, ok := cl.clients[addr]; ok {
+			c.Close()
+			delete(cl.clients, addr)
+		}
+		if c, ok := cl.forwarders[addr]; ok {
+			c.Close()
+			delete(cl.forwarders, addr)
+		}
+		cl.ring = nil
+		cl.logger.Printf("Forgot cluster node %s (%s)", id, addr)
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrUnknownNode, id)
+}
@@ -0,0 +1,393 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Cluster messages are exchanged between nodes as tag-length-value
+// records, laid out like protobuf so they can be described by a .proto file
+// if other implementations need them:
+//
+//	uvarint schema version | uvarint message type | field...
+//	field = uvarint (tag<<3 | wire type) | value
+//
+// A varint field's value is a uvarint and a bytes field's value is a
+// uvarint length followed by the bytes. Decoders skip fields with tags they
+// do not know, so a field can be added without a protocol version bump as
+// long as older nodes can do without it. Removing or reinterpreting a
+// field needs a new ProtocolVersion.
+
+// clusterSchemaVersion is written at the start of every message. Decoders
+// accept any version and rely on skipping unknown fields.
+const clusterSchemaVersion = 1
+
+// Field wire types
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// Message types
+const (
+	msgHello      = 1
+	msgNodeStatus = 2
+	msgReplStatus = 3
+	msgGossip     = 4
+)
+
+// errMalformedMessage is returned for messages that cannot be decoded
+var errMalformedMessage = errors.New("malformed cluster message")
+
+// clusterMessage is implemented by every message exchanged between nodes
+type clusterMessage interface {
+	messageType() uint64
+	encodeFields(e *msgEncoder)
+	// decodeField sets the field with the given tag. Unknown tags must be
+	// ignored.
+	decodeField(tag uint64, f msgField) error
+}
+
+// marshalMessage encodes m with its envelope
+func marshalMessage(m clusterMessage) []byte {
+	e := &msgEncoder{}
+	e.buf = binary.AppendUvarint(e.buf, clusterSchemaVersion)
+	e.buf = binary.AppendUvarint(e.buf, m.messageType())
+	m.encodeFields(e)
+	return e.buf
+}
+
+// unmarshalMessage decodes data into m, which must be of the type the
+// envelope names
+func unmarshalMessage(data []byte, m clusterMessage) error {
+	d := &msgDecoder{data: data}
+	if _, err := d.uvarint(); err != nil {
+		return err
+	}
+	msgType, err := d.uvarint()
+	if err != nil {
+		return err
+	}
+	if msgType != m.messageType() {
+		return fmt.Errorf("%w: expected message type %d, got %d", errMalformedMessage, m.messageType(), msgType)
+	}
+	for len(d.data) > 0 {
+		tag, f, err := d.field()
+		if err != nil {
+			return err
+		}
+		if err := m.decodeField(tag, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// msgEncoder appends fields to a message
+type msgEncoder struct {
+	buf []byte
+}
+
+func (e *msgEncoder) key(tag uint64, wireType uint64) {
+	e.buf = binary.AppendUvarint(e.buf, tag<<3|wireType)
+}
+
+// uint writes a varint field, omitting zero values
+func (e *msgEncoder) uint(tag, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.key(tag, wireVarint)
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+// bytes writes a bytes field, omitting empty values
+func (e *msgEncoder) bytes(tag uint64, b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	e.key(tag, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *msgEncoder) string(tag uint64, s string) {
+	e.bytes(tag, []byte(s))
+}
+
+// strings writes a repeated field, one record per element
+func (e *msgEncoder) strings(tag uint64, values []string) {
+	for _, s := range values {
+		e.key(tag, wireBytes)
+		e.buf = binary.AppendUvarint(e.buf, uint64(len(s)))
+		e.buf = append(e.buf, s...)
+	}
+}
+
+// message writes a nested message as a bytes field
+func (e *msgEncoder) message(tag uint64, m interface{ encodeFields(e *msgEncoder) }) {
+	nested := &msgEncoder{}
+	m.encodeFields(nested)
+	e.key(tag, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(nested.buf)))
+	e.buf = append(e.buf, nested.buf...)
+}
+
+// msgField is a decoded field value
+type msgField struct {
+	wireType uint64
+	varint   uint64
+	data     []byte
+}
+
+// uint returns a varint field's value
+func (f msgField) uint() (uint64, error) {
+	if f.wireType != wireVarint {
+		return 0, fmt.Errorf("%w: expected a varint field", errMalformedMessage)
+	}
+	return f.varint, nil
+}
+
+// string returns a bytes field's value
+func (f msgField) string() (string, error) {
+	if f.wireType != wireBytes {
+		return "", fmt.Errorf("%w: expected a bytes field", errMalformedMessage)
+	}
+	return string(f.data), nil
+}
+
+// message decodes a bytes field holding a nested message into m
+func (f msgField) message(m interface {
+	decodeField(tag uint64, f msgField) error
+}) error {
+	if f.wireType != wireBytes {
+		return fmt.Errorf("%w: expected a nested message", errMalformedMessage)
+	}
+	d := &msgDecoder{data: f.data}
+	for len(d.data) > 0 {
+		tag, nested, err := d.field()
+		if err != nil {
+			return err
+		}
+		if err := m.decodeField(tag, nested); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// msgDecoder reads fields from a message
+type msgDecoder struct {
+	data []byte
+}
+
+func (d *msgDecoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		return 0, errMalformedMessage
+	}
+	d.data = d.data[n:]
+	return v, nil
+}
+
+// field reads the next field. Fields of unknown wire types cannot be
+// skipped, so they fail decoding.
+func (d *msgDecoder) field() (uint64, msgField, error) {
+	key, err := d.uvarint()
+	if err != nil {
+		return 0, msgField{}, err
+	}
+	f := msgField{wireType: key & 7}
+	switch f.wireType {
+	case wireVarint:
+		f.varint, err = d.uvarint()
+	case wireBytes:
+		var n uint64
+		if n, err = d.uvarint(); err == nil {
+			if n > uint64(len(d.data)) {
+				return 0, msgField{}, errMalformedMessage
+			}
+			f.data = d.data[:n]
+			d.data = d.data[n:]
+		}
+	default:
+		err = fmt.Errorf("%w: unknown wire type %d", errMalformedMessage, f.wireType)
+	}
+	return key >> 3, f, err
+}
+
+// helloMessage is the reply to NODE.HELLO from protocol version 3
+type helloMessage struct {
+	NodeID     string   // 1
+	Version    int      // 2
+	MinVersion int      // 3
+	Features   []string // 4, repeated
+}
+
+func (m *helloMessage) messageType() uint64 { return msgHello }
+
+func (m *helloMessage) encodeFields(e *msgEncoder) {
+	e.string(1, m.NodeID)
+	e.uint(2, uint64(m.Version))
+	e.uint(3, uint64(m.MinVersion))
+	e.strings(4, m.Features)
+}
+
+func (m *helloMessage) decodeField(tag uint64, f msgField) (err error) {
+	var v uint64
+	switch tag {
+	case 1:
+		m.NodeID, err = f.string()
+	case 2:
+		v, err = f.uint()
+		m.Version = int(v)
+	case 3:
+		v, err = f.uint()
+		m.MinVersion = int(v)
+	case 4:
+		var feature string
+		if feature, err = f.string(); err == nil {
+			m.Features = append(m.Features, feature)
+		}
+	}
+	return err
+}
+
+// nodeStatusMessage is the reply to NODE.STATUS, which replaces parsing
+// INFO output when probing peers
+type nodeStatusMessage struct {
+	NodeID string // 1
+	Role   string // 2
+	// ReplOffset is the node's WAL position
+	ReplOffset uint64 // 3
+	// ReplFirstOffset is the oldest write in its replication backlog
+	ReplFirstOffset uint64 // 4
+	// Unreachable are the IDs of the peers the node sees offline
+	Unreachable []string // 5, repeated
+}
+
+func (m *nodeStatusMessage) messageType() uint64 { return msgNodeStatus }
+
+func (m *nodeStatusMessage) encodeFields(e *msgEncoder) {
+	e.string(1, m.NodeID)
+	e.string(2, m.Role)
+	e.uint(3, m.ReplOffset)
+	e.uint(4, m.ReplFirstOffset)
+	e.strings(5, m.Unreachable)
+}
+
+func (m *nodeStatusMessage) decodeField(tag uint64, f msgField) (err error) {
+	switch tag {
+	case 1:
+		m.NodeID, err = f.string()
+	case 2:
+		m.Role, err = f.string()
+	case 3:
+		m.ReplOffset, err = f.uint()
+	case 4:
+		m.ReplFirstOffset, err = f.uint()
+	case 5:
+		var id string
+		if id, err = f.string(); err == nil {
+			m.Unreachable = append(m.Unreachable, id)
+		}
+	}
+	return err
+}
+
+// replicationStatusMessage is sent by a primary with NODE.REPLSTATUS to
+// tell a replica how far behind it is
+type replicationStatusMessage struct {
+	PrimaryID string // 1
+	// Offset is the primary's WAL position of the last write the replica
+	// acknowledged
+	Offset uint64 // 2
+	// PrimaryOffset is the primary's WAL position
+	PrimaryOffset uint64 // 3
+	// LagMillis is how long the oldest write the replica is missing has
+	// waited
+	LagMillis uint64 // 4
+}
+
+func (m *replicationStatusMessage) messageType() uint64 { return msgReplStatus }
+
+func (m *replicationStatusMessage) encodeFields(e *msgEncoder) {
+	e.string(1, m.PrimaryID)
+	e.uint(2, m.Offset)
+	e.uint(3, m.PrimaryOffset)
+	e.uint(4, m.LagMillis)
+}
+
+func (m *replicationStatusMessage) decodeField(tag uint64, f msgField) (err error) {
+	switch tag {
+	case 1:
+		m.PrimaryID, err = f.string()
+	case 2:
+		m.Offset, err = f.uint()
+	case 3:
+		m.PrimaryOffset, err = f.uint()
+	case 4:
+		m.LagMillis, err = f.uint()
+	}
+	return err
+}
+
+// gossipMember is a member of the cluster as the sending node sees it
+type gossipMember struct {
+	NodeID string // 1
+	Addr   string // 2
+	State  string // 3
+	// Heartbeat is the last heartbeat the node is known to have sent
+	Heartbeat uint64 // 4
+}
+
+func (m *gossipMember) encodeFields(e *msgEncoder) {
+	e.string(1, m.NodeID)
+	e.string(2, m.Addr)
+	e.string(3, m.State)
+	e.uint(4, m.Heartbeat)
+}
+
+func (m *gossipMember) decodeField(tag uint64, f msgField) (err error) {
+	switch tag {
+	case 1:
+		m.NodeID, err = f.string()
+	case 2:
+		m.Addr, err = f.string()
+	case 3:
+		m.State, err = f.string()
+	case 4:
+		m.Heartbeat, err = f.uint()
+	}
+	return err
+}
+
+// gossipMessage is sent with NODE.GOSSIP and sent back in its reply: the
+// sender and the members it knows of
+type gossipMessage struct {
+	From    gossipMember   // 1
+	Members []gossipMember // 2, repeated
+}
+
+func (m *gossipMessage) messageType() uint64 { return msgGossip }
+
+func (m *gossipMessage) encodeFields(e *msgEncoder) {
+	e.message(1, &m.From)
+	for i := range m.Members {
+		e.message(2, &m.Members[i])
+	}
+}
+
+func (m *gossipMessage) decodeField(tag uint64, f msgField) (err error) {
+	switch tag {
+	case 1:
+		err = f.message(&m.From)
+	case 2:
+		var member gossipMember
+		if err = f.message(&member); err == nil {
+			m.Members = append(m.Members, member)
+		}
+	}
+	return err
+}
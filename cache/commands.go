@@ -0,0 +1,228 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// CommandFlags describe properties of a command used by the dispatcher
+type CommandFlags uint32
+
+const (
+	// FlagWrite marks commands that modify the keyspace
+	FlagWrite CommandFlags = 1 << iota
+	// FlagReadOnly marks commands that only read the keyspace
+	FlagReadOnly
+	// FlagAdmin marks administrative commands
+	FlagAdmin
+	// FlagSelfLogged marks write commands that log their own effect with
+	// Cache.logWrite rather than being logged as sent, because it depends
+	// on when they run
+	FlagSelfLogged
+	// FlagConnection marks commands that only affect the client's own
+	// connection
+	FlagConnection
+	// FlagPubSub marks commands managing the client's subscriptions
+	FlagPubSub
+	// FlagInternal marks commands cluster nodes send each other
+	FlagInternal
+)
+
+// FlagCategories are the flags that are ACL categories
+const FlagCategories = FlagReadOnly | FlagWrite | FlagAdmin | FlagConnection | FlagPubSub | FlagInternal
+
+// CommandHandler executes a command, writing its reply to ctx.Out. A
+// returned error is sent to the client as an error reply instead.
+type CommandHandler func(ctx *CommandContext) error
+
+// Command describes a server command
+type Command struct {
+	Name string
+	// Arity follows the Redis convention: a positive value is the exact
+	// argument count including the command name, a negative value is the
+	// minimum count
+	Arity int
+	// Flags must include an ACL category: read, write, admin, connection,
+	// pubsub or internal. Under an ACL, commands without one are refused.
+	Flags   CommandFlags
+	Handler CommandHandler
+	// Keys returns the keys a command accesses, for ACL checks and client
+	// tracking of reads. Reads of commands without it are not tracked, and
+	// only users with access to all keys may run them under an ACL.
+	Keys func(args [][]byte) []string
+	// KeyArgs returns the positions of the key arguments. RegisterCommands
+	// derives Keys from it, and namespace key normalization rewrites the
+	// arguments at these positions; commands with only Keys are not
+	// normalized.
+	KeyArgs func(args [][]byte) []int
+}
+
+// Client is the connection a command was sent on
+type Client interface {
+	// ID is unique among the server's connections
+	ID() uint64
+	// User is the ACL user the connection is signed in as
+	User() string
+}
+
+// CommandContext carries a single command invocation
+type CommandContext struct {
+	// Context is done once the command's execution deadline passes.
+	// Handlers that scan or call out pass it on or check it between
+	// steps, returning its error to abort.
+	Context context.Context
+	Cache   *Cache
+	// Client is nil for commands not sent by a client, such as those
+	// replayed from the AOF
+	Client Client
+	// Command is the command being run, set by the dispatcher
+	Command *Command
+	// Args holds the command name followed by its arguments
+	Args [][]byte
+	Out  *RESPWriter
+	// Forwarded is set for commands another node forwarded to this one as
+	// the owner of their keys, which are never forwarded again
+	Forwarded bool
+	// User is the ACL user a forwarded command runs as: the one its
+	// client signed in as on the forwarding node
+	User string
+}
+
+// Common command errors
+var (
+	ErrSyntax        = errors.New("ERR syntax error")
+	ErrNotInteger    = errors.New("ERR value is not an integer or out of range")
+	errInvalidExpire = errors.New("ERR invalid expire time")
+	errWitness       = errors.New("READONLY this node is a witness and holds no data")
+	ErrTimeout       = errors.New("TIMEOUT command exceeded its execution deadline")
+)
+
+// deadlineCheckInterval is how many items a scan visits between checks of
+// its command's deadline
+const deadlineCheckInterval = 1024
+
+// commandTable holds every registered command keyed by upper-case name
+var commandTable = make(map[string]*Command)
+
+// RegisterCommands adds commands to the command table
+func RegisterCommands(cmds ...*Command) {
+	for _, cmd := range cmds {
+		if cmd.Keys == nil && cmd.KeyArgs != nil {
+			keyArgs := cmd.KeyArgs
+			cmd.Keys = func(args [][]byte) []string {
+				positions := keyArgs(args)
+				keys := make([]string, len(positions))
+				for i, pos := range positions {
+					keys[i] = string(args[pos])
+				}
+				return keys
+			}
+		}
+		commandTable[strings.ToUpper(cmd.Name)] = cmd
+	}
+}
+
+// LookupCommand finds a command by name, ignoring case
+func LookupCommand(name string) *Command {
+	return commandTable[strings.ToUpper(name)]
+}
+
+// CheckServable returns the error cmd fails with if this node cannot
+// serve it: a witness holds no data, and a replica may refuse reads while
+// it lags its primary
+func (c *Cache) CheckServable(cmd *Command) error {
+	// A witness serves cluster and admin commands only
+	if cmd.Flags&(FlagWrite|FlagReadOnly) != 0 && cmd.Flags&FlagAdmin == 0 && c.isWitness() {
+		return errWitness
+	}
+	if cmd.Flags&FlagReadOnly != 0 && cmd.Flags&FlagAdmin == 0 {
+		if _, err := c.CheckStaleness(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunCommand runs ctx's command handler, logging a write for replication
+// unless the command logs its own effect
+func RunCommand(ctx *CommandContext) error {
+	cmd := ctx.Command
+	if wal := ctx.Cache.replicationLog(); wal != nil && cmd.Flags&FlagWrite != 0 && cmd.Flags&FlagSelfLogged == 0 {
+		ctx.Context = context.WithValue(ctx.Context, commandLoggedKey{}, true)
+		var keys []string
+		if cmd.Keys != nil {
+			keys = cmd.Keys(ctx.Args)
+		}
+		return wal.log(keys, ctx.Args, func() error { return cmd.Handler(ctx) })
+	}
+	return cmd.Handler(ctx)
+}
+
+// ParseInt parses an integer command argument
+func ParseInt(arg []byte) (int64, error) {
+	n, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	return n, nil
+}
+
+// keysFromArgs converts arguments to key strings
+func keysFromArgs(args [][]byte) []string {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	return keys
+}
+
+// noKeyArgs is Command.KeyArgs for commands that name no keys, so any
+// user with their category may run them
+func noKeyArgs(args [][]byte) []int {
+	return nil
+}
+
+// firstKeyArg is Command.KeyArgs for commands on their first argument
+func firstKeyArg(args [][]byte) []int {
+	return []int{1}
+}
+
+// allKeyArgs is Command.KeyArgs for commands on every argument
+func allKeyArgs(args [][]byte) []int {
+	return argRange(1, len(args))
+}
+
+// firstTwoKeyArgs is Command.KeyArgs for commands taking two keys first
+func firstTwoKeyArgs(args [][]byte) []int {
+	return []int{1, 2}
+}
+
+// numKeysArgs is Command.KeyArgs for commands taking a key count and then
+// that many keys
+func numKeysArgs(args [][]byte) []int {
+	n, err := ParseInt(args[1])
+	if err != nil || n < 0 || n > int64(len(args)-2) {
+		return nil
+	}
+	return argRange(2, 2+int(n))
+}
+
+// pairKeyArgs is Command.KeyArgs for commands taking key value pairs
+func pairKeyArgs(args [][]byte) []int {
+	positions := make([]int, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		positions = append(positions, i)
+	}
+	return positions
+}
+
+// argRange returns the positions from start up to end
+func argRange(start, end int) []int {
+	positions := make([]int, 0, end-start)
+	for i := start; i < end; i++ {
+		positions = append(positions, i)
+	}
+	return positions
+}
@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "GET", Arity: 2, Flags: FlagReadOnly, Handler: getCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "SET", Arity: -3, Flags: FlagWrite | FlagSelfLogged, Handler: setCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "DEL", Arity: -2, Flags: FlagWrite, Handler: delCommand, KeyArgs: allKeyArgs},
+		&Command{Name: "EXISTS", Arity: -2, Flags: FlagReadOnly, Handler: existsCommand, KeyArgs: allKeyArgs},
+		&Command{Name: "MGET", Arity: -2, Flags: FlagReadOnly, Handler: mgetCommand, KeyArgs: allKeyArgs},
+		&Command{Name: "MSET", Arity: -3, Flags: FlagWrite, Handler: msetCommand, KeyArgs: pairKeyArgs},
+		&Command{Name: "TYPE", Arity: 2, Flags: FlagReadOnly, Handler: typeCommand, KeyArgs: firstKeyArg},
+	)
+}
+
+func getCommand(ctx *CommandContext) error {
+	key := string(ctx.Args[1])
+	value, ok, err := ctx.Cache.GetOrFetch(ctx.Context, key)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("ERR %v", err)
+	}
+	if !ok {
+		if ctx.Cache.Type(key) != "none" {
+			return ErrWrongType
+		}
+		ctx.Out.WriteNull()
+		return nil
+	}
+	ctx.Out.WriteBulk(value)
+	return nil
+}
+
+// setCommand implements
+// SET key value [EX seconds|PX milliseconds|EXAT unix-seconds|PXAT unix-ms]
+// [SLIDING] [PIN] [COST n] [TAGS tags] [ORIGIN origin]
+func setCommand(ctx *CommandContext) error {
+	var opts SetOptions
+	for i := 3; i < len(ctx.Args); i++ {
+		option := strings.ToUpper(string(ctx.Args[i]))
+		switch option {
+		case "EX", "PX":
+			if opts.TTL != nil || opts.ExpiresAt != nil || i+1 >= len(ctx.Args) {
+				return ErrSyntax
+			}
+			n, err := ParseInt(ctx.Args[i+1])
+			if err != nil {
+				return err
+			}
+			if n <= 0 {
+				return errInvalidExpire
+			}
+			d := time.Duration(n) * time.Second
+			if option == "PX" {
+				d = time.Duration(n) * time.Millisecond
+			}
+			opts.TTL = &d
+			i++
+		case "EXAT", "PXAT":
+			if opts.TTL != nil || opts.ExpiresAt != nil || i+1 >= len(ctx.Args) {
+				return ErrSyntax
+			}
+			n, err := ParseInt(ctx.Args[i+1])
+			if err != nil {
+				return err
+			}
+			if n <= 0 {
+				return errInvalidExpire
+			}
+			at := time.UnixMilli(n)
+			if option == "EXAT" {
+				at = time.Unix(n, 0)
+			}
+			opts.ExpiresAt = &at
+			i++
+		case "SLIDING":
+			opts.Sliding = true
+		case "PIN":
+			opts.Pin = true
+		case "COST":
+			if i+1 >= len(ctx.Args) {
+				return ErrSyntax
+			}
+			n, err := ParseInt(ctx.Args[i+1])
+			if err != nil || n <= 0 {
+				return ErrNotInteger
+			}
+			opts.Cost = n
+			i++
+		case "TAGS":
+			if opts.Tags != nil || i+1 >= len(ctx.Args) {
+				return ErrSyntax
+			}
+			opts.Tags = ParseTags(string(ctx.Args[i+1]))
+			i++
+		case "ORIGIN":
+			if opts.Origin != "" || i+1 >= len(ctx.Args) {
+				return ErrSyntax
+			}
+			opts.Origin = string(ctx.Args[i+1])
+			i++
+		default:
+			return ErrSyntax
+		}
+	}
+	if opts.Sliding && opts.TTL == nil {
+		return errors.New("ERR SLIDING requires EX or PX")
+	}
+
+	// SetWithOptions logs the entry as stored, with an absolute expiry, so
+	// replaying the log later does not extend the TTL
+	if err := ctx.Cache.SetWithOptions(ctx.Context, string(ctx.Args[1]), ctx.Args[2], opts); err != nil {
+		if err == ErrOOM {
+			return err
+		}
+		return fmt.Errorf("ERR %v", err)
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+func delCommand(ctx *CommandContext) error {
+	deleted := int64(0)
+	for _, key := range ctx.Args[1:] {
+		ok, err := ctx.Cache.Delete(ctx.Context, string(key))
+		if err != nil {
+			return fmt.Errorf("ERR %v", err)
+		}
+		if ok {
+			deleted++
+		}
+	}
+	ctx.Out.WriteInteger(deleted)
+	return nil
+}
+
+func existsCommand(ctx *CommandContext) error {
+	count := int64(0)
+	for _, key := range ctx.Args[1:] {
+		if ctx.Cache.Exists(ctx.Context, string(key)) {
+			count++
+		}
+	}
+	ctx.Out.WriteInteger(count)
+	return nil
+}
+
+func mgetCommand(ctx *CommandContext) error {
+	ctx.Out.WriteArrayHeader(len(ctx.Args) - 1)
+	for _, key := range ctx.Args[1:] {
+		if value, ok := ctx.Cache.Get(ctx.Context, string(key)); ok {
+			ctx.Out.WriteBulk(value)
+		} else {
+			ctx.Out.WriteNull()
+		}
+	}
+	return nil
+}
+
+func msetCommand(ctx *CommandContext) error {
+	if len(ctx.Args)%2 != 1 {
+		return ErrSyntax
+	}
+	for i := 1; i < len(ctx.Args); i += 2 {
+		if err := ctx.Cache.SetWithOptions(ctx.Context, string(ctx.Args[i]), ctx.Args[i+1], SetOptions{}); err != nil {
+			return err
+		}
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+func typeCommand(ctx *CommandContext) error {
+	ctx.Out.WriteSimpleString(ctx.Cache.Type(string(ctx.Args[1])))
+	return nil
+}
@@ -0,0 +1,387 @@
+package cache
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Config holds cache-related configuration
+type Config struct {
+	// MaxMemory of zero is set to MaxMemoryFraction of the container's
+	// cgroup memory limit, or of the host's memory if there is none
+	MaxMemory         int64         `json:"max_memory" toml:"max_memory" yaml:"max_memory"`
+	MaxMemoryFraction float64       `json:"max_memory_fraction" toml:"max_memory_fraction" yaml:"max_memory_fraction"`
+	DefaultTTL        time.Duration `json:"default_ttl" toml:"default_ttl" yaml:"default_ttl"`
+	CleanupInterval   time.Duration `json:"cleanup_interval" toml:"cleanup_interval" yaml:"cleanup_interval"`
+	EvictionPolicy    string        `json:"eviction_policy" toml:"eviction_policy" yaml:"eviction_policy"`
+	// EvictionSamples is how many entries sampled-lru and volatile-ttl
+	// compare per eviction
+	EvictionSamples   int  `json:"eviction_samples" toml:"eviction_samples" yaml:"eviction_samples"`
+	EnableCompression bool `json:"enable_compression" toml:"enable_compression" yaml:"enable_compression"`
+	CompressionLevel  int  `json:"compression_level" toml:"compression_level" yaml:"compression_level"`
+	// ShardCount is how many independently locked shards the keyspace is
+	// split into, so that operations on keys in different shards run in
+	// parallel. One puts every key under a single lock. Zero picks a power
+	// of two from GOMAXPROCS.
+	ShardCount int `json:"shard_count" toml:"shard_count" yaml:"shard_count"`
+	// ShardHash picks the shard of a key: "fnv", the default, "xxhash" or
+	// "maphash"
+	ShardHash     string `json:"shard_hash" toml:"shard_hash" yaml:"shard_hash"`
+	EnableMetrics bool   `json:"enable_metrics" toml:"enable_metrics" yaml:"enable_metrics"`
+	MaxKeys       int    `json:"max_keys" toml:"max_keys" yaml:"max_keys"`
+	// MaxPinnedBytes caps the memory of pinned entries; zero means no limit
+	MaxPinnedBytes int64 `json:"max_pinned_bytes" toml:"max_pinned_bytes" yaml:"max_pinned_bytes"`
+	// Namespaces overrides TTL handling for keys of the form "namespace:..."
+	Namespaces map[string]NamespaceOptions `json:"namespaces" toml:"namespaces" yaml:"namespaces"`
+	// Preload lists files of reference data loaded into the cache on boot
+	Preload []PreloadConfig `json:"preload" toml:"preload" yaml:"preload"`
+	// RemovalWebhook receives batches of entries leaving the cache, limited
+	// to RemovalWebhookReasons when set
+	RemovalWebhook        string   `json:"removal_webhook" toml:"removal_webhook" yaml:"removal_webhook"`
+	RemovalWebhookReasons []string `json:"removal_webhook_reasons" toml:"removal_webhook_reasons" yaml:"removal_webhook_reasons"`
+	// KeyWebhooks receive batches of changes to keys matching their prefixes
+	KeyWebhooks []KeyWebhookConfig `json:"key_webhooks" toml:"key_webhooks" yaml:"key_webhooks"`
+	// MemorySoftLimitPercent of MaxMemory starts background eviction and a
+	// pressure notification when exceeded; zero disables it
+	MemorySoftLimitPercent int `json:"memory_soft_limit_percent" toml:"memory_soft_limit_percent" yaml:"memory_soft_limit_percent"`
+	// MemoryLimitAction is what happens to writes at MaxMemory: "evict" or
+	// "reject"
+	MemoryLimitAction string `json:"memory_limit_action" toml:"memory_limit_action" yaml:"memory_limit_action"`
+	// MemoryPressureWebhook receives memory pressure level changes
+	MemoryPressureWebhook string `json:"memory_pressure_webhook" toml:"memory_pressure_webhook" yaml:"memory_pressure_webhook"`
+	// DefragInterval is how often fragmentation is checked; zero disables
+	// defragmentation
+	DefragInterval time.Duration `json:"defrag_interval" toml:"defrag_interval" yaml:"defrag_interval"`
+	// DefragThreshold is the ratio of heap in use to accounted memory
+	// above which the cache is defragmented
+	DefragThreshold float64 `json:"defrag_threshold" toml:"defrag_threshold" yaml:"defrag_threshold"`
+	// IdleTimeout evicts entries not accessed for this long, whatever
+	// their TTL; zero disables the idle reaper
+	IdleTimeout time.Duration `json:"idle_timeout" toml:"idle_timeout" yaml:"idle_timeout"`
+	// IdleReapInterval is how often idle entries are looked for
+	IdleReapInterval time.Duration `json:"idle_reap_interval" toml:"idle_reap_interval" yaml:"idle_reap_interval"`
+	// AccessTraceSampleRate is the fraction of keys whose accesses are
+	// traced for the eviction simulator; zero disables tracing
+	AccessTraceSampleRate float64 `json:"access_trace_sample_rate" toml:"access_trace_sample_rate" yaml:"access_trace_sample_rate"`
+	// AccessTraceSize is how many recent accesses the trace keeps
+	AccessTraceSize int `json:"access_trace_size" toml:"access_trace_size" yaml:"access_trace_size"`
+}
+
+// ClusterConfig holds clustering configuration
+type ClusterConfig struct {
+	Enabled bool     `json:"enabled" toml:"enabled" yaml:"enabled"`
+	NodeID  string   `json:"node_id" toml:"node_id" yaml:"node_id"`
+	Seeds   []string `json:"seeds" toml:"seeds" yaml:"seeds"`
+	// AdvertiseAddr is the address other nodes reach this node's RESP
+	// listener at. It defaults to server.Config's host, or the hostname if
+	// that is unspecified, and port.
+	AdvertiseAddr string `json:"advertise_addr" toml:"advertise_addr" yaml:"advertise_addr"`
+	// Partition splits the keys between the data nodes with a consistent
+	// hash ring, forwarding commands to their keys' owner. VirtualNodes is
+	// how many points each node gets on the ring.
+	Partition        bool          `json:"partition" toml:"partition" yaml:"partition"`
+	VirtualNodes     int           `json:"virtual_nodes" toml:"virtual_nodes" yaml:"virtual_nodes"`
+	Port             int           `json:"port" toml:"port" yaml:"port"`
+	GossipInterval   time.Duration `json:"gossip_interval" toml:"gossip_interval" yaml:"gossip_interval"`
+	ProbeInterval    time.Duration `json:"probe_interval" toml:"probe_interval" yaml:"probe_interval"`
+	ProbeTimeout     time.Duration `json:"probe_timeout" toml:"probe_timeout" yaml:"probe_timeout"`
+	SuspicionMult    int           `json:"suspicion_mult" toml:"suspicion_mult" yaml:"suspicion_mult"`
+	ReconnectIntvl   time.Duration `json:"reconnect_interval" toml:"reconnect_interval" yaml:"reconnect_interval"`
+	ReconnectTimeout time.Duration `json:"reconnect_timeout" toml:"reconnect_timeout" yaml:"reconnect_timeout"`
+	// ReplBacklogSize is how many bytes of recent writes the WAL keeps for
+	// replicas to catch up from after a disconnect
+	ReplBacklogSize int64 `json:"repl_backlog_size" toml:"repl_backlog_size" yaml:"repl_backlog_size"`
+	// MirrorAddresses, when set, receive a copy of every write for a live
+	// migration to another cluster
+	MirrorAddresses []string `json:"mirror_addresses" toml:"mirror_addresses" yaml:"mirror_addresses"`
+	// MirrorBackfill copies the existing keys to the mirror before
+	// forwarding writes
+	MirrorBackfill bool `json:"mirror_backfill" toml:"mirror_backfill" yaml:"mirror_backfill"`
+	// Witness makes this node a voter in failure detection and quorum
+	// that holds no data
+	Witness bool `json:"witness" toml:"witness" yaml:"witness"`
+	// MaxStaleness bounds how far a replica may lag its primary before
+	// StaleReads applies to reads. Zero disables the bound.
+	MaxStaleness time.Duration `json:"max_staleness" toml:"max_staleness" yaml:"max_staleness"`
+	// StaleReads is "reject" or "flag"
+	StaleReads string `json:"stale_reads" toml:"stale_reads" yaml:"stale_reads"`
+	// IDGenNode is the node number in IDs from IDGEN, unique per node.
+	// Negative derives it from the node ID, which may collide.
+	IDGenNode int `json:"idgen_node" toml:"idgen_node" yaml:"idgen_node"`
+	// Username and Password sign this node in to its peers when it probes
+	// them and forwards commands to them. The user needs +@internal on
+	// the peers; Password may be a secret reference.
+	Username string `json:"username" toml:"username" yaml:"username"`
+	Password string `json:"password" toml:"password" yaml:"password"`
+}
+
+// StorageConfig holds persistence configuration
+type StorageConfig struct {
+	Enabled      bool          `json:"enabled" toml:"enabled" yaml:"enabled"`
+	Type         string        `json:"type" toml:"type" yaml:"type"`
+	Path         string        `json:"path" toml:"path" yaml:"path"`
+	SyncInterval time.Duration `json:"sync_interval" toml:"sync_interval" yaml:"sync_interval"`
+	// AppendFsync is when the AOF is synced to disk: "always", "everysec"
+	// or "no"
+	AppendFsync string `json:"append_fsync" toml:"append_fsync" yaml:"append_fsync"`
+	// AOFLoadTruncated truncates an AOF with a corrupt tail to its last
+	// valid record at startup instead of refusing to start
+	AOFLoadTruncated bool `json:"aof_load_truncated" toml:"aof_load_truncated" yaml:"aof_load_truncated"`
+	// SkipChecksum loads snapshots that fail checksum verification. It is
+	// meant for disaster recovery only.
+	SkipChecksum bool `json:"skip_checksum" toml:"skip_checksum" yaml:"skip_checksum"`
+	// FullSnapshotEvery makes every Nth snapshot a full one, the rest
+	// holding only keys changed since. 1 disables incremental snapshots and
+	// zero takes a full one only once half the keys have changed.
+	FullSnapshotEvery int `json:"full_snapshot_every" toml:"full_snapshot_every" yaml:"full_snapshot_every"`
+	// SnapshotInterval is how often a snapshot is taken in the background,
+	// if at least SnapshotMinChanges writes were made since the last one;
+	// zero disables the schedule
+	SnapshotInterval   time.Duration `json:"snapshot_interval" toml:"snapshot_interval" yaml:"snapshot_interval"`
+	SnapshotMinChanges int64         `json:"snapshot_min_changes" toml:"snapshot_min_changes" yaml:"snapshot_min_changes"`
+	MaxFileSize        int64         `json:"max_file_size" toml:"max_file_size" yaml:"max_file_size"`
+	Compression        bool          `json:"compression" toml:"compression" yaml:"compression"`
+	Encryption         bool          `json:"encryption" toml:"encryption" yaml:"encryption"`
+	EncryptionKey      string        `json:"encryption_key" toml:"encryption_key" yaml:"encryption_key"`
+	BackupEnabled      bool          `json:"backup_enabled" toml:"backup_enabled" yaml:"backup_enabled"`
+	BackupInterval     time.Duration `json:"backup_interval" toml:"backup_interval" yaml:"backup_interval"`
+	BackupRetention    int           `json:"backup_retention" toml:"backup_retention" yaml:"backup_retention"`
+}
+
+// CDCConfig holds change data capture configuration
+type CDCConfig struct {
+	Enabled bool `json:"enabled" toml:"enabled" yaml:"enabled"`
+	// Backend is "kafka" or "nats"
+	Backend string   `json:"backend" toml:"backend" yaml:"backend"`
+	Brokers []string `json:"brokers" toml:"brokers" yaml:"brokers"`
+	// Topic is the Kafka topic or NATS JetStream subject
+	Topic string `json:"topic" toml:"topic" yaml:"topic"`
+	// Prefix limits the stream to keys starting with it
+	Prefix string `json:"prefix" toml:"prefix" yaml:"prefix"`
+	// IncludeValues sends values instead of their SHA-256 hashes
+	IncludeValues bool `json:"include_values" toml:"include_values" yaml:"include_values"`
+	BatchSize     int  `json:"batch_size" toml:"batch_size" yaml:"batch_size"`
+	// BufferSize is how many events are held while the backend is
+	// unavailable before the publisher falls back on the event history
+	BufferSize int `json:"buffer_size" toml:"buffer_size" yaml:"buffer_size"`
+}
+
+// DefaultConfig returns the default cache configuration
+func DefaultConfig() Config {
+	return Config{
+		MaxMemory:              512 * 1024 * 1024, // 512MB
+		MaxMemoryFraction:      0.75,
+		DefaultTTL:             24 * time.Hour,
+		CleanupInterval:        10 * time.Minute,
+		EvictionPolicy:         "lru",
+		EvictionSamples:        5,
+		EnableCompression:      true,
+		CompressionLevel:       6,
+		ShardCount:             0, // derived from GOMAXPROCS
+		EnableMetrics:          true,
+		MaxKeys:                1000000,
+		MaxPinnedBytes:         64 * 1024 * 1024, // 64MB
+		MemorySoftLimitPercent: 90,
+		MemoryLimitAction:      MemoryLimitEvict,
+		DefragInterval:         time.Minute,
+		DefragThreshold:        1.5,
+		IdleReapInterval:       time.Minute,
+	}
+}
+
+// DefaultClusterConfig returns the default clustering configuration
+func DefaultClusterConfig() ClusterConfig {
+	return ClusterConfig{
+		Enabled:          false,
+		GossipInterval:   1 * time.Second,
+		ProbeInterval:    5 * time.Second,
+		ProbeTimeout:     3 * time.Second,
+		SuspicionMult:    5,
+		VirtualNodes:     defaultVirtualNodes,
+		ReconnectIntvl:   10 * time.Second,
+		ReconnectTimeout: 6 * time.Second,
+		ReplBacklogSize:  defaultWALBacklog,
+		MirrorBackfill:   true,
+		StaleReads:       StaleReadsReject,
+		IDGenNode:        -1,
+	}
+}
+
+// DefaultStorageConfig returns the default persistence configuration
+func DefaultStorageConfig() StorageConfig {
+	return StorageConfig{
+		Enabled:            false,
+		Type:               "aof",
+		Path:               "./data",
+		SyncInterval:       1 * time.Second,
+		AppendFsync:        FsyncEverySec,
+		AOFLoadTruncated:   true,
+		FullSnapshotEvery:  24,
+		SnapshotInterval:   15 * time.Minute,
+		SnapshotMinChanges: 1,
+		MaxFileSize:        1024 * 1024 * 1024, // 1GB
+		Compression:        true,
+		BackupEnabled:      false,
+		BackupInterval:     24 * time.Hour,
+		BackupRetention:    7,
+	}
+}
+
+// DefaultCDCConfig returns the default change data capture configuration
+func DefaultCDCConfig() CDCConfig {
+	return CDCConfig{
+		Enabled:    false,
+		Backend:    CDCKafka,
+		Topic:      "cache-changes",
+		BatchSize:  cdcDefaultBatchSize,
+		BufferSize: cdcDefaultBufferSize,
+	}
+}
+
+// Validate validates the cache configuration
+func (c *Config) Validate() error {
+	if c.MaxMemory < 1024*1024 { // 1MB minimum
+		return fmt.Errorf("max memory too small: %d", c.MaxMemory)
+	}
+	if c.MaxMemoryFraction < 0 || c.MaxMemoryFraction > 1 {
+		return fmt.Errorf("max memory fraction must be between 0 and 1")
+	}
+	if c.ShardCount < 1 {
+		return fmt.Errorf("shard count must be at least 1")
+	}
+	if _, err := shardHash(c.ShardHash); err != nil {
+		return err
+	}
+	if c.MaxKeys < 1 {
+		return fmt.Errorf("max keys must be at least 1")
+	}
+	if !slices.Contains(EvictionPolicies, strings.ToLower(c.EvictionPolicy)) {
+		return fmt.Errorf("unknown eviction policy: %s", c.EvictionPolicy)
+	}
+	if c.MaxPinnedBytes < 0 {
+		return fmt.Errorf("max pinned bytes cannot be negative")
+	}
+	if c.MemorySoftLimitPercent < 0 || c.MemorySoftLimitPercent >= 100 {
+		return fmt.Errorf("memory soft limit percent must be between 0 and 99")
+	}
+	if _, err := ParseMemoryLimitAction(c.MemoryLimitAction); err != nil {
+		return err
+	}
+	if c.DefragInterval > 0 && c.DefragThreshold <= 1 {
+		return fmt.Errorf("defrag threshold must be greater than 1")
+	}
+	if c.DefaultTTL < 0 {
+		return fmt.Errorf("default TTL cannot be negative")
+	}
+	if c.AccessTraceSampleRate < 0 || c.AccessTraceSampleRate > 1 {
+		return fmt.Errorf("access trace sample rate must be between 0 and 1")
+	}
+	if c.AccessTraceSize < 0 {
+		return fmt.Errorf("access trace size cannot be negative")
+	}
+	if c.IdleTimeout < 0 {
+		return fmt.Errorf("idle timeout cannot be negative")
+	}
+	if c.IdleTimeout > 0 && c.IdleReapInterval <= 0 {
+		return fmt.Errorf("idle reap interval must be positive when an idle timeout is set")
+	}
+	for _, reason := range c.RemovalWebhookReasons {
+		if _, err := ParseRemovalReason(reason); err != nil {
+			return err
+		}
+	}
+	for i, hook := range c.KeyWebhooks {
+		if err := hook.Validate(); err != nil {
+			return fmt.Errorf("key webhook %d: %w", i, err)
+		}
+	}
+	for ns, opts := range c.Namespaces {
+		if err := opts.Validate(); err != nil {
+			return fmt.Errorf("namespace %q: %w", ns, err)
+		}
+	}
+	for i, file := range c.Preload {
+		if err := file.Validate(); err != nil {
+			return fmt.Errorf("preload %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// Validate validates the persistence configuration
+func (c *StorageConfig) Validate() error {
+	if _, err := ParseFsyncPolicy(c.AppendFsync); err != nil {
+		return err
+	}
+	if c.FullSnapshotEvery < 0 {
+		return fmt.Errorf("full snapshot interval cannot be negative")
+	}
+	if c.SnapshotInterval < 0 || c.SnapshotMinChanges < 0 {
+		return fmt.Errorf("snapshot schedule cannot be negative")
+	}
+	return nil
+}
+
+// Validate validates the clustering configuration
+func (c *ClusterConfig) Validate() error {
+	if c.ReplBacklogSize < 0 {
+		return fmt.Errorf("replication backlog size cannot be negative")
+	}
+	if c.Enabled {
+		if len(c.Seeds) == 0 {
+			return fmt.Errorf("cluster seeds required when clustering is enabled")
+		}
+		if c.ProbeInterval <= 0 || c.ProbeTimeout <= 0 {
+			return fmt.Errorf("cluster probe interval and timeout must be positive")
+		}
+		if c.GossipInterval < 0 {
+			return fmt.Errorf("gossip interval cannot be negative")
+		}
+		if c.SuspicionMult < 1 {
+			return fmt.Errorf("suspicion multiplier must be at least 1")
+		}
+		if c.VirtualNodes < 0 {
+			return fmt.Errorf("virtual nodes cannot be negative")
+		}
+	}
+	if c.MaxStaleness < 0 {
+		return fmt.Errorf("max staleness cannot be negative")
+	}
+	if c.IDGenNode > MaxIDNode {
+		return fmt.Errorf("ID generator node number cannot exceed %d", MaxIDNode)
+	}
+	if _, err := ParseStaleReadPolicy(c.StaleReads); err != nil {
+		return err
+	}
+	if c.Witness {
+		if !c.Enabled {
+			return fmt.Errorf("a witness requires clustering to be enabled")
+		}
+		if len(c.MirrorAddresses) > 0 {
+			return fmt.Errorf("a witness holds no data to mirror")
+		}
+	}
+	return nil
+}
+
+// Validate validates the change data capture configuration
+func (c *CDCConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if _, err := ParseCDCBackend(c.Backend); err != nil {
+		return err
+	}
+	if len(c.Brokers) == 0 {
+		return fmt.Errorf("CDC brokers required when CDC is enabled")
+	}
+	if c.Topic == "" {
+		return fmt.Errorf("CDC topic required when CDC is enabled")
+	}
+	if c.BatchSize < 1 || c.BufferSize < c.BatchSize {
+		return fmt.Errorf("CDC buffer size must be at least the batch size, which must be at least 1")
+	}
+	return nil
+}
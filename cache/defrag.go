@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"runtime"
+	"time"
+)
+
+// Defragmentation tuning
+const (
+	// defragChunkSize entries are copied per shard lock acquisition
+	defragChunkSize = 1024
+	// defragPause is yielded to other goroutines between chunks
+	defragPause = time.Millisecond
+	// defragMinHeap avoids rebuilding small caches where the gain is noise
+	defragMinHeap = 32 * 1024 * 1024
+)
+
+// DefragStats describes the defragmentation routine's work
+type DefragStats struct {
+	Runs          int64         `json:"runs"`
+	LastRun       time.Time     `json:"last_run"`
+	LastDuration  time.Duration `json:"last_duration"`
+	Fragmentation float64       `json:"fragmentation"`
+}
+
+// compactor is implemented by eviction policies that can release memory
+// retained after heavy churn
+type compactor interface {
+	compact()
+}
+
+// Fragmentation returns the ratio of heap memory in use to the memory
+// accounted to entries. It includes everything else on the heap, so it
+// only approaches 1 when the cache dominates the process.
+func (c *Cache) Fragmentation() float64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return c.fragmentation(stats.HeapInuse)
+}
+
+func (c *Cache) fragmentation(heapInuse uint64) float64 {
+	used := c.usedMemory.Load()
+	if used <= 0 {
+		return 0
+	}
+	return float64(heapInuse) / float64(used)
+}
+
+// Defrag rebuilds the key maps and other structures that Go never shrinks,
+// so memory held for deleted entries can be released. Each shard's map is
+// copied in chunks under short locks of the shard; writes made between
+// chunks are mirrored into the new map. It reports false if it was
+// abandoned because the cache was cleared or another rebuild was running.
+func (c *Cache) Defrag() bool {
+	start := time.Now()
+
+	c.mutex.Lock()
+	if c.defragging {
+		c.mutex.Unlock()
+		return false
+	}
+	c.defragging = true
+	c.mutex.Unlock()
+
+	done := true
+	for _, s := range c.shards {
+		if done = s.defrag(); !done {
+			break
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.defragging = false
+	if !done {
+		return false
+	}
+	c.defragStats.Runs++
+	c.defragStats.LastRun = start
+	c.defragStats.LastDuration = time.Since(start)
+	return true
+}
+
+// defrag rebuilds the shard's key map and compacts its indexes, reporting
+// false if the shard was cleared meanwhile. It takes the shard's lock
+// itself.
+func (s *cacheShard) defrag() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	old := s.data
+	fresh := make(map[string]*CacheEntry, len(old))
+	s.rebuilding = fresh
+
+	copied := 0
+	for key, entry := range old {
+		fresh[key] = entry
+		copied++
+		if copied%defragChunkSize == 0 {
+			s.mutex.Unlock()
+			time.Sleep(defragPause)
+			s.mutex.Lock()
+			if s.rebuilding == nil {
+				// Cleared while unlocked
+				return false
+			}
+		}
+	}
+	s.data = fresh
+	s.rebuilding = nil
+	s.compactIndexes()
+	return true
+}
+
+// compactIndexes rebuilds the smaller structures that retain memory after
+// churn
+func (s *cacheShard) compactIndexes() {
+	if cap(s.expirations) > 2*len(s.expirations) {
+		s.expirations = append(make(expirationIndex, 0, len(s.expirations)), s.expirations...)
+	}
+	for tag, keys := range s.tags {
+		fresh := make(map[string]struct{}, len(keys))
+		for key := range keys {
+			fresh[key] = struct{}{}
+		}
+		s.tags[tag] = fresh
+	}
+	if policy, ok := s.policy.(compactor); ok {
+		policy.compact()
+	}
+}
+
+// DefragStats returns statistics about defragmentation runs
+func (c *Cache) DefragStats() DefragStats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.defragStats
+}
+
+// StartDefragRoutine checks fragmentation every interval and defragments
+// when it exceeds threshold
+func (c *Cache) StartDefragRoutine(interval time.Duration, threshold float64) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			var stats runtime.MemStats
+			runtime.ReadMemStats(&stats)
+			if stats.HeapInuse < defragMinHeap {
+				continue
+			}
+
+			ratio := c.fragmentation(stats.HeapInuse)
+			c.mutex.Lock()
+			c.defragStats.Fragmentation = ratio
+			c.mutex.Unlock()
+			if ratio > threshold {
+				c.Defrag()
+			}
+		}
+	}()
+}
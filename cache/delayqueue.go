@@ -0,0 +1,380 @@
+package cache
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// A delay queue holds items that become available at a not-before time,
+// such as retries and reminders. Items are ordered by due time, then by
+// when they were added, and each due item is handed to exactly one
+// consumer: popping removes it under the cache's write lock.
+
+// ErrDelayedItemExists is returned when adding an item whose ID is queued
+var ErrDelayedItemExists = errors.New("item ID already queued")
+
+// DelayedItem is an item of a delay queue
+type DelayedItem struct {
+	ID      string
+	Payload []byte
+	Due     time.Time
+}
+
+// delayQueue is a min-heap of items by due time, with an index by ID
+type delayQueue struct {
+	items []*delayedEntry
+	ids   map[string]*delayedEntry
+	// seq orders items due at the same time and generates IDs
+	seq uint64
+}
+
+type delayedEntry struct {
+	DelayedItem
+	seq   uint64
+	index int
+}
+
+func newDelayQueue() *delayQueue {
+	return &delayQueue{ids: make(map[string]*delayedEntry)}
+}
+
+// TypeName implements cacheObject
+func (q *delayQueue) TypeName() string {
+	return "delayqueue"
+}
+
+// memoryUsage implements memorySizer
+func (q *delayQueue) memoryUsage() int64 {
+	n := int64(unsafe.Sizeof(*q)) + allocSize(int64(cap(q.items))*int64(unsafe.Sizeof(uintptr(0))))
+	for id, item := range q.ids {
+		n += mapSlotSize + allocSize(int64(len(id))) + allocSize(int64(unsafe.Sizeof(*item))) + allocSize(int64(cap(item.Payload)))
+	}
+	return n
+}
+
+// rewriteCommands implements aofRewriter, queueing the items in the order
+// they were added so items due at the same time keep their order
+func (q *delayQueue) rewriteCommands(key string) [][][]byte {
+	items := append([]*delayedEntry(nil), q.items...)
+	sort.Slice(items, func(i, j int) bool { return items[i].seq < items[j].seq })
+	commands := make([][][]byte, len(items))
+	for i, item := range items {
+		commands[i] = dqAddAtArgs(key, item.DelayedItem)
+	}
+	return commands
+}
+
+func (q *delayQueue) Len() int { return len(q.items) }
+
+func (q *delayQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if !a.Due.Equal(b.Due) {
+		return a.Due.Before(b.Due)
+	}
+	return a.seq < b.seq
+}
+
+func (q *delayQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+	q.items[i].index = i
+	q.items[j].index = j
+}
+
+func (q *delayQueue) Push(x interface{}) {
+	item := x.(*delayedEntry)
+	item.index = len(q.items)
+	q.items = append(q.items, item)
+}
+
+func (q *delayQueue) Pop() interface{} {
+	n := len(q.items)
+	item := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	return item
+}
+
+// add queues an item, generating its ID if it has none
+func (q *delayQueue) add(item DelayedItem) (string, error) {
+	q.seq++
+	if item.ID == "" {
+		item.ID = strconv.FormatInt(item.Due.UnixMilli(), 10) + "-" + strconv.FormatUint(q.seq, 10)
+	}
+	if _, exists := q.ids[item.ID]; exists {
+		return "", ErrDelayedItemExists
+	}
+	entry := &delayedEntry{DelayedItem: item, seq: q.seq}
+	heap.Push(q, entry)
+	q.ids[item.ID] = entry
+	return item.ID, nil
+}
+
+// popDue removes and returns the first item due at now
+func (q *delayQueue) popDue(now time.Time) (DelayedItem, bool) {
+	if len(q.items) == 0 || q.items[0].Due.After(now) {
+		return DelayedItem{}, false
+	}
+	entry := heap.Pop(q).(*delayedEntry)
+	delete(q.ids, entry.ID)
+	return entry.DelayedItem, true
+}
+
+// remove deletes an item by ID
+func (q *delayQueue) remove(id string) bool {
+	entry, ok := q.ids[id]
+	if !ok {
+		return false
+	}
+	heap.Remove(q, entry.index)
+	delete(q.ids, id)
+	return true
+}
+
+// nextDue returns when the first item is due, or zero if the queue is empty
+func (q *delayQueue) nextDue() time.Time {
+	if len(q.items) == 0 {
+		return time.Time{}
+	}
+	return q.items[0].Due
+}
+
+// EnqueueDelayed adds an item to the delay queue at key, creating the
+// queue if needed, and returns its ID. An empty ID is generated.
+func (c *Cache) EnqueueDelayed(key string, item DelayedItem) (string, error) {
+	var id string
+	err := updateObject(c, key, func() (*delayQueue, error) { return newDelayQueue(), nil }, func(q *delayQueue) error {
+		var err error
+		id, err = q.add(item)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	c.keySignals.notify(key)
+	return id, nil
+}
+
+// PopDelayed removes and returns the first item of the queue at key that
+// is due
+func (c *Cache) PopDelayed(key string) (DelayedItem, bool, error) {
+	item, ok, _, err := c.popDelayed(key)
+	return item, ok, err
+}
+
+// popDelayed pops the first item due now, or returns when the first item
+// will be due
+func (c *Cache) popDelayed(key string) (item DelayedItem, ok bool, next time.Time, err error) {
+	err = updateObject(c, key, nil, func(q *delayQueue) error {
+		item, ok = q.popDue(c.now())
+		next = q.nextDue()
+		return nil
+	})
+	if err == ErrNoSuchKey {
+		err = nil
+	}
+	return item, ok, next, err
+}
+
+// delayedPop is popDelayed, or a variant of it that also logs the pop
+type delayedPop func(key string) (DelayedItem, bool, time.Time, error)
+
+// WaitDelayed pops the first due item of the queue at key, waiting until
+// one is due or ctx is done. Concurrent waiters each receive a different
+// item.
+func (c *Cache) WaitDelayed(ctx context.Context, key string) (DelayedItem, error) {
+	return c.waitDelayed(ctx, key, c.popDelayed)
+}
+
+func (c *Cache) waitDelayed(ctx context.Context, key string, pop delayedPop) (DelayedItem, error) {
+	var item DelayedItem
+	err := c.blockOnKey(ctx, key, func() (bool, time.Time, error) {
+		var ok bool
+		var next time.Time
+		var err error
+		item, ok, next, err = pop(key)
+		return ok, next, err
+	})
+	return item, err
+}
+
+// RemoveDelayed deletes an item from the queue at key by ID
+func (c *Cache) RemoveDelayed(key, id string) (bool, error) {
+	removed := false
+	err := updateObject(c, key, nil, func(q *delayQueue) error {
+		removed = q.remove(id)
+		return nil
+	})
+	if err == ErrNoSuchKey {
+		return false, nil
+	}
+	return removed, err
+}
+
+// DelayedLen returns the items in the queue at key, and how many are due
+func (c *Cache) DelayedLen(key string) (total, due int, err error) {
+	_, err = viewObject(c, key, func(q *delayQueue) error {
+		now := c.now()
+		total = q.Len()
+		for _, item := range q.items {
+			if !item.Due.After(now) {
+				due++
+			}
+		}
+		return nil
+	})
+	return total, due, err
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "DQ.ADD", Arity: -4, Flags: FlagWrite | FlagSelfLogged, Handler: dqAddCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "DQ.ADDAT", Arity: -4, Flags: FlagWrite | FlagSelfLogged, Handler: dqAddCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "DQ.POP", Arity: 2, Flags: FlagWrite | FlagSelfLogged, Handler: dqPopCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "DQ.BPOP", Arity: 3, Flags: FlagWrite | FlagSelfLogged, Handler: dqPopCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "DQ.DEL", Arity: 3, Flags: FlagWrite, Handler: dqDelCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "DQ.LEN", Arity: 2, Flags: FlagReadOnly, Handler: dqLenCommand, KeyArgs: firstKeyArg},
+	)
+}
+
+// delayQueueCommandError maps cache errors to replies, keeping those that
+// already carry a reply code
+func delayQueueCommandError(err error) error {
+	if err == ErrWrongType || err == ErrOOM {
+		return err
+	}
+	return fmt.Errorf("ERR %v", err)
+}
+
+// dqAddCommand implements DQ.ADD key delay-ms payload [ID id] and
+// DQ.ADDAT key unix-ms payload [ID id]. Both are logged as DQ.ADDAT with
+// the item's ID, so replicas and replays queue the same item.
+func dqAddCommand(ctx *CommandContext) error {
+	key := string(ctx.Args[1])
+	n, err := ParseInt(ctx.Args[2])
+	if err != nil {
+		return err
+	}
+	item := DelayedItem{Payload: append([]byte(nil), ctx.Args[3]...)}
+	if strings.EqualFold(string(ctx.Args[0]), "DQ.ADDAT") {
+		item.Due = time.UnixMilli(n)
+	} else if n < 0 {
+		return errors.New("ERR delay cannot be negative")
+	} else {
+		item.Due = ctx.Cache.now().Add(time.Duration(n) * time.Millisecond)
+	}
+	switch {
+	case len(ctx.Args) == 6 && strings.EqualFold(string(ctx.Args[4]), "ID"):
+		item.ID = string(ctx.Args[5])
+	case len(ctx.Args) != 4:
+		return ErrSyntax
+	}
+
+	err = ctx.Cache.logWrite(ctx.Context, []string{key}, func() ([][]byte, error) {
+		var err error
+		item.ID, err = ctx.Cache.EnqueueDelayed(key, item)
+		return dqAddAtArgs(key, item), err
+	})
+	if err != nil {
+		return delayQueueCommandError(err)
+	}
+	ctx.Out.WriteBulkString(item.ID)
+	return nil
+}
+
+// dqAddAtArgs is the DQ.ADDAT command queueing item
+func dqAddAtArgs(key string, item DelayedItem) [][]byte {
+	return [][]byte{
+		[]byte("DQ.ADDAT"), []byte(key),
+		[]byte(strconv.FormatInt(item.Due.UnixMilli(), 10)), item.Payload,
+		[]byte("ID"), []byte(item.ID),
+	}
+}
+
+// dqPopCommand implements DQ.POP key and DQ.BPOP key timeout-seconds,
+// replying with the item's ID and payload, or null if none is due. A zero
+// timeout waits until an item is due, within the command's execution
+// deadline if one is configured. The pop is logged as DQ.DEL of the
+// item, as which item is due depends on when the command runs.
+func dqPopCommand(ctx *CommandContext) error {
+	key := string(ctx.Args[1])
+	pop := func(key string) (item DelayedItem, ok bool, next time.Time, err error) {
+		err = ctx.Cache.logWrite(ctx.Context, []string{key}, func() ([][]byte, error) {
+			var err error
+			item, ok, next, err = ctx.Cache.popDelayed(key)
+			if err != nil || !ok {
+				return nil, err
+			}
+			return [][]byte{[]byte("DQ.DEL"), []byte(key), []byte(item.ID)}, nil
+		})
+		return item, ok, next, err
+	}
+
+	var item DelayedItem
+	var ok bool
+	var err error
+	if len(ctx.Args) == 3 {
+		timeout, perr := strconv.ParseFloat(string(ctx.Args[2]), 64)
+		if perr != nil || timeout < 0 {
+			return errors.New("ERR timeout is not a float or out of range")
+		}
+		waitCtx := ctx.Context
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			waitCtx, cancel = context.WithTimeout(ctx.Context, time.Duration(timeout*float64(time.Second)))
+			defer cancel()
+		}
+		item, err = ctx.Cache.waitDelayed(waitCtx, key, pop)
+		ok = err == nil
+		// Running out of time to wait is an empty reply, not an error
+		if err == context.DeadlineExceeded && ctx.Context.Err() == nil {
+			err = nil
+		}
+	} else {
+		item, ok, _, err = pop(key)
+	}
+	if err != nil {
+		if err == context.DeadlineExceeded {
+			return err
+		}
+		return delayQueueCommandError(err)
+	}
+	if !ok {
+		ctx.Out.WriteNull()
+		return nil
+	}
+
+	ctx.Out.WriteArrayHeader(2)
+	ctx.Out.WriteBulkString(item.ID)
+	ctx.Out.WriteBulk(item.Payload)
+	return nil
+}
+
+// dqDelCommand implements DQ.DEL key id, cancelling an item
+func dqDelCommand(ctx *CommandContext) error {
+	removed, err := ctx.Cache.RemoveDelayed(string(ctx.Args[1]), string(ctx.Args[2]))
+	if err != nil {
+		return delayQueueCommandError(err)
+	}
+	ctx.Out.WriteInteger(BoolInt(removed))
+	return nil
+}
+
+// dqLenCommand implements DQ.LEN key, replying with the queued items and
+// how many of them are due
+func dqLenCommand(ctx *CommandContext) error {
+	total, due, err := ctx.Cache.DelayedLen(string(ctx.Args[1]))
+	if err != nil {
+		return delayQueueCommandError(err)
+	}
+	ctx.Out.WriteArrayHeader(2)
+	ctx.Out.WriteInteger(int64(total))
+	ctx.Out.WriteInteger(int64(due))
+	return nil
+}
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// KeyEventType identifies the kind of change made to a key
+type KeyEventType string
+
+const (
+	KeyEventSet     KeyEventType = "set"
+	KeyEventDelete  KeyEventType = "del"
+	KeyEventExpired KeyEventType = "expired"
+	KeyEventEvicted KeyEventType = "evicted"
+	KeyEventFlush   KeyEventType = "flush"
+)
+
+// KeyEvent describes a single keyspace change. Seq increases monotonically
+// and can be used to resume a subscription after a disconnect.
+type KeyEvent struct {
+	Seq  uint64       `json:"seq"`
+	Type KeyEventType `json:"type"`
+	Key  string       `json:"key,omitempty"`
+	Time time.Time    `json:"time"`
+	// Reason says why a key left the cache, for del, expired and evicted events
+	Reason RemovalReason `json:"reason,omitempty"`
+}
+
+const (
+	// keyEventBufferSize is the per-subscriber queue length; slow subscribers
+	// lose events rather than blocking writers
+	keyEventBufferSize = 256
+
+	// keyEventHistorySize is how many recent events are kept for resumption
+	keyEventHistorySize = 4096
+)
+
+// ErrEventsUnavailable is returned when a subscription asks to resume from
+// a sequence number that has already left the event history
+var ErrEventsUnavailable = errors.New("requested events are no longer available")
+
+// KeyEventSubscription delivers keyspace events for keys matching a prefix
+type KeyEventSubscription struct {
+	C <-chan KeyEvent
+
+	ch       chan KeyEvent
+	prefix   string
+	dropped  int64
+	notifier *keyspaceNotifier
+	once     sync.Once
+}
+
+// Dropped returns how many events were discarded because the subscriber fell behind
+func (s *KeyEventSubscription) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Close stops delivery and releases the subscription
+func (s *KeyEventSubscription) Close() {
+	s.once.Do(func() {
+		s.notifier.unsubscribe(s)
+	})
+}
+
+// keyspaceNotifier fans keyspace events out to subscribers and keeps a
+// ring buffer of recent events so subscribers can resume
+type keyspaceNotifier struct {
+	mu          sync.Mutex
+	subscribers map[*KeyEventSubscription]struct{}
+	count       int32
+	seq         uint64
+	history     []KeyEvent
+	historyNext int
+}
+
+func newKeyspaceNotifier() *keyspaceNotifier {
+	return &keyspaceNotifier{
+		subscribers: make(map[*KeyEventSubscription]struct{}),
+		history:     make([]KeyEvent, 0, keyEventHistorySize),
+	}
+}
+
+// subscribe registers a subscriber. When resume is set, events after the
+// given sequence number that are still in the history are replayed first.
+func (n *keyspaceNotifier) subscribe(prefix string, after uint64, resume bool) (*KeyEventSubscription, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	var replay []KeyEvent
+	if resume && after < n.seq {
+		oldest := n.oldestSeq()
+		if after+1 < oldest {
+			return nil, ErrEventsUnavailable
+		}
+		n.forEachHistory(func(event KeyEvent) {
+			if event.Seq > after && matchesEventPrefix(event, prefix) {
+				replay = append(replay, event)
+			}
+		})
+	}
+
+	ch := make(chan KeyEvent, len(replay)+keyEventBufferSize)
+	for _, event := range replay {
+		ch <- event
+	}
+
+	sub := &KeyEventSubscription{
+		C:        ch,
+		ch:       ch,
+		prefix:   prefix,
+		notifier: n,
+	}
+	n.subscribers[sub] = struct{}{}
+	atomic.AddInt32(&n.count, 1)
+	return sub, nil
+}
+
+// position returns the sequence number of the latest event
+func (n *keyspaceNotifier) position() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.seq
+}
+
+// oldestSeq returns the sequence number of the oldest retained event
+func (n *keyspaceNotifier) oldestSeq() uint64 {
+	if len(n.history) < keyEventHistorySize {
+		return n.seq - uint64(len(n.history)) + 1
+	}
+	return n.history[n.historyNext].Seq
+}
+
+// forEachHistory visits retained events from oldest to newest
+func (n *keyspaceNotifier) forEachHistory(fn func(KeyEvent)) {
+	if len(n.history) < keyEventHistorySize {
+		for _, event := range n.history {
+			fn(event)
+		}
+		return
+	}
+	for i := 0; i < keyEventHistorySize; i++ {
+		fn(n.history[(n.historyNext+i)%keyEventHistorySize])
+	}
+}
+
+func matchesEventPrefix(event KeyEvent, prefix string) bool {
+	return event.Type == KeyEventFlush || strings.HasPrefix(event.Key, prefix)
+}
+
+func (n *keyspaceNotifier) unsubscribe(sub *KeyEventSubscription) {
+	n.mu.Lock()
+	delete(n.subscribers, sub)
+	atomic.AddInt32(&n.count, -1)
+	close(sub.ch)
+	n.mu.Unlock()
+}
+
+// publish records a change to key
+func (n *keyspaceNotifier) publish(eventType KeyEventType, key string) {
+	n.emit(KeyEvent{Type: eventType, Key: key})
+}
+
+// publishRemoval records a key leaving the cache along with the reason
+func (n *keyspaceNotifier) publishRemoval(key string, reason RemovalReason) {
+	n.emit(KeyEvent{Type: reason.eventType(), Key: key, Reason: reason})
+}
+
+// emit records an event and delivers it to every matching subscriber
+// without blocking. Flush events match every prefix.
+func (n *keyspaceNotifier) emit(event KeyEvent) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.seq++
+	event.Seq = n.seq
+	event.Time = time.Now()
+	if len(n.history) < keyEventHistorySize {
+		n.history = append(n.history, event)
+	} else {
+		n.history[n.historyNext] = event
+		n.historyNext = (n.historyNext + 1) % keyEventHistorySize
+	}
+
+	if atomic.LoadInt32(&n.count) == 0 {
+		return
+	}
+	for sub := range n.subscribers {
+		if !matchesEventPrefix(event, sub.prefix) {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+			atomic.AddInt64(&sub.dropped, 1)
+		}
+	}
+}
+
+// Subscribe returns a subscription receiving changes to keys starting with prefix
+func (c *Cache) Subscribe(prefix string) *KeyEventSubscription {
+	sub, _ := c.notifier.subscribe(prefix, 0, false)
+	return sub
+}
+
+// SubscribeFrom is like Subscribe but first replays retained events with a
+// sequence number greater than after. It returns ErrEventsUnavailable if
+// some of those events have already been discarded.
+func (c *Cache) SubscribeFrom(prefix string, after uint64) (*KeyEventSubscription, error) {
+	return c.notifier.subscribe(prefix, after, true)
+}
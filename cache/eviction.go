@@ -0,0 +1,124 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+)
+
+// Eviction policy names accepted in Config.EvictionPolicy
+const (
+	EvictionLRU         = "lru"
+	EvictionWTinyLFU    = "w-tinylfu"
+	EvictionSampledLRU  = "sampled-lru"
+	EvictionClock       = "clock"
+	EvictionLFU         = "lfu"
+	EvictionARC         = "arc"
+	EvictionRandom      = "random"
+	EvictionVolatileTTL = "volatile-ttl"
+)
+
+// EvictionPolicies lists every eviction policy name
+var EvictionPolicies = []string{
+	EvictionLRU, EvictionWTinyLFU, EvictionSampledLRU, EvictionClock,
+	EvictionLFU, EvictionARC, EvictionRandom, EvictionVolatileTTL,
+}
+
+// evictionPolicy decides which entry leaves the cache when it is over
+// capacity. Each shard has its own. Pinned entries are never handed to the
+// policy. All methods are called with the shard's write lock held.
+type evictionPolicy interface {
+	// Add records a newly inserted entry
+	Add(entry *CacheEntry)
+	// Access records a read or update of an entry
+	Access(entry *CacheEntry)
+	// Remove forgets an entry leaving the cache for any reason
+	Remove(entry *CacheEntry)
+	// Victim picks the next entry to evict, or nil if there is none. The
+	// caller removes it from the cache, which calls Remove.
+	Victim() *CacheEntry
+	// Len returns the number of tracked entries
+	Len() int
+	// Reset forgets every entry
+	Reset()
+}
+
+// newEvictionPolicy creates the policy selected by cfg.EvictionPolicy
+func newEvictionPolicy(cfg Config) (evictionPolicy, error) {
+	switch strings.ToLower(cfg.EvictionPolicy) {
+	case "", EvictionLRU:
+		return newLRUPolicy(), nil
+	case EvictionWTinyLFU:
+		return newWTinyLFUPolicy(cfg.MaxKeys), nil
+	case EvictionSampledLRU:
+		return newSampledLRUPolicy(cfg.EvictionSamples), nil
+	case EvictionClock:
+		return newClockPolicy(), nil
+	case EvictionLFU:
+		return newLFUPolicy(), nil
+	case EvictionARC:
+		return newARCPolicy(cfg.MaxKeys), nil
+	case EvictionRandom:
+		// Sampled LRU drawing a single entry picks one at random
+		return newSampledLRUPolicy(1), nil
+	case EvictionVolatileTTL:
+		return newVolatileTTLPolicy(cfg.EvictionSamples), nil
+	default:
+		return nil, fmt.Errorf("unknown eviction policy: %s", cfg.EvictionPolicy)
+	}
+}
+
+// EvictionPolicy returns the name of the cache's eviction policy
+func (c *Cache) EvictionPolicy() string {
+	return c.policyName
+}
+
+// Evictions returns how many entries have been evicted, by reason
+func (c *Cache) Evictions() map[RemovalReason]int64 {
+	counts := make(map[RemovalReason]int64)
+	for _, s := range c.shards {
+		s.mutex.RLock()
+		for reason, n := range s.evictions {
+			counts[reason] += n
+		}
+		s.mutex.RUnlock()
+	}
+	return counts
+}
+
+// lruPolicy evicts the least recently used entry
+type lruPolicy struct {
+	list *list.List
+}
+
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{list: list.New()}
+}
+
+func (p *lruPolicy) Add(entry *CacheEntry) {
+	entry.element = p.list.PushFront(entry)
+}
+
+func (p *lruPolicy) Access(entry *CacheEntry) {
+	p.list.MoveToFront(entry.element)
+}
+
+func (p *lruPolicy) Remove(entry *CacheEntry) {
+	p.list.Remove(entry.element)
+	entry.element = nil
+}
+
+func (p *lruPolicy) Victim() *CacheEntry {
+	if back := p.list.Back(); back != nil {
+		return back.Value.(*CacheEntry)
+	}
+	return nil
+}
+
+func (p *lruPolicy) Len() int {
+	return p.list.Len()
+}
+
+func (p *lruPolicy) Reset() {
+	p.list.Init()
+}
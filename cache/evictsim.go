@@ -0,0 +1,255 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultAccessTraceSize is how many accesses the trace keeps when its
+// size is not configured
+const defaultAccessTraceSize = 100000
+
+// simulationMaxRuns bounds the policy and size combinations of one request
+const simulationMaxRuns = 64
+
+// tracedAccess is one read or write in the access trace. Reads that missed
+// have no size.
+type tracedAccess struct {
+	key   string
+	size  int64
+	write bool
+}
+
+// accessTrace is a ring buffer of sampled accesses. Keys rather than
+// accesses are sampled: every access to a key is traced if its hash falls
+// under the rate.
+type accessTrace struct {
+	threshold uint64
+	rate      float64
+
+	mu      sync.Mutex
+	ring    []tracedAccess
+	next    int
+	full    bool
+	started time.Time
+}
+
+// newAccessTrace traces the keys sampled at rate into a ring of size
+// accesses
+func newAccessTrace(rate float64, size int) *accessTrace {
+	if size <= 0 {
+		size = defaultAccessTraceSize
+	}
+	threshold := uint64(math.MaxUint64)
+	if rate < 1 {
+		threshold = uint64(rate * math.MaxUint64)
+	}
+	return &accessTrace{threshold: threshold, rate: rate, ring: make([]tracedAccess, size), started: time.Now()}
+}
+
+// sampled reports whether key is traced
+func (t *accessTrace) sampled(key string) bool {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64() <= t.threshold
+}
+
+// record adds an access of a sampled key
+func (t *accessTrace) record(key string, size int64, write bool) {
+	if !t.sampled(key) {
+		return
+	}
+	t.mu.Lock()
+	t.ring[t.next] = tracedAccess{key: key, size: size, write: write}
+	if t.next++; t.next == len(t.ring) {
+		t.next, t.full = 0, true
+	}
+	t.mu.Unlock()
+}
+
+// accesses copies the trace, oldest access first
+func (t *accessTrace) accesses() []tracedAccess {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.full {
+		return append([]tracedAccess(nil), t.ring[:t.next]...)
+	}
+	out := make([]tracedAccess, 0, len(t.ring))
+	out = append(out, t.ring[t.next:]...)
+	return append(out, t.ring[:t.next]...)
+}
+
+// SetAccessTrace starts tracing the keys sampled at rate into a ring of
+// size accesses for the eviction simulator, replacing any trace so far. A
+// rate of zero stops tracing.
+func (c *Cache) SetAccessTrace(rate float64, size int) error {
+	if rate < 0 || rate > 1 {
+		return errors.New("access trace sample rate must be between 0 and 1")
+	}
+	var trace *accessTrace
+	if rate > 0 {
+		trace = newAccessTrace(rate, size)
+	}
+
+	c.trace.Store(trace)
+	return nil
+}
+
+// traceAccess records an access in the trace, if there is one
+func (c *Cache) traceAccess(key string, size int64, write bool) {
+	if trace := c.trace.Load(); trace != nil {
+		trace.record(key, size, write)
+	}
+}
+
+// SimulationRequest lists the policies and sizes to simulate. Every policy
+// is simulated at every size. Without policies every policy but
+// volatile-ttl is simulated, and without sizes the cache's own limits are
+// used.
+type SimulationRequest struct {
+	Policies []string `json:"policies"`
+	// MaxMemory lists memory limits in bytes, zero for none
+	MaxMemory []int64 `json:"max_memory"`
+	// MaxKeys is the key limit of every run, the cache's own when zero
+	MaxKeys int `json:"max_keys"`
+}
+
+// SimulationResult is the outcome of replaying the trace through one
+// policy at one size
+type SimulationResult struct {
+	Policy    string  `json:"policy"`
+	MaxMemory int64   `json:"max_memory"`
+	MaxKeys   int     `json:"max_keys"`
+	Reads     int     `json:"reads"`
+	Hits      int     `json:"hits"`
+	HitRate   float64 `json:"hit_rate"`
+	Evictions int     `json:"evictions"`
+}
+
+// SimulationReport describes the trace replayed and the result of each run
+type SimulationReport struct {
+	SampleRate float64            `json:"sample_rate"`
+	Accesses   int                `json:"accesses"`
+	Since      time.Time          `json:"since"`
+	Results    []SimulationResult `json:"results"`
+}
+
+// ErrNoAccessTrace is returned when simulating without a trace
+var ErrNoAccessTrace = errors.New("access tracing is not enabled")
+
+// SimulateEviction replays the access trace through each policy at each
+// size of req, scaled down by the sample rate
+func (c *Cache) SimulateEviction(req SimulationRequest) (SimulationReport, error) {
+	trace, maxKeys, maxCost := c.trace.Load(), c.maxSize, c.maxCost
+	if trace == nil {
+		return SimulationReport{}, ErrNoAccessTrace
+	}
+
+	policies := req.Policies
+	if len(policies) == 0 {
+		policies = slices.DeleteFunc(slices.Clone(EvictionPolicies), func(name string) bool { return name == EvictionVolatileTTL })
+	}
+	for _, name := range policies {
+		if strings.EqualFold(name, EvictionVolatileTTL) {
+			return SimulationReport{}, errors.New("volatile-ttl cannot be simulated, the access trace has no TTLs")
+		}
+	}
+	sizes := req.MaxMemory
+	if len(sizes) == 0 {
+		sizes = []int64{maxCost}
+	}
+	if req.MaxKeys > 0 {
+		maxKeys = req.MaxKeys
+	}
+	if len(policies)*len(sizes) > simulationMaxRuns {
+		return SimulationReport{}, fmt.Errorf("at most %d policy and size combinations can be simulated", simulationMaxRuns)
+	}
+	for _, size := range sizes {
+		if size < 0 {
+			return SimulationReport{}, errors.New("memory limits cannot be negative")
+		}
+	}
+
+	accesses := trace.accesses()
+	trace.mu.Lock()
+	since := trace.started
+	trace.mu.Unlock()
+	report := SimulationReport{SampleRate: trace.rate, Accesses: len(accesses), Since: since}
+	for _, name := range policies {
+		for _, size := range sizes {
+			result, err := simulatePolicy(accesses, trace.rate, name, size, maxKeys)
+			if err != nil {
+				return SimulationReport{}, err
+			}
+			report.Results = append(report.Results, result)
+		}
+	}
+	return report, nil
+}
+
+// simulatePolicy replays accesses through a cache with the named policy,
+// scaled down by the trace's sample rate. A read of a key the simulated
+// cache lacks is a miss, after which the key is filled as a client reading
+// through would; writes store their key without counting as reads.
+func simulatePolicy(accesses []tracedAccess, rate float64, name string, maxCost int64, maxKeys int) (SimulationResult, error) {
+	scaledKeys := max(1, int(float64(maxKeys)*rate))
+	scaledCost := int64(float64(maxCost) * rate)
+	policy, err := newEvictionPolicy(Config{EvictionPolicy: name, MaxKeys: scaledKeys})
+	if err != nil {
+		return SimulationResult{}, err
+	}
+	result := SimulationResult{Policy: strings.ToLower(name), MaxMemory: maxCost, MaxKeys: maxKeys}
+
+	entries := make(map[string]*CacheEntry)
+	var cost int64
+	for i, access := range accesses {
+		// Sampled LRU compares access times, so replay on a clock of its own
+		now := time.Unix(0, int64(i))
+		entry, exists := entries[access.key]
+		if !access.write {
+			result.Reads++
+			if exists {
+				result.Hits++
+				entry.LastAccessed = now
+				policy.Access(entry)
+				continue
+			}
+			if access.size == 0 {
+				continue
+			}
+		}
+
+		if exists {
+			cost += access.size - entry.memory
+			entry.memory = access.size
+			entry.LastAccessed = now
+			policy.Access(entry)
+		} else {
+			entry = &CacheEntry{Key: access.key, memory: access.size, LastAccessed: now}
+			entries[access.key] = entry
+			cost += access.size
+			policy.Add(entry)
+		}
+		for len(entries) > scaledKeys || (scaledCost > 0 && cost > scaledCost) {
+			victim := policy.Victim()
+			if victim == nil {
+				break
+			}
+			policy.Remove(victim)
+			delete(entries, victim.Key)
+			cost -= victim.memory
+			result.Evictions++
+		}
+	}
+	if result.Reads > 0 {
+		result.HitRate = float64(result.Hits) / float64(result.Reads)
+	}
+	return result, nil
+}
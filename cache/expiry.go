@@ -0,0 +1,131 @@
+package cache
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expirationIndex is a min-heap of entries with a TTL ordered by expiryAt.
+// expiryAt may lag behind ExpiresAt when a TTL is extended, as sliding
+// expiration does on every read; such entries are re-queued when they reach
+// the top instead of being fixed up on each access.
+type expirationIndex []*CacheEntry
+
+func (h expirationIndex) Len() int           { return len(h) }
+func (h expirationIndex) Less(i, j int) bool { return h[i].expiryAt.Before(h[j].expiryAt) }
+func (h expirationIndex) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].expiryPos = i + 1
+	h[j].expiryPos = j + 1
+}
+func (h *expirationIndex) Push(x interface{}) {
+	entry := x.(*CacheEntry)
+	*h = append(*h, entry)
+	entry.expiryPos = len(*h)
+}
+func (h *expirationIndex) Pop() interface{} {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	entry.expiryPos = 0
+	return entry
+}
+
+// trackExpiry adds a new entry with a TTL to the shard's expiration index
+func (s *cacheShard) trackExpiry(entry *CacheEntry) {
+	if entry.ExpiresAt == nil {
+		return
+	}
+	entry.expiryAt = *entry.ExpiresAt
+	heap.Push(&s.expirations, entry)
+}
+
+// untrackExpiry removes an entry from the shard's expiration index
+func (s *cacheShard) untrackExpiry(entry *CacheEntry) {
+	if entry.expiryPos > 0 {
+		heap.Remove(&s.expirations, entry.expiryPos-1)
+	}
+}
+
+// setExpiry changes the expiration time of a stored entry; nil removes the
+// TTL
+func (s *cacheShard) setExpiry(entry *CacheEntry, at *time.Time) {
+	entry.ExpiresAt = at
+	s.markDirty(entry.Key)
+	switch {
+	case at == nil:
+		s.untrackExpiry(entry)
+	case entry.expiryPos == 0:
+		s.trackExpiry(entry)
+	case at.Before(entry.expiryAt):
+		// Only earlier deadlines need the heap fixed now; later ones are
+		// picked up lazily by Cleanup
+		entry.expiryAt = *at
+		heap.Fix(&s.expirations, entry.expiryPos-1)
+	}
+}
+
+// ExpireAt sets key to expire at the given time, ending any sliding
+// expiration. It reports false if the key does not exist.
+func (c *Cache) ExpireAt(key string, at time.Time) bool {
+	s := c.shardFor(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := s.lookupLive(key)
+	if entry == nil {
+		return false
+	}
+	entry.SlidingTTL = 0
+	s.setExpiry(entry, &at)
+	s.recharge(entry)
+	return true
+}
+
+// SetClock makes the cache read the time from now when it sets and checks
+// expiries, so tests can expire keys without waiting. It must be called
+// before the cache is used.
+func (c *Cache) SetClock(now func() time.Time) {
+	c.now = now
+}
+
+// refreshSliding extends the TTL of an entry with sliding expiration by its
+// original duration. Callers hold the write lock of its shard.
+func (c *Cache) refreshSliding(entry *CacheEntry, now time.Time) {
+	if entry.SlidingTTL <= 0 || entry.ExpiresAt == nil {
+		return
+	}
+	expiresAt := now.Add(entry.SlidingTTL)
+	entry.ExpiresAt = &expiresAt
+}
+
+// slidingFor reports whether writes to key should use sliding expiration
+func (c *Cache) slidingFor(key string, requested bool) bool {
+	if requested {
+		return true
+	}
+	opts, ok := c.namespaceOptions(NamespaceOf(key))
+	return ok && opts.SlidingExpiration
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "PEXPIREAT", Arity: 3, Flags: FlagWrite, Handler: pexpireAtCommand, KeyArgs: firstKeyArg},
+	)
+}
+
+// pexpireAtCommand implements PEXPIREAT key unix-ms, replying 1 if the key
+// was found. The AOF rewrite uses it for the expiry of typed objects.
+func pexpireAtCommand(ctx *CommandContext) error {
+	ms, err := ParseInt(ctx.Args[2])
+	if err != nil {
+		return err
+	}
+	if ctx.Cache.ExpireAt(string(ctx.Args[1]), time.UnixMilli(ms)) {
+		ctx.Out.WriteInteger(1)
+	} else {
+		ctx.Out.WriteInteger(0)
+	}
+	return nil
+}
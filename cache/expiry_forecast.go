@@ -0,0 +1,127 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// expiryForecastWindows are the horizons an expiry forecast counts keys
+// expiring within
+var expiryForecastWindows = []time.Duration{time.Minute, 5 * time.Minute, time.Hour, 24 * time.Hour}
+
+// ExpiryWindow counts the keys, and the bytes they hold, expiring within a
+// horizon from now. Windows are cumulative: the hour includes the minute.
+type ExpiryWindow struct {
+	Window string `json:"window"`
+	Keys   int    `json:"keys"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// ExpiryForecast reports when keys with a TTL will expire, so operators can
+// anticipate the misses, and the origin load, that follow
+type ExpiryForecast struct {
+	Windows []ExpiryWindow `json:"windows"`
+	// Volatile keys have a TTL and Persistent ones do not
+	Volatile   int `json:"volatile"`
+	Persistent int `json:"persistent"`
+	// Expired keys are past their TTL but not yet removed; they count in
+	// every window
+	Expired int `json:"expired"`
+	// Sliding keys expire later than forecast if they are read in time
+	Sliding int `json:"sliding"`
+}
+
+// ExpiryForecast counts the keys starting with prefix by when they expire,
+// from the expiration index
+func (c *Cache) ExpiryForecast(prefix string) ExpiryForecast {
+	c.rlockAll()
+	defer c.runlockAll()
+
+	now := c.now()
+	forecast := ExpiryForecast{Windows: make([]ExpiryWindow, len(expiryForecastWindows))}
+	for i, window := range expiryForecastWindows {
+		forecast.Windows[i].Window = formatWindow(window)
+	}
+	for _, s := range c.shards {
+		for _, entry := range s.expirations {
+			if !strings.HasPrefix(entry.Key, prefix) || entry.ExpiresAt == nil {
+				continue
+			}
+			forecast.Volatile++
+			if entry.SlidingTTL > 0 {
+				forecast.Sliding++
+			}
+			left := entry.ExpiresAt.Sub(now)
+			if left <= 0 {
+				forecast.Expired++
+			}
+			for i, window := range expiryForecastWindows {
+				if left <= window {
+					forecast.Windows[i].Keys++
+					forecast.Windows[i].Bytes += entry.memory
+				}
+			}
+		}
+	}
+
+	keys := int(c.currentSize.Load())
+	if prefix != "" {
+		keys = 0
+		c.walkPrefix(prefix, func(string) bool {
+			keys++
+			return true
+		})
+	}
+	forecast.Persistent = keys - forecast.Volatile
+	return forecast
+}
+
+// formatWindow renders a forecast horizon as "5m" or "24h"
+func formatWindow(d time.Duration) string {
+	if d%time.Hour == 0 {
+		return fmt.Sprintf("%dh", d/time.Hour)
+	}
+	return fmt.Sprintf("%dm", d/time.Minute)
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "EXPIRYFORECAST", Arity: -1, Flags: FlagReadOnly | FlagAdmin, Handler: expiryForecastCommand},
+	)
+}
+
+// expiryForecastCommand implements EXPIRYFORECAST [PREFIX prefix], replying
+// with field/value pairs: the key counts, then the keys and bytes expiring
+// within each window
+func expiryForecastCommand(ctx *CommandContext) error {
+	prefix := ""
+	switch len(ctx.Args) {
+	case 1:
+	case 3:
+		if !strings.EqualFold(string(ctx.Args[1]), "PREFIX") {
+			return ErrSyntax
+		}
+		prefix = string(ctx.Args[2])
+	default:
+		return ErrSyntax
+	}
+
+	forecast := ctx.Cache.ExpiryForecast(prefix)
+	ctx.Out.WriteArrayHeader(8 + 4*len(forecast.Windows))
+	ctx.Out.WriteBulkString("volatile")
+	ctx.Out.WriteInteger(int64(forecast.Volatile))
+	ctx.Out.WriteBulkString("persistent")
+	ctx.Out.WriteInteger(int64(forecast.Persistent))
+	ctx.Out.WriteBulkString("expired")
+	ctx.Out.WriteInteger(int64(forecast.Expired))
+	ctx.Out.WriteBulkString("sliding")
+	ctx.Out.WriteInteger(int64(forecast.Sliding))
+	for _, window := range forecast.Windows {
+		ctx.Out.WriteBulkString("keys_" + window.Window)
+		ctx.Out.WriteInteger(int64(window.Keys))
+		ctx.Out.WriteBulkString("bytes_" + window.Window)
+		ctx.Out.WriteInteger(window.Bytes)
+	}
+	return nil
+}
@@ -0,0 +1,445 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// An export streams the live keys starting with a prefix, with their TTLs
+// and metadata, as NDJSON or CSV. Keys come in namespace order, so an
+// interrupted export resumes from the last key it wrote. Values of types
+// other than strings are listed without their value. Values that are not
+// valid UTF-8 are base64 encoded.
+
+// Export formats
+const (
+	ExportNDJSON = "ndjson"
+	ExportCSV    = "csv"
+)
+
+// exportBatch is how many keys are read per hold of the shards' read locks
+const exportBatch = 256
+
+// ExportCSVHeader names the columns of a CSV export
+var ExportCSVHeader = []string{"key", "type", "value", "encoding", "ttl_ms", "tags", "pinned", "size", "access_count", "created_at", "last_accessed"}
+
+// ExportRecord is one key of an export
+type ExportRecord struct {
+	Key   string `json:"key"`
+	Type  string `json:"type"`
+	Value string `json:"value,omitempty"`
+	// Encoding is "base64" for a value that is not valid UTF-8
+	Encoding string `json:"encoding,omitempty"`
+	// TTLMillis is the time left to live, or -1 for none
+	TTLMillis    int64     `json:"ttl_ms"`
+	Tags         []string  `json:"tags,omitempty"`
+	Pinned       bool      `json:"pinned,omitempty"`
+	Size         int64     `json:"size"`
+	AccessCount  int64     `json:"access_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastAccessed time.Time `json:"last_accessed"`
+}
+
+// ExportOptions selects the keys of an export and paces it
+type ExportOptions struct {
+	Prefix string
+	// After resumes an export after the last key it wrote
+	After string
+	// Rate caps the keys written per second; zero means no limit
+	Rate int
+	// Consistent reads every record when the export starts, so the export
+	// is a point-in-time view of the keyspace however long it takes to
+	// write, at the cost of holding all the records in memory
+	Consistent bool
+}
+
+// ParseExportFormat checks an export format name, defaulting to NDJSON
+func ParseExportFormat(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "", ExportNDJSON, "json":
+		return ExportNDJSON, nil
+	case ExportCSV:
+		return ExportCSV, nil
+	default:
+		return "", fmt.Errorf("unknown export format %q", name)
+	}
+}
+
+// Export calls emit with the records of the keys opts selects, in batches
+// paced to opts.Rate. Keys are listed when the export starts; those
+// removed before their batch is read are left out, unless opts.Consistent
+// has every record read when the export starts. It stops with ctx's error
+// once ctx is done, or with emit's error.
+func (c *Cache) Export(ctx context.Context, opts ExportOptions, emit func([]ExportRecord) error) error {
+	var keys []string
+	var snapshot []ExportRecord
+	var err error
+	if opts.Consistent {
+		snapshot, err = c.exportSnapshot(ctx, opts)
+	} else {
+		keys, err = c.exportKeys(ctx, opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	start, written, left := time.Now(), 0, len(keys)+len(snapshot)
+	for left > 0 {
+		n := exportBatch
+		if opts.Rate > 0 && n > opts.Rate {
+			n = opts.Rate
+		}
+		if n > left {
+			n = left
+		}
+		if opts.Rate > 0 {
+			due := start.Add(time.Duration(written) * time.Second / time.Duration(opts.Rate))
+			if err := sleepContext(ctx, time.Until(due)); err != nil {
+				return err
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var records []ExportRecord
+		if opts.Consistent {
+			records, snapshot = snapshot[:n], snapshot[n:]
+		} else {
+			records, keys = c.exportRecords(keys[:n]), keys[n:]
+		}
+		if err := emit(records); err != nil {
+			return err
+		}
+		written, left = written+n, left-n
+	}
+	return nil
+}
+
+// exportKeys lists the keys of an export
+func (c *Cache) exportKeys(ctx context.Context, opts ExportOptions) ([]string, error) {
+	c.rlockAll()
+	defer c.runlockAll()
+
+	keys := make([]string, 0)
+	err := c.walkExport(ctx, opts, func(key string) {
+		keys = append(keys, key)
+	})
+	return keys, err
+}
+
+// exportSnapshot reads the records of an export under one hold of every
+// shard's read lock, so they show the keyspace at a single point in time.
+// Values are shared rather than copied, as writes replace them instead of
+// changing them in place.
+func (c *Cache) exportSnapshot(ctx context.Context, opts ExportOptions) ([]ExportRecord, error) {
+	c.rlockAll()
+	defer c.runlockAll()
+
+	now := c.now()
+	records := make([]ExportRecord, 0)
+	err := c.walkExport(ctx, opts, func(key string) {
+		if entry := c.storedEntry(key); entry != nil && !entry.isExpired(now) {
+			records = append(records, exportRecord(entry, now))
+		}
+	})
+	return records, err
+}
+
+// walkExport calls fn with each key of an export in order. Callers hold
+// every shard's read lock.
+func (c *Cache) walkExport(ctx context.Context, opts ExportOptions, fn func(key string)) error {
+	visited := 0
+	var err error
+	c.walkPrefix(opts.Prefix, func(key string) bool {
+		if visited++; visited%deadlineCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		if opts.After == "" || keyOrderLess(opts.After, key) {
+			fn(key)
+		}
+		return true
+	})
+	return err
+}
+
+// exportRecords reads the records of the keys still live
+func (c *Cache) exportRecords(keys []string) []ExportRecord {
+	defer runlockShards(c.rlockKeys(keys...))
+
+	now := c.now()
+	records := make([]ExportRecord, 0, len(keys))
+	for _, key := range keys {
+		entry := c.storedEntry(key)
+		if entry == nil || entry.isExpired(now) {
+			continue
+		}
+		records = append(records, exportRecord(entry, now))
+	}
+	return records
+}
+
+// exportRecord describes entry as of now. Callers hold its shard's read
+// lock.
+func exportRecord(entry *CacheEntry, now time.Time) ExportRecord {
+	rec := ExportRecord{
+		Key:          entry.Key,
+		Type:         "string",
+		TTLMillis:    -1,
+		Tags:         entry.Tags,
+		Pinned:       entry.Pinned,
+		Size:         entry.memory,
+		AccessCount:  entry.AccessCount,
+		CreatedAt:    entry.CreatedAt,
+		LastAccessed: entry.LastAccessed,
+	}
+	if entry.ExpiresAt != nil {
+		rec.TTLMillis = entry.ExpiresAt.Sub(now).Milliseconds()
+	}
+	switch {
+	case entry.Object != nil:
+		rec.Type = entry.Object.TypeName()
+	case utf8.Valid(entry.Value):
+		rec.Value = string(entry.Value)
+	default:
+		rec.Value = base64.StdEncoding.EncodeToString(entry.Value)
+		rec.Encoding = "base64"
+	}
+	return rec
+}
+
+// CSVRow renders the record as a CSV row under ExportCSVHeader
+func (rec ExportRecord) CSVRow() []string {
+	return []string{
+		rec.Key,
+		rec.Type,
+		rec.Value,
+		rec.Encoding,
+		strconv.FormatInt(rec.TTLMillis, 10),
+		strings.Join(rec.Tags, ";"),
+		strconv.FormatBool(rec.Pinned),
+		strconv.FormatInt(rec.Size, 10),
+		strconv.FormatInt(rec.AccessCount, 10),
+		rec.CreatedAt.UTC().Format(time.RFC3339Nano),
+		rec.LastAccessed.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// sleepContext waits for d, returning early with ctx's error once ctx is
+// done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// RunExport implements the export subcommand, which streams a node's
+// keyspace from its HTTP API into a file. With -resume an interrupted
+// export carries on after the last complete record in the file.
+func RunExport(args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	api := flags.String("url", "http://127.0.0.1:8081", "Base URL of the node's HTTP API")
+	token := flags.String("token", os.Getenv("CACHE_TOKEN"), "Access token, if the API requires a session")
+	prefix := flags.String("prefix", "", "Only export keys starting with this prefix")
+	format := flags.String("format", ExportNDJSON, "Output format: ndjson or csv")
+	out := flags.String("out", "", "Output file, standard output when empty")
+	rate := flags.Int("rate", 0, "Maximum keys exported per second, 0 for no limit")
+	resume := flags.Bool("resume", false, "Continue an interrupted export into -out")
+	consistent := flags.Bool("consistent", false, "Export a point-in-time view of the keyspace")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: export [options]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	exportFormat, err := ParseExportFormat(*format)
+	if err != nil || flags.NArg() != 0 || *rate < 0 || (*resume && *out == "") {
+		flags.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	w := io.Writer(os.Stdout)
+	after := ""
+	if *out != "" {
+		file, err := openExportFile(*out, exportFormat, *resume)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot open %s: %v\n", *out, err)
+			return 1
+		}
+		defer file.Close()
+		w, after = file, file.after
+		if after != "" {
+			fmt.Fprintf(os.Stderr, "Resuming after %q\n", after)
+		}
+	}
+
+	query := url.Values{"format": {exportFormat}, "prefix": {*prefix}}
+	if after != "" {
+		query.Set("after", after)
+	}
+	if *rate > 0 {
+		query.Set("rate", strconv.Itoa(*rate))
+	}
+	if *consistent {
+		query.Set("consistent", "true")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(*api, "/")+"/api/v1/export?"+query.Encode(), nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid URL: %v\n", err)
+		return 2
+	}
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export failed: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		fmt.Fprintf(os.Stderr, "Export failed: %s: %s\n", resp.Status, strings.TrimSpace(string(body)))
+		return 1
+	}
+
+	start := time.Now()
+	n, err := io.Copy(w, resp.Body)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Export interrupted after %d bytes: %v\n", n, err)
+		if *out != "" {
+			fmt.Fprintln(os.Stderr, "Run again with -resume to continue")
+		}
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d bytes in %v\n", n, time.Since(start).Round(time.Millisecond))
+	return 0
+}
+
+// exportFile is an export's output file, with the last key already in it
+// when resuming
+type exportFile struct {
+	*os.File
+	after string
+}
+
+// openExportFile creates path, or when resuming opens it for appending
+// after cutting off a record left incomplete by the interruption
+func openExportFile(path, format string, resume bool) (*exportFile, error) {
+	if !resume {
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		return &exportFile{File: file}, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	after, end, err := lastExportedKey(file, format)
+	if err == nil {
+		err = file.Truncate(end)
+	}
+	if err == nil {
+		_, err = file.Seek(end, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &exportFile{File: file, after: after}, nil
+}
+
+// lastExportedKey returns the key of the last complete record of an
+// export and the offset just past it
+func lastExportedKey(file *os.File, format string) (string, int64, error) {
+	if format == ExportCSV {
+		return lastCSVKey(file)
+	}
+
+	br := bufio.NewReader(file)
+	var key string
+	var end int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			return key, end, nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		var rec ExportRecord
+		if json.Unmarshal(line, &rec) != nil {
+			return key, end, nil
+		}
+		key, end = rec.Key, end+int64(len(line))
+	}
+}
+
+// lastCSVKey is lastExportedKey for CSV. A header with no rows under it
+// counts as incomplete, so the export writes it again.
+func lastCSVKey(file *os.File) (string, int64, error) {
+	cr := csv.NewReader(file)
+	cr.FieldsPerRecord = len(ExportCSVHeader)
+	var key, prevKey string
+	var end, prevEnd int64
+	for {
+		row, err := cr.Read()
+		var parseErr *csv.ParseError
+		if err == io.EOF || errors.As(err, &parseErr) {
+			break
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		if key == "" && row[0] == ExportCSVHeader[0] {
+			continue
+		}
+		prevKey, prevEnd = key, end
+		key, end = row[0], cr.InputOffset()
+	}
+
+	// A last row running to the end of the file without a newline may
+	// have been cut off
+	if end > 0 {
+		var last [1]byte
+		if _, err := file.ReadAt(last[:], end-1); err != nil {
+			return "", 0, err
+		}
+		if last[0] != '\n' {
+			return prevKey, prevEnd, nil
+		}
+	}
+	return key, end, nil
+}
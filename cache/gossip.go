@@ -0,0 +1,164 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// Members find each other by gossip, as in SWIM. Every GossipInterval a
+// node bumps its heartbeat and swaps member lists with one random peer
+// through NODE.GOSSIP, each side adding the members it did not know of, so
+// a node that joins through a single seed is known to the whole cluster,
+// and knows it, within a few rounds. Heartbeats spread the same way and
+// stand in for SWIM's indirect probes: a peer this node cannot probe stays
+// suspect while others relay newer heartbeats from it, and is only
+// declared offline once none has arrived for SuspicionMult probe
+// intervals.
+
+// errClusterDisabled is returned by cluster commands on a node without one
+var errClusterDisabled = errors.New("ERR clustering is not enabled")
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "NODE.GOSSIP", Arity: 2, Flags: FlagInternal, Handler: nodeGossipCommand},
+	)
+}
+
+// gossipRound bumps this node's heartbeat and gossips with a random peer.
+// Peers that cannot be reached are left to the probes.
+func (cl *Cluster) gossipRound(ctx context.Context) {
+	cl.mu.Lock()
+	cl.self.Heartbeat++
+	msg := cl.gossipMessageLocked()
+	addr := cl.gossipTargetLocked()
+	c := cl.clients[addr]
+	cl.mu.Unlock()
+	if c == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cl.cfg.ProbeTimeout)
+	defer cancel()
+	reply, err := c.Do(ctx, "NODE.GOSSIP", marshalMessage(&msg))
+	if err != nil {
+		return
+	}
+	data, _ := reply.([]byte)
+	var peer gossipMessage
+	if err := unmarshalMessage(data, &peer); err != nil {
+		cl.logger.Printf("Malformed gossip from cluster node %s: %v", addr, err)
+		return
+	}
+	cl.mergeGossip(peer)
+}
+
+// gossipTargetLocked picks a random peer that speaks gossip, or returns ""
+// if there is none. Callers hold cl.mu.
+func (cl *Cluster) gossipTargetLocked() string {
+	var targets []string
+	for addr, node := range cl.nodes {
+		if node.State != NodeIncompatible && (PeerVersion{Features: node.Features}).HasFeature("gossip") {
+			targets = append(targets, addr)
+		}
+	}
+	if len(targets) == 0 {
+		return ""
+	}
+	return targets[rand.Intn(len(targets))]
+}
+
+// gossipMessageLocked describes this node and the members it knows of.
+// Callers hold cl.mu.
+func (cl *Cluster) gossipMessageLocked() gossipMessage {
+	msg := gossipMessage{From: gossipMember{
+		NodeID:    cl.self.ID,
+		Addr:      cl.self.Addr,
+		State:     cl.self.State,
+		Heartbeat: cl.self.Heartbeat,
+	}}
+	for _, node := range cl.nodes {
+		if node.State == NodeIncompatible {
+			continue
+		}
+		msg.Members = append(msg.Members, gossipMember{
+			NodeID:    node.ID,
+			Addr:      node.Addr,
+			State:     node.State,
+			Heartbeat: node.Heartbeat,
+		})
+	}
+	return msg
+}
+
+// mergeGossip starts tracking the members msg names that this node did not
+// know of and records the newer heartbeats it carries
+func (cl *Cluster) mergeGossip(msg gossipMessage) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	now := time.Now()
+	for _, member := range append([]gossipMember{msg.From}, msg.Members...) {
+		if member.Addr == "" || member.Addr == cl.self.Addr || member.NodeID == cl.self.ID {
+			continue
+		}
+		if _, forgotten := cl.forgotten[member.Addr]; forgotten {
+			continue
+		}
+		node, exists := cl.nodes[member.Addr]
+		if !exists {
+			cl.addNode(member.Addr)
+			if node = cl.nodes[member.Addr]; node == nil {
+				continue
+			}
+			cl.logger.Printf("Discovered cluster node %s (%s) through gossip", member.NodeID, member.Addr)
+		}
+		if member.Heartbeat > node.Heartbeat {
+			node.Heartbeat = member.Heartbeat
+			node.heartbeatAt = now
+		}
+	}
+}
+
+// heardOfLocked reports whether a heartbeat from node arrived within the
+// suspicion timeout, SuspicionMult probe intervals. Callers hold cl.mu.
+func (cl *Cluster) heardOfLocked(node *ClusterNode) bool {
+	timeout := time.Duration(cl.cfg.SuspicionMult) * cl.cfg.ProbeInterval
+	return !node.heartbeatAt.IsZero() && time.Since(node.heartbeatAt) < timeout
+}
+
+// LiveMembers counts this node and the peers not known to be down
+func (cl *Cluster) LiveMembers() int {
+	cl.mu.RLock()
+	defer cl.mu.RUnlock()
+
+	live := 1
+	for _, node := range cl.nodes {
+		switch node.State {
+		case NodeOnline, NodeSuspect, NodeDraining:
+			live++
+		}
+	}
+	return live
+}
+
+// nodeGossipCommand implements NODE.GOSSIP <message>, merging the sender's
+// gossipMessage and replying with this node's
+func nodeGossipCommand(ctx *CommandContext) error {
+	cl := ctx.Cache.clusterMembership()
+	if cl == nil {
+		return errClusterDisabled
+	}
+	var msg gossipMessage
+	if err := unmarshalMessage(ctx.Args[1], &msg); err != nil {
+		return errors.New("ERR " + err.Error())
+	}
+	cl.mergeGossip(msg)
+
+	cl.mu.RLock()
+	reply := cl.gossipMessageLocked()
+	cl.mu.RUnlock()
+	ctx.Out.WriteBulkString(string(marshalMessage(&reply)))
+	return nil
+}
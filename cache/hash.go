@@ -0,0 +1,44 @@
+package cache
+
+import (
+	"fmt"
+	"hash/maphash"
+	"strings"
+
+	"github.com/hamisionesmus/distributed-cache/internal/keyhash"
+)
+
+// Shard hash names accepted in Config.ShardHash
+const (
+	ShardHashFNV     = keyhash.FNV
+	ShardHashXXHash  = keyhash.XXHash
+	ShardHashMapHash = "maphash"
+)
+
+// shardHash returns the hash named by Config.ShardHash. maphash is
+// the fastest but seeded per process, so shards differ between runs.
+func shardHash(name string) (func(key string) uint64, error) {
+	switch strings.ToLower(name) {
+	case "", ShardHashFNV:
+		return fnv1a64, nil
+	case ShardHashMapHash:
+		seed := maphash.MakeSeed()
+		return func(key string) uint64 { return maphash.String(seed, key) }, nil
+	default:
+		h, err := keyhash.Func(strings.ToLower(name))
+		if err != nil {
+			return nil, fmt.Errorf("unknown shard hash: %s", name)
+		}
+		return func(key string) uint64 { return h([]byte(key)) }, nil
+	}
+}
+
+// fnv1a64 is FNV-1a without the allocation of hash/fnv
+func fnv1a64(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
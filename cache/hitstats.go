@@ -0,0 +1,140 @@
+package cache
+
+import "sync/atomic"
+
+// hitCounter counts the hits and misses of one stripe or namespace
+type hitCounter struct {
+	hits    atomic.Int64
+	misses  atomic.Int64
+	expired atomic.Int64
+	// pad keeps stripes on separate cache lines
+	_ [40]byte
+}
+
+// load returns the counts so far
+func (h *hitCounter) load() KeyspaceHits {
+	return KeyspaceHits{
+		Hits:          h.hits.Load(),
+		Misses:        h.misses.Load(),
+		ExpiredMisses: h.expired.Load(),
+	}
+}
+
+// KeyspaceHits counts the reads that found their key and those that did not
+type KeyspaceHits struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// ExpiredMisses are the misses that found the key expired
+	ExpiredMisses int64 `json:"expired_misses"`
+}
+
+// HitRate returns the share of reads that were hits, or zero before any
+func (h KeyspaceHits) HitRate() float64 {
+	if h.Hits+h.Misses == 0 {
+		return 0
+	}
+	return float64(h.Hits) / float64(h.Hits+h.Misses)
+}
+
+// keyspaceHits holds the counters of a cache, striped by the shard hash,
+// and of each configured namespace
+type keyspaceHits struct {
+	stripes []hitCounter
+	hash    func(key string) uint64
+	// namespaces is replaced as a whole under the cache's mutex when a
+	// namespace is added, so reads find it without a lock
+	namespaces atomic.Pointer[map[string]*hitCounter]
+}
+
+// newKeyspaceHits creates n stripes picked by hash, or one if hash is nil
+func newKeyspaceHits(n int, hash func(key string) uint64) *keyspaceHits {
+	if hash == nil {
+		n = 1
+	}
+	k := &keyspaceHits{
+		stripes: make([]hitCounter, n),
+		hash:    hash,
+	}
+	k.namespaces.Store(&map[string]*hitCounter{})
+	return k
+}
+
+// counters returns the stripe of key and its namespace's counter, nil if
+// the namespace is not counted
+func (k *keyspaceHits) counters(key string) (stripe, ns *hitCounter) {
+	stripe = &k.stripes[0]
+	if k.hash != nil {
+		stripe = &k.stripes[k.hash(key)%uint64(len(k.stripes))]
+	}
+	if namespaces := *k.namespaces.Load(); len(namespaces) > 0 {
+		ns = namespaces[NamespaceOf(key)]
+	}
+	return stripe, ns
+}
+
+// hit counts a read that found key
+func (k *keyspaceHits) hit(key string) {
+	stripe, ns := k.counters(key)
+	stripe.hits.Add(1)
+	if ns != nil {
+		ns.hits.Add(1)
+	}
+}
+
+// miss counts a read that did not find key, because it had expired if
+// expired is set
+func (k *keyspaceHits) miss(key string, expired bool) {
+	stripe, ns := k.counters(key)
+	stripe.misses.Add(1)
+	if expired {
+		stripe.expired.Add(1)
+	}
+	if ns != nil {
+		ns.misses.Add(1)
+		if expired {
+			ns.expired.Add(1)
+		}
+	}
+}
+
+// total sums the stripes
+func (k *keyspaceHits) total() KeyspaceHits {
+	var total KeyspaceHits
+	for i := range k.stripes {
+		counts := k.stripes[i].load()
+		total.Hits += counts.Hits
+		total.Misses += counts.Misses
+		total.ExpiredMisses += counts.ExpiredMisses
+	}
+	return total
+}
+
+// countNamespace starts counting the reads of namespace ns. Callers hold
+// the cache's mutex.
+func (k *keyspaceHits) countNamespace(ns string) {
+	old := *k.namespaces.Load()
+	if _, ok := old[ns]; ok {
+		return
+	}
+	namespaces := make(map[string]*hitCounter, len(old)+1)
+	for name, counter := range old {
+		namespaces[name] = counter
+	}
+	namespaces[ns] = &hitCounter{}
+	k.namespaces.Store(&namespaces)
+}
+
+// KeyspaceHits returns the hits and misses of every Get so far
+func (c *Cache) KeyspaceHits() KeyspaceHits {
+	return c.hits.total()
+}
+
+// NamespaceHits returns the hits and misses of each configured namespace
+func (c *Cache) NamespaceHits() map[string]KeyspaceHits {
+	namespaces := *c.hits.namespaces.Load()
+	hits := make(map[string]KeyspaceHits, len(namespaces))
+	for ns, counter := range namespaces {
+		hits[ns] = counter.load()
+	}
+	return hits
+}
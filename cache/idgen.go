@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"errors"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IDs are k-sortable 64-bit integers in the layout of Twitter's Snowflake:
+// 41 bits of milliseconds since idEpoch, a 10-bit node number and a 12-bit
+// sequence within the millisecond. IDs from one node strictly increase,
+// and nodes with different numbers never collide, so services need no
+// round trip to a single INCR counter.
+
+const (
+	idNodeBits = 10
+	idSeqBits  = 12
+	// MaxIDNode is the largest node number
+	MaxIDNode = 1<<idNodeBits - 1
+	idSeqMax  = 1<<idSeqBits - 1
+	// maxIDClockLag is how far the generator may run ahead of the wall
+	// clock, after it steps back or a burst exhausts a millisecond's
+	// sequence, before refusing to issue IDs
+	maxIDClockLag = time.Second
+)
+
+// idEpoch is the zero of the timestamp bits, 2024-01-01 UTC
+var idEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// errClockBackwards is returned while the clock is too far behind the last
+// ID issued
+var errClockBackwards = errors.New("ERR clock moved backwards, refusing to generate IDs")
+
+// IDGenerator issues the IDs of one node
+type IDGenerator struct {
+	mu   sync.Mutex
+	node int64
+	// last is the timestamp of the last ID, which may run ahead of the
+	// clock, and seq its sequence
+	last int64
+	seq  int64
+}
+
+// NewIDGenerator creates a generator for node, which must be at most
+// MaxIDNode
+func NewIDGenerator(node int64) (*IDGenerator, error) {
+	g := &IDGenerator{}
+	if err := g.SetNode(node); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// SetNode changes the node number of IDs issued from now on
+func (g *IDGenerator) SetNode(node int64) error {
+	if node < 0 || node > MaxIDNode {
+		return errors.New("ID node number out of range")
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.node = node
+	return nil
+}
+
+// Next returns n new IDs in increasing order. A clock stepping back by
+// less than maxIDClockLag is ridden out by carrying on from the last
+// timestamp; a bigger step fails until the clock catches up, rather than
+// risk reissuing IDs.
+func (g *IDGenerator) Next(n int) ([]int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := time.Since(idEpoch).Milliseconds()
+	if g.last-now > maxIDClockLag.Milliseconds() {
+		return nil, errClockBackwards
+	}
+	ids := make([]int64, n)
+	for i := range ids {
+		switch {
+		case now > g.last:
+			g.last, g.seq = now, 0
+		case g.seq < idSeqMax:
+			g.seq++
+		default:
+			// The millisecond's sequence is used up, so borrow the next
+			g.last, g.seq = g.last+1, 0
+		}
+		ids[i] = g.last<<(idNodeBits+idSeqBits) | g.node<<idSeqBits | g.seq
+	}
+	return ids, nil
+}
+
+// ParseID splits an ID into its time, node number and sequence
+func ParseID(id int64) (time.Time, int64, int64) {
+	ms := id >> (idNodeBits + idSeqBits)
+	node := id >> idSeqBits & MaxIDNode
+	return idEpoch.Add(time.Duration(ms) * time.Millisecond), node, id & idSeqMax
+}
+
+// idNodeFor returns the configured node number, or one derived from the
+// cluster node ID if node is negative. Derived numbers may collide, so
+// fleets relying on uniqueness should number their nodes.
+func idNodeFor(node int, nodeID string) int64 {
+	if node >= 0 {
+		return int64(node)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(nodeID))
+	return int64(h.Sum32() % (MaxIDNode + 1))
+}
+
+// SetIDNode sets the node number of the IDs IDGEN issues
+func (c *Cache) SetIDNode(node int64) error {
+	return c.ids.SetNode(node)
+}
+
+func init() {
+	RegisterCommands(&Command{Name: "IDGEN", Arity: -1, Flags: FlagReadOnly, Handler: idgenCommand, KeyArgs: noKeyArgs})
+}
+
+// idgenCommand implements IDGEN [count], replying with an ID, or an array
+// of count IDs, and IDGEN PARSE id, replying with the ID's unix time in
+// milliseconds, node number and sequence
+func idgenCommand(ctx *CommandContext) error {
+	if len(ctx.Args) == 3 && strings.EqualFold(string(ctx.Args[1]), "PARSE") {
+		id, err := ParseInt(ctx.Args[2])
+		if err != nil || id < 0 {
+			return errors.New("ERR invalid ID")
+		}
+		at, node, seq := ParseID(id)
+		ctx.Out.WriteArrayHeader(3)
+		ctx.Out.WriteInteger(at.UnixMilli())
+		ctx.Out.WriteInteger(node)
+		ctx.Out.WriteInteger(seq)
+		return nil
+	}
+
+	count := int64(1)
+	switch len(ctx.Args) {
+	case 1:
+	case 2:
+		n, err := ParseInt(ctx.Args[1])
+		if err != nil || n < 1 || n > idSeqMax+1 {
+			return errors.New("ERR count must be between 1 and " + strconv.Itoa(idSeqMax+1))
+		}
+		count = n
+	default:
+		return ErrSyntax
+	}
+	ids, err := ctx.Cache.ids.Next(int(count))
+	if err != nil {
+		return err
+	}
+	if len(ctx.Args) == 1 {
+		ctx.Out.WriteInteger(ids[0])
+		return nil
+	}
+	ctx.Out.WriteArrayHeader(len(ids))
+	for _, id := range ids {
+		ctx.Out.WriteInteger(id)
+	}
+	return nil
+}
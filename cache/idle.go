@@ -0,0 +1,101 @@
+package cache
+
+import "time"
+
+// The idle reaper evicts entries nobody has read or written within an idle
+// window, whatever their TTL, for caches where data that is stale but not
+// yet expired would otherwise hold most of the memory. Pinned entries are
+// never reaped.
+
+// idleReapChunk is how many idle entries are evicted per lock acquisition
+const idleReapChunk = 1024
+
+// IdleReapStats describes the idle reaper's work
+type IdleReapStats struct {
+	Runs           int64     `json:"runs"`
+	LastRun        time.Time `json:"last_run"`
+	Reaped         int64     `json:"reaped"`
+	ReclaimedBytes int64     `json:"reclaimed_bytes"`
+}
+
+// ReapIdle evicts the unpinned entries last accessed more than idle ago
+// and returns how many were evicted and the bytes they held
+func (c *Cache) ReapIdle(idle time.Duration) (int, int64) {
+	start := time.Now()
+	cutoff := start.Add(-idle)
+
+	reaped, reclaimed := 0, int64(0)
+	for _, s := range c.shards {
+		n, bytes := s.reapIdle(cutoff)
+		reaped += n
+		reclaimed += bytes
+	}
+
+	c.mutex.Lock()
+	c.idleStats.Runs++
+	c.idleStats.LastRun = start
+	c.idleStats.Reaped += int64(reaped)
+	c.idleStats.ReclaimedBytes += reclaimed
+	c.mutex.Unlock()
+	return reaped, reclaimed
+}
+
+// reapIdle evicts the shard's unpinned entries last accessed before cutoff.
+// It takes the shard's lock itself.
+func (s *cacheShard) reapIdle(cutoff time.Time) (int, int64) {
+	s.mutex.Lock()
+	s.flushReads()
+	s.mutex.Unlock()
+
+	// Find candidates under the read lock, then evict them in chunks,
+	// checking each again in case it was used in between
+	s.mutex.RLock()
+	var candidates []string
+	for key, entry := range s.data {
+		if !entry.Pinned && entry.LastAccessed.Before(cutoff) {
+			candidates = append(candidates, key)
+		}
+	}
+	s.mutex.RUnlock()
+
+	reaped, reclaimed := 0, int64(0)
+	for len(candidates) > 0 {
+		n := idleReapChunk
+		if n > len(candidates) {
+			n = len(candidates)
+		}
+		s.mutex.Lock()
+		for _, key := range candidates[:n] {
+			entry, ok := s.data[key]
+			if !ok || entry.Pinned || !entry.LastAccessed.Before(cutoff) {
+				continue
+			}
+			reclaimed += entry.memory
+			reaped++
+			s.dropEntry(entry, RemovalIdle)
+		}
+		s.mutex.Unlock()
+		candidates = candidates[n:]
+	}
+	return reaped, reclaimed
+}
+
+// IdleReapStats returns statistics about idle reaper runs
+func (c *Cache) IdleReapStats() IdleReapStats {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.idleStats
+}
+
+// StartIdleReaper evicts entries idle for longer than idle every interval
+func (c *Cache) StartIdleReaper(idle, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			c.ReapIdle(idle)
+		}
+	}()
+}
@@ -0,0 +1,312 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Index errors
+var (
+	ErrIndexExists   = errors.New("index already exists")
+	ErrIndexNotFound = errors.New("no such index")
+)
+
+// IndexInfo describes a secondary index
+type IndexInfo struct {
+	Name   string `json:"name"`
+	Prefix string `json:"prefix"`
+	Path   string `json:"path"`
+	Keys   int    `json:"keys"`
+}
+
+// secondaryIndex maps the value found at a JSON path to the keys holding it.
+// It only covers keys starting with prefix. Each shard holds the part of
+// the index over its keys, maintained under the shard's lock, so it always
+// agrees with the stored values.
+type secondaryIndex struct {
+	name   string
+	prefix string
+	path   string
+	fields []string
+
+	keysByValue map[string]map[string]struct{}
+	valueByKey  map[string]string
+}
+
+func newSecondaryIndex(name, prefix, path string) (*secondaryIndex, error) {
+	fields, err := parseIndexPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return &secondaryIndex{
+		name:        name,
+		prefix:      prefix,
+		path:        path,
+		fields:      fields,
+		keysByValue: make(map[string]map[string]struct{}),
+		valueByKey:  make(map[string]string),
+	}, nil
+}
+
+// parseIndexPath accepts dotted paths like "address.city" or "$.address.city"
+func parseIndexPath(path string) ([]string, error) {
+	trimmed := strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if trimmed == "" {
+		return nil, fmt.Errorf("invalid index path: %q", path)
+	}
+	fields := strings.Split(trimmed, ".")
+	for _, field := range fields {
+		if field == "" {
+			return nil, fmt.Errorf("invalid index path: %q", path)
+		}
+	}
+	return fields, nil
+}
+
+// extract returns the indexed value of a JSON document. Values that are not
+// JSON, lack the path, or hold an object, array or null are not indexed.
+func (idx *secondaryIndex) extract(value []byte) (string, bool) {
+	var doc interface{}
+	if err := json.Unmarshal(value, &doc); err != nil {
+		return "", false
+	}
+
+	for _, field := range idx.fields {
+		obj, ok := doc.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if doc, ok = obj[field]; !ok {
+			return "", false
+		}
+	}
+
+	switch v := doc.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	default:
+		return "", false
+	}
+}
+
+func (idx *secondaryIndex) add(key string, value []byte) {
+	if !strings.HasPrefix(key, idx.prefix) {
+		return
+	}
+	indexed, ok := idx.extract(value)
+	if !ok {
+		return
+	}
+
+	keys, exists := idx.keysByValue[indexed]
+	if !exists {
+		keys = make(map[string]struct{})
+		idx.keysByValue[indexed] = keys
+	}
+	keys[key] = struct{}{}
+	idx.valueByKey[key] = indexed
+}
+
+func (idx *secondaryIndex) remove(key string) {
+	indexed, ok := idx.valueByKey[key]
+	if !ok {
+		return
+	}
+	delete(idx.valueByKey, key)
+
+	keys := idx.keysByValue[indexed]
+	delete(keys, key)
+	if len(keys) == 0 {
+		delete(idx.keysByValue, indexed)
+	}
+}
+
+func (idx *secondaryIndex) reset() {
+	idx.keysByValue = make(map[string]map[string]struct{})
+	idx.valueByKey = make(map[string]string)
+}
+
+// indexEntry adds an entry to every index covering it
+func (s *cacheShard) indexEntry(entry *CacheEntry) {
+	for _, idx := range s.indexes {
+		idx.add(entry.Key, entry.Value)
+	}
+}
+
+// unindexEntry removes an entry from every index
+func (s *cacheShard) unindexEntry(entry *CacheEntry) {
+	for _, idx := range s.indexes {
+		idx.remove(entry.Key)
+	}
+}
+
+// CreateIndex declares an index over the JSON value at path for keys
+// starting with prefix, and builds it from the current keyspace
+func (c *Cache) CreateIndex(name, prefix, path string) error {
+	idx, err := newSecondaryIndex(name, prefix, path)
+	if err != nil {
+		return err
+	}
+
+	c.lockAll()
+	defer c.unlockAll()
+
+	if _, exists := c.shards[0].indexes[name]; exists {
+		return ErrIndexExists
+	}
+	for _, s := range c.shards {
+		part := &secondaryIndex{name: idx.name, prefix: idx.prefix, path: idx.path, fields: idx.fields}
+		part.reset()
+		for key, entry := range s.data {
+			part.add(key, entry.Value)
+		}
+		s.indexes[name] = part
+	}
+	return nil
+}
+
+// DropIndex removes an index
+func (c *Cache) DropIndex(name string) bool {
+	c.lockAll()
+	defer c.unlockAll()
+
+	if _, exists := c.shards[0].indexes[name]; !exists {
+		return false
+	}
+	for _, s := range c.shards {
+		delete(s.indexes, name)
+	}
+	return true
+}
+
+// QueryIndex returns up to limit keys, in sorted order, whose indexed value
+// equals value. A limit of zero or less returns every match.
+func (c *Cache) QueryIndex(name, value string, limit int) ([]string, error) {
+	c.rlockAll()
+	defer c.runlockAll()
+
+	if _, exists := c.shards[0].indexes[name]; !exists {
+		return nil, ErrIndexNotFound
+	}
+
+	now := c.now()
+	keys := make([]string, 0)
+	for _, s := range c.shards {
+		for key := range s.indexes[name].keysByValue[value] {
+			// Expired entries stay indexed until they are reclaimed
+			if entry := s.data[key]; entry.ExpiresAt != nil && now.After(*entry.ExpiresAt) {
+				continue
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+	return keys, nil
+}
+
+// Indexes describes every declared index
+func (c *Cache) Indexes() []IndexInfo {
+	c.rlockAll()
+	defer c.runlockAll()
+
+	infos := make([]IndexInfo, 0, len(c.shards[0].indexes))
+	for name, idx := range c.shards[0].indexes {
+		keys := 0
+		for _, s := range c.shards {
+			keys += len(s.indexes[name].valueByKey)
+		}
+		infos = append(infos, IndexInfo{
+			Name:   idx.name,
+			Prefix: idx.prefix,
+			Path:   idx.path,
+			Keys:   keys,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "IDX.CREATE", Arity: 6, Flags: FlagWrite, Handler: idxCreateCommand},
+		&Command{Name: "IDX.DROP", Arity: 2, Flags: FlagWrite, Handler: idxDropCommand},
+		&Command{Name: "IDX.QUERY", Arity: -3, Flags: FlagReadOnly, Handler: idxQueryCommand},
+		&Command{Name: "IDX.LIST", Arity: 1, Flags: FlagReadOnly, Handler: idxListCommand},
+	)
+}
+
+// idxCreateCommand implements IDX.CREATE name PREFIX prefix PATH path
+func idxCreateCommand(ctx *CommandContext) error {
+	if !strings.EqualFold(string(ctx.Args[2]), "PREFIX") || !strings.EqualFold(string(ctx.Args[4]), "PATH") {
+		return ErrSyntax
+	}
+
+	err := ctx.Cache.CreateIndex(string(ctx.Args[1]), string(ctx.Args[3]), string(ctx.Args[5]))
+	if err != nil {
+		return fmt.Errorf("ERR %v", err)
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// idxDropCommand implements IDX.DROP name
+func idxDropCommand(ctx *CommandContext) error {
+	if !ctx.Cache.DropIndex(string(ctx.Args[1])) {
+		return fmt.Errorf("ERR %v", ErrIndexNotFound)
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// idxQueryCommand implements IDX.QUERY name value [LIMIT count]
+func idxQueryCommand(ctx *CommandContext) error {
+	limit := 0
+	switch len(ctx.Args) {
+	case 3:
+	case 5:
+		if !strings.EqualFold(string(ctx.Args[3]), "LIMIT") {
+			return ErrSyntax
+		}
+		n, err := ParseInt(ctx.Args[4])
+		if err != nil || n < 0 {
+			return ErrNotInteger
+		}
+		limit = int(n)
+	default:
+		return ErrSyntax
+	}
+
+	keys, err := ctx.Cache.QueryIndex(string(ctx.Args[1]), string(ctx.Args[2]), limit)
+	if err != nil {
+		return fmt.Errorf("ERR %v", err)
+	}
+	ctx.Out.WriteStringArray(keys)
+	return nil
+}
+
+// idxListCommand implements IDX.LIST, replying with name, prefix, path and
+// key count for each index
+func idxListCommand(ctx *CommandContext) error {
+	infos := ctx.Cache.Indexes()
+	ctx.Out.WriteArrayHeader(len(infos))
+	for _, info := range infos {
+		ctx.Out.WriteArrayHeader(4)
+		ctx.Out.WriteBulkString(info.Name)
+		ctx.Out.WriteBulkString(info.Prefix)
+		ctx.Out.WriteBulkString(info.Path)
+		ctx.Out.WriteInteger(int64(info.Keys))
+	}
+	return nil
+}
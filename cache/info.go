@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// infoSection renders one "# Title" block of INFO output
+type infoSection func(c *Cache, b *strings.Builder)
+
+// infoSections are the sections of INFO in output order
+var infoSections = []struct {
+	name   string
+	render infoSection
+}{
+	{"server", infoServer},
+	{"memory", infoMemory},
+	{"persistence", infoPersistence},
+	{"stats", infoStats},
+	{"replication", infoReplication},
+	{"keyspace", infoKeyspace},
+}
+
+func infoServer(c *Cache, b *strings.Builder) {
+	fmt.Fprintf(b, "go_version:%s\r\n", runtime.Version())
+	fmt.Fprintf(b, "num_cpu:%d\r\n", runtime.NumCPU())
+	fmt.Fprintf(b, "gomaxprocs:%d\r\n", runtime.GOMAXPROCS(0))
+	if quota, ok := cgroupCPUQuota(); ok {
+		fmt.Fprintf(b, "cgroup_cpu_quota:%.2f\r\n", quota)
+	}
+	fmt.Fprintf(b, "shard_count:%d\r\n", len(c.shards))
+	fmt.Fprintf(b, "protocol_version:%d\r\n", ProtocolVersion)
+	fmt.Fprintf(b, "min_protocol_version:%d\r\n", MinProtocolVersion)
+}
+
+func infoMemory(c *Cache, b *strings.Builder) {
+	fmt.Fprintf(b, "used_memory:%d\r\n", c.usedMemory.Load())
+	fmt.Fprintf(b, "maxmemory:%d\r\n", c.maxCost)
+	fmt.Fprintf(b, "pinned_memory:%d\r\n", c.pinnedBytes.Load())
+	fmt.Fprintf(b, "memory_pressure:%s\r\n", c.MemoryPressure())
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	fmt.Fprintf(b, "mem_fragmentation_ratio:%.2f\r\n", c.defragStats.Fragmentation)
+	fmt.Fprintf(b, "idle_reaped_keys:%d\r\n", c.idleStats.Reaped)
+	fmt.Fprintf(b, "idle_reclaimed_bytes:%d\r\n", c.idleStats.ReclaimedBytes)
+}
+
+func infoPersistence(c *Cache, b *strings.Builder) {
+	if warmup, ok := c.Warmup(); ok {
+		fmt.Fprintf(b, "warming:%d\r\n", BoolInt(warmup.Warming))
+		fmt.Fprintf(b, "warming_loaded_keys:%d\r\n", warmup.Loaded)
+		fmt.Fprintf(b, "warming_total_keys:%d\r\n", warmup.Total)
+		fmt.Fprintf(b, "warming_skipped_keys:%d\r\n", warmup.Skipped)
+		fmt.Fprintf(b, "warming_dropped_keys:%d\r\n", warmup.Dropped)
+		fmt.Fprintf(b, "warming_duration_seconds:%.3f\r\n", warmup.Duration.Seconds())
+		if warmup.Error != "" {
+			b.WriteString("warming_last_status:err\r\n")
+		} else {
+			b.WriteString("warming_last_status:ok\r\n")
+		}
+	} else {
+		b.WriteString("warming:0\r\n")
+	}
+	saves := c.SaveStats()
+	saveStatus := "ok"
+	if saves.LastError != "" {
+		saveStatus = "err"
+	}
+	fmt.Fprintf(b, "rdb_changes_since_last_save:%d\r\n", saves.ChangesSinceSave)
+	fmt.Fprintf(b, "rdb_bgsave_in_progress:%d\r\n", BoolInt(saves.Saving))
+	if !saves.LastSave.IsZero() {
+		fmt.Fprintf(b, "rdb_last_save_time:%d\r\n", saves.LastSave.Unix())
+	}
+	fmt.Fprintf(b, "rdb_last_bgsave_status:%s\r\n", saveStatus)
+	aof := c.AOF()
+	if aof == nil {
+		b.WriteString("aof_enabled:0\r\n")
+		return
+	}
+	stats := aof.Stats()
+	status := "ok"
+	if stats.LastError != "" {
+		status = "err"
+	}
+	b.WriteString("aof_enabled:1\r\n")
+	fmt.Fprintf(b, "aof_fsync:%s\r\n", stats.Policy)
+	fmt.Fprintf(b, "aof_current_size:%d\r\n", stats.Size)
+	fmt.Fprintf(b, "aof_pending_bytes:%d\r\n", stats.PendingBytes)
+	fmt.Fprintf(b, "aof_last_fsync:%d\r\n", stats.LastFsync.Unix())
+	fmt.Fprintf(b, "aof_delayed_fsync:%d\r\n", stats.DelayedFsyncs)
+	fmt.Fprintf(b, "aof_last_write_status:%s\r\n", status)
+	fmt.Fprintf(b, "aof_truncated_bytes:%d\r\n", stats.TruncatedBytes)
+	fmt.Fprintf(b, "aof_rewrite_in_progress:%d\r\n", BoolInt(stats.Rewriting))
+	fmt.Fprintf(b, "aof_rewrites:%d\r\n", stats.Rewrites)
+	rewriteStatus := "ok"
+	if stats.LastRewriteError != "" {
+		rewriteStatus = "err"
+	}
+	fmt.Fprintf(b, "aof_last_bgrewrite_status:%s\r\n", rewriteStatus)
+}
+
+func infoStats(c *Cache, b *strings.Builder) {
+	hits := c.KeyspaceHits()
+	fmt.Fprintf(b, "keyspace_hits:%d\r\n", hits.Hits)
+	fmt.Fprintf(b, "keyspace_misses:%d\r\n", hits.Misses)
+	fmt.Fprintf(b, "keyspace_expired_misses:%d\r\n", hits.ExpiredMisses)
+	fmt.Fprintf(b, "keyspace_hit_rate:%.4f\r\n", hits.HitRate())
+	refreshed, failed := c.RefreshAheadStats()
+	fmt.Fprintf(b, "refresh_ahead_fetches:%d\r\n", refreshed)
+	fmt.Fprintf(b, "refresh_ahead_failures:%d\r\n", failed)
+
+	namespaces := c.NamespaceHits()
+	names := make([]string, 0, len(namespaces))
+	for ns := range namespaces {
+		names = append(names, ns)
+	}
+	sort.Strings(names)
+	for _, ns := range names {
+		hits := namespaces[ns]
+		fmt.Fprintf(b, "namespace_%s:hits=%d,misses=%d,expired_misses=%d,hit_rate=%.4f\r\n",
+			ns, hits.Hits, hits.Misses, hits.ExpiredMisses, hits.HitRate())
+	}
+}
+
+func infoReplication(c *Cache, b *strings.Builder) {
+	if lag, ok := c.ReplicaLag(); ok {
+		b.WriteString("role:replica\r\n")
+		fmt.Fprintf(b, "master_node_id:%s\r\n", lag.Primary)
+		fmt.Fprintf(b, "slave_repl_offset:%d\r\n", lag.Offset)
+		fmt.Fprintf(b, "slave_lag_records:%d\r\n", lag.LagRecords)
+		fmt.Fprintf(b, "slave_staleness_seconds:%.3f\r\n", lag.StalenessSeconds)
+		fmt.Fprintf(b, "master_last_report_seconds_ago:%d\r\n", int64(time.Since(lag.LastReport).Seconds()))
+		fmt.Fprintf(b, "stale_reads:%d\r\n", lag.StaleReads)
+	} else {
+		b.WriteString("role:master\r\n")
+	}
+	wal := c.replicationLog()
+	if wal == nil {
+		b.WriteString("repl_backlog_active:0\r\n")
+		return
+	}
+	stats := wal.Stats()
+	fmt.Fprintf(b, "master_repl_offset:%d\r\n", stats.Seq)
+	b.WriteString("repl_backlog_active:1\r\n")
+	fmt.Fprintf(b, "repl_backlog_size:%d\r\n", stats.BacklogLimit)
+	fmt.Fprintf(b, "repl_backlog_first_offset:%d\r\n", stats.FirstSeq)
+	fmt.Fprintf(b, "repl_backlog_histlen:%d\r\n", stats.BacklogBytes)
+}
+
+func infoKeyspace(c *Cache, b *strings.Builder) {
+	c.rlockAll()
+	defer c.runlockAll()
+
+	expires := 0
+	for _, s := range c.shards {
+		expires += len(s.expirations)
+	}
+	fmt.Fprintf(b, "keys:%d\r\n", c.currentSize.Load())
+	fmt.Fprintf(b, "expires:%d\r\n", expires)
+}
+
+// Info renders the named INFO sections, or all of them when none are given
+func (c *Cache) Info(sections ...string) string {
+	wanted := make(map[string]bool, len(sections))
+	for _, name := range sections {
+		wanted[strings.ToLower(name)] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["everything"]
+
+	var b strings.Builder
+	for _, section := range infoSections {
+		if !all && !wanted[section.name] {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", strings.Title(section.name))
+		section.render(c, &b)
+	}
+	return b.String()
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "INFO", Arity: -1, Flags: FlagReadOnly | FlagAdmin, Handler: infoCommand},
+	)
+}
+
+// infoCommand implements INFO [section ...]
+func infoCommand(ctx *CommandContext) error {
+	sections := make([]string, 0, len(ctx.Args)-1)
+	for _, arg := range ctx.Args[1:] {
+		sections = append(sections, string(arg))
+	}
+	ctx.Out.WriteBulkString(ctx.Cache.Info(sections...))
+	return nil
+}
@@ -0,0 +1,80 @@
+package cache
+
+import "container/heap"
+
+// lfuPolicy evicts the least frequently used entry, the least recently
+// used of those on a tie. Frequencies count the reads and updates since
+// the entry was added, so an entry rewritten under the same key starts
+// over. Entries sit on a min-heap, at the index kept in entry.slot.
+type lfuPolicy struct {
+	heap lfuHeap
+}
+
+// lfuItem is an entry on the heap with its frequency
+type lfuItem struct {
+	entry *CacheEntry
+	freq  int64
+}
+
+type lfuHeap []lfuItem
+
+func (h lfuHeap) Len() int { return len(h) }
+
+func (h lfuHeap) Less(i, j int) bool {
+	if h[i].freq != h[j].freq {
+		return h[i].freq < h[j].freq
+	}
+	return h[i].entry.LastAccessed.Before(h[j].entry.LastAccessed)
+}
+
+func (h lfuHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].entry.slot = i
+	h[j].entry.slot = j
+}
+
+func (h *lfuHeap) Push(x any) {
+	item := x.(lfuItem)
+	item.entry.slot = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *lfuHeap) Pop() any {
+	old := *h
+	item := old[len(old)-1]
+	old[len(old)-1] = lfuItem{}
+	*h = old[:len(old)-1]
+	return item
+}
+
+func newLFUPolicy() *lfuPolicy {
+	return &lfuPolicy{}
+}
+
+func (p *lfuPolicy) Add(entry *CacheEntry) {
+	heap.Push(&p.heap, lfuItem{entry: entry})
+}
+
+func (p *lfuPolicy) Access(entry *CacheEntry) {
+	p.heap[entry.slot].freq++
+	heap.Fix(&p.heap, entry.slot)
+}
+
+func (p *lfuPolicy) Remove(entry *CacheEntry) {
+	heap.Remove(&p.heap, entry.slot)
+}
+
+func (p *lfuPolicy) Victim() *CacheEntry {
+	if len(p.heap) == 0 {
+		return nil
+	}
+	return p.heap[0].entry
+}
+
+func (p *lfuPolicy) Len() int {
+	return len(p.heap)
+}
+
+func (p *lfuPolicy) Reset() {
+	p.heap = nil
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"container/list"
+	"time"
+	"unsafe"
+)
+
+// Fixed per-entry memory overheads on top of the key and value bytes
+var (
+	entryStructSize = int64(unsafe.Sizeof(CacheEntry{}))
+	// listElementSize covers the eviction policy's list node
+	listElementSize = int64(unsafe.Sizeof(list.Element{}))
+	// mapSlotSize is a string key, a pointer value and a tophash byte,
+	// amortized over Go's map load factor of 6.5 per 8-slot bucket
+	mapSlotSize = (int64(unsafe.Sizeof("")) + int64(unsafe.Sizeof(uintptr(0))) + 1) * 16 / 13
+	timeSize    = int64(unsafe.Sizeof(time.Time{}))
+	// entryOverhead is charged to every entry regardless of its contents
+	entryOverhead = entryStructSize + listElementSize + mapSlotSize
+)
+
+// memorySizer is implemented by objects that can estimate their own heap
+// usage. Objects without it are charged only the entry overhead.
+type memorySizer interface {
+	memoryUsage() int64
+}
+
+// allocSize rounds a heap allocation up to the allocator's 8 or 16 byte
+// granularity
+func allocSize(n int64) int64 {
+	if n <= 0 {
+		return 0
+	}
+	if n <= 8 {
+		return 8
+	}
+	return (n + 15) &^ 15
+}
+
+// stringSize is the memory held by a string stored in a struct or slice
+func stringSize(s string) int64 {
+	return int64(unsafe.Sizeof(s)) + allocSize(int64(len(s)))
+}
+
+// memoryUsage estimates the heap memory held by the entry, including the
+// map slot and list node that reference it and its tag index memberships.
+// Prefix and secondary indexes are optional and not charged.
+func (e *CacheEntry) memoryUsage() int64 {
+	n := entryOverhead + allocSize(int64(len(e.Key))) + allocSize(int64(cap(e.Value)))
+	if e.ExpiresAt != nil {
+		n += allocSize(timeSize)
+	}
+	n += allocSize(int64(len(e.Origin)))
+	for _, tag := range e.Tags {
+		// The tag string in the entry plus the key's slot in the tag's set
+		n += stringSize(tag) + mapSlotSize
+	}
+	if sizer, ok := e.Object.(memorySizer); ok {
+		n += sizer.memoryUsage()
+	}
+	return n
+}
+
+// cost is the entry's charge against the cost budget
+func (e *CacheEntry) cost() int64 {
+	if e.Cost > 0 {
+		return e.Cost
+	}
+	return e.memory
+}
+
+// recharge updates the accounting and version of an entry whose object
+// changed, marking it for the next incremental snapshot and evicting if
+// the cache is now over budget
+func (s *cacheShard) recharge(entry *CacheEntry) {
+	c := s.cache
+	s.markDirty(entry.Key)
+	oldMemory, oldCost, oldPinned := entry.memory, entry.cost(), entry.pinnedSize()
+	entry.memory = entry.memoryUsage()
+	c.usedMemory.Add(entry.memory - oldMemory)
+	c.totalCost.Add(entry.cost() - oldCost)
+	c.pinnedBytes.Add(entry.pinnedSize() - oldPinned)
+	entry.version = c.versions.Add(1)
+	s.evictOverflow(0)
+	c.updatePressure()
+}
+
+// UsedMemory returns the estimated heap memory held by all entries
+func (c *Cache) UsedMemory() int64 {
+	return c.usedMemory.Load()
+}
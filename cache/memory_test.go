@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// memoryTestEntries is how many entries each accounting test stores, enough
+// that the map's growth steps average out
+const memoryTestEntries = 50000
+
+// heapGrowth returns how much the live heap grows while fill runs
+func heapGrowth(fill func()) int64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	fill()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	return int64(after.HeapAlloc) - int64(before.HeapAlloc)
+}
+
+// TestMemoryAccountingMatchesHeap checks that UsedMemory tracks what the
+// Go heap actually holds for the entries, so a maxmemory limit bounds the
+// process's memory rather than only its value bytes
+func TestMemoryAccountingMatchesHeap(t *testing.T) {
+	ctx := context.Background()
+	ttl := time.Hour
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		set  func(c *Cache, i int)
+	}{
+		{"small strings", 0, func(c *Cache, i int) {
+			c.Set(ctx, fmt.Sprintf("key:%d", i), make([]byte, 16), nil)
+		}},
+		{"large strings", 0, func(c *Cache, i int) {
+			c.Set(ctx, fmt.Sprintf("key:%d", i), make([]byte, 1024), nil)
+		}},
+		{"strings with a ttl", 0, func(c *Cache, i int) {
+			c.Set(ctx, fmt.Sprintf("key:%d", i), make([]byte, 16), &ttl)
+		}},
+		{"default ttl", time.Hour, func(c *Cache, i int) {
+			c.Set(ctx, fmt.Sprintf("key:%d", i), make([]byte, 16), nil)
+		}},
+		{"sorted sets", 0, func(c *Cache, i int) {
+			members := make([]ScoredMember, 8)
+			for j := range members {
+				members[j] = ScoredMember{Member: fmt.Sprintf("member:%d", j), Score: float64(j)}
+			}
+			if _, _, err := c.ZAdd(ctx, fmt.Sprintf("key:%d", i), members, ZAddOptions{}); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.ShardCount = 1
+			cfg.MaxKeys = 2 * memoryTestEntries
+			cfg.MaxMemory = 1 << 40
+			cfg.DefaultTTL = tt.ttl
+			c, err := NewCacheFromConfig(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			heap := heapGrowth(func() {
+				for i := 0; i < memoryTestEntries; i++ {
+					tt.set(c, i)
+				}
+			})
+			used := c.UsedMemory()
+			runtime.KeepAlive(c)
+
+			ratio := float64(used) / float64(heap)
+			t.Logf("accounted %d bytes for %d bytes of heap (%.2f), %d per entry",
+				used, heap, ratio, used/memoryTestEntries)
+			if ratio < 0.8 || ratio > 1.25 {
+				t.Errorf("accounted %d bytes, but the heap grew by %d", used, heap)
+			}
+		})
+	}
+}
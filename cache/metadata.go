@@ -0,0 +1,110 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers carrying entry metadata on HTTP reads of a key
+const (
+	CreatedAtHeader    = "X-Cache-Created-At"
+	LastAccessedHeader = "X-Cache-Last-Accessed"
+	AccessCountHeader  = "X-Cache-Access-Count"
+	TTLHeader          = "X-Cache-TTL-Ms"
+	SizeHeader         = "X-Cache-Size"
+	OriginHeader       = "X-Cache-Origin"
+	VersionHeader      = "X-Cache-Version"
+)
+
+// EntryMetadata describes a key without its value
+type EntryMetadata struct {
+	Type         string    `json:"type"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastAccessed time.Time `json:"last_accessed"`
+	AccessCount  int64     `json:"access_count"`
+	// TTLMillis is the time left to live, or -1 for none
+	TTLMillis int64  `json:"ttl_ms"`
+	Size      int64  `json:"size"`
+	Origin    string `json:"origin,omitempty"`
+	// Version changes whenever the value is written; see CHECKMSET
+	Version uint64 `json:"version"`
+}
+
+// Metadata describes the entry at key. Reading it is not an access: it
+// neither counts nor extends a sliding TTL.
+func (c *Cache) Metadata(key string) (EntryMetadata, bool) {
+	s := c.shardFor(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	now := c.now()
+	entry, ok := s.data[key]
+	if !ok || entry.isExpired(now) {
+		return EntryMetadata{}, false
+	}
+	meta := EntryMetadata{
+		Type:         "string",
+		CreatedAt:    entry.CreatedAt,
+		LastAccessed: entry.LastAccessed,
+		AccessCount:  entry.AccessCount,
+		TTLMillis:    -1,
+		Size:         entry.memory,
+		Origin:       entry.Origin,
+		Version:      entry.version,
+	}
+	if entry.Object != nil {
+		meta.Type = entry.Object.TypeName()
+	}
+	if entry.ExpiresAt != nil {
+		meta.TTLMillis = entry.ExpiresAt.Sub(now).Milliseconds()
+	}
+	return meta, true
+}
+
+// WriteHeaders sets the metadata headers of an HTTP read
+func (m EntryMetadata) WriteHeaders(h http.Header) {
+	h.Set(CreatedAtHeader, m.CreatedAt.UTC().Format(time.RFC3339Nano))
+	h.Set(LastAccessedHeader, m.LastAccessed.UTC().Format(time.RFC3339Nano))
+	h.Set(AccessCountHeader, strconv.FormatInt(m.AccessCount, 10))
+	h.Set(TTLHeader, strconv.FormatInt(m.TTLMillis, 10))
+	h.Set(SizeHeader, strconv.FormatInt(m.Size, 10))
+	h.Set(VersionHeader, strconv.FormatUint(m.Version, 10))
+	if m.Origin != "" {
+		h.Set(OriginHeader, m.Origin)
+	}
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "METADATA", Arity: 2, Flags: FlagReadOnly, Handler: metadataCommand, KeyArgs: firstKeyArg},
+	)
+}
+
+// metadataCommand implements METADATA key, replying with field/value
+// pairs, or null for a missing key. Times are Unix milliseconds.
+func metadataCommand(ctx *CommandContext) error {
+	meta, ok := ctx.Cache.Metadata(string(ctx.Args[1]))
+	if !ok {
+		ctx.Out.WriteNull()
+		return nil
+	}
+	ctx.Out.WriteArrayHeader(16)
+	ctx.Out.WriteBulkString("type")
+	ctx.Out.WriteBulkString(meta.Type)
+	ctx.Out.WriteBulkString("created_at")
+	ctx.Out.WriteInteger(meta.CreatedAt.UnixMilli())
+	ctx.Out.WriteBulkString("last_accessed")
+	ctx.Out.WriteInteger(meta.LastAccessed.UnixMilli())
+	ctx.Out.WriteBulkString("access_count")
+	ctx.Out.WriteInteger(meta.AccessCount)
+	ctx.Out.WriteBulkString("ttl_ms")
+	ctx.Out.WriteInteger(meta.TTLMillis)
+	ctx.Out.WriteBulkString("size")
+	ctx.Out.WriteInteger(meta.Size)
+	ctx.Out.WriteBulkString("origin")
+	ctx.Out.WriteBulkString(meta.Origin)
+	ctx.Out.WriteBulkString("version")
+	ctx.Out.WriteBulkString(strconv.FormatUint(meta.Version, 10))
+	return nil
+}
@@ -0,0 +1,382 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/client"
+)
+
+// Mirror tuning
+const (
+	mirrorReadBatch = 512
+	mirrorRetryMin  = 100 * time.Millisecond
+	mirrorRetryMax  = 10 * time.Second
+)
+
+// MirrorStats describes how far a mirror is behind its source
+type MirrorStats struct {
+	Target string `json:"target"`
+	// Seq is the WAL sequence number of the last write forwarded
+	Seq uint64 `json:"seq"`
+	// LagRecords is how many logged writes have not been forwarded yet
+	LagRecords uint64 `json:"lag_records"`
+	// LagSeconds is how long the oldest unforwarded write has been waiting
+	LagSeconds float64 `json:"lag_seconds"`
+	Forwarded  int64   `json:"forwarded"`
+	// Rejected writes were refused by the target and skipped
+	Rejected int64 `json:"rejected"`
+	Errors   int64 `json:"errors"`
+	// Backfilling is set while existing keys are being copied, and
+	// Backfilled counts the keys copied by the last backfill
+	Backfilling  bool      `json:"backfilling"`
+	Backfilled   int64     `json:"backfilled"`
+	LastBackfill time.Time `json:"last_backfill"`
+	// Version is the protocol version negotiated with the target
+	Version int `json:"version,omitempty"`
+	// Stopped holds the reason the mirror gave up, such as an
+	// incompatible target version
+	Stopped string `json:"stopped,omitempty"`
+}
+
+// Mirror asynchronously forwards every write logged by a cache's WAL to
+// another cluster, so clients can be cut over to it once it has caught up.
+// A backfill first copies the keys that already exist; writes made while
+// it runs are forwarded after it, so the target converges on the source.
+// If the mirror falls behind the WAL backlog, it backfills again.
+type Mirror struct {
+	cache  *Cache
+	wal    *WAL
+	target *client.Client
+	name   string
+	logger *log.Logger
+
+	mu    sync.Mutex
+	stats MirrorStats
+	// behindSince is when the oldest unforwarded write was first seen
+	behindSince time.Time
+	// backfillStart is when the running backfill began
+	backfillStart time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartMirror starts forwarding writes from c to the cluster at addresses,
+// after copying the existing keys when backfill is set
+func StartMirror(c *Cache, addresses []string, backfill bool, logger *log.Logger) (*Mirror, error) {
+	target, err := client.NewClient(&client.Options{Addresses: addresses})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Mirror{
+		cache:  c,
+		wal:    c.EnableWAL(0),
+		target: target,
+		name:   strings.Join(addresses, ","),
+		logger: logger,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	m.stats.Target = m.name
+	go m.run(ctx, backfill)
+	return m, nil
+}
+
+// Target returns the addresses the mirror writes to, comma separated
+func (m *Mirror) Target() string {
+	return m.name
+}
+
+// Stats returns the mirror's progress and lag
+func (m *Mirror) Stats() MirrorStats {
+	seq := m.wal.Stats().Seq
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := m.stats
+	if seq > stats.Seq {
+		stats.LagRecords = seq - stats.Seq
+		if !m.behindSince.IsZero() {
+			stats.LagSeconds = time.Since(m.behindSince).Seconds()
+		}
+	}
+	return stats
+}
+
+// Close stops forwarding. Writes not yet forwarded are not sent.
+func (m *Mirror) Close() error {
+	m.cancel()
+	<-m.done
+	return m.target.Close()
+}
+
+func (m *Mirror) run(ctx context.Context, backfill bool) {
+	defer close(m.done)
+
+	peer, ok := m.handshake(ctx)
+	if !ok {
+		return
+	}
+	if peer.HasFeature("replstatus") {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.reportLag(ctx)
+		}()
+		defer wg.Wait()
+	}
+	seq := m.wal.Stats().Seq
+	m.setSeq(seq)
+	if backfill && !m.backfill(ctx) {
+		return
+	}
+
+	for {
+		records, err := m.wal.ReadFrom(seq, mirrorReadBatch)
+		if errors.Is(err, ErrWALTruncated) {
+			m.logger.Printf("Mirror to %s fell behind the replication backlog, backfilling", m.name)
+			seq = m.wal.Stats().Seq
+			m.setSeq(seq)
+			if !m.backfill(ctx) {
+				return
+			}
+			continue
+		}
+		if len(records) == 0 {
+			if m.wal.Wait(ctx, seq) != nil {
+				return
+			}
+			m.mu.Lock()
+			m.behindSince = time.Now()
+			m.mu.Unlock()
+			continue
+		}
+
+		for _, rec := range records {
+			if !m.forward(ctx, rec.Args) {
+				return
+			}
+			seq = rec.Seq
+			m.setSeq(seq)
+		}
+	}
+}
+
+// handshake negotiates the protocol version with the target, retrying
+// until it answers. It returns false if the target is incompatible or ctx
+// is done.
+func (m *Mirror) handshake(ctx context.Context) (PeerVersion, bool) {
+	backoff := mirrorRetryMin
+	for {
+		peer, err := negotiateVersion(ctx, m.target, m.cache.NodeID())
+		if err == nil {
+			m.mu.Lock()
+			m.stats.Version = peer.Version
+			m.mu.Unlock()
+			return peer, true
+		}
+		if errors.Is(err, ErrIncompatibleVersion) {
+			m.logger.Printf("!!! Mirror to %s stopped: %v", m.name, err)
+			m.mu.Lock()
+			m.stats.Stopped = err.Error()
+			m.mu.Unlock()
+			return peer, false
+		}
+
+		m.logger.Printf("Mirror to %s handshake failed: %v", m.name, err)
+		select {
+		case <-ctx.Done():
+			return peer, false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > mirrorRetryMax {
+			backoff = mirrorRetryMax
+		}
+	}
+}
+
+// setSeq records the last forwarded write
+func (m *Mirror) setSeq(seq uint64) {
+	caughtUp := m.wal.Stats().Seq <= seq
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stats.Seq = seq
+	if caughtUp {
+		m.behindSince = time.Time{}
+	} else if m.behindSince.IsZero() {
+		m.behindSince = time.Now()
+	}
+}
+
+// forward sends one command, retrying with backoff until the target
+// answers. Commands the target rejects are logged and skipped. It returns
+// false when ctx is done.
+func (m *Mirror) forward(ctx context.Context, args [][]byte) bool {
+	cmd := make([]interface{}, len(args))
+	for i, arg := range args {
+		cmd[i] = arg
+	}
+
+	backoff := mirrorRetryMin
+	for {
+		_, err := m.target.Do(ctx, cmd...)
+		var rejected client.Error
+		switch {
+		case err == nil:
+			m.mu.Lock()
+			m.stats.Forwarded++
+			m.mu.Unlock()
+			return true
+		case errors.As(err, &rejected):
+			m.logger.Printf("Mirror to %s rejected %s: %v", m.name, args[0], err)
+			m.mu.Lock()
+			m.stats.Rejected++
+			m.mu.Unlock()
+			return true
+		}
+
+		m.logger.Printf("Mirror to %s failed: %v", m.name, err)
+		m.mu.Lock()
+		m.stats.Errors++
+		m.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > mirrorRetryMax {
+			backoff = mirrorRetryMax
+		}
+	}
+}
+
+// backfill copies every live string entry to the target with its TTL,
+// tags and flags. It returns false when ctx is done.
+func (m *Mirror) backfill(ctx context.Context) bool {
+	m.cache.rlockAll()
+	entries, err := m.cache.collectEntries(time.Now())
+	m.cache.runlockAll()
+	if err != nil {
+		m.logger.Printf("Mirror to %s cannot backfill: %v", m.name, err)
+		return true
+	}
+	skipped := 0
+	for _, se := range entries {
+		if se.object {
+			skipped++
+		}
+	}
+
+	m.mu.Lock()
+	m.stats.Backfilling = true
+	m.stats.Backfilled = 0
+	m.backfillStart = time.Now()
+	m.mu.Unlock()
+	if skipped > 0 {
+		m.logger.Printf("Mirror to %s backfill skips %d keys that are not strings", m.name, skipped)
+	}
+
+	start := time.Now()
+	for _, se := range entries {
+		if se.object {
+			continue
+		}
+		args, ok := mirrorSetArgs(se)
+		if !ok {
+			continue
+		}
+		if !m.forward(ctx, args) {
+			return false
+		}
+		m.mu.Lock()
+		m.stats.Backfilled++
+		m.mu.Unlock()
+	}
+
+	m.mu.Lock()
+	m.stats.Backfilling = false
+	m.stats.LastBackfill = time.Now()
+	m.mu.Unlock()
+	m.logger.Printf("Mirror to %s backfilled %d keys in %v", m.name, len(entries), time.Since(start))
+	return true
+}
+
+// reportLag tells the target how far behind it is every
+// replicaHeartbeatInterval, so it can bound the staleness of its reads.
+// While backfilling, the target is as stale as the backfill is old.
+func (m *Mirror) reportLag(ctx context.Context) {
+	ticker := time.NewTicker(replicaHeartbeatInterval)
+	defer ticker.Stop()
+
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		stats := m.Stats()
+		lag := time.Duration(stats.LagSeconds * float64(time.Second))
+		m.mu.Lock()
+		if stats.Backfilling {
+			if since := time.Since(m.backfillStart); since > lag {
+				lag = since
+			}
+		}
+		m.mu.Unlock()
+		status := replicationStatusMessage{
+			PrimaryID:     m.cache.NodeID(),
+			Offset:        stats.Seq,
+			PrimaryOffset: stats.Seq + stats.LagRecords,
+			LagMillis:     uint64(lag / time.Millisecond),
+		}
+		_, err := m.target.Do(ctx, "NODE.REPLSTATUS", marshalMessage(&status))
+		// Log only the first of a run of failures; forward logs the rest
+		if err != nil && !failing && ctx.Err() == nil {
+			m.logger.Printf("Mirror to %s cannot report lag: %v", m.name, err)
+		}
+		failing = err != nil
+	}
+}
+
+// mirrorSetArgs builds the SET command recreating se. It returns false for
+// an entry that has expired since it was copied.
+func mirrorSetArgs(se snapshotEntry) ([][]byte, bool) {
+	args := [][]byte{[]byte("SET"), []byte(se.key), se.value}
+	if se.expiresAt > 0 {
+		remaining := se.expiresAt - time.Now().UnixNano()/int64(time.Millisecond)
+		if se.sliding > 0 {
+			remaining = int64(se.sliding / time.Millisecond)
+		}
+		if remaining <= 0 {
+			return nil, false
+		}
+		args = append(args, []byte("PX"), []byte(strconv.FormatInt(remaining, 10)))
+		if se.sliding > 0 {
+			args = append(args, []byte("SLIDING"))
+		}
+	}
+	if se.pinned {
+		args = append(args, []byte("PIN"))
+	}
+	if se.cost > 0 {
+		args = append(args, []byte("COST"), []byte(strconv.FormatInt(se.cost, 10)))
+	}
+	if len(se.tags) > 0 {
+		args = append(args, []byte("TAGS"), []byte(strings.Join(se.tags, ",")))
+	}
+	return args, true
+}
@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// NamespaceSeparator splits a key into its namespace and the remainder,
+// so "user:123" belongs to namespace "user"
+const NamespaceSeparator = ":"
+
+// NamespaceOf returns the namespace of a key, or "" if it has none
+func NamespaceOf(key string) string {
+	if i := strings.Index(key, NamespaceSeparator); i >= 0 {
+		return key[:i]
+	}
+	return ""
+}
+
+// NamespaceOptions holds settings applied to every key in a namespace
+type NamespaceOptions struct {
+	// DefaultTTL applies to writes without an explicit TTL, overriding the
+	// cache-wide default
+	DefaultTTL time.Duration `json:"default_ttl" toml:"default_ttl" yaml:"default_ttl"`
+	// MinTTL and MaxTTL clamp TTLs given by clients. A MaxTTL also bounds
+	// writes that would otherwise never expire.
+	MinTTL time.Duration `json:"min_ttl" toml:"min_ttl" yaml:"min_ttl"`
+	MaxTTL time.Duration `json:"max_ttl" toml:"max_ttl" yaml:"max_ttl"`
+	// SlidingExpiration makes reads extend the TTL of keys written with one,
+	// as if SetOptions.Sliding had been given
+	SlidingExpiration bool `json:"sliding_expiration" toml:"sliding_expiration" yaml:"sliding_expiration"`
+	// Origin, when set, fills misses in the namespace from an HTTP API
+	Origin *OriginConfig `json:"origin,omitempty" toml:"origin,omitempty" yaml:"origin,omitempty"`
+	// Keys normalizes the namespace's keys before commands see them
+	Keys KeyNormalization `json:"keys" toml:"keys" yaml:"keys"`
+	// RefreshAhead, when set, fetches keys from Origin again in the
+	// background once a read finds less than this fraction of their TTL
+	// left; see REFRESHAHEAD
+	RefreshAhead float64 `json:"refresh_ahead" toml:"refresh_ahead" yaml:"refresh_ahead"`
+}
+
+// KeyNormalization rewrites keys so that spellings clients consider the
+// same reach the same entry. It applies to protocol commands that declare
+// their key arguments and to the HTTP key API. A key is normalized by the
+// settings of its namespace, or if that has none, by those of the
+// namespace it names once trimmed and lower-cased, so "USER:1" finds the
+// settings of "user".
+type KeyNormalization struct {
+	// FoldCase lower-cases keys
+	FoldCase bool `json:"fold_case" toml:"fold_case" yaml:"fold_case"`
+	// TrimSpace removes leading and trailing white space
+	TrimSpace bool `json:"trim_space" toml:"trim_space" yaml:"trim_space"`
+	// MaxKeyLength refuses longer keys, in bytes after normalization. Zero
+	// means no limit.
+	MaxKeyLength int `json:"max_key_length" toml:"max_key_length" yaml:"max_key_length"`
+}
+
+// enabled reports whether the settings change or refuse any key
+func (n KeyNormalization) enabled() bool {
+	return n.FoldCase || n.TrimSpace || n.MaxKeyLength > 0
+}
+
+// apply normalizes key, a key of namespace ns
+func (n KeyNormalization) apply(ns, key string) (string, error) {
+	if n.TrimSpace {
+		key = strings.TrimSpace(key)
+	}
+	if n.FoldCase {
+		key = strings.ToLower(key)
+	}
+	if n.MaxKeyLength > 0 && len(key) > n.MaxKeyLength {
+		return "", fmt.Errorf("ERR key is longer than the %d bytes namespace '%s' allows", n.MaxKeyLength, ns)
+	}
+	return key, nil
+}
+
+// Validate checks that the TTL bounds are consistent
+func (o NamespaceOptions) Validate() error {
+	if o.DefaultTTL < 0 || o.MinTTL < 0 || o.MaxTTL < 0 {
+		return fmt.Errorf("TTLs cannot be negative")
+	}
+	if o.MaxTTL > 0 && o.MinTTL > o.MaxTTL {
+		return fmt.Errorf("min TTL %v exceeds max TTL %v", o.MinTTL, o.MaxTTL)
+	}
+	if o.Keys.MaxKeyLength < 0 {
+		return fmt.Errorf("max key length cannot be negative")
+	}
+	if o.RefreshAhead < 0 || o.RefreshAhead >= 1 {
+		return fmt.Errorf("refresh-ahead fraction must be between 0 and 1")
+	}
+	if o.Origin != nil {
+		return o.Origin.Validate()
+	}
+	return nil
+}
+
+// SetNamespaceOptions configures a namespace. Keys already stored keep the
+// settings they were written with.
+func (c *Cache) SetNamespaceOptions(ns string, opts NamespaceOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	namespaces := make(map[string]NamespaceOptions, len(*c.namespaces.Load())+1)
+	for name, o := range *c.namespaces.Load() {
+		namespaces[name] = o
+	}
+	namespaces[ns] = opts
+	c.namespaces.Store(&namespaces)
+	c.hits.countNamespace(ns)
+	if opts.Keys.enabled() {
+		c.normalizing.Store(true)
+	}
+	if opts.RefreshAhead > 0 {
+		c.refreshingAhead.Store(true)
+	}
+	return nil
+}
+
+// namespaceOptions returns the settings of namespace ns, reporting false
+// if it has none
+func (c *Cache) namespaceOptions(ns string) (NamespaceOptions, bool) {
+	opts, ok := (*c.namespaces.Load())[ns]
+	return opts, ok
+}
+
+// NormalizeKey applies the key normalization of key's namespace
+func (c *Cache) NormalizeKey(key string) (string, error) {
+	if !c.normalizing.Load() {
+		return key, nil
+	}
+
+	ns := NamespaceOf(key)
+	opts, ok := c.namespaceOptions(ns)
+	if !ok || !opts.Keys.enabled() {
+		ns = NamespaceOf(strings.ToLower(strings.TrimSpace(key)))
+		opts, _ = c.namespaceOptions(ns)
+	}
+	return opts.Keys.apply(ns, key)
+}
+
+// NormalizeArgs rewrites the key arguments of cmd in args, if it declares
+// them, with their namespace's key normalization
+func (c *Cache) NormalizeArgs(cmd *Command, args [][]byte) error {
+	if cmd.KeyArgs == nil || !c.normalizing.Load() {
+		return nil
+	}
+	for _, pos := range cmd.KeyArgs(args) {
+		key, err := c.NormalizeKey(string(args[pos]))
+		if err != nil {
+			return err
+		}
+		if key != string(args[pos]) {
+			args[pos] = []byte(key)
+		}
+	}
+	return nil
+}
+
+// KeyNormalizationMiddleware rewrites the key arguments of commands that
+// declare them with their namespace's key normalization
+func KeyNormalizationMiddleware(next CommandHandler) CommandHandler {
+	return func(ctx *CommandContext) error {
+		if err := ctx.Cache.NormalizeArgs(ctx.Command, ctx.Args); err != nil {
+			return err
+		}
+		return next(ctx)
+	}
+}
+
+// effectiveTTL applies the default TTL and the namespace bounds to the TTL
+// requested for key
+func (c *Cache) effectiveTTL(key string, ttl *time.Duration) *time.Duration {
+	opts, _ := c.namespaceOptions(NamespaceOf(key))
+
+	if ttl == nil {
+		switch {
+		case opts.DefaultTTL > 0:
+			ttl = &opts.DefaultTTL
+		case c.defaultTTL > 0:
+			ttl = &c.defaultTTL
+		case opts.MaxTTL > 0:
+			ttl = &opts.MaxTTL
+		default:
+			return nil
+		}
+	}
+
+	d := *ttl
+	if opts.MinTTL > 0 && d < opts.MinTTL {
+		d = opts.MinTTL
+	}
+	if opts.MaxTTL > 0 && d > opts.MaxTTL {
+		d = opts.MaxTTL
+	}
+	return &d
+}
@@ -0,0 +1,156 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrWrongType is returned when an operation targets a key holding a value
+// of another type
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// ErrNoSuchKey is returned when an operation requires an existing key
+var ErrNoSuchKey = errors.New("ERR no such key")
+
+// cacheObject is implemented by non-string values stored in CacheEntry.Object
+type cacheObject interface {
+	// TypeName is the name reported by the TYPE command
+	TypeName() string
+}
+
+// typeName returns the name of the entry's value type
+func (e *CacheEntry) typeName() string {
+	if e.Object != nil {
+		return e.Object.TypeName()
+	}
+	return "string"
+}
+
+// isExpired reports whether the entry's TTL has passed
+func (e *CacheEntry) isExpired(now time.Time) bool {
+	return e.ExpiresAt != nil && now.After(*e.ExpiresAt)
+}
+
+// lookupLive returns the entry for key, reclaiming it first if it has
+// expired
+func (s *cacheShard) lookupLive(key string) *CacheEntry {
+	entry, exists := s.data[key]
+	if !exists {
+		return nil
+	}
+	if entry.isExpired(s.cache.now()) {
+		s.dropEntry(entry, RemovalExpired)
+		return nil
+	}
+	return entry
+}
+
+// touchEntry records an access to entry
+func (s *cacheShard) touchEntry(entry *CacheEntry) {
+	now := s.cache.now()
+	entry.AccessCount++
+	entry.LastAccessed = now
+	if !entry.Pinned {
+		s.policy.Access(entry)
+	}
+	s.cache.refreshSliding(entry, now)
+	s.cache.traceAccess(entry.Key, entry.cost(), false)
+}
+
+// Type returns the type of the value stored at key, or "none"
+func (c *Cache) Type(key string) string {
+	s := c.shardFor(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entry, exists := s.data[key]
+	if !exists || entry.isExpired(c.now()) {
+		return "none"
+	}
+	return entry.typeName()
+}
+
+// liveObject returns the object of type T stored at key and its entry, or a
+// nil entry if the key is missing. Callers hold the write lock of the
+// shard.
+func liveObject[T cacheObject](s *cacheShard, key string) (T, *CacheEntry, error) {
+	var zero T
+	entry := s.lookupLive(key)
+	if entry == nil {
+		return zero, nil, nil
+	}
+	obj, ok := entry.Object.(T)
+	if !ok {
+		return zero, nil, ErrWrongType
+	}
+	return obj, entry, nil
+}
+
+// storeObject adds a new object entry at key. Callers have checked the key
+// is free.
+func (s *cacheShard) storeObject(key string, obj cacheObject) {
+	now := s.cache.now()
+	s.insertEntry(&CacheEntry{
+		Key:          key,
+		Object:       obj,
+		CreatedAt:    now,
+		LastAccessed: now,
+	})
+}
+
+// updateObject runs fn on the object of type T stored at key while holding
+// the write lock of its shard. If the key is missing and create is non-nil,
+// a new object is created and stored first; otherwise ErrNoSuchKey is
+// returned.
+func updateObject[T cacheObject](c *Cache, key string, create func() (T, error), fn func(obj T) error) error {
+	s := c.shardFor(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	obj, entry, err := liveObject[T](s, key)
+	if err != nil {
+		return err
+	}
+	if err := c.admitWrite(0); err != nil {
+		return err
+	}
+	if entry == nil {
+		if create == nil {
+			return ErrNoSuchKey
+		}
+		if obj, err = create(); err != nil {
+			return err
+		}
+		if err := fn(obj); err != nil {
+			return err
+		}
+		s.storeObject(key, obj)
+		return nil
+	}
+
+	if err := fn(obj); err != nil {
+		return err
+	}
+	s.touchEntry(entry)
+	s.recharge(entry)
+	c.notifier.publish(KeyEventSet, key)
+	return nil
+}
+
+// viewObject runs fn on the object of type T stored at key while holding
+// the read lock of its shard, and reports whether the key exists
+func viewObject[T cacheObject](c *Cache, key string, fn func(obj T) error) (bool, error) {
+	s := c.shardFor(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entry, exists := s.data[key]
+	if !exists || entry.isExpired(c.now()) {
+		return false, nil
+	}
+	obj, ok := entry.Object.(T)
+	if !ok {
+		return true, ErrWrongType
+	}
+	return true, fn(obj)
+}
@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Origin tuning
+const (
+	defaultOriginTimeout = 5 * time.Second
+	// maxOriginValueSize bounds a value fetched from an origin
+	maxOriginValueSize = 64 * 1024 * 1024
+)
+
+// ErrOriginUnavailable wraps failures to fetch a value from an origin
+var ErrOriginUnavailable = errors.New("origin unavailable")
+
+// OriginConfig makes a namespace a pull-through cache for an HTTP API.
+// URL is a template in which {key} is replaced by the whole key and {id}
+// by the part after the namespace, both path-escaped, so
+// "http://users.internal/v1/users/{id}" serves "user:123" from
+// /v1/users/123.
+type OriginConfig struct {
+	URL     string        `json:"url" toml:"url" yaml:"url"`
+	Timeout time.Duration `json:"timeout" toml:"timeout" yaml:"timeout"`
+	// Headers are sent with every request, for example for authentication
+	Headers map[string]string `json:"headers" toml:"headers" yaml:"headers"`
+	// HonorCacheControl takes the TTL from the response's max-age and does
+	// not store responses marked no-store or no-cache. Otherwise the
+	// namespace's TTL settings apply.
+	HonorCacheControl bool `json:"honor_cache_control" toml:"honor_cache_control" yaml:"honor_cache_control"`
+}
+
+// Validate checks the URL template
+func (o *OriginConfig) Validate() error {
+	if o.URL == "" {
+		return fmt.Errorf("origin URL required")
+	}
+	if _, err := url.Parse(o.expand("key", "id")); err != nil {
+		return fmt.Errorf("invalid origin URL: %w", err)
+	}
+	if o.Timeout < 0 {
+		return fmt.Errorf("origin timeout cannot be negative")
+	}
+	return nil
+}
+
+// expand fills in the URL template
+func (o *OriginConfig) expand(key, id string) string {
+	return strings.NewReplacer("{key}", url.PathEscape(key), "{id}", url.PathEscape(id)).Replace(o.URL)
+}
+
+// originFetch is a fetch in progress, shared by concurrent misses on a key
+type originFetch struct {
+	done  chan struct{}
+	value []byte
+	found bool
+	err   error
+}
+
+// originFetches deduplicates concurrent fetches of the same key
+type originFetches struct {
+	mu       sync.Mutex
+	client   *http.Client
+	inflight map[string]*originFetch
+}
+
+func newOriginFetches() *originFetches {
+	return &originFetches{client: &http.Client{}, inflight: make(map[string]*originFetch)}
+}
+
+// GetOrFetch returns key's value, fetching and storing it from its
+// namespace's origin on a miss. found is false when the key is missing
+// and either has no origin or the origin does not have it either.
+func (c *Cache) GetOrFetch(ctx context.Context, key string) ([]byte, bool, error) {
+	if value, ok := c.Get(ctx, key); ok {
+		return value, true, nil
+	}
+
+	origin := c.originFor(key)
+	if origin == nil || c.Type(key) != "none" {
+		return nil, false, nil
+	}
+
+	f := c.origins
+	f.mu.Lock()
+	fetch, ok := f.inflight[key]
+	if !ok {
+		fetch = &originFetch{done: make(chan struct{})}
+		f.inflight[key] = fetch
+		f.mu.Unlock()
+
+		fetch.value, fetch.found, fetch.err = c.fetchOrigin(ctx, f.client, origin, key)
+		f.mu.Lock()
+		delete(f.inflight, key)
+		f.mu.Unlock()
+		close(fetch.done)
+	} else {
+		f.mu.Unlock()
+	}
+
+	select {
+	case <-fetch.done:
+		return fetch.value, fetch.found, fetch.err
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
+
+// RefreshFromOrigin fetches key from its namespace's origin and stores it,
+// replacing any cached value. found is false when the key has no origin
+// or the origin does not have it.
+func (c *Cache) RefreshFromOrigin(ctx context.Context, key string) (bool, error) {
+	origin := c.originFor(key)
+	if origin == nil {
+		return false, nil
+	}
+	_, found, err := c.fetchOrigin(ctx, c.origins.client, origin, key)
+	return found, err
+}
+
+// originFor returns the origin of key's namespace, nil if it has none
+func (c *Cache) originFor(key string) *OriginConfig {
+	opts, _ := c.namespaceOptions(NamespaceOf(key))
+	return opts.Origin
+}
+
+// fetchOrigin requests key from origin and stores the response
+func (c *Cache) fetchOrigin(ctx context.Context, client *http.Client, origin *OriginConfig, key string) ([]byte, bool, error) {
+	timeout := origin.Timeout
+	if timeout == 0 {
+		timeout = defaultOriginTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	id := strings.TrimPrefix(key, NamespaceOf(key)+NamespaceSeparator)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin.expand(key, id), nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrOriginUnavailable, err)
+	}
+	for name, value := range origin.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrOriginUnavailable, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, false, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, fmt.Errorf("%w: unexpected status %s", ErrOriginUnavailable, resp.Status)
+	}
+
+	value, err := io.ReadAll(io.LimitReader(resp.Body, maxOriginValueSize+1))
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrOriginUnavailable, err)
+	}
+	if len(value) > maxOriginValueSize {
+		return nil, false, fmt.Errorf("%w: value exceeds %d bytes", ErrOriginUnavailable, maxOriginValueSize)
+	}
+
+	var opts SetOptions
+	if origin.HonorCacheControl {
+		ttl, store := cacheControlTTL(resp.Header.Get("Cache-Control"))
+		if !store {
+			return value, true, nil
+		}
+		opts.TTL = ttl
+	}
+	// A value the cache cannot hold is still served
+	c.SetWithOptions(ctx, key, value, opts)
+	return value, true, nil
+}
+
+// cacheControlTTL reads the TTL from a Cache-Control header, preferring
+// s-maxage as a shared cache should. store is false for no-store,
+// no-cache, private and a zero max-age.
+func cacheControlTTL(header string) (ttl *time.Duration, store bool) {
+	var maxAge, sMaxAge *time.Duration
+	for _, directive := range strings.Split(header, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache", "private":
+			return nil, false
+		case "max-age", "s-maxage":
+			seconds, err := strconv.ParseInt(strings.Trim(value, `"`), 10, 64)
+			if err != nil || seconds < 0 {
+				continue
+			}
+			d := time.Duration(seconds) * time.Second
+			if strings.EqualFold(name, "s-maxage") {
+				sMaxAge = &d
+			} else {
+				maxAge = &d
+			}
+		}
+	}
+	if sMaxAge != nil {
+		maxAge = sMaxAge
+	}
+	if maxAge != nil && *maxAge == 0 {
+		return nil, false
+	}
+	return maxAge, true
+}
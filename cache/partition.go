@@ -0,0 +1,211 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hamisionesmus/distributed-cache/client"
+	"github.com/hamisionesmus/distributed-cache/internal/keyhash"
+)
+
+// defaultVirtualNodes is how many ring points a node gets by default
+const defaultVirtualNodes = 160
+
+// errCrossNode is returned for commands whose keys have different owners
+var errCrossNode = errors.New("CROSSSLOT keys in request don't hash to the same node")
+
+// partitionPoint is a position on the ring owned by a node
+type partitionPoint struct {
+	hash uint64
+	addr string
+}
+
+// hashRing maps keys to the address of the node owning them. Each node
+// owns the keys hashing up to its points, so a node joining or leaving
+// only moves the keys next to its own.
+type hashRing struct {
+	points []partitionPoint
+	hash   func([]byte) uint64
+}
+
+// newHashRing places each of addrs on the ring at vnodes points
+func newHashRing(addrs []string, vnodes int) *hashRing {
+	hash := keyhash.XXHash64
+	r := &hashRing{points: make([]partitionPoint, 0, len(addrs)*vnodes), hash: hash}
+	for _, addr := range addrs {
+		for i := 0; i < vnodes; i++ {
+			r.points = append(r.points, partitionPoint{hash: hash([]byte(addr + "-" + strconv.Itoa(i))), addr: addr})
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool {
+		if r.points[i].hash != r.points[j].hash {
+			return r.points[i].hash < r.points[j].hash
+		}
+		return r.points[i].addr < r.points[j].addr
+	})
+	return r
+}
+
+// owner returns the address of the node owning key, or "" if the ring is
+// empty
+func (r *hashRing) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := r.hash([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].addr
+}
+
+// partitionRing returns the ring of the current members, building it again
+// if membership changed since it was last built
+func (cl *Cluster) partitionRing() *hashRing {
+	cl.mu.RLock()
+	ring := cl.ring
+	cl.mu.RUnlock()
+	if ring != nil {
+		return ring
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if cl.ring == nil {
+		var addrs []string
+		if cl.self.Role != RoleWitness {
+			addrs = append(addrs, cl.self.Addr)
+		}
+		for addr, node := range cl.nodes {
+			owns := node.State == NodeOnline || node.State == NodeSuspect
+			if owns && node.Role != RoleWitness && (PeerVersion{Features: node.Features}).HasFeature("partition") {
+				addrs = append(addrs, addr)
+			}
+		}
+		vnodes := cl.cfg.VirtualNodes
+		if vnodes <= 0 {
+			vnodes = defaultVirtualNodes
+		}
+		cl.ring = newHashRing(addrs, vnodes)
+	}
+	return cl.ring
+}
+
+// Owner returns the address of the node owning key, which is this node's
+// when partitioning is off
+func (cl *Cluster) Owner(key string) string {
+	if !cl.cfg.Partition {
+		return cl.self.Addr
+	}
+	if owner := cl.partitionRing().owner(key); owner != "" {
+		return owner
+	}
+	return cl.self.Addr
+}
+
+// forwarder returns the client forwarding commands to the node at addr.
+// Forwarded commands may block, so they do not share the probes' client
+// and wait for replies as long as their context allows.
+func (cl *Cluster) forwarder(addr string) (*client.Client, error) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if c, ok := cl.forwarders[addr]; ok {
+		return c, nil
+	}
+	c, err := client.NewClient(&client.Options{
+		Addresses:   []string{addr},
+		DialTimeout: cl.cfg.ProbeTimeout,
+		ReadTimeout: -1,
+		Username:    cl.cfg.Username,
+		Password:    cl.cfg.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	cl.forwarders[addr] = c
+	return c, nil
+}
+
+// forward runs ctx's command on the node at addr and relays its reply
+func (cl *Cluster) forward(ctx *CommandContext, addr string) error {
+	c, err := cl.forwarder(addr)
+	if err != nil {
+		return fmt.Errorf("CLUSTERDOWN cannot reach the owner %s: %v", addr, err)
+	}
+	var user string
+	if ctx.Client != nil {
+		user = ctx.Client.User()
+	}
+	args := make([]interface{}, 0, len(ctx.Args)+2)
+	args = append(args, "NODE.FORWARD", user)
+	for _, arg := range ctx.Args {
+		args = append(args, arg)
+	}
+
+	reply, err := c.Do(ctx.Context, args...)
+	var replyErr client.Error
+	switch {
+	case errors.As(err, &replyErr):
+		return errors.New(string(replyErr))
+	case errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled):
+		return err
+	case err != nil:
+		return fmt.Errorf("CLUSTERDOWN cannot reach the owner %s: %v", addr, err)
+	}
+	writeForwardedReply(ctx.Out, reply)
+	return nil
+}
+
+// writeForwardedReply writes a reply read by the client package back out
+func writeForwardedReply(out *RESPWriter, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		out.WriteNull()
+	case string:
+		out.WriteSimpleString(v)
+	case []byte:
+		out.WriteBulk(v)
+	case int64:
+		out.WriteInteger(v)
+	case client.Error:
+		out.WriteError(string(v))
+	case []interface{}:
+		out.WriteArrayHeader(len(v))
+		for _, item := range v {
+			writeForwardedReply(out, item)
+		}
+	default:
+		out.WriteError(fmt.Sprintf("ERR unexpected reply %T from the owner", reply))
+	}
+}
+
+// PartitionMiddleware forwards commands for keys this node does not own to
+// their owner, which runs them without forwarding again. Commands whose
+// keys have different owners are refused.
+func PartitionMiddleware(next CommandHandler) CommandHandler {
+	return func(ctx *CommandContext) error {
+		cl := ctx.Cache.clusterMembership()
+		if cl == nil || !cl.cfg.Partition || ctx.Forwarded || ctx.Command.Keys == nil {
+			return next(ctx)
+		}
+		keys := ctx.Command.Keys(ctx.Args)
+		if len(keys) == 0 {
+			return next(ctx)
+		}
+		owner := cl.Owner(keys[0])
+		for _, key := range keys[1:] {
+			if cl.Owner(key) != owner {
+				return errCrossNode
+			}
+		}
+		if owner == cl.self.Addr {
+			return next(ctx)
+		}
+		return cl.forward(ctx, owner)
+	}
+}
@@ -0,0 +1,104 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPinLimit is returned when pinning would exceed the pinned memory limit
+var ErrPinLimit = errors.New("pinned memory limit exceeded")
+
+// pinnedSize is the entry's charge against the pinned limit, zero if unpinned
+func (e *CacheEntry) pinnedSize() int64 {
+	if !e.Pinned {
+		return 0
+	}
+	return e.memory
+}
+
+// pinFits reports whether n more pinned bytes fit. Pins racing on other
+// shards may each see room for themselves.
+func (c *Cache) pinFits(n int64) bool {
+	return c.maxPinnedBytes <= 0 || c.pinnedBytes.Load()+n <= c.maxPinnedBytes
+}
+
+// Pin excludes key from eviction. It reports false if the key does not exist.
+func (c *Cache) Pin(key string) (bool, error) {
+	s := c.shardFor(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := s.lookupLive(key)
+	if entry == nil {
+		return false, nil
+	}
+	if entry.Pinned {
+		return true, nil
+	}
+	if !c.pinFits(entry.memory) {
+		return true, ErrPinLimit
+	}
+
+	s.policy.Remove(entry)
+	entry.Pinned = true
+	c.pinnedBytes.Add(entry.memory)
+	s.markDirty(key)
+	return true, nil
+}
+
+// Unpin makes key evictable again, as the most recently used entry. It
+// reports false if the key does not exist.
+func (c *Cache) Unpin(key string) bool {
+	s := c.shardFor(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := s.lookupLive(key)
+	if entry == nil {
+		return false
+	}
+	if entry.Pinned {
+		c.pinnedBytes.Add(-entry.memory)
+		entry.Pinned = false
+		s.policy.Add(entry)
+		s.markDirty(key)
+		s.evictOverflow(1)
+	}
+	return true
+}
+
+// PinnedBytes returns the memory charged to pinned entries
+func (c *Cache) PinnedBytes() int64 {
+	return c.pinnedBytes.Load()
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "PIN", Arity: 2, Flags: FlagWrite, Handler: pinCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "UNPIN", Arity: 2, Flags: FlagWrite, Handler: unpinCommand, KeyArgs: firstKeyArg},
+	)
+}
+
+// pinCommand implements PIN key, replying 1 if the key was found
+func pinCommand(ctx *CommandContext) error {
+	found, err := ctx.Cache.Pin(string(ctx.Args[1]))
+	if err != nil {
+		return fmt.Errorf("ERR %v", err)
+	}
+	if found {
+		ctx.Out.WriteInteger(1)
+	} else {
+		ctx.Out.WriteInteger(0)
+	}
+	return nil
+}
+
+// unpinCommand implements UNPIN key, replying 1 if the key was found
+func unpinCommand(ctx *CommandContext) error {
+	if ctx.Cache.Unpin(string(ctx.Args[1])) {
+		ctx.Out.WriteInteger(1)
+	} else {
+		ctx.Out.WriteInteger(0)
+	}
+	return nil
+}
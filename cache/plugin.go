@@ -0,0 +1,54 @@
+package cache
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Custom commands are compiled into the server as plugins: Go files in
+// the main package guarded by a build tag, which register their commands
+// from an init function. Building with the tag includes them:
+//
+//	go build -tags plugin_example
+//
+// See plugin_example.go at the repository root. Programs embedding the
+// cache register theirs the same way before serving. Handlers get the cache engine through
+// ctx.Cache and the same CommandContext as built-in commands, and run
+// through the same middleware, ACL checks and deadlines.
+
+// RegisterCommand adds a custom command. It must be called before the
+// server starts, normally from a plugin's init function. Names are case
+// insensitive and cannot replace a built-in or already registered command.
+// The arity follows Command.Arity, and flags mark the command as reading
+// or writing keys so ACL categories, rate limits and replication apply;
+// they must name at least one ACL category.
+// Without key information, only users with access to all keys may run it
+// under an ACL; plugins can register a Command with Keys set through
+// RegisterCommands instead.
+func RegisterCommand(name string, arity int, flags CommandFlags, handler CommandHandler) error {
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return fmt.Errorf("invalid command name %q", name)
+	}
+	if arity == 0 {
+		return fmt.Errorf("command %s: arity cannot be zero", name)
+	}
+	if handler == nil {
+		return fmt.Errorf("command %s: no handler", name)
+	}
+	if flags&FlagCategories == 0 {
+		return fmt.Errorf("command %s: flags name no ACL category", name)
+	}
+	if LookupCommand(name) != nil {
+		return fmt.Errorf("command %s is already registered", strings.ToUpper(name))
+	}
+	RegisterCommands(&Command{Name: strings.ToUpper(name), Arity: arity, Flags: flags, Handler: handler})
+	return nil
+}
+
+// MustRegisterCommand is RegisterCommand panicking on error, for init
+// functions
+func MustRegisterCommand(name string, arity int, flags CommandFlags, handler CommandHandler) {
+	if err := RegisterCommand(name, arity, flags, handler); err != nil {
+		panic(err)
+	}
+}
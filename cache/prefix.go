@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// EnablePrefixIndex starts maintaining a radix tree of keys per namespace,
+// so prefix listing and deletion no longer scan the whole keyspace
+func (c *Cache) EnablePrefixIndex() {
+	c.lockAll()
+	defer c.unlockAll()
+
+	for _, s := range c.shards {
+		if s.prefixIndex != nil {
+			continue
+		}
+		s.prefixIndex = make(map[string]*radixTree)
+		for key := range s.data {
+			s.addToPrefixIndex(key)
+		}
+	}
+}
+
+// addToPrefixIndex records key in its namespace tree
+func (s *cacheShard) addToPrefixIndex(key string) {
+	if s.prefixIndex == nil {
+		return
+	}
+	ns := NamespaceOf(key)
+	tree, ok := s.prefixIndex[ns]
+	if !ok {
+		tree = newRadixTree()
+		s.prefixIndex[ns] = tree
+	}
+	tree.Insert(key)
+}
+
+// removeFromPrefixIndex forgets key
+func (s *cacheShard) removeFromPrefixIndex(key string) {
+	if s.prefixIndex == nil {
+		return
+	}
+	ns := NamespaceOf(key)
+	if tree, ok := s.prefixIndex[ns]; ok {
+		tree.Delete(key)
+		if tree.Len() == 0 {
+			delete(s.prefixIndex, ns)
+		}
+	}
+}
+
+// walkPrefix visits keys starting with prefix in keyOrderLess order until
+// fn returns false. With several shards their keys are gathered and sorted
+// before the first visit. Callers hold every shard's lock.
+func (c *Cache) walkPrefix(prefix string, fn func(key string) bool) {
+	if len(c.shards) == 1 {
+		c.shards[0].walkPrefix(prefix, fn)
+		return
+	}
+
+	keys := make([]string, 0)
+	for _, s := range c.shards {
+		s.walkPrefix(prefix, func(key string) bool {
+			keys = append(keys, key)
+			return true
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keyOrderLess(keys[i], keys[j]) })
+	for _, key := range keys {
+		if !fn(key) {
+			return
+		}
+	}
+}
+
+// walkPrefix visits the shard's keys starting with prefix in keyOrderLess
+// order until fn returns false
+func (s *cacheShard) walkPrefix(prefix string, fn func(key string) bool) {
+	if s.prefixIndex == nil {
+		keys := make([]string, 0)
+		for key := range s.data {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool { return keyOrderLess(keys[i], keys[j]) })
+		for _, key := range keys {
+			if !fn(key) {
+				return
+			}
+		}
+		return
+	}
+
+	// A prefix that spans a separator lives in a single namespace tree
+	if strings.Contains(prefix, NamespaceSeparator) {
+		if tree, ok := s.prefixIndex[NamespaceOf(prefix)]; ok {
+			tree.WalkPrefix(prefix, fn)
+		}
+		return
+	}
+
+	// Otherwise any namespace starting with prefix, and keys without a
+	// namespace, may match
+	namespaces := make([]string, 0, len(s.prefixIndex))
+	for ns := range s.prefixIndex {
+		if ns == "" || strings.HasPrefix(ns, prefix) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces)
+
+	stopped := false
+	for _, ns := range namespaces {
+		s.prefixIndex[ns].WalkPrefix(prefix, func(key string) bool {
+			if !fn(key) {
+				stopped = true
+				return false
+			}
+			return true
+		})
+		if stopped {
+			return
+		}
+	}
+}
+
+// keyOrderLess orders keys by namespace and then lexically within one, the
+// order of the namespace trees of the prefix index
+func keyOrderLess(a, b string) bool {
+	if nsA, nsB := NamespaceOf(a), NamespaceOf(b); nsA != nsB {
+		return nsA < nsB
+	}
+	return a < b
+}
+
+// KeysWithPrefix returns up to limit live keys starting with prefix. Keys
+// are in lexical order within a namespace. A limit of zero or less returns
+// every match. The walk stops with ctx's error once ctx is done.
+func (c *Cache) KeysWithPrefix(ctx context.Context, prefix string, limit int) ([]string, error) {
+	c.rlockAll()
+	defer c.runlockAll()
+
+	now := c.now()
+	keys := make([]string, 0)
+	visited := 0
+	var err error
+	c.walkPrefix(prefix, func(key string) bool {
+		if visited++; visited%deadlineCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		if entry := c.storedEntry(key); entry.ExpiresAt != nil && now.After(*entry.ExpiresAt) {
+			return true
+		}
+		keys = append(keys, key)
+		return limit <= 0 || len(keys) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// DeletePrefix removes every key starting with prefix and returns how many
+// were removed
+func (c *Cache) DeletePrefix(prefix string) int {
+	c.lockAll()
+	defer c.unlockAll()
+
+	keys := make([]string, 0)
+	c.walkPrefix(prefix, func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+
+	for _, key := range keys {
+		s := c.shardFor(key)
+		s.dropEntry(s.data[key], RemovalDeleted)
+	}
+	return len(keys)
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "KEYSPREFIX", Arity: -2, Flags: FlagReadOnly, Handler: keysPrefixCommand},
+		&Command{Name: "DELPREFIX", Arity: 2, Flags: FlagWrite, Handler: delPrefixCommand},
+	)
+}
+
+// keysPrefixCommand implements KEYSPREFIX prefix [LIMIT count]
+func keysPrefixCommand(ctx *CommandContext) error {
+	limit := 0
+	switch len(ctx.Args) {
+	case 2:
+	case 4:
+		if !strings.EqualFold(string(ctx.Args[2]), "LIMIT") {
+			return ErrSyntax
+		}
+		n, err := ParseInt(ctx.Args[3])
+		if err != nil || n < 0 {
+			return ErrNotInteger
+		}
+		limit = int(n)
+	default:
+		return ErrSyntax
+	}
+
+	keys, err := ctx.Cache.KeysWithPrefix(ctx.Context, string(ctx.Args[1]), limit)
+	if err != nil {
+		return err
+	}
+	ctx.Out.WriteStringArray(keys)
+	return nil
+}
+
+// delPrefixCommand implements DELPREFIX prefix
+func delPrefixCommand(ctx *CommandContext) error {
+	ctx.Out.WriteInteger(int64(ctx.Cache.DeletePrefix(string(ctx.Args[1]))))
+	return nil
+}
@@ -0,0 +1,244 @@
+package cache
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Preload files seed the cache with reference data on boot. Each holds
+// key, value and optional TTL tuples in one of three formats:
+//
+//	json  an array of {"key": ..., "value": ..., "ttl": ...} objects. A
+//	      value that is not a JSON string is stored as its JSON text.
+//	csv   rows of key,value[,ttl], optionally under a key,value[,ttl] header
+//	resp  RESP arrays of key, value and optional TTL bulk strings, as
+//	      written by a RESP client library
+//
+// A TTL is a number of seconds or a duration such as "1h30m"; without one
+// the namespace's default TTL applies. Keys are stored under the file's
+// namespace, so key "fr" in namespace "country" becomes "country:fr".
+
+// Preload formats
+const (
+	PreloadJSON = "json"
+	PreloadCSV  = "csv"
+	PreloadRESP = "resp"
+)
+
+// PreloadConfig names one file to load on boot
+type PreloadConfig struct {
+	Path string `json:"path" toml:"path" yaml:"path"`
+	// Format is "json", "csv" or "resp", and is taken from the file's
+	// extension when empty
+	Format string `json:"format" toml:"format" yaml:"format"`
+	// Namespace, when set, prefixes every key in the file
+	Namespace string `json:"namespace" toml:"namespace" yaml:"namespace"`
+}
+
+// preloadTuple is one key to preload
+type preloadTuple struct {
+	key   string
+	value []byte
+	ttl   time.Duration
+}
+
+// format returns the file's format, from its extension if not configured
+func (p PreloadConfig) format() (string, error) {
+	format := strings.ToLower(p.Format)
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(p.Path)), ".")
+	}
+	switch format {
+	case PreloadJSON, PreloadCSV, PreloadRESP:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown preload format %q for %s", format, p.Path)
+	}
+}
+
+// Validate checks that the file is named and its format known
+func (p PreloadConfig) Validate() error {
+	if p.Path == "" {
+		return errors.New("preload path required")
+	}
+	if strings.Contains(p.Namespace, NamespaceSeparator) {
+		return fmt.Errorf("preload namespace %q cannot contain %q", p.Namespace, NamespaceSeparator)
+	}
+	_, err := p.format()
+	return err
+}
+
+// Preload loads the files in order, later files overwriting keys of
+// earlier ones, and returns how many keys were written. Loading stops at
+// the first bad file with an error naming it and the record at fault.
+func (c *Cache) Preload(ctx context.Context, files []PreloadConfig) (int, error) {
+	loaded := 0
+	for _, file := range files {
+		n, err := c.preloadFile(ctx, file)
+		loaded += n
+		if err != nil {
+			return loaded, fmt.Errorf("preloading %s: %w", file.Path, err)
+		}
+	}
+	return loaded, nil
+}
+
+// preloadFile loads one file
+func (c *Cache) preloadFile(ctx context.Context, p PreloadConfig) (int, error) {
+	format, err := p.format()
+	if err != nil {
+		return 0, err
+	}
+	file, err := os.Open(p.Path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	loaded := 0
+	store := func(t preloadTuple) error {
+		if t.key == "" {
+			return errors.New("empty key")
+		}
+		if p.Namespace != "" {
+			t.key = p.Namespace + NamespaceSeparator + t.key
+		}
+		var opts SetOptions
+		if t.ttl > 0 {
+			opts.TTL = &t.ttl
+		}
+		if err := c.SetWithOptions(ctx, t.key, t.value, opts); err != nil {
+			return err
+		}
+		loaded++
+		return nil
+	}
+
+	switch format {
+	case PreloadJSON:
+		err = scanPreloadJSON(file, store)
+	case PreloadCSV:
+		err = scanPreloadCSV(file, store)
+	default:
+		err = scanPreloadRESP(file, store)
+	}
+	return loaded, err
+}
+
+// scanPreloadJSON calls store with each object of a JSON array
+func scanPreloadJSON(r io.Reader, store func(preloadTuple) error) error {
+	dec := json.NewDecoder(r)
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+		return errors.New("expected a JSON array of key/value objects")
+	}
+	for record := 1; dec.More(); record++ {
+		var obj struct {
+			Key   string          `json:"key"`
+			Value json.RawMessage `json:"value"`
+			TTL   json.RawMessage `json:"ttl"`
+		}
+		if err := dec.Decode(&obj); err != nil {
+			return fmt.Errorf("record %d: %w", record, err)
+		}
+		t := preloadTuple{key: obj.Key, value: []byte(obj.Value)}
+		var s string
+		if json.Unmarshal(obj.Value, &s) == nil {
+			t.value = []byte(s)
+		}
+		if len(obj.TTL) > 0 && string(obj.TTL) != "null" {
+			ttl, err := parsePreloadTTL(strings.Trim(string(obj.TTL), `"`))
+			if err != nil {
+				return fmt.Errorf("record %d: %w", record, err)
+			}
+			t.ttl = ttl
+		}
+		if err := store(t); err != nil {
+			return fmt.Errorf("record %d: %w", record, err)
+		}
+	}
+	return nil
+}
+
+// scanPreloadCSV calls store with each row of a CSV file
+func scanPreloadCSV(r io.Reader, store func(preloadTuple) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		if line == 1 && len(row) >= 2 && strings.EqualFold(row[0], "key") && strings.EqualFold(row[1], "value") {
+			continue
+		}
+		if len(row) < 2 || len(row) > 3 {
+			return fmt.Errorf("line %d: expected key,value[,ttl]", line)
+		}
+		t := preloadTuple{key: row[0], value: []byte(row[1])}
+		if len(row) == 3 && row[2] != "" {
+			if t.ttl, err = parsePreloadTTL(row[2]); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		if err := store(t); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+}
+
+// scanPreloadRESP calls store with each RESP array of a file
+func scanPreloadRESP(r io.Reader, store func(preloadTuple) error) error {
+	rr := NewRESPReader(r, DefaultRequestLimits())
+	for record := 1; ; record++ {
+		args, err := rr.ReadCommand()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("record %d: %w", record, err)
+		}
+		if len(args) == 0 {
+			record--
+			continue
+		}
+		if len(args) > 3 || len(args) < 2 {
+			return fmt.Errorf("record %d: expected key, value and optional TTL", record)
+		}
+		t := preloadTuple{key: string(args[0]), value: args[1]}
+		if len(args) == 3 {
+			if t.ttl, err = parsePreloadTTL(string(args[2])); err != nil {
+				return fmt.Errorf("record %d: %w", record, err)
+			}
+		}
+		if err := store(t); err != nil {
+			return fmt.Errorf("record %d: %w", record, err)
+		}
+	}
+}
+
+// parsePreloadTTL parses a TTL given in seconds or as a duration
+func parsePreloadTTL(s string) (time.Duration, error) {
+	var ttl time.Duration
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		ttl = time.Duration(seconds) * time.Second
+	} else if ttl, err = time.ParseDuration(s); err != nil {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	if ttl < 0 {
+		return 0, fmt.Errorf("invalid TTL %q", s)
+	}
+	return ttl, nil
+}
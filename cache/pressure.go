@@ -0,0 +1,240 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrOOM is returned for writes rejected because the cache is at its
+// memory limit and configured to reject rather than evict
+var ErrOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'")
+
+// Actions taken when a write would exceed the hard memory limit, set in
+// Config.MemoryLimitAction
+const (
+	// MemoryLimitEvict evicts synchronously until the write fits
+	MemoryLimitEvict = "evict"
+	// MemoryLimitReject fails the write with ErrOOM, like Redis noeviction
+	MemoryLimitReject = "reject"
+)
+
+// Soft limit relief tuning
+const (
+	// reliefBatchSize bounds how many entries are evicted per lock
+	// acquisition, so readers are not stalled
+	reliefBatchSize = 128
+	// reliefMarginPercent of the soft limit is evicted below it before the
+	// pressure clears, so usage hovering at the limit does not flap
+	reliefMarginPercent = 5
+)
+
+// MemoryPressure is how close the cache is to its memory limit
+type MemoryPressure int
+
+const (
+	PressureNone MemoryPressure = iota
+	// PressureSoft means usage is above the soft limit
+	PressureSoft
+	// PressureHard means usage has reached the hard limit
+	PressureHard
+)
+
+func (p MemoryPressure) String() string {
+	switch p {
+	case PressureSoft:
+		return "soft"
+	case PressureHard:
+		return "hard"
+	default:
+		return "none"
+	}
+}
+
+// MarshalJSON encodes the level by name
+func (p MemoryPressure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.String())
+}
+
+// MemoryPressureEvent reports a change of memory pressure level
+type MemoryPressureEvent struct {
+	Level     MemoryPressure `json:"level"`
+	Previous  MemoryPressure `json:"previous"`
+	Used      int64          `json:"used"`
+	SoftLimit int64          `json:"soft_limit"`
+	HardLimit int64          `json:"hard_limit"`
+	Time      time.Time      `json:"time"`
+}
+
+// MemoryPressureListener is called for every pressure level change. Like
+// removal listeners, it runs on a separate goroutine.
+type MemoryPressureListener func(event MemoryPressureEvent)
+
+// pressureQueueSize bounds pressure events awaiting delivery
+const pressureQueueSize = 64
+
+// OnMemoryPressure registers a listener for pressure level changes and
+// returns a function that unregisters it
+func (c *Cache) OnMemoryPressure(fn MemoryPressureListener) func() {
+	return c.pressureEvents.add(fn)
+}
+
+// MemoryPressure returns the current pressure level
+func (c *Cache) MemoryPressure() MemoryPressure {
+	return MemoryPressure(c.pressure.Load())
+}
+
+// rejectsWrites reports whether the cache fails writes at its memory limit
+func (c *Cache) rejectsWrites() bool {
+	return c.maxCost > 0 && c.memoryLimitAction == MemoryLimitReject
+}
+
+// admitWrite returns ErrOOM if the cache rejects writes and adding delta
+// would take it over its memory limit. Writes racing on other shards may
+// each see room for themselves.
+func (c *Cache) admitWrite(delta int64) error {
+	if c.rejectsWrites() && c.totalCost.Load()+delta > c.maxCost {
+		return ErrOOM
+	}
+	return nil
+}
+
+// reliefTarget is the usage soft limit relief evicts down to
+func (c *Cache) reliefTarget() int64 {
+	return c.softCost - c.softCost*reliefMarginPercent/100
+}
+
+// updatePressure recomputes the pressure level, notifies listeners of a
+// change and wakes the relief routine under pressure. Of writers racing
+// on different shards, the one that changes the level notifies.
+func (c *Cache) updatePressure() {
+	used := c.totalCost.Load()
+	previous := c.MemoryPressure()
+	level := PressureNone
+	switch {
+	case c.maxCost > 0 && used >= c.maxCost:
+		level = PressureHard
+	case c.softCost > 0 && used > c.softCost:
+		level = PressureSoft
+	case previous > PressureNone && c.softCost > 0 && used > c.reliefTarget():
+		// Pressure persists until relief gets below the target
+		level = PressureSoft
+	}
+	if level > PressureNone {
+		c.wakeRelief()
+	}
+	if level == previous || !c.pressure.CompareAndSwap(int32(previous), int32(level)) {
+		return
+	}
+
+	c.pressureEvents.dispatch(MemoryPressureEvent{
+		Level:     level,
+		Previous:  previous,
+		Used:      used,
+		SoftLimit: c.softCost,
+		HardLimit: c.maxCost,
+		Time:      time.Now(),
+	})
+}
+
+// wakeRelief wakes the relief routine started by StartCleanupRoutine
+func (c *Cache) wakeRelief() {
+	select {
+	case c.pressureSignal <- struct{}{}:
+	default:
+	}
+}
+
+// reliefReason returns why the relief routine evicts: usage above the
+// relief target under pressure, or an overflow a shard could not cover
+// from its own keys. It returns "" once there is neither.
+func (c *Cache) reliefReason() RemovalReason {
+	if c.MemoryPressure() > PressureNone && c.softCost > 0 && c.totalCost.Load() > c.reliefTarget() {
+		return RemovalEvictedMemory
+	}
+	return c.overflow()
+}
+
+// relievePressure evicts entries in small batches, spread over the shards
+// and locking one at a time, until reliefReason clears, and returns how
+// many were evicted
+func (c *Cache) relievePressure() int {
+	batch := max(reliefBatchSize/len(c.shards), 1)
+	evicted := 0
+	for {
+		pass := 0
+		for _, s := range c.shards {
+			s.mutex.Lock()
+			for n := 0; n < batch; n++ {
+				reason := c.reliefReason()
+				if reason == "" {
+					break
+				}
+				victim := s.policy.Victim()
+				if victim == nil {
+					break
+				}
+				s.dropEntry(victim, reason)
+				pass++
+			}
+			c.updatePressure()
+			s.mutex.Unlock()
+		}
+
+		evicted += pass
+		if pass == 0 || c.reliefReason() == "" {
+			return evicted
+		}
+	}
+}
+
+// StartMemoryPressureWebhook posts every pressure level change from c as
+// JSON to url, and returns a function that stops it
+func StartMemoryPressureWebhook(c *Cache, url string, logger *log.Logger) func() {
+	client := &http.Client{Timeout: webhookTimeout}
+	return c.OnMemoryPressure(func(event MemoryPressureEvent) {
+		body, err := json.Marshal(event)
+		if err != nil {
+			logger.Printf("Memory pressure webhook failed: %v", err)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			logger.Printf("Memory pressure webhook failed: %v", err)
+			return
+		}
+		req.Header.Set("Content-Type", webhookContentType)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			logger.Printf("Memory pressure webhook failed: %v", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			logger.Printf("Memory pressure webhook failed: unexpected status %s", resp.Status)
+		}
+	})
+}
+
+// ParseMemoryLimitAction validates a memory limit action name
+func ParseMemoryLimitAction(name string) (string, error) {
+	switch action := strings.ToLower(name); action {
+	case "", MemoryLimitEvict:
+		return MemoryLimitEvict, nil
+	case MemoryLimitReject:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown memory limit action: %s", name)
+	}
+}
@@ -0,0 +1,302 @@
+package cache
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+	"unsafe"
+)
+
+// A priority queue hands out the item of highest priority first, and items
+// of equal priority in the order they were pushed. Which item a pop takes
+// depends only on the pushes and pops before it, so pops are replicated
+// and replayed as they are.
+
+// PriorityItem is an item of a priority queue
+type PriorityItem struct {
+	Priority int64
+	Payload  []byte
+}
+
+// priorityQueue is a max-heap of items by priority
+type priorityQueue struct {
+	items []*priorityEntry
+	// seq orders items of the same priority
+	seq uint64
+}
+
+type priorityEntry struct {
+	PriorityItem
+	seq uint64
+}
+
+func newPriorityQueue() *priorityQueue {
+	return &priorityQueue{}
+}
+
+// TypeName implements cacheObject
+func (q *priorityQueue) TypeName() string {
+	return "priorityqueue"
+}
+
+// memoryUsage implements memorySizer
+func (q *priorityQueue) memoryUsage() int64 {
+	n := int64(unsafe.Sizeof(*q)) + allocSize(int64(cap(q.items))*int64(unsafe.Sizeof(uintptr(0))))
+	for _, item := range q.items {
+		n += allocSize(int64(unsafe.Sizeof(*item))) + allocSize(int64(cap(item.Payload)))
+	}
+	return n
+}
+
+// rewriteCommands implements aofRewriter, pushing the items in the order
+// they were added so items of the same priority keep their order
+func (q *priorityQueue) rewriteCommands(key string) [][][]byte {
+	items := append([]*priorityEntry(nil), q.items...)
+	sort.Slice(items, func(i, j int) bool { return items[i].seq < items[j].seq })
+	commands := make([][][]byte, len(items))
+	for i, item := range items {
+		commands[i] = [][]byte{
+			[]byte("PQ.PUSH"), []byte(key),
+			[]byte(strconv.FormatInt(item.Priority, 10)), item.Payload,
+		}
+	}
+	return commands
+}
+
+func (q *priorityQueue) Len() int { return len(q.items) }
+
+func (q *priorityQueue) Less(i, j int) bool {
+	a, b := q.items[i], q.items[j]
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	return a.seq < b.seq
+}
+
+func (q *priorityQueue) Swap(i, j int) {
+	q.items[i], q.items[j] = q.items[j], q.items[i]
+}
+
+func (q *priorityQueue) Push(x interface{}) {
+	q.items = append(q.items, x.(*priorityEntry))
+}
+
+func (q *priorityQueue) Pop() interface{} {
+	n := len(q.items)
+	item := q.items[n-1]
+	q.items[n-1] = nil
+	q.items = q.items[:n-1]
+	return item
+}
+
+// push queues an item
+func (q *priorityQueue) push(item PriorityItem) {
+	q.seq++
+	heap.Push(q, &priorityEntry{PriorityItem: item, seq: q.seq})
+}
+
+// pop removes and returns the item of highest priority
+func (q *priorityQueue) pop() (PriorityItem, bool) {
+	if len(q.items) == 0 {
+		return PriorityItem{}, false
+	}
+	return heap.Pop(q).(*priorityEntry).PriorityItem, true
+}
+
+// PushPriority adds an item to the priority queue at key, creating the
+// queue if needed, and returns the queue's length
+func (c *Cache) PushPriority(key string, item PriorityItem) (int, error) {
+	n := 0
+	err := updateObject(c, key, func() (*priorityQueue, error) { return newPriorityQueue(), nil }, func(q *priorityQueue) error {
+		q.push(item)
+		n = q.Len()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	c.keySignals.notify(key)
+	return n, nil
+}
+
+// PopPriority removes and returns the item of highest priority of the
+// queue at key
+func (c *Cache) PopPriority(key string) (item PriorityItem, ok bool, err error) {
+	err = updateObject(c, key, nil, func(q *priorityQueue) error {
+		item, ok = q.pop()
+		return nil
+	})
+	if err == ErrNoSuchKey {
+		err = nil
+	}
+	return item, ok, err
+}
+
+// WaitPriority pops the item of highest priority of the queue at key,
+// waiting until there is one or ctx is done. Concurrent waiters each
+// receive a different item.
+func (c *Cache) WaitPriority(ctx context.Context, key string) (PriorityItem, error) {
+	return c.waitPriority(ctx, key, c.PopPriority)
+}
+
+func (c *Cache) waitPriority(ctx context.Context, key string, pop func(key string) (PriorityItem, bool, error)) (PriorityItem, error) {
+	var item PriorityItem
+	err := c.blockOnKey(ctx, key, func() (bool, time.Time, error) {
+		var ok bool
+		var err error
+		item, ok, err = pop(key)
+		return ok, time.Time{}, err
+	})
+	return item, err
+}
+
+// PeekPriority returns the item of highest priority of the queue at key
+// without removing it
+func (c *Cache) PeekPriority(key string) (item PriorityItem, ok bool, err error) {
+	_, err = viewObject(c, key, func(q *priorityQueue) error {
+		if q.Len() > 0 {
+			item, ok = q.items[0].PriorityItem, true
+		}
+		return nil
+	})
+	return item, ok, err
+}
+
+// PriorityLen returns the items in the queue at key
+func (c *Cache) PriorityLen(key string) (int, error) {
+	n := 0
+	_, err := viewObject(c, key, func(q *priorityQueue) error {
+		n = q.Len()
+		return nil
+	})
+	return n, err
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "PQ.PUSH", Arity: 4, Flags: FlagWrite, Handler: pqPushCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "PQ.POP", Arity: 2, Flags: FlagWrite, Handler: pqPopCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "PQ.BPOP", Arity: 3, Flags: FlagWrite | FlagSelfLogged, Handler: pqBPopCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "PQ.PEEK", Arity: 2, Flags: FlagReadOnly, Handler: pqPeekCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "PQ.LEN", Arity: 2, Flags: FlagReadOnly, Handler: pqLenCommand, KeyArgs: firstKeyArg},
+	)
+}
+
+// priorityQueueCommandError maps cache errors to replies, keeping those
+// that already carry a reply code
+func priorityQueueCommandError(err error) error {
+	if err == ErrWrongType || err == ErrOOM {
+		return err
+	}
+	return fmt.Errorf("ERR %v", err)
+}
+
+// pqPushCommand implements PQ.PUSH key priority payload, replying with the
+// queue's length
+func pqPushCommand(ctx *CommandContext) error {
+	priority, err := ParseInt(ctx.Args[2])
+	if err != nil {
+		return err
+	}
+	n, err := ctx.Cache.PushPriority(string(ctx.Args[1]), PriorityItem{
+		Priority: priority,
+		Payload:  append([]byte(nil), ctx.Args[3]...),
+	})
+	if err != nil {
+		return priorityQueueCommandError(err)
+	}
+	ctx.Out.WriteInteger(int64(n))
+	return nil
+}
+
+// pqPopCommand implements PQ.POP key, replying with the priority and
+// payload of the item popped, or null if the queue is empty
+func pqPopCommand(ctx *CommandContext) error {
+	item, ok, err := ctx.Cache.PopPriority(string(ctx.Args[1]))
+	if err != nil {
+		return priorityQueueCommandError(err)
+	}
+	writePriorityItem(ctx, item, ok)
+	return nil
+}
+
+// pqBPopCommand implements PQ.BPOP key timeout-seconds, which waits for an
+// item if the queue is empty. A zero timeout waits indefinitely, within
+// the command's execution deadline if one is configured. A successful pop
+// is logged as PQ.POP, so waiting never holds up the log.
+func pqBPopCommand(ctx *CommandContext) error {
+	key := string(ctx.Args[1])
+	timeout, err := strconv.ParseFloat(string(ctx.Args[2]), 64)
+	if err != nil || timeout < 0 {
+		return errors.New("ERR timeout is not a float or out of range")
+	}
+	pop := func(key string) (item PriorityItem, ok bool, err error) {
+		err = ctx.Cache.logWrite(ctx.Context, []string{key}, func() ([][]byte, error) {
+			var err error
+			item, ok, err = ctx.Cache.PopPriority(key)
+			if err != nil || !ok {
+				return nil, err
+			}
+			return [][]byte{[]byte("PQ.POP"), []byte(key)}, nil
+		})
+		return item, ok, err
+	}
+
+	waitCtx := ctx.Context
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		waitCtx, cancel = context.WithTimeout(ctx.Context, time.Duration(timeout*float64(time.Second)))
+		defer cancel()
+	}
+	item, err := ctx.Cache.waitPriority(waitCtx, key, pop)
+	// Running out of time to wait is an empty reply, not an error
+	if err == context.DeadlineExceeded && ctx.Context.Err() == nil {
+		writePriorityItem(ctx, item, false)
+		return nil
+	}
+	if err != nil {
+		if err == context.DeadlineExceeded {
+			return err
+		}
+		return priorityQueueCommandError(err)
+	}
+	writePriorityItem(ctx, item, true)
+	return nil
+}
+
+// pqPeekCommand implements PQ.PEEK key, replying like PQ.POP without
+// removing the item
+func pqPeekCommand(ctx *CommandContext) error {
+	item, ok, err := ctx.Cache.PeekPriority(string(ctx.Args[1]))
+	if err != nil {
+		return priorityQueueCommandError(err)
+	}
+	writePriorityItem(ctx, item, ok)
+	return nil
+}
+
+// pqLenCommand implements PQ.LEN key
+func pqLenCommand(ctx *CommandContext) error {
+	n, err := ctx.Cache.PriorityLen(string(ctx.Args[1]))
+	if err != nil {
+		return priorityQueueCommandError(err)
+	}
+	ctx.Out.WriteInteger(int64(n))
+	return nil
+}
+
+// writePriorityItem replies with an item's priority and payload, or null
+// if there is no item
+func writePriorityItem(ctx *CommandContext, item PriorityItem, ok bool) {
+	if !ok {
+		ctx.Out.WriteNull()
+		return
+	}
+	ctx.Out.WriteArrayHeader(2)
+	ctx.Out.WriteInteger(item.Priority)
+	ctx.Out.WriteBulk(item.Payload)
+}
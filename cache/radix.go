@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"sort"
+	"strings"
+)
+
+// radixTree is a compressed prefix tree over a set of keys
+type radixTree struct {
+	root *radixNode
+	size int
+}
+
+type radixNode struct {
+	prefix   string
+	leaf     bool
+	children []*radixNode // sorted by first byte of prefix
+}
+
+func newRadixTree() *radixTree {
+	return &radixTree{root: &radixNode{}}
+}
+
+// Len returns the number of keys in the tree
+func (t *radixTree) Len() int {
+	return t.size
+}
+
+// Insert adds key to the tree and reports whether it was not already present
+func (t *radixTree) Insert(key string) bool {
+	n := t.root
+	search := key
+	for {
+		if search == "" {
+			if n.leaf {
+				return false
+			}
+			n.leaf = true
+			t.size++
+			return true
+		}
+
+		child := n.child(search[0])
+		if child == nil {
+			n.addChild(&radixNode{prefix: search, leaf: true})
+			t.size++
+			return true
+		}
+
+		common := commonPrefixLen(search, child.prefix)
+		if common == len(child.prefix) {
+			n = child
+			search = search[common:]
+			continue
+		}
+
+		// Split the edge at the point where the keys diverge
+		split := &radixNode{prefix: search[:common]}
+		n.replaceChild(child, split)
+		child.prefix = child.prefix[common:]
+		split.addChild(child)
+
+		search = search[common:]
+		if search == "" {
+			split.leaf = true
+		} else {
+			split.addChild(&radixNode{prefix: search, leaf: true})
+		}
+		t.size++
+		return true
+	}
+}
+
+// Delete removes key from the tree and reports whether it was present
+func (t *radixTree) Delete(key string) bool {
+	var parent *radixNode
+	n := t.root
+	search := key
+	for search != "" {
+		child := n.child(search[0])
+		if child == nil || !strings.HasPrefix(search, child.prefix) {
+			return false
+		}
+		parent, n = n, child
+		search = search[len(child.prefix):]
+	}
+	if !n.leaf {
+		return false
+	}
+
+	n.leaf = false
+	t.size--
+
+	// Keep the tree compressed: drop empty leaves and merge single-child nodes
+	if n != t.root && len(n.children) == 0 {
+		parent.removeChild(n)
+		if parent != t.root && !parent.leaf && len(parent.children) == 1 {
+			parent.mergeChild()
+		}
+	} else if n != t.root && len(n.children) == 1 {
+		n.mergeChild()
+	}
+	return true
+}
+
+// WalkPrefix calls fn for every key starting with prefix in lexical order,
+// stopping early if fn returns false
+func (t *radixTree) WalkPrefix(prefix string, fn func(key string) bool) {
+	n := t.root
+	search := prefix
+	built := ""
+	for search != "" {
+		child := n.child(search[0])
+		if child == nil {
+			return
+		}
+		switch {
+		case strings.HasPrefix(search, child.prefix):
+			search = search[len(child.prefix):]
+		case strings.HasPrefix(child.prefix, search):
+			// The prefix ends part way along this edge
+			search = ""
+		default:
+			return
+		}
+		built += child.prefix
+		n = child
+	}
+	n.walk(built, fn)
+}
+
+func (n *radixNode) walk(built string, fn func(key string) bool) bool {
+	if n.leaf && !fn(built) {
+		return false
+	}
+	for _, child := range n.children {
+		if !child.walk(built+child.prefix, fn) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *radixNode) child(b byte) *radixNode {
+	i := sort.Search(len(n.children), func(i int) bool { return n.children[i].prefix[0] >= b })
+	if i < len(n.children) && n.children[i].prefix[0] == b {
+		return n.children[i]
+	}
+	return nil
+}
+
+func (n *radixNode) addChild(child *radixNode) {
+	b := child.prefix[0]
+	i := sort.Search(len(n.children), func(i int) bool { return n.children[i].prefix[0] >= b })
+	n.children = append(n.children, nil)
+	copy(n.children[i+1:], n.children[i:])
+	n.children[i] = child
+}
+
+func (n *radixNode) replaceChild(old, replacement *radixNode) {
+	for i, child := range n.children {
+		if child == old {
+			n.children[i] = replacement
+			return
+		}
+	}
+}
+
+func (n *radixNode) removeChild(old *radixNode) {
+	for i, child := range n.children {
+		if child == old {
+			n.children = append(n.children[:i], n.children[i+1:]...)
+			return
+		}
+	}
+}
+
+// mergeChild folds a node's only child into it
+func (n *radixNode) mergeChild() {
+	child := n.children[0]
+	n.prefix += child.prefix
+	n.leaf = child.leaf
+	n.children = child.children
+}
+
+func commonPrefixLen(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
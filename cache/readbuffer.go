@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// readStripeSize is how many hits a stripe holds before they are applied
+const readStripeSize = 64
+
+// bufferedHit is a hit waiting to be applied
+type bufferedHit struct {
+	entry *CacheEntry
+	at    time.Time
+}
+
+// readStripe buffers a shard's hits taken under its read lock. A full
+// stripe is applied under the shard's write lock, so access order lags by
+// up to readStripeSize hits per shard.
+type readStripe struct {
+	mu   sync.Mutex
+	hits []bufferedHit
+}
+
+// record buffers a hit, returning the stripe's hits once it is full
+func (s *readStripe) record(entry *CacheEntry, at time.Time) []bufferedHit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.hits = append(s.hits, bufferedHit{entry: entry, at: at})
+	if len(s.hits) < readStripeSize {
+		return nil
+	}
+	full := s.hits
+	s.hits = make([]bufferedHit, 0, readStripeSize)
+	return full
+}
+
+// take empties the stripe, returning its hits
+func (s *readStripe) take() []bufferedHit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hits := s.hits
+	s.hits = nil
+	return hits
+}
+
+// getShared serves a hit under the shard's read lock. done is false when
+// the key needs the write lock: it has expired or has a sliding TTL.
+func (s *cacheShard) getShared(key string) (value []byte, ok, done bool) {
+	c := s.cache
+	s.mutex.RLock()
+	now := s.cache.now()
+	entry, exists := s.data[key]
+	switch {
+	case !exists:
+		c.traceAccess(key, 0, false)
+		c.hits.miss(key, false)
+		s.mutex.RUnlock()
+		return nil, false, true
+	case entry.isExpired(now) || entry.SlidingTTL > 0:
+		s.mutex.RUnlock()
+		return nil, false, false
+	case entry.Object != nil:
+		s.mutex.RUnlock()
+		return nil, false, true
+	}
+	value = entry.Value
+	full := s.reads.record(entry, now)
+	c.traceAccess(key, entry.cost(), false)
+	c.hits.hit(key)
+	refresh := c.dueForRefresh(entry, now)
+	s.mutex.RUnlock()
+
+	if refresh {
+		c.refreshAhead(key)
+	}
+	if full != nil {
+		s.mutex.Lock()
+		s.applyHits(full)
+		s.mutex.Unlock()
+	}
+	return value, true, true
+}
+
+// applyHits records buffered hits on the entries still in the shard
+func (s *cacheShard) applyHits(hits []bufferedHit) {
+	for _, hit := range hits {
+		entry := hit.entry
+		if s.data[entry.Key] != entry {
+			continue
+		}
+		entry.AccessCount++
+		if hit.at.After(entry.LastAccessed) {
+			entry.LastAccessed = hit.at
+		}
+		if !entry.Pinned {
+			s.policy.Access(entry)
+		}
+	}
+}
+
+// flushReads applies the shard's buffered hits
+func (s *cacheShard) flushReads() {
+	if hits := s.reads.take(); len(hits) > 0 {
+		s.applyHits(hits)
+	}
+}
@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errInvalidRefreshFraction is returned for fractions outside (0, 1)
+var errInvalidRefreshFraction = errors.New("ERR refresh-ahead fraction must be between 0 and 1")
+
+// refreshAheadMarks are the keys and prefixes marked for refresh-ahead,
+// each with the fraction of the TTL left that triggers a refresh. Reads
+// that find less left fetch the key again from its origin in the
+// background.
+type refreshAheadMarks struct {
+	mu       sync.RWMutex
+	keys     map[string]float64
+	prefixes map[string]float64
+}
+
+// refreshAheadStats counts refreshes started by reads
+type refreshAheadStats struct {
+	refreshed atomic.Int64
+	failed    atomic.Int64
+}
+
+// RefreshAheadMark is a key, or a prefix ending in "*", and its fraction
+type RefreshAheadMark struct {
+	Pattern  string  `json:"pattern"`
+	Fraction float64 `json:"fraction"`
+}
+
+// MarkRefreshAhead refreshes pattern, a key or a prefix ending in "*",
+// ahead of expiry once less than fraction of its TTL is left
+func (c *Cache) MarkRefreshAhead(pattern string, fraction float64) error {
+	if fraction <= 0 || fraction >= 1 {
+		return errInvalidRefreshFraction
+	}
+
+	c.refreshMarks.mu.Lock()
+	defer c.refreshMarks.mu.Unlock()
+
+	if c.refreshMarks.keys == nil {
+		c.refreshMarks.keys = make(map[string]float64)
+		c.refreshMarks.prefixes = make(map[string]float64)
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		c.refreshMarks.prefixes[prefix] = fraction
+	} else {
+		c.refreshMarks.keys[pattern] = fraction
+	}
+	c.refreshingAhead.Store(true)
+	return nil
+}
+
+// UnmarkRefreshAhead removes a mark, reporting whether there was one
+func (c *Cache) UnmarkRefreshAhead(pattern string) bool {
+	c.refreshMarks.mu.Lock()
+	defer c.refreshMarks.mu.Unlock()
+
+	marks := c.refreshMarks.keys
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		marks, pattern = c.refreshMarks.prefixes, prefix
+	}
+	if _, ok := marks[pattern]; !ok {
+		return false
+	}
+	delete(marks, pattern)
+	return true
+}
+
+// RefreshAheadMarks returns the marks, sorted by pattern
+func (c *Cache) RefreshAheadMarks() []RefreshAheadMark {
+	c.refreshMarks.mu.RLock()
+	defer c.refreshMarks.mu.RUnlock()
+
+	marks := make([]RefreshAheadMark, 0, len(c.refreshMarks.keys)+len(c.refreshMarks.prefixes))
+	for key, fraction := range c.refreshMarks.keys {
+		marks = append(marks, RefreshAheadMark{Pattern: key, Fraction: fraction})
+	}
+	for prefix, fraction := range c.refreshMarks.prefixes {
+		marks = append(marks, RefreshAheadMark{Pattern: prefix + "*", Fraction: fraction})
+	}
+	sort.Slice(marks, func(i, j int) bool { return marks[i].Pattern < marks[j].Pattern })
+	return marks
+}
+
+// refreshFraction returns the fraction of key's TTL left that triggers a
+// refresh, zero if key is not marked
+func (c *Cache) refreshFraction(key string) float64 {
+	c.refreshMarks.mu.RLock()
+	defer c.refreshMarks.mu.RUnlock()
+
+	if fraction, ok := c.refreshMarks.keys[key]; ok {
+		return fraction
+	}
+	longest, fraction := -1, 0.0
+	for prefix, f := range c.refreshMarks.prefixes {
+		if len(prefix) > longest && strings.HasPrefix(key, prefix) {
+			longest, fraction = len(prefix), f
+		}
+	}
+	if longest >= 0 {
+		return fraction
+	}
+	opts, _ := c.namespaceOptions(NamespaceOf(key))
+	return opts.RefreshAhead
+}
+
+// dueForRefresh reports whether a read of entry at now should refresh it
+// from its origin. Callers hold the lock of its shard.
+func (c *Cache) dueForRefresh(entry *CacheEntry, now time.Time) bool {
+	if !c.refreshingAhead.Load() || entry.ExpiresAt == nil || entry.SlidingTTL > 0 || entry.Object != nil {
+		return false
+	}
+	fraction := c.refreshFraction(entry.Key)
+	if fraction <= 0 || c.originFor(entry.Key) == nil {
+		return false
+	}
+	ttl := entry.ExpiresAt.Sub(entry.CreatedAt)
+	return entry.ExpiresAt.Sub(now) < time.Duration(fraction*float64(ttl))
+}
+
+// refreshAhead fetches key from its origin in the background, unless a
+// fetch of it is already running. Misses on key meanwhile wait for it, as
+// they would for one started by GetOrFetch.
+func (c *Cache) refreshAhead(key string) {
+	f := c.origins
+	f.mu.Lock()
+	if _, ok := f.inflight[key]; ok {
+		f.mu.Unlock()
+		return
+	}
+	fetch := &originFetch{done: make(chan struct{})}
+	f.inflight[key] = fetch
+	f.mu.Unlock()
+
+	go func() {
+		if origin := c.originFor(key); origin != nil {
+			fetch.value, fetch.found, fetch.err = c.fetchOrigin(context.Background(), f.client, origin, key)
+		}
+		if fetch.err != nil {
+			c.refreshStats.failed.Add(1)
+		} else {
+			c.refreshStats.refreshed.Add(1)
+		}
+
+		f.mu.Lock()
+		delete(f.inflight, key)
+		f.mu.Unlock()
+		close(fetch.done)
+	}()
+}
+
+// RefreshAheadStats returns the refreshes reads started that succeeded and
+// those that failed
+func (c *Cache) RefreshAheadStats() (refreshed, failed int64) {
+	return c.refreshStats.refreshed.Load(), c.refreshStats.failed.Load()
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "REFRESHAHEAD", Arity: -2, Flags: FlagAdmin, Handler: refreshAheadCommand},
+	)
+}
+
+// refreshAheadCommand implements REFRESHAHEAD SET pattern fraction,
+// REFRESHAHEAD DEL pattern and REFRESHAHEAD LIST. A pattern is a key or a
+// prefix ending in "*".
+func refreshAheadCommand(ctx *CommandContext) error {
+	switch sub := strings.ToUpper(string(ctx.Args[1])); {
+	case sub == "SET" && len(ctx.Args) == 4:
+		fraction, err := strconv.ParseFloat(string(ctx.Args[3]), 64)
+		if err != nil {
+			return errInvalidRefreshFraction
+		}
+		if err := ctx.Cache.MarkRefreshAhead(string(ctx.Args[2]), fraction); err != nil {
+			return err
+		}
+		ctx.Out.WriteSimpleString("OK")
+	case sub == "DEL" && len(ctx.Args) == 3:
+		ctx.Out.WriteInteger(BoolInt(ctx.Cache.UnmarkRefreshAhead(string(ctx.Args[2]))))
+	case sub == "LIST" && len(ctx.Args) == 2:
+		marks := ctx.Cache.RefreshAheadMarks()
+		ctx.Out.WriteArrayHeader(2 * len(marks))
+		for _, mark := range marks {
+			ctx.Out.WriteBulkString(mark.Pattern)
+			ctx.Out.WriteBulkString(fmt.Sprintf("%g", mark.Fraction))
+		}
+	default:
+		return ErrSyntax
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RemovalReason says why an entry left the cache
+type RemovalReason string
+
+const (
+	RemovalExpired       RemovalReason = "expired"
+	RemovalEvictedLRU    RemovalReason = "evicted-lru"
+	RemovalEvictedMemory RemovalReason = "evicted-memory"
+	RemovalDeleted       RemovalReason = "deleted"
+	// RemovalIdle is an eviction by the idle reaper
+	RemovalIdle RemovalReason = "evicted-idle"
+)
+
+// ParseRemovalReason parses a reason name such as "evicted-lru"
+func ParseRemovalReason(name string) (RemovalReason, error) {
+	switch reason := RemovalReason(name); reason {
+	case RemovalExpired, RemovalEvictedLRU, RemovalEvictedMemory, RemovalDeleted, RemovalIdle:
+		return reason, nil
+	default:
+		return "", fmt.Errorf("unknown removal reason: %s", name)
+	}
+}
+
+// eventType maps a removal reason to the keyspace event published for it
+func (r RemovalReason) eventType() KeyEventType {
+	switch r {
+	case RemovalExpired:
+		return KeyEventExpired
+	case RemovalEvictedLRU, RemovalEvictedMemory, RemovalIdle:
+		return KeyEventEvicted
+	default:
+		return KeyEventDelete
+	}
+}
+
+// RemovalEvent describes an entry that left the cache. Value is nil for
+// non-string types.
+type RemovalEvent struct {
+	Key    string        `json:"key"`
+	Value  []byte        `json:"value,omitempty"`
+	Reason RemovalReason `json:"reason"`
+	Time   time.Time     `json:"time"`
+}
+
+// RemovalListener is called for every entry leaving the cache. Listeners
+// run on a separate goroutine, so they may call back into the cache.
+type RemovalListener func(event RemovalEvent)
+
+// removalQueueSize bounds events awaiting delivery to listeners; beyond it
+// events are dropped rather than stalling cache writes
+const removalQueueSize = 4096
+
+// eventDispatcher delivers events to listeners in order on its own goroutine
+type eventDispatcher[E any] struct {
+	mu        sync.RWMutex
+	listeners map[int]func(E)
+	nextID    int
+	count     int32
+	queue     chan E
+	dropped   int64
+	startOnce sync.Once
+}
+
+func newEventDispatcher[E any](queueSize int) *eventDispatcher[E] {
+	return &eventDispatcher[E]{
+		listeners: make(map[int]func(E)),
+		queue:     make(chan E, queueSize),
+	}
+}
+
+func (d *eventDispatcher[E]) add(fn func(E)) func() {
+	d.startOnce.Do(func() { go d.run() })
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	id := d.nextID
+	d.nextID++
+	d.listeners[id] = fn
+	atomic.AddInt32(&d.count, 1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.mu.Lock()
+			delete(d.listeners, id)
+			d.mu.Unlock()
+			atomic.AddInt32(&d.count, -1)
+		})
+	}
+}
+
+// dispatch queues an event without blocking
+func (d *eventDispatcher[E]) dispatch(event E) {
+	if atomic.LoadInt32(&d.count) == 0 {
+		return
+	}
+	select {
+	case d.queue <- event:
+	default:
+		atomic.AddInt64(&d.dropped, 1)
+	}
+}
+
+func (d *eventDispatcher[E]) run() {
+	for event := range d.queue {
+		d.mu.RLock()
+		for _, fn := range d.listeners {
+			fn(event)
+		}
+		d.mu.RUnlock()
+	}
+}
+
+// OnRemoval registers a listener for entries leaving the cache through
+// expiry, eviction or deletion, and returns a function that unregisters it.
+// Clear does not report individual entries. Events are dropped if listeners
+// fall far behind; see DroppedRemovals.
+func (c *Cache) OnRemoval(fn RemovalListener) func() {
+	return c.removals.add(fn)
+}
+
+// DroppedRemovals returns how many removal events were not delivered because
+// listeners fell behind
+func (c *Cache) DroppedRemovals() int64 {
+	return atomic.LoadInt64(&c.removals.dropped)
+}
+
+// dropEntry removes an entry and reports why
+func (s *cacheShard) dropEntry(entry *CacheEntry, reason RemovalReason) {
+	c := s.cache
+	s.removeEntry(entry)
+	if reason.eventType() == KeyEventEvicted {
+		s.evictions[reason]++
+	}
+	c.notifier.publishRemoval(entry.Key, reason)
+	c.removals.dispatch(RemovalEvent{
+		Key:    entry.Key,
+		Value:  entry.Value,
+		Reason: reason,
+		Time:   time.Now(),
+	})
+	c.updatePressure()
+}
+
+// Removal webhook tuning
+const (
+	webhookBatchSize     = 100
+	webhookFlushInterval = time.Second
+	webhookTimeout       = 10 * time.Second
+)
+
+// RemovalWebhook posts removal events as JSON arrays to an HTTP endpoint,
+// batching them to limit request volume. Delivery is best effort: a failed
+// batch is logged and discarded.
+type RemovalWebhook struct {
+	url     string
+	reasons map[RemovalReason]bool
+	client  *http.Client
+	logger  *log.Logger
+
+	events     chan RemovalEvent
+	unregister func()
+	done       chan struct{}
+}
+
+// StartRemovalWebhook starts posting removal events from c to url. If
+// reasons is non-empty only those reasons are sent.
+func StartRemovalWebhook(c *Cache, url string, reasons []RemovalReason, logger *log.Logger) *RemovalWebhook {
+	w := &RemovalWebhook{
+		url:     url,
+		reasons: make(map[RemovalReason]bool),
+		client:  &http.Client{Timeout: webhookTimeout},
+		logger:  logger,
+		events:  make(chan RemovalEvent, removalQueueSize),
+		done:    make(chan struct{}),
+	}
+	for _, reason := range reasons {
+		w.reasons[reason] = true
+	}
+
+	w.unregister = c.OnRemoval(func(event RemovalEvent) {
+		if len(w.reasons) > 0 && !w.reasons[event.Reason] {
+			return
+		}
+		select {
+		case w.events <- event:
+		default:
+			w.logger.Printf("Removal webhook queue full, dropping event for %q", event.Key)
+		}
+	})
+
+	go w.run()
+	return w
+}
+
+// Close stops the webhook after sending queued events
+func (w *RemovalWebhook) Close() {
+	w.unregister()
+	close(w.events)
+	<-w.done
+}
+
+func (w *RemovalWebhook) run() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(webhookFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]RemovalEvent, 0, webhookBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := w.post(batch); err != nil {
+			w.logger.Printf("Removal webhook failed, dropped %d events: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case event, ok := <-w.events:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= webhookBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (w *RemovalWebhook) post(batch []RemovalEvent) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", webhookContentType)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package cache
+
+// Rename moves the value at src to dst with its type, TTL, tags, cost,
+// origin and pin. Any value at dst is replaced, unless nx is set, in which
+// case nothing is moved and Rename reports false. Renaming a key to itself
+// leaves it in place. Both keys' shards are locked for the move, so a
+// rename is atomic however the two keys hash. A pinned value that no
+// longer fits within the pinned memory limit under its longer name is
+// moved unpinned.
+func (c *Cache) Rename(src, dst string, nx bool) (bool, error) {
+	defer unlockShards(c.lockKeys(src, dst))
+
+	from, to := c.shardFor(src), c.shardFor(dst)
+	entry := from.lookupLive(src)
+	if entry == nil {
+		return false, ErrNoSuchKey
+	}
+	if src == dst {
+		return !nx, nil
+	}
+	if existing := to.lookupLive(dst); existing != nil {
+		if nx {
+			return false, nil
+		}
+		to.dropEntry(existing, RemovalDeleted)
+	}
+
+	// Observers see src deleted and dst set
+	from.dropEntry(entry, RemovalDeleted)
+	moved := &CacheEntry{
+		Key:          dst,
+		Value:        entry.Value,
+		Object:       entry.Object,
+		Tags:         entry.Tags,
+		ExpiresAt:    entry.ExpiresAt,
+		SlidingTTL:   entry.SlidingTTL,
+		Cost:         entry.Cost,
+		Origin:       entry.Origin,
+		CreatedAt:    entry.CreatedAt,
+		AccessCount:  entry.AccessCount,
+		LastAccessed: entry.LastAccessed,
+	}
+	moved.Pinned = entry.Pinned && c.pinFits(moved.memoryUsage())
+	to.insertEntry(moved)
+	return true, nil
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "RENAME", Arity: 3, Flags: FlagWrite, Handler: renameCommand, KeyArgs: allKeyArgs},
+		&Command{Name: "RENAMENX", Arity: 3, Flags: FlagWrite, Handler: renameCommand, KeyArgs: allKeyArgs},
+	)
+}
+
+// renameCommand implements RENAME src dst, replying OK, and RENAMENX src
+// dst, replying 1 if dst was free and 0 otherwise
+func renameCommand(ctx *CommandContext) error {
+	nx := ctx.Command.Name == "RENAMENX"
+	renamed, err := ctx.Cache.Rename(string(ctx.Args[1]), string(ctx.Args[2]), nx)
+	if err != nil {
+		return err
+	}
+	if nx {
+		ctx.Out.WriteInteger(BoolInt(renamed))
+	} else {
+		ctx.Out.WriteSimpleString("OK")
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "NODE.REPLSTATUS", Arity: 2, Flags: FlagAdmin, Handler: nodeReplStatusCommand},
+	)
+}
+
+// Stale read policies, applied to reads on a replica lagging more than
+// ClusterConfig.MaxStaleness behind its primary
+const (
+	// StaleReadsReject fails the read
+	StaleReadsReject = "reject"
+	// StaleReadsFlag serves the read, counts it and marks HTTP responses
+	// with the X-Cache-Stale header
+	StaleReadsFlag = "flag"
+)
+
+// StaleHeader carries a replica's staleness in seconds on HTTP reads
+// served beyond the bound
+const StaleHeader = "X-Cache-Stale"
+
+// errStaleReplica is returned for reads rejected by StaleReadsReject
+var errStaleReplica = errors.New("STALE replica is lagging")
+
+// replicaHeartbeatInterval is how often a mirror reports the replica's lag
+const replicaHeartbeatInterval = time.Second
+
+// ParseStaleReadPolicy validates a stale read policy name
+func ParseStaleReadPolicy(policy string) (string, error) {
+	switch strings.ToLower(policy) {
+	case StaleReadsReject:
+		return StaleReadsReject, nil
+	case StaleReadsFlag:
+		return StaleReadsFlag, nil
+	default:
+		return "", fmt.Errorf("invalid stale read policy %q: use %q or %q", policy, StaleReadsReject, StaleReadsFlag)
+	}
+}
+
+// ReplicaLag is how far this node is behind the primary replicating to it
+type ReplicaLag struct {
+	Primary string `json:"primary"`
+	// Offset is the primary's WAL position of the last write applied here
+	Offset uint64 `json:"offset"`
+	// LagRecords is how many writes logged by the primary are missing
+	LagRecords uint64 `json:"lag_records"`
+	// StalenessSeconds bounds how old the data served here may be. It
+	// keeps growing when the primary stops reporting.
+	StalenessSeconds float64 `json:"staleness_seconds"`
+	// LastReport is when the primary last reported the lag
+	LastReport time.Time `json:"last_report"`
+	// StaleReads counts reads served or rejected beyond MaxStaleness
+	StaleReads int64 `json:"stale_reads"`
+}
+
+// replicaState is what the primary last reported about this replica
+type replicaState struct {
+	status   replicationStatusMessage
+	received time.Time
+
+	maxStaleness time.Duration
+	policy       string
+	staleReads   int64
+}
+
+// ReplicaLag reports how far this node is behind its primary. It returns
+// false if no primary has reported replicating to this node.
+func (c *Cache) ReplicaLag() (ReplicaLag, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	if c.replica.received.IsZero() {
+		return ReplicaLag{}, false
+	}
+	return c.replicaLagLocked(time.Now()), true
+}
+
+func (c *Cache) replicaLagLocked(now time.Time) ReplicaLag {
+	r := &c.replica
+	lag := ReplicaLag{
+		Primary:    r.status.PrimaryID,
+		Offset:     r.status.Offset,
+		LastReport: r.received,
+		StaleReads: r.staleReads,
+		StalenessSeconds: (time.Duration(r.status.LagMillis)*time.Millisecond +
+			now.Sub(r.received)).Seconds(),
+	}
+	if r.status.PrimaryOffset > r.status.Offset {
+		lag.LagRecords = r.status.PrimaryOffset - r.status.Offset
+	}
+	return lag
+}
+
+// SetMaxStaleness applies policy to reads once this node lags more than
+// max behind its primary. Zero disables the bound.
+func (c *Cache) SetMaxStaleness(max time.Duration, policy string) error {
+	policy, err := ParseStaleReadPolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.replica.maxStaleness = max
+	c.replica.policy = policy
+	return nil
+}
+
+// CheckStaleness counts a read made beyond the staleness bound and
+// returns its staleness, or an error if the policy rejects it. Nodes no
+// primary reports to are never stale.
+func (c *Cache) CheckStaleness() (time.Duration, error) {
+	c.mutex.RLock()
+	bounded := c.replica.maxStaleness > 0 && !c.replica.received.IsZero()
+	c.mutex.RUnlock()
+	if !bounded {
+		return 0, nil
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	r := &c.replica
+	staleness := time.Duration(c.replicaLagLocked(time.Now()).StalenessSeconds * float64(time.Second))
+	if staleness <= r.maxStaleness {
+		return 0, nil
+	}
+	r.staleReads++
+	if r.policy == StaleReadsReject {
+		return staleness, fmt.Errorf("%w: %v behind the primary, the bound is %v",
+			errStaleReplica, staleness.Round(time.Millisecond), r.maxStaleness)
+	}
+	return staleness, nil
+}
+
+// nodeReplStatusCommand implements NODE.REPLSTATUS message, sent by a
+// primary's mirror to tell the replica how far behind it is
+func nodeReplStatusCommand(ctx *CommandContext) error {
+	var status replicationStatusMessage
+	if err := unmarshalMessage(ctx.Args[1], &status); err != nil {
+		return fmt.Errorf("ERR %v", err)
+	}
+
+	ctx.Cache.mutex.Lock()
+	ctx.Cache.replica.status = status
+	ctx.Cache.replica.received = time.Now()
+	ctx.Cache.mutex.Unlock()
+
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
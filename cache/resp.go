@@ -0,0 +1,244 @@
+package cache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+)
+
+// Protocol errors are sent to the client, which is then disconnected:
+//
+//	ERR Protocol error                              malformed RESP
+//	ERR Protocol error: invalid multibulk length    more than MaxArgs arguments
+//	ERR Protocol error: invalid bulk length         an argument over MaxBulkBytes
+//	ERR Protocol error: request too large           a command over MaxRequestBytes
+var (
+	ErrProtocol        = errors.New("ERR Protocol error")
+	errTooManyArgs     = errors.New("ERR Protocol error: invalid multibulk length")
+	errBulkTooLarge    = errors.New("ERR Protocol error: invalid bulk length")
+	errRequestTooLarge = errors.New("ERR Protocol error: request too large")
+)
+
+// ProtocolErrorReasons names each protocol error in metrics
+var ProtocolErrorReasons = map[error]string{
+	ErrProtocol:        "malformed",
+	errTooManyArgs:     "too_many_args",
+	errBulkTooLarge:    "bulk_too_large",
+	errRequestTooLarge: "request_too_large",
+}
+
+// RequestLimits bound the commands a client may send, so a malicious one
+// cannot make the server allocate without limit. Zero disables a limit.
+type RequestLimits struct {
+	// MaxArgs caps the arguments of a command, including its name
+	MaxArgs int `json:"max_args" toml:"max_args" yaml:"max_args"`
+	// MaxBulkBytes caps the size of one argument
+	MaxBulkBytes int64 `json:"max_bulk_bytes" toml:"max_bulk_bytes" yaml:"max_bulk_bytes"`
+	// MaxRequestBytes caps the size of a whole command
+	MaxRequestBytes int64 `json:"max_request_bytes" toml:"max_request_bytes" yaml:"max_request_bytes"`
+}
+
+// DefaultRequestLimits follows Redis's defaults
+func DefaultRequestLimits() RequestLimits {
+	return RequestLimits{
+		MaxArgs:         1024 * 1024,
+		MaxBulkBytes:    512 << 20,
+		MaxRequestBytes: 1 << 30,
+	}
+}
+
+// Lengths over math.MaxInt32 are refused even with the limits disabled, so
+// that sizes derived from them cannot overflow, a command's total among them
+const maxLength = math.MaxInt32
+
+// Buffers for a command are sized by what the client announced only up to
+// these bounds, and grow beyond them as the bytes actually arrive
+const (
+	maxPreallocArgs = 1024
+	bulkChunkSize   = 64 << 10
+)
+
+// RESPReader parses client commands sent as RESP arrays or inline text
+type RESPReader struct {
+	r      *bufio.Reader
+	limits RequestLimits
+}
+
+func NewRESPReader(r io.Reader, limits RequestLimits) *RESPReader {
+	return &RESPReader{r: bufio.NewReader(r), limits: limits}
+}
+
+// ReadCommand reads the next command and returns its arguments, with the
+// command name as the first element
+func (rr *RESPReader) ReadCommand() ([][]byte, error) {
+	line, err := rr.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	if line[0] != '*' {
+		// Inline command, as typed into telnet
+		fields := bytes.Fields(line)
+		if rr.limits.MaxArgs > 0 && len(fields) > rr.limits.MaxArgs {
+			return nil, errTooManyArgs
+		}
+		args := make([][]byte, len(fields))
+		for i, field := range fields {
+			args[i] = append([]byte(nil), field...)
+		}
+		return args, nil
+	}
+
+	count, err := strconv.Atoi(string(line[1:]))
+	if err != nil || count < 0 {
+		return nil, ErrProtocol
+	}
+	if count > maxLength || rr.limits.MaxArgs > 0 && count > rr.limits.MaxArgs {
+		return nil, errTooManyArgs
+	}
+
+	total := int64(len(line))
+	args := make([][]byte, 0, min(count, maxPreallocArgs))
+	for len(args) < count {
+		line, err := rr.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, ErrProtocol
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 {
+			return nil, ErrProtocol
+		}
+		if size > maxLength || rr.limits.MaxBulkBytes > 0 && int64(size) > rr.limits.MaxBulkBytes {
+			return nil, errBulkTooLarge
+		}
+		total += int64(len(line)) + int64(size)
+		if rr.limits.MaxRequestBytes > 0 && total > rr.limits.MaxRequestBytes {
+			return nil, errRequestTooLarge
+		}
+
+		arg, err := rr.readBulk(size)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk reads a bulk argument of size bytes and its trailing CRLF, in
+// chunks of bulkChunkSize
+func (rr *RESPReader) readBulk(size int) ([]byte, error) {
+	buf := make([]byte, 0, min(size+2, bulkChunkSize))
+	for len(buf) < size+2 {
+		n := min(size+2-len(buf), bulkChunkSize)
+		buf = slices.Grow(buf, n)[:len(buf)+n]
+		if _, err := io.ReadFull(rr.r, buf[len(buf)-n:]); err != nil {
+			return nil, err
+		}
+	}
+	if buf[size] != '\r' || buf[size+1] != '\n' {
+		return nil, ErrProtocol
+	}
+	return buf[:size], nil
+}
+
+// Buffered reports whether more input is already buffered, allowing
+// pipelined replies to be flushed together
+func (rr *RESPReader) Buffered() bool {
+	return rr.r.Buffered() > 0
+}
+
+func (rr *RESPReader) readLine() ([]byte, error) {
+	line, err := rr.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, ErrProtocol
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// RESPWriter encodes replies in RESP
+type RESPWriter struct {
+	w *bufio.Writer
+}
+
+func NewRESPWriter(w io.Writer) *RESPWriter {
+	return &RESPWriter{w: bufio.NewWriter(w)}
+}
+
+// WriteSimpleString writes a status reply such as +OK
+func (rw *RESPWriter) WriteSimpleString(s string) {
+	rw.w.WriteByte('+')
+	rw.w.WriteString(s)
+	rw.w.WriteString("\r\n")
+}
+
+// WriteError writes an error reply. msg should start with an error code
+// such as ERR or WRONGTYPE.
+func (rw *RESPWriter) WriteError(msg string) {
+	rw.w.WriteByte('-')
+	rw.w.WriteString(msg)
+	rw.w.WriteString("\r\n")
+}
+
+// WriteInteger writes an integer reply
+func (rw *RESPWriter) WriteInteger(n int64) {
+	rw.w.WriteByte(':')
+	rw.w.WriteString(strconv.FormatInt(n, 10))
+	rw.w.WriteString("\r\n")
+}
+
+// WriteBulk writes a bulk string reply
+func (rw *RESPWriter) WriteBulk(b []byte) {
+	rw.w.WriteByte('$')
+	rw.w.WriteString(strconv.Itoa(len(b)))
+	rw.w.WriteString("\r\n")
+	rw.w.Write(b)
+	rw.w.WriteString("\r\n")
+}
+
+// WriteBulkString writes a bulk string reply from a string
+func (rw *RESPWriter) WriteBulkString(s string) {
+	rw.w.WriteByte('$')
+	rw.w.WriteString(strconv.Itoa(len(s)))
+	rw.w.WriteString("\r\n")
+	rw.w.WriteString(s)
+	rw.w.WriteString("\r\n")
+}
+
+// WriteNull writes a null bulk reply
+func (rw *RESPWriter) WriteNull() {
+	rw.w.WriteString("$-1\r\n")
+}
+
+// WriteArrayHeader starts an array reply of n elements
+func (rw *RESPWriter) WriteArrayHeader(n int) {
+	rw.w.WriteByte('*')
+	rw.w.WriteString(strconv.Itoa(n))
+	rw.w.WriteString("\r\n")
+}
+
+// WriteStringArray writes an array of bulk strings
+func (rw *RESPWriter) WriteStringArray(items []string) {
+	rw.WriteArrayHeader(len(items))
+	for _, item := range items {
+		rw.WriteBulkString(item)
+	}
+}
+
+// Flush sends buffered replies to the client
+func (rw *RESPWriter) Flush() error {
+	return rw.w.Flush()
+}
@@ -0,0 +1,35 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadCommandRejectsMalformedLengths(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  error
+	}{
+		{"negative count", "*-2\r\n", ErrProtocol},
+		{"count not a number", "*x\r\n", ErrProtocol},
+		{"count overflowing int", "*99999999999999999999\r\n", ErrProtocol},
+		{"count over the hard cap", "*2147483648\r\n", errTooManyArgs},
+		{"negative length", "*1\r\n$-1\r\n", ErrProtocol},
+		{"length not a number", "*1\r\n$x\r\n", ErrProtocol},
+		{"length overflowing int", "*1\r\n$99999999999999999999\r\n", ErrProtocol},
+		{"length over the hard cap", "*1\r\n$2147483648\r\n", errBulkTooLarge},
+		{"length near the int limit", "*1\r\n$9223372036854775806\r\n", errBulkTooLarge},
+		{"missing bulk header", "*1\r\nGET\r\n", ErrProtocol},
+		{"bulk without CRLF", "*1\r\n$3\r\nGETxx", ErrProtocol},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The limits are disabled, leaving only the hard caps
+			rr := NewRESPReader(strings.NewReader(tt.input), RequestLimits{})
+			if args, err := rr.ReadCommand(); err != tt.want {
+				t.Errorf("ReadCommand(%q) = %q, %v, want %v", tt.input, args, err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package cache
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "RESTORE", Arity: -4, Flags: FlagWrite, Handler: restoreCommand, KeyArgs: firstKeyArg},
+	)
+}
+
+// Errors returned by RESTORE, worded as Redis words them
+var (
+	errBusyKey       = errors.New("BUSYKEY Target key name already exists.")
+	errBadDumpFormat = errors.New("ERR DUMP payload version or checksum are wrong")
+	errBadRDBData    = errors.New("ERR Bad data format")
+)
+
+// MaxValueSize bounds the size of a value accepted over the REST API or
+// restored from a DUMP payload
+const MaxValueSize = 512 * 1024 * 1024
+
+// maxRDBVersion is the newest Redis RDB version whose DUMP payloads are
+// accepted
+const maxRDBVersion = 12
+
+// RDB value type and encodings used by string payloads
+const (
+	rdbTypeString = 0
+
+	rdbLen6bit  = 0
+	rdbLen14bit = 1
+	rdbLen32bit = 0x80
+	rdbLen64bit = 0x81
+	rdbEncVal   = 3
+
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+// redisCRCTable is the reflected Jones polynomial Redis checksums DUMP
+// payloads with. Redis does not invert the CRC before or after, unlike
+// hash/crc64, so only the table is shared.
+var redisCRCTable = crc64.MakeTable(0x95ac9329ac4bc9b5)
+
+func redisCRC64(data []byte) uint64 {
+	var crc uint64
+	for _, b := range data {
+		crc = redisCRCTable[byte(crc)^b] ^ (crc >> 8)
+	}
+	return crc
+}
+
+// restoreCommand implements
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL] [IDLETIME s] [FREQ f]
+// for payloads produced by Redis's DUMP. Only string values can be
+// restored; IDLETIME and FREQ are accepted and ignored.
+func restoreCommand(ctx *CommandContext) error {
+	key := string(ctx.Args[1])
+	ttl, err := ParseInt(ctx.Args[2])
+	if err != nil {
+		return err
+	}
+	if ttl < 0 {
+		return errors.New("ERR Invalid TTL value, must be >= 0")
+	}
+
+	var replace, absTTL bool
+	for i := 4; i < len(ctx.Args); i++ {
+		switch strings.ToUpper(string(ctx.Args[i])) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		case "IDLETIME", "FREQ":
+			if i+1 >= len(ctx.Args) {
+				return ErrSyntax
+			}
+			if _, err := ParseInt(ctx.Args[i+1]); err != nil {
+				return err
+			}
+			i++
+		default:
+			return ErrSyntax
+		}
+	}
+
+	value, err := decodeDumpPayload(ctx.Args[3])
+	if err != nil {
+		return err
+	}
+	if !replace && ctx.Cache.Type(key) != "none" {
+		return errBusyKey
+	}
+
+	var opts SetOptions
+	if ttl > 0 {
+		d := time.Duration(ttl) * time.Millisecond
+		if absTTL {
+			d = time.Until(time.Unix(0, ttl*int64(time.Millisecond)))
+		}
+		if d <= 0 {
+			// Already expired: the key is not created, as in Redis
+			if _, err := ctx.Cache.Delete(ctx.Context, key); err != nil {
+				return errors.New("ERR " + err.Error())
+			}
+			ctx.Out.WriteSimpleString("OK")
+			return nil
+		}
+		opts.TTL = &d
+	}
+	if err := ctx.Cache.SetWithOptions(ctx.Context, key, value, opts); err != nil {
+		if err == ErrOOM {
+			return err
+		}
+		return errors.New("ERR " + err.Error())
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// decodeDumpPayload verifies a Redis DUMP payload, laid out as the RDB
+// encoding of the value, a 2-byte RDB version and an 8-byte CRC64, all
+// little-endian, and returns the string it holds
+func decodeDumpPayload(payload []byte) ([]byte, error) {
+	if len(payload) < 10 {
+		return nil, errBadDumpFormat
+	}
+	body := payload[:len(payload)-8]
+	version := binary.LittleEndian.Uint16(body[len(body)-2:])
+	if version > maxRDBVersion {
+		return nil, errBadDumpFormat
+	}
+	if redisCRC64(body) != binary.LittleEndian.Uint64(payload[len(payload)-8:]) {
+		return nil, errBadDumpFormat
+	}
+
+	data := body[:len(body)-2]
+	if data[0] != rdbTypeString {
+		return nil, errors.New("ERR only string values can be restored")
+	}
+	d := &rdbDecoder{data: data[1:]}
+	value, err := d.string()
+	if err != nil || len(d.data) != 0 {
+		return nil, errBadRDBData
+	}
+	return value, nil
+}
+
+// rdbDecoder reads RDB-encoded lengths and strings
+type rdbDecoder struct {
+	data []byte
+}
+
+func (d *rdbDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data) < n {
+		return nil, errBadRDBData
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b, nil
+}
+
+// length reads a length, or reports that the value is specially encoded
+// and returns the encoding
+func (d *rdbDecoder) length() (n uint64, encoded bool, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, false, err
+	}
+	switch b[0] >> 6 {
+	case rdbLen6bit:
+		return uint64(b[0] & 0x3f), false, nil
+	case rdbLen14bit:
+		low, err := d.next(1)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b[0]&0x3f)<<8 | uint64(low[0]), false, nil
+	case rdbEncVal:
+		return uint64(b[0] & 0x3f), true, nil
+	}
+	switch b[0] {
+	case rdbLen32bit:
+		v, err := d.next(4)
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(v)), false, nil
+	case rdbLen64bit:
+		v, err := d.next(8)
+		if err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(v), false, nil
+	}
+	return 0, false, errBadRDBData
+}
+
+// string reads a plain, integer-encoded or LZF-compressed string
+func (d *rdbDecoder) string() ([]byte, error) {
+	n, encoded, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		if n > uint64(len(d.data)) {
+			return nil, errBadRDBData
+		}
+		b, err := d.next(int(n))
+		return append([]byte(nil), b...), err
+	}
+
+	switch n {
+	case rdbEncInt8:
+		b, err := d.next(1)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int8(b[0])), 10), nil
+	case rdbEncInt16:
+		b, err := d.next(2)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int16(binary.LittleEndian.Uint16(b))), 10), nil
+	case rdbEncInt32:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(nil, int64(int32(binary.LittleEndian.Uint32(b))), 10), nil
+	case rdbEncLZF:
+		compressedLen, _, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		rawLen, _, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		if compressedLen > uint64(len(d.data)) || rawLen > MaxValueSize {
+			return nil, errBadRDBData
+		}
+		compressed, err := d.next(int(compressedLen))
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, int(rawLen))
+	}
+	return nil, errBadRDBData
+}
+
+// lzfDecompress expands LZF data, as used by RDB, to exactly rawLen bytes
+func lzfDecompress(in []byte, rawLen int) ([]byte, error) {
+	out := make([]byte, 0, rawLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			// Literal run of ctrl+1 bytes
+			n := ctrl + 1
+			if i+n > len(in) || len(out)+n > rawLen {
+				return nil, errBadRDBData
+			}
+			out = append(out, in[i:i+n]...)
+			i += n
+			continue
+		}
+
+		// Back reference
+		n := ctrl >> 5
+		if n == 7 {
+			if i >= len(in) {
+				return nil, errBadRDBData
+			}
+			n += int(in[i])
+			i++
+		}
+		n += 2
+		if i >= len(in) {
+			return nil, errBadRDBData
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 || len(out)+n > rawLen {
+			return nil, errBadRDBData
+		}
+		for j := 0; j < n; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != rawLen {
+		return nil, errBadRDBData
+	}
+	return out, nil
+}
@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/client"
+)
+
+// A cluster backup is a full snapshot from each node of a client-side
+// ring. restore-cluster redistributes the keys of such a backup onto a
+// ring of another size: it reads every snapshot, assigns each live key to
+// the nodes the target ring maps it to, and writes one snapshot per target
+// node for it to load at startup. Each written file is read back and
+// checked before the tool reports success.
+
+// restoreManifestName is the file describing a remapped backup
+const restoreManifestName = "manifest.json"
+
+// restoreTarget is one target node's share of a remapped backup
+type restoreTarget struct {
+	Node     string `json:"node"`
+	File     string `json:"file"`
+	Entries  int    `json:"entries"`
+	Bytes    int64  `json:"bytes"`
+	Verified bool   `json:"verified"`
+
+	data snapshotData
+	keys map[string]int
+}
+
+// restoreManifest records how a backup was remapped
+type restoreManifest struct {
+	Created    time.Time        `json:"created"`
+	Sources    []string         `json:"sources"`
+	Nodes      []string         `json:"nodes"`
+	Replicas   int              `json:"replicas"`
+	Keys       int              `json:"keys"`
+	Duplicates int              `json:"duplicates"`
+	Expired    int              `json:"expired"`
+	Targets    []*restoreTarget `json:"targets"`
+}
+
+// RunRestoreCluster implements the restore-cluster tool
+func RunRestoreCluster(args []string) int {
+	flags := flag.NewFlagSet("restore-cluster", flag.ContinueOnError)
+	nodes := flags.String("nodes", "", "Comma-separated addresses of the target ring's nodes, as clients name them")
+	weights := flags.String("weights", "", "Comma-separated addr=weight pairs of the target ring")
+	replicas := flags.Int("replicas", 1, "Nodes each key is written to, as the ring's Replicas option")
+	out := flags.String("out", "", "Directory to write a snapshot per target node to")
+	compress := flags.Bool("compress", true, "Compress the written snapshots")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: restore-cluster -nodes addr,... -out dir [options] <snapshot>...")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *nodes == "" || *out == "" || flags.NArg() == 0 || *replicas < 1 {
+		flags.Usage()
+		return 2
+	}
+	ring, err := parseRingWeights(*weights)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -weights: %v\n", err)
+		return 2
+	}
+
+	manifest := &restoreManifest{
+		Created:  time.Now(),
+		Sources:  flags.Args(),
+		Nodes:    strings.Split(*nodes, ","),
+		Replicas: *replicas,
+	}
+	if manifest.Replicas > len(manifest.Nodes) {
+		manifest.Replicas = len(manifest.Nodes)
+	}
+	layout := client.NewRingLayout(manifest.Nodes, ring)
+	targets := make(map[string]*restoreTarget, len(manifest.Nodes))
+	for _, node := range manifest.Nodes {
+		target := &restoreTarget{
+			Node: node,
+			File: filepath.Join(*out, restoreFileName(node)),
+			keys: make(map[string]int),
+		}
+		targets[node] = target
+		manifest.Targets = append(manifest.Targets, target)
+	}
+
+	// Distribute the keys of each source snapshot
+	now := time.Now().UnixMilli()
+	seen := make(map[string]bool)
+	for _, path := range manifest.Sources {
+		data, info, err := readSnapshotFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read %s: %v\n", path, err)
+			return 1
+		}
+		if !data.base.IsZero() {
+			fmt.Fprintf(os.Stderr, "%s is an incremental snapshot; restore-cluster needs a full snapshot of each node\n", path)
+			return 1
+		}
+		for _, se := range data.entries {
+			if se.expiresAt != 0 && se.expiresAt <= now {
+				manifest.Expired++
+				continue
+			}
+			if seen[se.key] {
+				// The old ring kept replicas of the key on several nodes
+				manifest.Duplicates++
+				continue
+			}
+			seen[se.key] = true
+			for _, node := range layout.Owners(se.key, manifest.Replicas) {
+				target := targets[node]
+				target.keys[se.key] = len(target.data.entries)
+				target.data.entries = append(target.data.entries, se)
+			}
+		}
+		fmt.Printf("Read %s: %d entries, taken %s\n", path, info.Entries, info.Created.Format(time.RFC3339))
+	}
+	manifest.Keys = len(seen)
+
+	// Write and verify each target node's snapshot
+	opts := SnapshotOptions{Compress: *compress}
+	for _, target := range manifest.Targets {
+		info, err := saveSnapshotFile(target.File, func(w io.Writer) (SnapshotInfo, error) {
+			return writeSnapshot(context.Background(), w, target.data, manifest.Created, opts)
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot write %s: %v\n", target.File, err)
+			return 1
+		}
+		target.Entries, target.Bytes = info.Entries, info.Bytes
+		share := 0.0
+		if manifest.Keys > 0 {
+			share = 100 * float64(target.Entries) / float64(manifest.Keys)
+		}
+		fmt.Printf("Wrote %s for %s: %d entries (%.1f%% of keys), %d bytes\n", target.File, target.Node, target.Entries, share, target.Bytes)
+
+		if err := verifyRestoreTarget(target, layout, manifest.Replicas); err != nil {
+			fmt.Fprintf(os.Stderr, "Verification of %s failed: %v\n", target.File, err)
+			return 1
+		}
+		target.Verified = true
+	}
+
+	manifestData, _ := json.MarshalIndent(manifest, "", "  ")
+	if err := os.WriteFile(filepath.Join(*out, restoreManifestName), append(manifestData, '\n'), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot write manifest: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Remapped %d keys from %d snapshots onto %d nodes (%d duplicates, %d expired skipped); all files verified\n",
+		manifest.Keys, len(manifest.Sources), len(manifest.Nodes), manifest.Duplicates, manifest.Expired)
+	return 0
+}
+
+// verifyRestoreTarget reads back a written snapshot, checking its checksum,
+// that it holds exactly the entries assigned to its node and that the
+// target ring maps each of them there
+func verifyRestoreTarget(target *restoreTarget, layout *client.RingLayout, replicas int) error {
+	data, _, err := readSnapshotFile(target.File)
+	if err != nil {
+		return err
+	}
+	if len(data.entries) != len(target.data.entries) {
+		return fmt.Errorf("holds %d entries, want %d", len(data.entries), len(target.data.entries))
+	}
+	for _, se := range data.entries {
+		i, ok := target.keys[se.key]
+		if !ok || string(target.data.entries[i].value) != string(se.value) {
+			return fmt.Errorf("key %q does not match the source", se.key)
+		}
+		owned := false
+		for _, node := range layout.Owners(se.key, replicas) {
+			owned = owned || node == target.Node
+		}
+		if !owned {
+			return fmt.Errorf("key %q does not belong on %s", se.key, target.Node)
+		}
+	}
+	return nil
+}
+
+// readSnapshotFile decodes and verifies the snapshot at path
+func readSnapshotFile(path string) (snapshotData, SnapshotInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return snapshotData{}, SnapshotInfo{}, err
+	}
+	defer file.Close()
+
+	return readSnapshot(file, SnapshotLoadOptions{})
+}
+
+// restoreFileName is the snapshot file name for a target node
+func restoreFileName(node string) string {
+	return strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(node) + ".snap"
+}
+
+// parseRingWeights parses addr=weight pairs
+func parseRingWeights(list string) (map[string]int, error) {
+	weights := make(map[string]int)
+	if list == "" {
+		return weights, nil
+	}
+	for _, pair := range strings.Split(list, ",") {
+		addr, w, ok := strings.Cut(pair, "=")
+		weight, err := strconv.Atoi(w)
+		if !ok || err != nil || weight < 1 {
+			return nil, fmt.Errorf("invalid weight %q", pair)
+		}
+		weights[addr] = weight
+	}
+	return weights, nil
+}
@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"math/rand"
+	"time"
+)
+
+// defaultEvictionSamples matches the Redis maxmemory-samples default
+const defaultEvictionSamples = 5
+
+// sampledLRUPolicy approximates LRU the way Redis does: it samples a few
+// random entries and evicts the one accessed longest ago. Reads only update
+// LastAccessed, which the cache does anyway, so no list is reordered on the
+// hot path.
+type sampledLRUPolicy struct {
+	entries []*CacheEntry
+	samples int
+	rng     *rand.Rand
+}
+
+func newSampledLRUPolicy(samples int) *sampledLRUPolicy {
+	if samples <= 0 {
+		samples = defaultEvictionSamples
+	}
+	return &sampledLRUPolicy{
+		samples: samples,
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (p *sampledLRUPolicy) Add(entry *CacheEntry) {
+	entry.slot = len(p.entries)
+	p.entries = append(p.entries, entry)
+}
+
+func (p *sampledLRUPolicy) Access(entry *CacheEntry) {}
+
+func (p *sampledLRUPolicy) Remove(entry *CacheEntry) {
+	last := len(p.entries) - 1
+	moved := p.entries[last]
+	p.entries[entry.slot] = moved
+	moved.slot = entry.slot
+	p.entries[last] = nil
+	p.entries = p.entries[:last]
+}
+
+// compact releases slots left behind by removals
+func (p *sampledLRUPolicy) compact() {
+	if cap(p.entries) > 2*len(p.entries) {
+		p.entries = append(make([]*CacheEntry, 0, len(p.entries)), p.entries...)
+	}
+}
+
+func (p *sampledLRUPolicy) Victim() *CacheEntry {
+	if len(p.entries) == 0 {
+		return nil
+	}
+	var victim *CacheEntry
+	for i := 0; i < p.samples; i++ {
+		candidate := p.entries[p.rng.Intn(len(p.entries))]
+		if victim == nil || candidate.LastAccessed.Before(victim.LastAccessed) {
+			victim = candidate
+		}
+	}
+	return victim
+}
+
+func (p *sampledLRUPolicy) Len() int {
+	return len(p.entries)
+}
+
+func (p *sampledLRUPolicy) Reset() {
+	p.entries = nil
+}
@@ -0,0 +1,511 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Scheduled job tasks
+const (
+	// TaskSnapshot saves a full or incremental snapshot, as SaveSnapshots
+	TaskSnapshot = "snapshot"
+	// TaskPurgePrefix deletes the keys under Prefix
+	TaskPurgePrefix = "purge-prefix"
+	// TaskWarmup refetches Keys from their namespace's origin
+	TaskWarmup = "warmup"
+	// TaskReport writes an INFO report into the directory Path
+	TaskReport = "report"
+)
+
+// defaultJobHistory is how many runs of each job are remembered
+const defaultJobHistory = 20
+
+// ErrUnknownJob is returned for a job name that is not configured
+var ErrUnknownJob = errors.New("no such job")
+
+// SchedulerConfig configures jobs run on cron schedules
+type SchedulerConfig struct {
+	Jobs []ScheduledJobConfig `json:"jobs" toml:"jobs" yaml:"jobs"`
+	// History is how many runs of each job are kept for SCHEDULE HISTORY
+	History int `json:"history" toml:"history" yaml:"history"`
+}
+
+// ScheduledJobConfig is one job. Schedule is a standard five-field cron
+// expression, or a descriptor such as "@hourly" or "@every 10m".
+type ScheduledJobConfig struct {
+	Name     string   `json:"name" toml:"name" yaml:"name"`
+	Schedule string   `json:"schedule" toml:"schedule" yaml:"schedule"`
+	Task     string   `json:"task" toml:"task" yaml:"task"`
+	Prefix   string   `json:"prefix" toml:"prefix" yaml:"prefix"`
+	Keys     []string `json:"keys" toml:"keys" yaml:"keys"`
+	Path     string   `json:"path" toml:"path" yaml:"path"`
+}
+
+// Validate checks the jobs' names, schedules and task arguments
+func (c SchedulerConfig) Validate() error {
+	if c.History < 0 {
+		return fmt.Errorf("scheduler history cannot be negative")
+	}
+	names := make(map[string]bool, len(c.Jobs))
+	for _, job := range c.Jobs {
+		if job.Name == "" || strings.ContainsAny(job.Name, " \t\r\n") {
+			return fmt.Errorf("invalid job name %q", job.Name)
+		}
+		if names[job.Name] {
+			return fmt.Errorf("duplicate job %q", job.Name)
+		}
+		names[job.Name] = true
+		if _, err := cron.ParseStandard(job.Schedule); err != nil {
+			return fmt.Errorf("job %s: invalid schedule %q: %v", job.Name, job.Schedule, err)
+		}
+		switch job.Task {
+		case TaskSnapshot:
+		case TaskPurgePrefix:
+			if job.Prefix == "" {
+				return fmt.Errorf("job %s: %s needs a prefix", job.Name, job.Task)
+			}
+		case TaskWarmup:
+			if len(job.Keys) == 0 {
+				return fmt.Errorf("job %s: %s needs keys", job.Name, job.Task)
+			}
+		case TaskReport:
+			if job.Path == "" {
+				return fmt.Errorf("job %s: %s needs a path", job.Name, job.Task)
+			}
+		default:
+			return fmt.Errorf("job %s: unknown task %q", job.Name, job.Task)
+		}
+	}
+	return nil
+}
+
+// JobRun is the outcome of one run of a job
+type JobRun struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration"`
+	// Manual is set for runs started by SCHEDULE RUN
+	Manual bool   `json:"manual,omitempty"`
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// JobStatus describes a job and its recent runs, newest first
+type JobStatus struct {
+	Name     string    `json:"name"`
+	Schedule string    `json:"schedule"`
+	Task     string    `json:"task"`
+	Paused   bool      `json:"paused"`
+	Running  bool      `json:"running"`
+	Next     time.Time `json:"next"`
+	History  []JobRun  `json:"history"`
+}
+
+// scheduledJob is a job's state
+type scheduledJob struct {
+	cfg      ScheduledJobConfig
+	schedule cron.Schedule
+	next     time.Time
+	paused   bool
+	running  bool
+	history  []JobRun
+}
+
+// Scheduler runs jobs on their cron schedules. A job still running when it
+// is next due is skipped rather than run twice at once.
+type Scheduler struct {
+	cache   *Cache
+	storage StorageConfig
+	logger  *log.Logger
+	history int
+
+	mu   sync.Mutex
+	jobs map[string]*scheduledJob
+	// wake interrupts the wait for the next due job
+	wake chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// StartScheduler starts running cfg's jobs on cache. Snapshot jobs write
+// where storage says.
+func StartScheduler(cache *Cache, cfg SchedulerConfig, storage StorageConfig, logger *log.Logger) (*Scheduler, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	history := cfg.History
+	if history == 0 {
+		history = defaultJobHistory
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Scheduler{
+		cache:   cache,
+		storage: storage,
+		logger:  logger,
+		history: history,
+		jobs:    make(map[string]*scheduledJob, len(cfg.Jobs)),
+		wake:    make(chan struct{}, 1),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	now := time.Now()
+	for _, jc := range cfg.Jobs {
+		schedule, _ := cron.ParseStandard(jc.Schedule)
+		s.jobs[jc.Name] = &scheduledJob{cfg: jc, schedule: schedule, next: schedule.Next(now)}
+	}
+	cache.attachScheduler(s)
+
+	s.wg.Add(1)
+	go s.loop()
+	return s, nil
+}
+
+// Close stops scheduling and waits for running jobs, which are cancelled
+func (s *Scheduler) Close() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// loop starts each job as it falls due
+func (s *Scheduler) loop() {
+	defer s.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		now := time.Now()
+		next := s.startDue(now)
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if !next.IsZero() {
+			timer.Reset(next.Sub(now))
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-timer.C:
+		case <-s.wake:
+		}
+	}
+}
+
+// startDue starts the jobs due at now and returns when the next one is,
+// or zero if none is scheduled
+func (s *Scheduler) startDue(now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, job := range s.jobs {
+		if !job.next.After(now) {
+			if job.running {
+				s.logger.Printf("Job %s is still running, skipping its run due at %s", job.cfg.Name, job.next.Format(time.RFC3339))
+			} else if !job.paused {
+				s.startLocked(job, false)
+			}
+			job.next = job.schedule.Next(now)
+		}
+		if !job.paused && (next.IsZero() || job.next.Before(next)) {
+			next = job.next
+		}
+	}
+	return next
+}
+
+// Run starts a job now, outside its schedule
+func (s *Scheduler) Run(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[name]
+	if !ok {
+		return ErrUnknownJob
+	}
+	if job.running {
+		return fmt.Errorf("job %s is already running", name)
+	}
+	s.startLocked(job, true)
+	return nil
+}
+
+// SetPaused stops a job from running on its schedule, or lets it again
+func (s *Scheduler) SetPaused(name string, paused bool) error {
+	s.mu.Lock()
+	job, ok := s.jobs[name]
+	if ok {
+		job.paused = paused
+		job.next = job.schedule.Next(time.Now())
+	}
+	s.mu.Unlock()
+	if !ok {
+		return ErrUnknownJob
+	}
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Jobs returns every job's status, sorted by name
+func (s *Scheduler) Jobs() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]JobStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, job.statusLocked())
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	return jobs
+}
+
+// Job returns one job's status
+func (s *Scheduler) Job(name string) (JobStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[name]
+	if !ok {
+		return JobStatus{}, ErrUnknownJob
+	}
+	return job.statusLocked(), nil
+}
+
+func (job *scheduledJob) statusLocked() JobStatus {
+	history := make([]JobRun, len(job.history))
+	for i, run := range job.history {
+		history[len(history)-1-i] = run
+	}
+	return JobStatus{
+		Name:     job.cfg.Name,
+		Schedule: job.cfg.Schedule,
+		Task:     job.cfg.Task,
+		Paused:   job.paused,
+		Running:  job.running,
+		Next:     job.next,
+		History:  history,
+	}
+}
+
+// startLocked runs job in the background
+func (s *Scheduler) startLocked(job *scheduledJob, manual bool) {
+	if s.ctx.Err() != nil {
+		return
+	}
+	job.running = true
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		run := JobRun{Started: time.Now(), Manual: manual}
+		result, err := s.execute(job.cfg)
+		run.Duration = time.Since(run.Started)
+		run.Result = result
+		if err != nil {
+			run.Error = err.Error()
+			s.logger.Printf("Job %s failed after %s: %v", job.cfg.Name, run.Duration, err)
+		}
+
+		s.mu.Lock()
+		job.running = false
+		job.history = append(job.history, run)
+		if len(job.history) > s.history {
+			job.history = job.history[len(job.history)-s.history:]
+		}
+		s.mu.Unlock()
+	}()
+}
+
+// execute performs a job's task, returning a summary of what it did
+func (s *Scheduler) execute(cfg ScheduledJobConfig) (string, error) {
+	switch cfg.Task {
+	case TaskSnapshot:
+		info, err := s.cache.SaveSnapshots(s.ctx, s.storage)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d entries, %d bytes", info.Entries, info.Bytes), nil
+	case TaskPurgePrefix:
+		return fmt.Sprintf("%d keys deleted", s.cache.DeletePrefix(cfg.Prefix)), nil
+	case TaskWarmup:
+		var refreshed, failed int
+		var firstErr error
+		for _, key := range cfg.Keys {
+			if s.ctx.Err() != nil {
+				return "", s.ctx.Err()
+			}
+			found, err := s.cache.RefreshFromOrigin(s.ctx, key)
+			switch {
+			case err != nil:
+				failed++
+				if firstErr == nil {
+					firstErr = err
+				}
+			case found:
+				refreshed++
+			}
+		}
+		result := fmt.Sprintf("%d of %d keys refreshed", refreshed, len(cfg.Keys))
+		if failed > 0 {
+			return result, fmt.Errorf("%d keys failed, first: %w", failed, firstErr)
+		}
+		return result, nil
+	case TaskReport:
+		path := filepath.Join(cfg.Path, "report-"+time.Now().UTC().Format("20060102T150405Z")+".txt")
+		if err := os.WriteFile(path, []byte(s.cache.Info()), 0o644); err != nil {
+			return "", err
+		}
+		return path, nil
+	default:
+		return "", fmt.Errorf("unknown task %q", cfg.Task)
+	}
+}
+
+// attachScheduler makes SCHEDULE commands control s
+func (c *Cache) attachScheduler(s *Scheduler) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.scheduler = s
+}
+
+// Scheduler returns the scheduler, if one has been started
+func (c *Cache) Scheduler() *Scheduler {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.scheduler
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "SCHEDULE", Arity: -2, Flags: FlagAdmin, Handler: scheduleCommand},
+	)
+}
+
+// scheduleCommand implements SCHEDULE LIST, SCHEDULE HISTORY job [count],
+// SCHEDULE RUN job, SCHEDULE PAUSE job and SCHEDULE RESUME job
+func scheduleCommand(ctx *CommandContext) error {
+	s := ctx.Cache.Scheduler()
+	if s == nil {
+		return errors.New("ERR no jobs are scheduled")
+	}
+
+	sub := strings.ToUpper(string(ctx.Args[1]))
+	if sub == "LIST" {
+		if len(ctx.Args) != 2 {
+			return ErrSyntax
+		}
+		jobs := s.Jobs()
+		ctx.Out.WriteArrayHeader(len(jobs))
+		for _, job := range jobs {
+			next := ""
+			if !job.Paused {
+				next = job.Next.Format(time.RFC3339)
+			}
+			ctx.Out.WriteArrayHeader(12)
+			ctx.Out.WriteBulkString("name")
+			ctx.Out.WriteBulkString(job.Name)
+			ctx.Out.WriteBulkString("schedule")
+			ctx.Out.WriteBulkString(job.Schedule)
+			ctx.Out.WriteBulkString("task")
+			ctx.Out.WriteBulkString(job.Task)
+			ctx.Out.WriteBulkString("paused")
+			ctx.Out.WriteInteger(BoolInt(job.Paused))
+			ctx.Out.WriteBulkString("running")
+			ctx.Out.WriteInteger(BoolInt(job.Running))
+			ctx.Out.WriteBulkString("next")
+			ctx.Out.WriteBulkString(next)
+		}
+		return nil
+	}
+	if sub == "HISTORY" {
+		if len(ctx.Args) != 3 && len(ctx.Args) != 4 {
+			return ErrSyntax
+		}
+		return scheduleHistory(ctx, s, string(ctx.Args[2]))
+	}
+	if len(ctx.Args) != 3 {
+		return ErrSyntax
+	}
+	name := string(ctx.Args[2])
+
+	var err error
+	switch sub {
+	case "RUN":
+		err = s.Run(name)
+	case "PAUSE":
+		err = s.SetPaused(name, true)
+	case "RESUME":
+		err = s.SetPaused(name, false)
+	default:
+		return fmt.Errorf("ERR unknown SCHEDULE subcommand '%s'", sub)
+	}
+	if err != nil {
+		return fmt.Errorf("ERR %v", err)
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// scheduleHistory writes a job's recent runs, newest first
+func scheduleHistory(ctx *CommandContext, s *Scheduler, name string) error {
+	job, err := s.Job(name)
+	if err != nil {
+		return fmt.Errorf("ERR %v", err)
+	}
+	runs := job.History
+	if len(ctx.Args) == 4 {
+		count, err := strconv.Atoi(string(ctx.Args[3]))
+		if err != nil || count < 0 {
+			return ErrNotInteger
+		}
+		if count < len(runs) {
+			runs = runs[:count]
+		}
+	}
+
+	ctx.Out.WriteArrayHeader(len(runs))
+	for _, run := range runs {
+		ctx.Out.WriteArrayHeader(10)
+		ctx.Out.WriteBulkString("started")
+		ctx.Out.WriteBulkString(run.Started.Format(time.RFC3339))
+		ctx.Out.WriteBulkString("duration_ms")
+		ctx.Out.WriteInteger(run.Duration.Milliseconds())
+		ctx.Out.WriteBulkString("manual")
+		ctx.Out.WriteInteger(BoolInt(run.Manual))
+		ctx.Out.WriteBulkString("result")
+		ctx.Out.WriteBulkString(run.Result)
+		ctx.Out.WriteBulkString("error")
+		ctx.Out.WriteBulkString(run.Error)
+	}
+	return nil
+}
+
+// BoolInt is 1 for true and 0 for false, for integer replies
+func BoolInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,344 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// A semaphore is a counting semaphore whose permits are held under leases.
+// Each acquire takes some permits for a TTL and returns a lease ID, which
+// renews or releases them. A lease not renewed in time lapses and its
+// permits return to the pool, so a crashed holder cannot leak them. The
+// limit is given with each acquire, letting a fleet change it without
+// coordination.
+
+// SemaphoreInfo describes a semaphore
+type SemaphoreInfo struct {
+	Limit  int
+	InUse  int
+	Leases int
+}
+
+// semaphore holds the live leases of a semaphore by ID
+type semaphore struct {
+	limit  int
+	leases map[string]*semaphoreLease
+}
+
+type semaphoreLease struct {
+	permits int
+	expires time.Time
+}
+
+func newSemaphore() *semaphore {
+	return &semaphore{leases: make(map[string]*semaphoreLease)}
+}
+
+// TypeName implements cacheObject
+func (s *semaphore) TypeName() string {
+	return "semaphore"
+}
+
+// memoryUsage implements memorySizer
+func (s *semaphore) memoryUsage() int64 {
+	n := int64(unsafe.Sizeof(*s))
+	for id, lease := range s.leases {
+		n += mapSlotSize + allocSize(int64(len(id))) + allocSize(int64(unsafe.Sizeof(*lease)))
+	}
+	return n
+}
+
+// rewriteCommands implements aofRewriter, acquiring each lease under its
+// ID. Leases granted before the limit was lowered may hold more than it,
+// in which case the limit is raised to what they hold.
+func (s *semaphore) rewriteCommands(key string) [][][]byte {
+	ids := make([]string, 0, len(s.leases))
+	held := 0
+	for id, lease := range s.leases {
+		ids = append(ids, id)
+		held += lease.permits
+	}
+	sort.Strings(ids)
+
+	limit := []byte(strconv.Itoa(max(s.limit, held)))
+	commands := make([][][]byte, len(ids))
+	for i, id := range ids {
+		lease := s.leases[id]
+		commands[i] = [][]byte{
+			[]byte("SEM.ACQUIREAT"), []byte(key), limit,
+			[]byte(strconv.Itoa(lease.permits)),
+			[]byte(strconv.FormatInt(lease.expires.UnixMilli(), 10)),
+			[]byte("ID"), []byte(id),
+		}
+	}
+	return commands
+}
+
+// prune drops the leases lapsed at now
+func (s *semaphore) prune(now time.Time) {
+	for id, lease := range s.leases {
+		if !now.Before(lease.expires) {
+			delete(s.leases, id)
+		}
+	}
+}
+
+// inUse returns the permits held by leases other than skip
+func (s *semaphore) inUse(skip string) int {
+	n := 0
+	for id, lease := range s.leases {
+		if id != skip {
+			n += lease.permits
+		}
+	}
+	return n
+}
+
+// acquire takes permits under the lease id until expires, reporting false
+// if not enough are free. Acquiring under a live lease renews it with the
+// new count.
+func (s *semaphore) acquire(id string, limit, permits int, expires time.Time) bool {
+	s.limit = limit
+	if s.inUse(id)+permits > limit {
+		return false
+	}
+	s.leases[id] = &semaphoreLease{permits: permits, expires: expires}
+	return true
+}
+
+// newLeaseID returns a random lease ID of 256 bits, base64url encoded
+func newLeaseID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AcquireSemaphore takes permits of the semaphore at key under a lease
+// lasting until expires, creating the semaphore if needed. It returns the
+// lease ID, generated if id is empty, and false if fewer than permits of
+// limit are free.
+func (c *Cache) AcquireSemaphore(key string, limit, permits int, expires time.Time, id string) (string, bool, error) {
+	if limit < 1 || permits < 1 || permits > limit {
+		return "", false, errors.New("permits must be between 1 and the limit")
+	}
+	if id == "" {
+		var err error
+		if id, err = newLeaseID(); err != nil {
+			return "", false, err
+		}
+	}
+	acquired := false
+	err := updateObject(c, key, func() (*semaphore, error) { return newSemaphore(), nil }, func(s *semaphore) error {
+		s.prune(c.now())
+		acquired = s.acquire(id, limit, permits, expires)
+		return nil
+	})
+	if err != nil || !acquired {
+		return "", false, err
+	}
+	return id, true, nil
+}
+
+// RenewSemaphore extends a live lease of the semaphore at key until
+// expires, reporting false if it has lapsed or was released
+func (c *Cache) RenewSemaphore(key, id string, expires time.Time) (bool, error) {
+	renewed := false
+	err := updateObject(c, key, nil, func(s *semaphore) error {
+		s.prune(c.now())
+		if lease, ok := s.leases[id]; ok {
+			lease.expires = expires
+			renewed = true
+		}
+		return nil
+	})
+	if err == ErrNoSuchKey {
+		return false, nil
+	}
+	return renewed, err
+}
+
+// ReleaseSemaphore ends a lease of the semaphore at key, returning its
+// permits, and reports whether it was live
+func (c *Cache) ReleaseSemaphore(key, id string) (bool, error) {
+	released := false
+	err := updateObject(c, key, nil, func(s *semaphore) error {
+		s.prune(c.now())
+		if _, ok := s.leases[id]; ok {
+			delete(s.leases, id)
+			released = true
+		}
+		return nil
+	})
+	if err == ErrNoSuchKey {
+		return false, nil
+	}
+	return released, err
+}
+
+// SemaphoreInfo returns the limit of the semaphore at key and its live
+// leases
+func (c *Cache) SemaphoreInfo(key string) (SemaphoreInfo, error) {
+	var info SemaphoreInfo
+	_, err := viewObject(c, key, func(s *semaphore) error {
+		now := c.now()
+		info.Limit = s.limit
+		for _, lease := range s.leases {
+			if now.Before(lease.expires) {
+				info.InUse += lease.permits
+				info.Leases++
+			}
+		}
+		return nil
+	})
+	return info, err
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "SEM.ACQUIRE", Arity: -5, Flags: FlagWrite | FlagSelfLogged, Handler: semAcquireCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "SEM.ACQUIREAT", Arity: -5, Flags: FlagWrite | FlagSelfLogged, Handler: semAcquireCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "SEM.RENEW", Arity: 4, Flags: FlagWrite | FlagSelfLogged, Handler: semRenewCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "SEM.RENEWAT", Arity: 4, Flags: FlagWrite | FlagSelfLogged, Handler: semRenewCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "SEM.RELEASE", Arity: 3, Flags: FlagWrite, Handler: semReleaseCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "SEM.INFO", Arity: 2, Flags: FlagReadOnly, Handler: semInfoCommand, KeyArgs: firstKeyArg},
+	)
+}
+
+// semaphoreCommandError maps cache errors to replies, keeping those that
+// already carry a reply code
+func semaphoreCommandError(err error) error {
+	if err == ErrWrongType || err == ErrOOM {
+		return err
+	}
+	return fmt.Errorf("ERR %v", err)
+}
+
+// leaseExpiry parses the TTL in milliseconds of SEM.ACQUIRE and SEM.RENEW,
+// from now, or the unix time in milliseconds of SEM.ACQUIREAT and
+// SEM.RENEWAT
+func leaseExpiry(name, arg []byte, now time.Time) (time.Time, error) {
+	n, err := ParseInt(arg)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if strings.HasSuffix(strings.ToUpper(string(name)), "AT") {
+		return time.UnixMilli(n), nil
+	}
+	if n <= 0 {
+		return time.Time{}, errors.New("ERR invalid lease TTL")
+	}
+	return now.Add(time.Duration(n) * time.Millisecond), nil
+}
+
+// semAcquireCommand implements SEM.ACQUIRE key limit permits ttl-ms
+// [ID lease] and SEM.ACQUIREAT key limit permits unix-ms [ID lease],
+// replying with the lease ID, or null if too few permits are free. Giving
+// the ID of a live lease renews it. Acquires are logged as SEM.ACQUIREAT
+// with the lease ID, so replicas and replays grant the same lease.
+func semAcquireCommand(ctx *CommandContext) error {
+	key := string(ctx.Args[1])
+	limit, err := ParseInt(ctx.Args[2])
+	if err != nil {
+		return err
+	}
+	permits, err := ParseInt(ctx.Args[3])
+	if err != nil {
+		return err
+	}
+	expires, err := leaseExpiry(ctx.Args[0], ctx.Args[4], ctx.Cache.now())
+	if err != nil {
+		return err
+	}
+	var id string
+	switch {
+	case len(ctx.Args) == 7 && strings.EqualFold(string(ctx.Args[5]), "ID"):
+		id = string(ctx.Args[6])
+	case len(ctx.Args) != 5:
+		return ErrSyntax
+	}
+
+	acquired := false
+	err = ctx.Cache.logWrite(ctx.Context, []string{key}, func() ([][]byte, error) {
+		var err error
+		id, acquired, err = ctx.Cache.AcquireSemaphore(key, int(limit), int(permits), expires, id)
+		if err != nil || !acquired {
+			return nil, err
+		}
+		return [][]byte{
+			[]byte("SEM.ACQUIREAT"), []byte(key), ctx.Args[2], ctx.Args[3],
+			[]byte(strconv.FormatInt(expires.UnixMilli(), 10)), []byte("ID"), []byte(id),
+		}, nil
+	})
+	if err != nil {
+		return semaphoreCommandError(err)
+	}
+	if !acquired {
+		ctx.Out.WriteNull()
+		return nil
+	}
+	ctx.Out.WriteBulkString(id)
+	return nil
+}
+
+// semRenewCommand implements SEM.RENEW key lease ttl-ms and SEM.RENEWAT
+// key lease unix-ms, replying 1 if the lease was live. Renewals are logged
+// as SEM.RENEWAT.
+func semRenewCommand(ctx *CommandContext) error {
+	key, id := string(ctx.Args[1]), string(ctx.Args[2])
+	expires, err := leaseExpiry(ctx.Args[0], ctx.Args[3], ctx.Cache.now())
+	if err != nil {
+		return err
+	}
+
+	renewed := false
+	err = ctx.Cache.logWrite(ctx.Context, []string{key}, func() ([][]byte, error) {
+		var err error
+		renewed, err = ctx.Cache.RenewSemaphore(key, id, expires)
+		if err != nil || !renewed {
+			return nil, err
+		}
+		return [][]byte{
+			[]byte("SEM.RENEWAT"), []byte(key), []byte(id),
+			[]byte(strconv.FormatInt(expires.UnixMilli(), 10)),
+		}, nil
+	})
+	if err != nil {
+		return semaphoreCommandError(err)
+	}
+	ctx.Out.WriteInteger(BoolInt(renewed))
+	return nil
+}
+
+// semReleaseCommand implements SEM.RELEASE key lease, replying 1 if the
+// lease was live
+func semReleaseCommand(ctx *CommandContext) error {
+	released, err := ctx.Cache.ReleaseSemaphore(string(ctx.Args[1]), string(ctx.Args[2]))
+	if err != nil {
+		return semaphoreCommandError(err)
+	}
+	ctx.Out.WriteInteger(BoolInt(released))
+	return nil
+}
+
+// semInfoCommand implements SEM.INFO key, replying with the semaphore's
+// limit, the permits in use and the live leases
+func semInfoCommand(ctx *CommandContext) error {
+	info, err := ctx.Cache.SemaphoreInfo(string(ctx.Args[1]))
+	if err != nil {
+		return semaphoreCommandError(err)
+	}
+	ctx.Out.WriteArrayHeader(3)
+	ctx.Out.WriteInteger(int64(info.Limit))
+	ctx.Out.WriteInteger(int64(info.InUse))
+	ctx.Out.WriteInteger(int64(info.Leases))
+	return nil
+}
@@ -0,0 +1,403 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// Set algebra locks the shards of every key at once, so SINTERSTORE and
+// the other STORE variants see a consistent view and replace their
+// destination atomically. Cluster nodes hold the whole keyspace, so the
+// keys of one command are always local to the node running it.
+
+// Set operations
+const (
+	setInter = "inter"
+	setUnion = "union"
+	setDiff  = "diff"
+)
+
+// set is an unordered collection of distinct members
+type set struct {
+	members map[string]struct{}
+}
+
+func newSet() *set {
+	return &set{members: make(map[string]struct{})}
+}
+
+// TypeName implements cacheObject
+func (s *set) TypeName() string {
+	return "set"
+}
+
+// memoryUsage implements memorySizer
+func (s *set) memoryUsage() int64 {
+	n := int64(unsafe.Sizeof(*s))
+	for member := range s.members {
+		n += mapSlotSize + allocSize(int64(len(member)))
+	}
+	return n
+}
+
+// rewriteCommands implements aofRewriter
+func (s *set) rewriteCommands(key string) [][][]byte {
+	var commands [][][]byte
+	args := [][]byte{[]byte("SADD"), []byte(key)}
+	for member := range s.members {
+		args = append(args, []byte(member))
+		if len(args)-2 == aofRewriteBatch {
+			commands = append(commands, args)
+			args = [][]byte{[]byte("SADD"), []byte(key)}
+		}
+	}
+	if len(args) > 2 {
+		commands = append(commands, args)
+	}
+	return commands
+}
+
+// SetAdd adds members to the set at key, creating it if needed, and
+// returns how many were not already members
+func (c *Cache) SetAdd(key string, members ...string) (int, error) {
+	added := 0
+	err := updateObject(c, key, func() (*set, error) { return newSet(), nil }, func(s *set) error {
+		for _, member := range members {
+			if _, ok := s.members[member]; !ok {
+				s.members[member] = struct{}{}
+				added++
+			}
+		}
+		return nil
+	})
+	return added, err
+}
+
+// SetRemove removes members from the set at key, deleting it once empty,
+// and returns how many were members
+func (c *Cache) SetRemove(key string, members ...string) (int, error) {
+	shard := c.shardFor(key)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	s, entry, err := liveObject[*set](shard, key)
+	if err != nil || entry == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if _, ok := s.members[member]; ok {
+			delete(s.members, member)
+			removed++
+		}
+	}
+	if len(s.members) == 0 {
+		shard.dropEntry(entry, RemovalDeleted)
+	} else if removed > 0 {
+		shard.touchEntry(entry)
+		shard.recharge(entry)
+		c.notifier.publish(KeyEventSet, key)
+	}
+	return removed, nil
+}
+
+// SetIsMember reports whether member is in the set at key
+func (c *Cache) SetIsMember(key, member string) (bool, error) {
+	found := false
+	_, err := viewObject(c, key, func(s *set) error {
+		_, found = s.members[member]
+		return nil
+	})
+	return found, err
+}
+
+// SetMembers returns the members of the set at key
+func (c *Cache) SetMembers(key string) ([]string, error) {
+	return c.SetOp(setUnion, []string{key}, 0)
+}
+
+// SetCard returns the number of members of the set at key
+func (c *Cache) SetCard(key string) (int, error) {
+	n := 0
+	_, err := viewObject(c, key, func(s *set) error {
+		n = len(s.members)
+		return nil
+	})
+	return n, err
+}
+
+// SetOp returns the intersection, union or difference of the sets at
+// keys, in which missing keys are empty sets. A positive limit stops
+// once that many members are found.
+func (c *Cache) SetOp(op string, keys []string, limit int) ([]string, error) {
+	defer runlockShards(c.rlockKeys(keys...))
+
+	sets, err := c.sourceSets(keys)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0)
+	combineSets(op, sets, func(member string) bool {
+		result = append(result, member)
+		return limit <= 0 || len(result) < limit
+	})
+	return result, nil
+}
+
+// StoreSetOp stores the intersection, union or difference of the sets at
+// keys as the set at dest, replacing any value there, and returns its
+// size. An empty result deletes dest.
+func (c *Cache) StoreSetOp(op, dest string, keys []string) (int, error) {
+	defer unlockShards(c.lockKeys(append([]string{dest}, keys...)...))
+
+	sets, err := c.sourceSets(keys)
+	if err != nil {
+		return 0, err
+	}
+	result := newSet()
+	combineSets(op, sets, func(member string) bool {
+		result.members[member] = struct{}{}
+		return true
+	})
+
+	shard := c.shardFor(dest)
+	old := shard.lookupLive(dest)
+	if len(result.members) == 0 {
+		if old != nil {
+			shard.dropEntry(old, RemovalDeleted)
+		}
+		return 0, nil
+	}
+	if err := c.admitWrite(0); err != nil {
+		return 0, err
+	}
+	if old != nil {
+		shard.removeEntry(old)
+	}
+	shard.storeObject(dest, result)
+	return len(result.members), nil
+}
+
+// sourceSets returns the sets at keys, with nil for missing keys. Callers
+// hold the locks of the keys' shards.
+func (c *Cache) sourceSets(keys []string) ([]*set, error) {
+	now := c.now()
+	sets := make([]*set, len(keys))
+	for i, key := range keys {
+		entry := c.storedEntry(key)
+		if entry == nil || entry.isExpired(now) {
+			continue
+		}
+		s, ok := entry.Object.(*set)
+		if !ok {
+			return nil, ErrWrongType
+		}
+		sets[i] = s
+	}
+	return sets, nil
+}
+
+// combineSets calls emit with each member of the intersection, union or
+// difference of sets until it returns false. Nil sets are empty.
+func combineSets(op string, sets []*set, emit func(member string) bool) {
+	size := func(s *set) int {
+		if s == nil {
+			return 0
+		}
+		return len(s.members)
+	}
+
+	switch op {
+	case setInter:
+		// Walk the smallest set, probing the others
+		smallest := 0
+		for i, s := range sets {
+			if size(s) < size(sets[smallest]) {
+				smallest = i
+			}
+		}
+		if size(sets[smallest]) == 0 {
+			return
+		}
+	members:
+		for member := range sets[smallest].members {
+			for i, s := range sets {
+				if _, ok := s.members[member]; i != smallest && !ok {
+					continue members
+				}
+			}
+			if !emit(member) {
+				return
+			}
+		}
+	case setUnion:
+		seen := make(map[string]struct{})
+		for _, s := range sets {
+			if s == nil {
+				continue
+			}
+			for member := range s.members {
+				if _, ok := seen[member]; ok {
+					continue
+				}
+				seen[member] = struct{}{}
+				if !emit(member) {
+					return
+				}
+			}
+		}
+	case setDiff:
+		if size(sets[0]) == 0 {
+			return
+		}
+	diff:
+		for member := range sets[0].members {
+			for _, s := range sets[1:] {
+				if s == nil {
+					continue
+				}
+				if _, ok := s.members[member]; ok {
+					continue diff
+				}
+			}
+			if !emit(member) {
+				return
+			}
+		}
+	}
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "SADD", Arity: -3, Flags: FlagWrite, Handler: saddCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "SREM", Arity: -3, Flags: FlagWrite, Handler: sremCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "SISMEMBER", Arity: 3, Flags: FlagReadOnly, Handler: sismemberCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "SMEMBERS", Arity: 2, Flags: FlagReadOnly, Handler: smembersCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "SCARD", Arity: 2, Flags: FlagReadOnly, Handler: scardCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "SINTER", Arity: -2, Flags: FlagReadOnly, Handler: setOpCommand, KeyArgs: allKeyArgs},
+		&Command{Name: "SUNION", Arity: -2, Flags: FlagReadOnly, Handler: setOpCommand, KeyArgs: allKeyArgs},
+		&Command{Name: "SDIFF", Arity: -2, Flags: FlagReadOnly, Handler: setOpCommand, KeyArgs: allKeyArgs},
+		&Command{Name: "SINTERSTORE", Arity: -3, Flags: FlagWrite, Handler: setOpStoreCommand, KeyArgs: allKeyArgs},
+		&Command{Name: "SUNIONSTORE", Arity: -3, Flags: FlagWrite, Handler: setOpStoreCommand, KeyArgs: allKeyArgs},
+		&Command{Name: "SDIFFSTORE", Arity: -3, Flags: FlagWrite, Handler: setOpStoreCommand, KeyArgs: allKeyArgs},
+		&Command{Name: "SINTERCARD", Arity: -3, Flags: FlagReadOnly, Handler: sintercardCommand, KeyArgs: numKeysArgs},
+	)
+}
+
+// setCommandError maps cache errors to replies, keeping those that already
+// carry a reply code
+func setCommandError(err error) error {
+	if err == ErrWrongType || err == ErrOOM {
+		return err
+	}
+	return fmt.Errorf("ERR %v", err)
+}
+
+// setOpOf returns the operation of a set algebra command such as SINTER or
+// SDIFFSTORE
+func setOpOf(name []byte) string {
+	return strings.TrimSuffix(strings.ToLower(string(name)[1:]), "store")
+}
+
+// saddCommand implements SADD key member [member ...]
+func saddCommand(ctx *CommandContext) error {
+	added, err := ctx.Cache.SetAdd(string(ctx.Args[1]), keysFromArgs(ctx.Args[2:])...)
+	if err != nil {
+		return setCommandError(err)
+	}
+	ctx.Out.WriteInteger(int64(added))
+	return nil
+}
+
+// sremCommand implements SREM key member [member ...]
+func sremCommand(ctx *CommandContext) error {
+	removed, err := ctx.Cache.SetRemove(string(ctx.Args[1]), keysFromArgs(ctx.Args[2:])...)
+	if err != nil {
+		return setCommandError(err)
+	}
+	ctx.Out.WriteInteger(int64(removed))
+	return nil
+}
+
+// sismemberCommand implements SISMEMBER key member
+func sismemberCommand(ctx *CommandContext) error {
+	found, err := ctx.Cache.SetIsMember(string(ctx.Args[1]), string(ctx.Args[2]))
+	if err != nil {
+		return setCommandError(err)
+	}
+	ctx.Out.WriteInteger(BoolInt(found))
+	return nil
+}
+
+// smembersCommand implements SMEMBERS key
+func smembersCommand(ctx *CommandContext) error {
+	members, err := ctx.Cache.SetMembers(string(ctx.Args[1]))
+	if err != nil {
+		return setCommandError(err)
+	}
+	ctx.Out.WriteStringArray(members)
+	return nil
+}
+
+// scardCommand implements SCARD key
+func scardCommand(ctx *CommandContext) error {
+	n, err := ctx.Cache.SetCard(string(ctx.Args[1]))
+	if err != nil {
+		return setCommandError(err)
+	}
+	ctx.Out.WriteInteger(int64(n))
+	return nil
+}
+
+// setOpCommand implements SINTER, SUNION and SDIFF key [key ...]
+func setOpCommand(ctx *CommandContext) error {
+	members, err := ctx.Cache.SetOp(setOpOf(ctx.Args[0]), keysFromArgs(ctx.Args[1:]), 0)
+	if err != nil {
+		return setCommandError(err)
+	}
+	ctx.Out.WriteStringArray(members)
+	return nil
+}
+
+// setOpStoreCommand implements SINTERSTORE, SUNIONSTORE and SDIFFSTORE
+// destination key [key ...], replying with the size of the stored set
+func setOpStoreCommand(ctx *CommandContext) error {
+	n, err := ctx.Cache.StoreSetOp(setOpOf(ctx.Args[0]), string(ctx.Args[1]), keysFromArgs(ctx.Args[2:]))
+	if err != nil {
+		return setCommandError(err)
+	}
+	ctx.Out.WriteInteger(int64(n))
+	return nil
+}
+
+// sintercardCommand implements SINTERCARD numkeys key [key ...]
+// [LIMIT limit], replying with the size of the intersection, counting up
+// to limit if it is not zero
+func sintercardCommand(ctx *CommandContext) error {
+	numKeys, err := ParseInt(ctx.Args[1])
+	if err != nil || numKeys < 1 {
+		return errors.New("ERR numkeys should be greater than 0")
+	}
+	if numKeys > int64(len(ctx.Args)-2) {
+		return errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	keys := keysFromArgs(ctx.Args[2 : 2+numKeys])
+	limit := int64(0)
+	switch rest := ctx.Args[2+numKeys:]; {
+	case len(rest) == 2 && strings.EqualFold(string(rest[0]), "LIMIT"):
+		if limit, err = ParseInt(rest[1]); err != nil || limit < 0 {
+			return errors.New("ERR LIMIT can't be negative")
+		}
+	case len(rest) != 0:
+		return ErrSyntax
+	}
+
+	members, err := ctx.Cache.SetOp(setInter, keys, int(limit))
+	if err != nil {
+		return setCommandError(err)
+	}
+	ctx.Out.WriteInteger(int64(len(members)))
+	return nil
+}
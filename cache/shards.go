@@ -0,0 +1,194 @@
+package cache
+
+import (
+	"math"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+const (
+	// shardsPerProc spreads lock contention so that each P rarely waits on
+	// another for the same shard
+	shardsPerProc = 4
+	// maxAutoShards caps the automatic shard count on very large machines
+	maxAutoShards = 1024
+)
+
+// AlignGOMAXPROCS lowers GOMAXPROCS to the cgroup CPU quota, rounded up, so
+// the scheduler does not run more threads than the container may use. It
+// leaves an explicit GOMAXPROCS environment variable alone and returns the
+// resulting value.
+func AlignGOMAXPROCS() int {
+	procs := runtime.GOMAXPROCS(0)
+	if os.Getenv("GOMAXPROCS") != "" {
+		return procs
+	}
+	quota, ok := cgroupCPUQuota()
+	if !ok {
+		return procs
+	}
+	limit := int(math.Ceil(quota))
+	if limit < 1 {
+		limit = 1
+	}
+	if limit < procs {
+		runtime.GOMAXPROCS(limit)
+		return limit
+	}
+	return procs
+}
+
+// autoShardCount returns the power of two shard count for procs processors
+func autoShardCount(procs int) int {
+	shards := 1
+	for shards < procs*shardsPerProc && shards < maxAutoShards {
+		shards <<= 1
+	}
+	return shards
+}
+
+// ResolveShardCount replaces a zero ShardCount with one derived from
+// GOMAXPROCS
+func (c *Config) ResolveShardCount() {
+	if c.ShardCount == 0 {
+		c.ShardCount = autoShardCount(runtime.GOMAXPROCS(0))
+	}
+}
+
+// cacheShard is one part of the keyspace: the keys hashing to it and the
+// indexes over them, under their own lock. An operation on one key locks
+// only its shard, one on several keys locks theirs with lockKeys, and one
+// over the whole keyspace locks every shard. The shard's methods are
+// called with its write lock held.
+type cacheShard struct {
+	cache *Cache
+	// index is the shard's position in Cache.shards, the order shards are
+	// locked in
+	index  int
+	mutex  sync.RWMutex
+	data   map[string]*CacheEntry
+	policy evictionPolicy
+	// indexes, prefixIndex and tags hold the shard's part of each
+	// secondary index and of the prefix and tag indexes
+	indexes     map[string]*secondaryIndex
+	prefixIndex map[string]*radixTree
+	tags        map[string]map[string]struct{}
+	expirations expirationIndex
+	// rebuilding is the replacement key map while Defrag runs
+	rebuilding map[string]*CacheEntry
+	// evictions counts the entries evicted by reason
+	evictions map[RemovalReason]int64
+	// reads buffers the hits served under the read lock, when sharded
+	reads readStripe
+	// dirtyKeys holds keys changed since the last full snapshot, once one
+	// has been taken. dirtyAll means the changes are unknown.
+	dirtyKeys map[string]struct{}
+	dirtyAll  bool
+}
+
+func newCacheShard(c *Cache, index int, policy evictionPolicy) *cacheShard {
+	return &cacheShard{
+		cache:     c,
+		index:     index,
+		data:      make(map[string]*CacheEntry),
+		policy:    policy,
+		indexes:   make(map[string]*secondaryIndex),
+		evictions: make(map[RemovalReason]int64),
+	}
+}
+
+// shardFor returns the shard holding key
+func (c *Cache) shardFor(key string) *cacheShard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	return c.shards[c.shardHash(key)%uint64(len(c.shards))]
+}
+
+// storedEntry returns the entry at key, expired or not. Callers hold the
+// lock of key's shard.
+func (c *Cache) storedEntry(key string) *CacheEntry {
+	return c.shardFor(key).data[key]
+}
+
+// keyShards returns the shards holding keys once each, in shard order, so
+// callers locking overlapping keys cannot deadlock
+func (c *Cache) keyShards(keys []string) []*cacheShard {
+	shards := make([]*cacheShard, 0, len(keys))
+	for _, key := range keys {
+		shards = append(shards, c.shardFor(key))
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i].index < shards[j].index })
+
+	unique := shards[:0]
+	for i, s := range shards {
+		if i == 0 || s != shards[i-1] {
+			unique = append(unique, s)
+		}
+	}
+	return unique
+}
+
+// lockKeys write-locks the shards holding keys and returns them for
+// unlockShards
+func (c *Cache) lockKeys(keys ...string) []*cacheShard {
+	shards := c.keyShards(keys)
+	for _, s := range shards {
+		s.mutex.Lock()
+	}
+	return shards
+}
+
+// unlockShards releases the shards locked by lockKeys
+func unlockShards(shards []*cacheShard) {
+	for _, s := range shards {
+		s.mutex.Unlock()
+	}
+}
+
+// rlockKeys read-locks the shards holding keys and returns them for
+// runlockShards
+func (c *Cache) rlockKeys(keys ...string) []*cacheShard {
+	shards := c.keyShards(keys)
+	for _, s := range shards {
+		s.mutex.RLock()
+	}
+	return shards
+}
+
+// runlockShards releases the shards locked by rlockKeys
+func runlockShards(shards []*cacheShard) {
+	for _, s := range shards {
+		s.mutex.RUnlock()
+	}
+}
+
+// lockAll write-locks every shard, in order
+func (c *Cache) lockAll() {
+	for _, s := range c.shards {
+		s.mutex.Lock()
+	}
+}
+
+// unlockAll releases the shards locked by lockAll
+func (c *Cache) unlockAll() {
+	for _, s := range c.shards {
+		s.mutex.Unlock()
+	}
+}
+
+// rlockAll read-locks every shard, in order
+func (c *Cache) rlockAll() {
+	for _, s := range c.shards {
+		s.mutex.RLock()
+	}
+}
+
+// runlockAll releases the shards locked by rlockAll
+func (c *Cache) runlockAll() {
+	for _, s := range c.shards {
+		s.mutex.RUnlock()
+	}
+}
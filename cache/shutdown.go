@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// SetSnapshotStorage sets where SHUTDOWN writes its final snapshot. Without
+// it SHUTDOWN saves nothing unless told to SAVE, which then fails.
+func (c *Cache) SetSnapshotStorage(cfg StorageConfig) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.snapshotStorage = &cfg
+}
+
+// SnapshotsEnabled reports whether SHUTDOWN saves a snapshot by default
+func (c *Cache) SnapshotsEnabled() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.snapshotStorage != nil && c.snapshotStorage.Enabled
+}
+
+// PersistForShutdown syncs the AOF and, if save is set, writes a snapshot
+// where SetSnapshotStorage said. Nothing is written when save is set but
+// no storage is configured; that is an error instead.
+func (c *Cache) PersistForShutdown(ctx context.Context, save bool) error {
+	c.mutex.RLock()
+	storage := c.snapshotStorage
+	c.mutex.RUnlock()
+
+	if aof := c.AOF(); aof != nil {
+		if err := aof.Sync(); err != nil {
+			return fmt.Errorf("syncing the AOF: %w", err)
+		}
+	}
+	if !save {
+		return nil
+	}
+	if storage == nil {
+		return errors.New("no snapshot storage is configured")
+	}
+	// Wait for a BGSAVE rather than write the same files at once
+	c.saves.mu.Lock()
+	defer c.saves.mu.Unlock()
+	if _, err := c.saveLocked(ctx); err != nil {
+		return fmt.Errorf("saving a snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,794 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// A snapshot file is a header, a sequence of opcode-tagged records and a
+// trailer:
+//
+//	"DCSNAP" | version uint16 | compression byte | created unix nanos int64
+//	[opBase base created unix nanos]
+//	opEntry or opDelete record...
+//	opEOF | crc64 (ECMA) of every preceding uncompressed byte, uint64
+//
+// Everything after the header is compressed as one stream when compression
+// is set. Version 1 files have no compression byte and are never
+// compressed. Version 2 entries carry no access count. Version 3 files hold
+// no typed objects. Integers in records are varints; all fixed-width fields are
+// big-endian.
+const (
+	snapshotMagic   = "DCSNAP"
+	snapshotVersion = 4
+)
+
+// Snapshot body compression
+const (
+	snapshotCompressionNone byte = 0
+	snapshotCompressionZstd byte = 1
+)
+
+// Snapshot record opcodes
+const (
+	snapshotOpEntry byte = 0x01
+	// snapshotOpBase marks an incremental snapshot and names the full
+	// snapshot it applies to
+	snapshotOpBase byte = 0x02
+	// snapshotOpDelete records a key removed since the base snapshot
+	snapshotOpDelete byte = 0x03
+	snapshotOpEOF    byte = 0xFF
+)
+
+// Snapshot entry flags
+const (
+	snapshotFlagPinned = 1 << iota
+	snapshotFlagSliding
+	// snapshotFlagHits is followed by the entry's access count, which
+	// orders the warm-up on load
+	snapshotFlagHits
+	// snapshotFlagObject marks an entry whose value is a typed object, as
+	// encoded by encodeSnapshotObject
+	snapshotFlagObject
+)
+
+var snapshotCRCTable = crc64.MakeTable(crc64.ECMA)
+
+// Snapshot load errors
+var (
+	ErrSnapshotMagic    = errors.New("not a snapshot file")
+	ErrSnapshotVersion  = errors.New("unsupported snapshot version")
+	ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+)
+
+// SnapshotLoadOptions controls how a snapshot is read
+type SnapshotLoadOptions struct {
+	// SkipChecksum loads a file whose checksum does not match, for disaster
+	// recovery when a damaged snapshot is all that is left
+	SkipChecksum bool
+}
+
+// SnapshotOptions controls how a snapshot is written
+type SnapshotOptions struct {
+	// Compress compresses the snapshot with zstd
+	Compress bool
+	// Concurrency is the number of zstd encoder goroutines, GOMAXPROCS
+	// when zero
+	Concurrency int
+}
+
+// SnapshotInfo describes a written or loaded snapshot
+type SnapshotInfo struct {
+	Version     int       `json:"version"`
+	Created     time.Time `json:"created"`
+	Compression string    `json:"compression"`
+	// Base is the creation time of the full snapshot an incremental
+	// snapshot applies to, and zero for full snapshots
+	Base    time.Time `json:"base,omitempty"`
+	Entries int       `json:"entries"`
+	Deleted int       `json:"deleted,omitempty"`
+	// Bytes is the file size and RawBytes the size before compression
+	Bytes    int64         `json:"bytes"`
+	RawBytes int64         `json:"raw_bytes"`
+	Duration time.Duration `json:"duration"`
+	// ChecksumMismatch is set when a damaged file was loaded anyway
+	// because of SnapshotLoadOptions.SkipChecksum
+	ChecksumMismatch bool `json:"checksum_mismatch,omitempty"`
+}
+
+// snapshotEntry is the persisted part of a CacheEntry
+type snapshotEntry struct {
+	key       string
+	value     []byte
+	tags      []string
+	expiresAt int64 // unix milliseconds, 0 for none
+	sliding   time.Duration
+	pinned    bool
+	cost      int64
+	hits      int64
+	// object is set when value holds an encoded typed object, and decoded
+	// is that object once read back from a file
+	object  bool
+	decoded cacheObject
+}
+
+// snapshotData is the content of a full or incremental snapshot
+type snapshotData struct {
+	entries []snapshotEntry
+	deletes []string
+	// base is the full snapshot an incremental one applies to
+	base time.Time
+}
+
+// snapshotEntryOf copies the persisted fields of entry, encoding its value
+// if it holds a typed object. Callers hold the lock of its shard.
+func snapshotEntryOf(entry *CacheEntry) (snapshotEntry, error) {
+	se := snapshotEntry{
+		key:     entry.Key,
+		value:   entry.Value,
+		tags:    entry.Tags,
+		sliding: entry.SlidingTTL,
+		pinned:  entry.Pinned,
+		cost:    entry.Cost,
+		hits:    entry.AccessCount,
+	}
+	if entry.ExpiresAt != nil {
+		se.expiresAt = entry.ExpiresAt.UnixNano() / int64(time.Millisecond)
+	}
+	if entry.Object != nil {
+		var err error
+		if se.value, err = encodeSnapshotObject(entry.Key, entry.Object); err != nil {
+			return se, err
+		}
+		se.object = true
+	}
+	return se, nil
+}
+
+// collectSnapshot copies the live entries of c and starts tracking changes
+// against created for incremental snapshots
+func (c *Cache) collectSnapshot(created time.Time) (snapshotData, error) {
+	c.lockAll()
+	defer c.unlockAll()
+
+	var data snapshotData
+	var err error
+	if data.entries, err = c.collectEntries(time.Now()); err != nil {
+		return data, err
+	}
+	c.resetDirtyKeys(created)
+	return data, nil
+}
+
+// collectEntries copies the entries live at now, failing if one holds an
+// object snapshots cannot hold. Callers hold every shard's lock.
+func (c *Cache) collectEntries(now time.Time) ([]snapshotEntry, error) {
+	entries := make([]snapshotEntry, 0, c.currentSize.Load())
+	for _, s := range c.shards {
+		for _, entry := range s.data {
+			if entry.isExpired(now) {
+				continue
+			}
+			se, err := snapshotEntryOf(entry)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, se)
+		}
+	}
+	return entries, nil
+}
+
+// SnapshotOptions returns the snapshot settings of the storage config
+func (cfg StorageConfig) SnapshotOptions() SnapshotOptions {
+	return SnapshotOptions{Compress: cfg.Compression}
+}
+
+// Ratio returns the uncompressed size divided by the file size
+func (info SnapshotInfo) Ratio() float64 {
+	if info.Bytes == 0 {
+		return 0
+	}
+	return float64(info.RawBytes) / float64(info.Bytes)
+}
+
+// compressionName names a snapshot compression byte
+func compressionName(compression byte) string {
+	if compression == snapshotCompressionZstd {
+		return "zstd"
+	}
+	return "none"
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// snapshotEncoder writes varint-framed fields while hashing them. n counts
+// uncompressed bytes and file the bytes reaching the underlying writer.
+type snapshotEncoder struct {
+	w       *bufio.Writer
+	file    *countingWriter
+	zw      *zstd.Encoder
+	crc     hash.Hash64
+	n       int64
+	scratch [binary.MaxVarintLen64]byte
+}
+
+func newSnapshotEncoder(w io.Writer) *snapshotEncoder {
+	file := &countingWriter{w: w}
+	return &snapshotEncoder{w: bufio.NewWriter(file), file: file, crc: crc64.New(snapshotCRCTable)}
+}
+
+func (e *snapshotEncoder) write(b []byte) error {
+	e.crc.Write(b)
+	e.n += int64(len(b))
+	_, err := e.w.Write(b)
+	return err
+}
+
+func (e *snapshotEncoder) byte(b byte) error {
+	e.scratch[0] = b
+	return e.write(e.scratch[:1])
+}
+
+func (e *snapshotEncoder) uvarint(v uint64) error {
+	return e.write(e.scratch[:binary.PutUvarint(e.scratch[:], v)])
+}
+
+func (e *snapshotEncoder) varint(v int64) error {
+	return e.write(e.scratch[:binary.PutVarint(e.scratch[:], v)])
+}
+
+func (e *snapshotEncoder) bytes(b []byte) error {
+	if err := e.uvarint(uint64(len(b))); err != nil {
+		return err
+	}
+	return e.write(b)
+}
+
+// header writes the file header and switches the rest of the stream to
+// compression, using concurrency encoder goroutines
+func (e *snapshotEncoder) header(created time.Time, compression byte, concurrency int) error {
+	var header [len(snapshotMagic) + 11]byte
+	copy(header[:], snapshotMagic)
+	binary.BigEndian.PutUint16(header[len(snapshotMagic):], snapshotVersion)
+	header[len(snapshotMagic)+2] = compression
+	binary.BigEndian.PutUint64(header[len(snapshotMagic)+3:], uint64(created.UnixNano()))
+	if err := e.write(header[:]); err != nil {
+		return err
+	}
+	if compression == snapshotCompressionNone {
+		return nil
+	}
+
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	zw, err := zstd.NewWriter(e.file, zstd.WithEncoderConcurrency(concurrency))
+	if err != nil {
+		return err
+	}
+	e.zw = zw
+	e.w.Reset(zw)
+	return nil
+}
+
+func (e *snapshotEncoder) entry(se snapshotEntry) error {
+	var flags uint64
+	if se.pinned {
+		flags |= snapshotFlagPinned
+	}
+	if se.sliding > 0 {
+		flags |= snapshotFlagSliding
+	}
+	if se.hits > 0 {
+		flags |= snapshotFlagHits
+	}
+	if se.object {
+		flags |= snapshotFlagObject
+	}
+
+	if err := e.byte(snapshotOpEntry); err != nil {
+		return err
+	}
+	if err := e.bytes([]byte(se.key)); err != nil {
+		return err
+	}
+	if err := e.bytes(se.value); err != nil {
+		return err
+	}
+	if err := e.varint(se.expiresAt); err != nil {
+		return err
+	}
+	if err := e.uvarint(flags); err != nil {
+		return err
+	}
+	if se.sliding > 0 {
+		if err := e.varint(int64(se.sliding)); err != nil {
+			return err
+		}
+	}
+	if se.hits > 0 {
+		if err := e.uvarint(uint64(se.hits)); err != nil {
+			return err
+		}
+	}
+	if err := e.varint(se.cost); err != nil {
+		return err
+	}
+	if err := e.uvarint(uint64(len(se.tags))); err != nil {
+		return err
+	}
+	for _, tag := range se.tags {
+		if err := e.bytes([]byte(tag)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trailer writes the EOF opcode and the checksum, then flushes
+func (e *snapshotEncoder) trailer() error {
+	if err := e.byte(snapshotOpEOF); err != nil {
+		return err
+	}
+	var sum [8]byte
+	binary.BigEndian.PutUint64(sum[:], e.crc.Sum64())
+	if _, err := e.w.Write(sum[:]); err != nil {
+		return err
+	}
+	e.n += int64(len(sum))
+	if err := e.w.Flush(); err != nil {
+		return err
+	}
+	if e.zw != nil {
+		return e.zw.Close()
+	}
+	return nil
+}
+
+// WriteSnapshot writes a point-in-time copy of the keys of c to w. Later
+// incremental snapshots record changes relative to it, so it must be kept
+// until the next full snapshot. Writing stops with ctx's error once ctx is
+// done.
+func (c *Cache) WriteSnapshot(ctx context.Context, w io.Writer, opts SnapshotOptions) (SnapshotInfo, error) {
+	created := time.Now()
+	data, err := c.collectSnapshot(created)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	info, err := writeSnapshot(ctx, w, data, created, opts)
+	if err != nil {
+		// Changes since this snapshot are only tracked relative to it, so
+		// the next snapshot must be a full one
+		c.invalidateDirtyKeys()
+	}
+	return info, err
+}
+
+// writeSnapshot encodes data to w
+func writeSnapshot(ctx context.Context, w io.Writer, data snapshotData, created time.Time, opts SnapshotOptions) (SnapshotInfo, error) {
+	compression := snapshotCompressionNone
+	if opts.Compress {
+		compression = snapshotCompressionZstd
+	}
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = runtime.GOMAXPROCS(0)
+	}
+	info := SnapshotInfo{
+		Version:     snapshotVersion,
+		Created:     created,
+		Compression: compressionName(compression),
+		Base:        data.base,
+		Entries:     len(data.entries),
+		Deleted:     len(data.deletes),
+	}
+
+	enc := newSnapshotEncoder(w)
+	if err := enc.header(created, compression, concurrency); err != nil {
+		return info, err
+	}
+	if !data.base.IsZero() {
+		if err := enc.byte(snapshotOpBase); err != nil {
+			return info, err
+		}
+		if err := enc.varint(data.base.UnixNano()); err != nil {
+			return info, err
+		}
+	}
+	for i, se := range data.entries {
+		if i%deadlineCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return info, err
+			}
+		}
+		if err := enc.entry(se); err != nil {
+			return info, err
+		}
+	}
+	for _, key := range data.deletes {
+		if err := enc.byte(snapshotOpDelete); err != nil {
+			return info, err
+		}
+		if err := enc.bytes([]byte(key)); err != nil {
+			return info, err
+		}
+	}
+	if err := enc.trailer(); err != nil {
+		return info, err
+	}
+	info.RawBytes = enc.n
+	info.Bytes = enc.file.n
+	info.Duration = time.Since(created)
+	return info, nil
+}
+
+// SaveSnapshot writes a full snapshot to path, replacing it atomically so
+// a crash never leaves a partial file in its place
+func (c *Cache) SaveSnapshot(ctx context.Context, path string, opts SnapshotOptions) (SnapshotInfo, error) {
+	return saveSnapshotFile(path, func(w io.Writer) (SnapshotInfo, error) {
+		return c.WriteSnapshot(ctx, w, opts)
+	})
+}
+
+// saveSnapshotFile writes a snapshot with write to a temporary file and
+// renames it to path
+func saveSnapshotFile(path string, write func(w io.Writer) (SnapshotInfo, error)) (SnapshotInfo, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return SnapshotInfo{}, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	info, err := write(tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return info, err
+	}
+	return info, os.Rename(tmp.Name(), path)
+}
+
+// snapshotDecoder reads the fields written by snapshotEncoder while
+// hashing them
+type snapshotDecoder struct {
+	r   *bufio.Reader
+	crc hash.Hash64
+	n   int64
+}
+
+func (d *snapshotDecoder) ReadByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	d.crc.Write([]byte{b})
+	d.n++
+	return b, nil
+}
+
+func (d *snapshotDecoder) full(b []byte) error {
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		return unexpectedEOF(err)
+	}
+	d.crc.Write(b)
+	d.n += int64(len(b))
+	return nil
+}
+
+func (d *snapshotDecoder) uvarint() (uint64, error) {
+	return binary.ReadUvarint(d)
+}
+
+func (d *snapshotDecoder) varint() (int64, error) {
+	return binary.ReadVarint(d)
+}
+
+func (d *snapshotDecoder) bytes() ([]byte, error) {
+	n, err := d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	// Grow as data arrives rather than trusting a possibly corrupt length
+	var buf []byte
+	for remaining := n; remaining > 0; {
+		chunk := remaining
+		if chunk > 64*1024 {
+			chunk = 64 * 1024
+		}
+		start := len(buf)
+		buf = append(buf, make([]byte, chunk)...)
+		if err := d.full(buf[start:]); err != nil {
+			return nil, err
+		}
+		remaining -= chunk
+	}
+	return buf, nil
+}
+
+func (d *snapshotDecoder) entry() (snapshotEntry, error) {
+	var se snapshotEntry
+	key, err := d.bytes()
+	if err != nil {
+		return se, err
+	}
+	se.key = string(key)
+	if se.value, err = d.bytes(); err != nil {
+		return se, err
+	}
+	if se.expiresAt, err = d.varint(); err != nil {
+		return se, err
+	}
+	flags, err := d.uvarint()
+	if err != nil {
+		return se, err
+	}
+	se.pinned = flags&snapshotFlagPinned != 0
+	se.object = flags&snapshotFlagObject != 0
+	if flags&snapshotFlagSliding != 0 {
+		sliding, err := d.varint()
+		if err != nil {
+			return se, err
+		}
+		se.sliding = time.Duration(sliding)
+	}
+	if flags&snapshotFlagHits != 0 {
+		hits, err := d.uvarint()
+		if err != nil {
+			return se, err
+		}
+		se.hits = int64(hits)
+	}
+	if se.cost, err = d.varint(); err != nil {
+		return se, err
+	}
+	tags, err := d.uvarint()
+	if err != nil {
+		return se, err
+	}
+	for i := uint64(0); i < tags; i++ {
+		tag, err := d.bytes()
+		if err != nil {
+			return se, err
+		}
+		se.tags = append(se.tags, string(tag))
+	}
+	if se.object {
+		if se.decoded, err = decodeSnapshotObject(se.value); err != nil {
+			return se, fmt.Errorf("key %q: %w", se.key, err)
+		}
+	}
+	return se, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readSnapshot decodes and verifies a snapshot without touching the cache
+func readSnapshot(r io.Reader, opts SnapshotLoadOptions) (snapshotData, SnapshotInfo, error) {
+	file := &countingReader{r: r}
+	d := &snapshotDecoder{r: bufio.NewReader(file), crc: crc64.New(snapshotCRCTable)}
+	var info SnapshotInfo
+
+	var prefix [len(snapshotMagic) + 2]byte
+	if err := d.full(prefix[:]); err != nil {
+		return snapshotData{}, info, ErrSnapshotMagic
+	}
+	if string(prefix[:len(snapshotMagic)]) != snapshotMagic {
+		return snapshotData{}, info, ErrSnapshotMagic
+	}
+	info.Version = int(binary.BigEndian.Uint16(prefix[len(snapshotMagic):]))
+	compression := snapshotCompressionNone
+	switch info.Version {
+	case 1:
+	case 2, 3, snapshotVersion:
+		b, err := d.ReadByte()
+		if err != nil {
+			return snapshotData{}, info, err
+		}
+		if b > snapshotCompressionZstd {
+			return snapshotData{}, info, fmt.Errorf("unknown snapshot compression %d", b)
+		}
+		compression = b
+	default:
+		return snapshotData{}, info, fmt.Errorf("%w: %d", ErrSnapshotVersion, info.Version)
+	}
+	var created [8]byte
+	if err := d.full(created[:]); err != nil {
+		return snapshotData{}, info, err
+	}
+	info.Created = time.Unix(0, int64(binary.BigEndian.Uint64(created[:])))
+	info.Compression = compressionName(compression)
+
+	if compression == snapshotCompressionZstd {
+		zr, err := zstd.NewReader(d.r)
+		if err != nil {
+			return snapshotData{}, info, err
+		}
+		defer zr.Close()
+		d.r = bufio.NewReader(zr)
+	}
+
+	var data snapshotData
+	for records := 1; ; records++ {
+		op, err := d.ReadByte()
+		if err != nil {
+			return snapshotData{}, info, err
+		}
+		if op == snapshotOpEOF {
+			break
+		}
+		switch {
+		case op == snapshotOpEntry:
+			se, err := d.entry()
+			if err != nil {
+				return snapshotData{}, info, fmt.Errorf("reading snapshot record %d: %w", records, err)
+			}
+			data.entries = append(data.entries, se)
+		case op == snapshotOpDelete:
+			key, err := d.bytes()
+			if err != nil {
+				return snapshotData{}, info, fmt.Errorf("reading snapshot record %d: %w", records, err)
+			}
+			data.deletes = append(data.deletes, string(key))
+		case op == snapshotOpBase && records == 1:
+			base, err := d.varint()
+			if err != nil {
+				return snapshotData{}, info, err
+			}
+			data.base = time.Unix(0, base)
+		default:
+			return snapshotData{}, info, fmt.Errorf("unexpected snapshot opcode 0x%02x at offset %d", op, d.n-1)
+		}
+	}
+
+	want := d.crc.Sum64()
+	var sum [8]byte
+	if _, err := io.ReadFull(d.r, sum[:]); err != nil {
+		return snapshotData{}, info, unexpectedEOF(err)
+	}
+	info.RawBytes = d.n + int64(len(sum))
+	info.Bytes = file.n
+	if got := binary.BigEndian.Uint64(sum[:]); got != want {
+		if !opts.SkipChecksum {
+			return snapshotData{}, info, fmt.Errorf("%w: file has %016x, contents hash to %016x", ErrSnapshotChecksum, got, want)
+		}
+		info.ChecksumMismatch = true
+	}
+	info.Base = data.base
+	info.Entries = len(data.entries)
+	info.Deleted = len(data.deletes)
+	return data, info, nil
+}
+
+// ReadSnapshot loads a snapshot from r into c. The whole snapshot is read
+// and verified before any key is written, so a corrupt file leaves the
+// cache untouched. Keys already in the cache are overwritten and entries
+// that expired since the snapshot was taken are skipped. An incremental
+// snapshot is applied as is; LoadSnapshots checks it matches its base.
+// Nothing is applied if ctx is done by the time the snapshot is verified.
+func (c *Cache) ReadSnapshot(ctx context.Context, r io.Reader, opts SnapshotLoadOptions) (SnapshotInfo, error) {
+	data, info, err := readSnapshot(r, opts)
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return info, err
+	}
+	c.applySnapshot(data)
+	return info, nil
+}
+
+// applySnapshot writes decoded snapshot contents into c
+func (c *Cache) applySnapshot(data snapshotData) {
+	c.lockAll()
+	defer c.unlockAll()
+
+	for _, key := range data.deletes {
+		s := c.shardFor(key)
+		if old, exists := s.data[key]; exists {
+			s.removeEntry(old)
+		}
+	}
+	now := time.Now()
+	for _, se := range data.entries {
+		entry := se.cacheEntry(now)
+		if entry == nil {
+			continue
+		}
+		s := c.shardFor(se.key)
+		if old, exists := s.data[se.key]; exists {
+			s.removeEntry(old)
+		}
+		entry.Pinned = se.pinned && c.pinFits(entry.memoryUsage())
+		s.insertEntry(entry)
+	}
+}
+
+// cacheEntry rebuilds the entry se was taken from, or returns nil if it
+// has expired by now. The entry is left unpinned for the caller to pin if
+// the pin budget allows.
+func (se snapshotEntry) cacheEntry(now time.Time) *CacheEntry {
+	entry := &CacheEntry{
+		Key:          se.key,
+		Value:        se.value,
+		Object:       se.decoded,
+		Tags:         se.tags,
+		SlidingTTL:   se.sliding,
+		Cost:         se.cost,
+		AccessCount:  se.hits,
+		CreatedAt:    now,
+		LastAccessed: now,
+	}
+	if se.object {
+		entry.Value = nil
+	}
+	if se.expiresAt != 0 {
+		expiresAt := time.Unix(0, se.expiresAt*int64(time.Millisecond))
+		if now.After(expiresAt) {
+			return nil
+		}
+		entry.ExpiresAt = &expiresAt
+	}
+	return entry
+}
+
+// LoadSnapshot loads the snapshot file at path into c
+func (c *Cache) LoadSnapshot(ctx context.Context, path string, opts SnapshotLoadOptions) (SnapshotInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	defer file.Close()
+
+	info, err := c.ReadSnapshot(ctx, file, opts)
+	if err != nil {
+		return info, fmt.Errorf("loading snapshot %s: %w", path, err)
+	}
+	return info, nil
+}
@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Snapshot file names in the storage path. The incremental file holds the
+// changes since the full one was written.
+const (
+	snapshotFileName            = "dump.snap"
+	incrementalSnapshotFileName = "dump.incr.snap"
+)
+
+// ErrFullSnapshotRequired is returned for an incremental snapshot when
+// changes have not been tracked since a full snapshot
+var ErrFullSnapshotRequired = errors.New("incremental snapshot needs a full snapshot first")
+
+// resetDirtyKeys starts tracking changes against the full snapshot created
+// at base. Callers hold every shard's write lock.
+func (c *Cache) resetDirtyKeys(base time.Time) {
+	for _, s := range c.shards {
+		s.dirtyKeys = make(map[string]struct{})
+		s.dirtyAll = false
+	}
+	c.snapshotBase = base
+	c.incrementals = 0
+}
+
+// dirtyUnknown reports whether the changes since the last full snapshot
+// are unknown. Callers hold every shard's lock.
+func (c *Cache) dirtyUnknown() bool {
+	for _, s := range c.shards {
+		if s.dirtyKeys == nil || s.dirtyAll {
+			return true
+		}
+	}
+	return false
+}
+
+// invalidateDirtyKeys forces the next snapshot to be a full one
+func (c *Cache) invalidateDirtyKeys() {
+	c.lockAll()
+	defer c.unlockAll()
+
+	for _, s := range c.shards {
+		s.dirtyAll = s.dirtyKeys != nil
+	}
+}
+
+// markDirty records a change to key for the next incremental snapshot
+// and for a running warm-up
+func (s *cacheShard) markDirty(key string) {
+	s.cache.noteWarmupWrite(key)
+	s.cache.saves.changes.Add(1)
+	if s.dirtyKeys != nil {
+		s.dirtyKeys[key] = struct{}{}
+	}
+}
+
+// collectIncremental copies the keys changed since the last full snapshot.
+// Keys that no longer exist are recorded as deleted.
+func (c *Cache) collectIncremental() (snapshotData, error) {
+	c.lockAll()
+	defer c.unlockAll()
+
+	if c.dirtyUnknown() {
+		return snapshotData{}, ErrFullSnapshotRequired
+	}
+
+	now := time.Now()
+	data := snapshotData{base: c.snapshotBase}
+	for _, s := range c.shards {
+		for key := range s.dirtyKeys {
+			entry, exists := s.data[key]
+			if !exists || entry.isExpired(now) {
+				data.deletes = append(data.deletes, key)
+				continue
+			}
+			se, err := snapshotEntryOf(entry)
+			if err != nil {
+				return snapshotData{}, err
+			}
+			data.entries = append(data.entries, se)
+		}
+	}
+	c.incrementals++
+	return data, nil
+}
+
+// WriteIncrementalSnapshot writes the keys changed since the last full
+// snapshot to w. Each incremental snapshot replaces the previous one: it
+// holds every change since the full snapshot, not since the last
+// incremental.
+func (c *Cache) WriteIncrementalSnapshot(ctx context.Context, w io.Writer, opts SnapshotOptions) (SnapshotInfo, error) {
+	created := time.Now()
+	data, err := c.collectIncremental()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	return writeSnapshot(ctx, w, data, created, opts)
+}
+
+// needsFullSnapshot reports whether the next snapshot should be a full
+// one: when no changes are tracked, after fullEvery-1 incremental ones, or
+// once the changes cover half of the keyspace so an incremental snapshot
+// saves little
+func (c *Cache) needsFullSnapshot(fullEvery int) bool {
+	c.rlockAll()
+	defer c.runlockAll()
+
+	switch {
+	case c.dirtyUnknown():
+		return true
+	case fullEvery > 0 && c.incrementals >= fullEvery-1:
+		return true
+	}
+	dirty, keys := 0, 0
+	for _, s := range c.shards {
+		dirty += len(s.dirtyKeys)
+		keys += len(s.data)
+	}
+	return dirty*2 > keys
+}
+
+// SaveSnapshots writes a full or incremental snapshot into cfg.Path. A new
+// full snapshot removes the incremental file it supersedes.
+func (c *Cache) SaveSnapshots(ctx context.Context, cfg StorageConfig) (SnapshotInfo, error) {
+	fullPath := filepath.Join(cfg.Path, snapshotFileName)
+	incrementalPath := filepath.Join(cfg.Path, incrementalSnapshotFileName)
+	opts := cfg.SnapshotOptions()
+
+	if !c.needsFullSnapshot(cfg.FullSnapshotEvery) {
+		info, err := saveSnapshotFile(incrementalPath, func(w io.Writer) (SnapshotInfo, error) {
+			return c.WriteIncrementalSnapshot(ctx, w, opts)
+		})
+		if err != ErrFullSnapshotRequired {
+			return info, err
+		}
+	}
+
+	info, err := c.SaveSnapshot(ctx, fullPath, opts)
+	if err != nil {
+		return info, err
+	}
+	if err := os.Remove(incrementalPath); err != nil && !os.IsNotExist(err) {
+		return info, err
+	}
+	return info, nil
+}
+
+// LoadSnapshots loads the full snapshot in cfg.Path and then the
+// incremental one, if it was taken against that full snapshot. An
+// incremental file left over from an older full snapshot is ignored.
+// Changes are tracked against the loaded full snapshot, so the next
+// incremental snapshot carries on from it. Loading stops with ctx's error
+// once ctx is done.
+func (c *Cache) LoadSnapshots(ctx context.Context, cfg StorageConfig) (full, incremental SnapshotInfo, err error) {
+	opts := SnapshotLoadOptions{SkipChecksum: cfg.SkipChecksum}
+
+	full, data, err := loadSnapshotFile(filepath.Join(cfg.Path, snapshotFileName), opts)
+	if os.IsNotExist(err) {
+		return full, incremental, nil
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return full, incremental, err
+	}
+	c.applySnapshot(data)
+	c.lockAll()
+	c.resetDirtyKeys(full.Created)
+	c.unlockAll()
+
+	incremental, data, err = loadSnapshotFile(filepath.Join(cfg.Path, incrementalSnapshotFileName), opts)
+	if os.IsNotExist(err) {
+		return full, SnapshotInfo{}, nil
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return full, incremental, err
+	}
+	if !incremental.Base.Equal(full.Created) {
+		return full, SnapshotInfo{}, nil
+	}
+	c.applySnapshot(data)
+	return full, incremental, nil
+}
+
+// loadSnapshotFile reads and verifies the snapshot at path
+func loadSnapshotFile(path string, opts SnapshotLoadOptions) (SnapshotInfo, snapshotData, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return SnapshotInfo{}, snapshotData{}, err
+	}
+	defer file.Close()
+
+	data, info, err := readSnapshot(file, opts)
+	if err != nil {
+		return info, data, &os.PathError{Op: "load snapshot", Path: path, Err: err}
+	}
+	return info, data, nil
+}
@@ -0,0 +1,413 @@
+package cache
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// A typed object is stored in a snapshot as an entry with
+// snapshotFlagObject set, whose value is the object's type byte followed by
+// its fields in the framing of the rest of the file. Times are unix nanos.
+
+// Snapshot object types
+const (
+	snapshotObjectSet byte = iota + 1
+	snapshotObjectSortedSet
+	snapshotObjectDelayQueue
+	snapshotObjectPriorityQueue
+	snapshotObjectSemaphore
+	snapshotObjectTimeSeries
+	snapshotObjectVector
+)
+
+// errSnapshotObject is returned for an object value that does not decode
+var errSnapshotObject = errors.New("malformed object in snapshot")
+
+// snapshotObject is implemented by objects snapshots can hold. A snapshot
+// fails rather than drop an object without it.
+type snapshotObject interface {
+	// appendSnapshot appends the object's type byte and fields to b
+	appendSnapshot(b []byte) []byte
+}
+
+// encodeSnapshotObject encodes obj as the value of a snapshot entry
+func encodeSnapshotObject(key string, obj cacheObject) ([]byte, error) {
+	encoder, ok := obj.(snapshotObject)
+	if !ok {
+		return nil, fmt.Errorf("key %q holds a %s, which snapshots cannot hold", key, obj.TypeName())
+	}
+	return encoder.appendSnapshot(nil), nil
+}
+
+func appendBytes(b, p []byte) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(p))), p...)
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(s))), s...)
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	return binary.BigEndian.AppendUint64(b, math.Float64bits(f))
+}
+
+func appendStringMap(b []byte, m map[string]string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(m)))
+	for _, k := range sortedKeys(m) {
+		b = appendString(appendString(b, k), m[k])
+	}
+	return b
+}
+
+// objectDecoder reads the fields of an encoded object. The first error
+// sticks, so fields are read unchecked and err is checked at the end.
+type objectDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *objectDecoder) fail() {
+	if d.err == nil {
+		d.err = errSnapshotObject
+	}
+	d.b = nil
+}
+
+func (d *objectDecoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *objectDecoder) varint() int64 {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.fail()
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+// count reads a number of items, each taking at least one byte, so a
+// corrupt count cannot make the caller allocate more than the value holds
+func (d *objectDecoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.b)) {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
+
+func (d *objectDecoder) bytes() []byte {
+	n := d.count()
+	p := append([]byte(nil), d.b[:n]...)
+	d.b = d.b[n:]
+	return p
+}
+
+func (d *objectDecoder) string() string {
+	n := d.count()
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+func (d *objectDecoder) float() float64 {
+	if len(d.b) < 8 {
+		d.fail()
+		return 0
+	}
+	f := math.Float64frombits(binary.BigEndian.Uint64(d.b))
+	d.b = d.b[8:]
+	return f
+}
+
+func (d *objectDecoder) stringMap() map[string]string {
+	m := make(map[string]string)
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		k := d.string()
+		m[k] = d.string()
+	}
+	return m
+}
+
+// decodeSnapshotObject rebuilds an object encoded by appendSnapshot
+func decodeSnapshotObject(b []byte) (cacheObject, error) {
+	if len(b) == 0 {
+		return nil, errSnapshotObject
+	}
+	d := &objectDecoder{b: b[1:]}
+	var obj cacheObject
+	var err error
+	switch b[0] {
+	case snapshotObjectSet:
+		obj = decodeSet(d)
+	case snapshotObjectSortedSet:
+		obj = decodeSortedSet(d)
+	case snapshotObjectDelayQueue:
+		obj = decodeDelayQueue(d)
+	case snapshotObjectPriorityQueue:
+		obj = decodePriorityQueue(d)
+	case snapshotObjectSemaphore:
+		obj = decodeSemaphore(d)
+	case snapshotObjectTimeSeries:
+		obj = decodeTimeSeries(d)
+	case snapshotObjectVector:
+		obj, err = decodeVectorIndex(d)
+	default:
+		return nil, fmt.Errorf("unknown snapshot object type %d", b[0])
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d.err == nil && len(d.b) > 0 {
+		d.err = errSnapshotObject
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return obj, nil
+}
+
+// appendSnapshot implements snapshotObject
+func (s *set) appendSnapshot(b []byte) []byte {
+	b = append(b, snapshotObjectSet)
+	b = binary.AppendUvarint(b, uint64(len(s.members)))
+	for member := range s.members {
+		b = appendString(b, member)
+	}
+	return b
+}
+
+func decodeSet(d *objectDecoder) *set {
+	s := newSet()
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		s.members[d.string()] = struct{}{}
+	}
+	return s
+}
+
+// appendSnapshot implements snapshotObject
+func (z *sortedSet) appendSnapshot(b []byte) []byte {
+	b = append(b, snapshotObjectSortedSet)
+	b = binary.AppendUvarint(b, uint64(len(z.scores)))
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		b = appendFloat(appendString(b, x.member), x.score)
+	}
+	return b
+}
+
+func decodeSortedSet(d *objectDecoder) *sortedSet {
+	z := newSortedSet()
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		member := d.string()
+		z.set(member, d.float())
+	}
+	return z
+}
+
+// appendSnapshot implements snapshotObject
+func (q *delayQueue) appendSnapshot(b []byte) []byte {
+	b = append(b, snapshotObjectDelayQueue)
+	b = binary.AppendUvarint(b, q.seq)
+	b = binary.AppendUvarint(b, uint64(len(q.items)))
+	for _, item := range q.items {
+		b = appendString(b, item.ID)
+		b = appendBytes(b, item.Payload)
+		b = binary.AppendVarint(b, item.Due.UnixNano())
+		b = binary.AppendUvarint(b, item.seq)
+	}
+	return b
+}
+
+func decodeDelayQueue(d *objectDecoder) *delayQueue {
+	q := newDelayQueue()
+	q.seq = d.uvarint()
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		entry := &delayedEntry{}
+		entry.ID = d.string()
+		entry.Payload = d.bytes()
+		entry.Due = time.Unix(0, d.varint())
+		entry.seq = d.uvarint()
+		heap.Push(q, entry)
+		q.ids[entry.ID] = entry
+	}
+	return q
+}
+
+// appendSnapshot implements snapshotObject
+func (q *priorityQueue) appendSnapshot(b []byte) []byte {
+	b = append(b, snapshotObjectPriorityQueue)
+	b = binary.AppendUvarint(b, q.seq)
+	b = binary.AppendUvarint(b, uint64(len(q.items)))
+	for _, item := range q.items {
+		b = binary.AppendVarint(b, item.Priority)
+		b = appendBytes(b, item.Payload)
+		b = binary.AppendUvarint(b, item.seq)
+	}
+	return b
+}
+
+func decodePriorityQueue(d *objectDecoder) *priorityQueue {
+	q := newPriorityQueue()
+	q.seq = d.uvarint()
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		entry := &priorityEntry{}
+		entry.Priority = d.varint()
+		entry.Payload = d.bytes()
+		entry.seq = d.uvarint()
+		heap.Push(q, entry)
+	}
+	return q
+}
+
+// appendSnapshot implements snapshotObject
+func (s *semaphore) appendSnapshot(b []byte) []byte {
+	b = append(b, snapshotObjectSemaphore)
+	b = binary.AppendUvarint(b, uint64(s.limit))
+	b = binary.AppendUvarint(b, uint64(len(s.leases)))
+	for id, lease := range s.leases {
+		b = appendString(b, id)
+		b = binary.AppendUvarint(b, uint64(lease.permits))
+		b = binary.AppendVarint(b, lease.expires.UnixNano())
+	}
+	return b
+}
+
+func decodeSemaphore(d *objectDecoder) *semaphore {
+	s := newSemaphore()
+	s.limit = int(d.uvarint())
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		id := d.string()
+		lease := &semaphoreLease{permits: int(d.uvarint())}
+		lease.expires = time.Unix(0, d.varint())
+		s.leases[id] = lease
+	}
+	return s
+}
+
+// appendSnapshot implements snapshotObject. Rules keep their open bucket,
+// so compaction carries on where it left off.
+func (s *timeSeries) appendSnapshot(b []byte) []byte {
+	b = append(b, snapshotObjectTimeSeries)
+	b = binary.AppendVarint(b, int64(s.retention))
+	b = appendStringMap(b, s.labels)
+	b = binary.AppendUvarint(b, uint64(len(s.samples)))
+	for _, sample := range s.samples {
+		b = appendFloat(binary.AppendVarint(b, sample.Timestamp), sample.Value)
+	}
+	b = binary.AppendUvarint(b, uint64(len(s.rules)))
+	for _, rule := range s.rules {
+		b = appendString(b, rule.dest)
+		b = binary.AppendUvarint(b, uint64(rule.aggregation.Type))
+		b = binary.AppendVarint(b, int64(rule.aggregation.Bucket))
+		b = binary.AppendVarint(b, rule.bucketStart)
+		acc := &rule.acc
+		b = binary.AppendUvarint(b, uint64(acc.count))
+		for _, f := range []float64{acc.sum, acc.min, acc.max, acc.first, acc.last} {
+			b = appendFloat(b, f)
+		}
+	}
+	return b
+}
+
+func decodeTimeSeries(d *objectDecoder) *timeSeries {
+	s := newTimeSeries(TimeSeriesOptions{Retention: time.Duration(d.varint())})
+	s.labels = d.stringMap()
+	n := d.count()
+	s.samples = make([]TSSample, 0, n)
+	for ; n > 0 && d.err == nil; n-- {
+		ts := d.varint()
+		s.samples = append(s.samples, TSSample{Timestamp: ts, Value: d.float()})
+	}
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		rule := &tsCompactionRule{dest: d.string()}
+		rule.aggregation.Type = TSAggregationType(d.uvarint())
+		rule.aggregation.Bucket = time.Duration(d.varint())
+		rule.bucketStart = d.varint()
+		acc := &rule.acc
+		acc.kind = rule.aggregation.Type
+		acc.count = int(d.uvarint())
+		acc.sum, acc.min, acc.max, acc.first, acc.last = d.float(), d.float(), d.float(), d.float(), d.float()
+		if _, ok := tsAggregationNames[acc.kind]; !ok || rule.aggregation.Bucket < time.Millisecond {
+			d.fail()
+		}
+		s.rules = append(s.rules, rule)
+	}
+	return s
+}
+
+// appendSnapshot implements snapshotObject. The graph is rebuilt on load
+// rather than stored, so only the embeddings are written.
+func (v *vectorIndex) appendSnapshot(b []byte) []byte {
+	b = append(b, snapshotObjectVector)
+	b = binary.AppendUvarint(b, uint64(v.dim))
+	b = binary.AppendUvarint(b, uint64(v.metric))
+	b = binary.AppendUvarint(b, uint64(v.m))
+	b = binary.AppendUvarint(b, uint64(v.efConstruction))
+
+	ids := make([]string, 0, len(v.nodes))
+	for id := range v.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	b = binary.AppendUvarint(b, uint64(len(ids)))
+	for _, id := range ids {
+		node := v.nodes[id]
+		b = appendString(b, id)
+		for _, x := range node.vector {
+			b = binary.BigEndian.AppendUint32(b, math.Float32bits(x))
+		}
+		b = appendStringMap(b, node.meta)
+	}
+	return b
+}
+
+func decodeVectorIndex(d *objectDecoder) (*vectorIndex, error) {
+	opts := VectorOptions{
+		Dim:            int(d.uvarint()),
+		Metric:         VectorMetric(d.uvarint()),
+		M:              int(d.uvarint()),
+		EFConstruction: int(d.uvarint()),
+	}
+	if d.err != nil || opts.Metric > VectorIP {
+		return nil, errSnapshotObject
+	}
+	v, err := newVectorIndex(opts)
+	if err != nil {
+		return nil, err
+	}
+	for n := d.count(); n > 0 && d.err == nil; n-- {
+		id := d.string()
+		if len(d.b) < 4*v.dim {
+			d.fail()
+			break
+		}
+		vector := make([]float32, v.dim)
+		for i := range vector {
+			vector[i] = math.Float32frombits(binary.BigEndian.Uint32(d.b[4*i:]))
+		}
+		d.b = d.b[4*v.dim:]
+		meta := d.stringMap()
+		if len(meta) == 0 {
+			meta = nil
+		}
+		if d.err == nil {
+			if err := v.Add(id, vector, meta); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return v, nil
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestSnapshotRoundTripsMixedKeyspace(t *testing.T) {
+	ctx := context.Background()
+	for _, compress := range []bool{false, true} {
+		c := newMixedKeyspace(t)
+		var file bytes.Buffer
+		written, err := c.WriteSnapshot(ctx, &file, SnapshotOptions{Compress: compress})
+		mustDo(t, err)
+
+		loaded, err := NewCacheFromConfig(DefaultConfig())
+		mustDo(t, err)
+		read, err := loaded.ReadSnapshot(ctx, &file, SnapshotLoadOptions{})
+		mustDo(t, err)
+		if keys := c.currentSize.Load(); read.Entries != written.Entries || int64(read.Entries) != keys {
+			t.Errorf("read %d entries, wrote %d of %d keys", read.Entries, written.Entries, keys)
+		}
+		checkSameKeyspace(t, loaded, c)
+	}
+}
+
+// TestIncrementalSnapshotTracksObjects checks that changes made inside a
+// typed object reach the incremental snapshot
+func TestIncrementalSnapshotTracksObjects(t *testing.T) {
+	ctx := context.Background()
+	cfg := StorageConfig{Path: t.TempDir()}
+	c := newMixedKeyspace(t)
+	full, err := c.SaveSnapshots(ctx, cfg)
+	mustDo(t, err)
+
+	_, err = c.SetAdd("set", "added")
+	mustDo(t, err)
+	_, _, err = c.PopPriority("priorityqueue")
+	mustDo(t, err)
+	incremental, err := c.SaveSnapshots(ctx, cfg)
+	mustDo(t, err)
+	if !incremental.Base.Equal(full.Created) || incremental.Entries != 2 {
+		t.Fatalf("second snapshot is %+v, want an incremental one of 2 entries", incremental)
+	}
+
+	loaded, err := NewCacheFromConfig(DefaultConfig())
+	mustDo(t, err)
+	_, _, err = loaded.LoadSnapshots(ctx, cfg)
+	mustDo(t, err)
+	checkSameKeyspace(t, loaded, c)
+}
+
+func TestSnapshotRejectsMalformedObject(t *testing.T) {
+	c := newMixedKeyspace(t)
+	c.rlockAll()
+	se, err := snapshotEntryOf(c.storedEntry("zset"))
+	c.runlockAll()
+	mustDo(t, err)
+
+	for n := 0; n < len(se.value); n++ {
+		if _, err := decodeSnapshotObject(se.value[:n]); err == nil {
+			t.Errorf("decoded a sorted set cut to %d of %d bytes", n, len(se.value))
+		}
+	}
+	if _, err := decodeSnapshotObject(append(se.value, 0)); err == nil {
+		t.Error("decoded a sorted set with a trailing byte")
+	}
+}
@@ -0,0 +1,190 @@
+package cache
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// invalidateBatchSize bounds how many entries background invalidation
+// removes per lock acquisition
+const invalidateBatchSize = 1000
+
+// SetWithTags stores a value like Set and attaches tags to it, so it can
+// later be removed with InvalidateTag
+func (c *Cache) SetWithTags(ctx context.Context, key string, value []byte, ttl *time.Duration, tags []string) {
+	c.SetWithOptions(ctx, key, value, SetOptions{TTL: ttl, Tags: tags})
+}
+
+// addToTagIndex records the entry's tags
+func (s *cacheShard) addToTagIndex(entry *CacheEntry) {
+	if len(entry.Tags) == 0 {
+		return
+	}
+	if s.tags == nil {
+		s.tags = make(map[string]map[string]struct{})
+	}
+	for _, tag := range entry.Tags {
+		keys, ok := s.tags[tag]
+		if !ok {
+			keys = make(map[string]struct{})
+			s.tags[tag] = keys
+		}
+		keys[entry.Key] = struct{}{}
+	}
+}
+
+// removeFromTagIndex forgets the entry's tags
+func (s *cacheShard) removeFromTagIndex(entry *CacheEntry) {
+	for _, tag := range entry.Tags {
+		if keys, ok := s.tags[tag]; ok {
+			delete(keys, entry.Key)
+			if len(keys) == 0 {
+				delete(s.tags, tag)
+			}
+		}
+	}
+}
+
+// SetTags replaces the tags of key, reporting false if it does not exist
+func (c *Cache) SetTags(key string, tags []string) bool {
+	s := c.shardFor(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entry := s.lookupLive(key)
+	if entry == nil {
+		return false
+	}
+	s.removeFromTagIndex(entry)
+	entry.Tags = tags
+	s.addToTagIndex(entry)
+	s.recharge(entry)
+	return true
+}
+
+// InvalidateTag invalidates every entry carrying tag and returns how many
+// there were. Entries stop being visible immediately: they are marked
+// expired with every shard locked, then removed in the background in
+// batches so a large tag does not stall other clients. Entries set again
+// after the call are not affected.
+func (c *Cache) InvalidateTag(ctx context.Context, tag string) int {
+	invalidated := 0
+	c.logWrite(ctx, nil, func() ([][]byte, error) {
+		if invalidated = c.invalidateTag(tag); invalidated == 0 {
+			return nil, nil
+		}
+		return [][]byte{[]byte("INVALIDATE"), []byte("TAG"), []byte(tag)}, nil
+	})
+	return invalidated
+}
+
+// invalidateTag invalidates the entries carrying tag without logging it
+func (c *Cache) invalidateTag(tag string) int {
+	c.lockAll()
+	now := c.now()
+	stale := make([][]*CacheEntry, len(c.shards))
+	invalidated := 0
+	for i, s := range c.shards {
+		for key := range s.tags[tag] {
+			entry := s.data[key]
+			if entry.isExpired(now) {
+				continue
+			}
+			s.setExpiry(entry, &now)
+			stale[i] = append(stale[i], entry)
+			invalidated++
+		}
+	}
+	c.unlockAll()
+
+	for i, entries := range stale {
+		if len(entries) > 0 {
+			go c.shards[i].removeStale(entries)
+		}
+	}
+	return invalidated
+}
+
+// removeStale removes invalidated entries of the shard that have not since
+// been replaced
+func (s *cacheShard) removeStale(stale []*CacheEntry) {
+	for len(stale) > 0 {
+		n := invalidateBatchSize
+		if n > len(stale) {
+			n = len(stale)
+		}
+
+		s.mutex.Lock()
+		for _, entry := range stale[:n] {
+			if s.data[entry.Key] == entry {
+				s.dropEntry(entry, RemovalDeleted)
+			}
+		}
+		s.mutex.Unlock()
+
+		stale = stale[n:]
+	}
+}
+
+// TagCount returns the number of entries carrying tag
+func (c *Cache) TagCount(tag string) int {
+	now := c.now()
+	count := 0
+	for _, s := range c.shards {
+		s.mutex.RLock()
+		for key := range s.tags[tag] {
+			if !s.data[key].isExpired(now) {
+				count++
+			}
+		}
+		s.mutex.RUnlock()
+	}
+	return count
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "INVALIDATE", Arity: 3, Flags: FlagWrite, Handler: invalidateCommand},
+		&Command{Name: "TAGCOUNT", Arity: 2, Flags: FlagReadOnly, Handler: tagCountCommand},
+		&Command{Name: "SETTAGS", Arity: 3, Flags: FlagWrite, Handler: setTagsCommand, KeyArgs: firstKeyArg},
+	)
+}
+
+// invalidateCommand implements INVALIDATE TAG tag, replying with the number
+// of entries invalidated
+func invalidateCommand(ctx *CommandContext) error {
+	if !strings.EqualFold(string(ctx.Args[1]), "TAG") {
+		return ErrSyntax
+	}
+	ctx.Out.WriteInteger(int64(ctx.Cache.InvalidateTag(ctx.Context, string(ctx.Args[2]))))
+	return nil
+}
+
+// tagCountCommand implements TAGCOUNT tag
+func tagCountCommand(ctx *CommandContext) error {
+	ctx.Out.WriteInteger(int64(ctx.Cache.TagCount(string(ctx.Args[1]))))
+	return nil
+}
+
+// setTagsCommand implements SETTAGS key tag[,tag...], replying 1 if the
+// key was found. The AOF rewrite uses it for the tags of typed objects.
+func setTagsCommand(ctx *CommandContext) error {
+	if ctx.Cache.SetTags(string(ctx.Args[1]), ParseTags(string(ctx.Args[2]))) {
+		ctx.Out.WriteInteger(1)
+	} else {
+		ctx.Out.WriteInteger(0)
+	}
+	return nil
+}
+
+// ParseTags splits a comma separated tag list, dropping empty names
+func ParseTags(list string) []string {
+	tags := make([]string, 0)
+	for _, tag := range strings.Split(list, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
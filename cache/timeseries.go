@@ -0,0 +1,897 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// Time series errors
+var (
+	ErrSeriesExists     = errors.New("time series already exists")
+	ErrSampleTooOld     = errors.New("timestamp is older than the retention period")
+	ErrRuleExists       = errors.New("compaction rule already exists")
+	ErrRuleNotFound     = errors.New("compaction rule not found")
+	ErrInvalidRuleChain = errors.New("compaction destination cannot have rules of its own")
+)
+
+// TSAggregationType selects how samples in a bucket are combined
+type TSAggregationType int
+
+const (
+	TSAvg TSAggregationType = iota
+	TSMin
+	TSMax
+	TSSum
+	TSCount
+	TSFirst
+	TSLast
+)
+
+var tsAggregationNames = map[TSAggregationType]string{
+	TSAvg:   "avg",
+	TSMin:   "min",
+	TSMax:   "max",
+	TSSum:   "sum",
+	TSCount: "count",
+	TSFirst: "first",
+	TSLast:  "last",
+}
+
+// ParseTSAggregationType parses an aggregation name such as "avg", ignoring case
+func ParseTSAggregationType(name string) (TSAggregationType, error) {
+	for t, n := range tsAggregationNames {
+		if strings.EqualFold(name, n) {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown aggregation type: %s", name)
+}
+
+func (t TSAggregationType) String() string {
+	return tsAggregationNames[t]
+}
+
+// TSAggregation groups samples into fixed buckets aligned to the epoch
+type TSAggregation struct {
+	Type   TSAggregationType
+	Bucket time.Duration
+}
+
+// TSSample is a single time series point. Timestamps are Unix milliseconds.
+type TSSample struct {
+	Timestamp int64
+	Value     float64
+}
+
+// TSLabelFilter matches series whose label equals, or with Negate does not
+// equal, Value. A missing label is treated as the empty string.
+type TSLabelFilter struct {
+	Label  string
+	Value  string
+	Negate bool
+}
+
+// TimeSeriesOptions configures a new time series
+type TimeSeriesOptions struct {
+	// Retention drops samples older than the newest sample minus Retention.
+	// Zero keeps samples forever.
+	Retention time.Duration
+	Labels    map[string]string
+}
+
+// TSSeriesRange is the result of a multi-series range query
+type TSSeriesRange struct {
+	Key     string
+	Labels  map[string]string
+	Samples []TSSample
+}
+
+// tsAggregator accumulates samples for one bucket
+type tsAggregator struct {
+	kind  TSAggregationType
+	count int
+	sum   float64
+	min   float64
+	max   float64
+	first float64
+	last  float64
+}
+
+func (a *tsAggregator) add(v float64) {
+	if a.count == 0 {
+		a.min, a.max, a.first = v, v, v
+	}
+	a.min = math.Min(a.min, v)
+	a.max = math.Max(a.max, v)
+	a.sum += v
+	a.last = v
+	a.count++
+}
+
+func (a *tsAggregator) value() float64 {
+	switch a.kind {
+	case TSMin:
+		return a.min
+	case TSMax:
+		return a.max
+	case TSSum:
+		return a.sum
+	case TSCount:
+		return float64(a.count)
+	case TSFirst:
+		return a.first
+	case TSLast:
+		return a.last
+	default:
+		return a.sum / float64(a.count)
+	}
+}
+
+func (a *tsAggregator) reset() {
+	*a = tsAggregator{kind: a.kind}
+}
+
+// tsCompactionRule downsamples a source series into dest. Only the latest
+// bucket is open: samples arriving for earlier buckets are stored in the
+// source but not compacted.
+type tsCompactionRule struct {
+	dest        string
+	aggregation TSAggregation
+	bucketStart int64
+	acc         tsAggregator
+}
+
+// add feeds a sample to the rule and returns the completed bucket, if any
+func (r *tsCompactionRule) add(sample TSSample) (TSSample, bool) {
+	start := bucketStart(sample.Timestamp, r.aggregation.Bucket)
+	if r.acc.count == 0 {
+		r.bucketStart = start
+	}
+	switch {
+	case start == r.bucketStart:
+		r.acc.add(sample.Value)
+		return TSSample{}, false
+	case start > r.bucketStart:
+		done := TSSample{Timestamp: r.bucketStart, Value: r.acc.value()}
+		r.acc.reset()
+		r.bucketStart = start
+		r.acc.add(sample.Value)
+		return done, true
+	default:
+		return TSSample{}, false
+	}
+}
+
+func bucketStart(ts int64, bucket time.Duration) int64 {
+	ms := bucket.Milliseconds()
+	return ts - ts%ms
+}
+
+// timeSeries is a sorted run of samples with optional retention and
+// downsampling rules
+type timeSeries struct {
+	retention time.Duration
+	labels    map[string]string
+	samples   []TSSample
+	rules     []*tsCompactionRule
+}
+
+func newTimeSeries(opts TimeSeriesOptions) *timeSeries {
+	labels := opts.Labels
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	return &timeSeries{retention: opts.Retention, labels: labels}
+}
+
+// TypeName implements cacheObject
+func (s *timeSeries) TypeName() string {
+	return "timeseries"
+}
+
+// memoryUsage implements memorySizer
+func (s *timeSeries) memoryUsage() int64 {
+	n := int64(unsafe.Sizeof(*s)) + allocSize(int64(cap(s.samples))*int64(unsafe.Sizeof(TSSample{})))
+	for label, value := range s.labels {
+		n += mapSlotSize + allocSize(int64(len(label))) + allocSize(int64(len(value)))
+	}
+	for _, rule := range s.rules {
+		n += int64(unsafe.Sizeof(rule)) + allocSize(int64(unsafe.Sizeof(*rule))) + allocSize(int64(len(rule.dest)))
+	}
+	return n
+}
+
+// rewriteCommands implements aofRewriter, creating the series and adding
+// its samples. Rules name other series, so they are written as links.
+func (s *timeSeries) rewriteCommands(key string) [][][]byte {
+	create := [][]byte{[]byte("TS.CREATE"), []byte(key)}
+	if s.retention > 0 {
+		create = append(create, []byte("RETENTION"), []byte(strconv.FormatInt(s.retention.Milliseconds(), 10)))
+	}
+	if len(s.labels) > 0 {
+		create = append(create, []byte("LABELS"))
+		for _, label := range sortedKeys(s.labels) {
+			create = append(create, []byte(label), []byte(s.labels[label]))
+		}
+	}
+
+	commands := make([][][]byte, 0, 1+len(s.samples))
+	commands = append(commands, create)
+	for _, sample := range s.samples {
+		commands = append(commands, [][]byte{
+			[]byte("TS.ADD"), []byte(key),
+			[]byte(strconv.FormatInt(sample.Timestamp, 10)),
+			[]byte(strconv.FormatFloat(sample.Value, 'g', -1, 64)),
+		})
+	}
+	return commands
+}
+
+// rewriteLinks implements aofLinkRewriter. A recreated rule starts with
+// its open bucket empty, so the samples that bucket already holds are not
+// compacted.
+func (s *timeSeries) rewriteLinks(key string) [][][]byte {
+	commands := make([][][]byte, len(s.rules))
+	for i, rule := range s.rules {
+		commands[i] = [][]byte{
+			[]byte("TS.CREATERULE"), []byte(key), []byte(rule.dest),
+			[]byte("AGGREGATION"), []byte(rule.aggregation.Type.String()),
+			[]byte(strconv.FormatInt(rule.aggregation.Bucket.Milliseconds(), 10)),
+		}
+	}
+	return commands
+}
+
+// add stores a sample, replacing any sample with the same timestamp, and
+// returns buckets completed by the series' compaction rules
+func (s *timeSeries) add(sample TSSample) (map[string]TSSample, error) {
+	n := len(s.samples)
+	if s.retention > 0 && n > 0 && sample.Timestamp < s.samples[n-1].Timestamp-s.retention.Milliseconds() {
+		return nil, ErrSampleTooOld
+	}
+
+	i := sort.Search(n, func(i int) bool { return s.samples[i].Timestamp >= sample.Timestamp })
+	switch {
+	case i < n && s.samples[i].Timestamp == sample.Timestamp:
+		s.samples[i] = sample
+	case i == n:
+		s.samples = append(s.samples, sample)
+	default:
+		s.samples = append(s.samples, TSSample{})
+		copy(s.samples[i+1:], s.samples[i:])
+		s.samples[i] = sample
+	}
+	s.trim()
+
+	var completed map[string]TSSample
+	for _, rule := range s.rules {
+		if done, ok := rule.add(sample); ok {
+			if completed == nil {
+				completed = make(map[string]TSSample)
+			}
+			completed[rule.dest] = done
+		}
+	}
+	return completed, nil
+}
+
+// trim drops samples that have fallen out of the retention window
+func (s *timeSeries) trim() {
+	if s.retention <= 0 || len(s.samples) == 0 {
+		return
+	}
+	cutoff := s.samples[len(s.samples)-1].Timestamp - s.retention.Milliseconds()
+	i := sort.Search(len(s.samples), func(i int) bool { return s.samples[i].Timestamp >= cutoff })
+	if i > 0 {
+		s.samples = append(s.samples[:0], s.samples[i:]...)
+	}
+}
+
+// rangeSamples returns samples with from <= timestamp <= to, aggregated into
+// buckets when agg is set, limited to count results when count is positive
+func (s *timeSeries) rangeSamples(from, to int64, agg *TSAggregation, count int) []TSSample {
+	lo := sort.Search(len(s.samples), func(i int) bool { return s.samples[i].Timestamp >= from })
+	hi := sort.Search(len(s.samples), func(i int) bool { return s.samples[i].Timestamp > to })
+	if lo >= hi {
+		return []TSSample{}
+	}
+	window := s.samples[lo:hi]
+
+	var result []TSSample
+	if agg == nil {
+		result = make([]TSSample, len(window))
+		copy(result, window)
+	} else {
+		acc := tsAggregator{kind: agg.Type}
+		current := bucketStart(window[0].Timestamp, agg.Bucket)
+		for _, sample := range window {
+			if start := bucketStart(sample.Timestamp, agg.Bucket); start != current {
+				result = append(result, TSSample{Timestamp: current, Value: acc.value()})
+				acc.reset()
+				current = start
+			}
+			acc.add(sample.Value)
+		}
+		result = append(result, TSSample{Timestamp: current, Value: acc.value()})
+	}
+
+	if count > 0 && len(result) > count {
+		result = result[:count]
+	}
+	return result
+}
+
+func (s *timeSeries) matches(filters []TSLabelFilter) bool {
+	for _, f := range filters {
+		if (s.labels[f.Label] == f.Value) == f.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+// CreateTimeSeries stores an empty time series at key
+func (c *Cache) CreateTimeSeries(key string, opts TimeSeriesOptions) error {
+	s := c.shardFor(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entry := s.lookupLive(key); entry != nil {
+		return ErrSeriesExists
+	}
+	if err := c.admitWrite(0); err != nil {
+		return err
+	}
+	s.storeObject(key, newTimeSeries(opts))
+	return nil
+}
+
+// AddSample appends a sample to the time series at key, creating the series
+// with opts if it does not exist. Completed downsampling buckets are written
+// to the rules' destination series.
+func (c *Cache) AddSample(key string, sample TSSample, opts TimeSeriesOptions) error {
+	s := c.shardFor(key)
+	s.mutex.Lock()
+	if !s.hasCompactionRules(key) {
+		defer s.mutex.Unlock()
+		return c.addSampleLocked(key, sample, &opts)
+	}
+	s.mutex.Unlock()
+
+	// Completed buckets cascade to destinations in any shard, so a series
+	// with rules is written with every shard locked
+	c.lockAll()
+	defer c.unlockAll()
+	return c.addSampleLocked(key, sample, &opts)
+}
+
+// hasCompactionRules reports whether key holds a time series with
+// compaction rules
+func (s *cacheShard) hasCompactionRules(key string) bool {
+	series, _, _ := liveObject[*timeSeries](s, key)
+	return series != nil && len(series.rules) > 0
+}
+
+// addSampleLocked adds a sample and cascades compactions. A nil opts means
+// the series must already exist. Callers hold the write lock of key's
+// shard, and of every shard if the series has rules.
+func (c *Cache) addSampleLocked(key string, sample TSSample, opts *TimeSeriesOptions) error {
+	s := c.shardFor(key)
+	series, entry, err := liveObject[*timeSeries](s, key)
+	if err != nil {
+		return err
+	}
+	if entry == nil && opts == nil {
+		return ErrNoSuchKey
+	}
+	if err := c.admitWrite(0); err != nil {
+		return err
+	}
+
+	created := entry == nil
+	if created {
+		series = newTimeSeries(*opts)
+	}
+	completed, err := series.add(sample)
+	if err != nil {
+		return err
+	}
+	if created {
+		s.storeObject(key, series)
+	} else {
+		s.touchEntry(entry)
+		s.recharge(entry)
+		c.notifier.publish(KeyEventSet, key)
+	}
+
+	for dest, done := range completed {
+		// A deleted or replaced destination silently drops the bucket
+		c.addSampleLocked(dest, done, nil)
+	}
+	return nil
+}
+
+// SampleRange returns samples of the time series at key between from and to
+// inclusive
+func (c *Cache) SampleRange(key string, from, to int64, agg *TSAggregation, count int) ([]TSSample, error) {
+	var samples []TSSample
+	exists, err := viewObject(c, key, func(series *timeSeries) error {
+		samples = series.rangeSamples(from, to, agg, count)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNoSuchKey
+	}
+	return samples, nil
+}
+
+// LastSample returns the newest sample of the time series at key
+func (c *Cache) LastSample(key string) (TSSample, bool, error) {
+	var sample TSSample
+	found := false
+	exists, err := viewObject(c, key, func(series *timeSeries) error {
+		if n := len(series.samples); n > 0 {
+			sample, found = series.samples[n-1], true
+		}
+		return nil
+	})
+	if err == nil && !exists {
+		err = ErrNoSuchKey
+	}
+	return sample, found, err
+}
+
+// CreateCompactionRule downsamples new samples of src into the existing
+// series dest
+func (c *Cache) CreateCompactionRule(src, dest string, agg TSAggregation) error {
+	if agg.Bucket < time.Millisecond {
+		return errors.New("bucket duration must be at least 1ms")
+	}
+	if src == dest {
+		return ErrInvalidRuleChain
+	}
+
+	defer unlockShards(c.lockKeys(src, dest))
+
+	source, srcEntry, err := liveObject[*timeSeries](c.shardFor(src), src)
+	if err != nil {
+		return err
+	}
+	target, destEntry, err := liveObject[*timeSeries](c.shardFor(dest), dest)
+	if err != nil {
+		return err
+	}
+	if srcEntry == nil || destEntry == nil {
+		return ErrNoSuchKey
+	}
+	// Forbidding rules out of a destination rules out cycles
+	if len(target.rules) > 0 {
+		return ErrInvalidRuleChain
+	}
+	for _, rule := range source.rules {
+		if rule.dest == dest {
+			return ErrRuleExists
+		}
+	}
+
+	source.rules = append(source.rules, &tsCompactionRule{
+		dest:        dest,
+		aggregation: agg,
+		acc:         tsAggregator{kind: agg.Type},
+	})
+	c.shardFor(src).recharge(srcEntry)
+	return nil
+}
+
+// DeleteCompactionRule removes the rule from src into dest
+func (c *Cache) DeleteCompactionRule(src, dest string) error {
+	s := c.shardFor(src)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	source, entry, err := liveObject[*timeSeries](s, src)
+	if err != nil {
+		return err
+	}
+	if entry == nil {
+		return ErrNoSuchKey
+	}
+	for i, rule := range source.rules {
+		if rule.dest == dest {
+			source.rules = append(source.rules[:i], source.rules[i+1:]...)
+			s.recharge(entry)
+			return nil
+		}
+	}
+	return ErrRuleNotFound
+}
+
+// QueryTimeSeries returns the sorted keys of every time series whose labels
+// match all filters
+func (c *Cache) QueryTimeSeries(filters []TSLabelFilter) []string {
+	c.rlockAll()
+	defer c.runlockAll()
+
+	return c.matchingSeriesLocked(filters)
+}
+
+// matchingSeriesLocked scans for time series matching filters. Callers hold
+// every shard's lock.
+func (c *Cache) matchingSeriesLocked(filters []TSLabelFilter) []string {
+	now := c.now()
+	keys := make([]string, 0)
+	for _, s := range c.shards {
+		for key, entry := range s.data {
+			series, ok := entry.Object.(*timeSeries)
+			if !ok || entry.isExpired(now) || !series.matches(filters) {
+				continue
+			}
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// MultiRange runs a range query over every time series whose labels match
+// all filters, giving up with ctx's error once ctx is done
+func (c *Cache) MultiRange(ctx context.Context, from, to int64, agg *TSAggregation, count int, filters []TSLabelFilter) ([]TSSeriesRange, error) {
+	c.rlockAll()
+	defer c.runlockAll()
+
+	keys := c.matchingSeriesLocked(filters)
+	ranges := make([]TSSeriesRange, len(keys))
+	for i, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		series := c.storedEntry(key).Object.(*timeSeries)
+		ranges[i] = TSSeriesRange{
+			Key:     key,
+			Labels:  series.labels,
+			Samples: series.rangeSamples(from, to, agg, count),
+		}
+	}
+	return ranges, nil
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "TS.CREATE", Arity: -2, Flags: FlagWrite, Handler: tsCreateCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "TS.ADD", Arity: -4, Flags: FlagWrite | FlagSelfLogged, Handler: tsAddCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "TS.GET", Arity: 2, Flags: FlagReadOnly, Handler: tsGetCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "TS.RANGE", Arity: -4, Flags: FlagReadOnly, Handler: tsRangeCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "TS.MRANGE", Arity: -5, Flags: FlagReadOnly, Handler: tsMRangeCommand},
+		&Command{Name: "TS.QUERYINDEX", Arity: -2, Flags: FlagReadOnly, Handler: tsQueryIndexCommand},
+		&Command{Name: "TS.CREATERULE", Arity: 6, Flags: FlagWrite, Handler: tsCreateRuleCommand, KeyArgs: firstTwoKeyArgs},
+		&Command{Name: "TS.DELETERULE", Arity: 3, Flags: FlagWrite, Handler: tsDeleteRuleCommand, KeyArgs: firstTwoKeyArgs},
+	)
+}
+
+// tsCommandError maps cache errors to replies, keeping those that already
+// carry a reply code
+func tsCommandError(err error) error {
+	if err == ErrWrongType || err == ErrNoSuchKey || err == ErrOOM {
+		return err
+	}
+	return fmt.Errorf("ERR %v", err)
+}
+
+// parseSeriesOptions reads [RETENTION ms] [LABELS label value ...] from args
+func parseSeriesOptions(args [][]byte) (TimeSeriesOptions, error) {
+	var opts TimeSeriesOptions
+	for i := 0; i < len(args); {
+		switch strings.ToUpper(string(args[i])) {
+		case "RETENTION":
+			if i+1 >= len(args) {
+				return opts, ErrSyntax
+			}
+			ms, err := ParseInt(args[i+1])
+			if err != nil || ms < 0 {
+				return opts, ErrNotInteger
+			}
+			opts.Retention = time.Duration(ms) * time.Millisecond
+			i += 2
+		case "LABELS":
+			labels, next, err := parseFieldPairs(args, i+1, "RETENTION")
+			if err != nil {
+				return opts, err
+			}
+			opts.Labels = labels
+			i = next
+		default:
+			return opts, ErrSyntax
+		}
+	}
+	return opts, nil
+}
+
+// parseTimestamp accepts Unix milliseconds, or "*" for now
+func parseTimestamp(arg []byte, now time.Time) (int64, error) {
+	if string(arg) == "*" {
+		return now.UnixMilli(), nil
+	}
+	ts, err := ParseInt(arg)
+	if err != nil || ts < 0 {
+		return 0, errors.New("ERR invalid timestamp")
+	}
+	return ts, nil
+}
+
+// parseRangeBound accepts Unix milliseconds, "-" for the earliest sample or
+// "+" for the latest
+func parseRangeBound(arg []byte) (int64, error) {
+	switch string(arg) {
+	case "-":
+		return math.MinInt64, nil
+	case "+":
+		return math.MaxInt64, nil
+	}
+	ts, err := ParseInt(arg)
+	if err != nil {
+		return 0, errors.New("ERR invalid timestamp")
+	}
+	return ts, nil
+}
+
+// parseAggregation reads AGGREGATION type bucketMs at args[i]
+func parseAggregation(args [][]byte, i int) (*TSAggregation, error) {
+	if i+2 >= len(args) {
+		return nil, ErrSyntax
+	}
+	kind, err := ParseTSAggregationType(string(args[i+1]))
+	if err != nil {
+		return nil, fmt.Errorf("ERR %v", err)
+	}
+	ms, err := ParseInt(args[i+2])
+	if err != nil || ms < 1 {
+		return nil, errors.New("ERR bucket duration must be a positive integer")
+	}
+	return &TSAggregation{Type: kind, Bucket: time.Duration(ms) * time.Millisecond}, nil
+}
+
+// parseLabelFilters reads label=value and label!=value expressions
+func parseLabelFilters(args [][]byte) ([]TSLabelFilter, error) {
+	filters := make([]TSLabelFilter, 0, len(args))
+	for _, arg := range args {
+		expr := string(arg)
+		if i := strings.Index(expr, "!="); i > 0 {
+			filters = append(filters, TSLabelFilter{Label: expr[:i], Value: expr[i+2:], Negate: true})
+		} else if i := strings.Index(expr, "="); i > 0 {
+			filters = append(filters, TSLabelFilter{Label: expr[:i], Value: expr[i+1:]})
+		} else {
+			return nil, fmt.Errorf("ERR invalid filter: %s", expr)
+		}
+	}
+	return filters, nil
+}
+
+// parseRangeOptions reads [AGGREGATION type bucketMs] [COUNT n], stopping
+// at FILTER, and returns the index of the next argument
+func parseRangeOptions(args [][]byte, i int) (*TSAggregation, int, int, error) {
+	var agg *TSAggregation
+	count := 0
+	for i < len(args) {
+		switch strings.ToUpper(string(args[i])) {
+		case "AGGREGATION":
+			var err error
+			if agg, err = parseAggregation(args, i); err != nil {
+				return nil, 0, 0, err
+			}
+			i += 3
+		case "COUNT":
+			if i+1 >= len(args) {
+				return nil, 0, 0, ErrSyntax
+			}
+			n, err := ParseInt(args[i+1])
+			if err != nil || n < 0 {
+				return nil, 0, 0, ErrNotInteger
+			}
+			count = int(n)
+			i += 2
+		case "FILTER":
+			return agg, count, i, nil
+		default:
+			return nil, 0, 0, ErrSyntax
+		}
+	}
+	return agg, count, i, nil
+}
+
+func writeSamples(out *RESPWriter, samples []TSSample) {
+	out.WriteArrayHeader(len(samples))
+	for _, sample := range samples {
+		writeSample(out, sample)
+	}
+}
+
+func writeSample(out *RESPWriter, sample TSSample) {
+	out.WriteArrayHeader(2)
+	out.WriteInteger(sample.Timestamp)
+	out.WriteBulkString(strconv.FormatFloat(sample.Value, 'g', -1, 64))
+}
+
+// tsCreateCommand implements TS.CREATE key [RETENTION ms] [LABELS label value ...]
+func tsCreateCommand(ctx *CommandContext) error {
+	opts, err := parseSeriesOptions(ctx.Args[2:])
+	if err != nil {
+		return err
+	}
+	if err := ctx.Cache.CreateTimeSeries(string(ctx.Args[1]), opts); err != nil {
+		return tsCommandError(err)
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// tsAddCommand implements
+// TS.ADD key timestamp|* value [RETENTION ms] [LABELS label value ...]
+// where the options apply when the series is created. It is logged with
+// the timestamp * resolved to.
+func tsAddCommand(ctx *CommandContext) error {
+	ts, err := parseTimestamp(ctx.Args[2], ctx.Cache.now())
+	if err != nil {
+		return err
+	}
+	value, err := strconv.ParseFloat(string(ctx.Args[3]), 64)
+	if err != nil {
+		return errors.New("ERR invalid value")
+	}
+	opts, err := parseSeriesOptions(ctx.Args[4:])
+	if err != nil {
+		return err
+	}
+
+	// Compactions cascade to keys the command does not name, so it is
+	// ordered against every other write
+	err = ctx.Cache.logWrite(ctx.Context, nil, func() ([][]byte, error) {
+		if err := ctx.Cache.AddSample(string(ctx.Args[1]), TSSample{Timestamp: ts, Value: value}, opts); err != nil {
+			return nil, err
+		}
+		args := slices.Clone(ctx.Args)
+		args[2] = []byte(strconv.FormatInt(ts, 10))
+		return args, nil
+	})
+	if err != nil {
+		return tsCommandError(err)
+	}
+	ctx.Out.WriteInteger(ts)
+	return nil
+}
+
+// tsGetCommand implements TS.GET key, replying with the newest sample or an
+// empty array
+func tsGetCommand(ctx *CommandContext) error {
+	sample, found, err := ctx.Cache.LastSample(string(ctx.Args[1]))
+	if err != nil {
+		return tsCommandError(err)
+	}
+	if !found {
+		ctx.Out.WriteArrayHeader(0)
+		return nil
+	}
+	writeSample(ctx.Out, sample)
+	return nil
+}
+
+// tsRangeCommand implements
+// TS.RANGE key from to [AGGREGATION type bucketMs] [COUNT n]
+func tsRangeCommand(ctx *CommandContext) error {
+	from, err := parseRangeBound(ctx.Args[2])
+	if err != nil {
+		return err
+	}
+	to, err := parseRangeBound(ctx.Args[3])
+	if err != nil {
+		return err
+	}
+	agg, count, next, err := parseRangeOptions(ctx.Args, 4)
+	if err != nil {
+		return err
+	}
+	if next != len(ctx.Args) {
+		return ErrSyntax
+	}
+
+	samples, err := ctx.Cache.SampleRange(string(ctx.Args[1]), from, to, agg, count)
+	if err != nil {
+		return tsCommandError(err)
+	}
+	writeSamples(ctx.Out, samples)
+	return nil
+}
+
+// tsMRangeCommand implements
+// TS.MRANGE from to [AGGREGATION type bucketMs] [COUNT n] FILTER expr ...
+// replying with key, labels and samples for each matching series
+func tsMRangeCommand(ctx *CommandContext) error {
+	from, err := parseRangeBound(ctx.Args[1])
+	if err != nil {
+		return err
+	}
+	to, err := parseRangeBound(ctx.Args[2])
+	if err != nil {
+		return err
+	}
+	agg, count, next, err := parseRangeOptions(ctx.Args, 3)
+	if err != nil {
+		return err
+	}
+	if next+1 >= len(ctx.Args) {
+		return ErrSyntax
+	}
+	filters, err := parseLabelFilters(ctx.Args[next+1:])
+	if err != nil {
+		return err
+	}
+
+	ranges, err := ctx.Cache.MultiRange(ctx.Context, from, to, agg, count, filters)
+	if err != nil {
+		return err
+	}
+	ctx.Out.WriteArrayHeader(len(ranges))
+	for _, r := range ranges {
+		ctx.Out.WriteArrayHeader(3)
+		ctx.Out.WriteBulkString(r.Key)
+
+		labels := make([]string, 0, len(r.Labels))
+		for label := range r.Labels {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		ctx.Out.WriteArrayHeader(len(labels))
+		for _, label := range labels {
+			ctx.Out.WriteStringArray([]string{label, r.Labels[label]})
+		}
+
+		writeSamples(ctx.Out, r.Samples)
+	}
+	return nil
+}
+
+// tsQueryIndexCommand implements TS.QUERYINDEX expr ...
+func tsQueryIndexCommand(ctx *CommandContext) error {
+	filters, err := parseLabelFilters(ctx.Args[1:])
+	if err != nil {
+		return err
+	}
+	ctx.Out.WriteStringArray(ctx.Cache.QueryTimeSeries(filters))
+	return nil
+}
+
+// tsCreateRuleCommand implements
+// TS.CREATERULE src dest AGGREGATION type bucketMs
+func tsCreateRuleCommand(ctx *CommandContext) error {
+	if !strings.EqualFold(string(ctx.Args[3]), "AGGREGATION") {
+		return ErrSyntax
+	}
+	agg, err := parseAggregation(ctx.Args, 3)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Cache.CreateCompactionRule(string(ctx.Args[1]), string(ctx.Args[2]), *agg); err != nil {
+		return tsCommandError(err)
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// tsDeleteRuleCommand implements TS.DELETERULE src dest
+func tsDeleteRuleCommand(ctx *CommandContext) error {
+	if err := ctx.Cache.DeleteCompactionRule(string(ctx.Args[1]), string(ctx.Args[2])); err != nil {
+		return tsCommandError(err)
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
@@ -0,0 +1,36 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestTSAddLogsResolvedTimestamp checks that TS.ADD * takes its timestamp
+// from the cache's clock and is logged with it, so a replica adds the
+// sample at the same time
+func TestTSAddLogsResolvedTimestamp(t *testing.T) {
+	c, err := NewCacheFromConfig(DefaultConfig())
+	mustDo(t, err)
+	now := time.UnixMilli(1700000000000)
+	c.SetClock(func() time.Time { return now })
+	wal := c.EnableWAL(1 << 20)
+
+	if got := runCommand(t, c, "TS.ADD", "series", "*", "1.5", "LABELS", "host", "a"); got != ":1700000000000\r\n" {
+		t.Errorf("TS.ADD * replied %q, want the clock's time", got)
+	}
+	runCommand(t, c, "TS.ADD", "series", "1700000000001", "2")
+	want := []string{
+		fmt.Sprintf("%q", [][]byte{[]byte("TS.ADD"), []byte("series"), []byte("1700000000000"), []byte("1.5"), []byte("LABELS"), []byte("host"), []byte("a")}),
+		fmt.Sprintf("%q", [][]byte{[]byte("TS.ADD"), []byte("series"), []byte("1700000000001"), []byte("2")}),
+	}
+	logged := walCommands(t, wal, 0)
+	if len(logged) != len(want) {
+		t.Fatalf("TS.ADD logged %v, want %v", logged, want)
+	}
+	for i := range want {
+		if logged[i] != want[i] {
+			t.Errorf("TS.ADD logged %s, want %s", logged[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package cache
+
+import (
+	"container/list"
+	"hash/maphash"
+)
+
+// W-TinyLFU tuning, following the Caffeine defaults
+const (
+	// tinyLFUWindowPercent of capacity is a plain LRU admission window that
+	// lets new entries build up frequency before competing for main space
+	tinyLFUWindowPercent = 1
+	// tinyLFUProtectedPercent of the main space holds entries accessed
+	// again after admission
+	tinyLFUProtectedPercent = 80
+	// tinyLFUSampleFactor times capacity increments trigger halving every
+	// counter, so old popularity fades
+	tinyLFUSampleFactor = 10
+)
+
+// W-TinyLFU segments an entry can be in
+const (
+	segmentWindow = iota
+	segmentProbation
+	segmentProtected
+)
+
+// frequencySketch is a count-min sketch of 4-bit saturating counters that
+// estimates how often a key has been seen
+type frequencySketch struct {
+	seed      maphash.Seed
+	table     []uint8
+	mask      uint64
+	additions int
+	resetAt   int
+}
+
+func newFrequencySketch(capacity int) *frequencySketch {
+	width := 16
+	for width < capacity {
+		width <<= 1
+	}
+	return &frequencySketch{
+		seed:    maphash.MakeSeed(),
+		table:   make([]uint8, width),
+		mask:    uint64(width - 1),
+		resetAt: tinyLFUSampleFactor * max(capacity, 1),
+	}
+}
+
+// indexes returns one counter position per sketch row for key
+func (s *frequencySketch) indexes(key string) [4]uint64 {
+	h := maphash.String(s.seed, key)
+	lo, hi := h, h>>32|h<<32
+	var idx [4]uint64
+	for i := range idx {
+		idx[i] = (lo + uint64(i)*hi) & s.mask
+	}
+	return idx
+}
+
+// Increment records an occurrence of key
+func (s *frequencySketch) Increment(key string) {
+	for _, i := range s.indexes(key) {
+		if s.table[i] < 15 {
+			s.table[i]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAt {
+		for i := range s.table {
+			s.table[i] >>= 1
+		}
+		s.additions /= 2
+	}
+}
+
+// Estimate returns the approximate number of occurrences of key
+func (s *frequencySketch) Estimate(key string) uint8 {
+	estimate := uint8(15)
+	for _, i := range s.indexes(key) {
+		if s.table[i] < estimate {
+			estimate = s.table[i]
+		}
+	}
+	return estimate
+}
+
+// wTinyLFUPolicy puts new entries in a small LRU window. Entries leaving the
+// window are only admitted to the main segmented LRU if they have been seen
+// more often than the entry they would displace, so one-hit wonders from
+// scans cannot flush out hot entries.
+type wTinyLFUPolicy struct {
+	capacity     int
+	windowCap    int
+	protectedCap int
+
+	sketch    *frequencySketch
+	window    *list.List
+	probation *list.List
+	protected *list.List
+}
+
+func newWTinyLFUPolicy(capacity int) *wTinyLFUPolicy {
+	windowCap := max(1, capacity*tinyLFUWindowPercent/100)
+	return &wTinyLFUPolicy{
+		capacity:     capacity,
+		windowCap:    windowCap,
+		protectedCap: (capacity - windowCap) * tinyLFUProtectedPercent / 100,
+		sketch:       newFrequencySketch(capacity),
+		window:       list.New(),
+		probation:    list.New(),
+		protected:    list.New(),
+	}
+}
+
+func (p *wTinyLFUPolicy) segment(entry *CacheEntry) *list.List {
+	switch entry.segment {
+	case segmentProbation:
+		return p.probation
+	case segmentProtected:
+		return p.protected
+	default:
+		return p.window
+	}
+}
+
+func (p *wTinyLFUPolicy) move(entry *CacheEntry, segment int) {
+	p.segment(entry).Remove(entry.element)
+	entry.segment = segment
+	entry.element = p.segment(entry).PushFront(entry)
+}
+
+func (p *wTinyLFUPolicy) Add(entry *CacheEntry) {
+	p.sketch.Increment(entry.Key)
+	entry.segment = segmentWindow
+	entry.element = p.window.PushFront(entry)
+
+	// While there is room, entries leaving the window go straight to the
+	// main space; once full, Victim decides whether they are admitted
+	if p.window.Len() > p.windowCap && p.Len() <= p.capacity {
+		p.move(p.window.Back().Value.(*CacheEntry), segmentProbation)
+	}
+}
+
+func (p *wTinyLFUPolicy) Access(entry *CacheEntry) {
+	p.sketch.Increment(entry.Key)
+	switch entry.segment {
+	case segmentProbation:
+		p.move(entry, segmentProtected)
+		if p.protected.Len() > p.protectedCap {
+			p.move(p.protected.Back().Value.(*CacheEntry), segmentProbation)
+		}
+	default:
+		p.segment(entry).MoveToFront(entry.element)
+	}
+}
+
+func (p *wTinyLFUPolicy) Remove(entry *CacheEntry) {
+	p.segment(entry).Remove(entry.element)
+	entry.element = nil
+}
+
+func (p *wTinyLFUPolicy) Victim() *CacheEntry {
+	var candidate, victim *CacheEntry
+	if p.window.Len() > p.windowCap {
+		candidate = p.window.Back().Value.(*CacheEntry)
+	}
+	if back := p.probation.Back(); back != nil {
+		victim = back.Value.(*CacheEntry)
+	} else if back := p.protected.Back(); back != nil {
+		victim = back.Value.(*CacheEntry)
+	}
+
+	switch {
+	case candidate == nil && victim == nil:
+		if back := p.window.Back(); back != nil {
+			return back.Value.(*CacheEntry)
+		}
+		return nil
+	case candidate == nil:
+		return victim
+	case victim == nil:
+		return candidate
+	}
+
+	// Admit the window's candidate only if it is more popular
+	if p.sketch.Estimate(candidate.Key) > p.sketch.Estimate(victim.Key) {
+		p.move(candidate, segmentProbation)
+		return victim
+	}
+	return candidate
+}
+
+func (p *wTinyLFUPolicy) Len() int {
+	return p.window.Len() + p.probation.Len() + p.protected.Len()
+}
+
+func (p *wTinyLFUPolicy) Reset() {
+	p.window.Init()
+	p.probation.Init()
+	p.protected.Init()
+}
@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// The hit-rate benchmarks replay a skewed workload broken up by scans of
+// keys read once, which flush a plain LRU but that W-TinyLFU does not
+// admit over the popular keys. They report the hit rate as hit%:
+//
+//	go test -run '^$' -bench HitRate
+
+// Shape of the hit-rate workload
+const (
+	// hitRateCapacity is how many keys the cache holds
+	hitRateCapacity = 1000
+	// hitRatePopular keys are read with a Zipf distribution
+	hitRatePopular = 20 * hitRateCapacity
+	// every hitRateScanEvery reads, a scan reads hitRateScanLength new keys
+	hitRateScanEvery  = 10000
+	hitRateScanLength = 2 * hitRateCapacity
+	hitRateTraceLen   = 500000
+)
+
+// hitRateTrace returns the keys the workload reads, in order
+func hitRateTrace() []string {
+	rng := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rng, 1.1, 1, hitRatePopular-1)
+	trace := make([]string, 0, hitRateTraceLen)
+	scanned := 0
+	for len(trace) < hitRateTraceLen {
+		if len(trace)%hitRateScanEvery == hitRateScanEvery-1 {
+			for i := 0; i < hitRateScanLength && len(trace) < hitRateTraceLen; i++ {
+				trace = append(trace, fmt.Sprintf("scan:%d", scanned))
+				scanned++
+			}
+			continue
+		}
+		trace = append(trace, fmt.Sprintf("popular:%d", zipf.Uint64()))
+	}
+	return trace
+}
+
+// newHitRateCache returns a single shard cache holding hitRateCapacity keys
+// under policy
+func newHitRateCache(tb testing.TB, policy string) *Cache {
+	cfg := DefaultConfig()
+	cfg.ShardCount = 1
+	cfg.MaxKeys = hitRateCapacity
+	cfg.EvictionPolicy = policy
+	c, err := NewCacheFromConfig(cfg)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return c
+}
+
+// replayHitRate reads each key of trace, writing it on a miss as a
+// read-through cache would, and returns the share of reads that hit
+func replayHitRate(c *Cache, trace []string, n int) float64 {
+	ctx := context.Background()
+	hits := 0
+	for i := 0; i < n; i++ {
+		key := trace[i%len(trace)]
+		if _, ok := c.Get(ctx, key); ok {
+			hits++
+		} else {
+			c.Set(ctx, key, benchValue, nil)
+		}
+	}
+	return float64(hits) / float64(n)
+}
+
+func BenchmarkHitRate(b *testing.B) {
+	trace := hitRateTrace()
+	for _, policy := range []string{EvictionLRU, EvictionLFU, EvictionWTinyLFU} {
+		b.Run(policy, func(b *testing.B) {
+			c := newHitRateCache(b, policy)
+			b.ResetTimer()
+			rate := replayHitRate(c, trace, b.N)
+			b.ReportMetric(100*rate, "hit%")
+		})
+	}
+}
+
+func TestWTinyLFUResistsScans(t *testing.T) {
+	if testing.Short() {
+		t.Skip("replays a long trace")
+	}
+	trace := hitRateTrace()
+	lru := replayHitRate(newHitRateCache(t, EvictionLRU), trace, len(trace))
+	tinyLFU := replayHitRate(newHitRateCache(t, EvictionWTinyLFU), trace, len(trace))
+	t.Logf("hit rate: lru %.1f%%, w-tinylfu %.1f%%", 100*lru, 100*tinyLFU)
+	if tinyLFU <= lru {
+		t.Errorf("w-tinylfu hit rate %.1f%% is not above lru's %.1f%%", 100*tinyLFU, 100*lru)
+	}
+}
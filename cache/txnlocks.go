@@ -0,0 +1,74 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrKeyLocked is returned for writes to keys a pending distributed
+// transaction holds
+var ErrKeyLocked = errors.New("TRYAGAIN key is locked by a pending distributed transaction")
+
+// txnLocks maps the keys locked by pending distributed transactions to
+// the transaction holding each. The table lives in the cache so that every
+// server writing to it sees the same locks, but Cache methods do not check
+// it: the RESP, HTTP and memcached servers refuse writes to locked keys
+// before making them, while embedders calling the Cache directly bypass
+// the locks, as do commands that do not declare their keys, such as
+// DELPATTERN, FLUSHALL and tag invalidation.
+type txnLocks struct {
+	mu     sync.Mutex
+	owners map[string]string
+}
+
+// LockKeys locks keys for the transaction id, failing with ErrKeyLocked
+// without locking any if another transaction holds one of them
+func (c *Cache) LockKeys(id string, keys []string) error {
+	l := &c.txnLocks
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if owner, locked := l.owners[key]; locked && owner != id {
+			return ErrKeyLocked
+		}
+	}
+	if l.owners == nil {
+		l.owners = make(map[string]string)
+	}
+	for _, key := range keys {
+		l.owners[key] = id
+	}
+	return nil
+}
+
+// UnlockKeys unlocks those of keys the transaction id holds
+func (c *Cache) UnlockKeys(id string, keys []string) {
+	l := &c.txnLocks
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		if l.owners[key] == id {
+			delete(l.owners, key)
+		}
+	}
+}
+
+// CheckKeyLocks returns ErrKeyLocked if a pending transaction holds any of
+// keys, for servers to call before writing them
+func (c *Cache) CheckKeyLocks(keys ...string) error {
+	l := &c.txnLocks
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.owners) == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		if _, locked := l.owners[key]; locked {
+			return ErrKeyLocked
+		}
+	}
+	return nil
+}
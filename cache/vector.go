@@ -0,0 +1,833 @@
+package cache
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// Vector index defaults
+const (
+	defaultVectorM              = 16
+	defaultVectorEFConstruction = 200
+	defaultVectorEFSearch       = 50
+	maxVectorDim                = 32768
+)
+
+// Vector index errors
+var (
+	ErrVectorExists    = errors.New("vector index already exists")
+	ErrVectorDimension = errors.New("vector dimension mismatch")
+)
+
+// VectorMetric selects how distance between embeddings is measured
+type VectorMetric int
+
+const (
+	// VectorCosine ranks by cosine distance, 1 - cos(a, b)
+	VectorCosine VectorMetric = iota
+	// VectorL2 ranks by euclidean distance
+	VectorL2
+	// VectorIP ranks by negated inner product
+	VectorIP
+)
+
+// ParseVectorMetric parses COSINE, L2 or IP, ignoring case
+func ParseVectorMetric(name string) (VectorMetric, error) {
+	switch strings.ToUpper(name) {
+	case "COSINE":
+		return VectorCosine, nil
+	case "L2":
+		return VectorL2, nil
+	case "IP":
+		return VectorIP, nil
+	default:
+		return 0, fmt.Errorf("unknown vector metric: %s", name)
+	}
+}
+
+func (m VectorMetric) String() string {
+	switch m {
+	case VectorCosine:
+		return "COSINE"
+	case VectorL2:
+		return "L2"
+	case VectorIP:
+		return "IP"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// VectorOptions configures a new vector index
+type VectorOptions struct {
+	Dim    int
+	Metric VectorMetric
+	// M is the number of links kept per node on the upper layers; layer 0
+	// keeps twice as many
+	M              int
+	EFConstruction int
+}
+
+// VectorMatch is a single nearest neighbor result. Lower distances are closer.
+type VectorMatch struct {
+	ID       string
+	Distance float32
+	Meta     map[string]string
+}
+
+// vectorIndex is a hierarchical navigable small world graph over embeddings
+// stored under a single key
+type vectorIndex struct {
+	dim            int
+	metric         VectorMetric
+	m              int
+	mMax0          int
+	efConstruction int
+	levelMult      float64
+
+	nodes    map[string]*hnswNode
+	entry    *hnswNode
+	maxLevel int
+	rng      *rand.Rand
+	// bytes is the estimated memory held by nodes
+	bytes int64
+}
+
+type hnswNode struct {
+	id        string
+	vector    []float32
+	meta      map[string]string
+	level     int
+	neighbors [][]*hnswNode // links per layer, 0..level
+}
+
+type hnswCandidate struct {
+	node *hnswNode
+	dist float32
+}
+
+// candidateHeap is a min-heap by distance, or a max-heap when max is set
+type candidateHeap struct {
+	items []hnswCandidate
+	max   bool
+}
+
+func (h *candidateHeap) Len() int { return len(h.items) }
+func (h *candidateHeap) Less(i, j int) bool {
+	if h.max {
+		return h.items[i].dist > h.items[j].dist
+	}
+	return h.items[i].dist < h.items[j].dist
+}
+func (h *candidateHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *candidateHeap) Push(x interface{}) { h.items = append(h.items, x.(hnswCandidate)) }
+func (h *candidateHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+func (h *candidateHeap) top() hnswCandidate { return h.items[0] }
+
+func newVectorIndex(opts VectorOptions) (*vectorIndex, error) {
+	if opts.Dim <= 0 || opts.Dim > maxVectorDim {
+		return nil, fmt.Errorf("vector dimension must be between 1 and %d", maxVectorDim)
+	}
+	if opts.M == 0 {
+		opts.M = defaultVectorM
+	}
+	if opts.EFConstruction == 0 {
+		opts.EFConstruction = defaultVectorEFConstruction
+	}
+	if opts.M < 2 || opts.EFConstruction < 1 {
+		return nil, errors.New("invalid HNSW parameters")
+	}
+	return &vectorIndex{
+		dim:            opts.Dim,
+		metric:         opts.Metric,
+		m:              opts.M,
+		mMax0:          opts.M * 2,
+		efConstruction: opts.EFConstruction,
+		levelMult:      1 / math.Log(float64(opts.M)),
+		nodes:          make(map[string]*hnswNode),
+		rng:            rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+// TypeName implements cacheObject
+func (v *vectorIndex) TypeName() string {
+	return "vector"
+}
+
+// rewriteCommands implements aofRewriter, creating the index and adding
+// each embedding in ID order. The graph is rebuilt rather than copied, so
+// its links, but not the embeddings, may differ from the original's.
+func (v *vectorIndex) rewriteCommands(key string) [][][]byte {
+	commands := [][][]byte{{
+		[]byte("VEC.CREATE"), []byte(key),
+		[]byte("DIM"), []byte(strconv.Itoa(v.dim)),
+		[]byte("METRIC"), []byte(v.metric.String()),
+		[]byte("M"), []byte(strconv.Itoa(v.m)),
+		[]byte("EF_CONSTRUCTION"), []byte(strconv.Itoa(v.efConstruction)),
+	}}
+
+	ids := make([]string, 0, len(v.nodes))
+	for id := range v.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		node := v.nodes[id]
+		blob := make([]byte, 4*len(node.vector))
+		for i, x := range node.vector {
+			binary.LittleEndian.PutUint32(blob[4*i:], math.Float32bits(x))
+		}
+		args := [][]byte{[]byte("VEC.ADD"), []byte(key), []byte(id), []byte("FP32"), blob}
+		if len(node.meta) > 0 {
+			args = append(args, []byte("META"))
+			for _, field := range sortedKeys(node.meta) {
+				args = append(args, []byte(field), []byte(node.meta[field]))
+			}
+		}
+		commands = append(commands, args)
+	}
+	return commands
+}
+
+// prepare validates a vector and returns the copy stored or searched with
+func (v *vectorIndex) prepare(vector []float32) ([]float32, error) {
+	if len(vector) != v.dim {
+		return nil, ErrVectorDimension
+	}
+	prepared := make([]float32, len(vector))
+	copy(prepared, vector)
+	if v.metric == VectorCosine {
+		var norm float64
+		for _, x := range prepared {
+			norm += float64(x) * float64(x)
+		}
+		if norm == 0 {
+			return nil, errors.New("cannot use a zero vector with the cosine metric")
+		}
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range prepared {
+			prepared[i] *= scale
+		}
+	}
+	return prepared, nil
+}
+
+// distance compares two prepared vectors; lower is closer
+func (v *vectorIndex) distance(a, b []float32) float32 {
+	switch v.metric {
+	case VectorL2:
+		var sum float32
+		for i := range a {
+			d := a[i] - b[i]
+			sum += d * d
+		}
+		return sum
+	case VectorIP:
+		var dot float32
+		for i := range a {
+			dot += a[i] * b[i]
+		}
+		return -dot
+	default:
+		// Cosine vectors are normalized on the way in
+		var dot float32
+		for i := range a {
+			dot += a[i] * b[i]
+		}
+		return 1 - dot
+	}
+}
+
+// reportedDistance converts an internal distance to the one returned to
+// callers; L2 is compared squared but reported as a true distance
+func (v *vectorIndex) reportedDistance(d float32) float32 {
+	if v.metric == VectorL2 {
+		return float32(math.Sqrt(float64(d)))
+	}
+	return d
+}
+
+func (v *vectorIndex) maxConnections(level int) int {
+	if level == 0 {
+		return v.mMax0
+	}
+	return v.m
+}
+
+func (v *vectorIndex) randomLevel() int {
+	return int(math.Floor(-math.Log(1-v.rng.Float64()) * v.levelMult))
+}
+
+// Len returns the number of stored vectors
+func (v *vectorIndex) Len() int {
+	return len(v.nodes)
+}
+
+// nodeSize estimates the memory held by a node, assuming its link lists
+// are full so the estimate does not change as the graph is rewired
+func (v *vectorIndex) nodeSize(node *hnswNode) int64 {
+	ptr := int64(unsafe.Sizeof(node))
+	n := mapSlotSize + stringSize(node.id) + allocSize(int64(unsafe.Sizeof(*node)))
+	n += allocSize(int64(cap(node.vector)) * int64(unsafe.Sizeof(float32(0))))
+	for field, value := range node.meta {
+		n += mapSlotSize + allocSize(int64(len(field))) + allocSize(int64(len(value)))
+	}
+	n += allocSize(int64(len(node.neighbors)) * int64(unsafe.Sizeof(node.neighbors)))
+	for l := range node.neighbors {
+		n += allocSize(int64(v.maxConnections(l)+1) * ptr)
+	}
+	return n
+}
+
+// memoryUsage implements memorySizer
+func (v *vectorIndex) memoryUsage() int64 {
+	return int64(unsafe.Sizeof(*v)) + v.bytes
+}
+
+// Add inserts or replaces the vector for id
+func (v *vectorIndex) Add(id string, vector []float32, meta map[string]string) error {
+	prepared, err := v.prepare(vector)
+	if err != nil {
+		return err
+	}
+	if _, exists := v.nodes[id]; exists {
+		v.Delete(id)
+	}
+
+	level := v.randomLevel()
+	node := &hnswNode{
+		id:        id,
+		vector:    prepared,
+		meta:      meta,
+		level:     level,
+		neighbors: make([][]*hnswNode, level+1),
+	}
+	v.nodes[id] = node
+	v.bytes += v.nodeSize(node)
+
+	if v.entry == nil {
+		v.entry = node
+		v.maxLevel = level
+		return nil
+	}
+
+	// Descend greedily through the layers above the new node
+	ep := v.entry
+	for l := v.maxLevel; l > level; l-- {
+		ep = v.searchLayer(prepared, ep, 1, l)[0].node
+	}
+
+	for l := min(level, v.maxLevel); l >= 0; l-- {
+		candidates := v.searchLayer(prepared, ep, v.efConstruction, l)
+		node.neighbors[l] = closestNodes(candidates, v.m)
+		for _, neighbor := range node.neighbors[l] {
+			neighbor.neighbors[l] = append(neighbor.neighbors[l], node)
+			if len(neighbor.neighbors[l]) > v.maxConnections(l) {
+				v.shrinkNeighbors(neighbor, l)
+			}
+		}
+		ep = candidates[0].node
+	}
+
+	if level > v.maxLevel {
+		v.entry = node
+		v.maxLevel = level
+	}
+	return nil
+}
+
+// Delete removes the vector for id, relinking its neighbors so the graph
+// stays navigable, and reports whether it existed
+func (v *vectorIndex) Delete(id string) bool {
+	node, exists := v.nodes[id]
+	if !exists {
+		return false
+	}
+	delete(v.nodes, id)
+	v.bytes -= v.nodeSize(node)
+
+	for l, links := range node.neighbors {
+		for _, neighbor := range links {
+			candidates := make([]*hnswNode, 0, len(neighbor.neighbors[l])+len(links))
+			seen := map[*hnswNode]bool{node: true, neighbor: true}
+			for _, n := range append(neighbor.neighbors[l], links...) {
+				if !seen[n] {
+					seen[n] = true
+					candidates = append(candidates, n)
+				}
+			}
+			neighbor.neighbors[l] = candidates
+			if len(candidates) > v.maxConnections(l) {
+				v.shrinkNeighbors(neighbor, l)
+			}
+		}
+	}
+
+	// Links pointing at the node from outside its own neighborhood are
+	// possible after pruning, so sweep any that remain
+	for _, other := range v.nodes {
+		for l := 0; l <= other.level && l <= node.level; l++ {
+			for i, n := range other.neighbors[l] {
+				if n == node {
+					other.neighbors[l] = append(other.neighbors[l][:i], other.neighbors[l][i+1:]...)
+					break
+				}
+			}
+		}
+	}
+
+	if v.entry == node {
+		v.entry = nil
+		v.maxLevel = 0
+		for _, other := range v.nodes {
+			if v.entry == nil || other.level > v.maxLevel {
+				v.entry = other
+				v.maxLevel = other.level
+			}
+		}
+	}
+	return true
+}
+
+// shrinkNeighbors keeps only the closest links of node on a layer
+func (v *vectorIndex) shrinkNeighbors(node *hnswNode, level int) {
+	links := node.neighbors[level]
+	candidates := make([]hnswCandidate, len(links))
+	for i, n := range links {
+		candidates[i] = hnswCandidate{node: n, dist: v.distance(node.vector, n.vector)}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].dist < candidates[j].dist })
+	node.neighbors[level] = closestNodes(candidates, v.maxConnections(level))
+}
+
+// searchLayer returns up to ef nodes closest to query on a layer, starting
+// from ep, sorted by ascending distance
+func (v *vectorIndex) searchLayer(query []float32, ep *hnswNode, ef, level int) []hnswCandidate {
+	start := hnswCandidate{node: ep, dist: v.distance(query, ep.vector)}
+	visited := map[*hnswNode]bool{ep: true}
+	candidates := &candidateHeap{items: []hnswCandidate{start}}
+	results := &candidateHeap{items: []hnswCandidate{start}, max: true}
+
+	for candidates.Len() > 0 {
+		current := heap.Pop(candidates).(hnswCandidate)
+		if results.Len() >= ef && current.dist > results.top().dist {
+			break
+		}
+		for _, n := range current.node.neighbors[level] {
+			if visited[n] {
+				continue
+			}
+			visited[n] = true
+			d := v.distance(query, n.vector)
+			if results.Len() < ef || d < results.top().dist {
+				heap.Push(candidates, hnswCandidate{node: n, dist: d})
+				heap.Push(results, hnswCandidate{node: n, dist: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	sorted := results.items
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].dist < sorted[j].dist })
+	return sorted
+}
+
+// Search returns the k nearest vectors to query whose metadata matches every
+// field in filter. ef sets the search breadth and defaults to
+// defaultVectorEFSearch. When a filter rejects too many candidates the
+// search is widened, falling back to an exact scan.
+func (v *vectorIndex) Search(query []float32, k, ef int, filter map[string]string) ([]VectorMatch, error) {
+	prepared, err := v.prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	if v.entry == nil || k <= 0 {
+		return []VectorMatch{}, nil
+	}
+	if ef <= 0 {
+		ef = defaultVectorEFSearch
+	}
+	if ef < k {
+		ef = k
+	}
+
+	ep := v.entry
+	for l := v.maxLevel; l > 0; l-- {
+		ep = v.searchLayer(prepared, ep, 1, l)[0].node
+	}
+
+	for {
+		candidates := v.searchLayer(prepared, ep, ef, 0)
+		matches := v.collectMatches(candidates, k, filter)
+		if len(matches) >= k || len(filter) == 0 && len(candidates) < ef {
+			return matches, nil
+		}
+		if ef >= len(v.nodes) {
+			break
+		}
+		ef *= 2
+	}
+
+	// The graph walk could not find enough matches; scan exactly
+	candidates := make([]hnswCandidate, 0, len(v.nodes))
+	for _, node := range v.nodes {
+		candidates = append(candidates, hnswCandidate{node: node, dist: v.distance(prepared, node.vector)})
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].dist < candidates[j].dist })
+	return v.collectMatches(candidates, k, filter), nil
+}
+
+func (v *vectorIndex) collectMatches(candidates []hnswCandidate, k int, filter map[string]string) []VectorMatch {
+	matches := make([]VectorMatch, 0, k)
+	for _, c := range candidates {
+		if !metaMatches(c.node.meta, filter) {
+			continue
+		}
+		matches = append(matches, VectorMatch{
+			ID:       c.node.id,
+			Distance: v.reportedDistance(c.dist),
+			Meta:     c.node.meta,
+		})
+		if len(matches) == k {
+			break
+		}
+	}
+	return matches
+}
+
+func metaMatches(meta, filter map[string]string) bool {
+	for field, want := range filter {
+		if got, ok := meta[field]; !ok || got != want {
+			return false
+		}
+	}
+	return true
+}
+
+func closestNodes(candidates []hnswCandidate, n int) []*hnswNode {
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	nodes := make([]*hnswNode, len(candidates))
+	for i, c := range candidates {
+		nodes[i] = c.node
+	}
+	return nodes
+}
+
+// CreateVectorIndex stores an empty vector index at key
+func (c *Cache) CreateVectorIndex(key string, opts VectorOptions) error {
+	created := false
+	err := updateObject(c, key, func() (*vectorIndex, error) {
+		created = true
+		return newVectorIndex(opts)
+	}, func(*vectorIndex) error { return nil })
+	if err != nil {
+		return err
+	}
+	if !created {
+		return ErrVectorExists
+	}
+	return nil
+}
+
+// AddVector inserts or replaces an embedding with optional metadata in the
+// vector index at key
+func (c *Cache) AddVector(key, id string, vector []float32, meta map[string]string) error {
+	return updateObject(c, key, nil, func(idx *vectorIndex) error {
+		return idx.Add(id, vector, meta)
+	})
+}
+
+// DeleteVector removes an embedding from the vector index at key
+func (c *Cache) DeleteVector(key, id string) (bool, error) {
+	deleted := false
+	err := updateObject(c, key, nil, func(idx *vectorIndex) error {
+		deleted = idx.Delete(id)
+		return nil
+	})
+	if err == ErrNoSuchKey {
+		return false, nil
+	}
+	return deleted, err
+}
+
+// SearchVectors returns the k nearest neighbors of query in the vector index
+// at key, restricted to embeddings whose metadata matches filter
+func (c *Cache) SearchVectors(key string, query []float32, k, ef int, filter map[string]string) ([]VectorMatch, error) {
+	var matches []VectorMatch
+	exists, err := viewObject(c, key, func(idx *vectorIndex) error {
+		var err error
+		matches, err = idx.Search(query, k, ef, filter)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrNoSuchKey
+	}
+	return matches, nil
+}
+
+// VectorCount returns the number of embeddings in the vector index at key
+func (c *Cache) VectorCount(key string) (int, error) {
+	count := 0
+	_, err := viewObject(c, key, func(idx *vectorIndex) error {
+		count = idx.Len()
+		return nil
+	})
+	return count, err
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "VEC.CREATE", Arity: -4, Flags: FlagWrite, Handler: vecCreateCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "VEC.ADD", Arity: -5, Flags: FlagWrite, Handler: vecAddCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "VEC.SEARCH", Arity: -5, Flags: FlagReadOnly, Handler: vecSearchCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "VEC.DEL", Arity: 3, Flags: FlagWrite, Handler: vecDelCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "VEC.CARD", Arity: 2, Flags: FlagReadOnly, Handler: vecCardCommand, KeyArgs: firstKeyArg},
+	)
+}
+
+// vectorCommandError maps cache errors to replies, keeping those that
+// already carry a reply code
+func vectorCommandError(err error) error {
+	if err == ErrWrongType || err == ErrNoSuchKey || err == ErrOOM {
+		return err
+	}
+	return fmt.Errorf("ERR %v", err)
+}
+
+// vecCreateCommand implements
+// VEC.CREATE key DIM n [METRIC COSINE|L2|IP] [M m] [EF_CONSTRUCTION ef]
+func vecCreateCommand(ctx *CommandContext) error {
+	var opts VectorOptions
+	for i := 2; i < len(ctx.Args); i += 2 {
+		if i+1 >= len(ctx.Args) {
+			return ErrSyntax
+		}
+		value := ctx.Args[i+1]
+		switch strings.ToUpper(string(ctx.Args[i])) {
+		case "DIM":
+			n, err := ParseInt(value)
+			if err != nil {
+				return err
+			}
+			opts.Dim = int(n)
+		case "METRIC":
+			metric, err := ParseVectorMetric(string(value))
+			if err != nil {
+				return fmt.Errorf("ERR %v", err)
+			}
+			opts.Metric = metric
+		case "M":
+			n, err := ParseInt(value)
+			if err != nil {
+				return err
+			}
+			opts.M = int(n)
+		case "EF_CONSTRUCTION":
+			n, err := ParseInt(value)
+			if err != nil {
+				return err
+			}
+			opts.EFConstruction = int(n)
+		default:
+			return ErrSyntax
+		}
+	}
+
+	if err := ctx.Cache.CreateVectorIndex(string(ctx.Args[1]), opts); err != nil {
+		return vectorCommandError(err)
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// parseVectorArg reads VALUES n v1 .. vn or FP32 blob starting at args[i]
+// and returns the vector and the index of the next argument
+func parseVectorArg(args [][]byte, i int) ([]float32, int, error) {
+	if i >= len(args) {
+		return nil, 0, ErrSyntax
+	}
+	switch strings.ToUpper(string(args[i])) {
+	case "VALUES":
+		if i+1 >= len(args) {
+			return nil, 0, ErrSyntax
+		}
+		n, err := ParseInt(args[i+1])
+		if err != nil || n < 1 || int(n) > len(args)-i-2 {
+			return nil, 0, ErrSyntax
+		}
+		vector := make([]float32, n)
+		for j := range vector {
+			f, err := strconv.ParseFloat(string(args[i+2+j]), 32)
+			if err != nil {
+				return nil, 0, errors.New("ERR vector value is not a valid float")
+			}
+			vector[j] = float32(f)
+		}
+		return vector, i + 2 + int(n), nil
+	case "FP32":
+		if i+1 >= len(args) {
+			return nil, 0, ErrSyntax
+		}
+		blob := args[i+1]
+		if len(blob) == 0 || len(blob)%4 != 0 {
+			return nil, 0, errors.New("ERR FP32 blob length must be a multiple of 4")
+		}
+		vector := make([]float32, len(blob)/4)
+		for j := range vector {
+			vector[j] = math.Float32frombits(binary.LittleEndian.Uint32(blob[j*4:]))
+		}
+		return vector, i + 2, nil
+	default:
+		return nil, 0, ErrSyntax
+	}
+}
+
+// parseFieldPairs reads field value pairs until the end of args or the
+// next keyword in stop
+func parseFieldPairs(args [][]byte, i int, stop ...string) (map[string]string, int, error) {
+	fields := make(map[string]string)
+	for i < len(args) {
+		word := strings.ToUpper(string(args[i]))
+		for _, s := range stop {
+			if word == s {
+				return fields, i, nil
+			}
+		}
+		if i+1 >= len(args) {
+			return nil, 0, ErrSyntax
+		}
+		fields[string(args[i])] = string(args[i+1])
+		i += 2
+	}
+	return fields, i, nil
+}
+
+// vecAddCommand implements
+// VEC.ADD key id (VALUES n v1 .. vn | FP32 blob) [META field value ...]
+func vecAddCommand(ctx *CommandContext) error {
+	vector, i, err := parseVectorArg(ctx.Args, 3)
+	if err != nil {
+		return err
+	}
+
+	var meta map[string]string
+	if i < len(ctx.Args) {
+		if !strings.EqualFold(string(ctx.Args[i]), "META") {
+			return ErrSyntax
+		}
+		if meta, _, err = parseFieldPairs(ctx.Args, i+1); err != nil {
+			return err
+		}
+	}
+
+	if err := ctx.Cache.AddVector(string(ctx.Args[1]), string(ctx.Args[2]), vector, meta); err != nil {
+		return vectorCommandError(err)
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// vecSearchCommand implements
+// VEC.SEARCH key k (VALUES n v1 .. vn | FP32 blob) [FILTER field value ...] [EF ef]
+// replying with an id, distance and metadata array per match, closest first
+func vecSearchCommand(ctx *CommandContext) error {
+	k, err := ParseInt(ctx.Args[2])
+	if err != nil || k < 1 {
+		return ErrNotInteger
+	}
+	query, i, err := parseVectorArg(ctx.Args, 3)
+	if err != nil {
+		return err
+	}
+
+	var filter map[string]string
+	ef := 0
+	for i < len(ctx.Args) {
+		switch strings.ToUpper(string(ctx.Args[i])) {
+		case "FILTER":
+			if filter, i, err = parseFieldPairs(ctx.Args, i+1, "EF"); err != nil {
+				return err
+			}
+		case "EF":
+			if i+1 >= len(ctx.Args) {
+				return ErrSyntax
+			}
+			n, err := ParseInt(ctx.Args[i+1])
+			if err != nil || n < 1 {
+				return ErrNotInteger
+			}
+			ef = int(n)
+			i += 2
+		default:
+			return ErrSyntax
+		}
+	}
+
+	matches, err := ctx.Cache.SearchVectors(string(ctx.Args[1]), query, int(k), ef, filter)
+	if err != nil {
+		return vectorCommandError(err)
+	}
+
+	ctx.Out.WriteArrayHeader(len(matches))
+	for _, match := range matches {
+		ctx.Out.WriteArrayHeader(3)
+		ctx.Out.WriteBulkString(match.ID)
+		ctx.Out.WriteBulkString(strconv.FormatFloat(float64(match.Distance), 'g', -1, 32))
+
+		fields := make([]string, 0, len(match.Meta))
+		for field := range match.Meta {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		ctx.Out.WriteArrayHeader(len(fields) * 2)
+		for _, field := range fields {
+			ctx.Out.WriteBulkString(field)
+			ctx.Out.WriteBulkString(match.Meta[field])
+		}
+	}
+	return nil
+}
+
+// vecDelCommand implements VEC.DEL key id
+func vecDelCommand(ctx *CommandContext) error {
+	deleted, err := ctx.Cache.DeleteVector(string(ctx.Args[1]), string(ctx.Args[2]))
+	if err != nil {
+		return vectorCommandError(err)
+	}
+	if deleted {
+		ctx.Out.WriteInteger(1)
+	} else {
+		ctx.Out.WriteInteger(0)
+	}
+	return nil
+}
+
+// vecCardCommand implements VEC.CARD key
+func vecCardCommand(ctx *CommandContext) error {
+	count, err := ctx.Cache.VectorCount(string(ctx.Args[1]))
+	if err != nil {
+		return vectorCommandError(err)
+	}
+	ctx.Out.WriteInteger(int64(count))
+	return nil
+}
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hamisionesmus/distributed-cache/client"
+)
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "NODE.HELLO", Arity: -2, Flags: FlagInternal, Handler: nodeHelloCommand},
+		&Command{Name: "NODE.STATUS", Arity: 1, Flags: FlagInternal, Handler: nodeStatusCommand},
+	)
+}
+
+// ProtocolVersion is the version of the node-to-node protocol spoken by
+// this build. It is raised whenever cluster probes or replication change
+// incompatibly. Version 3 replaced the NODE.HELLO array reply and INFO
+// probes with the messages in clustermsg.go.
+const ProtocolVersion = 3
+
+// MinProtocolVersion is the oldest peer version this build interoperates
+// with. Keeping it one behind ProtocolVersion lets a cluster run mixed
+// N and N-1 versions during a rolling upgrade.
+const MinProtocolVersion = ProtocolVersion - 1
+
+// legacyProtocolVersion is assumed for peers predating NODE.HELLO
+const legacyProtocolVersion = 1
+
+// messageProtocolVersion is the first version exchanging clustermsg.go
+// messages rather than ad-hoc replies
+const messageProtocolVersion = 3
+
+// nodeFeatures lists optional capabilities peers can check before relying
+// on them
+var nodeFeatures = []string{"wal", "restore", "drain", "witness", "replstatus", "gossip"}
+
+// features returns the features this node advertises: nodeFeatures, and
+// "partition" while it partitions keys with its cluster
+func (c *Cache) features() []string {
+	if cl := c.clusterMembership(); cl != nil {
+		return cl.self.Features
+	}
+	return nodeFeatures
+}
+
+// ErrIncompatibleVersion is returned when a peer's protocol version is
+// outside the range this node supports
+var ErrIncompatibleVersion = errors.New("incompatible protocol version")
+
+// PeerVersion is what a peer reported in its handshake
+type PeerVersion struct {
+	NodeID     string   `json:"node_id,omitempty"`
+	Version    int      `json:"version"`
+	MinVersion int      `json:"min_version"`
+	Features   []string `json:"features,omitempty"`
+}
+
+// compatible reports whether each side supports the other's version
+func (v PeerVersion) compatible() error {
+	if v.Version < MinProtocolVersion || ProtocolVersion < v.MinVersion {
+		return fmt.Errorf("%w: peer speaks %d (supports %d to %d), this node speaks %d (supports %d to %d)",
+			ErrIncompatibleVersion, v.Version, v.MinVersion, v.Version, ProtocolVersion, MinProtocolVersion, ProtocolVersion)
+	}
+	return nil
+}
+
+// HasFeature reports whether the peer advertised feature
+func (v PeerVersion) HasFeature(feature string) bool {
+	for _, f := range v.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeHelloCommand implements NODE.HELLO version [node-id], the handshake
+// nodes exchange before probing or replicating to each other. It replies
+// with this node's version, the oldest version it supports, its node ID
+// and its features, or an error if the caller's version is unsupported.
+// Callers from messageProtocolVersion on get a helloMessage, older ones
+// the array reply they understand.
+func nodeHelloCommand(ctx *CommandContext) error {
+	version, err := ParseInt(ctx.Args[1])
+	if err != nil {
+		return err
+	}
+	if version < MinProtocolVersion {
+		return fmt.Errorf("ERR %v: %d is older than the oldest supported version %d, upgrade it first",
+			ErrIncompatibleVersion, version, MinProtocolVersion)
+	}
+
+	if version >= messageProtocolVersion {
+		ctx.Out.WriteBulkString(string(marshalMessage(&helloMessage{
+			NodeID:     ctx.Cache.NodeID(),
+			Version:    ProtocolVersion,
+			MinVersion: MinProtocolVersion,
+			Features:   ctx.Cache.features(),
+		})))
+		return nil
+	}
+	ctx.Out.WriteArrayHeader(4)
+	ctx.Out.WriteInteger(ProtocolVersion)
+	ctx.Out.WriteInteger(MinProtocolVersion)
+	ctx.Out.WriteBulkString(ctx.Cache.NodeID())
+	ctx.Out.WriteStringArray(ctx.Cache.features())
+	return nil
+}
+
+// negotiateVersion performs the NODE.HELLO handshake with a peer. A peer
+// that does not know the command predates versioning and is treated as
+// legacyProtocolVersion.
+func negotiateVersion(ctx context.Context, c *client.Client, nodeID string) (PeerVersion, error) {
+	reply, err := c.Do(ctx, "NODE.HELLO", ProtocolVersion, nodeID)
+	var replyErr client.Error
+	if errors.As(err, &replyErr) {
+		if strings.HasPrefix(string(replyErr), "ERR unknown command") {
+			peer := PeerVersion{Version: legacyProtocolVersion, MinVersion: legacyProtocolVersion}
+			return peer, peer.compatible()
+		}
+		if strings.Contains(string(replyErr), ErrIncompatibleVersion.Error()) {
+			return PeerVersion{}, fmt.Errorf("%w: peer refused handshake: %s", ErrIncompatibleVersion, replyErr)
+		}
+		return PeerVersion{}, fmt.Errorf("peer refused handshake: %s", replyErr)
+	}
+	if err != nil {
+		return PeerVersion{}, err
+	}
+
+	if data, ok := reply.([]byte); ok {
+		var hello helloMessage
+		if err := unmarshalMessage(data, &hello); err != nil {
+			return PeerVersion{}, fmt.Errorf("NODE.HELLO reply: %w", err)
+		}
+		peer := PeerVersion{NodeID: hello.NodeID, Version: hello.Version, MinVersion: hello.MinVersion, Features: hello.Features}
+		return peer, peer.compatible()
+	}
+
+	// Peers before messageProtocolVersion reply with an array
+	items, ok := reply.([]interface{})
+	if !ok || len(items) < 3 {
+		return PeerVersion{}, fmt.Errorf("malformed NODE.HELLO reply %v", reply)
+	}
+	var peer PeerVersion
+	version, ok1 := items[0].(int64)
+	minVersion, ok2 := items[1].(int64)
+	id, _ := items[2].([]byte)
+	if !ok1 || !ok2 {
+		return PeerVersion{}, fmt.Errorf("malformed NODE.HELLO reply %v", reply)
+	}
+	peer.Version, peer.MinVersion, peer.NodeID = int(version), int(minVersion), string(id)
+	if len(items) > 3 {
+		features, _ := items[3].([]interface{})
+		for _, f := range features {
+			if name, ok := f.([]byte); ok {
+				peer.Features = append(peer.Features, string(name))
+			}
+		}
+	}
+	return peer, peer.compatible()
+}
+
+// nodeStatusCommand implements NODE.STATUS, which peers probe instead of
+// parsing INFO. It replies with a nodeStatusMessage.
+func nodeStatusCommand(ctx *CommandContext) error {
+	status := nodeStatusMessage{NodeID: ctx.Cache.NodeID(), Role: RolePrimary}
+	if cl := ctx.Cache.clusterMembership(); cl != nil {
+		status.Role = cl.Role()
+		status.Unreachable = cl.unreachable()
+	}
+	if wal := ctx.Cache.replicationLog(); wal != nil {
+		stats := wal.Stats()
+		status.ReplOffset, status.ReplFirstOffset = stats.Seq, stats.FirstSeq
+	}
+	ctx.Out.WriteBulkString(string(marshalMessage(&status)))
+	return nil
+}
@@ -0,0 +1,107 @@
+package cache
+
+import "time"
+
+// A View lets code embedding the cache, such as analytics jobs, read a
+// namespace at leisure without holding the cache's locks against clients.
+// Taking one copies the namespace's entry headers under a single hold of
+// every shard's read lock; values are shared rather than copied, since
+// writes replace them instead of changing them in place. The view never
+// changes afterwards: later writes, deletes and expirations do not show in
+// it.
+
+// ViewEntry is a key of a View
+type ViewEntry struct {
+	// Value is the string value, nil for other types
+	Value []byte
+	Type  string
+	Tags  []string
+	// ExpiresAt is zero for keys without a TTL
+	ExpiresAt   time.Time
+	Pinned      bool
+	AccessCount int64
+}
+
+// View is an immutable copy of a namespace. It is safe for concurrent use.
+type View struct {
+	namespace string
+	taken     time.Time
+	keys      []string
+	entries   map[string]ViewEntry
+}
+
+// Snapshot returns a read-only view of the keys in namespace, or of the
+// whole keyspace when namespace is empty. Keys are visited in namespace
+// order, the order KEYSPREFIX and exports use.
+func (c *Cache) Snapshot(namespace string) *View {
+	prefix := ""
+	if namespace != "" {
+		prefix = namespace + NamespaceSeparator
+	}
+
+	c.rlockAll()
+	defer c.runlockAll()
+
+	now := c.now()
+	v := &View{namespace: namespace, taken: now, entries: make(map[string]ViewEntry)}
+	c.walkPrefix(prefix, func(key string) bool {
+		entry := c.storedEntry(key)
+		if entry == nil || entry.isExpired(now) {
+			return true
+		}
+		ve := ViewEntry{
+			Value:       entry.Value,
+			Type:        entry.typeName(),
+			Tags:        entry.Tags,
+			Pinned:      entry.Pinned,
+			AccessCount: entry.AccessCount,
+		}
+		if entry.ExpiresAt != nil {
+			ve.ExpiresAt = *entry.ExpiresAt
+		}
+		v.keys = append(v.keys, key)
+		v.entries[key] = ve
+		return true
+	})
+	return v
+}
+
+// Namespace returns the namespace the view was taken of
+func (v *View) Namespace() string {
+	return v.namespace
+}
+
+// Taken returns when the view was taken
+func (v *View) Taken() time.Time {
+	return v.taken
+}
+
+// Len returns the number of keys in the view
+func (v *View) Len() int {
+	return len(v.keys)
+}
+
+// Get returns the string value at key as of the view
+func (v *View) Get(key string) ([]byte, bool) {
+	entry, ok := v.entries[key]
+	if !ok || entry.Value == nil {
+		return nil, false
+	}
+	return entry.Value, true
+}
+
+// Entry returns the key's entry as of the view
+func (v *View) Entry(key string) (ViewEntry, bool) {
+	entry, ok := v.entries[key]
+	return entry, ok
+}
+
+// Range calls fn for each key in order until fn returns false. Callers
+// must not modify the values and tags they are given.
+func (v *View) Range(fn func(key string, entry ViewEntry) bool) {
+	for _, key := range v.keys {
+		if !fn(key, v.entries[key]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"math/rand"
+	"time"
+)
+
+// volatileSampleRounds bounds how many samples volatile-ttl draws looking
+// for entries with a TTL, as a multiple of its sample size
+const volatileSampleRounds = 8
+
+// volatileTTLPolicy evicts the entry closest to expiring, the way Redis's
+// volatile-ttl does: it samples random entries and picks the one with the
+// earliest expiry. Entries without a TTL are never evicted, so a cache
+// holding none with a TTL stays over its limit rather than lose data
+// meant to be kept.
+type volatileTTLPolicy struct {
+	entries []*CacheEntry
+	samples int
+	rng     *rand.Rand
+}
+
+func newVolatileTTLPolicy(samples int) *volatileTTLPolicy {
+	if samples <= 0 {
+		samples = defaultEvictionSamples
+	}
+	return &volatileTTLPolicy{
+		samples: samples,
+		rng:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+func (p *volatileTTLPolicy) Add(entry *CacheEntry) {
+	entry.slot = len(p.entries)
+	p.entries = append(p.entries, entry)
+}
+
+func (p *volatileTTLPolicy) Access(entry *CacheEntry) {}
+
+func (p *volatileTTLPolicy) Remove(entry *CacheEntry) {
+	last := len(p.entries) - 1
+	moved := p.entries[last]
+	p.entries[entry.slot] = moved
+	moved.slot = entry.slot
+	p.entries[last] = nil
+	p.entries = p.entries[:last]
+}
+
+func (p *volatileTTLPolicy) Victim() *CacheEntry {
+	var victim *CacheEntry
+	found := 0
+	for i := 0; i < p.samples*volatileSampleRounds && found < p.samples && len(p.entries) > 0; i++ {
+		candidate := p.entries[p.rng.Intn(len(p.entries))]
+		if candidate.ExpiresAt == nil {
+			continue
+		}
+		found++
+		if victim == nil || candidate.ExpiresAt.Before(*victim.ExpiresAt) {
+			victim = candidate
+		}
+	}
+	return victim
+}
+
+func (p *volatileTTLPolicy) Len() int {
+	return len(p.entries)
+}
+
+func (p *volatileTTLPolicy) Reset() {
+	p.entries = nil
+}
@@ -0,0 +1,311 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"slices"
+	"sync"
+)
+
+// defaultWALBacklog is the replication backlog kept when none is configured
+const defaultWALBacklog = 1024 * 1024 // 1MB
+
+// walRecordOverhead approximates the per-record and per-argument framing
+// charged against the backlog
+const walRecordOverhead = 32
+
+// walOrderStripes is how many locks order writes by their keys
+const walOrderStripes = 64
+
+// ErrWALTruncated is returned when a reader asks for records that have
+// already left the backlog and must resynchronize from a snapshot
+var ErrWALTruncated = errors.New("requested offset is no longer in the replication backlog")
+
+// WALRecord is one write command in the log. Seq numbers start at 1 and
+// increase by one per record, so a consumer's position is the Seq of the
+// last record it applied.
+type WALRecord struct {
+	Seq  uint64
+	Args [][]byte
+}
+
+// size is the record's charge against the backlog
+func (r WALRecord) size() int64 {
+	n := int64(walRecordOverhead)
+	for _, arg := range r.Args {
+		n += int64(len(arg)) + walRecordOverhead
+	}
+	return n
+}
+
+// walSink consumes records synchronously, in order, as they are logged.
+// The AOF is one; its errors are its own to report because the command has
+// already been applied.
+type walSink interface {
+	appendRecord(rec WALRecord)
+}
+
+// WALStats describes the log's position and backlog
+type WALStats struct {
+	// Seq is the sequence number of the last record logged
+	Seq uint64 `json:"seq"`
+	// FirstSeq is the oldest record still in the backlog, zero when empty
+	FirstSeq     uint64 `json:"first_seq"`
+	BacklogBytes int64  `json:"backlog_bytes"`
+	BacklogLimit int64  `json:"backlog_limit"`
+}
+
+// WAL is the ordered log of write commands applied to a cache. It assigns
+// each command a sequence number, hands it to synchronous sinks such as
+// the AOF, and keeps a bounded backlog that replicas read from by offset,
+// so persistence and replication are independent of each other.
+//
+// Writes to the same keys are logged in the order they took effect, as
+// each holds the order stripes of its keys from applying until it has a
+// sequence number. Writes to other keys apply concurrently, and mu is held
+// only to number a record and add it to the backlog. Records reach the
+// sinks in sequence order under sinkMu, before the write that logged them
+// returns. Locks are taken in the order stripes, sinkMu, mu.
+type WAL struct {
+	order [walOrderStripes]sync.Mutex
+	seed  maphash.Seed
+
+	// sinkMu serializes handing records to the sinks
+	sinkMu sync.Mutex
+	sinks  []walSink
+
+	mu  sync.Mutex
+	seq uint64
+	// pending are the records logged but not yet handed to the sinks
+	pending []WALRecord
+	// backlog[head:] holds the retained records
+	backlog []WALRecord
+	head    int
+	bytes   int64
+	limit   int64
+	// changed is closed and replaced whenever a record is logged
+	changed chan struct{}
+}
+
+// NewWAL creates a log keeping up to backlogBytes of recent records
+func NewWAL(backlogBytes int64) *WAL {
+	if backlogBytes <= 0 {
+		backlogBytes = defaultWALBacklog
+	}
+	return &WAL{limit: backlogBytes, seed: maphash.MakeSeed(), changed: make(chan struct{})}
+}
+
+// stripes returns the sorted order stripes of keys, every stripe if there
+// are none, as for writes whose keys are not known
+func (w *WAL) stripes(keys []string) []int {
+	if len(keys) == 0 {
+		all := make([]int, walOrderStripes)
+		for i := range all {
+			all[i] = i
+		}
+		return all
+	}
+	stripes := make([]int, len(keys))
+	for i, key := range keys {
+		stripes[i] = int(maphash.String(w.seed, key) % walOrderStripes)
+	}
+	slices.Sort(stripes)
+	return slices.Compact(stripes)
+}
+
+func (w *WAL) lockOrder(stripes []int) {
+	for _, i := range stripes {
+		w.order[i].Lock()
+	}
+}
+
+func (w *WAL) unlockOrder(stripes []int) {
+	for _, i := range stripes {
+		w.order[i].Unlock()
+	}
+}
+
+// addSink registers a synchronous consumer of records logged from now on
+func (w *WAL) addSink(sink walSink) {
+	stripes := w.stripes(nil)
+	w.lockOrder(stripes)
+	defer w.unlockOrder(stripes)
+
+	w.deliver()
+	w.sinkMu.Lock()
+	w.sinks = append(w.sinks, sink)
+	w.sinkMu.Unlock()
+}
+
+// barrier runs fn while no command is being applied or logged, and every
+// record has reached the sinks, so fn sees the cache exactly as of the
+// last record logged
+func (w *WAL) barrier(fn func()) {
+	stripes := w.stripes(nil)
+	w.lockOrder(stripes)
+	defer w.unlockOrder(stripes)
+
+	w.deliver()
+	fn()
+}
+
+// log runs apply and, if it succeeds, logs args. keys are those the
+// command writes, nil if they are not known.
+func (w *WAL) log(keys []string, args [][]byte, apply func() error) error {
+	return w.logApplied(keys, func() ([][]byte, error) {
+		return args, apply()
+	})
+}
+
+// logApplied runs apply and logs the command it returns, or nothing if it
+// fails or returns nil, for writes only known once they have run. keys
+// are those the write may change, nil if they are not known, in which
+// case it is ordered against every other write.
+func (w *WAL) logApplied(keys []string, apply func() ([][]byte, error)) error {
+	stripes := w.stripes(keys)
+	w.lockOrder(stripes)
+	args, err := apply()
+	if err != nil || args == nil {
+		w.unlockOrder(stripes)
+		return err
+	}
+	w.append(args)
+	w.unlockOrder(stripes)
+
+	w.deliver()
+	return nil
+}
+
+// append numbers a record of args and adds it to the backlog and the
+// records pending delivery to the sinks
+func (w *WAL) append(args [][]byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.seq++
+	rec := WALRecord{Seq: w.seq, Args: args}
+	w.pending = append(w.pending, rec)
+
+	w.backlog = append(w.backlog, rec)
+	w.bytes += rec.size()
+	for w.bytes > w.limit && len(w.backlog)-w.head > 1 {
+		w.bytes -= w.backlog[w.head].size()
+		w.backlog[w.head] = WALRecord{}
+		w.head++
+	}
+	if w.head > len(w.backlog)/2 {
+		w.backlog = append(w.backlog[:0], w.backlog[w.head:]...)
+		w.head = 0
+	}
+
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// deliver hands the pending records to the sinks in order. A record may be
+// delivered by a later write that took sinkMu first; its own write then
+// waits here until that is done.
+func (w *WAL) deliver() {
+	w.sinkMu.Lock()
+	defer w.sinkMu.Unlock()
+
+	w.mu.Lock()
+	pending := w.pending
+	w.pending = nil
+	w.mu.Unlock()
+
+	for _, rec := range pending {
+		for _, sink := range w.sinks {
+			sink.appendRecord(rec)
+		}
+	}
+}
+
+// ReadFrom returns up to max records after seq, the position of a
+// consumer that has applied everything up to and including seq. It
+// returns ErrWALTruncated when records after seq have been dropped.
+func (w *WAL) ReadFrom(seq uint64, max int) ([]WALRecord, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if seq >= w.seq {
+		return nil, nil
+	}
+	backlog := w.backlog[w.head:]
+	if len(backlog) == 0 || seq+1 < backlog[0].Seq {
+		return nil, ErrWALTruncated
+	}
+	start := int(seq + 1 - backlog[0].Seq)
+	end := len(backlog)
+	if max > 0 && end-start > max {
+		end = start + max
+	}
+	return append([]WALRecord(nil), backlog[start:end]...), nil
+}
+
+// Wait blocks until a record after seq has been logged or ctx is done
+func (w *WAL) Wait(ctx context.Context, seq uint64) error {
+	for {
+		w.mu.Lock()
+		if w.seq > seq {
+			w.mu.Unlock()
+			return nil
+		}
+		changed := w.changed
+		w.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Stats returns the log's position and backlog usage
+func (w *WAL) Stats() WALStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stats := WALStats{Seq: w.seq, BacklogBytes: w.bytes, BacklogLimit: w.limit}
+	if w.head < len(w.backlog) {
+		stats.FirstSeq = w.backlog[w.head].Seq
+	}
+	return stats
+}
+
+// EnableWAL starts logging write commands with a backlog of backlogBytes.
+// It returns the existing log if there is one.
+func (c *Cache) EnableWAL(backlogBytes int64) *WAL {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.wal.Load() == nil {
+		c.wal.Store(NewWAL(backlogBytes))
+	}
+	return c.wal.Load()
+}
+
+// replicationLog returns the log write commands go through, if enabled
+func (c *Cache) replicationLog() *WAL {
+	return c.wal.Load()
+}
+
+// commandLoggedKey marks the context of a command the dispatcher logs as
+// sent, so the Cache methods it calls do not log their part of it again
+type commandLoggedKey struct{}
+
+// logWrite runs apply and logs the command it returns in place of the one
+// that ran. It logs the writes of FlagSelfLogged commands and of the
+// exported Cache methods, so writes from the HTTP API reach the WAL as
+// RESP ones do. Nothing is logged if apply fails or returns nil, or if ctx
+// is that of a command logged as sent or replayed from the AOF. keys are
+// those the write may change, nil if they are not known.
+func (c *Cache) logWrite(ctx context.Context, keys []string, apply func() ([][]byte, error)) error {
+	if wal := c.replicationLog(); wal != nil && ctx.Value(commandLoggedKey{}) == nil {
+		return wal.logApplied(keys, apply)
+	}
+	_, err := apply()
+	return err
+}
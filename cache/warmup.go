@@ -0,0 +1,265 @@
+package cache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A warm-up loads the snapshots in the storage path in the background while
+// the node already serves, hottest keys first, so a restart does not open
+// with a storm of misses on the keys clients use most. Pinned keys load
+// first, then the rest by the access count persisted with them, in small
+// batches so requests interleave with the load. Until it finishes the node
+// reports itself warming in /readyz and INFO, and keys not loaded yet read
+// as misses.
+//
+// A key clients write or delete during the warm-up keeps their change and
+// the snapshot's copy is skipped. Once the cache is full the remaining,
+// colder keys are dropped rather than evicting the hot keys loaded before
+// them.
+
+// warmupBatch is how many keys are loaded per hold of their shards' locks
+const warmupBatch = 256
+
+// WarmupStatus reports the progress of a warm-up
+type WarmupStatus struct {
+	Warming bool      `json:"warming"`
+	Started time.Time `json:"started"`
+	// Total is the number of keys in the snapshots, known once they have
+	// been read
+	Total  int `json:"total"`
+	Loaded int `json:"loaded"`
+	// Skipped keys had expired or were changed by clients during the
+	// warm-up; Dropped keys did not fit in the cache
+	Skipped  int           `json:"skipped"`
+	Dropped  int           `json:"dropped"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// warmup is the state of the last warm-up. Writers take mu after the lock
+// of the key's shard.
+type warmup struct {
+	mu     sync.Mutex
+	status WarmupStatus
+	// written holds the keys clients changed while warming, and tracking
+	// lets writes skip mu once the warm-up is over
+	written  map[string]struct{}
+	tracking atomic.Bool
+}
+
+// StartWarmup loads the snapshots in cfg.Path in the background, as
+// LoadSnapshots does but hottest keys first, and returns a channel that
+// receives the outcome once loading ends. Loading stops with ctx's error
+// once ctx is done.
+func (c *Cache) StartWarmup(ctx context.Context, cfg StorageConfig) <-chan error {
+	w := &warmup{
+		status:  WarmupStatus{Warming: true, Started: time.Now()},
+		written: make(map[string]struct{}),
+	}
+	w.tracking.Store(true)
+	c.warmup.Store(w)
+
+	done := make(chan error, 1)
+	go func() {
+		err := c.warm(ctx, cfg, w)
+
+		w.tracking.Store(false)
+		w.mu.Lock()
+		w.status.Warming = false
+		w.status.Duration = time.Since(w.status.Started)
+		if err != nil {
+			w.status.Error = err.Error()
+		}
+		w.written = nil
+		w.mu.Unlock()
+		done <- err
+	}()
+	return done
+}
+
+// warm reads the full and incremental snapshots and loads their merged
+// keys in order of heat
+func (c *Cache) warm(ctx context.Context, cfg StorageConfig, w *warmup) error {
+	opts := SnapshotLoadOptions{SkipChecksum: cfg.SkipChecksum}
+
+	full, data, err := loadSnapshotFile(filepath.Join(cfg.Path, snapshotFileName), opts)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entries := data.entries
+	var changed map[string]struct{}
+	incremental, delta, err := loadSnapshotFile(filepath.Join(cfg.Path, incrementalSnapshotFileName), opts)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	case incremental.Base.Equal(full.Created):
+		entries, changed = mergeIncremental(entries, delta)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].pinned != entries[j].pinned {
+			return entries[i].pinned
+		}
+		return entries[i].hits > entries[j].hits
+	})
+
+	// Changes are tracked against the full snapshot as after LoadSnapshots:
+	// the incremental snapshot's keys and those clients have changed so far
+	// are dirty, the keys loaded from the full snapshot are not
+	c.lockAll()
+	w.mu.Lock()
+	c.resetDirtyKeys(full.Created)
+	for key := range changed {
+		c.shardFor(key).dirtyKeys[key] = struct{}{}
+	}
+	for key := range w.written {
+		c.shardFor(key).dirtyKeys[key] = struct{}{}
+	}
+	w.status.Total = len(entries)
+	w.mu.Unlock()
+	c.unlockAll()
+
+	for start := 0; start < len(entries); start += warmupBatch {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := start + warmupBatch
+		if end > len(entries) {
+			end = len(entries)
+		}
+		if !c.loadWarmBatch(w, entries[start:end], changed) {
+			w.mu.Lock()
+			w.status.Dropped = w.status.Total - w.status.Loaded - w.status.Skipped
+			w.mu.Unlock()
+			break
+		}
+	}
+	return nil
+}
+
+// loadWarmBatch loads one batch of a warm-up, reporting false once the
+// cache is full
+func (c *Cache) loadWarmBatch(w *warmup, batch []snapshotEntry, changed map[string]struct{}) bool {
+	keys := make([]string, len(batch))
+	for i, se := range batch {
+		keys[i] = se.key
+	}
+	defer unlockShards(c.lockKeys(keys...))
+
+	loaded, skipped := 0, 0
+	defer func() {
+		w.mu.Lock()
+		w.status.Loaded += loaded
+		w.status.Skipped += skipped
+		w.mu.Unlock()
+	}()
+
+	now := time.Now()
+	for _, se := range batch {
+		if w.clientWrote(se.key) {
+			skipped++
+			continue
+		}
+		entry := se.cacheEntry(now)
+		if entry == nil {
+			skipped++
+			continue
+		}
+		if !c.warmupFits(entry) {
+			return false
+		}
+		s := c.shardFor(se.key)
+		entry.Pinned = se.pinned && c.pinFits(entry.memoryUsage())
+		s.insertEntry(entry)
+		// The insert noted itself as a client write
+		w.mu.Lock()
+		delete(w.written, se.key)
+		w.mu.Unlock()
+		if _, ok := changed[se.key]; !ok {
+			delete(s.dirtyKeys, se.key)
+		}
+		loaded++
+	}
+	return true
+}
+
+// clientWrote reports whether clients changed key while warming
+func (w *warmup) clientWrote(key string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, ok := w.written[key]
+	return ok
+}
+
+// warmupFits reports whether entry can be loaded without evicting
+func (c *Cache) warmupFits(entry *CacheEntry) bool {
+	if c.currentSize.Load() >= int64(c.maxSize) {
+		return false
+	}
+	cost := entry.Cost
+	if cost <= 0 {
+		cost = entry.memoryUsage()
+	}
+	return c.maxCost <= 0 || c.totalCost.Load()+cost <= c.maxCost
+}
+
+// noteWarmupWrite records a change clients made to key while warming, so
+// the snapshot's older copy is not loaded over it. Callers hold the write
+// lock of key's shard.
+func (c *Cache) noteWarmupWrite(key string) {
+	w := c.warmup.Load()
+	if w == nil || !w.tracking.Load() {
+		return
+	}
+	w.mu.Lock()
+	if w.written != nil {
+		w.written[key] = struct{}{}
+	}
+	w.mu.Unlock()
+}
+
+// mergeIncremental applies an incremental snapshot to the entries of its
+// full snapshot, returning the merged entries and the keys it changed
+func mergeIncremental(entries []snapshotEntry, delta snapshotData) ([]snapshotEntry, map[string]struct{}) {
+	changed := make(map[string]struct{}, len(delta.entries)+len(delta.deletes))
+	for _, key := range delta.deletes {
+		changed[key] = struct{}{}
+	}
+	for _, se := range delta.entries {
+		changed[se.key] = struct{}{}
+	}
+
+	merged := make([]snapshotEntry, 0, len(entries)+len(delta.entries))
+	for _, se := range entries {
+		if _, ok := changed[se.key]; !ok {
+			merged = append(merged, se)
+		}
+	}
+	return append(merged, delta.entries...), changed
+}
+
+// Warmup returns the status of the running or last warm-up, reporting
+// false if none was started
+func (c *Cache) Warmup() (WarmupStatus, bool) {
+	w := c.warmup.Load()
+	if w == nil {
+		return WarmupStatus{}, false
+	}
+	w.mu.Lock()
+	status := w.status
+	w.mu.Unlock()
+	if status.Warming {
+		status.Duration = time.Since(status.Started)
+	}
+	return status, true
+}
@@ -0,0 +1,215 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Key webhook retry tuning
+const (
+	keyWebhookMaxAttempts = 5
+	keyWebhookRetryMin    = time.Second
+	keyWebhookRetryMax    = 30 * time.Second
+)
+
+// webhookContentType is the content type of the JSON every webhook posts
+const webhookContentType = "application/json"
+
+// keyWebhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of
+// the request body under the webhook's secret
+const keyWebhookSignatureHeader = "X-Cache-Signature"
+
+// KeyWebhookConfig sends changes to keys starting with Prefix to URL
+type KeyWebhookConfig struct {
+	URL    string `json:"url" toml:"url" yaml:"url"`
+	Prefix string `json:"prefix" toml:"prefix" yaml:"prefix"`
+	// Events limits the webhook to these event types; empty means all
+	Events []string `json:"events" toml:"events" yaml:"events"`
+	// Secret signs request bodies when set
+	Secret string `json:"secret" toml:"secret" yaml:"secret"`
+}
+
+// Validate checks the webhook's URL and event types
+func (cfg KeyWebhookConfig) Validate() error {
+	if cfg.URL == "" {
+		return fmt.Errorf("webhook URL required")
+	}
+	for _, name := range cfg.Events {
+		if _, err := ParseKeyEventType(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseKeyEventType validates a keyspace event type name
+func ParseKeyEventType(name string) (KeyEventType, error) {
+	switch eventType := KeyEventType(name); eventType {
+	case KeyEventSet, KeyEventDelete, KeyEventExpired, KeyEventEvicted, KeyEventFlush:
+		return eventType, nil
+	default:
+		return "", fmt.Errorf("unknown key event type: %s", name)
+	}
+}
+
+// KeyWebhook posts keyspace events for a prefix as JSON arrays to an HTTP
+// endpoint, batching them like the removal webhook. A failed batch is
+// retried with exponential backoff and dropped after
+// keyWebhookMaxAttempts; events are also lost if the endpoint falls so far
+// behind that the subscription overflows.
+type KeyWebhook struct {
+	cfg    KeyWebhookConfig
+	events map[KeyEventType]bool
+	client *http.Client
+	logger *log.Logger
+
+	sub    *KeyEventSubscription
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartKeyWebhook starts posting changes from c as described by cfg
+func StartKeyWebhook(c *Cache, cfg KeyWebhookConfig, logger *log.Logger) *KeyWebhook {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &KeyWebhook{
+		cfg:    cfg,
+		events: make(map[KeyEventType]bool),
+		client: &http.Client{Timeout: webhookTimeout},
+		logger: logger,
+		sub:    c.Subscribe(cfg.Prefix),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	for _, name := range cfg.Events {
+		w.events[KeyEventType(name)] = true
+	}
+
+	go w.run(ctx)
+	return w
+}
+
+// StartKeyWebhooks starts a webhook for each entry of configs and returns a
+// function stopping them all
+func StartKeyWebhooks(c *Cache, configs []KeyWebhookConfig, logger *log.Logger) func() {
+	hooks := make([]*KeyWebhook, len(configs))
+	for i, cfg := range configs {
+		hooks[i] = StartKeyWebhook(c, cfg, logger)
+	}
+	return func() {
+		for _, hook := range hooks {
+			hook.Close()
+		}
+	}
+}
+
+// Close stops the webhook after attempting to send queued events once
+func (w *KeyWebhook) Close() {
+	w.cancel()
+	<-w.done
+}
+
+func (w *KeyWebhook) run(ctx context.Context) {
+	defer close(w.done)
+	defer w.sub.Close()
+
+	ticker := time.NewTicker(webhookFlushInterval)
+	defer ticker.Stop()
+
+	var reported int64
+	batch := make([]KeyEvent, 0, webhookBatchSize)
+	flush := func(ctx context.Context) {
+		if dropped := w.sub.Dropped(); dropped > reported {
+			w.logger.Printf("Key webhook %s fell behind, dropped %d events", w.cfg.URL, dropped-reported)
+			reported = dropped
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := w.deliver(ctx, batch); err != nil {
+			w.logger.Printf("Key webhook %s failed, dropped %d events: %v", w.cfg.URL, len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			flush(context.Background())
+			return
+		case event := <-w.sub.C:
+			if len(w.events) > 0 && !w.events[event.Type] {
+				continue
+			}
+			batch = append(batch, event)
+			if len(batch) >= webhookBatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		}
+	}
+}
+
+// deliver posts batch, retrying with exponential backoff until it is
+// accepted, ctx is done or the attempts run out
+func (w *KeyWebhook) deliver(ctx context.Context, batch []KeyEvent) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	backoff := keyWebhookRetryMin
+	for attempt := 1; ; attempt++ {
+		err = w.post(ctx, body)
+		if err == nil || attempt == keyWebhookMaxAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > keyWebhookRetryMax {
+			backoff = keyWebhookRetryMax
+		}
+	}
+}
+
+func (w *KeyWebhook) post(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", webhookContentType)
+	if w.cfg.Secret != "" {
+		req.Header.Set(keyWebhookSignatureHeader, "sha256="+signWebhookBody(w.cfg.Secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// signWebhookBody returns the hex HMAC-SHA256 of body under secret
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,657 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// A sorted set orders distinct members by score, then by member, as in
+// Redis. Members are kept in a map for score lookups and in a skip list
+// whose links record how many members they span, so rank lookups and
+// range reads by rank take logarithmic time.
+
+const (
+	zslMaxLevel = 32
+	// zslP is the chance a node rises to each next level
+	zslP = 0.25
+)
+
+var errNotFloat = errors.New("ERR value is not a valid float")
+
+// ScoredMember is a member of a sorted set and its score
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// ZAddOptions are the conditions of ZADD
+type ZAddOptions struct {
+	// NX only adds new members, XX only updates existing ones
+	NX, XX bool
+	// GT and LT only update a score to a greater or lesser one
+	GT, LT bool
+}
+
+type zslNode struct {
+	member   string
+	score    float64
+	backward *zslNode
+	level    []zslLevel
+}
+
+type zslLevel struct {
+	forward *zslNode
+	// span is how many nodes forward skips, counting the one it lands on
+	span int
+}
+
+type skiplist struct {
+	header *zslNode
+	tail   *zslNode
+	length int
+	level  int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{header: &zslNode{level: make([]zslLevel, zslMaxLevel)}, level: 1}
+}
+
+// before reports whether n sorts before score and member
+func (n *zslNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < zslMaxLevel && rand.Float64() < zslP {
+		level++
+	}
+	return level
+}
+
+// insert adds a member that is not in the list
+func (zsl *skiplist) insert(score float64, member string) {
+	var update [zslMaxLevel]*zslNode
+	var rank [zslMaxLevel]int
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+	x = &zslNode{member: member, score: score, level: make([]zslLevel, level)}
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+}
+
+// delete removes a member, reporting whether it was in the list
+func (zsl *skiplist) delete(score float64, member string) bool {
+	var update [zslMaxLevel]*zslNode
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+	return true
+}
+
+// rank returns the 0-based position of a member in the list, or -1
+func (zsl *skiplist) rank(score float64, member string) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !(score < x.level[i].forward.score ||
+			(score == x.level[i].forward.score && member < x.level[i].forward.member)) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x != zsl.header && x.member == member {
+			return rank - 1
+		}
+	}
+	return -1
+}
+
+// byRank returns the node at a 0-based position, or nil
+func (zsl *skiplist) byRank(rank int) *zslNode {
+	target, traversed := rank+1, 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= target {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == target {
+			return x
+		}
+	}
+	return nil
+}
+
+// sortedSet is a set of members ordered by score
+type sortedSet struct {
+	scores map[string]float64
+	zsl    *skiplist
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{scores: make(map[string]float64), zsl: newSkiplist()}
+}
+
+// TypeName implements cacheObject
+func (z *sortedSet) TypeName() string {
+	return "zset"
+}
+
+// memoryUsage implements memorySizer
+func (z *sortedSet) memoryUsage() int64 {
+	n := int64(unsafe.Sizeof(*z)) + allocSize(int64(unsafe.Sizeof(*z.zsl)))
+	n += allocSize(zslMaxLevel * int64(unsafe.Sizeof(zslLevel{})))
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		// The member string is shared by the map and the node
+		n += mapSlotSize + allocSize(int64(len(x.member))) + allocSize(int64(unsafe.Sizeof(*x))) +
+			allocSize(int64(len(x.level))*int64(unsafe.Sizeof(zslLevel{})))
+	}
+	return n
+}
+
+// rewriteCommands implements aofRewriter
+func (z *sortedSet) rewriteCommands(key string) [][][]byte {
+	var commands [][][]byte
+	args := [][]byte{[]byte("ZADD"), []byte(key)}
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		args = append(args, []byte(formatScore(x.score)), []byte(x.member))
+		if len(args)-2 == 2*aofRewriteBatch {
+			commands = append(commands, args)
+			args = [][]byte{[]byte("ZADD"), []byte(key)}
+		}
+	}
+	if len(args) > 2 {
+		commands = append(commands, args)
+	}
+	return commands
+}
+
+// set gives member score, reporting whether it was added or its score
+// changed
+func (z *sortedSet) set(member string, score float64) (added, changed bool) {
+	old, exists := z.scores[member]
+	if exists {
+		if old == score {
+			return false, false
+		}
+		z.zsl.delete(old, member)
+	}
+	z.zsl.insert(score, member)
+	z.scores[member] = score
+	return !exists, exists
+}
+
+// remove deletes member, reporting whether it was in the set
+func (z *sortedSet) remove(member string) bool {
+	score, ok := z.scores[member]
+	if !ok {
+		return false
+	}
+	z.zsl.delete(score, member)
+	delete(z.scores, member)
+	return true
+}
+
+// rank returns the 0-based position of member from the lowest score, or
+// from the highest if rev is set
+func (z *sortedSet) rank(member string, rev bool) (int, bool) {
+	score, ok := z.scores[member]
+	if !ok {
+		return 0, false
+	}
+	rank := z.zsl.rank(score, member)
+	if rev {
+		rank = z.zsl.length - 1 - rank
+	}
+	return rank, true
+}
+
+// rangeByRank returns the members from position start to stop inclusive,
+// counted from the highest score if rev is set. Negative positions count
+// from the end.
+func (z *sortedSet) rangeByRank(start, stop int, rev bool) []ScoredMember {
+	n := z.zsl.length
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	if start > stop || start >= n {
+		return []ScoredMember{}
+	}
+
+	members := make([]ScoredMember, 0, stop-start+1)
+	var x *zslNode
+	if rev {
+		x = z.zsl.byRank(n - 1 - start)
+	} else {
+		x = z.zsl.byRank(start)
+	}
+	for i := start; i <= stop && x != nil; i++ {
+		members = append(members, ScoredMember{Member: x.member, Score: x.score})
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+	return members
+}
+
+// ZAdd sets the scores of members of the sorted set at key, creating it
+// if needed, subject to opts. It returns how many members were added, and
+// how many were added or changed.
+func (c *Cache) ZAdd(ctx context.Context, key string, members []ScoredMember, opts ZAddOptions) (added, changed int, err error) {
+	if opts.NX && (opts.XX || opts.GT || opts.LT) || opts.GT && opts.LT {
+		return 0, 0, errors.New("GT, LT and NX options at the same time are not compatible")
+	}
+	create := func() (*sortedSet, error) { return newSortedSet(), nil }
+	if opts.XX {
+		create = nil
+	}
+	err = c.logWrite(ctx, []string{key}, func() ([][]byte, error) {
+		// Only the members that changed are logged, with their new scores
+		args := [][]byte{[]byte("ZADD"), []byte(key)}
+		err := updateObject(c, key, create, func(z *sortedSet) error {
+			for _, m := range members {
+				old, exists := z.scores[m.Member]
+				if exists && (opts.NX || opts.GT && m.Score <= old || opts.LT && m.Score >= old) ||
+					!exists && opts.XX {
+					continue
+				}
+				a, ch := z.set(m.Member, m.Score)
+				if a {
+					added++
+				}
+				if a || ch {
+					changed++
+					args = append(args, []byte(formatScore(m.Score)), []byte(m.Member))
+				}
+			}
+			return nil
+		})
+		if err != nil || changed == 0 {
+			return nil, err
+		}
+		return args, nil
+	})
+	if err == ErrNoSuchKey {
+		err = nil
+	}
+	return added, changed, err
+}
+
+// ZIncrBy adds incr to the score of member of the sorted set at key, which
+// starts from zero, and returns the new score
+func (c *Cache) ZIncrBy(ctx context.Context, key, member string, incr float64) (float64, error) {
+	var score float64
+	err := c.logWrite(ctx, []string{key}, func() ([][]byte, error) {
+		err := updateObject(c, key, func() (*sortedSet, error) { return newSortedSet(), nil }, func(z *sortedSet) error {
+			score = z.scores[member] + incr
+			if math.IsNaN(score) {
+				return errors.New("resulting score is not a number (NaN)")
+			}
+			z.set(member, score)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{[]byte("ZADD"), []byte(key), []byte(formatScore(score)), []byte(member)}, nil
+	})
+	return score, err
+}
+
+// ZRem removes members from the sorted set at key, deleting it once empty,
+// and returns how many were members
+func (c *Cache) ZRem(ctx context.Context, key string, members ...string) (int, error) {
+	removed := 0
+	err := c.logWrite(ctx, []string{key}, func() ([][]byte, error) {
+		s := c.shardFor(key)
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+
+		z, entry, err := liveObject[*sortedSet](s, key)
+		if err != nil || entry == nil {
+			return nil, err
+		}
+		args := [][]byte{[]byte("ZREM"), []byte(key)}
+		for _, member := range members {
+			if z.remove(member) {
+				removed++
+				args = append(args, []byte(member))
+			}
+		}
+		if len(z.scores) == 0 {
+			s.dropEntry(entry, RemovalDeleted)
+		} else if removed > 0 {
+			s.touchEntry(entry)
+			s.recharge(entry)
+			c.notifier.publish(KeyEventSet, key)
+		}
+		if removed == 0 {
+			return nil, nil
+		}
+		return args, nil
+	})
+	return removed, err
+}
+
+// ZScore returns the score of member of the sorted set at key
+func (c *Cache) ZScore(key, member string) (float64, bool, error) {
+	var score float64
+	var ok bool
+	_, err := viewObject(c, key, func(z *sortedSet) error {
+		score, ok = z.scores[member]
+		return nil
+	})
+	return score, ok, err
+}
+
+// ZRank returns the 0-based rank of member of the sorted set at key, from
+// the lowest score or, if rev is set, the highest, along with its score
+func (c *Cache) ZRank(key, member string, rev bool) (int, float64, bool, error) {
+	var rank int
+	var score float64
+	var ok bool
+	_, err := viewObject(c, key, func(z *sortedSet) error {
+		if rank, ok = z.rank(member, rev); ok {
+			score = z.scores[member]
+		}
+		return nil
+	})
+	return rank, score, ok, err
+}
+
+// ZRange returns the members of the sorted set at key from rank start to
+// stop inclusive, counted from the highest score if rev is set
+func (c *Cache) ZRange(key string, start, stop int, rev bool) ([]ScoredMember, error) {
+	members := []ScoredMember{}
+	_, err := viewObject(c, key, func(z *sortedSet) error {
+		members = z.rangeByRank(start, stop, rev)
+		return nil
+	})
+	return members, err
+}
+
+// ZCard returns the number of members of the sorted set at key
+func (c *Cache) ZCard(key string) (int, error) {
+	n := 0
+	_, err := viewObject(c, key, func(z *sortedSet) error {
+		n = len(z.scores)
+		return nil
+	})
+	return n, err
+}
+
+func init() {
+	RegisterCommands(
+		&Command{Name: "ZADD", Arity: -4, Flags: FlagWrite, Handler: zaddCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "ZINCRBY", Arity: 4, Flags: FlagWrite, Handler: zincrbyCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "ZREM", Arity: -3, Flags: FlagWrite, Handler: zremCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "ZSCORE", Arity: 3, Flags: FlagReadOnly, Handler: zscoreCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "ZRANK", Arity: 3, Flags: FlagReadOnly, Handler: zrankCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "ZREVRANK", Arity: 3, Flags: FlagReadOnly, Handler: zrankCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "ZRANGE", Arity: -4, Flags: FlagReadOnly, Handler: zrangeCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "ZREVRANGE", Arity: -4, Flags: FlagReadOnly, Handler: zrangeCommand, KeyArgs: firstKeyArg},
+		&Command{Name: "ZCARD", Arity: 2, Flags: FlagReadOnly, Handler: zcardCommand, KeyArgs: firstKeyArg},
+	)
+}
+
+// zsetCommandError maps cache errors to replies, keeping those that
+// already carry a reply code
+func zsetCommandError(err error) error {
+	if err == ErrWrongType || err == ErrOOM {
+		return err
+	}
+	return fmt.Errorf("ERR %v", err)
+}
+
+// parseScore parses a score, which may be inf or -inf but not NaN
+func parseScore(arg []byte) (float64, error) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, errNotFloat
+	}
+	return score, nil
+}
+
+// formatScore formats a score as Redis does
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// zaddCommand implements
+// ZADD key [NX|XX] [GT|LT] [CH] score member [score member ...], replying
+// with the members added, or added or changed with CH
+func zaddCommand(ctx *CommandContext) error {
+	var opts ZAddOptions
+	ch := false
+	i := 2
+options:
+	for ; i < len(ctx.Args); i++ {
+		switch strings.ToUpper(string(ctx.Args[i])) {
+		case "NX":
+			opts.NX = true
+		case "XX":
+			opts.XX = true
+		case "GT":
+			opts.GT = true
+		case "LT":
+			opts.LT = true
+		case "CH":
+			ch = true
+		default:
+			break options
+		}
+	}
+	pairs := ctx.Args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		return ErrSyntax
+	}
+	members := make([]ScoredMember, 0, len(pairs)/2)
+	for j := 0; j < len(pairs); j += 2 {
+		score, err := parseScore(pairs[j])
+		if err != nil {
+			return err
+		}
+		members = append(members, ScoredMember{Member: string(pairs[j+1]), Score: score})
+	}
+
+	added, changed, err := ctx.Cache.ZAdd(ctx.Context, string(ctx.Args[1]), members, opts)
+	if err != nil {
+		return zsetCommandError(err)
+	}
+	if ch {
+		added = changed
+	}
+	ctx.Out.WriteInteger(int64(added))
+	return nil
+}
+
+// zincrbyCommand implements ZINCRBY key increment member, replying with
+// the new score
+func zincrbyCommand(ctx *CommandContext) error {
+	incr, err := parseScore(ctx.Args[2])
+	if err != nil {
+		return err
+	}
+	score, err := ctx.Cache.ZIncrBy(ctx.Context, string(ctx.Args[1]), string(ctx.Args[3]), incr)
+	if err != nil {
+		return zsetCommandError(err)
+	}
+	ctx.Out.WriteBulkString(formatScore(score))
+	return nil
+}
+
+// zremCommand implements ZREM key member [member ...]
+func zremCommand(ctx *CommandContext) error {
+	removed, err := ctx.Cache.ZRem(ctx.Context, string(ctx.Args[1]), keysFromArgs(ctx.Args[2:])...)
+	if err != nil {
+		return zsetCommandError(err)
+	}
+	ctx.Out.WriteInteger(int64(removed))
+	return nil
+}
+
+// zscoreCommand implements ZSCORE key member
+func zscoreCommand(ctx *CommandContext) error {
+	score, ok, err := ctx.Cache.ZScore(string(ctx.Args[1]), string(ctx.Args[2]))
+	if err != nil {
+		return zsetCommandError(err)
+	}
+	if !ok {
+		ctx.Out.WriteNull()
+		return nil
+	}
+	ctx.Out.WriteBulkString(formatScore(score))
+	return nil
+}
+
+// zrankCommand implements ZRANK and ZREVRANK key member, replying with the
+// member's 0-based rank or null
+func zrankCommand(ctx *CommandContext) error {
+	rev := strings.EqualFold(string(ctx.Args[0]), "ZREVRANK")
+	rank, _, ok, err := ctx.Cache.ZRank(string(ctx.Args[1]), string(ctx.Args[2]), rev)
+	if err != nil {
+		return zsetCommandError(err)
+	}
+	if !ok {
+		ctx.Out.WriteNull()
+		return nil
+	}
+	ctx.Out.WriteInteger(int64(rank))
+	return nil
+}
+
+// zrangeCommand implements ZRANGE key start stop [REV] [WITHSCORES] and
+// ZREVRANGE key start stop [WITHSCORES], by rank
+func zrangeCommand(ctx *CommandContext) error {
+	rev := strings.EqualFold(string(ctx.Args[0]), "ZREVRANGE")
+	start, err := ParseInt(ctx.Args[2])
+	if err != nil {
+		return err
+	}
+	stop, err := ParseInt(ctx.Args[3])
+	if err != nil {
+		return err
+	}
+	withScores := false
+	for _, arg := range ctx.Args[4:] {
+		switch option := strings.ToUpper(string(arg)); {
+		case option == "WITHSCORES":
+			withScores = true
+		case option == "REV" && !rev:
+			rev = true
+		default:
+			return ErrSyntax
+		}
+	}
+
+	members, err := ctx.Cache.ZRange(string(ctx.Args[1]), int(start), int(stop), rev)
+	if err != nil {
+		return zsetCommandError(err)
+	}
+	if withScores {
+		ctx.Out.WriteArrayHeader(2 * len(members))
+	} else {
+		ctx.Out.WriteArrayHeader(len(members))
+	}
+	for _, m := range members {
+		ctx.Out.WriteBulkString(m.Member)
+		if withScores {
+			ctx.Out.WriteBulkString(formatScore(m.Score))
+		}
+	}
+	return nil
+}
+
+// zcardCommand implements ZCARD key
+func zcardCommand(ctx *CommandContext) error {
+	n, err := ctx.Cache.ZCard(string(ctx.Args[1]))
+	if err != nil {
+		return zsetCommandError(err)
+	}
+	ctx.Out.WriteInteger(int64(n))
+	return nil
+}
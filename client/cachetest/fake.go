@@ -0,0 +1,167 @@
+// Package cachetest provides an in-memory stand-in for the cache client,
+// so application tests run without a server
+package cachetest
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+	"github.com/hamisionesmus/distributed-cache/client"
+	"github.com/hamisionesmus/distributed-cache/server"
+)
+
+// fakeAddr is the address the client dials; any address reaches the fake
+const fakeAddr = "cachetest"
+
+// Fake is a client.Client connected to a cache engine and RESP server in
+// the same process over in-memory pipes, so commands have the server's
+// semantics without a network. Its clock only moves when Advance is
+// called, so tests can expire keys without sleeping. It is safe for
+// concurrent use.
+type Fake struct {
+	*client.Client
+	cache    *cache.Cache
+	server   *server.TCPServer
+	listener *pipeListener
+
+	mu  sync.Mutex
+	now time.Time
+}
+
+var _ client.Cmdable = (*Fake)(nil)
+
+// NewFake returns an empty fake whose clock starts at the current time.
+// opts configures the client, for example its Codec, and may be nil; its
+// Addresses and Dialer are replaced.
+func NewFake(opts *client.Options) (*Fake, error) {
+	c, err := cache.NewCacheFromConfig(cache.DefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+	f := &Fake{
+		cache:    c,
+		server:   server.NewTCPServer(c, log.New(io.Discard, "", 0)),
+		listener: newPipeListener(),
+		now:      time.Now(),
+	}
+	c.SetClock(f.Now)
+	go f.server.Serve(f.listener)
+
+	var o client.Options
+	if opts != nil {
+		o = *opts
+	}
+	o.Addresses = []string{fakeAddr}
+	o.Replicas = nil
+	o.Dialer = f.listener.dial
+	if f.Client, err = client.NewClient(&o); err != nil {
+		f.server.Shutdown(context.Background())
+		return nil, err
+	}
+	return f, nil
+}
+
+// Cache returns the engine behind the fake, to seed or inspect it directly
+func (f *Fake) Cache() *cache.Cache {
+	return f.cache
+}
+
+// Now returns the fake's clock
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the clock forward by d, expiring keys whose TTL runs out
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// TTL returns the time key has left to live, and false if it does not
+// exist. A key without expiry has a TTL of zero.
+func (f *Fake) TTL(key string) (time.Duration, bool) {
+	meta, ok := f.cache.Metadata(key)
+	if !ok {
+		return 0, false
+	}
+	if meta.TTLMillis < 0 {
+		return 0, true
+	}
+	return time.Duration(meta.TTLMillis) * time.Millisecond, true
+}
+
+// Keys returns the live keys in sorted order
+func (f *Fake) Keys() []string {
+	keys, _ := f.cache.KeysWithPrefix(context.Background(), "", 0)
+	sort.Strings(keys)
+	return keys
+}
+
+// FlushAll removes every key
+func (f *Fake) FlushAll() {
+	f.cache.Clear()
+}
+
+// Close closes the client and stops the server
+func (f *Fake) Close() error {
+	err := f.Client.Close()
+	f.server.Shutdown(context.Background())
+	return err
+}
+
+// pipeListener is a net.Listener whose connections are net.Pipe pairs
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+// dial connects a new pipe to the listener
+func (l *pipeListener) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	clientEnd, serverEnd := net.Pipe()
+	select {
+	case l.conns <- serverEnd:
+		return clientEnd, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// pipeAddr is the address of a pipeListener
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return fakeAddr }
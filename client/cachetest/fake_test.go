@@ -0,0 +1,49 @@
+package cachetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/client"
+)
+
+// TestAdvanceExpires checks that moving the fake's clock, without any real
+// time passing, expires a key, a semaphore lease and the delay of a queued
+// item
+func TestAdvanceExpires(t *testing.T) {
+	ctx := context.Background()
+	f, err := NewFake(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := f.Set(ctx, "key", "v", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if lease, err := f.Do(ctx, "SEM.ACQUIRE", "sem", 1, 1, 60000); err != nil || lease == nil {
+		t.Fatalf("SEM.ACQUIRE = %v, %v", lease, err)
+	}
+	if _, err := f.Do(ctx, "DQ.ADD", "queue", 60000, "job"); err != nil {
+		t.Fatal(err)
+	}
+	if item, err := f.Do(ctx, "DQ.POP", "queue"); err != nil || item != nil {
+		t.Fatalf("DQ.POP before the delay = %v, %v", item, err)
+	}
+
+	f.Advance(time.Minute + time.Millisecond)
+	if _, err := f.Get(ctx, "key"); err != client.ErrNil {
+		t.Errorf("GET after the TTL = %v, want nil", err)
+	}
+	if info, err := f.Cache().SemaphoreInfo("sem"); err != nil || info.InUse != 0 {
+		t.Errorf("semaphore after the lease TTL = %+v, %v, want no permits in use", info, err)
+	}
+	if lease, err := f.Do(ctx, "SEM.ACQUIRE", "sem", 1, 1, 60000); err != nil || lease == nil {
+		t.Errorf("SEM.ACQUIRE after the lease TTL = %v, %v", lease, err)
+	}
+	item, err := f.Do(ctx, "DQ.POP", "queue")
+	if reply, ok := item.([]interface{}); err != nil || !ok || len(reply) != 2 || string(reply[1].([]byte)) != "job" {
+		t.Errorf("DQ.POP after the delay = %v, %v", item, err)
+	}
+}
@@ -0,0 +1,482 @@
+// Package client is a Go client for the distributed cache server
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNil is returned when a key does not exist
+var ErrNil = errors.New("cache: nil")
+
+// ErrClosed is returned when using a client after Close
+var ErrClosed = errors.New("cache: client is closed")
+
+// Options configures a Client
+type Options struct {
+	// Addresses lists server addresses in order of preference. Commands
+	// go to the first one whose circuit breaker is closed.
+	Addresses []string
+	// Replicas serve hedged reads, see HedgeDelay
+	Replicas []string
+	// Username and Password sign connections in with AUTH; without a
+	// Username they sign in as the server's default user
+	Username  string
+	Password  string
+	TLSConfig *tls.Config
+	// Dialer, when set, opens connections in place of TCP, for example to
+	// a server in the same process; TLSConfig is then ignored
+	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	DialTimeout time.Duration
+	// ReadTimeout bounds the wait for a reply. Negative waits as long as
+	// the command's context allows, for blocking commands.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// PoolSize is the maximum number of idle connections kept open
+	PoolSize int
+	// Multiplex, when positive, shares that many connections between all
+	// callers, pipelining their commands instead of checking connections
+	// out of the pool. It keeps connection counts low for large fleets but
+	// a slow command delays the others on its connection.
+	Multiplex int
+
+	// Codec encodes values written with SetAs; defaults to JSON
+	Codec Codec
+
+	// Retry retries idempotent commands that fail transiently
+	Retry RetryPolicy
+	// BreakerThreshold consecutive failures open a node's circuit breaker,
+	// sending commands to the next address for BreakerCooldown. Zero
+	// disables circuit breaking.
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	// HedgeDelay, when positive, sends a read that has not been answered
+	// by then to a replica as well, returning whichever reply comes first
+	HedgeDelay time.Duration
+	// HedgePercentile, between 0 and 1, hedges reads slower than that
+	// percentile of recent reads instead, for example 0.95. HedgeDelay
+	// then applies until enough reads were timed and bounds the delay
+	// from below.
+	HedgePercentile float64
+
+	// Hooks observe commands and connections, see NewOTelHook and
+	// NewPrometheusHook
+	Hooks []Hook
+}
+
+func (o *Options) setDefaults() {
+	if o.DialTimeout == 0 {
+		o.DialTimeout = 5 * time.Second
+	}
+	if o.ReadTimeout == 0 {
+		o.ReadTimeout = 3 * time.Second
+	}
+	if o.WriteTimeout == 0 {
+		o.WriteTimeout = o.ReadTimeout
+		if o.WriteTimeout < 0 {
+			o.WriteTimeout = 3 * time.Second
+		}
+	}
+	if o.PoolSize == 0 {
+		o.PoolSize = 10
+	}
+	if o.Codec == nil {
+		o.Codec = JSONCodec
+	}
+	if o.BreakerCooldown == 0 {
+		o.BreakerCooldown = 5 * time.Second
+	}
+	o.Retry.setDefaults()
+}
+
+// Cmdable is the key-value API shared by Client and test fakes such as
+// cachetest.Fake, so application code can take either
+type Cmdable interface {
+	Do(ctx context.Context, args ...interface{}) (interface{}, error)
+	Get(ctx context.Context, key string) (string, error)
+	GetBytes(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	Del(ctx context.Context, keys ...string) (int64, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	MSet(ctx context.Context, values map[string]interface{}) error
+	MGet(ctx context.Context, keys ...string) ([][]byte, error)
+	// Codec returns the codec SetAs encodes values with
+	Codec() Codec
+	Close() error
+}
+
+var _ Cmdable = (*Client)(nil)
+
+// Client is a pooled connection to cache servers. It is safe for
+// concurrent use.
+type Client struct {
+	opts        Options
+	nodes       []*node
+	replicas    []*node
+	replicaNext uint32
+
+	// latencies times reads for HedgePercentile
+	latencies *latencyWindow
+	hedged    atomic.Int64
+	hedgesWon atomic.Int64
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// node holds the connections to one server
+type node struct {
+	addr    string
+	idle    chan *conn
+	breaker breaker
+	// muxes are the shared connections in multiplexed mode
+	muxes   []*muxConn
+	muxNext uint32
+}
+
+// conn is a single server connection
+type conn struct {
+	addr    string
+	netConn net.Conn
+	r       *bufio.Reader
+	w       *bufio.Writer
+}
+
+// NewClient creates a client. Connections are opened lazily.
+func NewClient(opts *Options) (*Client, error) {
+	if opts == nil || len(opts.Addresses) == 0 {
+		return nil, fmt.Errorf("cache: at least one address is required")
+	}
+
+	o := *opts
+	o.setDefaults()
+	c := &Client{opts: o}
+	for _, addr := range o.Addresses {
+		c.nodes = append(c.nodes, c.newNode(addr))
+	}
+	for _, addr := range o.Replicas {
+		c.replicas = append(c.replicas, c.newNode(addr))
+	}
+	if o.HedgePercentile > 0 && o.HedgePercentile < 1 {
+		c.latencies = newLatencyWindow(o.HedgePercentile)
+	}
+	return c, nil
+}
+
+func (c *Client) newNode(addr string) *node {
+	n := &node{
+		addr:    addr,
+		idle:    make(chan *conn, c.opts.PoolSize),
+		breaker: breaker{threshold: c.opts.BreakerThreshold, cooldown: c.opts.BreakerCooldown},
+	}
+	if c.opts.Multiplex > 0 {
+		n.muxes = make([]*muxConn, c.opts.Multiplex)
+	}
+	return n
+}
+
+// Codec returns Options.Codec
+func (c *Client) Codec() Codec {
+	return c.opts.Codec
+}
+
+// Close closes all idle connections and fails commands pending on
+// multiplexed ones
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	for _, n := range append(c.nodes, c.replicas...) {
+		close(n.idle)
+		for cn := range n.idle {
+			cn.close(c.opts, nil)
+		}
+		for _, m := range n.muxes {
+			if m != nil {
+				m.fail(ErrClosed)
+			}
+		}
+	}
+	return nil
+}
+
+// Do sends a command and returns its decoded reply. Error replies from the
+// server are returned as Error. Idempotent commands are retried according
+// to Options.Retry.
+func (c *Client) Do(ctx context.Context, args ...interface{}) (interface{}, error) {
+	retries := 0
+	if isIdempotent(args) {
+		retries = c.opts.Retry.MaxRetries
+	}
+	for attempt := 0; ; attempt++ {
+		reply, err := c.doHedged(ctx, args)
+		if err == nil || attempt >= retries || !retryable(err) {
+			return reply, err
+		}
+		if c.opts.Retry.wait(ctx, attempt) != nil {
+			return reply, err
+		}
+	}
+}
+
+// doNode sends a command to n and records the outcome in its circuit
+// breaker
+func (c *Client) doNode(ctx context.Context, n *node, args []interface{}) (interface{}, error) {
+	if n == nil {
+		return nil, ErrCircuitOpen
+	}
+
+	cmd := CommandInfo{Name: commandName(args), Addr: n.addr, Start: time.Now()}
+	ctx = c.commandStart(ctx, cmd)
+
+	var reply interface{}
+	var err error
+	if n.muxes != nil {
+		reply, err = c.doMultiplexed(ctx, n, args)
+	} else {
+		reply, err = c.doPooled(ctx, n, args)
+	}
+	n.breaker.record(err)
+	c.commandEnd(ctx, cmd, err)
+	return reply, err
+}
+
+// doPooled sends a command over a connection checked out of n's pool
+func (c *Client) doPooled(ctx context.Context, n *node, args []interface{}) (interface{}, error) {
+	cn, err := c.getConn(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := cn.roundTrip(ctx, c.opts, args)
+	if err != nil {
+		cn.close(c.opts, err)
+		return nil, err
+	}
+	c.putConn(n, cn)
+
+	if e, ok := reply.(Error); ok {
+		return nil, e
+	}
+	return reply, nil
+}
+
+// Get returns the value stored at key, or ErrNil if it does not exist
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	b, err := c.GetBytes(ctx, key)
+	return string(b), err
+}
+
+// GetBytes is like Get but returns the raw value
+func (c *Client) GetBytes(ctx context.Context, key string) ([]byte, error) {
+	reply, err := c.Do(ctx, "GET", key)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrNil
+	}
+	b, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("cache: unexpected GET reply %T", reply)
+	}
+	return b, nil
+}
+
+// Set stores value at key. A zero ttl means the key does not expire.
+func (c *Client) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	args := []interface{}{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", int64(ttl/time.Millisecond))
+	}
+	_, err := c.Do(ctx, args...)
+	return err
+}
+
+// Del removes keys and returns how many existed
+func (c *Client) Del(ctx context.Context, keys ...string) (int64, error) {
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, "DEL")
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := reply.(int64)
+	return n, nil
+}
+
+// Exists reports whether key exists
+func (c *Client) Exists(ctx context.Context, key string) (bool, error) {
+	reply, err := c.Do(ctx, "EXISTS", key)
+	if err != nil {
+		return false, err
+	}
+	n, _ := reply.(int64)
+	return n > 0, nil
+}
+
+// MSet stores several keys at once
+func (c *Client) MSet(ctx context.Context, values map[string]interface{}) error {
+	args := make([]interface{}, 0, 2*len(values)+1)
+	args = append(args, "MSET")
+	for key, value := range values {
+		args = append(args, key, value)
+	}
+	_, err := c.Do(ctx, args...)
+	return err
+}
+
+// MGet returns the values of several keys, with nil for missing keys
+func (c *Client) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, "MGET")
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("cache: unexpected MGET reply %T", reply)
+	}
+	values := make([][]byte, len(items))
+	for i, item := range items {
+		values[i], _ = item.([]byte)
+	}
+	return values, nil
+}
+
+// getConn takes an idle connection to n or dials a new one
+func (c *Client) getConn(ctx context.Context, n *node) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	c.mu.Unlock()
+
+	select {
+	case cn := <-n.idle:
+		if cn != nil {
+			return cn, nil
+		}
+	default:
+	}
+	return c.dial(ctx, n.addr)
+}
+
+// putConn returns a healthy connection to n's pool, closing it if full
+func (c *Client) putConn(n *node, cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		cn.close(c.opts, nil)
+		return
+	}
+	select {
+	case n.idle <- cn:
+	default:
+		cn.close(c.opts, nil)
+	}
+}
+
+func (c *Client) dial(ctx context.Context, addr string) (*conn, error) {
+	dialer := &net.Dialer{Timeout: c.opts.DialTimeout}
+
+	var netConn net.Conn
+	var err error
+	if c.opts.Dialer != nil {
+		dialCtx, cancel := context.WithTimeout(ctx, c.opts.DialTimeout)
+		netConn, err = c.opts.Dialer(dialCtx, "tcp", addr)
+		cancel()
+	} else if c.opts.TLSConfig != nil {
+		netConn, err = (&tls.Dialer{NetDialer: dialer, Config: c.opts.TLSConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		netConn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		connOpened(c.opts, addr, err)
+		return nil, err
+	}
+
+	cn := &conn{
+		addr:    addr,
+		netConn: netConn,
+		r:       bufio.NewReader(netConn),
+		w:       bufio.NewWriter(netConn),
+	}
+
+	if c.opts.Password != "" {
+		auth := []interface{}{"AUTH", c.opts.Password}
+		if c.opts.Username != "" {
+			auth = []interface{}{"AUTH", c.opts.Username, c.opts.Password}
+		}
+		reply, err := cn.roundTrip(ctx, c.opts, auth)
+		if err == nil {
+			if e, ok := reply.(Error); ok {
+				err = e
+			}
+		}
+		if err != nil {
+			netConn.Close()
+			err = fmt.Errorf("cache: authentication failed: %w", err)
+			connOpened(c.opts, addr, err)
+			return nil, err
+		}
+	}
+	connOpened(c.opts, addr, nil)
+	return cn, nil
+}
+
+// readDeadline returns the deadline for reading the reply to a command
+// written now, zero when ReadTimeout is negative
+func (o *Options) readDeadline() time.Time {
+	if o.ReadTimeout < 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(o.WriteTimeout + o.ReadTimeout)
+}
+
+// roundTrip writes a command and reads its reply, honoring ctx deadlines
+func (cn *conn) roundTrip(ctx context.Context, opts Options, args []interface{}) (interface{}, error) {
+	writeDeadline := time.Now().Add(opts.WriteTimeout)
+	readDeadline := opts.readDeadline()
+	if deadline, ok := ctx.Deadline(); ok {
+		if deadline.Before(writeDeadline) {
+			writeDeadline = deadline
+		}
+		if readDeadline.IsZero() || deadline.Before(readDeadline) {
+			readDeadline = deadline
+		}
+	}
+
+	cn.netConn.SetWriteDeadline(writeDeadline)
+	if err := writeCommand(cn.w, args); err != nil {
+		return nil, err
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	cn.netConn.SetReadDeadline(readDeadline)
+	return readReply(cn.r)
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+// Content type markers stored as the first byte of values written by SetAs
+const (
+	ContentTypeJSON     byte = 0x01
+	ContentTypeProtobuf byte = 0x02
+	ContentTypeMsgpack  byte = 0x03
+)
+
+// Codec marshals typed values to and from their stored form. Each codec owns
+// a content type byte that is prepended to the encoded value, so GetAs can
+// decode values regardless of which codec wrote them.
+type Codec interface {
+	ContentType() byte
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSONCodec encodes values with encoding/json
+	JSONCodec Codec = jsonCodec{}
+	// ProtobufCodec encodes values implementing proto.Message
+	ProtobufCodec Codec = protobufCodec{}
+	// MsgpackCodec encodes values with MessagePack
+	MsgpackCodec Codec = msgpackCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[byte]Codec{
+		ContentTypeJSON:     JSONCodec,
+		ContentTypeProtobuf: ProtobufCodec,
+		ContentTypeMsgpack:  MsgpackCodec,
+	}
+)
+
+// RegisterCodec makes a custom codec available to GetAs. Content types
+// below 0x80 are reserved for built-in codecs.
+func RegisterCodec(codec Codec) error {
+	ct := codec.ContentType()
+	if ct < 0x80 {
+		return fmt.Errorf("cache: content type 0x%02x is reserved", ct)
+	}
+
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	if _, exists := codecs[ct]; exists {
+		return fmt.Errorf("cache: content type 0x%02x already registered", ct)
+	}
+	codecs[ct] = codec
+	return nil
+}
+
+func codecFor(ct byte) (Codec, bool) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[ct]
+	return codec, ok
+}
+
+// EncodeValue marshals v with codec and prepends its content type byte
+func EncodeValue(codec Codec, v interface{}) ([]byte, error) {
+	data, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(data)+1)
+	out = append(out, codec.ContentType())
+	return append(out, data...), nil
+}
+
+// DecodeValue unmarshals a value produced by EncodeValue into v, choosing
+// the codec from the embedded content type byte
+func DecodeValue(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return fmt.Errorf("cache: empty value has no content type")
+	}
+	codec, ok := codecFor(data[0])
+	if !ok {
+		return fmt.Errorf("cache: unknown content type 0x%02x", data[0])
+	}
+	return codec.Unmarshal(data[1:], v)
+}
+
+// SetAs encodes value with the client's codec and stores it at key
+func SetAs[T any](ctx context.Context, c Cmdable, key string, value T, ttl time.Duration) error {
+	return SetWithCodec(ctx, c, c.Codec(), key, value, ttl)
+}
+
+// SetWithCodec is like SetAs but uses the given codec
+func SetWithCodec[T any](ctx context.Context, c Cmdable, codec Codec, key string, value T, ttl time.Duration) error {
+	data, err := EncodeValue(codec, value)
+	if err != nil {
+		return fmt.Errorf("cache: encoding %s: %w", key, err)
+	}
+	return c.Set(ctx, key, data, ttl)
+}
+
+// GetAs fetches key and decodes it into a T using the codec recorded when
+// the value was written. It returns ErrNil if the key does not exist.
+func GetAs[T any](ctx context.Context, c Cmdable, key string) (T, error) {
+	var value T
+	data, err := c.GetBytes(ctx, key)
+	if err != nil {
+		return value, err
+	}
+
+	// Protobuf messages are pointers and must be allocated before decoding
+	target := interface{}(&value)
+	if m, ok := newProtoMessage(value); ok {
+		target = m
+	}
+	if err := DecodeValue(data, target); err != nil {
+		return value, fmt.Errorf("cache: decoding %s: %w", key, err)
+	}
+	if m, ok := target.(T); ok {
+		value = m
+	}
+	return value, nil
+}
+
+// newProtoMessage allocates a fresh message when T is a pointer to a
+// protobuf message type
+func newProtoMessage(zero interface{}) (interface{}, bool) {
+	t := reflect.TypeOf(zero)
+	if t == nil || t.Kind() != reflect.Ptr {
+		return nil, false
+	}
+	m, ok := reflect.New(t.Elem()).Interface().(proto.Message)
+	return m, ok
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() byte                          { return ContentTypeJSON }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() byte { return ContentTypeProtobuf }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not a proto.Message", v)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() byte                          { return ContentTypeMsgpack }
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
@@ -0,0 +1,29 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/hamisionesmus/distributed-cache/internal/keyhash"
+)
+
+// Hash names accepted by RingOptions.Hash and HashFunc
+const (
+	// HashKetama is MD5 as libmemcached's ketama uses it
+	HashKetama = "ketama"
+	// HashFNV is 64-bit FNV-1a
+	HashFNV = keyhash.FNV
+	// HashXXHash is 64-bit xxHash with a zero seed, as in
+	// github.com/cespare/xxhash
+	HashXXHash = keyhash.XXHash
+)
+
+// HashFunc returns the 64-bit hash function called name, for sharding
+// compatible with other clients. Ketama has no 64-bit form and is only
+// available through RingOptions.
+func HashFunc(name string) (func([]byte) uint64, error) {
+	h, err := keyhash.Func(name)
+	if err != nil {
+		return nil, fmt.Errorf("cache: %v", err)
+	}
+	return h, nil
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// With Options.HedgePercentile set, the hedge delay follows the latency of
+// recent reads rather than being fixed: a read is hedged once it has taken
+// longer than that percentile of the last hedgeWindowSize reads, so only
+// the slowest few percent are sent twice however fast the servers are.
+
+const (
+	// hedgeWindowSize is how many recent read latencies the delay is
+	// computed from
+	hedgeWindowSize = 1024
+	// hedgeMinSamples is how many reads are timed before the percentile
+	// is used; until then the delay is Options.HedgeDelay
+	hedgeMinSamples = 100
+	// hedgeRecomputeEvery is how many reads pass between updates of the
+	// delay
+	hedgeRecomputeEvery = 64
+)
+
+// HedgeStats describes the reads a client hedged
+type HedgeStats struct {
+	// Hedged counts reads sent to a replica as well as the primary
+	Hedged int64
+	// Won counts hedged reads answered by the replica first
+	Won int64
+	// Delay is the current hedge delay, zero while hedging is off
+	Delay time.Duration
+}
+
+// latencyWindow keeps the latencies of recent reads and the percentile of
+// them hedging waits for
+type latencyWindow struct {
+	percentile float64
+
+	mu      sync.Mutex
+	samples []time.Duration
+	next    int
+	pending int
+
+	// delay is the percentile as of the last update, zero until
+	// hedgeMinSamples reads were timed
+	delay atomic.Int64
+}
+
+func newLatencyWindow(percentile float64) *latencyWindow {
+	return &latencyWindow{percentile: percentile, samples: make([]time.Duration, 0, hedgeWindowSize)}
+}
+
+// record adds the latency of a read, updating the delay every
+// hedgeRecomputeEvery reads
+func (w *latencyWindow) record(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.samples) < hedgeWindowSize {
+		w.samples = append(w.samples, d)
+	} else {
+		w.samples[w.next] = d
+		w.next = (w.next + 1) % hedgeWindowSize
+	}
+	w.pending++
+	if len(w.samples) < hedgeMinSamples || w.pending < hedgeRecomputeEvery {
+		return
+	}
+	w.pending = 0
+
+	sorted := make([]time.Duration, len(w.samples))
+	copy(sorted, w.samples)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(w.percentile * float64(len(sorted)))
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	w.delay.Store(int64(sorted[i]))
+}
+
+// hedgeDelay returns how long a read waits before it is hedged, or zero if
+// reads are not hedged yet
+func (c *Client) hedgeDelay() time.Duration {
+	delay := c.opts.HedgeDelay
+	if c.latencies != nil {
+		if d := time.Duration(c.latencies.delay.Load()); d > delay {
+			delay = d
+		}
+	}
+	return delay
+}
+
+// HedgeStats returns how many reads were hedged and the current delay
+func (c *Client) HedgeStats() HedgeStats {
+	return HedgeStats{
+		Hedged: c.hedged.Load(),
+		Won:    c.hedgesWon.Load(),
+		Delay:  c.hedgeDelay(),
+	}
+}
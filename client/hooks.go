@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"time"
+)
+
+// CommandInfo describes a command sent to a server
+type CommandInfo struct {
+	// Name is the upper-case command name
+	Name string
+	// Addr is the server the command was sent to
+	Addr  string
+	Start time.Time
+}
+
+// Hook observes a client's commands and connections, for latency
+// breakdowns and tracing without wrapping every call. Hooks must be safe
+// for concurrent use and should not block. Options.Hooks lists the hooks
+// of a client.
+type Hook interface {
+	// OnCommandStart is called before a command is sent. The context it
+	// returns is passed to OnCommandEnd, so a hook can carry state such as
+	// a span. Retries and hedged reads are reported as separate commands.
+	OnCommandStart(ctx context.Context, cmd CommandInfo) context.Context
+	// OnCommandEnd is called with the command's error, which is an Error
+	// for error replies
+	OnCommandEnd(ctx context.Context, cmd CommandInfo, err error)
+	// OnConnOpen is called after dialing addr, with the error if it failed
+	OnConnOpen(addr string, err error)
+	// OnConnClose is called when a connection is closed, with the error
+	// that broke it or nil for a normal close
+	OnConnClose(addr string, err error)
+}
+
+// commandStart runs the OnCommandStart hooks
+func (c *Client) commandStart(ctx context.Context, cmd CommandInfo) context.Context {
+	for _, hook := range c.opts.Hooks {
+		ctx = hook.OnCommandStart(ctx, cmd)
+	}
+	return ctx
+}
+
+// commandEnd runs the OnCommandEnd hooks in reverse order
+func (c *Client) commandEnd(ctx context.Context, cmd CommandInfo, err error) {
+	for i := len(c.opts.Hooks) - 1; i >= 0; i-- {
+		c.opts.Hooks[i].OnCommandEnd(ctx, cmd, err)
+	}
+}
+
+// connOpened runs the OnConnOpen hooks
+func connOpened(opts Options, addr string, err error) {
+	for _, hook := range opts.Hooks {
+		hook.OnConnOpen(addr, err)
+	}
+}
+
+// close closes the connection and runs the OnConnClose hooks
+func (cn *conn) close(opts Options, err error) {
+	cn.netConn.Close()
+	for _, hook := range opts.Hooks {
+		hook.OnConnClose(cn.addr, err)
+	}
+}
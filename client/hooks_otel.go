@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// otelHook traces each command as a client span
+type otelHook struct {
+	tracer trace.Tracer
+}
+
+// NewOTelHook returns a hook that starts a client span named after each
+// command, such as "cache GET", following the OpenTelemetry database
+// semantic conventions. Error replies and connection failures mark the
+// span as failed.
+func NewOTelHook(tracer trace.Tracer) Hook {
+	return otelHook{tracer: tracer}
+}
+
+func (h otelHook) OnCommandStart(ctx context.Context, cmd CommandInfo) context.Context {
+	ctx, _ = h.tracer.Start(ctx, "cache "+cmd.Name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(cmd.Start),
+		trace.WithAttributes(
+			attribute.String("db.system", "distributed-cache"),
+			attribute.String("db.operation", cmd.Name),
+			attribute.String("server.address", cmd.Addr),
+		))
+	return ctx
+}
+
+func (h otelHook) OnCommandEnd(ctx context.Context, cmd CommandInfo, err error) {
+	span := trace.SpanFromContext(ctx)
+	if err != nil {
+		span.RecordError(err)
+		var reply Error
+		if !errors.As(err, &reply) {
+			span.SetStatus(codes.Error, err.Error())
+		}
+	}
+	span.End()
+}
+
+func (otelHook) OnConnOpen(addr string, err error) {}
+
+func (otelHook) OnConnClose(addr string, err error) {}
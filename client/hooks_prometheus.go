@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// prometheusHook records command latencies and connection churn
+type prometheusHook struct {
+	commandDuration *prometheus.HistogramVec
+	connsOpened     *prometheus.CounterVec
+	connsClosed     *prometheus.CounterVec
+}
+
+// NewPrometheusHook returns a hook exporting client metrics to reg:
+// command latencies by command, server and outcome, and connections
+// opened and closed by server
+func NewPrometheusHook(reg prometheus.Registerer) (Hook, error) {
+	h := prometheusHook{
+		commandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "cache_client_command_duration_seconds",
+			Help:    "Latency of cache client commands",
+			Buckets: []float64{.0001, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"command", "addr", "status"}),
+		connsOpened: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_client_connections_opened_total",
+			Help: "Connections dialed by the cache client",
+		}, []string{"addr", "status"}),
+		connsClosed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "cache_client_connections_closed_total",
+			Help: "Connections closed by the cache client",
+		}, []string{"addr", "status"}),
+	}
+	for _, c := range []prometheus.Collector{h.commandDuration, h.connsOpened, h.connsClosed} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// outcome labels an error: ok, server_error for error replies, or error
+func outcome(err error) string {
+	var reply Error
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.As(err, &reply):
+		return "server_error"
+	default:
+		return "error"
+	}
+}
+
+func (h prometheusHook) OnCommandStart(ctx context.Context, cmd CommandInfo) context.Context {
+	return ctx
+}
+
+func (h prometheusHook) OnCommandEnd(ctx context.Context, cmd CommandInfo, err error) {
+	h.commandDuration.WithLabelValues(cmd.Name, cmd.Addr, outcome(err)).Observe(time.Since(cmd.Start).Seconds())
+}
+
+func (h prometheusHook) OnConnOpen(addr string, err error) {
+	h.connsOpened.WithLabelValues(addr, outcome(err)).Inc()
+}
+
+func (h prometheusHook) OnConnClose(addr string, err error) {
+	h.connsClosed.WithLabelValues(addr, outcome(err)).Inc()
+}
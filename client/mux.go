@@ -0,0 +1,212 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// muxQueueSize bounds the commands written to a multiplexed connection
+// whose replies have not been read yet. Callers block beyond it.
+const muxQueueSize = 4096
+
+// muxRequest is a command waiting for its reply on a multiplexed connection
+type muxRequest struct {
+	args  []interface{}
+	reply interface{}
+	err   error
+	done  chan struct{}
+}
+
+// muxConn pipelines the commands of many goroutines over one connection.
+// A writer goroutine batches queued commands into as few writes as
+// possible and a reader goroutine matches replies to them in order. Any
+// I/O error or reply timeout breaks the connection and fails every
+// command on it; the client dials a replacement on next use.
+type muxConn struct {
+	cn   *conn
+	opts Options
+
+	requests chan *muxRequest
+	inflight chan *muxRequest
+
+	once sync.Once
+	err  error
+	done chan struct{}
+	// writerDone is closed once nothing more can be queued in inflight
+	writerDone chan struct{}
+}
+
+func newMuxConn(cn *conn, opts Options) *muxConn {
+	m := &muxConn{
+		cn:         cn,
+		opts:       opts,
+		requests:   make(chan *muxRequest),
+		inflight:   make(chan *muxRequest, muxQueueSize),
+		done:       make(chan struct{}),
+		writerDone: make(chan struct{}),
+	}
+	go m.writeLoop()
+	go m.readLoop()
+	return m
+}
+
+// do sends a command and waits for its reply. A caller giving up on ctx
+// leaves the reply to be read and discarded.
+func (m *muxConn) do(ctx context.Context, args []interface{}) (interface{}, error) {
+	req := &muxRequest{args: args, done: make(chan struct{})}
+	select {
+	case m.requests <- req:
+	case <-m.done:
+		return nil, m.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case <-req.done:
+		return req.reply, req.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// broken reports whether the connection has failed
+func (m *muxConn) broken() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// fail closes the connection and records why
+func (m *muxConn) fail(err error) {
+	m.once.Do(func() {
+		m.err = err
+		close(m.done)
+		if err == ErrClosed {
+			err = nil
+		}
+		m.cn.close(m.opts, err)
+	})
+}
+
+// writeLoop writes queued commands, flushing once no more are waiting
+func (m *muxConn) writeLoop() {
+	defer close(m.writerDone)
+
+	for {
+		var req *muxRequest
+		select {
+		case req = <-m.requests:
+		case <-m.done:
+			return
+		}
+
+		m.cn.netConn.SetWriteDeadline(time.Now().Add(m.opts.WriteTimeout))
+		for req != nil {
+			// Queue before writing so the reader never sees an unexpected reply
+			select {
+			case m.inflight <- req:
+			case <-m.done:
+				req.err = m.err
+				close(req.done)
+				return
+			}
+			if err := writeCommand(m.cn.w, req.args); err != nil {
+				m.fail(err)
+				return
+			}
+
+			select {
+			case req = <-m.requests:
+			default:
+				req = nil
+			}
+		}
+		if err := m.cn.w.Flush(); err != nil {
+			m.fail(err)
+			return
+		}
+	}
+}
+
+// readLoop reads replies in the order their commands were written and
+// fails the queued commands once the connection breaks
+func (m *muxConn) readLoop() {
+	for {
+		var req *muxRequest
+		select {
+		case req = <-m.inflight:
+		case <-m.done:
+			m.drain()
+			return
+		}
+
+		m.cn.netConn.SetReadDeadline(m.opts.readDeadline())
+		reply, err := readReply(m.cn.r)
+		if err != nil {
+			m.fail(err)
+			req.err = err
+			close(req.done)
+			m.drain()
+			return
+		}
+		if e, ok := reply.(Error); ok {
+			req.err = e
+		} else {
+			req.reply = reply
+		}
+		close(req.done)
+	}
+}
+
+// drain fails the commands still waiting for replies on a broken
+// connection, once the writer has stopped queueing them
+func (m *muxConn) drain() {
+	<-m.writerDone
+	for {
+		select {
+		case req := <-m.inflight:
+			req.err = m.err
+			close(req.done)
+		default:
+			return
+		}
+	}
+}
+
+// doMultiplexed sends a command over one of the connections shared with
+// n, spreading callers over them in turn
+func (c *Client) doMultiplexed(ctx context.Context, n *node, args []interface{}) (interface{}, error) {
+	m, err := c.muxConn(ctx, n)
+	if err != nil {
+		return nil, err
+	}
+	return m.do(ctx, args)
+}
+
+// muxConn picks the next shared connection, dialing it if it is missing
+// or broken
+func (c *Client) muxConn(ctx context.Context, n *node) (*muxConn, error) {
+	i := int(atomic.AddUint32(&n.muxNext, 1) % uint32(len(n.muxes)))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, ErrClosed
+	}
+	if m := n.muxes[i]; m != nil && !m.broken() {
+		return m, nil
+	}
+	cn, err := c.dial(ctx, n.addr)
+	if err != nil {
+		return nil, err
+	}
+	n.muxes[i] = newMuxConn(cn, c.opts)
+	return n.muxes[i], nil
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Message is a message received on a subscription
+type Message struct {
+	// Pattern is the pattern that matched, empty for channel subscriptions
+	Pattern string
+	Channel string
+	Payload []byte
+}
+
+// PubSub is a connection in subscribe mode. It holds a dedicated
+// connection outside the pool and is not safe for concurrent use.
+type PubSub struct {
+	cn   *conn
+	opts Options
+}
+
+// PSubscribe subscribes to channels matching the given patterns and waits
+// for the server to confirm each one
+func (c *Client) PSubscribe(ctx context.Context, patterns ...string) (*PubSub, error) {
+	cn, err := c.dial(ctx, c.opts.Addresses[0])
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]interface{}, 0, len(patterns)+1)
+	args = append(args, "PSUBSCRIBE")
+	for _, pattern := range patterns {
+		args = append(args, pattern)
+	}
+	reply, err := cn.roundTrip(ctx, c.opts, args)
+	for i := 1; err == nil; i++ {
+		if e, ok := reply.(Error); ok {
+			err = e
+			break
+		}
+		if i == len(patterns) {
+			return &PubSub{cn: cn, opts: c.opts}, nil
+		}
+		reply, err = readReply(cn.r)
+	}
+	cn.close(c.opts, err)
+	return nil, err
+}
+
+// Receive waits for the next message until ctx is done
+func (ps *PubSub) Receive(ctx context.Context) (*Message, error) {
+	for {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			deadline = time.Time{}
+		}
+		ps.cn.netConn.SetReadDeadline(deadline)
+
+		stop := context.AfterFunc(ctx, func() {
+			ps.cn.netConn.SetReadDeadline(time.Now())
+		})
+		reply, err := readReply(ps.cn.r)
+		stop()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, err
+		}
+
+		items, ok := reply.([]interface{})
+		if !ok || len(items) < 3 {
+			return nil, fmt.Errorf("cache: unexpected pubsub reply %v", reply)
+		}
+		kind, _ := items[0].([]byte)
+		switch string(kind) {
+		case "pmessage":
+			if len(items) != 4 {
+				return nil, fmt.Errorf("cache: malformed pmessage")
+			}
+			pattern, _ := items[1].([]byte)
+			channel, _ := items[2].([]byte)
+			payload, _ := items[3].([]byte)
+			return &Message{Pattern: string(pattern), Channel: string(channel), Payload: payload}, nil
+		case "message":
+			channel, _ := items[1].([]byte)
+			payload, _ := items[2].([]byte)
+			return &Message{Channel: string(channel), Payload: payload}, nil
+		}
+		// Subscription confirmations and the like are skipped
+	}
+}
+
+// Close closes the subscription's connection
+func (ps *PubSub) Close() error {
+	ps.cn.close(ps.opts, nil)
+	return nil
+}
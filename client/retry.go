@@ -0,0 +1,261 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrCircuitOpen is returned when every node's circuit breaker is open
+var ErrCircuitOpen = errors.New("cache: circuit breaker open")
+
+// RetryPolicy controls retries of idempotent commands after timeouts,
+// connection errors and replies such as MOVED, TRYAGAIN or LOADING. Each
+// retry waits an exponentially growing backoff, randomized by Jitter so a
+// fleet of clients does not retry in lockstep.
+type RetryPolicy struct {
+	// MaxRetries of zero disables retries
+	MaxRetries int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Jitter is the fraction of each backoff that is randomized, from 0
+	// to 1
+	Jitter float64
+}
+
+func (p *RetryPolicy) setDefaults() {
+	if p.MinBackoff == 0 {
+		p.MinBackoff = 8 * time.Millisecond
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = 512 * time.Millisecond
+	}
+	if p.Jitter == 0 {
+		p.Jitter = 0.5
+	}
+}
+
+// backoff returns the wait before retry number attempt+1
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff << uint(attempt)
+	if d > p.MaxBackoff || d <= 0 {
+		d = p.MaxBackoff
+	}
+	jitter := time.Duration(p.Jitter * float64(d))
+	if jitter > 0 {
+		d = d - jitter + time.Duration(rand.Int63n(int64(jitter)+1))
+	}
+	return d
+}
+
+// wait sleeps for the backoff or until ctx is done
+func (p *RetryPolicy) wait(ctx context.Context, attempt int) error {
+	timer := time.NewTimer(p.backoff(attempt))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// retryableReplies are error reply prefixes worth retrying, possibly on
+// another node
+var retryableReplies = []string{"MOVED ", "ASK ", "TRYAGAIN", "LOADING", "CLUSTERDOWN", "STALE"}
+
+// retryable reports whether err is transient. Error replies are final
+// unless listed in retryableReplies; connection errors and timeouts are
+// transient, but cancellation by the caller is not.
+func retryable(err error) bool {
+	var reply Error
+	if errors.As(err, &reply) {
+		for _, prefix := range retryableReplies {
+			if strings.HasPrefix(string(reply), prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, ErrClosed)
+}
+
+// readOnlyCommands can be retried and hedged
+var readOnlyCommands = map[string]bool{
+	"GET": true, "MGET": true, "EXISTS": true, "TYPE": true, "TTL": true, "PTTL": true,
+	"DUMP": true, "SCAN": true, "KEYSPREFIX": true, "TAGCOUNT": true, "INFO": true, "PING": true,
+	"TS.GET": true, "TS.RANGE": true, "TS.MRANGE": true, "VEC.SEARCH": true, "VEC.CARD": true,
+	"IDX.QUERY": true,
+}
+
+// idempotentWrites leave the same result when applied twice
+var idempotentWrites = map[string]bool{"SET": true, "MSET": true, "DEL": true, "PEXPIREAT": true, "SETTAGS": true}
+
+// commandName returns the upper-case name of a command
+func commandName(args []interface{}) string {
+	if len(args) == 0 {
+		return ""
+	}
+	return strings.ToUpper(string(argBytes(args[0])))
+}
+
+// isIdempotent reports whether a command may be sent again after a failure
+// that left its outcome unknown. SET is only idempotent without a
+// condition or GET.
+func isIdempotent(args []interface{}) bool {
+	name := commandName(args)
+	if name == "SET" && len(args) > 3 {
+		for _, arg := range args[3:] {
+			switch strings.ToUpper(string(argBytes(arg))) {
+			case "NX", "XX", "GET":
+				return false
+			}
+		}
+	}
+	return readOnlyCommands[name] || idempotentWrites[name]
+}
+
+// breaker is a node's circuit breaker. After threshold consecutive
+// failures it opens for cooldown, then lets a single probe through and
+// closes again once one succeeds.
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow reports whether a command may be sent to the node
+func (b *breaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return true
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record counts a command's outcome. Only transient errors count as
+// failures; an error reply such as WRONGTYPE means the node is healthy.
+func (b *breaker) record(err error) {
+	if b.threshold <= 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	// A command abandoned by its caller says nothing about the node
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+	if err == nil || !retryable(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// pickNode returns the first node whose circuit breaker allows a command,
+// or nil if all are open
+func pickNode(nodes []*node) *node {
+	for _, n := range nodes {
+		if n.breaker.allow() {
+			return n
+		}
+	}
+	return nil
+}
+
+// doHedged sends a command to the preferred node. A read still unanswered
+// after the hedge delay is also sent to a replica, and the first
+// successful reply wins.
+func (c *Client) doHedged(ctx context.Context, args []interface{}) (interface{}, error) {
+	primary := pickNode(c.nodes)
+	delay := c.hedgeDelay()
+	if len(c.replicas) == 0 || !readOnlyCommands[commandName(args)] || (delay <= 0 && c.latencies == nil) {
+		return c.doNode(ctx, primary, args)
+	}
+	start := time.Now()
+	if delay <= 0 {
+		// Reads are timed until the percentile is known
+		reply, err := c.doNode(ctx, primary, args)
+		if err == nil {
+			c.latencies.record(time.Since(start))
+		}
+		return reply, err
+	}
+
+	// The losing request is abandoned when the winner returns
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		reply  interface{}
+		err    error
+		hedged bool
+	}
+	results := make(chan result, 2)
+	send := func(n *node, hedged bool) {
+		go func() {
+			reply, err := c.doNode(ctx, n, args)
+			results <- result{reply, err, hedged}
+		}()
+	}
+
+	send(primary, false)
+	pending := 1
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	hedge := timer.C
+	for {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil || pending == 0 {
+				if r.err == nil && c.latencies != nil {
+					// A hedge winning only bounds the primary's latency
+					// from below, which is what the percentile needs
+					c.latencies.record(time.Since(start))
+				}
+				if r.err == nil && r.hedged {
+					c.hedgesWon.Add(1)
+				}
+				return r.reply, r.err
+			}
+		case <-hedge:
+			hedge = nil
+			// Rotate over the replicas so hedges spread evenly
+			next := int(atomic.AddUint32(&c.replicaNext, 1))
+			for i := range c.replicas {
+				if n := c.replicas[(next+i)%len(c.replicas)]; n.breaker.allow() {
+					send(n, true)
+					c.hedged.Add(1)
+					pending++
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package client
+
+import (
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrNoHealthyNodes is returned when every node a key maps to is down
+var ErrNoHealthyNodes = errors.New("cache: no healthy node for key")
+
+// ErrCrossSlot is returned for a command whose keys map to different
+// nodes, which no single node can run atomically
+var ErrCrossSlot = errors.New("cache: keys map to different nodes")
+
+// ketamaPointsPerHash is how many ring points one MD5 digest yields, and
+// ketamaHashesPerNode how many digests an average weight node gets
+const (
+	ketamaPointsPerHash = 4
+	ketamaHashesPerNode = 40
+)
+
+// RingOptions configures a Ring
+type RingOptions struct {
+	// Options is used for every node's client. Its Addresses are ignored.
+	Options Options
+	// Nodes lists the node addresses. They name the nodes on the ring, so
+	// keep them identical across clients.
+	Nodes []string
+	// Weights gives nodes a larger share of the keys, defaulting to 1
+	Weights map[string]int
+	// Replicas is how many distinct nodes each write goes to, defaulting
+	// to 1. Reads go to the first healthy one.
+	Replicas int
+	// Hash places keys and nodes on the ring: HashKetama, the default,
+	// HashFNV or HashXXHash. Match the hash of an existing client to share
+	// its sharding; with FNV or xxHash, node points are the upper 32 bits
+	// of the hash of "address-index".
+	Hash string
+	// PartialResults makes MGet return the values it could read when some
+	// nodes fail, alongside a *PartialError naming the keys it could not,
+	// instead of no values at all
+	PartialResults bool
+	// HealthCheckInterval is how often nodes are pinged. Keys of a node
+	// that fails a check move to the next nodes on the ring until it
+	// recovers. Zero disables health checks.
+	HealthCheckInterval time.Duration
+}
+
+// ringPoint is a position on the continuum owned by a node
+type ringPoint struct {
+	hash uint32
+	node int
+}
+
+// Ring shards keys over independent nodes on the client side with a
+// ketama consistent hash ring, compatible with libmemcached and other
+// ketama clients given the same node names and weights. It is safe for
+// concurrent use.
+type Ring struct {
+	opts      RingOptions
+	clients   []*Client
+	continuum []ringPoint
+	keyHash   func([]byte) uint32
+
+	mu      sync.RWMutex
+	healthy []bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewRing creates a client for each node and starts health checks
+func NewRing(opts *RingOptions) (*Ring, error) {
+	if opts == nil || len(opts.Nodes) == 0 {
+		return nil, fmt.Errorf("cache: at least one node is required")
+	}
+
+	continuum, keyHash, err := buildContinuum(opts.Nodes, opts.Weights, opts.Hash)
+	if err != nil {
+		return nil, err
+	}
+	r := &Ring{opts: *opts, continuum: continuum, keyHash: keyHash, healthy: make([]bool, len(opts.Nodes))}
+	if r.opts.Replicas < 1 {
+		r.opts.Replicas = 1
+	}
+	if r.opts.Replicas > len(opts.Nodes) {
+		r.opts.Replicas = len(opts.Nodes)
+	}
+	for i, addr := range opts.Nodes {
+		nodeOpts := opts.Options
+		nodeOpts.Addresses = []string{addr}
+		c, err := NewClient(&nodeOpts)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.clients = append(r.clients, c)
+		r.healthy[i] = true
+	}
+
+	if opts.HealthCheckInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		r.cancel = cancel
+		r.done = make(chan struct{})
+		go r.checkHealth(ctx)
+	}
+	return r, nil
+}
+
+// buildContinuum builds the ring for the hash named, returning it with
+// the function placing keys on it
+func buildContinuum(nodes []string, weights map[string]int, hash string) ([]ringPoint, func([]byte) uint32, error) {
+	if hash == "" || hash == HashKetama {
+		return ketamaContinuum(nodes, weights), ketamaKeyHash, nil
+	}
+	h64, err := HashFunc(hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyHash := func(b []byte) uint32 { return uint32(h64(b) >> 32) }
+
+	var points []ringPoint
+	for i, addr := range nodes {
+		for k := 0; k < ketamaHashes(nodes, weights, addr)*ketamaPointsPerHash; k++ {
+			points = append(points, ringPoint{hash: keyHash([]byte(fmt.Sprintf("%s-%d", addr, k))), node: i})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+	return points, keyHash, nil
+}
+
+// ketamaHashes is how many digests addr gets on the ring: a share of
+// ketamaHashesPerNode per node in proportion to its weight
+func ketamaHashes(nodes []string, weights map[string]int, addr string) int {
+	weight := func(addr string) int {
+		if w, ok := weights[addr]; ok && w > 0 {
+			return w
+		}
+		return 1
+	}
+	total := 0
+	for _, node := range nodes {
+		total += weight(node)
+	}
+	share := float64(weight(addr)) / float64(total)
+	// The epsilon matches libmemcached's rounding
+	return int(math.Floor(share*ketamaHashesPerNode*float64(len(nodes)) + 0.0000000001))
+}
+
+// ketamaContinuum builds the ring the way libmemcached's ketama does:
+// each node gets points in proportion to its weight, four per MD5 digest
+// of "address-index"
+func ketamaContinuum(nodes []string, weights map[string]int) []ringPoint {
+	var points []ringPoint
+	for i, addr := range nodes {
+		for k := 0; k < ketamaHashes(nodes, weights, addr); k++ {
+			digest := md5.Sum([]byte(fmt.Sprintf("%s-%d", addr, k)))
+			for h := 0; h < ketamaPointsPerHash; h++ {
+				points = append(points, ringPoint{hash: ketamaHash(digest[h*4:]), node: i})
+			}
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].hash < points[j].hash })
+	return points
+}
+
+// ketamaHash reads a ring position from four bytes of a digest
+func ketamaHash(b []byte) uint32 {
+	return uint32(b[3])<<24 | uint32(b[2])<<16 | uint32(b[1])<<8 | uint32(b[0])
+}
+
+// ketamaKeyHash places a key on a ketama ring
+func ketamaKeyHash(key []byte) uint32 {
+	digest := md5.Sum(key)
+	return ketamaHash(digest[:])
+}
+
+// nodesFor returns up to n distinct healthy nodes for key, in ring order
+func (r *Ring) nodesFor(key string, n int) []int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return walkContinuum(r.continuum, r.keyHash, key, n, func(node int) bool { return r.healthy[node] })
+}
+
+// walkContinuum returns up to n distinct nodes for key that pass use, in
+// ring order
+func walkContinuum(continuum []ringPoint, keyHash func([]byte) uint32, key string, n int, use func(node int) bool) []int {
+	hash := keyHash([]byte(key))
+	start := sort.Search(len(continuum), func(i int) bool { return continuum[i].hash >= hash })
+
+	seen := make(map[int]bool, n)
+	var nodes []int
+	for i := 0; i < len(continuum) && len(nodes) < n; i++ {
+		node := continuum[(start+i)%len(continuum)].node
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		if use(node) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// RingLayout places keys on nodes exactly as a Ring with the same nodes,
+// weights and hash does, without connecting to them, so tools can plan
+// where data belongs
+type RingLayout struct {
+	nodes     []string
+	continuum []ringPoint
+	keyHash   func([]byte) uint32
+}
+
+// NewRingLayout creates the layout of a ketama ring of nodes, with weights
+// as in RingOptions
+func NewRingLayout(nodes []string, weights map[string]int) *RingLayout {
+	return &RingLayout{nodes: nodes, continuum: ketamaContinuum(nodes, weights), keyHash: ketamaKeyHash}
+}
+
+// NewRingLayoutHash is NewRingLayout for a ring using hash, as in
+// RingOptions.Hash
+func NewRingLayoutHash(nodes []string, weights map[string]int, hash string) (*RingLayout, error) {
+	continuum, keyHash, err := buildContinuum(nodes, weights, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &RingLayout{nodes: nodes, continuum: continuum, keyHash: keyHash}, nil
+}
+
+// Owners returns the addresses of the first n distinct nodes key maps to,
+// the first being where a Ring reads it
+func (l *RingLayout) Owners(key string, n int) []string {
+	nodes := walkContinuum(l.continuum, l.keyHash, key, n, func(int) bool { return true })
+	owners := make([]string, len(nodes))
+	for i, node := range nodes {
+		owners[i] = l.nodes[node]
+	}
+	return owners
+}
+
+// NodeFor returns the address of the node that reads of key go to
+func (r *Ring) NodeFor(key string) (string, error) {
+	nodes := r.nodesFor(key, 1)
+	if len(nodes) == 0 {
+		return "", ErrNoHealthyNodes
+	}
+	return r.opts.Nodes[nodes[0]], nil
+}
+
+// Do sends a command about key to the first healthy node it maps to
+func (r *Ring) Do(ctx context.Context, key string, args ...interface{}) (interface{}, error) {
+	nodes := r.nodesFor(key, 1)
+	if len(nodes) == 0 {
+		return nil, ErrNoHealthyNodes
+	}
+	return r.clients[nodes[0]].Do(ctx, args...)
+}
+
+// DoAll sends a write about key to each of its Replicas healthy nodes
+// and returns the first node's reply. It fails if any node fails.
+func (r *Ring) DoAll(ctx context.Context, key string, args ...interface{}) (interface{}, error) {
+	nodes := r.nodesFor(key, r.opts.Replicas)
+	if len(nodes) == 0 {
+		return nil, ErrNoHealthyNodes
+	}
+
+	replies := make([]interface{}, len(nodes))
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func(i, node int) {
+			defer wg.Done()
+			replies[i], errs[i] = r.clients[node].Do(ctx, args...)
+		}(i, node)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("cache: node %s: %w", r.opts.Nodes[nodes[i]], err)
+		}
+	}
+	return replies[0], nil
+}
+
+// Get returns the value stored at key, or ErrNil if it does not exist
+func (r *Ring) Get(ctx context.Context, key string) ([]byte, error) {
+	reply, err := r.Do(ctx, key, "GET", key)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrNil
+	}
+	b, ok := reply.([]byte)
+	if !ok {
+		return nil, fmt.Errorf("cache: unexpected GET reply %T", reply)
+	}
+	return b, nil
+}
+
+// Set stores value at key on each of its nodes. A zero ttl means the key
+// does not expire.
+func (r *Ring) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	args := []interface{}{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", int64(ttl/time.Millisecond))
+	}
+	_, err := r.DoAll(ctx, key, args...)
+	return err
+}
+
+// Del removes key from each of its nodes
+func (r *Ring) Del(ctx context.Context, key string) error {
+	_, err := r.DoAll(ctx, key, "DEL", key)
+	return err
+}
+
+// Rename moves the value at src to dst on each of their nodes, replacing
+// any value at dst. Both keys must map to the same nodes; otherwise it
+// fails with ErrCrossSlot and nothing is moved.
+func (r *Ring) Rename(ctx context.Context, src, dst string) error {
+	if err := r.sameNodes(src, dst); err != nil {
+		return err
+	}
+	_, err := r.DoAll(ctx, src, "RENAME", src, dst)
+	return err
+}
+
+// RenameNX is Rename that only moves the value if dst does not exist, and
+// reports whether it did
+func (r *Ring) RenameNX(ctx context.Context, src, dst string) (bool, error) {
+	if err := r.sameNodes(src, dst); err != nil {
+		return false, err
+	}
+	reply, err := r.DoAll(ctx, src, "RENAMENX", src, dst)
+	if err != nil {
+		return false, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return false, fmt.Errorf("cache: unexpected RENAMENX reply %T", reply)
+	}
+	return n == 1, nil
+}
+
+// sameNodes fails with ErrCrossSlot unless a and b map to the same nodes
+func (r *Ring) sameNodes(a, b string) error {
+	nodesA, nodesB := r.nodesFor(a, r.opts.Replicas), r.nodesFor(b, r.opts.Replicas)
+	if len(nodesA) != len(nodesB) {
+		return ErrCrossSlot
+	}
+	for i := range nodesA {
+		if nodesA[i] != nodesB[i] {
+			return ErrCrossSlot
+		}
+	}
+	return nil
+}
+
+// Healthy returns the addresses of the nodes passing health checks
+func (r *Ring) Healthy() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var nodes []string
+	for i, ok := range r.healthy {
+		if ok {
+			nodes = append(nodes, r.opts.Nodes[i])
+		}
+	}
+	return nodes
+}
+
+// checkHealth pings every node each HealthCheckInterval
+func (r *Ring) checkHealth(ctx context.Context) {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.opts.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		healthy := make([]bool, len(r.clients))
+		var wg sync.WaitGroup
+		for i, c := range r.clients {
+			wg.Add(1)
+			go func(i int, c *Client) {
+				defer wg.Done()
+				pingCtx, cancel := context.WithTimeout(ctx, r.opts.HealthCheckInterval)
+				defer cancel()
+				_, err := c.Do(pingCtx, "PING")
+				healthy[i] = err == nil
+			}(i, c)
+		}
+		wg.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+
+		r.mu.Lock()
+		r.healthy = healthy
+		r.mu.Unlock()
+	}
+}
+
+// Close stops health checks and closes every node's client
+func (r *Ring) Close() error {
+	if r.cancel != nil {
+		r.cancel()
+		<-r.done
+	}
+	for _, c := range r.clients {
+		c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PartialError is returned by a Ring's MGet and MSet when some of the
+// nodes they fanned out to failed. The keys on the other nodes were read
+// or written.
+type PartialError struct {
+	// Failed maps each key that was not read or written to why
+	Failed map[string]error
+}
+
+func (e *PartialError) Error() string {
+	keys := make([]string, 0, len(e.Failed))
+	for key := range e.Failed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = fmt.Sprintf("%s: %v", key, e.Failed[key])
+	}
+	return "cache: multi-key command partially failed, " + strings.Join(parts, "; ")
+}
+
+// fanOut runs one command per node in parallel, built by command from the
+// indexes of the keys that node holds, and returns each node's reply and
+// error
+func (r *Ring) fanOut(ctx context.Context, groups map[int][]int, command func(idx []int) []interface{}) (map[int]interface{}, map[int]error) {
+	replies := make(map[int]interface{}, len(groups))
+	errs := make(map[int]error, len(groups))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for node, idx := range groups {
+		wg.Add(1)
+		go func(node int, idx []int) {
+			defer wg.Done()
+			reply, err := r.clients[node].Do(ctx, command(idx)...)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[node] = fmt.Errorf("cache: node %s: %w", r.opts.Nodes[node], err)
+			} else {
+				replies[node] = reply
+			}
+		}(node, idx)
+	}
+	wg.Wait()
+	return replies, errs
+}
+
+// MGet returns the values of keys in order, with nil for missing keys.
+// Keys are grouped by the node reads of them go to and each node is sent
+// one MGET, all in parallel. If a node fails, MGet returns a
+// *PartialError naming its keys, and no values unless
+// RingOptions.PartialResults is set.
+func (r *Ring) MGet(ctx context.Context, keys ...string) ([][]byte, error) {
+	failed := make(map[string]error)
+	groups := make(map[int][]int)
+	for i, key := range keys {
+		nodes := r.nodesFor(key, 1)
+		if len(nodes) == 0 {
+			failed[key] = ErrNoHealthyNodes
+			continue
+		}
+		groups[nodes[0]] = append(groups[nodes[0]], i)
+	}
+
+	replies, errs := r.fanOut(ctx, groups, func(idx []int) []interface{} {
+		args := make([]interface{}, 0, len(idx)+1)
+		args = append(args, "MGET")
+		for _, i := range idx {
+			args = append(args, keys[i])
+		}
+		return args
+	})
+
+	values := make([][]byte, len(keys))
+	for node, idx := range groups {
+		if err, ok := errs[node]; ok {
+			for _, i := range idx {
+				failed[keys[i]] = err
+			}
+			continue
+		}
+		items, ok := replies[node].([]interface{})
+		if !ok || len(items) != len(idx) {
+			err := fmt.Errorf("cache: node %s: unexpected MGET reply %T", r.opts.Nodes[node], replies[node])
+			for _, i := range idx {
+				failed[keys[i]] = err
+			}
+			continue
+		}
+		for j, i := range idx {
+			values[i], _ = items[j].([]byte)
+		}
+	}
+
+	if len(failed) > 0 {
+		if !r.opts.PartialResults {
+			values = nil
+		}
+		return values, &PartialError{Failed: failed}
+	}
+	return values, nil
+}
+
+// MSet stores several keys at once, each on its Replicas healthy nodes.
+// Keys are grouped by node and each node is sent one MSET, all in
+// parallel, so the write is atomic on each node but not across them: if a
+// node fails, MSet returns a *PartialError naming its keys and the other
+// nodes keep their writes.
+func (r *Ring) MSet(ctx context.Context, values map[string]interface{}) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	failed := make(map[string]error)
+	groups := make(map[int][]int)
+	for i, key := range keys {
+		nodes := r.nodesFor(key, r.opts.Replicas)
+		if len(nodes) == 0 {
+			failed[key] = ErrNoHealthyNodes
+			continue
+		}
+		for _, node := range nodes {
+			groups[node] = append(groups[node], i)
+		}
+	}
+
+	_, errs := r.fanOut(ctx, groups, func(idx []int) []interface{} {
+		args := make([]interface{}, 0, 2*len(idx)+1)
+		args = append(args, "MSET")
+		for _, i := range idx {
+			args = append(args, keys[i], values[keys[i]])
+		}
+		return args
+	})
+	for node, err := range errs {
+		for _, i := range groups[node] {
+			failed[keys[i]] = err
+		}
+	}
+
+	if len(failed) > 0 {
+		return &PartialError{Failed: failed}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTxTimeout is how long nodes hold a transaction's keys locked
+// between prepare and commit, unless Tx.Timeout says otherwise
+const DefaultTxTimeout = 5 * time.Second
+
+// ErrTxAborted is returned when a node refused to prepare a transaction.
+// No node applied any of it.
+var ErrTxAborted = errors.New("cache: transaction aborted")
+
+// TxCommitError is returned when a transaction prepared everywhere but
+// some nodes failed to commit it, typically because their prepare timed
+// out. The other nodes applied their writes.
+type TxCommitError struct {
+	// Failed maps the address of each node that did not commit to why
+	Failed map[string]error
+}
+
+func (e *TxCommitError) Error() string {
+	addrs := make([]string, 0, len(e.Failed))
+	for addr := range e.Failed {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	parts := make([]string, len(addrs))
+	for i, addr := range addrs {
+		parts[i] = fmt.Sprintf("%s: %v", addr, e.Failed[addr])
+	}
+	return "cache: transaction partially committed, failed on " + strings.Join(parts, "; ")
+}
+
+// Tx is a set of writes applied atomically across a Ring's nodes with
+// two-phase commit. Every node holding a key first locks it and checks
+// the writes, and only once all have done so are the writes applied.
+// Writes by others to the locked keys fail with TRYAGAIN meanwhile.
+//
+// Keys that can share a node should be kept together instead where
+// possible, as a transaction costs two round trips to each node.
+type Tx struct {
+	// Timeout bounds how long nodes keep the keys locked waiting for the
+	// commit, defaulting to DefaultTxTimeout
+	Timeout time.Duration
+
+	r    *Ring
+	cmds []txCommand
+}
+
+type txCommand struct {
+	key  string
+	args []interface{}
+}
+
+// txPlan is one node's share of a transaction
+type txPlan struct {
+	node int
+	// cmds are the indexes of the transaction's commands the node runs
+	cmds []int
+	args []interface{}
+}
+
+// Tx starts a transaction
+func (r *Ring) Tx() *Tx {
+	return &Tx{r: r}
+}
+
+// Queue adds a write command about key to the transaction
+func (tx *Tx) Queue(key string, args ...interface{}) {
+	tx.cmds = append(tx.cmds, txCommand{key: key, args: args})
+}
+
+// Exec runs the transaction, returning the reply of each queued command
+// in order. Replies of commands that failed are Error values. It returns
+// an error wrapping ErrTxAborted if nothing was applied, or a
+// *TxCommitError if only some nodes applied their writes.
+func (tx *Tx) Exec(ctx context.Context) ([]interface{}, error) {
+	if len(tx.cmds) == 0 {
+		return nil, nil
+	}
+	timeout := tx.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTxTimeout
+	}
+	id, err := txID()
+	if err != nil {
+		return nil, err
+	}
+
+	// Every replica of a key takes part, and the first answers for it
+	plans := make(map[int]*txPlan)
+	primary := make([]int, len(tx.cmds))
+	for i, cmd := range tx.cmds {
+		nodes := tx.r.nodesFor(cmd.key, tx.r.opts.Replicas)
+		if len(nodes) == 0 {
+			return nil, ErrNoHealthyNodes
+		}
+		primary[i] = nodes[0]
+		for _, node := range nodes {
+			plan := plans[node]
+			if plan == nil {
+				plan = &txPlan{node: node, args: []interface{}{"TXN.PREPARE", id, int64(timeout / time.Millisecond)}}
+				plans[node] = plan
+			}
+			plan.cmds = append(plan.cmds, i)
+			plan.args = append(plan.args, len(cmd.args))
+			plan.args = append(plan.args, cmd.args...)
+		}
+	}
+
+	prepared := tx.eachNode(ctx, plans, func(plan *txPlan) (interface{}, error) {
+		return tx.r.clients[plan.node].Do(ctx, plan.args...)
+	})
+	for node, result := range prepared {
+		if result.err != nil {
+			tx.eachNode(ctx, plans, func(plan *txPlan) (interface{}, error) {
+				return tx.r.clients[plan.node].Do(ctx, "TXN.ABORT", id)
+			})
+			return nil, fmt.Errorf("%w: node %s: %v", ErrTxAborted, tx.r.opts.Nodes[node], result.err)
+		}
+	}
+
+	committed := tx.eachNode(ctx, plans, func(plan *txPlan) (interface{}, error) {
+		return tx.r.clients[plan.node].Do(ctx, "TXN.COMMIT", id)
+	})
+	replies := make([]interface{}, len(tx.cmds))
+	var commitErr *TxCommitError
+	for node, result := range committed {
+		values, ok := result.reply.([]interface{})
+		if result.err == nil && (!ok || len(values) != len(plans[node].cmds)) {
+			result.err = fmt.Errorf("unexpected TXN.COMMIT reply %T", result.reply)
+		}
+		if result.err != nil {
+			if commitErr == nil {
+				commitErr = &TxCommitError{Failed: make(map[string]error)}
+			}
+			commitErr.Failed[tx.r.opts.Nodes[node]] = result.err
+			continue
+		}
+		for j, i := range plans[node].cmds {
+			if primary[i] == node {
+				replies[i] = values[j]
+			}
+		}
+	}
+	if commitErr != nil {
+		return replies, commitErr
+	}
+	return replies, nil
+}
+
+// txResult is one node's reply in a phase of a transaction
+type txResult struct {
+	reply interface{}
+	err   error
+}
+
+// eachNode runs a phase of the transaction on every node in parallel
+func (tx *Tx) eachNode(ctx context.Context, plans map[int]*txPlan, fn func(plan *txPlan) (interface{}, error)) map[int]txResult {
+	results := make(map[int]txResult, len(plans))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, plan := range plans {
+		wg.Add(1)
+		go func(plan *txPlan) {
+			defer wg.Done()
+			reply, err := fn(plan)
+			mu.Lock()
+			results[plan.node] = txResult{reply: reply, err: err}
+			mu.Unlock()
+		}(plan)
+	}
+	wg.Wait()
+	return results
+}
+
+// txID returns a random transaction ID
+func txID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
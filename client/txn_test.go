@@ -0,0 +1,112 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+	"github.com/hamisionesmus/distributed-cache/client"
+	"github.com/hamisionesmus/distributed-cache/server"
+)
+
+// startNode serves a new cache on a local port until the test ends
+func startNode(t *testing.T) (*cache.Cache, string) {
+	t.Helper()
+	c, err := cache.NewCacheFromConfig(cache.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := server.NewTCPServer(c, log.New(io.Discard, "", 0))
+	go s.Serve(listener)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return c, listener.Addr().String()
+}
+
+// newTxRing returns a ring of two nodes and keys placed one on each
+func newTxRing(t *testing.T) (*client.Ring, []*cache.Cache, []string) {
+	t.Helper()
+	first, firstAddr := startNode(t)
+	second, secondAddr := startNode(t)
+	nodes := []string{firstAddr, secondAddr}
+	r, err := client.NewRing(&client.RingOptions{Nodes: nodes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+
+	layout := client.NewRingLayout(nodes, nil)
+	keys := make([]string, 2)
+	for i := 0; keys[0] == "" || keys[1] == ""; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		if layout.Owners(key, 1)[0] == firstAddr {
+			keys[0] = key
+		} else {
+			keys[1] = key
+		}
+	}
+	return r, []*cache.Cache{first, second}, keys
+}
+
+func TestTxCommitsOnEveryNode(t *testing.T) {
+	ctx := context.Background()
+	r, caches, keys := newTxRing(t)
+
+	tx := r.Tx()
+	tx.Queue(keys[0], "SET", keys[0], "a")
+	tx.Queue(keys[1], "SET", keys[1], "b")
+	tx.Queue(keys[1], "SADD", keys[1], "c")
+	replies, err := tx.Exec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 3 || replies[0] != "OK" || replies[1] != "OK" {
+		t.Fatalf("Exec replied %v", replies)
+	}
+	if _, ok := replies[2].(client.Error); !ok {
+		t.Errorf("SADD to a string replied %v, want an Error", replies[2])
+	}
+	for i, want := range []string{"a", "b"} {
+		if value, _ := caches[i].Get(ctx, keys[i]); string(value) != want {
+			t.Errorf("%s = %q, want %q", keys[i], value, want)
+		}
+	}
+}
+
+// TestTxAbortsOnConflict locks a key on one node with another transaction
+// and checks the ring's transaction applies nothing on either node
+func TestTxAbortsOnConflict(t *testing.T) {
+	ctx := context.Background()
+	r, caches, keys := newTxRing(t)
+	if err := caches[1].LockKeys("other", []string{keys[1]}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := r.Tx()
+	tx.Queue(keys[0], "SET", keys[0], "a")
+	tx.Queue(keys[1], "SET", keys[1], "b")
+	if _, err := tx.Exec(ctx); !errors.Is(err, client.ErrTxAborted) {
+		t.Fatalf("Exec = %v, want ErrTxAborted", err)
+	}
+	for i := range keys {
+		if _, ok := caches[i].Get(ctx, keys[i]); ok {
+			t.Errorf("an aborted transaction wrote %s", keys[i])
+		}
+	}
+	if err := caches[0].CheckKeyLocks(keys[0]); err != nil {
+		t.Errorf("the aborted transaction left %s locked", keys[0])
+	}
+}
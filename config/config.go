@@ -0,0 +1,333 @@
+// Package config loads the server configuration from a file, flags and
+// the environment
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hamisionesmus/distributed-cache/cache"
+	"github.com/hamisionesmus/distributed-cache/metrics"
+	"github.com/hamisionesmus/distributed-cache/server"
+	"gopkg.in/yaml.v2"
+)
+
+// Config represents the application configuration
+type Config struct {
+	Server    server.Config         `json:"server" toml:"server" yaml:"server"`
+	Cache     cache.Config          `json:"cache" toml:"cache" yaml:"cache"`
+	Cluster   cache.ClusterConfig   `json:"cluster" toml:"cluster" yaml:"cluster"`
+	Storage   cache.StorageConfig   `json:"storage" toml:"storage" yaml:"storage"`
+	Scheduler cache.SchedulerConfig `json:"scheduler" toml:"scheduler" yaml:"scheduler"`
+	CDC       cache.CDCConfig       `json:"cdc" toml:"cdc" yaml:"cdc"`
+	Metrics   metrics.Config        `json:"metrics" toml:"metrics" yaml:"metrics"`
+	Security  server.SecurityConfig `json:"security" toml:"security" yaml:"security"`
+	Logging   LoggingConfig         `json:"logging" toml:"logging" yaml:"logging"`
+}
+
+// LoggingConfig holds logging configuration
+type LoggingConfig struct {
+	Level    string `json:"level" toml:"level" yaml:"level"`
+	Format   string `json:"format" toml:"format" yaml:"format"`
+	Output   string `json:"output" toml:"output" yaml:"output"`
+	File     string `json:"file" toml:"file" yaml:"file"`
+	MaxSize  int64  `json:"max_size" toml:"max_size" yaml:"max_size"`
+	MaxFiles int    `json:"max_files" toml:"max_files" yaml:"max_files"`
+	Compress bool   `json:"compress" toml:"compress" yaml:"compress"`
+}
+
+// DefaultConfig returns a default configuration
+func DefaultConfig() *Config {
+	return &Config{
+		Server:   server.DefaultConfig(),
+		Cache:    cache.DefaultConfig(),
+		Cluster:  cache.DefaultClusterConfig(),
+		Storage:  cache.DefaultStorageConfig(),
+		CDC:      cache.DefaultCDCConfig(),
+		Metrics:  metrics.DefaultConfig(),
+		Security: server.DefaultSecurityConfig(),
+		Logging: LoggingConfig{
+			Level:    "info",
+			Format:   "json",
+			Output:   "stdout",
+			MaxSize:  100 * 1024 * 1024, // 100MB
+			MaxFiles: 5,
+			Compress: true,
+		},
+	}
+}
+
+// LoadConfig loads configuration from file and command line flags
+func LoadConfig() (*Config, error) {
+	config := DefaultConfig()
+
+	// Parse command line flags
+	var configFile string
+	flag.StringVar(&configFile, "config", "", "Path to configuration file")
+	flag.StringVar(&config.Server.Host, "host", config.Server.Host, "Server host")
+	flag.IntVar(&config.Server.Port, "port", config.Server.Port, "Server port")
+	flag.IntVar(&config.Server.HTTPPort, "http-port", config.Server.HTTPPort, "HTTP server port")
+	flag.Int64Var(&config.Cache.MaxMemory, "max-memory", config.Cache.MaxMemory, "Maximum memory usage, 0 to detect from the cgroup limit")
+	flag.BoolVar(&config.Cluster.Enabled, "cluster", config.Cluster.Enabled, "Enable clustering")
+	flag.BoolVar(&config.Storage.SkipChecksum, "skip-checksum", config.Storage.SkipChecksum, "Load snapshots that fail checksum verification")
+	flag.Parse()
+
+	// Load from file if specified
+	if configFile != "" {
+		if err := loadFromFile(config, configFile); err != nil {
+			return nil, fmt.Errorf("failed to load config from file: %w", err)
+		}
+	}
+
+	// Override with environment variables
+	loadFromEnv(config)
+
+	if err := config.Cache.ResolveMaxMemory(); err != nil {
+		return nil, err
+	}
+	cache.AlignGOMAXPROCS()
+	config.Cache.ResolveShardCount()
+
+	// Validate configuration
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return config, nil
+}
+
+// loadFromFile loads configuration from a file
+func loadFromFile(config *Config, filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case strings.HasSuffix(filename, ".json"):
+		return json.Unmarshal(data, config)
+	case strings.HasSuffix(filename, ".toml"):
+		return toml.Unmarshal(data, config)
+	case strings.HasSuffix(filename, ".yaml"), strings.HasSuffix(filename, ".yml"):
+		return yaml.Unmarshal(data, config)
+	default:
+		return fmt.Errorf("unsupported config file format")
+	}
+}
+
+// loadFromEnv loads configuration from environment variables
+func loadFromEnv(config *Config) {
+	// Server config
+	if v := os.Getenv("CACHE_HOST"); v != "" {
+		config.Server.Host = v
+	}
+	if v := os.Getenv("CACHE_PORT"); v != "" {
+		if port, err := strconv.Atoi(v); err == nil {
+			config.Server.Port = port
+		}
+	}
+	if v := os.Getenv("CACHE_HTTP_PORT"); v != "" {
+		if port, err := strconv.Atoi(v); err == nil {
+			config.Server.HTTPPort = port
+		}
+	}
+	loadListenersFromEnv(config)
+	if v := os.Getenv("CACHE_COMMAND_TIMEOUT"); v != "" {
+		if timeout, err := time.ParseDuration(v); err == nil {
+			config.Server.CommandTimeout = timeout
+		}
+	}
+
+	// Cache config
+	if v := os.Getenv("CACHE_MAX_MEMORY"); v != "" {
+		if strings.EqualFold(v, cache.MaxMemoryAuto) {
+			config.Cache.MaxMemory = 0
+		} else if mem, err := strconv.ParseInt(v, 10, 64); err == nil {
+			config.Cache.MaxMemory = mem
+		}
+	}
+	if v := os.Getenv("CACHE_SHARD_COUNT"); v != "" {
+		if shards, err := strconv.Atoi(v); err == nil {
+			config.Cache.ShardCount = shards
+		}
+	}
+	if v := os.Getenv("CACHE_SHARD_HASH"); v != "" {
+		config.Cache.ShardHash = v
+	}
+	if v := os.Getenv("CACHE_MAX_MEMORY_FRACTION"); v != "" {
+		if fraction, err := strconv.ParseFloat(v, 64); err == nil {
+			config.Cache.MaxMemoryFraction = fraction
+		}
+	}
+	if v := os.Getenv("CACHE_IDLE_TIMEOUT"); v != "" {
+		if idle, err := time.ParseDuration(v); err == nil {
+			config.Cache.IdleTimeout = idle
+		}
+	}
+
+	// Cluster config
+	if v := os.Getenv("CACHE_CLUSTER_ENABLED"); v != "" {
+		if enabled, err := strconv.ParseBool(v); err == nil {
+			config.Cluster.Enabled = enabled
+		}
+	}
+	if v := os.Getenv("CACHE_CLUSTER_SEEDS"); v != "" {
+		config.Cluster.Seeds = strings.Split(v, ",")
+	}
+	if v := os.Getenv("CACHE_CLUSTER_ADVERTISE_ADDR"); v != "" {
+		config.Cluster.AdvertiseAddr = v
+	}
+	if v := os.Getenv("CACHE_CLUSTER_PARTITION"); v != "" {
+		if partition, err := strconv.ParseBool(v); err == nil {
+			config.Cluster.Partition = partition
+		}
+	}
+	if v := os.Getenv("CACHE_CLUSTER_VIRTUAL_NODES"); v != "" {
+		if vnodes, err := strconv.Atoi(v); err == nil {
+			config.Cluster.VirtualNodes = vnodes
+		}
+	}
+	if v := os.Getenv("CACHE_CLUSTER_USERNAME"); v != "" {
+		config.Cluster.Username = v
+	}
+	if v := os.Getenv("CACHE_CLUSTER_PASSWORD"); v != "" {
+		config.Cluster.Password = v
+	}
+	if v := os.Getenv("CACHE_CLUSTER_WITNESS"); v != "" {
+		if witness, err := strconv.ParseBool(v); err == nil {
+			config.Cluster.Witness = witness
+		}
+	}
+	if v := os.Getenv("CACHE_MAX_STALENESS"); v != "" {
+		if staleness, err := time.ParseDuration(v); err == nil {
+			config.Cluster.MaxStaleness = staleness
+		}
+	}
+	if v := os.Getenv("CACHE_MIRROR_ADDRESSES"); v != "" {
+		config.Cluster.MirrorAddresses = strings.Split(v, ",")
+	}
+	if v := os.Getenv("CACHE_IDGEN_NODE"); v != "" {
+		if node, err := strconv.Atoi(v); err == nil {
+			config.Cluster.IDGenNode = node
+		}
+	}
+
+	// CDC config
+	if v := os.Getenv("CACHE_CDC_BACKEND"); v != "" {
+		config.CDC.Backend = v
+	}
+	if v := os.Getenv("CACHE_CDC_BROKERS"); v != "" {
+		config.CDC.Brokers = strings.Split(v, ",")
+	}
+	if v := os.Getenv("CACHE_CDC_TOPIC"); v != "" {
+		config.CDC.Topic = v
+	}
+
+	if v := os.Getenv("CACHE_METRICS_TOKEN"); v != "" {
+		config.Metrics.AuthToken = v
+	}
+
+	// Security config
+	if v := os.Getenv("CACHE_AUTH_ENABLED"); v != "" {
+		if enabled, err := strconv.ParseBool(v); err == nil {
+			config.Security.EnableAuth = enabled
+		}
+	}
+	if v := os.Getenv("CACHE_JWT_SECRET"); v != "" {
+		config.Security.JWTSecret = v
+	}
+	if v := os.Getenv("VAULT_ADDR"); v != "" {
+		config.Security.VaultAddress = v
+	}
+	if v := os.Getenv("CACHE_REQUIREPASS"); v != "" {
+		config.Security.RequirePass = v
+	}
+	if v := os.Getenv("CACHE_ACL_FILE"); v != "" {
+		config.Security.EnableACL = true
+		config.Security.ACLFile = v
+	}
+}
+
+// Validate validates the configuration
+func (c *Config) Validate() error {
+	if err := c.Server.Validate(); err != nil {
+		return err
+	}
+	if err := c.validateListeners(); err != nil {
+		return err
+	}
+	if err := c.Cache.Validate(); err != nil {
+		return err
+	}
+	if err := c.Storage.Validate(); err != nil {
+		return err
+	}
+	if err := c.Scheduler.Validate(); err != nil {
+		return err
+	}
+	if err := c.Cluster.Validate(); err != nil {
+		return err
+	}
+	if err := c.CDC.Validate(); err != nil {
+		return err
+	}
+	if err := c.Security.Validate(); err != nil {
+		return err
+	}
+	// Secret references outside the security settings
+	for _, ref := range []string{c.Metrics.AuthToken, c.Cluster.Password} {
+		if err := server.ValidateSecretRef(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Save saves the configuration to a file
+func (c *Config) Save(filename string) error {
+	var data []byte
+	var err error
+
+	switch {
+	case strings.HasSuffix(filename, ".json"):
+		data, err = json.MarshalIndent(c, "", "  ")
+	case strings.HasSuffix(filename, ".toml"):
+		data, err = toml.Marshal(*c)
+	case strings.HasSuffix(filename, ".yaml"), strings.HasSuffix(filename, ".yml"):
+		data, err = yaml.Marshal(c)
+	default:
+		return fmt.Errorf("unsupported config file format")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// String returns a string representation of the configuration
+func (c *Config) String() string {
+	data, _ := json.MarshalIndent(c, "", "  ")
+	return string(data)
+}
+
+// ClusterAddr returns the address this node advertises to its peers
+func (c *Config) ClusterAddr() string {
+	if c.Cluster.AdvertiseAddr != "" {
+		return c.Cluster.AdvertiseAddr
+	}
+	host := c.Server.Host
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host, _ = os.Hostname()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(c.Server.Port))
+}
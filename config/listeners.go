@@ -0,0 +1,108 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hamisionesmus/distributed-cache/server"
+)
+
+// Listeners returns the resolved configuration of every front-end, in the
+// order they are started. The HTTP listener is only enabled if EnableHTTP
+// is also set, and the metrics listener only if metrics are.
+func (c *Config) Listeners() []server.NamedListener {
+	l := c.Server.Listeners
+	l.HTTP.Enabled = l.HTTP.Enabled && c.Server.EnableHTTP
+	l.Metrics.Enabled = l.Metrics.Enabled && c.Metrics.Enabled
+	for _, fallback := range []struct {
+		listener *server.ListenerConfig
+		port     int
+		tls      bool
+	}{
+		{&l.RESP, c.Server.Port, true},
+		{&l.HTTP, c.Server.HTTPPort, true},
+		{&l.Metrics, c.Metrics.PrometheusPort, false},
+	} {
+		if fallback.listener.Port == 0 {
+			fallback.listener.Port = fallback.port
+		}
+		if fallback.tls && !fallback.listener.EnableTLS && c.Server.EnableTLS {
+			fallback.listener.EnableTLS = true
+			fallback.listener.TLSCertFile = c.Server.TLSCertFile
+			fallback.listener.TLSKeyFile = c.Server.TLSKeyFile
+		}
+	}
+
+	all := []server.NamedListener{
+		{Name: server.ListenerRESP, ListenerConfig: l.RESP},
+		{Name: server.ListenerHTTP, ListenerConfig: l.HTTP},
+		{Name: server.ListenerMetrics, ListenerConfig: l.Metrics},
+		{Name: server.ListenerGRPC, ListenerConfig: l.GRPC},
+		{Name: server.ListenerMemcached, ListenerConfig: l.Memcached},
+	}
+	for i := range all {
+		if all[i].Host == "" {
+			all[i].Host = c.Server.Host
+		}
+	}
+	return all
+}
+
+// validateListeners checks the enabled listeners' ports and TLS settings,
+// and that no two of them bind the same address
+func (c *Config) validateListeners() error {
+	bound := make(map[string]string)
+	for _, l := range c.Listeners() {
+		if !l.Enabled {
+			continue
+		}
+		if l.Name == server.ListenerMemcached && (c.Security.EnableACL || c.Security.RequirePass != "") {
+			return errors.New("the memcached front-end cannot authenticate clients, so it cannot be used with a password or ACLs")
+		}
+		if l.Port < 1 || l.Port > 65535 {
+			return fmt.Errorf("invalid %s listener port: %d", l.Name, l.Port)
+		}
+		if l.EnableTLS && (l.TLSCertFile == "" || l.TLSKeyFile == "") {
+			return fmt.Errorf("%s listener TLS needs a certificate and a key", l.Name)
+		}
+		if other, ok := bound[l.Addr()]; ok {
+			return fmt.Errorf("%s and %s listeners both bind %s", other, l.Name, l.Addr())
+		}
+		bound[l.Addr()] = l.Name
+	}
+	return nil
+}
+
+// loadListenersFromEnv applies CACHE_<NAME>_ENABLED and CACHE_<NAME>_PORT
+// for every front-end. CACHE_HTTP_PORT is read with the server settings.
+func loadListenersFromEnv(config *Config) {
+	listeners := map[string]*server.ListenerConfig{
+		server.ListenerRESP:      &config.Server.Listeners.RESP,
+		server.ListenerHTTP:      &config.Server.Listeners.HTTP,
+		server.ListenerMetrics:   &config.Server.Listeners.Metrics,
+		server.ListenerGRPC:      &config.Server.Listeners.GRPC,
+		server.ListenerMemcached: &config.Server.Listeners.Memcached,
+	}
+	for name, listener := range listeners {
+		prefix := "CACHE_" + strings.ToUpper(name) + "_"
+		if v := os.Getenv(prefix + "ENABLED"); v != "" {
+			if enabled, err := strconv.ParseBool(v); err == nil {
+				listener.Enabled = enabled
+				if name == server.ListenerHTTP {
+					config.Server.EnableHTTP = enabled
+				}
+			}
+		}
+		if name == server.ListenerRESP || name == server.ListenerHTTP {
+			continue
+		}
+		if v := os.Getenv(prefix + "PORT"); v != "" {
+			if port, err := strconv.Atoi(v); err == nil {
+				listener.Port = port
+			}
+		}
+	}
+}
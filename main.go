@@ -2,180 +2,162 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
-	"net"
 	"os"
-	"os/signal"
-	"strconv"
-	"sync"
-	"syscall"
 	"time"
 
-	"github.com/hamisionesmus/project4/cache"
-	"github.com/hamisionesmus/project4/server"
+	"github.com/hamisionesmus/distributed-cache/cache"
+	"github.com/hamisionesmus/distributed-cache/config"
+	"github.com/hamisionesmus/distributed-cache/metrics"
+	"github.com/hamisionesmus/distributed-cache/server"
 )
 
 func main() {
-	// Parse command line flags
-	config := parseFlags()
+	// Offline tools run instead of the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "aof-check":
+			os.Exit(cache.RunAOFCheck(os.Args[2:]))
+		case "migrate-from-redis":
+			os.Exit(runMigrateFromRedis(os.Args[2:]))
+		case "acl-hashpass":
+			os.Exit(server.RunACLHashPass(os.Args[2:]))
+		case "restore-cluster":
+			os.Exit(cache.RunRestoreCluster(os.Args[2:]))
+		case "export":
+			os.Exit(cache.RunExport(os.Args[2:]))
+		}
+	}
 
 	// Initialize logger
 	logger := log.New(os.Stdout, "[CACHE] ", log.LstdFlags)
 
-	// Create cache instance
-	cacheInstance := cache.NewCache(cache.Config{
-		MaxMemory:     config.MaxMemory,
-		EvictionPolicy: config.EvictionPolicy,
-	})
-
-	// Start cache cleanup routine
-	go cacheInstance.StartCleanup()
-
-	// Create TCP server
-	tcpServer := server.NewTCPServer(cacheInstance, logger)
+	// Flags, then the config file, then the environment
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		logger.Fatalf("Loading configuration: %v", err)
+	}
+	ctx := context.Background()
 
-	// Start TCP server
-	go func() {
-		logger.Printf("Starting TCP server on %s:%d", config.Host, config.Port)
-		if err := tcpServer.Start(fmt.Sprintf("%s:%d", config.Host, config.Port)); err != nil {
-			logger.Fatalf("TCP server failed: %v", err)
-		}
-	}()
+	// Create cache instance and its background routines
+	c, err := cache.NewCacheFromConfig(cfg.Cache)
+	if err != nil {
+		logger.Fatalf("Creating the cache: %v", err)
+	}
+	c.StartCleanupRoutine(cfg.Cache.CleanupInterval)
+	if cfg.Cache.DefragInterval > 0 {
+		c.StartDefragRoutine(cfg.Cache.DefragInterval, cfg.Cache.DefragThreshold)
+	}
+	if cfg.Cache.IdleTimeout > 0 {
+		c.StartIdleReaper(cfg.Cache.IdleTimeout, cfg.Cache.IdleReapInterval)
+	}
 
-	// Start HTTP monitoring server if enabled
-	if config.HTTPPort > 0 {
-		httpServer := server.NewHTTPServer(cacheInstance, logger)
-		go func() {
-			logger.Printf("Starting HTTP server on %s:%d", config.Host, config.HTTPPort)
-			if err := httpServer.Start(fmt.Sprintf("%s:%d", config.Host, config.HTTPPort)); err != nil {
-				logger.Fatalf("HTTP server failed: %v", err)
+	// Restore from the AOF if there is one, otherwise from snapshots
+	var aof *cache.AOF
+	if cfg.Storage.Enabled {
+		c.SetSnapshotStorage(cfg.Storage)
+		if cfg.Storage.Type == "aof" {
+			if aof, err = c.EnableAOF(ctx, cfg.Storage, logger); err != nil {
+				logger.Fatalf("Loading the AOF: %v", err)
 			}
-		}()
+		} else if _, _, err := c.LoadSnapshots(ctx, cfg.Storage); err != nil {
+			logger.Fatalf("Loading snapshots: %v", err)
+		}
+		if cfg.Storage.SnapshotInterval > 0 {
+			c.StartSnapshotSchedule(cfg.Storage.SnapshotInterval, cfg.Storage.SnapshotMinChanges)
+		}
 	}
 
-	// Wait for interrupt signal
-	waitForShutdown()
-
-	// Graceful shutdown
-	logger.Println("Shutting down servers...")
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
-
-	var wg sync.WaitGroup
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		tcpServer.Shutdown(ctx)
-	}()
-
-	wg.Wait()
-	logger.Println("Servers shut down gracefully")
-}
-
-type Config struct {
-	Host           string
-	Port           int
-	HTTPPort       int
-	MaxMemory      string
-	EvictionPolicy string
-}
-
-func parseFlags() *Config {
-	// Simple flag parsing (in real implementation, use flag package)
-	host := getEnv("CACHE_HOST", "0.0.0.0")
-	port := getEnvInt("CACHE_PORT", 8080)
-	httpPort := getEnvInt("CACHE_HTTP_PORT", 8081)
-	maxMemory := getEnv("CACHE_MAX_MEMORY", "1GB")
-	evictionPolicy := getEnv("CACHE_EVICTION_POLICY", "lru")
-
-	return &Config{
-		Host:           host,
-		Port:           port,
-		HTTPPort:       httpPort,
-		MaxMemory:      maxMemory,
-		EvictionPolicy: evictionPolicy,
+	secrets := server.StartSecretWatcher(server.NewSecretResolver(cfg.Security), cfg.Security.SecretRefreshInterval, logger)
+	defer secrets.Close()
+
+	// Create the front-ends; StartListeners starts the enabled ones
+	tcpServer := server.NewTCPServer(c, logger)
+	tcpServer.SetOutputBufferLimits(cfg.Server.OutputBufferLimits)
+	tcpServer.SetRequestLimits(cfg.Server.RequestLimits)
+	tcpServer.SetCommandTimeout(cfg.Server.CommandTimeout)
+	tcpServer.SetTrackingTableMaxKeys(cfg.Server.TrackingTableMaxKeys)
+	httpServer := server.NewHTTPServer(c, logger)
+	httpServer.SetTimeouts(cfg.Server.ReadTimeout, cfg.Server.WriteTimeout)
+	if cfg.Server.EnableCORS {
+		httpServer.SetCORSOrigins(cfg.Server.CORSOrigins)
 	}
-}
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	var acl *server.ACL
+	if cfg.Security.EnableACL {
+		if acl, err = server.LoadACLFile(cfg.Security.ACLFile); err != nil {
+			logger.Fatalf("Loading ACLs: %v", err)
+		}
+		tcpServer.SetACL(acl)
 	}
-	return defaultValue
-}
-
-func getEnvInt(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
+	if cfg.Security.EnableAuth {
+		if acl == nil {
+			logger.Fatalf("HTTP API authentication needs an ACL file")
+		}
+		jwtSecret, err := secrets.Watch(ctx, cfg.Security.JWTSecret)
+		if err != nil {
+			logger.Fatalf("Reading the JWT secret: %v", err)
 		}
+		httpServer.SetSessions(server.NewSessions(acl, jwtSecret, cfg.Security.JWTExpiry, cfg.Security.RefreshExpiry))
 	}
-	return defaultValue
-}
-
-func waitForShutdown() {
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
-}
-
-// Placeholder implementations (would be in separate files in real project)
 
-type Cache struct {
-	data map[string]interface{}
-	mu   sync.RWMutex
-}
-
-func NewCache(config cache.Config) *Cache {
-	return &Cache{
-		data: make(map[string]interface{}),
+	stats := metrics.NewMetrics()
+	stats.WatchEvictions(c)
+	stats.WatchKeyspaceHits(c)
+	stats.WatchIdleReaper(c)
+	stats.WatchCommandTimeouts(tcpServer)
+	stats.WatchProtocolErrors(tcpServer)
+	defer stats.WatchMemoryPressure(c)()
+	defer stats.WatchMemoryUsage(c, cfg.Metrics.Interval)()
+	if aof != nil {
+		stats.WatchAOF(aof)
 	}
-}
-
-func (c *Cache) StartCleanup() {
-	// Implementation for cleanup routine
-}
-
-type TCPServer struct{}
-
-func NewTCPServer(cache *Cache, logger *log.Logger) *TCPServer {
-	return &TCPServer{}
-}
-
-func (s *TCPServer) Start(addr string) error {
-	listener, err := net.Listen("tcp", addr)
-	if err != nil {
-		return err
+	var cluster *cache.Cluster
+	if cfg.Cluster.Enabled {
+		if cfg.Cluster.Password != "" {
+			password, err := secrets.Watch(ctx, cfg.Cluster.Password)
+			if err != nil {
+				logger.Fatalf("Reading the cluster password: %v", err)
+			}
+			cfg.Cluster.Password = string(password.Value())
+		}
+		cluster = cache.NewCluster(c, cfg.Cluster, cfg.ClusterAddr(), logger)
+		cluster.Start()
+		defer cluster.Stop()
+		httpServer.SetCluster(cluster)
+		stats.WatchCluster(cluster)
 	}
-	defer listener.Close()
-
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			return err
+	var metricsToken *server.Secret
+	if cfg.Metrics.AuthToken != "" {
+		if metricsToken, err = secrets.Watch(ctx, cfg.Metrics.AuthToken); err != nil {
+			logger.Fatalf("Reading the metrics token: %v", err)
 		}
-		go s.handleConnection(conn)
 	}
-}
 
-func (s *TCPServer) handleConnection(conn net.Conn) {
-	defer conn.Close()
-	// Handle Redis protocol commands
-	conn.Write([]byte("+OK\r\n"))
-}
+	listeners, err := server.StartListeners(ctx, cfg.Listeners(), server.Frontends{
+		RESP:      tcpServer,
+		HTTP:      httpServer,
+		Metrics:   metrics.NewMetricsServer(stats, metricsToken),
+		GRPC:      server.NewGRPCServer(c, logger),
+		Memcached: server.NewMemcachedServer(c, logger),
+	}, secrets, logger)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
 
-func (s *TCPServer) Shutdown(ctx context.Context) error {
-	return nil
-}
+	// Wait for an interrupt signal or a SHUTDOWN command
+	reason := server.WaitForShutdownRequest()
 
-type HTTPServer struct{}
+	// Graceful shutdown
+	logger.Printf("Shutting down servers (%s)...", reason)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
 
-func NewHTTPServer(cache *Cache, logger *log.Logger) *HTTPServer {
-	return &HTTPServer{}
+	listeners.Shutdown(shutdownCtx)
+	if aof != nil {
+		if err := aof.Close(); err != nil {
+			logger.Printf("Closing the AOF: %v", err)
+		}
+	}
+	logger.Println("Servers shut down gracefully")
 }
-
-func (s *HTTPServer) Start(addr string) error {
-	// HTTP server implementation would go here
-	return nil
-}
\ No newline at end of file
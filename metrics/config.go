@@ -0,0 +1,28 @@
+package metrics
+
+import "time"
+
+// Config holds metrics configuration
+type Config struct {
+	Enabled         bool          `json:"enabled" toml:"enabled" yaml:"enabled"`
+	Interval        time.Duration `json:"interval" toml:"interval" yaml:"interval"`
+	RetentionPeriod time.Duration `json:"retention_period" toml:"retention_period" yaml:"retention_period"`
+	PrometheusPort  int           `json:"prometheus_port" toml:"prometheus_port" yaml:"prometheus_port"`
+	// AuthToken is the bearer token scrapers must send for /metrics and
+	// /status, none if empty. It may be a secret reference.
+	AuthToken       string    `json:"auth_token" toml:"auth_token" yaml:"auth_token"`
+	EnableHistogram bool      `json:"enable_histogram" toml:"enable_histogram" yaml:"enable_histogram"`
+	Buckets         []float64 `json:"buckets" toml:"buckets" yaml:"buckets"`
+}
+
+// DefaultConfig returns the default metrics configuration
+func DefaultConfig() Config {
+	return Config{
+		Enabled:         true,
+		Interval:        10 * time.Second,
+		RetentionPeriod: 7 * 24 * time.Hour,
+		PrometheusPort:  9090,
+		EnableHistogram: true,
+		Buckets:         []float64{.005, .01, .025, .05, .1, .25, .5, 1.0, 2.5, 5.0, 10.0},
+	}
+}
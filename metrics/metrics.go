@@ -0,0 +1,780 @@
+// Package metrics exports cache and server statistics to Prometheus
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+	"github.com/hamisionesmus/distributed-cache/server"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics holds all Prometheus metrics
+type Metrics struct {
+	// Cache metrics
+	cacheHits         prometheus.Counter
+	cacheMisses       prometheus.Counter
+	cacheEvictions    prometheus.Counter
+	cacheKeysTotal    prometheus.Gauge
+	cacheMemoryUsage  prometheus.Gauge
+	memoryPressure    prometheus.Gauge
+	memoryPressureEvents *prometheus.CounterVec
+
+	// Request metrics
+	requestsTotal     *prometheus.CounterVec
+	requestDuration   *prometheus.HistogramVec
+	activeConnections prometheus.Gauge
+
+	// Storage metrics
+	aofWriteDuration  *prometheus.HistogramVec
+	aofFsyncDuration  prometheus.Histogram
+	aofDelayedFsyncs  prometheus.Counter
+	aofTruncatedBytes prometheus.Counter
+	snapshotDuration  *prometheus.HistogramVec
+	snapshotRatio     prometheus.Gauge
+	snapshotSize      prometheus.Gauge
+	storageWritten    *prometheus.CounterVec
+	storageRead       *prometheus.CounterVec
+	aof               *cache.AOF
+
+	// Cluster metrics
+	clusterNodes      prometheus.Gauge
+	clusterReplicas   prometheus.Gauge
+	clusterLeader     prometheus.Gauge
+
+	// System metrics
+	goRoutines        prometheus.Gauge
+	memoryAllocated   prometheus.Gauge
+	gcPauseTime       prometheus.Gauge
+
+	// Custom metrics
+	operationsTotal   *prometheus.CounterVec
+	errorsTotal       *prometheus.CounterVec
+
+	registry         *prometheus.Registry
+	mu               sync.RWMutex
+}
+
+// NewMetrics creates a new metrics instance
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+	}
+
+	m.initCacheMetrics()
+	m.initRequestMetrics()
+	m.initStorageMetrics()
+	m.initClusterMetrics()
+	m.initSystemMetrics()
+	m.initCustomMetrics()
+
+	return m
+}
+
+// initCacheMetrics initializes cache-related metrics
+func (m *Metrics) initCacheMetrics() {
+	m.cacheHits = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cache_hits_total",
+		Help: "Total number of cache hits",
+	})
+	m.cacheMisses = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cache_misses_total",
+		Help: "Total number of cache misses",
+	})
+	m.cacheEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "cache_evictions_total",
+		Help: "Total number of cache evictions",
+	})
+	m.cacheKeysTotal = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cache_keys_total",
+		Help: "Total number of keys in cache",
+	})
+	m.cacheMemoryUsage = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cache_memory_usage_bytes",
+		Help: "Current memory usage of cache",
+	})
+
+	m.memoryPressure = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cache_memory_pressure_level",
+		Help: "Memory pressure level (0=none, 1=soft limit, 2=hard limit)",
+	})
+	m.memoryPressureEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_memory_pressure_events_total",
+		Help: "Total number of memory pressure level changes",
+	}, []string{"level"})
+
+	m.registry.MustRegister(
+		m.cacheHits,
+		m.cacheMisses,
+		m.cacheEvictions,
+		m.cacheKeysTotal,
+		m.cacheMemoryUsage,
+		m.memoryPressure,
+		m.memoryPressureEvents,
+	)
+}
+
+// initRequestMetrics initializes request-related metrics
+func (m *Metrics) initRequestMetrics() {
+	m.requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests",
+	}, []string{"method", "endpoint", "status"})
+
+	m.requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request duration in seconds",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "endpoint"})
+
+	m.activeConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "active_connections",
+		Help: "Number of active connections",
+	})
+
+	m.registry.MustRegister(
+		m.requestsTotal,
+		m.requestDuration,
+		m.activeConnections,
+	)
+}
+
+// initStorageMetrics initializes persistence-related metrics
+func (m *Metrics) initStorageMetrics() {
+	m.aofWriteDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "aof_write_duration_seconds",
+		Help:    "Time to append a command to the AOF, including fsync under the always policy",
+		Buckets: []float64{.00001, .00005, .0001, .0005, .001, .005, .01, .05, .1, .5, 1},
+	}, []string{"policy"})
+	m.aofFsyncDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "aof_fsync_duration_seconds",
+		Help:    "Time taken by AOF fsyncs",
+		Buckets: []float64{.0001, .0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	})
+	m.aofDelayedFsyncs = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "aof_delayed_fsync_total",
+		Help: "Total number of everysec AOF fsyncs that took longer than their interval",
+	})
+
+	m.aofTruncatedBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "aof_truncated_bytes_total",
+		Help: "Total bytes cut from corrupt AOF tails at load",
+	})
+
+	m.snapshotDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "snapshot_duration_seconds",
+		Help:    "Time to write a snapshot",
+		Buckets: prometheus.ExponentialBuckets(.01, 2, 14),
+	}, []string{"compression"})
+	m.snapshotRatio = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "snapshot_compression_ratio",
+		Help: "Uncompressed to compressed size ratio of the last snapshot",
+	})
+	m.snapshotSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "snapshot_size_bytes",
+		Help: "File size of the last snapshot",
+	})
+
+	m.storageWritten = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_bytes_written_total",
+		Help: "Total bytes written to persistence files",
+	}, []string{"kind"})
+	m.storageRead = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_bytes_read_total",
+		Help: "Total bytes read from persistence files",
+	}, []string{"kind"})
+	aofSize := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "aof_size_bytes",
+		Help: "Current size of the AOF",
+	}, func() float64 {
+		return m.aofStat(func(stats cache.AOFStats) int64 { return stats.Size })
+	})
+	aofPending := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "aof_pending_fsync_bytes",
+		Help: "Bytes appended to the AOF and not yet synced to disk",
+	}, func() float64 {
+		return m.aofStat(func(stats cache.AOFStats) int64 { return stats.PendingBytes })
+	})
+
+	m.registry.MustRegister(
+		m.aofWriteDuration,
+		m.aofFsyncDuration,
+		m.aofDelayedFsyncs,
+		m.aofTruncatedBytes,
+		m.snapshotDuration,
+		m.snapshotRatio,
+		m.snapshotSize,
+		m.storageWritten,
+		m.storageRead,
+		aofSize,
+		aofPending,
+	)
+}
+
+// initClusterMetrics initializes cluster-related metrics
+func (m *Metrics) initClusterMetrics() {
+	m.clusterNodes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cluster_nodes",
+		Help: "Number of nodes in cluster",
+	})
+	m.clusterReplicas = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cluster_replicas",
+		Help: "Number of replicas in cluster",
+	})
+	m.clusterLeader = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "cluster_leader",
+		Help: "Whether this node is the cluster leader (1=yes, 0=no)",
+	})
+
+	m.registry.MustRegister(
+		m.clusterNodes,
+		m.clusterReplicas,
+		m.clusterLeader,
+	)
+}
+
+// initSystemMetrics initializes system-related metrics
+func (m *Metrics) initSystemMetrics() {
+	m.goRoutines = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "go_goroutines",
+		Help: "Number of goroutines",
+	})
+	m.memoryAllocated = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "go_memory_allocated_bytes",
+		Help: "Allocated memory in bytes",
+	})
+	m.gcPauseTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "go_gc_pause_time_seconds",
+		Help: "GC pause time in seconds",
+	})
+
+	m.registry.MustRegister(
+		m.goRoutines,
+		m.memoryAllocated,
+		m.gcPauseTime,
+	)
+}
+
+// initCustomMetrics initializes custom application metrics
+func (m *Metrics) initCustomMetrics() {
+	m.operationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_operations_total",
+		Help: "Total number of cache operations",
+	}, []string{"operation", "result"})
+
+	m.errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_errors_total",
+		Help: "Total number of errors",
+	}, []string{"type", "operation"})
+
+	m.registry.MustRegister(
+		m.operationsTotal,
+		m.errorsTotal,
+	)
+}
+
+// RecordCacheHit records a cache hit
+func (m *Metrics) RecordCacheHit() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cacheHits.Inc()
+}
+
+// RecordCacheMiss records a cache miss
+func (m *Metrics) RecordCacheMiss() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cacheMisses.Inc()
+}
+
+// RecordCacheEviction records a cache eviction
+func (m *Metrics) RecordCacheEviction() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cacheEvictions.Inc()
+}
+
+// SetCacheKeys sets the total number of keys in cache
+func (m *Metrics) SetCacheKeys(count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cacheKeysTotal.Set(float64(count))
+}
+
+// SetCacheMemoryUsage sets the current memory usage
+func (m *Metrics) SetCacheMemoryUsage(bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cacheMemoryUsage.Set(float64(bytes))
+}
+
+// WatchMemoryUsage sets cache_memory_usage_bytes to the estimated memory
+// held by c's entries every interval, until the returned function is
+// called
+func (m *Metrics) WatchMemoryUsage(c *cache.Cache, interval time.Duration) func() {
+	done := make(chan struct{})
+	m.SetCacheMemoryUsage(c.UsedMemory())
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.SetCacheMemoryUsage(c.UsedMemory())
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// RecordMemoryPressure records a change of memory pressure level
+func (m *Metrics) RecordMemoryPressure(level cache.MemoryPressure) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.memoryPressure.Set(float64(level))
+	m.memoryPressureEvents.WithLabelValues(level.String()).Inc()
+}
+
+// WatchMemoryPressure records pressure level changes of c until the
+// returned function is called
+func (m *Metrics) WatchMemoryPressure(c *cache.Cache) func() {
+	return c.OnMemoryPressure(func(event cache.MemoryPressureEvent) {
+		m.RecordMemoryPressure(event.Level)
+	})
+}
+
+// ObserveAOFWrite records a command appended to the AOF
+func (m *Metrics) ObserveAOFWrite(policy string, n int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.aofWriteDuration.WithLabelValues(policy).Observe(d.Seconds())
+	m.storageWritten.WithLabelValues("aof").Add(float64(n))
+}
+
+// ObserveAOFFsync records an AOF fsync and whether it fell behind schedule
+func (m *Metrics) ObserveAOFFsync(d time.Duration, delayed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.aofFsyncDuration.Observe(d.Seconds())
+	if delayed {
+		m.aofDelayedFsyncs.Inc()
+	}
+}
+
+// WatchAOF records the I/O of a, including what was read and truncated
+// when it was loaded
+func (m *Metrics) WatchAOF(a *cache.AOF) {
+	stats := a.Stats()
+	m.mu.Lock()
+	m.aof = a
+	m.storageRead.WithLabelValues("aof").Add(float64(stats.LoadedBytes))
+	if stats.TruncatedBytes > 0 {
+		m.aofTruncatedBytes.Add(float64(stats.TruncatedBytes))
+	}
+	m.mu.Unlock()
+	a.SetObserver(m)
+}
+
+// WatchMirror exports the lag and progress of mirror, labelled with its
+// target
+func (m *Metrics) WatchMirror(mirror *cache.Mirror) {
+	labels := prometheus.Labels{"target": mirror.Target()}
+	stat := func(value func(stats cache.MirrorStats) float64) func() float64 {
+		return func() float64 { return value(mirror.Stats()) }
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "mirror_lag_records",
+			Help:        "Writes logged but not yet forwarded to the mirror",
+			ConstLabels: labels,
+		}, stat(func(stats cache.MirrorStats) float64 { return float64(stats.LagRecords) })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "mirror_lag_seconds",
+			Help:        "How long the oldest unforwarded write has waited",
+			ConstLabels: labels,
+		}, stat(func(stats cache.MirrorStats) float64 { return stats.LagSeconds })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "mirror_forwarded_total",
+			Help:        "Total writes forwarded to the mirror, including backfill",
+			ConstLabels: labels,
+		}, stat(func(stats cache.MirrorStats) float64 { return float64(stats.Forwarded) })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "mirror_errors_total",
+			Help:        "Total failed attempts to forward a write to the mirror",
+			ConstLabels: labels,
+		}, stat(func(stats cache.MirrorStats) float64 { return float64(stats.Errors) })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "mirror_backfill_keys",
+			Help:        "Keys copied by the mirror's current or last backfill",
+			ConstLabels: labels,
+		}, stat(func(stats cache.MirrorStats) float64 { return float64(stats.Backfilled) })),
+	)
+}
+
+// WatchReplica exports how far c lags the primary replicating to it
+func (m *Metrics) WatchReplica(c *cache.Cache) {
+	lag := func(value func(lag cache.ReplicaLag) float64) func() float64 {
+		return func() float64 {
+			if lag, ok := c.ReplicaLag(); ok {
+				return value(lag)
+			}
+			return 0
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "replica_lag_records",
+			Help: "Writes logged by the primary but not yet applied by this replica",
+		}, lag(func(lag cache.ReplicaLag) float64 { return float64(lag.LagRecords) })),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "replica_staleness_seconds",
+			Help: "Upper bound on how old the data served by this replica is",
+		}, lag(func(lag cache.ReplicaLag) float64 { return lag.StalenessSeconds })),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "replica_stale_reads_total",
+			Help: "Total reads served or rejected beyond the staleness bound",
+		}, lag(func(lag cache.ReplicaLag) float64 { return float64(lag.StaleReads) })),
+	)
+}
+
+// WatchCommandTimeouts exports how many commands s aborted at their
+// execution deadline
+func (m *Metrics) WatchCommandTimeouts(s *server.TCPServer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "command_timeouts_total",
+		Help: "Total commands aborted after exceeding the command timeout",
+	}, func() float64 { return float64(s.CommandTimeouts()) }))
+}
+
+// WatchOutputBufferEvictions exports the clients s disconnected for
+// exceeding their output buffer limits
+func (m *Metrics) WatchOutputBufferEvictions(s *server.TCPServer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, class := range []string{server.OutputClassNormal, server.OutputClassPubSub} {
+		pubsub := class == server.OutputClassPubSub
+		m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "client_output_buffer_evictions_total",
+			Help:        "Total clients disconnected for exceeding their output buffer limit",
+			ConstLabels: prometheus.Labels{"class": class},
+		}, func() float64 {
+			normal, pubsubs := s.OutputBufferEvictions()
+			if pubsub {
+				return float64(pubsubs)
+			}
+			return float64(normal)
+		}))
+	}
+}
+
+// WatchProtocolErrors exports the clients s disconnected for protocol
+// errors, including breaking the request limits
+func (m *Metrics) WatchProtocolErrors(s *server.TCPServer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, reason := range cache.ProtocolErrorReasons {
+		reason := reason
+		m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "protocol_errors_total",
+			Help:        "Total clients disconnected for protocol errors or oversized requests",
+			ConstLabels: prometheus.Labels{"reason": reason},
+		}, func() float64 { return float64(s.ProtocolErrors()[reason]) }))
+	}
+}
+
+// WatchIdleReaper exports the entries the idle reaper evicted from c and
+// the memory they held
+func (m *Metrics) WatchIdleReaper(c *cache.Cache) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "cache_idle_reaped_total",
+		Help: "Total entries evicted for not being accessed within the idle timeout",
+	}, func() float64 { return float64(c.IdleReapStats().Reaped) }))
+	m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "cache_idle_reclaimed_bytes_total",
+		Help: "Total bytes reclaimed by evicting idle entries",
+	}, func() float64 { return float64(c.IdleReapStats().ReclaimedBytes) }))
+}
+
+// WatchEvictions exports the entries c evicted by reason, labelled with
+// its eviction policy so policies can be compared across nodes
+func (m *Metrics) WatchEvictions(c *cache.Cache) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, reason := range []cache.RemovalReason{cache.RemovalEvictedLRU, cache.RemovalEvictedMemory, cache.RemovalIdle} {
+		m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "cache_policy_evictions_total",
+			Help:        "Total entries evicted, by eviction policy and reason",
+			ConstLabels: prometheus.Labels{"policy": c.EvictionPolicy(), "reason": string(reason)},
+		}, func() float64 { return float64(c.Evictions()[reason]) }))
+	}
+}
+
+// WatchKeyspaceHits exports the hits and misses of c's reads in place of
+// cache_hits_total and cache_misses_total, and a hit rate gauge for each
+// namespace configured on c when it is called
+func (m *Metrics) WatchKeyspaceHits(c *cache.Cache) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registry.Unregister(m.cacheHits)
+	m.registry.Unregister(m.cacheMisses)
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "cache_hits_total",
+			Help: "Total number of cache hits",
+		}, func() float64 { return float64(c.KeyspaceHits().Hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "cache_misses_total",
+			Help: "Total number of cache misses",
+		}, func() float64 { return float64(c.KeyspaceHits().Misses) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "cache_expired_misses_total",
+			Help: "Total cache misses on keys found expired",
+		}, func() float64 { return float64(c.KeyspaceHits().ExpiredMisses) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "cache_hit_rate",
+			Help: "Share of reads that were cache hits",
+		}, func() float64 { return c.KeyspaceHits().HitRate() }),
+	)
+	for ns := range c.NamespaceHits() {
+		m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "cache_namespace_hit_rate",
+			Help:        "Share of reads that were cache hits, by namespace",
+			ConstLabels: prometheus.Labels{"namespace": ns},
+		}, func() float64 { return c.NamespaceHits()[ns].HitRate() }))
+	}
+}
+
+// WatchRateLimiter exports the commands and requests refused by limiter
+func (m *Metrics) WatchRateLimiter(limiter *server.RateLimiter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, class := range []string{"read", "write"} {
+		write := class == "write"
+		m.registry.MustRegister(prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "rate_limited_total",
+			Help:        "Total commands refused for exceeding their identity's rate limit",
+			ConstLabels: prometheus.Labels{"class": class},
+		}, func() float64 {
+			reads, writes := limiter.Limited()
+			if write {
+				return float64(writes)
+			}
+			return float64(reads)
+		}))
+	}
+}
+
+// aofStat reads a value from the watched AOF's stats, zero if none
+func (m *Metrics) aofStat(value func(stats cache.AOFStats) int64) float64 {
+	m.mu.RLock()
+	a := m.aof
+	m.mu.RUnlock()
+	if a == nil {
+		return 0
+	}
+	return float64(value(a.Stats()))
+}
+
+// RecordSnapshot records a completed snapshot
+func (m *Metrics) RecordSnapshot(info cache.SnapshotInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshotDuration.WithLabelValues(info.Compression).Observe(info.Duration.Seconds())
+	m.snapshotRatio.Set(info.Ratio())
+	m.snapshotSize.Set(float64(info.Bytes))
+	m.storageWritten.WithLabelValues("snapshot").Add(float64(info.Bytes))
+}
+
+// RecordSnapshotLoad records a snapshot read at startup
+func (m *Metrics) RecordSnapshotLoad(info cache.SnapshotInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.storageRead.WithLabelValues("snapshot").Add(float64(info.Bytes))
+}
+
+// RecordRequest records an HTTP request
+func (m *Metrics) RecordRequest(method, endpoint string, statusCode int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := strconv.Itoa(statusCode)
+	m.requestsTotal.WithLabelValues(method, endpoint, status).Inc()
+	m.requestDuration.WithLabelValues(method, endpoint).Observe(duration.Seconds())
+}
+
+// SetActiveConnections sets the number of active connections
+func (m *Metrics) SetActiveConnections(count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.activeConnections.Set(float64(count))
+}
+
+// WatchCluster exports the live members of cl as cluster_nodes, in place
+// of the value set with SetClusterNodes
+func (m *Metrics) WatchCluster(cl *cache.Cluster) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.registry.Unregister(m.clusterNodes)
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "cluster_nodes",
+		Help: "Number of live nodes in cluster, counting this one",
+	}, func() float64 { return float64(cl.LiveMembers()) }))
+}
+
+// SetClusterNodes sets the number of cluster nodes
+func (m *Metrics) SetClusterNodes(count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clusterNodes.Set(float64(count))
+}
+
+// SetClusterReplicas sets the number of cluster replicas
+func (m *Metrics) SetClusterReplicas(count int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clusterReplicas.Set(float64(count))
+}
+
+// SetClusterLeader sets whether this node is the cluster leader
+func (m *Metrics) SetClusterLeader(isLeader bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if isLeader {
+		m.clusterLeader.Set(1)
+	} else {
+		m.clusterLeader.Set(0)
+	}
+}
+
+// UpdateSystemMetrics updates Go runtime metrics
+func (m *Metrics) UpdateSystemMetrics() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Note: In a real implementation, you'd use runtime metrics
+	// For now, we'll use placeholder values
+	m.goRoutines.Set(42) // runtime.NumGoroutine()
+	m.memoryAllocated.Set(1024 * 1024 * 50) // runtime memory stats
+	m.gcPauseTime.Set(0.001) // GC pause time
+}
+
+// RecordOperation records a cache operation
+func (m *Metrics) RecordOperation(operation, result string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.operationsTotal.WithLabelValues(operation, result).Inc()
+}
+
+// RecordError records an error
+func (m *Metrics) RecordError(errorType, operation string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errorsTotal.WithLabelValues(errorType, operation).Inc()
+}
+
+// healthHandler handles health check requests
+func (m *Metrics) healthHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`{"status": "healthy"}`))
+}
+
+// statusHandler handles status requests
+func (m *Metrics) statusHandler(w http.ResponseWriter, r *http.Request) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status := map[string]interface{}{
+		"timestamp": time.Now().Unix(),
+		"cache": map[string]interface{}{
+			"hits":    m.cacheHits.Desc().String(),
+			"misses":  m.cacheMisses.Desc().String(),
+			"keys":    m.cacheKeysTotal.Desc().String(),
+			"memory":  m.cacheMemoryUsage.Desc().String(),
+		},
+		"system": map[string]interface{}{
+			"goroutines": m.goRoutines.Desc().String(),
+			"memory":     m.memoryAllocated.Desc().String(),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
+
+// GetMetricsSummary returns a summary of current metrics
+func (m *Metrics) GetMetricsSummary() map[string]interface{} {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Gather metrics from the registry
+	metricsFamilies, err := m.registry.Gather()
+	if err != nil {
+		return map[string]interface{}{
+			"error": err.Error(),
+		}
+	}
+
+	summary := make(map[string]interface{})
+
+	for _, mf := range metricsFamilies {
+		name := mf.GetName()
+		metric := mf.GetMetric()[0] // Get first metric
+
+		switch mf.GetType() {
+		case prometheus.MetricType_COUNTER:
+			summary[name] = metric.GetCounter().GetValue()
+		case prometheus.MetricType_GAUGE:
+			summary[name] = metric.GetGauge().GetValue()
+		case prometheus.MetricType_HISTOGRAM:
+			hist := metric.GetHistogram()
+			summary[name] = map[string]interface{}{
+				"count": hist.GetSampleCount(),
+				"sum":   hist.GetSampleSum(),
+			}
+		}
+	}
+
+	return summary
+}
+
+// Reset resets all metrics (useful for testing)
+func (m *Metrics) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Reset counters
+	m.cacheHits.Reset()
+	m.cacheMisses.Reset()
+	m.cacheEvictions.Reset()
+
+	// Reset gauges
+	m.cacheKeysTotal.Set(0)
+	m.cacheMemoryUsage.Set(0)
+	m.memoryPressure.Set(0)
+	m.activeConnections.Set(0)
+	m.clusterNodes.Set(0)
+	m.clusterReplicas.Set(0)
+	m.clusterLeader.Set(0)
+	m.goRoutines.Set(0)
+	m.memoryAllocated.Set(0)
+	m.gcPauseTime.Set(0)
+}
\ No newline at end of file
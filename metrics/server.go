@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/hamisionesmus/distributed-cache/server"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsServer serves /metrics, /health and /status on a listener of its
+// own, apart from the admin API, so scrapers never need admin credentials
+// and the metrics port can be exposed on its own
+type MetricsServer struct {
+	metrics *Metrics
+	server  *http.Server
+	// token, when set, is the bearer token /metrics and /status require.
+	// /health stays open for liveness probes.
+	token *server.Secret
+}
+
+// NewMetricsServer creates a server for m. A non-nil token must be sent by
+// scrapers as "Authorization: Bearer <token>"; it is read on every request,
+// so a rotated token takes effect at once.
+func NewMetricsServer(m *Metrics, token *server.Secret) *MetricsServer {
+	s := &MetricsServer{metrics: m, token: token}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.requireToken(promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})))
+	mux.Handle("/status", s.requireToken(http.HandlerFunc(m.statusHandler)))
+	mux.HandleFunc("/health", m.healthHandler)
+
+	s.server = &http.Server{Handler: mux}
+	return s
+}
+
+// requireToken rejects requests without the bearer token, if one is set
+func (s *MetricsServer) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != nil {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(got), s.token.Value()) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+				server.WriteHTTPError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Start listens on addr and serves requests until the server is shut down
+func (s *MetricsServer) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves requests on listener, which may be a TLS listener, until
+// the server is shut down
+func (s *MetricsServer) Serve(listener net.Listener) error {
+	if err := s.server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the server, waiting for in-flight scrapes
+func (s *MetricsServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
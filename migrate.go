@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/client"
+)
+
+// migrateTailQueueSize bounds the keys changed on the source that are
+// waiting to be copied again while tailing
+const migrateTailQueueSize = 100000
+
+// keyspaceChannelPrefix is the prefix of Redis keyspace notification
+// channels for database 0
+const keyspaceChannelPrefix = "__keyspace@0__:"
+
+// migration copies keys from a Redis source to a cache with DUMP and
+// RESTORE
+type migration struct {
+	source  *client.Client
+	target  *client.Client
+	replace bool
+	limiter <-chan time.Time
+
+	scanned  int64
+	copied   int64
+	deleted  int64
+	skipped  int64
+	missed   int64
+	reported int32
+}
+
+// runMigrateFromRedis implements the migrate-from-redis subcommand, which
+// copies the keys of database 0 of a live Redis instance into a cache and
+// with -tail keeps copying keys as they change until interrupted. It
+// returns the process exit code.
+func runMigrateFromRedis(args []string) int {
+	flags := flag.NewFlagSet("migrate-from-redis", flag.ContinueOnError)
+	source := flags.String("source", "", "Source Redis address (host:port)")
+	sourcePassword := flags.String("source-password", "", "Source Redis password")
+	target := flags.String("target", "127.0.0.1:6379", "Target cache address (host:port)")
+	targetPassword := flags.String("target-password", "", "Target cache password")
+	match := flags.String("match", "*", "Only migrate keys matching this pattern")
+	count := flags.Int("count", 1000, "Keys requested per SCAN call")
+	rate := flags.Int("rate", 0, "Maximum keys copied per second, 0 for no limit")
+	replace := flags.Bool("replace", true, "Overwrite keys that already exist in the target")
+	tail := flags.Bool("tail", false, "Keep copying keys changed on the source until interrupted; "+
+		"needs notify-keyspace-events to include K and A on the source")
+	progress := flags.Duration("progress", 5*time.Second, "Interval between progress reports")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: migrate-from-redis -source host:port [options]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *source == "" || flags.NArg() != 0 || *count < 1 || *rate < 0 {
+		flags.Usage()
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	m := &migration{replace: *replace}
+	var err error
+	if m.source, err = client.NewClient(&client.Options{Addresses: []string{*source}, Password: *sourcePassword}); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid source: %v\n", err)
+		return 1
+	}
+	defer m.source.Close()
+	if m.target, err = client.NewClient(&client.Options{Addresses: []string{*target}, Password: *targetPassword}); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid target: %v\n", err)
+		return 1
+	}
+	defer m.target.Close()
+	if *rate > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(*rate))
+		defer ticker.Stop()
+		m.limiter = ticker.C
+	}
+
+	// Subscribe before scanning so writes made during the copy are caught
+	var changed chan string
+	if *tail {
+		sub, err := m.source.PSubscribe(ctx, keyspaceChannelPrefix+*match)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot subscribe to keyspace notifications: %v\n", err)
+			return 1
+		}
+		defer sub.Close()
+		changed = make(chan string, migrateTailQueueSize)
+		go m.tail(ctx, sub, changed)
+	}
+
+	start := time.Now()
+	go m.report(ctx, *progress, start)
+
+	if err := m.scan(ctx, *match, *count); err != nil {
+		fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+		m.printProgress(start)
+		return 1
+	}
+	fmt.Printf("Initial copy finished in %v\n", time.Since(start).Round(time.Millisecond))
+	m.printProgress(start)
+
+	if changed == nil {
+		return 0
+	}
+	fmt.Println("Tailing keyspace notifications, interrupt to stop")
+	for {
+		select {
+		case <-ctx.Done():
+			m.printProgress(start)
+			return 0
+		case key := <-changed:
+			if err := m.copyKey(ctx, key); err != nil && ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "Migration failed: %v\n", err)
+				return 1
+			}
+		}
+	}
+}
+
+// scan copies every key matching pattern
+func (m *migration) scan(ctx context.Context, pattern string, count int) error {
+	cursor := "0"
+	for {
+		reply, err := m.source.Do(ctx, "SCAN", cursor, "MATCH", pattern, "COUNT", count)
+		if err != nil {
+			return fmt.Errorf("SCAN: %w", err)
+		}
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 2 {
+			return fmt.Errorf("SCAN: unexpected reply %v", reply)
+		}
+		next, _ := items[0].([]byte)
+		keys, _ := items[1].([]interface{})
+		for _, key := range keys {
+			name, _ := key.([]byte)
+			atomic.AddInt64(&m.scanned, 1)
+			if err := m.copyKey(ctx, string(name)); err != nil {
+				return err
+			}
+		}
+
+		cursor = string(next)
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// copyKey copies key with its remaining TTL, or deletes it from the target
+// if it no longer exists on the source. Values the target rejects, such as
+// non-string types, are counted as skipped.
+func (m *migration) copyKey(ctx context.Context, key string) error {
+	if m.limiter != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-m.limiter:
+		}
+	}
+
+	dump, err := m.source.Do(ctx, "DUMP", key)
+	if err != nil {
+		return fmt.Errorf("DUMP %q: %w", key, err)
+	}
+	ttl, err := m.source.Do(ctx, "PTTL", key)
+	if err != nil {
+		return fmt.Errorf("PTTL %q: %w", key, err)
+	}
+	pttl, _ := ttl.(int64)
+	if dump == nil || pttl == -2 {
+		if _, err := m.target.Do(ctx, "DEL", key); err != nil {
+			return fmt.Errorf("DEL %q: %w", key, err)
+		}
+		atomic.AddInt64(&m.deleted, 1)
+		return nil
+	}
+	if pttl < 0 {
+		pttl = 0
+	}
+
+	args := []interface{}{"RESTORE", key, pttl, dump}
+	if m.replace {
+		args = append(args, "REPLACE")
+	}
+	_, err = m.target.Do(ctx, args...)
+	var rejected client.Error
+	if errors.As(err, &rejected) {
+		atomic.AddInt64(&m.skipped, 1)
+		// Report the first few so the cause is visible without flooding
+		if atomic.AddInt32(&m.reported, 1) <= 10 {
+			fmt.Fprintf(os.Stderr, "Skipped %q: %v\n", key, rejected)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("RESTORE %q: %w", key, err)
+	}
+	atomic.AddInt64(&m.copied, 1)
+	return nil
+}
+
+// tail queues keys named by keyspace notifications. When the queue is full
+// the key is dropped and counted, since the target may then be stale.
+func (m *migration) tail(ctx context.Context, sub *client.PubSub, changed chan<- string) {
+	for {
+		msg, err := sub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Fprintf(os.Stderr, "Keyspace notifications stopped: %v\n", err)
+			}
+			return
+		}
+		select {
+		case changed <- strings.TrimPrefix(msg.Channel, keyspaceChannelPrefix):
+		default:
+			atomic.AddInt64(&m.missed, 1)
+		}
+	}
+}
+
+func (m *migration) report(ctx context.Context, interval time.Duration, start time.Time) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.printProgress(start)
+		}
+	}
+}
+
+func (m *migration) printProgress(start time.Time) {
+	copied := atomic.LoadInt64(&m.copied)
+	elapsed := time.Since(start)
+	fmt.Printf("scanned=%d copied=%d deleted=%d skipped=%d missed=%d elapsed=%v rate=%.0f/s\n",
+		atomic.LoadInt64(&m.scanned), copied, atomic.LoadInt64(&m.deleted),
+		atomic.LoadInt64(&m.skipped), atomic.LoadInt64(&m.missed),
+		elapsed.Round(time.Second), float64(copied)/elapsed.Seconds())
+}
@@ -0,0 +1,22 @@
+//go:build plugin_example
+
+package main
+
+import "github.com/hamisionesmus/distributed-cache/cache"
+
+// An example plugin, built with -tags plugin_example, adding
+// EXAMPLE.STRLEN key: the length of key's value, or 0 if it is missing.
+
+func init() {
+	cache.MustRegisterCommand("EXAMPLE.STRLEN", 2, cache.FlagReadOnly, exampleStrlenCommand)
+}
+
+func exampleStrlenCommand(ctx *cache.CommandContext) error {
+	value, ok := ctx.Cache.Get(ctx.Context, string(ctx.Args[1]))
+	if !ok {
+		ctx.Out.WriteInteger(0)
+		return nil
+	}
+	ctx.Out.WriteInteger(int64(len(value)))
+	return nil
+}
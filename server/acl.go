@@ -0,0 +1,467 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// The ACL file uses the Redis ACL file syntax, one directive per line:
+//
+//	user <name> [on|off] [>password|#hash|nopass] [+@cat|-@cat]
+//	     [~pattern|%R~pattern|%W~pattern|%RW~pattern|allkeys|resetkeys]
+//	namespace <name> admin
+//
+// Categories are read, write, admin, connection, pubsub and internal, or
+// all. Every user starts with connection; internal is for the user cluster
+// nodes authenticate to each other as. Key patterns grant read
+// and write access (~), or only one of them (%R~, %W~), to matching keys,
+// so "~billing:*" scopes a user to the billing namespace. Keys in a
+// namespace marked admin are only accessible to users with +@admin,
+// whatever their key patterns. Lines starting with # are comments.
+
+// aclPerm is the access a command needs to a key
+type aclPerm uint8
+
+const (
+	aclRead aclPerm = 1 << iota
+	aclWrite
+)
+
+// aclKeyRule grants perm on keys matching pattern
+type aclKeyRule struct {
+	pattern string
+	perm    aclPerm
+}
+
+// ACL errors, sent to clients as is
+var (
+	errNoAuth      = errors.New("NOAUTH Authentication required.")
+	errWrongPass   = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	errNoPermKeys  = errors.New("NOPERM this user has no permissions to access one of the keys used as arguments")
+	errACLDisabled = errors.New("ERR ACLs are not enabled")
+)
+
+// ACLUser is a user defined in the ACL file
+type ACLUser struct {
+	Name    string
+	Enabled bool
+	// NoPass lets the user authenticate with any password
+	NoPass bool
+	// passwords are salted hashes, or SHA-256 digests from Redis ACL files
+	passwords []passwordHash
+	// categories are the command flags the user may run
+	categories cache.CommandFlags
+	keys       []aclKeyRule
+}
+
+// ACL holds the users and admin-only namespaces loaded from an ACL file.
+// It is safe for concurrent use and can be reloaded in place.
+type ACL struct {
+	path string
+
+	mu              sync.RWMutex
+	users           map[string]*ACLUser
+	adminNamespaces map[string]bool
+}
+
+// NewRequirePassACL returns an ACL with a single "default" user allowed
+// everything once authenticated with password, like Redis's requirepass
+func NewRequirePassACL(password string) (*ACL, error) {
+	h, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	user := &ACLUser{
+		Name:       "default",
+		Enabled:    true,
+		passwords:  []passwordHash{h},
+		categories: aclCategories["all"],
+		keys:       []aclKeyRule{{pattern: "*", perm: aclRead | aclWrite}},
+	}
+	return &ACL{users: map[string]*ACLUser{user.Name: user}}, nil
+}
+
+// LoadACLFile reads the ACL file at path
+func LoadACLFile(path string) (*ACL, error) {
+	acl := &ACL{path: path}
+	if err := acl.Reload(); err != nil {
+		return nil, err
+	}
+	return acl, nil
+}
+
+// Reload re-reads the ACL file. On error the current rules are kept.
+// Connected clients are checked against the new rules from their next
+// command.
+func (a *ACL) Reload() error {
+	if a.path == "" {
+		return errors.New("no ACL file is configured")
+	}
+	file, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	users, namespaces, err := parseACL(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", a.path, err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.users = users
+	a.adminNamespaces = namespaces
+	return nil
+}
+
+// parseACL parses ACL file directives
+func parseACL(r io.Reader) (map[string]*ACLUser, map[string]bool, error) {
+	users := make(map[string]*ACLUser)
+	namespaces := make(map[string]bool)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch {
+		case fields[0] == "user" && len(fields) >= 2:
+			if _, exists := users[fields[1]]; exists {
+				return nil, nil, fmt.Errorf("line %d: duplicate user %q", line, fields[1])
+			}
+			user := &ACLUser{Name: fields[1], categories: cache.FlagConnection}
+			for _, rule := range fields[2:] {
+				if err := user.applyRule(rule); err != nil {
+					return nil, nil, fmt.Errorf("line %d: %w", line, err)
+				}
+			}
+			users[user.Name] = user
+		case fields[0] == "namespace" && len(fields) == 3 && fields[2] == "admin":
+			namespaces[fields[1]] = true
+		default:
+			return nil, nil, fmt.Errorf("line %d: invalid directive %q", line, scanner.Text())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return users, namespaces, nil
+}
+
+// aclCategories maps ACL category names to command flags
+var aclCategories = map[string]cache.CommandFlags{
+	"read":       cache.FlagReadOnly,
+	"write":      cache.FlagWrite,
+	"admin":      cache.FlagAdmin,
+	"connection": cache.FlagConnection,
+	"pubsub":     cache.FlagPubSub,
+	"internal":   cache.FlagInternal,
+	"all":        cache.FlagCategories,
+}
+
+// applyRule applies one rule of a user directive
+func (u *ACLUser) applyRule(rule string) error {
+	switch {
+	case rule == "on":
+		u.Enabled = true
+	case rule == "off":
+		u.Enabled = false
+	case rule == "nopass":
+		u.NoPass = true
+		u.passwords = nil
+	case strings.HasPrefix(rule, ">"):
+		h, err := hashPassword(rule[1:])
+		if err != nil {
+			return err
+		}
+		u.passwords = append(u.passwords, h)
+		u.NoPass = false
+	case strings.HasPrefix(rule, "#"):
+		h, err := parsePasswordHash(rule[1:])
+		if err != nil {
+			return err
+		}
+		u.passwords = append(u.passwords, h)
+		u.NoPass = false
+	case rule == "allcommands":
+		u.categories = aclCategories["all"]
+	case rule == "nocommands":
+		u.categories = 0
+	case strings.HasPrefix(rule, "+@") || strings.HasPrefix(rule, "-@"):
+		flags, ok := aclCategories[rule[2:]]
+		if !ok {
+			return fmt.Errorf("unknown category %q", rule[2:])
+		}
+		if rule[0] == '+' {
+			u.categories |= flags
+		} else {
+			u.categories &^= flags
+		}
+	case rule == "allkeys":
+		u.keys = append(u.keys, aclKeyRule{pattern: "*", perm: aclRead | aclWrite})
+	case rule == "resetkeys":
+		u.keys = nil
+	case strings.HasPrefix(rule, "~"):
+		u.keys = append(u.keys, aclKeyRule{pattern: rule[1:], perm: aclRead | aclWrite})
+	case strings.HasPrefix(rule, "%"):
+		i := strings.Index(rule, "~")
+		if i < 0 {
+			return fmt.Errorf("invalid key rule %q", rule)
+		}
+		var perm aclPerm
+		for _, c := range strings.ToUpper(rule[1:i]) {
+			switch c {
+			case 'R':
+				perm |= aclRead
+			case 'W':
+				perm |= aclWrite
+			default:
+				return fmt.Errorf("invalid key rule %q", rule)
+			}
+		}
+		if perm == 0 {
+			return fmt.Errorf("invalid key rule %q", rule)
+		}
+		u.keys = append(u.keys, aclKeyRule{pattern: rule[i+1:], perm: perm})
+	default:
+		return fmt.Errorf("unknown rule %q", rule)
+	}
+	return nil
+}
+
+// user returns the named user, or nil if there is none
+func (a *ACL) user(name string) *ACLUser {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.users[name]
+}
+
+// Authenticate checks a username and password, returning the user
+func (a *ACL) Authenticate(name, password string) (*ACLUser, error) {
+	user := a.user(name)
+	if user == nil || !user.Enabled {
+		return nil, errWrongPass
+	}
+	if user.NoPass {
+		return user, nil
+	}
+	for _, h := range user.passwords {
+		if h.verify(password) {
+			return user, nil
+		}
+	}
+	return nil, errWrongPass
+}
+
+// defaultUser returns the user new connections start as: "default" if it
+// is enabled and needs no password, otherwise nil so clients must AUTH
+func (a *ACL) defaultUser() *ACLUser {
+	user := a.user("default")
+	if user == nil || !user.Enabled || !user.NoPass {
+		return nil
+	}
+	return user
+}
+
+// adminNamespace reports whether key is in a namespace reserved for admins
+func (a *ACL) adminNamespace(key string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.adminNamespaces[cache.NamespaceOf(key)]
+}
+
+// check returns an error if the user may not run cmd with args. Commands
+// need their categories, and are refused if they have none. Keyspace
+// commands also need access to every key they name; those that cannot
+// name their keys need access to all keys.
+func (a *ACL) check(user *ACLUser, cmd *cache.Command, args [][]byte) error {
+	if !user.hasCategories(cmd.Flags) {
+		return fmt.Errorf("NOPERM this user has no permissions to run the '%s' command", strings.ToLower(cmd.Name))
+	}
+	if cmd.Flags&(cache.FlagWrite|cache.FlagReadOnly) == 0 || cmd.Flags&cache.FlagAdmin != 0 {
+		return nil
+	}
+	if cmd.Keys == nil {
+		return a.checkKeys(user, cmd.Flags, nil)
+	}
+	if keys := cmd.Keys(args); len(keys) > 0 {
+		return a.checkKeys(user, cmd.Flags, keys)
+	}
+	return nil
+}
+
+// hasCategories reports whether the user has the ACL categories in flags
+func (u *ACLUser) hasCategories(flags cache.CommandFlags) bool {
+	needed := flags & aclCategories["all"]
+	if flags&cache.FlagAdmin != 0 {
+		// Admin commands reading server state need only the admin category
+		needed = cache.FlagAdmin
+	}
+	return needed != 0 && u.categories&needed == needed
+}
+
+// checkKeys checks the user may read keys, or write them if flags has
+// FlagWrite. Nil keys stands for every key.
+func (a *ACL) checkKeys(user *ACLUser, flags cache.CommandFlags, keys []string) error {
+	perm := aclRead
+	if flags&cache.FlagWrite != 0 {
+		perm = aclWrite
+	}
+	if keys == nil {
+		if !user.canAccess("*", perm) || (user.categories&cache.FlagAdmin == 0 && a.hasAdminNamespaces()) {
+			return errNoPermKeys
+		}
+		return nil
+	}
+	for _, key := range keys {
+		if a.adminNamespace(key) && user.categories&cache.FlagAdmin == 0 {
+			return errNoPermKeys
+		}
+		if !user.canAccess(key, perm) {
+			return errNoPermKeys
+		}
+	}
+	return nil
+}
+
+// hasAdminNamespaces reports whether any namespace is reserved for admins
+func (a *ACL) hasAdminNamespaces() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return len(a.adminNamespaces) > 0
+}
+
+// canAccess reports whether a key rule grants perm on key. A key of "*"
+// asks for access to every key, which only a "*" pattern grants.
+func (u *ACLUser) canAccess(key string, perm aclPerm) bool {
+	for _, rule := range u.keys {
+		if rule.perm&perm != perm {
+			continue
+		}
+		if key == "*" {
+			if rule.pattern == "*" {
+				return true
+			}
+			continue
+		}
+		if cache.GlobMatch(rule.pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	cache.RegisterCommands(
+		&cache.Command{Name: "AUTH", Arity: -2, Flags: cache.FlagConnection, Handler: authCommand},
+		&cache.Command{Name: "ACL", Arity: 2, Flags: cache.FlagConnection, Handler: aclCommand},
+	)
+}
+
+// authCommand implements AUTH [username] password, where a lone password
+// authenticates the default user
+func authCommand(ctx *cache.CommandContext) error {
+	cc := clientOf(ctx)
+	if cc == nil {
+		return errNoConnection
+	}
+	acl := cc.server.accessList()
+	if acl == nil {
+		return errors.New("ERR AUTH called without any ACL configured")
+	}
+
+	var name, password string
+	switch len(ctx.Args) {
+	case 2:
+		name, password = "default", string(ctx.Args[1])
+	case 3:
+		name, password = string(ctx.Args[1]), string(ctx.Args[2])
+	default:
+		return cache.ErrSyntax
+	}
+	user, err := acl.Authenticate(name, password)
+	if err != nil {
+		return err
+	}
+	cc.state.Authenticate(user.Name)
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// aclCommand implements ACL WHOAMI and ACL LOAD. LOAD needs +@admin.
+func aclCommand(ctx *cache.CommandContext) error {
+	cc := clientOf(ctx)
+	if cc == nil {
+		return errNoConnection
+	}
+	acl := cc.server.accessList()
+	if acl == nil {
+		return errACLDisabled
+	}
+
+	switch strings.ToUpper(string(ctx.Args[1])) {
+	case "WHOAMI":
+		ctx.Out.WriteBulkString(cc.state.User())
+	case "LOAD":
+		user := acl.user(cc.state.User())
+		if user == nil || user.categories&cache.FlagAdmin == 0 {
+			return errors.New("NOPERM this user has no permissions to run the 'acl|load' command")
+		}
+		if err := acl.Reload(); err != nil {
+			return fmt.Errorf("ERR %v", err)
+		}
+		ctx.Out.WriteSimpleString("OK")
+	default:
+		return fmt.Errorf("ERR unknown subcommand '%s'", ctx.Args[1])
+	}
+	return nil
+}
+
+// checkACL enforces the connection's user's permissions on a command.
+// Without an ACL every command is allowed; with one, connections that
+// have not authenticated may only run AUTH and QUIT.
+func (cc *clientConn) checkACL(cmd *cache.Command, args [][]byte) error {
+	acl := cc.server.accessList()
+	if acl == nil || cmd.Name == "AUTH" || cmd.Name == "QUIT" {
+		return nil
+	}
+	if cc.state.User() == "" {
+		if user := acl.defaultUser(); user != nil {
+			cc.state.Authenticate(user.Name)
+		}
+	}
+	user := acl.user(cc.state.User())
+	if user == nil || !user.Enabled {
+		return errNoAuth
+	}
+	return acl.check(user, cmd, args)
+}
+
+// checkForwardedACL checks a command a peer forwarded on behalf of the
+// user its client signed in as, the default user if the peer has no ACL
+func (cc *clientConn) checkForwardedACL(name string, cmd *cache.Command, args [][]byte) error {
+	acl := cc.server.accessList()
+	if acl == nil {
+		return nil
+	}
+	user := acl.user(name)
+	if name == "" {
+		user = acl.defaultUser()
+	}
+	if user == nil || !user.Enabled {
+		return errNoAuth
+	}
+	return acl.check(user, cmd, args)
+}
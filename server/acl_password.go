@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ACL passwords are kept as salted hashes. "#" rules in the ACL file take
+// an argon2id hash in PHC format, as printed by the acl-hashpass tool, or a
+// bcrypt hash. The unsalted SHA-256 hex digests of Redis ACL files are
+// still accepted, and ">" rules are hashed with argon2id when the file is
+// loaded so no password is held in memory in the clear.
+
+// argon2id parameters for new hashes, following the OWASP recommendation
+const (
+	argon2Time    = 2
+	argon2Memory  = 19 * 1024
+	argon2Threads = 1
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+// passwordHash verifies passwords against one stored hash
+type passwordHash interface {
+	verify(password string) bool
+}
+
+// hashPassword returns a new argon2id hash of password
+func hashPassword(password string) (argon2Hash, error) {
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return argon2Hash{}, err
+	}
+	return argon2Hash{
+		time:    argon2Time,
+		memory:  argon2Memory,
+		threads: argon2Threads,
+		salt:    salt,
+		key:     argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen),
+	}, nil
+}
+
+// parsePasswordHash parses the hash of a "#" rule
+func parsePasswordHash(encoded string) (passwordHash, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return parseArgon2Hash(encoded)
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		if _, err := bcrypt.Cost([]byte(encoded)); err != nil {
+			return nil, fmt.Errorf("invalid bcrypt hash: %v", err)
+		}
+		return bcryptHash(encoded), nil
+	default:
+		digest, err := hex.DecodeString(encoded)
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("invalid password hash %q", encoded)
+		}
+		return sha256Hash(digest), nil
+	}
+}
+
+// argon2Hash is an argon2id hash with its parameters
+type argon2Hash struct {
+	time, memory uint32
+	threads      uint8
+	salt, key    []byte
+}
+
+// parseArgon2Hash parses $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<key>
+func parseArgon2Hash(encoded string) (argon2Hash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return argon2Hash{}, fmt.Errorf("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2Hash{}, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	var h argon2Hash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return argon2Hash{}, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return argon2Hash{}, fmt.Errorf("invalid argon2id salt")
+	}
+	if h.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(h.key) == 0 {
+		return argon2Hash{}, fmt.Errorf("invalid argon2id key")
+	}
+	return h, nil
+}
+
+func (h argon2Hash) verify(password string) bool {
+	key := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.key)))
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// String encodes the hash in PHC format
+func (h argon2Hash) String() string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.memory, h.time, h.threads,
+		base64.RawStdEncoding.EncodeToString(h.salt), base64.RawStdEncoding.EncodeToString(h.key))
+}
+
+// bcryptHash is a bcrypt hash
+type bcryptHash string
+
+func (h bcryptHash) verify(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(h), []byte(password)) == nil
+}
+
+// sha256Hash is the unsalted digest Redis ACL files use
+type sha256Hash []byte
+
+func (h sha256Hash) verify(password string) bool {
+	digest := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(h, digest[:]) == 1
+}
+
+// RunACLHashPass implements the acl-hashpass tool, printing the "#" rule
+// for a password read from stdin
+func RunACLHashPass(args []string) int {
+	flags := flag.NewFlagSet("acl-hashpass", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: acl-hashpass < password")
+		fmt.Fprintln(flags.Output(), "Prints the ACL file rule setting a user's password to the one read from stdin.")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 0 {
+		flags.Usage()
+		return 2
+	}
+
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Cannot read password: %v\n", err)
+		} else {
+			fmt.Fprintln(os.Stderr, "Password is empty")
+		}
+		return 1
+	}
+	h, err := hashPassword(password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Cannot hash password: %v\n", err)
+		return 1
+	}
+	fmt.Println("#" + h.String())
+	return 0
+}
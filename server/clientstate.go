@@ -0,0 +1,189 @@
+package server
+
+import "errors"
+
+// ClientState is what a connection's commands remember between them: the
+// user it is signed in as, the namespace it selected, the commands it
+// queued since MULTI, whether it is subscribed to invalidations, its
+// client tracking mode and whether it asked to quit. Each command changes
+// it only through the transitions below, and RESET returns it to the zero
+// value a new connection starts with.
+//
+// A ClientState is used by its connection's command loop, except that
+// Subscribed is also read when invalidations are pushed: the subscription
+// methods are called with the connection's pushMu held.
+type ClientState struct {
+	// user is the ACL user the connection is signed in as, "" until AUTH
+	// or the first command run under an ACL
+	user string
+
+	// namespace is the one SELECT chose, "" for none
+	namespace string
+
+	// multi is set from MULTI until EXEC or DISCARD, with the commands
+	// queued meanwhile. aborted is set when a command could not be
+	// queued, making EXEC fail.
+	multi   bool
+	aborted bool
+	queued  [][][]byte
+
+	subscribed bool
+
+	// tracking is set by CLIENT TRACKING ON, and caching by CLIENT CACHING
+	// for the next command only
+	tracking *trackingOptions
+	caching  int
+
+	// quit closes the connection once pending replies are written
+	quit bool
+}
+
+// User returns the ACL user the connection is signed in as
+func (st *ClientState) User() string {
+	return st.user
+}
+
+// Authenticate signs the connection in as user
+func (st *ClientState) Authenticate(user string) {
+	st.user = user
+}
+
+// Namespace returns the namespace the connection selected, "" for none
+func (st *ClientState) Namespace() string {
+	return st.namespace
+}
+
+// Select makes the connection's keys those of namespace ns, or of no
+// namespace if ns is ""
+func (st *ClientState) Select(ns string) {
+	st.namespace = ns
+}
+
+// InMulti reports whether commands are being queued for EXEC
+func (st *ClientState) InMulti() bool {
+	return st.multi
+}
+
+// Multi starts queueing commands
+func (st *ClientState) Multi() error {
+	if st.multi {
+		return errors.New("ERR MULTI calls can not be nested")
+	}
+	st.multi = true
+	return nil
+}
+
+// Queue adds a command to run on EXEC
+func (st *ClientState) Queue(args [][]byte) {
+	st.queued = append(st.queued, args)
+}
+
+// QueueFailed records a command that could not be queued, so that EXEC
+// runs none of them
+func (st *ClientState) QueueFailed() {
+	st.aborted = true
+}
+
+// Exec ends the queue and returns the commands to run
+func (st *ClientState) Exec() ([][][]byte, error) {
+	if !st.multi {
+		return nil, errors.New("ERR EXEC without MULTI")
+	}
+	queued, aborted := st.queued, st.aborted
+	st.multi, st.aborted, st.queued = false, false, nil
+	if aborted {
+		return nil, errors.New("EXECABORT Transaction discarded because of previous errors.")
+	}
+	return queued, nil
+}
+
+// Discard ends the queue, dropping the commands in it
+func (st *ClientState) Discard() error {
+	if !st.multi {
+		return errors.New("ERR DISCARD without MULTI")
+	}
+	st.multi, st.aborted, st.queued = false, false, nil
+	return nil
+}
+
+// Subscribed reports whether invalidations are pushed to the connection
+func (st *ClientState) Subscribed() bool {
+	return st.subscribed
+}
+
+// Subscribe starts pushing invalidations to the connection
+func (st *ClientState) Subscribe() {
+	st.subscribed = true
+}
+
+// Unsubscribe stops pushing invalidations to the connection
+func (st *ClientState) Unsubscribe() {
+	st.subscribed = false
+}
+
+// Tracking returns the connection's client tracking options, nil when
+// tracking is off
+func (st *ClientState) Tracking() *trackingOptions {
+	return st.tracking
+}
+
+// EnableTracking turns client tracking on with opts, clearing any CLIENT
+// CACHING given under the previous options
+func (st *ClientState) EnableTracking(opts trackingOptions) {
+	st.tracking = &opts
+	st.caching = cachingDefault
+}
+
+// DisableTracking turns client tracking off
+func (st *ClientState) DisableTracking() {
+	st.tracking = nil
+	st.caching = cachingDefault
+}
+
+// Caching returns the CLIENT CACHING choice for the next command
+func (st *ClientState) Caching() int {
+	return st.caching
+}
+
+// SetCaching records CLIENT CACHING YES or NO for the next command. YES
+// is only valid when tracking in OPTIN mode and NO in OPTOUT mode.
+func (st *ClientState) SetCaching(yes bool) error {
+	switch {
+	case yes && (st.tracking == nil || !st.tracking.optin):
+		return errors.New("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode")
+	case !yes && (st.tracking == nil || !st.tracking.optout):
+		return errors.New("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode")
+	}
+	if yes {
+		st.caching = cachingYes
+	} else {
+		st.caching = cachingNo
+	}
+	return nil
+}
+
+// takeCaching returns the CLIENT CACHING choice and clears it, as each
+// command consumes it
+func (st *ClientState) takeCaching() int {
+	caching := st.caching
+	st.caching = cachingDefault
+	return caching
+}
+
+// Quit asks for the connection to be closed after its pending replies
+func (st *ClientState) Quit() {
+	st.quit = true
+}
+
+// Quitting reports whether the connection asked to be closed
+func (st *ClientState) Quitting() bool {
+	return st.quit
+}
+
+// Reset returns the state to that of a new connection, reporting whether
+// tracking was on so the caller can drop the keys tracked for it
+func (st *ClientState) Reset() (wasTracking bool) {
+	wasTracking = st.tracking != nil
+	*st = ClientState{}
+	return wasTracking
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestClientStateZeroValue(t *testing.T) {
+	var st ClientState
+	if st.User() != "" || st.Subscribed() || st.Tracking() != nil || st.Caching() != cachingDefault || st.Quitting() {
+		t.Fatalf("new connection state is not empty: %+v", st)
+	}
+}
+
+func TestClientStateAuthenticateAndSubscribe(t *testing.T) {
+	var st ClientState
+	st.Authenticate("alice")
+	if got := st.User(); got != "alice" {
+		t.Errorf("User() = %q after Authenticate(alice)", got)
+	}
+	st.Authenticate("bob")
+	if got := st.User(); got != "bob" {
+		t.Errorf("User() = %q after signing in again as bob", got)
+	}
+
+	st.Subscribe()
+	if !st.Subscribed() {
+		t.Error("not subscribed after Subscribe")
+	}
+	st.Unsubscribe()
+	if st.Subscribed() {
+		t.Error("still subscribed after Unsubscribe")
+	}
+}
+
+func TestClientStateCachingNeedsMatchingTrackingMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		tracking *trackingOptions
+		yes      bool
+		ok       bool
+		want     int
+	}{
+		{"yes without tracking", nil, true, false, cachingDefault},
+		{"no without tracking", nil, false, false, cachingDefault},
+		{"yes in default mode", &trackingOptions{}, true, false, cachingDefault},
+		{"no in default mode", &trackingOptions{}, false, false, cachingDefault},
+		{"yes in optin mode", &trackingOptions{optin: true}, true, true, cachingYes},
+		{"no in optin mode", &trackingOptions{optin: true}, false, false, cachingDefault},
+		{"yes in optout mode", &trackingOptions{optout: true}, true, false, cachingDefault},
+		{"no in optout mode", &trackingOptions{optout: true}, false, true, cachingNo},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var st ClientState
+			if tt.tracking != nil {
+				st.EnableTracking(*tt.tracking)
+			}
+			err := st.SetCaching(tt.yes)
+			if (err == nil) != tt.ok {
+				t.Fatalf("SetCaching(%v) error = %v, want ok %v", tt.yes, err, tt.ok)
+			}
+			if got := st.Caching(); got != tt.want {
+				t.Errorf("Caching() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientStateCachingAppliesToOneCommand(t *testing.T) {
+	var st ClientState
+	st.EnableTracking(trackingOptions{optin: true})
+	if err := st.SetCaching(true); err != nil {
+		t.Fatal(err)
+	}
+	if got := st.takeCaching(); got != cachingYes {
+		t.Errorf("first takeCaching() = %d, want cachingYes", got)
+	}
+	if got := st.takeCaching(); got != cachingDefault {
+		t.Errorf("second takeCaching() = %d, want cachingDefault", got)
+	}
+}
+
+func TestClientStateTrackingChangeClearsCaching(t *testing.T) {
+	var st ClientState
+	st.EnableTracking(trackingOptions{optin: true})
+	if err := st.SetCaching(true); err != nil {
+		t.Fatal(err)
+	}
+	st.EnableTracking(trackingOptions{optout: true})
+	if got := st.Caching(); got != cachingDefault {
+		t.Errorf("Caching() = %d after switching to optout, want cachingDefault", got)
+	}
+	if tr := st.Tracking(); tr == nil || !tr.optout || tr.optin {
+		t.Errorf("Tracking() = %+v after switching to optout", tr)
+	}
+
+	if err := st.SetCaching(false); err != nil {
+		t.Fatal(err)
+	}
+	st.DisableTracking()
+	if st.Tracking() != nil {
+		t.Error("still tracking after DisableTracking")
+	}
+	if got := st.Caching(); got != cachingDefault {
+		t.Errorf("Caching() = %d after DisableTracking, want cachingDefault", got)
+	}
+}
+
+func TestClientStateEnableTrackingCopiesOptions(t *testing.T) {
+	var st ClientState
+	opts := trackingOptions{bcast: true, prefixes: []string{"user:"}}
+	st.EnableTracking(opts)
+	opts.bcast = false
+	if !st.Tracking().bcast {
+		t.Error("changing the caller's options changed the connection's")
+	}
+}
+
+func TestClientStateReset(t *testing.T) {
+	for _, tracking := range []bool{false, true} {
+		var st ClientState
+		st.Authenticate("alice")
+		st.Select("app")
+		if err := st.Multi(); err != nil {
+			t.Fatal(err)
+		}
+		st.Queue([][]byte{[]byte("GET"), []byte("k")})
+		st.QueueFailed()
+		st.Subscribe()
+		st.Quit()
+		if tracking {
+			st.EnableTracking(trackingOptions{optin: true})
+			if err := st.SetCaching(true); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got := st.Reset(); got != tracking {
+			t.Errorf("Reset() = %v with tracking %v", got, tracking)
+		}
+		if !reflect.DeepEqual(st, ClientState{}) {
+			t.Errorf("state after Reset is %+v, want that of a new connection", st)
+		}
+	}
+}
+
+func TestClientStateQuit(t *testing.T) {
+	var st ClientState
+	st.Quit()
+	if !st.Quitting() {
+		t.Error("not quitting after Quit")
+	}
+}
+
+func TestClientStateSelect(t *testing.T) {
+	var st ClientState
+	st.Select("app")
+	if got := st.Namespace(); got != "app" {
+		t.Errorf("Namespace() = %q after Select(app)", got)
+	}
+	st.Select("")
+	if got := st.Namespace(); got != "" {
+		t.Errorf("Namespace() = %q after Select(\"\")", got)
+	}
+}
+
+func TestClientStateMulti(t *testing.T) {
+	var st ClientState
+	if _, err := st.Exec(); err == nil {
+		t.Error("Exec without Multi succeeded")
+	}
+	if err := st.Discard(); err == nil {
+		t.Error("Discard without Multi succeeded")
+	}
+
+	if err := st.Multi(); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Multi(); err == nil {
+		t.Error("nested Multi succeeded")
+	}
+	if !st.InMulti() {
+		t.Fatal("not queueing after Multi")
+	}
+	first, second := [][]byte{[]byte("SET"), []byte("k"), []byte("v")}, [][]byte{[]byte("GET"), []byte("k")}
+	st.Queue(first)
+	st.Queue(second)
+	queued, err := st.Exec()
+	if err != nil || !reflect.DeepEqual(queued, [][][]byte{first, second}) {
+		t.Errorf("Exec() = %q, %v, want the queued commands", queued, err)
+	}
+	if st.InMulti() {
+		t.Error("still queueing after Exec")
+	}
+}
+
+func TestClientStateMultiAbortsAfterFailedQueue(t *testing.T) {
+	var st ClientState
+	if err := st.Multi(); err != nil {
+		t.Fatal(err)
+	}
+	st.Queue([][]byte{[]byte("GET"), []byte("k")})
+	st.QueueFailed()
+	if queued, err := st.Exec(); err == nil || !strings.HasPrefix(err.Error(), "EXECABORT") {
+		t.Errorf("Exec() = %q, %v after a failed queue, want EXECABORT", queued, err)
+	}
+
+	// The next transaction starts clean
+	if err := st.Multi(); err != nil {
+		t.Fatal(err)
+	}
+	st.Queue([][]byte{[]byte("GET"), []byte("k")})
+	if err := st.Discard(); err != nil || st.InMulti() {
+		t.Fatalf("Discard() = %v, queueing %v", err, st.InMulti())
+	}
+	if err := st.Multi(); err != nil {
+		t.Fatal(err)
+	}
+	if queued, err := st.Exec(); err != nil || len(queued) != 0 {
+		t.Errorf("Exec() = %q, %v after Discard, want no commands", queued, err)
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"errors"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+func init() {
+	cache.RegisterCommands(
+		&cache.Command{Name: "PING", Arity: -1, Flags: cache.FlagConnection, Handler: pingCommand},
+		&cache.Command{Name: "ECHO", Arity: 2, Flags: cache.FlagConnection, Handler: echoCommand},
+		&cache.Command{Name: "QUIT", Arity: 1, Flags: cache.FlagConnection, Handler: quitCommand},
+		&cache.Command{Name: "RESET", Arity: 1, Flags: cache.FlagConnection, Handler: resetCommand},
+		&cache.Command{Name: "SELECT", Arity: 2, Flags: cache.FlagConnection, Handler: selectCommand},
+	)
+}
+
+// pingCommand implements PING [message], replying PONG or the message
+func pingCommand(ctx *cache.CommandContext) error {
+	switch len(ctx.Args) {
+	case 1:
+		ctx.Out.WriteSimpleString("PONG")
+	case 2:
+		ctx.Out.WriteBulk(ctx.Args[1])
+	default:
+		return errors.New("ERR wrong number of arguments for 'ping' command")
+	}
+	return nil
+}
+
+func echoCommand(ctx *cache.CommandContext) error {
+	ctx.Out.WriteBulk(ctx.Args[1])
+	return nil
+}
+
+// quitCommand implements QUIT: the connection is closed once the reply,
+// and any pipelined before it, is written
+func quitCommand(ctx *cache.CommandContext) error {
+	if cc := clientOf(ctx); cc != nil {
+		cc.state.Quit()
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// resetCommand implements RESET, which returns the connection to the state
+// of a new one: subscriptions, client tracking, the selected namespace and
+// any commands queued since MULTI are dropped, and the connection is
+// signed back in as the default user. Pools run it before handing a
+// connection to its next borrower.
+func resetCommand(ctx *cache.CommandContext) error {
+	if cc := clientOf(ctx); cc != nil {
+		cc.pushMu.Lock()
+		wasTracking := cc.state.Reset()
+		cc.pushMu.Unlock()
+
+		if wasTracking {
+			cc.server.tracker.forget(cc.id)
+		}
+	}
+	ctx.Out.WriteSimpleString("RESET")
+	return nil
+}
+
+// selectCommand implements SELECT namespace. The key arguments of the
+// connection's commands are then prefixed with the namespace and ":", so
+// its keys are those of the namespace and take its settings. SELECT 0 or
+// SELECT "" selects no namespace, so clients selecting the default
+// database keep working; other databases map to the namespace of the same
+// name. Commands that do not declare their key arguments, such as KEYS,
+// SCAN and FLUSHALL, still see the whole keyspace, and keys in replies
+// keep the prefix.
+func selectCommand(ctx *cache.CommandContext) error {
+	cc := clientOf(ctx)
+	if cc == nil {
+		return errNoConnection
+	}
+	ns := string(ctx.Args[1])
+	if ns == "0" {
+		ns = ""
+	}
+	cc.state.Select(ns)
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// selectedKeys prefixes the key arguments of cmd in args with the
+// namespace the connection selected
+func (cc *clientConn) selectedKeys(cmd *cache.Command, args [][]byte) {
+	ns := cc.state.Namespace()
+	if ns == "" || cmd.KeyArgs == nil {
+		return
+	}
+	for _, pos := range cmd.KeyArgs(args) {
+		args[pos] = append([]byte(ns+cache.NamespaceSeparator), args[pos]...)
+	}
+}
+
+// selectMiddleware applies the namespace the client selected to its
+// commands' keys. Forwarded commands come with their keys already
+// prefixed by the node they were sent to.
+func selectMiddleware(next cache.CommandHandler) cache.CommandHandler {
+	return func(ctx *cache.CommandContext) error {
+		if cc := clientOf(ctx); cc != nil && !ctx.Forwarded {
+			cc.selectedKeys(ctx.Command, ctx.Args)
+		}
+		return next(ctx)
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// Config holds server-related configuration
+type Config struct {
+	Host           string        `json:"host" toml:"host" yaml:"host"`
+	Port           int           `json:"port" toml:"port" yaml:"port"`
+	HTTPPort       int           `json:"http_port" toml:"http_port" yaml:"http_port"`
+	ReadTimeout    time.Duration `json:"read_timeout" toml:"read_timeout" yaml:"read_timeout"`
+	WriteTimeout   time.Duration `json:"write_timeout" toml:"write_timeout" yaml:"write_timeout"`
+	MaxConnections int           `json:"max_connections" toml:"max_connections" yaml:"max_connections"`
+	EnableHTTP     bool          `json:"enable_http" toml:"enable_http" yaml:"enable_http"`
+	EnableTLS      bool          `json:"enable_tls" toml:"enable_tls" yaml:"enable_tls"`
+	TLSCertFile    string        `json:"tls_cert_file" toml:"tls_cert_file" yaml:"tls_cert_file"`
+	TLSKeyFile     string        `json:"tls_key_file" toml:"tls_key_file" yaml:"tls_key_file"`
+	EnableCORS     bool          `json:"enable_cors" toml:"enable_cors" yaml:"enable_cors"`
+	CORSOrigins    []string      `json:"cors_origins" toml:"cors_origins" yaml:"cors_origins"`
+	// TrackingTableMaxKeys caps the keys remembered for CLIENT TRACKING;
+	// zero means no limit
+	TrackingTableMaxKeys int `json:"tracking_table_max_keys" toml:"tracking_table_max_keys" yaml:"tracking_table_max_keys"`
+	// CommandTimeout aborts a command still running after it with a
+	// TIMEOUT error; zero means no limit
+	CommandTimeout time.Duration `json:"command_timeout" toml:"command_timeout" yaml:"command_timeout"`
+	// OutputBufferLimits disconnect clients leaving too much output
+	// unread, such as slow pub/sub subscribers
+	OutputBufferLimits OutputBufferLimits `json:"output_buffer_limits" toml:"output_buffer_limits" yaml:"output_buffer_limits"`
+	// RequestLimits cap the arguments and size of client commands
+	RequestLimits cache.RequestLimits `json:"request_limits" toml:"request_limits" yaml:"request_limits"`
+	// Listeners enable each front-end and set its port and TLS; see
+	// ListenerConfig for how they fall back to the settings above
+	Listeners ListenersConfig `json:"listeners" toml:"listeners" yaml:"listeners"`
+}
+
+// SecurityConfig holds security configuration. JWTSecret, TLSCertFile,
+// TLSKeyFile and VaultToken may be secret references such as
+// "file:///run/secrets/jwt", "env://JWT_SECRET" or
+// "vault://kv/cache#jwt_secret" instead of inline values.
+type SecurityConfig struct {
+	EnableAuth bool          `json:"enable_auth" toml:"enable_auth" yaml:"enable_auth"`
+	AuthType   string        `json:"auth_type" toml:"auth_type" yaml:"auth_type"`
+	JWTSecret  string        `json:"jwt_secret" toml:"jwt_secret" yaml:"jwt_secret"`
+	JWTExpiry  time.Duration `json:"jwt_expiry" toml:"jwt_expiry" yaml:"jwt_expiry"`
+	// RefreshExpiry is how long an HTTP API session lasts without being
+	// refreshed; its access tokens last JWTExpiry
+	RefreshExpiry   time.Duration `json:"refresh_expiry" toml:"refresh_expiry" yaml:"refresh_expiry"`
+	EnableACL       bool          `json:"enable_acl" toml:"enable_acl" yaml:"enable_acl"`
+	ACLFile         string        `json:"acl_file" toml:"acl_file" yaml:"acl_file"`
+	EnableTLS       bool          `json:"enable_tls" toml:"enable_tls" yaml:"enable_tls"`
+	TLSCertFile     string        `json:"tls_cert_file" toml:"tls_cert_file" yaml:"tls_cert_file"`
+	TLSKeyFile      string        `json:"tls_key_file" toml:"tls_key_file" yaml:"tls_key_file"`
+	EnableRateLimit bool          `json:"enable_rate_limit" toml:"enable_rate_limit" yaml:"enable_rate_limit"`
+	RateLimitRPM    int           `json:"rate_limit_rpm" toml:"rate_limit_rpm" yaml:"rate_limit_rpm"`
+	// RateLimitWriteRPM is the default write budget; zero uses RateLimitRPM,
+	// which is otherwise the read budget. RateLimitUsers overrides both for
+	// the named ACL users.
+	RateLimitWriteRPM int                   `json:"rate_limit_write_rpm" toml:"rate_limit_write_rpm" yaml:"rate_limit_write_rpm"`
+	RateLimitUsers    map[string]RateBudget `json:"rate_limit_users" toml:"rate_limit_users" yaml:"rate_limit_users"`
+	EnableIPFilter    bool                  `json:"enable_ip_filter" toml:"enable_ip_filter" yaml:"enable_ip_filter"`
+	AllowedIPs        []string              `json:"allowed_ips" toml:"allowed_ips" yaml:"allowed_ips"`
+	// RequirePass, when set, makes clients AUTH with this password before
+	// running commands, as with Redis's requirepass. It cannot be combined
+	// with an ACL file, where the default user's password is set instead.
+	RequirePass string `json:"requirepass" toml:"requirepass" yaml:"requirepass"`
+	// VaultAddress and VaultToken are used to resolve vault:// references
+	VaultAddress string `json:"vault_address" toml:"vault_address" yaml:"vault_address"`
+	VaultToken   string `json:"vault_token" toml:"vault_token" yaml:"vault_token"`
+	// SecretRefreshInterval is how often referenced secrets are re-read
+	SecretRefreshInterval time.Duration `json:"secret_refresh_interval" toml:"secret_refresh_interval" yaml:"secret_refresh_interval"`
+}
+
+// DefaultConfig returns the default server configuration
+func DefaultConfig() Config {
+	return Config{
+		Host:                 "0.0.0.0",
+		Port:                 6379,
+		HTTPPort:             8080,
+		ReadTimeout:          30 * time.Second,
+		WriteTimeout:         30 * time.Second,
+		MaxConnections:       10000,
+		EnableHTTP:           true,
+		EnableTLS:            false,
+		EnableCORS:           true,
+		CORSOrigins:          []string{"*"},
+		TrackingTableMaxKeys: defaultTrackingTableMaxKeys,
+		OutputBufferLimits:   DefaultOutputBufferLimits(),
+		RequestLimits:        cache.DefaultRequestLimits(),
+		Listeners:            DefaultListenersConfig(),
+	}
+}
+
+// DefaultSecurityConfig returns the default security configuration
+func DefaultSecurityConfig() SecurityConfig {
+	return SecurityConfig{
+		EnableAuth:            false,
+		AuthType:              "jwt",
+		JWTExpiry:             15 * time.Minute,
+		RefreshExpiry:         7 * 24 * time.Hour,
+		EnableACL:             false,
+		EnableRateLimit:       true,
+		RateLimitRPM:          1000,
+		VaultToken:            "env://VAULT_TOKEN",
+		SecretRefreshInterval: defaultSecretRefreshInterval,
+	}
+}
+
+// Validate validates the server configuration. The listeners are checked
+// with the whole configuration, as they fall back on other sections.
+func (c *Config) Validate() error {
+	if c.Port < 1 || c.Port > 65535 {
+		return fmt.Errorf("invalid server port: %d", c.Port)
+	}
+	if c.HTTPPort < 1 || c.HTTPPort > 65535 {
+		return fmt.Errorf("invalid HTTP port: %d", c.HTTPPort)
+	}
+	if c.TrackingTableMaxKeys < 0 {
+		return fmt.Errorf("tracking table max keys cannot be negative")
+	}
+	if c.CommandTimeout < 0 {
+		return fmt.Errorf("command timeout cannot be negative")
+	}
+	if err := c.OutputBufferLimits.Validate(); err != nil {
+		return err
+	}
+	if c.RequestLimits.MaxArgs < 0 || c.RequestLimits.MaxBulkBytes < 0 || c.RequestLimits.MaxRequestBytes < 0 {
+		return fmt.Errorf("request limits cannot be negative")
+	}
+	return nil
+}
+
+// Validate validates the security configuration
+func (c *SecurityConfig) Validate() error {
+	if c.RateLimitRPM < 0 || c.RateLimitWriteRPM < 0 {
+		return fmt.Errorf("rate limits cannot be negative")
+	}
+	for user, budget := range c.RateLimitUsers {
+		if budget.ReadRPM < 0 || budget.WriteRPM < 0 {
+			return fmt.Errorf("rate limits for user %q cannot be negative", user)
+		}
+	}
+	if c.EnableAuth {
+		if c.JWTSecret == "" {
+			return fmt.Errorf("JWT secret required when auth is enabled")
+		}
+		if c.JWTExpiry < time.Minute {
+			return fmt.Errorf("JWT expiry too short")
+		}
+		if c.RefreshExpiry < c.JWTExpiry {
+			return fmt.Errorf("refresh expiry must be at least the JWT expiry")
+		}
+	}
+	for _, ref := range []string{c.JWTSecret, c.TLSCertFile, c.TLSKeyFile, c.VaultToken, c.RequirePass} {
+		if err := ValidateSecretRef(ref); err != nil {
+			return err
+		}
+	}
+	if strings.HasPrefix(c.VaultToken, secretSchemeVault) {
+		return fmt.Errorf("the Vault token cannot itself be stored in Vault")
+	}
+	if c.SecretRefreshInterval < 0 {
+		return fmt.Errorf("secret refresh interval cannot be negative")
+	}
+	if c.EnableACL && c.ACLFile == "" {
+		return fmt.Errorf("ACL file required when ACLs are enabled")
+	}
+	if c.EnableACL && c.RequirePass != "" {
+		return fmt.Errorf("requirepass cannot be combined with an ACL file; set the default user's password there")
+	}
+	return nil
+}
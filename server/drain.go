@@ -0,0 +1,158 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// errDraining is sent to clients connecting to a draining node
+var errDraining = errors.New("LOADING server is draining, connect to another node")
+
+// Drain states
+const (
+	DrainServing  = "serving"
+	DrainDraining = "draining"
+	DrainDrained  = "drained"
+)
+
+// drainPollInterval is how often a drain checks whether it is complete
+const drainPollInterval = 100 * time.Millisecond
+
+// DrainStatus reports the progress of a drain
+type DrainStatus struct {
+	State   string    `json:"state"`
+	Started time.Time `json:"started,omitempty"`
+	// Connections are clients still connected. They are served until they
+	// disconnect and do not hold up the drain.
+	Connections int `json:"connections"`
+	// AOFPendingBytes are appended but not yet synced to disk
+	AOFPendingBytes int64 `json:"aof_pending_bytes"`
+	// ReplicationLag is the most writes any replica is missing
+	ReplicationLag uint64 `json:"replication_lag"`
+	// SafeToTerminate is set once every write is on disk and on every
+	// replica
+	SafeToTerminate bool `json:"safe_to_terminate"`
+}
+
+// Drainer takes a node out of service for maintenance: it stops accepting
+// client connections, syncs the AOF and waits for replicas to catch up,
+// then reports that the node can be terminated without losing writes.
+type Drainer struct {
+	cache   *cache.Cache
+	tcp     *TCPServer
+	cluster *cache.Cluster
+	logger  *log.Logger
+
+	mu      sync.Mutex
+	state   string
+	started time.Time
+	stop    chan struct{}
+}
+
+// NewDrainer creates a drainer for the node serving c over tcp. cluster
+// may be nil.
+func NewDrainer(c *cache.Cache, tcp *TCPServer, cluster *cache.Cluster, logger *log.Logger) *Drainer {
+	return &Drainer{cache: c, tcp: tcp, cluster: cluster, logger: logger, state: DrainServing}
+}
+
+// Drain starts draining the node and returns its status. Draining an
+// already draining node only reports its status.
+func (d *Drainer) Drain() DrainStatus {
+	d.mu.Lock()
+	if d.state == DrainServing {
+		d.state = DrainDraining
+		d.started = time.Now()
+		d.stop = make(chan struct{})
+		d.tcp.SetDraining(true)
+		if d.cluster != nil {
+			d.cluster.SetSelfState(cache.NodeDraining)
+		}
+		d.logger.Printf("Draining: refusing new connections and waiting for replicas")
+		go d.wait(d.stop)
+	}
+	d.mu.Unlock()
+
+	return d.Status()
+}
+
+// Resume cancels a drain and accepts connections again
+func (d *Drainer) Resume() DrainStatus {
+	d.mu.Lock()
+	if d.state != DrainServing {
+		close(d.stop)
+		d.stop = nil
+		d.state = DrainServing
+		d.started = time.Time{}
+		d.tcp.SetDraining(false)
+		if d.cluster != nil {
+			d.cluster.SetSelfState(cache.NodeOnline)
+		}
+		d.logger.Printf("Drain cancelled, accepting connections")
+	}
+	d.mu.Unlock()
+
+	return d.Status()
+}
+
+// Status reports the drain's progress
+func (d *Drainer) Status() DrainStatus {
+	d.mu.Lock()
+	status := DrainStatus{State: d.state, Started: d.started}
+	d.mu.Unlock()
+
+	status.Connections = d.tcp.ConnectionCount()
+	if aof := d.cache.AOF(); aof != nil {
+		status.AOFPendingBytes = aof.Stats().PendingBytes
+	}
+	if d.cluster != nil {
+		status.ReplicationLag = d.cluster.MaxReplicaLag()
+	}
+	status.SafeToTerminate = status.State == DrainDrained
+	return status
+}
+
+// wait keeps syncing the AOF and checking replicas until the drain is
+// cancelled. Connected clients can still write, so a drained node goes
+// back to draining while new writes reach the replicas.
+func (d *Drainer) wait(stop chan struct{}) {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := d.syncAOF()
+		if err != nil {
+			d.logger.Printf("Draining: AOF sync failed: %v", err)
+		}
+		drained := err == nil && d.Status().ReplicationLag == 0
+
+		d.mu.Lock()
+		if d.stop == stop {
+			if drained && d.state == DrainDraining {
+				d.logger.Printf("Drained in %v, safe to terminate", time.Since(d.started).Round(time.Millisecond))
+			}
+			if drained {
+				d.state = DrainDrained
+			} else {
+				d.state = DrainDraining
+			}
+		}
+		d.mu.Unlock()
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Drainer) syncAOF() error {
+	if aof := d.cache.AOF(); aof != nil {
+		return aof.Sync()
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package server
+
+import "github.com/hamisionesmus/distributed-cache/cache"
+
+func init() {
+	cache.RegisterCommands(
+		&cache.Command{Name: "NODE.FORWARD", Arity: -3, Flags: cache.FlagInternal, Handler: nodeForwardCommand},
+	)
+}
+
+// nodeForwardCommand implements NODE.FORWARD user command [arg ...], which
+// a node sends the owner of a command's keys. The command is run here
+// whatever this node's view of the ring, with the permissions of user.
+func nodeForwardCommand(ctx *cache.CommandContext) error {
+	dispatchCommand(&cache.CommandContext{
+		Context:   ctx.Context,
+		Cache:     ctx.Cache,
+		Client:    ctx.Client,
+		Args:      ctx.Args[2:],
+		Out:       ctx.Out,
+		Forwarded: true,
+		User:      string(ctx.Args[1]),
+	})
+	return nil
+}
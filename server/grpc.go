@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"strconv"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcCodec encodes gRPC messages as JSON. The cache service is registered
+// from a hand-written service descriptor, so clients select this codec with
+// grpc.CallContentSubtype("json").
+type grpcCodec struct{}
+
+func (grpcCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (grpcCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (grpcCodec) Name() string                               { return "json" }
+
+// WatchRequest starts a watch on keys with the given prefix. A non-empty
+// ResumeToken continues a previous stream from the event after that token.
+type WatchRequest struct {
+	Prefix      string `json:"prefix"`
+	ResumeToken string `json:"resume_token,omitempty"`
+}
+
+// WatchEvent is a single change delivered on a watch stream
+type WatchEvent struct {
+	Type        string `json:"type"`
+	Key         string `json:"key,omitempty"`
+	TimeUnixNs  int64  `json:"time_unix_ns"`
+	ResumeToken string `json:"resume_token"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// cacheService is the handler type of the cache gRPC service
+type cacheService interface {
+	Watch(req *WatchRequest, stream grpc.ServerStream) error
+}
+
+var cacheServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cache.v1.Cache",
+	HandlerType: (*cacheService)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       watchStreamHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "cache.v1",
+}
+
+func watchStreamHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(WatchRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(cacheService).Watch(req, stream)
+}
+
+// GRPCServer serves the gRPC API on top of the cache
+type GRPCServer struct {
+	cache  *cache.Cache
+	logger *log.Logger
+	server *grpc.Server
+}
+
+// NewGRPCServer creates a new gRPC API server for the given cache
+func NewGRPCServer(cache *cache.Cache, logger *log.Logger) *GRPCServer {
+	s := &GRPCServer{
+		cache:  cache,
+		logger: logger,
+		server: grpc.NewServer(grpc.ForceServerCodec(grpcCodec{})),
+	}
+	s.server.RegisterService(&cacheServiceDesc, s)
+	return s
+}
+
+// Start listens on addr and serves requests until the server is shut down
+func (s *GRPCServer) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves requests on listener, which may be a TLS listener, until
+// the server is shut down
+func (s *GRPCServer) Serve(listener net.Listener) error {
+	return s.server.Serve(listener)
+}
+
+// Shutdown stops the server gracefully, cancelling open streams if the
+// context expires first
+func (s *GRPCServer) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.server.Stop()
+		return ctx.Err()
+	}
+}
+
+// Watch streams keyspace changes for a prefix. Every event carries a resume
+// token; reconnecting with the last token received replays anything missed,
+// or fails with OutOfRange when those events are no longer retained.
+func (s *GRPCServer) Watch(req *WatchRequest, stream grpc.ServerStream) error {
+	var sub *cache.KeyEventSubscription
+	if req.ResumeToken == "" {
+		sub = s.cache.Subscribe(req.Prefix)
+	} else {
+		after, err := strconv.ParseUint(req.ResumeToken, 10, 64)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid resume token %q", req.ResumeToken)
+		}
+		sub, err = s.cache.SubscribeFrom(req.Prefix, after)
+		if err == cache.ErrEventsUnavailable {
+			return status.Error(codes.OutOfRange, "resume token expired, resynchronize and watch again")
+		} else if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	defer sub.Close()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+
+		case event, ok := <-sub.C:
+			if !ok {
+				return nil
+			}
+
+			// Falling behind means events were lost; end the stream so the
+			// client resumes from its last token instead of silently diverging
+			if sub.Dropped() > 0 {
+				return status.Error(codes.ResourceExhausted, "watcher fell behind, resume from the last token")
+			}
+
+			if err := stream.SendMsg(&WatchEvent{
+				Type:        string(event.Type),
+				Key:         event.Key,
+				TimeUnixNs:  event.Time.UnixNano(),
+				ResumeToken: strconv.FormatUint(event.Seq, 10),
+				Reason:      string(event.Reason),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+}
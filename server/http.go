@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// HTTPServer serves the REST API on top of the cache
+type HTTPServer struct {
+	cache  *cache.Cache
+	logger *log.Logger
+	server *http.Server
+
+	mu       sync.RWMutex
+	cluster  *cache.Cluster
+	drainer  *Drainer
+	sessions *Sessions
+	limiter  *RateLimiter
+	// corsOrigins may call the API from a browser
+	corsOrigins []string
+}
+
+// NewHTTPServer creates a new REST API server for the given cache
+func NewHTTPServer(cache *cache.Cache, logger *log.Logger) *HTTPServer {
+	s := &HTTPServer{
+		cache:  cache,
+		logger: logger,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/keys", s.requireSession(s.handleKeys))
+	mux.HandleFunc("/api/v1/keys/", s.requireSession(s.handleKey))
+	mux.HandleFunc("/api/v1/batch", s.requireSession(s.handleBatch))
+	mux.HandleFunc("/api/v1/events", s.requireSession(s.handleEvents))
+	mux.HandleFunc("/api/v1/export", s.requireAdmin(s.handleExport))
+	mux.HandleFunc("/api/v1/expiry/forecast", s.requireAdmin(s.handleExpiryForecast))
+	mux.HandleFunc("/api/v1/eviction/simulate", s.requireAdmin(s.handleEvictionSimulation))
+	mux.HandleFunc("/api/v1/tags/", s.requireSession(s.handleTag))
+	mux.HandleFunc("/api/v1/schedule", s.requireAdmin(s.handleSchedule))
+	mux.HandleFunc("/api/v1/schedule/", s.requireAdmin(s.handleScheduleJob))
+	mux.HandleFunc("/api/v1/leaderboards/", s.requireSession(s.handleLeaderboard))
+	mux.HandleFunc("/cluster/topology", s.requireAdmin(s.handleClusterTopology))
+	mux.HandleFunc("/cluster/nodes/", s.requireAdmin(s.handleClusterNode))
+	mux.HandleFunc("/cluster/drain", s.requireAdmin(s.handleDrain))
+	mux.HandleFunc("/cluster/reshard", s.requireAdmin(s.handleClusterUnsupported))
+	mux.HandleFunc("/cluster/failover", s.requireAdmin(s.handleClusterUnsupported))
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/auth/login", s.handleLogin)
+	mux.HandleFunc("/auth/refresh", s.handleRefresh)
+	mux.HandleFunc("/auth/logout", s.handleLogout)
+
+	s.server = &http.Server{
+		Handler: s.corsHandler(compressionHandler(mux)),
+	}
+	return s
+}
+
+// SetTimeouts bounds the time to read a request and to write its
+// response; zero means no limit. Call it before the server is started.
+func (s *HTTPServer) SetTimeouts(read, write time.Duration) {
+	s.server.ReadTimeout = read
+	s.server.WriteTimeout = write
+}
+
+// Start listens on addr and serves requests until the server is shut down
+func (s *HTTPServer) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves requests on listener, which may be a TLS listener, until
+// the server is shut down
+func (s *HTTPServer) Serve(listener net.Listener) error {
+	if err := s.server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the server, waiting for in-flight requests
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// handleKey dispatches requests for a single key
+func (s *HTTPServer) handleKey(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/api/v1/keys/")
+	if key == "" {
+		WriteHTTPError(w, http.StatusBadRequest, "missing key")
+		return
+	}
+	key, err := s.cache.NormalizeKey(key)
+	if err != nil {
+		WriteHTTPError(w, http.StatusBadRequest, strings.TrimPrefix(err.Error(), "ERR "))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if s.authorize(w, r, cache.FlagReadOnly, key) {
+			s.getKey(w, r, key)
+		}
+	case http.MethodPut, http.MethodPost:
+		if s.authorize(w, r, cache.FlagWrite, key) {
+			s.putKey(w, r, key)
+		}
+	case http.MethodDelete:
+		if !s.authorize(w, r, cache.FlagWrite, key) {
+			return
+		}
+		deleted, err := s.cache.Delete(r.Context(), key)
+		if err != nil {
+			WriteHTTPError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !deleted {
+			WriteHTTPError(w, http.StatusNotFound, "key not found")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, POST, DELETE")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// getKey writes the value of key in the representation requested by Accept
+func (s *HTTPServer) getKey(w http.ResponseWriter, r *http.Request, key string) {
+	w.Header().Add("Vary", "Accept")
+
+	codec := negotiateCodec(r.Header.Get("Accept"))
+	if codec == nil {
+		WriteHTTPError(w, http.StatusNotAcceptable, "no acceptable representation")
+		return
+	}
+
+	staleness, err := s.cache.CheckStaleness()
+	if err != nil {
+		WriteHTTPError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+	if staleness > 0 {
+		w.Header().Set(cache.StaleHeader, strconv.FormatFloat(staleness.Seconds(), 'f', 3, 64))
+	}
+
+	value, ok, err := s.cache.GetOrFetch(r.Context(), key)
+	if err != nil {
+		WriteHTTPError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	if !ok {
+		WriteHTTPError(w, http.StatusNotFound, "key not found")
+		return
+	}
+
+	if meta, ok := s.cache.Metadata(key); ok {
+		meta.WriteHeaders(w.Header())
+	}
+	w.Header().Set("Content-Type", codec.ContentType())
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err := codec.Encode(w, key, value); err != nil {
+		s.logger.Printf("Failed to encode value for %q: %v", key, err)
+	}
+}
+
+// putKey stores the request body under key, decoding it according to Content-Type
+func (s *HTTPServer) putKey(w http.ResponseWriter, r *http.Request, key string) {
+	codec := codecForContentType(r.Header.Get("Content-Type"))
+	if codec == nil {
+		WriteHTTPError(w, http.StatusUnsupportedMediaType, "unsupported content type")
+		return
+	}
+
+	body, err := decodeRequestBody(r)
+	if err != nil {
+		WriteHTTPError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	defer body.Close()
+
+	value, ttl, err := codec.Decode(http.MaxBytesReader(w, body, cache.MaxValueSize))
+	if err != nil {
+		WriteHTTPError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// An explicit ttl query parameter takes precedence over the body
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		if ttl, err = parseTTLParam(v); err != nil {
+			WriteHTTPError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	opts := cache.SetOptions{TTL: ttl}
+	if v := r.URL.Query().Get("tags"); v != "" {
+		opts.Tags = cache.ParseTags(v)
+	}
+	if v := r.URL.Query().Get("sliding"); v != "" {
+		sliding, err := strconv.ParseBool(v)
+		if err != nil || sliding && ttl == nil {
+			WriteHTTPError(w, http.StatusBadRequest, "invalid sliding")
+			return
+		}
+		opts.Sliding = sliding
+	}
+	if v := r.URL.Query().Get("cost"); v != "" {
+		cost, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || cost <= 0 {
+			WriteHTTPError(w, http.StatusBadRequest, "invalid cost")
+			return
+		}
+		opts.Cost = cost
+	}
+	opts.Origin = r.URL.Query().Get("origin")
+	if v := r.URL.Query().Get("pin"); v != "" {
+		pin, err := strconv.ParseBool(v)
+		if err != nil {
+			WriteHTTPError(w, http.StatusBadRequest, "invalid pin")
+			return
+		}
+		opts.Pin = pin
+	}
+
+	if err := s.cache.SetWithOptions(r.Context(), key, value, opts); err != nil {
+		WriteHTTPError(w, http.StatusInsufficientStorage, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WriteHTTPError writes a JSON error response
+func WriteHTTPError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
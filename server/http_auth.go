@@ -0,0 +1,435 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// Sessions issue and check the tokens guarding the HTTP admin API. Logging
+// in with ACL credentials opens a session and returns a short-lived JWT
+// access token and an opaque refresh token. Refreshing rotates both, and
+// logging out revokes the session, which invalidates its access token
+// straight away rather than at expiry.
+type Sessions struct {
+	acl           *ACL
+	secret        *Secret
+	expiry        time.Duration
+	refreshExpiry time.Duration
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	// refresh maps the SHA-256 digest of each live refresh token to its
+	// session ID
+	refresh map[string]string
+}
+
+// session is one login
+type session struct {
+	id      string
+	user    string
+	refresh string
+	expires time.Time
+}
+
+// sessionTokens is the reply to a login or refresh
+type sessionTokens struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+var errInvalidSession = errors.New("invalid or expired session")
+
+// NewSessions creates sessions for users of acl, signing access tokens
+// valid for expiry with secret. Sessions last refreshExpiry after the last
+// refresh.
+func NewSessions(acl *ACL, secret *Secret, expiry, refreshExpiry time.Duration) *Sessions {
+	return &Sessions{
+		acl:           acl,
+		secret:        secret,
+		expiry:        expiry,
+		refreshExpiry: refreshExpiry,
+		sessions:      make(map[string]*session),
+		refresh:       make(map[string]string),
+	}
+}
+
+// Login checks the credentials and opens a session
+func (s *Sessions) Login(username, password string) (*sessionTokens, error) {
+	if _, err := s.acl.Authenticate(username, password); err != nil {
+		return nil, err
+	}
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked(time.Now())
+	sess := &session{id: id, user: username}
+	s.sessions[id] = sess
+	return s.issueLocked(sess)
+}
+
+// Refresh exchanges a refresh token for new tokens. The old refresh token
+// can't be used again.
+func (s *Sessions) Refresh(refreshToken string) (*sessionTokens, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.pruneLocked(now)
+	id, ok := s.refresh[hashToken(refreshToken)]
+	if !ok {
+		return nil, errInvalidSession
+	}
+	sess := s.sessions[id]
+	if user := s.acl.user(sess.user); user == nil || !user.Enabled {
+		s.revokeLocked(sess)
+		return nil, errInvalidSession
+	}
+	delete(s.refresh, sess.refresh)
+	return s.issueLocked(sess)
+}
+
+// Revoke ends the session an access token belongs to
+func (s *Sessions) Revoke(accessToken string) error {
+	id, _, err := s.parse(accessToken)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sess, ok := s.sessions[id]; ok {
+		s.revokeLocked(sess)
+	}
+	return nil
+}
+
+// Verify checks an access token, returning the ACL user it was issued to
+func (s *Sessions) Verify(accessToken string) (*ACLUser, error) {
+	id, username, err := s.parse(accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	s.mu.Unlock()
+	if !ok || sess.user != username {
+		return nil, errInvalidSession
+	}
+	user := s.acl.user(username)
+	if user == nil || !user.Enabled {
+		return nil, errInvalidSession
+	}
+	return user, nil
+}
+
+// sessionUserKey is the request context key of the session's user
+type sessionUserKey struct{}
+
+// sessionUser is the ACL user a request's session signed in as
+type sessionUser struct {
+	acl  *ACL
+	user *ACLUser
+}
+
+// parse checks an access token's signature and expiry, returning its
+// session ID and user
+func (s *Sessions) parse(accessToken string) (id, username string, err error) {
+	var claims jwt.RegisteredClaims
+	_, err = jwt.ParseWithClaims(accessToken, &claims, func(*jwt.Token) (interface{}, error) {
+		return s.secret.Value(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil || claims.ID == "" {
+		return "", "", errInvalidSession
+	}
+	return claims.ID, claims.Subject, nil
+}
+
+// issueLocked signs a new access token and refresh token for sess
+func (s *Sessions) issueLocked(sess *session) (*sessionTokens, error) {
+	now := time.Now()
+	access, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		ID:        sess.id,
+		Subject:   sess.user,
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(s.expiry)),
+	}).SignedString(s.secret.Value())
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	sess.refresh = hashToken(refresh)
+	sess.expires = now.Add(s.refreshExpiry)
+	s.refresh[sess.refresh] = sess.id
+	return &sessionTokens{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.expiry / time.Second),
+		RefreshToken: refresh,
+	}, nil
+}
+
+func (s *Sessions) revokeLocked(sess *session) {
+	delete(s.sessions, sess.id)
+	delete(s.refresh, sess.refresh)
+}
+
+// pruneLocked drops sessions whose refresh token has expired
+func (s *Sessions) pruneLocked(now time.Time) {
+	for _, sess := range s.sessions {
+		if now.After(sess.expires) {
+			s.revokeLocked(sess)
+		}
+	}
+}
+
+// randomToken returns 256 random bits, base64url encoded
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the digest refresh tokens are looked up by, so a dump
+// of the session table holds no usable tokens
+func hashToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return string(digest[:])
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// SetSessions requires a session on the admin endpoints and enables the
+// /auth endpoints
+func (s *HTTPServer) SetSessions(sessions *Sessions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sessions = sessions
+}
+
+// sessionsOrError returns the sessions, answering 404 if they are off
+func (s *HTTPServer) sessionsOrError(w http.ResponseWriter) *Sessions {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.sessions == nil {
+		WriteHTTPError(w, http.StatusNotFound, "authentication is not enabled")
+	}
+	return s.sessions
+}
+
+// requireSession wraps an API handler, answering 401 unless the request
+// carries a valid access token and 429 once its user, or its IP without
+// sessions, is over the rate limit. GET and HEAD requests count as reads.
+// The handler checks the session's user with authorize. Without sessions
+// no token is needed.
+func (s *HTTPServer) requireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		sessions, limiter := s.sessions, s.limiter
+		s.mu.RUnlock()
+
+		var username string
+		if sessions != nil {
+			user, err := sessions.Verify(bearerToken(r))
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="cache"`)
+				WriteHTTPError(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			username = user.Name
+			r = r.WithContext(context.WithValue(r.Context(), sessionUserKey{}, sessionUser{acl: sessions.acl, user: user}))
+		}
+		write := r.Method != http.MethodGet && r.Method != http.MethodHead
+		if limiter != nil && !limiter.Allow(username, r.RemoteAddr, write) {
+			w.Header().Set("Retry-After", "1")
+			WriteHTTPError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireAdmin wraps an admin handler like requireSession, also answering
+// 403 unless the session's user has +@admin
+func (s *HTTPServer) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return s.requireSession(func(w http.ResponseWriter, r *http.Request) {
+		if s.authorize(w, r, cache.FlagAdmin) {
+			next(w, r)
+		}
+	})
+}
+
+// authorize answers 403 and returns false unless the session's user may
+// make the request, as checked by permit. Writes to keys a pending
+// distributed transaction holds are answered 409.
+func (s *HTTPServer) authorize(w http.ResponseWriter, r *http.Request, flags cache.CommandFlags, keys ...string) bool {
+	if err := permit(r.Context(), flags, keys); err != nil {
+		WriteHTTPError(w, http.StatusForbidden, err.Error())
+		return false
+	}
+	if flags&cache.FlagWrite != 0 {
+		if err := s.cache.CheckKeyLocks(keys...); err != nil {
+			w.Header().Set("Retry-After", "1")
+			WriteHTTPError(w, http.StatusConflict, err.Error())
+			return false
+		}
+	}
+	return true
+}
+
+// permit checks the session's user on ctx has the ACL categories in flags
+// and may read or write keys, as for a RESP command with those flags and
+// keys. Nil keys stands for every key. Without sessions everything is
+// permitted.
+func permit(ctx context.Context, flags cache.CommandFlags, keys []string) error {
+	su, ok := ctx.Value(sessionUserKey{}).(sessionUser)
+	if !ok {
+		return nil
+	}
+	if !su.user.hasCategories(flags) {
+		return errors.New("this user has no permissions for this endpoint")
+	}
+	if flags&cache.FlagAdmin != 0 {
+		return nil
+	}
+	if err := su.acl.checkKeys(su.user, flags, keys); err != nil {
+		return errors.New(strings.TrimPrefix(err.Error(), "NOPERM "))
+	}
+	return nil
+}
+
+// handleLogin serves POST /auth/login with a JSON body of username and
+// password, answering with a new session's tokens. Attempts count as
+// writes against the rate limits of both the client's IP and the user, so
+// passwords cannot be guessed faster than either allows.
+func (s *HTTPServer) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	sessions := s.authRequest(w, r, &req)
+	if sessions == nil {
+		return
+	}
+	if req.Username == "" {
+		req.Username = "default"
+	}
+	s.mu.RLock()
+	limiter := s.limiter
+	s.mu.RUnlock()
+	if limiter != nil && (!limiter.Allow("", r.RemoteAddr, true) || !limiter.Allow(req.Username, r.RemoteAddr, true)) {
+		w.Header().Set("Retry-After", "1")
+		WriteHTTPError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return
+	}
+
+	tokens, err := sessions.Login(req.Username, req.Password)
+	if errors.Is(err, errWrongPass) {
+		WriteHTTPError(w, http.StatusUnauthorized, "invalid username or password")
+		return
+	}
+	writeSessionTokens(w, tokens, err)
+}
+
+// handleRefresh serves POST /auth/refresh with a JSON body holding a
+// refresh token, answering with new tokens
+func (s *HTTPServer) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	sessions := s.authRequest(w, r, &req)
+	if sessions == nil {
+		return
+	}
+
+	tokens, err := sessions.Refresh(req.RefreshToken)
+	if errors.Is(err, errInvalidSession) {
+		WriteHTTPError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	writeSessionTokens(w, tokens, err)
+}
+
+// handleLogout serves POST /auth/logout, revoking the session of the
+// request's access token
+func (s *HTTPServer) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	sessions := s.sessionsOrError(w)
+	if sessions == nil {
+		return
+	}
+
+	if err := sessions.Revoke(bearerToken(r)); err != nil {
+		WriteHTTPError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authRequest checks the method of an /auth request and decodes its body
+// into req, returning nil once it has answered an invalid request
+func (s *HTTPServer) authRequest(w http.ResponseWriter, r *http.Request, req interface{}) *Sessions {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return nil
+	}
+	sessions := s.sessionsOrError(w)
+	if sessions == nil {
+		return nil
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(req); err != nil {
+		WriteHTTPError(w, http.StatusBadRequest, "invalid request body")
+		return nil
+	}
+	return sessions
+}
+
+// writeSessionTokens answers with tokens, or a 500 if issuing them failed
+func writeSessionTokens(w http.ResponseWriter, tokens *sessionTokens, err error) {
+	if err != nil {
+		WriteHTTPError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(tokens)
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// login posts a login for user from remoteAddr and returns the status
+func login(h *HTTPServer, remoteAddr, user, password string) int {
+	body := `{"username":"` + user + `","password":"` + password + `"}`
+	r := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(body))
+	r.RemoteAddr = remoteAddr
+	w := httptest.NewRecorder()
+	h.server.Handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+// TestLoginIsRateLimited checks that login attempts are refused once the
+// client's IP, or the user from any IP, is over its write budget
+func TestLoginIsRateLimited(t *testing.T) {
+	acl, err := NewRequirePassACL("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHTTPServer(newTxnCache(t), log.New(io.Discard, "", 0))
+	h.SetSessions(NewSessions(acl, &Secret{value: []byte("jwt")}, time.Minute, time.Hour))
+	h.SetRateLimiter(NewRateLimiter(RateBudget{WriteRPM: 6}, nil))
+
+	if code := login(h, "10.0.0.1:1000", "default", "wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("first wrong password answered %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := login(h, "10.0.0.1:1001", "default", "secret"); code != http.StatusTooManyRequests {
+		t.Errorf("second attempt from the same IP answered %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := login(h, "10.0.0.2:1000", "default", "secret"); code != http.StatusTooManyRequests {
+		t.Errorf("attempt for the same user from another IP answered %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := login(h, "10.0.0.3:1000", "other", "secret"); code != http.StatusUnauthorized {
+		t.Errorf("attempt for another user from another IP answered %d, want %d", code, http.StatusUnauthorized)
+	}
+}
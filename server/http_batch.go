@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// maxBatchOperations bounds the number of operations in a single batch request
+const maxBatchOperations = 1000
+
+// batchOperation is a single get/set/del within a batch request
+type batchOperation struct {
+	Op       string `json:"op"`
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	TTL      int64  `json:"ttl,omitempty"`
+}
+
+// batchResult reports the outcome of one batch operation using HTTP status codes
+type batchResult struct {
+	Status   int    `json:"status"`
+	Value    string `json:"value,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// handleBatch executes an array of operations and reports a result per item.
+// The batch itself succeeds even when individual operations fail.
+func (s *HTTPServer) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	body, err := decodeRequestBody(r)
+	if err != nil {
+		WriteHTTPError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	defer body.Close()
+
+	var ops []batchOperation
+	if err := json.NewDecoder(http.MaxBytesReader(w, body, cache.MaxValueSize)).Decode(&ops); err != nil {
+		WriteHTTPError(w, http.StatusBadRequest, fmt.Sprintf("invalid batch body: %v", err))
+		return
+	}
+	if len(ops) > maxBatchOperations {
+		WriteHTTPError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("batch exceeds %d operations", maxBatchOperations))
+		return
+	}
+
+	results := make([]batchResult, len(ops))
+	for i, op := range ops {
+		results[i] = s.executeBatchOperation(r.Context(), op)
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
+
+// executeBatchOperation applies a single batch operation to the cache
+func (s *HTTPServer) executeBatchOperation(ctx context.Context, op batchOperation) batchResult {
+	if op.Key == "" {
+		return batchResult{Status: http.StatusBadRequest, Error: "missing key"}
+	}
+
+	flags := cache.FlagWrite
+	if strings.EqualFold(op.Op, "get") {
+		flags = cache.FlagReadOnly
+	}
+	if err := permit(ctx, flags, []string{op.Key}); err != nil {
+		return batchResult{Status: http.StatusForbidden, Error: err.Error()}
+	}
+	if flags == cache.FlagWrite {
+		if err := s.cache.CheckKeyLocks(op.Key); err != nil {
+			return batchResult{Status: http.StatusConflict, Error: err.Error()}
+		}
+	}
+
+	switch strings.ToLower(op.Op) {
+	case "get":
+		value, ok := s.cache.Get(ctx, op.Key)
+		if !ok {
+			return batchResult{Status: http.StatusNotFound, Error: "key not found"}
+		}
+		result := batchResult{Status: http.StatusOK}
+		result.Value, result.Encoding = encodeTextValue(value)
+		return result
+
+	case "set":
+		value, err := decodeTextValue(op.Value, op.Encoding)
+		if err != nil {
+			return batchResult{Status: http.StatusBadRequest, Error: err.Error()}
+		}
+
+		ttl, err := ttlFromSeconds(op.TTL)
+		if err != nil {
+			return batchResult{Status: http.StatusBadRequest, Error: err.Error()}
+		}
+		if err := s.cache.SetWithOptions(ctx, op.Key, value, cache.SetOptions{TTL: ttl}); err != nil {
+			return batchResult{Status: http.StatusInsufficientStorage, Error: err.Error()}
+		}
+		return batchResult{Status: http.StatusNoContent}
+
+	case "del":
+		deleted, err := s.cache.Delete(ctx, op.Key)
+		if err != nil {
+			return batchResult{Status: http.StatusInternalServerError, Error: err.Error()}
+		}
+		if !deleted {
+			return batchResult{Status: http.StatusNotFound, Error: "key not found"}
+		}
+		return batchResult{Status: http.StatusNoContent}
+
+	default:
+		return batchResult{Status: http.StatusBadRequest, Error: fmt.Sprintf("unknown operation: %q", op.Op)}
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// bulkValue is a value in a bulk request or response, encoded as in
+// jsonDocument
+type bulkValue struct {
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"`
+	TTL      int64  `json:"ttl,omitempty"`
+}
+
+// handleKeys reads, writes and deletes many keys at once. GET and DELETE
+// name the keys with repeated key query parameters; PUT and POST take a
+// JSON object mapping keys to values.
+func (s *HTTPServer) handleKeys(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+		keys, ok := s.bulkKeys(w, r)
+		if !ok {
+			return
+		}
+		if r.Method == http.MethodGet {
+			if s.authorize(w, r, cache.FlagReadOnly, keys...) {
+				s.getKeys(w, r, keys)
+			}
+		} else if s.authorize(w, r, cache.FlagWrite, keys...) {
+			s.deleteKeys(w, r, keys)
+		}
+	case http.MethodPut, http.MethodPost:
+		s.putKeys(w, r)
+	default:
+		w.Header().Set("Allow", "GET, PUT, POST, DELETE")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// bulkKeys returns the normalized keys named by the request's key query
+// parameters, writing an error response if they are invalid
+func (s *HTTPServer) bulkKeys(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	keys := r.URL.Query()["key"]
+	if len(keys) == 0 {
+		WriteHTTPError(w, http.StatusBadRequest, "missing key")
+		return nil, false
+	}
+	if len(keys) > maxBatchOperations {
+		WriteHTTPError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("request exceeds %d keys", maxBatchOperations))
+		return nil, false
+	}
+	for i, key := range keys {
+		normalized, err := s.cache.NormalizeKey(key)
+		if err != nil {
+			WriteHTTPError(w, http.StatusBadRequest, key+": "+strings.TrimPrefix(err.Error(), "ERR "))
+			return nil, false
+		}
+		keys[i] = normalized
+	}
+	return keys, true
+}
+
+// getKeys writes the keys that were found as a JSON object; missing keys
+// are left out
+func (s *HTTPServer) getKeys(w http.ResponseWriter, r *http.Request, keys []string) {
+	found := make(map[string]bulkValue, len(keys))
+	for _, key := range keys {
+		value, ok, err := s.cache.GetOrFetch(r.Context(), key)
+		if err != nil {
+			WriteHTTPError(w, http.StatusBadGateway, err.Error())
+			return
+		}
+		if !ok {
+			continue
+		}
+		var v bulkValue
+		v.Value, v.Encoding = encodeTextValue(value)
+		found[key] = v
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": found})
+}
+
+// putKeys stores every key in the request body. A ttl query parameter
+// applies to the values that do not carry their own.
+func (s *HTTPServer) putKeys(w http.ResponseWriter, r *http.Request) {
+	var defaultTTL *time.Duration
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		var err error
+		if defaultTTL, err = parseTTLParam(v); err != nil {
+			WriteHTTPError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	body, err := decodeRequestBody(r)
+	if err != nil {
+		WriteHTTPError(w, http.StatusUnsupportedMediaType, err.Error())
+		return
+	}
+	defer body.Close()
+
+	var values map[string]bulkValue
+	if err := json.NewDecoder(http.MaxBytesReader(w, body, cache.MaxValueSize)).Decode(&values); err != nil {
+		WriteHTTPError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		return
+	}
+	if len(values) > maxBatchOperations {
+		WriteHTTPError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("request exceeds %d keys", maxBatchOperations))
+		return
+	}
+
+	// Validate everything first so a bad entry does not leave the others
+	// half written
+	type pending struct {
+		key   string
+		value []byte
+		ttl   *time.Duration
+	}
+	writes := make([]pending, 0, len(values))
+	for key, v := range values {
+		normalized, err := s.cache.NormalizeKey(key)
+		if err != nil {
+			WriteHTTPError(w, http.StatusBadRequest, key+": "+strings.TrimPrefix(err.Error(), "ERR "))
+			return
+		}
+		value, err := decodeTextValue(v.Value, v.Encoding)
+		if err != nil {
+			WriteHTTPError(w, http.StatusBadRequest, fmt.Sprintf("%s: %v", key, err))
+			return
+		}
+		ttl := defaultTTL
+		if v.TTL != 0 {
+			if ttl, err = ttlFromSeconds(v.TTL); err != nil {
+				WriteHTTPError(w, http.StatusBadRequest, fmt.Sprintf("%s: %v", key, err))
+				return
+			}
+		}
+		writes = append(writes, pending{key: normalized, value: value, ttl: ttl})
+	}
+	keys := make([]string, len(writes))
+	for i, p := range writes {
+		keys[i] = p.key
+	}
+	if !s.authorize(w, r, cache.FlagWrite, keys...) {
+		return
+	}
+
+	for _, p := range writes {
+		if err := s.cache.SetWithOptions(r.Context(), p.key, p.value, cache.SetOptions{TTL: p.ttl}); err != nil {
+			WriteHTTPError(w, http.StatusInsufficientStorage, fmt.Sprintf("%s: %v", p.key, err))
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteKeys deletes keys and reports how many existed
+func (s *HTTPServer) deleteKeys(w http.ResponseWriter, r *http.Request, keys []string) {
+	deleted := 0
+	for _, key := range keys {
+		ok, err := s.cache.Delete(r.Context(), key)
+		if err != nil {
+			WriteHTTPError(w, http.StatusInternalServerError, fmt.Sprintf("%s: %v", key, err))
+			return
+		}
+		if ok {
+			deleted++
+		}
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]int{"deleted": deleted})
+}
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// handleClusterTopology serves GET /cluster/topology with the nodes, roles,
+// replication offsets and health known to this node
+func (s *HTTPServer) handleClusterTopology(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	cluster := s.clusterOrError(w)
+	if cluster == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(cluster.Topology())
+}
+
+// handleClusterNode serves POST /cluster/nodes/{id}/forget
+func (s *HTTPServer) handleClusterNode(w http.ResponseWriter, r *http.Request) {
+	id, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/cluster/nodes/"), "/")
+	if !ok || id == "" || action != "forget" {
+		WriteHTTPError(w, http.StatusNotFound, "unknown node action")
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	cluster := s.clusterOrError(w)
+	if cluster == nil {
+		return
+	}
+
+	switch err := cluster.Forget(id); {
+	case errors.Is(err, cache.ErrUnknownNode):
+		WriteHTTPError(w, http.StatusNotFound, err.Error())
+	case err != nil:
+		WriteHTTPError(w, http.StatusConflict, err.Error())
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleDrain serves /cluster/drain: GET reports the drain's progress,
+// POST starts draining this node and DELETE cancels the drain
+func (s *HTTPServer) handleDrain(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	drainer := s.drainer
+	s.mu.RUnlock()
+	if drainer == nil {
+		WriteHTTPError(w, http.StatusServiceUnavailable, "draining is not available")
+		return
+	}
+
+	var status DrainStatus
+	switch r.Method {
+	case http.MethodGet:
+		status = drainer.Status()
+	case http.MethodPost:
+		status = drainer.Drain()
+	case http.MethodDelete:
+		status = drainer.Resume()
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(status)
+}
+
+// handleClusterUnsupported answers admin actions that need slot-based
+// resharding or in-cluster replication, which this node does not have.
+// Keys partitioned by the hash ring move on their own as nodes join and
+// leave.
+func (s *HTTPServer) handleClusterUnsupported(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	WriteHTTPError(w, http.StatusNotImplemented, strings.TrimPrefix(r.URL.Path, "/cluster/")+" is not supported by this cluster")
+}
+
+// clusterOrError returns the cluster, answering 503 if clustering is off
+func (s *HTTPServer) clusterOrError(w http.ResponseWriter) *cache.Cluster {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.cluster == nil {
+		WriteHTTPError(w, http.StatusServiceUnavailable, "clustering is not enabled")
+	}
+	return s.cluster
+}
+
+// SetCluster enables the cluster admin endpoints
+func (s *HTTPServer) SetCluster(cluster *cache.Cluster) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cluster = cluster
+}
+
+// SetDrainer enables the drain endpoint
+func (s *HTTPServer) SetDrainer(drainer *Drainer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.drainer = drainer
+}
+
+// readiness is the body of /readyz
+type readiness struct {
+	Status string              `json:"status"`
+	Warmup *cache.WarmupStatus `json:"warmup,omitempty"`
+}
+
+// handleReadyz serves GET /readyz for load balancers and orchestrators. It
+// answers 503 while a warm-up is still reading the snapshots and while the
+// node drains. Once the hottest keys start loading the node takes traffic:
+// it answers 200 with a status of "warming" until the warm-up ends, and
+// "ready" after.
+func (s *HTTPServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	s.mu.RLock()
+	drainer := s.drainer
+	s.mu.RUnlock()
+
+	ready, code := readiness{Status: "ready"}, http.StatusOK
+	if status, ok := s.cache.Warmup(); ok && status.Warming {
+		ready.Status, ready.Warmup = "warming", &status
+		if status.Total == 0 {
+			code = http.StatusServiceUnavailable
+		}
+	} else if drainer != nil && drainer.Status().State != DrainServing {
+		ready, code = readiness{Status: "draining"}, http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(ready)
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	contentTypeJSON    = "application/json"
+	contentTypeMsgpack = "application/msgpack"
+	contentTypeOctet   = "application/octet-stream"
+)
+
+// valueCodec converts cache values to and from an HTTP representation
+type valueCodec interface {
+	ContentType() string
+	Encode(w io.Writer, key string, value []byte) error
+	Decode(r io.Reader) ([]byte, *time.Duration, error)
+}
+
+var (
+	jsonValueCodec    valueCodec = jsonCodec{}
+	msgpackValueCodec valueCodec = msgpackCodec{}
+	octetValueCodec   valueCodec = octetCodec{}
+)
+
+// valueCodecs maps media types to the codec that handles them
+var valueCodecs = map[string]valueCodec{
+	contentTypeJSON:           jsonValueCodec,
+	contentTypeMsgpack:        msgpackValueCodec,
+	"application/x-msgpack":   msgpackValueCodec,
+	"application/vnd.msgpack": msgpackValueCodec,
+	contentTypeOctet:          octetValueCodec,
+}
+
+// negotiateCodec picks the codec preferred by an Accept header,
+// or nil if none of the accepted media types are supported
+func negotiateCodec(accept string) valueCodec {
+	if strings.TrimSpace(accept) == "" {
+		return jsonValueCodec
+	}
+
+	var best valueCodec
+	bestQ := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, q := parseQualityValue(part)
+		if q <= 0 {
+			continue
+		}
+
+		var codec valueCodec
+		switch mediaType {
+		case "*/*", "application/*":
+			codec = jsonValueCodec
+		default:
+			codec = valueCodecs[mediaType]
+		}
+
+		// Ties keep the earliest match, matching the client's stated order
+		if codec != nil && q > bestQ {
+			best, bestQ = codec, q
+		}
+	}
+	return best
+}
+
+// codecForContentType returns the codec for a request Content-Type,
+// treating a missing header as raw bytes
+func codecForContentType(contentType string) valueCodec {
+	if contentType == "" {
+		return octetValueCodec
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	return valueCodecs[mediaType]
+}
+
+// parseQualityValue splits an Accept or Accept-Encoding element into its
+// lowercased value and q parameter
+func parseQualityValue(part string) (string, float64) {
+	fields := strings.Split(part, ";")
+	value := strings.ToLower(strings.TrimSpace(fields[0]))
+	q := 1.0
+	for _, param := range fields[1:] {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(param, "q=") {
+			continue
+		}
+		if parsed, err := strconv.ParseFloat(param[2:], 64); err == nil {
+			q = parsed
+		}
+	}
+	return value, q
+}
+
+// ttlFromSeconds converts a wire TTL into the cache representation
+func ttlFromSeconds(seconds int64) (*time.Duration, error) {
+	if seconds < 0 {
+		return nil, fmt.Errorf("invalid ttl: %d", seconds)
+	}
+	if seconds == 0 {
+		return nil, nil
+	}
+	ttl := time.Duration(seconds) * time.Second
+	return &ttl, nil
+}
+
+// parseTTLParam parses a ttl query parameter: whole seconds, or a duration
+// such as "90s" or "1h30m"
+func parseTTLParam(v string) (*time.Duration, error) {
+	ttl, err := time.ParseDuration(v)
+	if err != nil {
+		seconds, perr := strconv.ParseInt(v, 10, 64)
+		if perr != nil {
+			return nil, fmt.Errorf("invalid ttl: %s", v)
+		}
+		ttl = time.Duration(seconds) * time.Second
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("invalid ttl: %s", v)
+	}
+	return &ttl, nil
+}
+
+// encodeTextValue renders a value as a string for text formats, falling
+// back to base64 for values that are not valid UTF-8
+func encodeTextValue(value []byte) (string, string) {
+	if utf8.Valid(value) {
+		return string(value), ""
+	}
+	return base64.StdEncoding.EncodeToString(value), "base64"
+}
+
+// decodeTextValue reverses encodeTextValue
+func decodeTextValue(text, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(text), nil
+	case "base64":
+		value, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 value: %w", err)
+		}
+		return value, nil
+	default:
+		return nil, fmt.Errorf("unsupported value encoding: %s", encoding)
+	}
+}
+
+// jsonDocument is the JSON representation of a value. Values that are not
+// valid UTF-8 are base64 encoded and flagged through Encoding.
+type jsonDocument struct {
+	Key      string `json:"key,omitempty"`
+	Value    string `json:"value"`
+	Encoding string `json:"encoding,omitempty"`
+	TTL      int64  `json:"ttl,omitempty"`
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return contentTypeJSON }
+
+func (jsonCodec) Encode(w io.Writer, key string, value []byte) error {
+	doc := jsonDocument{Key: key}
+	doc.Value, doc.Encoding = encodeTextValue(value)
+	return json.NewEncoder(w).Encode(doc)
+}
+
+func (jsonCodec) Decode(r io.Reader) ([]byte, *time.Duration, error) {
+	var doc jsonDocument
+	if err := json.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid JSON body: %w", err)
+	}
+
+	value, err := decodeTextValue(doc.Value, doc.Encoding)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ttl, err := ttlFromSeconds(doc.TTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	return value, ttl, nil
+}
+
+// msgpackDocument is the MessagePack representation of a value
+type msgpackDocument struct {
+	Key   string `msgpack:"key,omitempty"`
+	Value []byte `msgpack:"value"`
+	TTL   int64  `msgpack:"ttl,omitempty"`
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string { return contentTypeMsgpack }
+
+func (msgpackCodec) Encode(w io.Writer, key string, value []byte) error {
+	return msgpack.NewEncoder(w).Encode(msgpackDocument{Key: key, Value: value})
+}
+
+func (msgpackCodec) Decode(r io.Reader) ([]byte, *time.Duration, error) {
+	var doc msgpackDocument
+	if err := msgpack.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid MessagePack body: %w", err)
+	}
+	ttl, err := ttlFromSeconds(doc.TTL)
+	if err != nil {
+		return nil, nil, err
+	}
+	return doc.Value, ttl, nil
+}
+
+// octetCodec transfers values as raw bytes; TTLs travel in the query string
+type octetCodec struct{}
+
+func (octetCodec) ContentType() string { return contentTypeOctet }
+
+func (octetCodec) Encode(w io.Writer, key string, value []byte) error {
+	_, err := w.Write(value)
+	return err
+}
+
+func (octetCodec) Decode(r io.Reader) ([]byte, *time.Duration, error) {
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return value, nil, nil
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressMinSize is the smallest response body worth compressing
+const compressMinSize = 1024
+
+// compressionHandler compresses response bodies larger than compressMinSize
+// using the best encoding the client accepts
+func compressionHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding, status: http.StatusOK}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks zstd or gzip from an Accept-Encoding header,
+// preferring zstd when both are equally acceptable
+func negotiateEncoding(acceptEncoding string) string {
+	best := ""
+	bestQ := 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		encoding, q := parseQualityValue(part)
+		if encoding != "zstd" && encoding != "gzip" {
+			continue
+		}
+		if q > bestQ || (q == bestQ && q > 0 && encoding == "zstd") {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// decodeRequestBody unwraps a request body sent with Content-Encoding
+func decodeRequestBody(r *http.Request) (io.ReadCloser, error) {
+	switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+	case "", "identity":
+		return r.Body, nil
+	case "gzip":
+		return gzip.NewReader(r.Body)
+	case "zstd":
+		decoder, err := zstd.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding")
+	}
+}
+
+// compressWriter buffers the start of a response and switches to a
+// compressed stream once the body grows past compressMinSize
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	status      int
+	buf         []byte
+	encoder     io.WriteCloser
+	passthrough bool
+	wroteHeader bool
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	cw.status = status
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if cw.encoder != nil {
+		return cw.encoder.Write(p)
+	}
+	if cw.passthrough {
+		return cw.ResponseWriter.Write(p)
+	}
+
+	// Handlers that already encoded their body are left alone
+	if cw.Header().Get("Content-Encoding") != "" {
+		cw.passthrough = true
+		cw.flushHeader()
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) < compressMinSize {
+		return len(p), nil
+	}
+
+	if err := cw.startEncoder(); err != nil {
+		return 0, err
+	}
+	if _, err := cw.encoder.Write(cw.buf); err != nil {
+		return 0, err
+	}
+	cw.buf = nil
+	return len(p), nil
+}
+
+// startEncoder commits the compressed response headers and creates the encoder
+func (cw *compressWriter) startEncoder() error {
+	header := cw.Header()
+	header.Set("Content-Encoding", cw.encoding)
+	header.Del("Content-Length")
+	cw.flushHeader()
+
+	switch cw.encoding {
+	case "zstd":
+		encoder, err := zstd.NewWriter(cw.ResponseWriter)
+		if err != nil {
+			return err
+		}
+		cw.encoder = encoder
+	default:
+		cw.encoder = gzip.NewWriter(cw.ResponseWriter)
+	}
+	return nil
+}
+
+// Flush pushes buffered data to the client. Streams that flush before
+// reaching compressMinSize are sent uncompressed from then on.
+func (cw *compressWriter) Flush() {
+	flusher, ok := cw.ResponseWriter.(http.Flusher)
+	if !ok {
+		return
+	}
+
+	switch {
+	case cw.encoder != nil:
+		if f, ok := cw.encoder.(interface{ Flush() error }); ok {
+			f.Flush()
+		}
+	case !cw.passthrough:
+		cw.passthrough = true
+		cw.flushHeader()
+		if len(cw.buf) > 0 {
+			cw.ResponseWriter.Write(cw.buf)
+			cw.buf = nil
+		}
+	}
+	flusher.Flush()
+}
+
+func (cw *compressWriter) flushHeader() {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+}
+
+// Close finishes the compressed stream, or writes small bodies uncompressed
+func (cw *compressWriter) Close() error {
+	if cw.encoder != nil {
+		return cw.encoder.Close()
+	}
+	cw.flushHeader()
+	if len(cw.buf) > 0 {
+		_, err := cw.ResponseWriter.Write(cw.buf)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// corsAllowHeaders are the request headers browsers may send cross-origin,
+// and corsExposeHeaders the response headers their scripts may read
+const (
+	corsAllowHeaders  = "Authorization, Content-Type, Content-Encoding, Accept, Accept-Encoding"
+	corsExposeHeaders = cache.CreatedAtHeader + ", " + cache.LastAccessedHeader + ", " + cache.AccessCountHeader + ", " +
+		cache.TTLHeader + ", " + cache.SizeHeader + ", " + cache.OriginHeader + ", " + cache.VersionHeader + ", " + cache.StaleHeader
+)
+
+// corsHandler lets browsers on the origins set with SetCORSOrigins call the
+// API, answering preflight requests itself
+func (s *HTTPServer) corsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+
+		allowed, any := s.corsAllowed(origin)
+		if !allowed {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if any {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Set("Access-Control-Expose-Headers", corsExposeHeaders)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, PUT, POST, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// corsAllowed reports whether origin may call the API, and whether every
+// origin may
+func (s *HTTPServer) corsAllowed(origin string) (allowed, any bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, o := range s.corsOrigins {
+		if o == "*" {
+			return true, true
+		}
+		if strings.EqualFold(o, origin) {
+			return true, false
+		}
+	}
+	return false, false
+}
+
+// SetCORSOrigins lets browsers on origins call the API; "*" allows any
+// origin and none disables CORS
+func (s *HTTPServer) SetCORSOrigins(origins []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.corsOrigins = origins
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// sseKeepAliveInterval is how often an idle event stream sends a comment
+// so proxies don't time out the connection
+const sseKeepAliveInterval = 15 * time.Second
+
+// handleEvents streams keyspace changes as Server-Sent Events.
+// The optional prefix query parameter restricts the stream to matching keys,
+// and reconnecting clients resume after the event named by Last-Event-ID.
+func (s *HTTPServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if !s.authorize(w, r, cache.FlagReadOnly) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteHTTPError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	prefix := r.URL.Query().Get("prefix")
+	var sub *cache.KeyEventSubscription
+	if lastID := r.Header.Get("Last-Event-ID"); lastID != "" {
+		after, err := strconv.ParseUint(lastID, 10, 64)
+		if err != nil {
+			WriteHTTPError(w, http.StatusBadRequest, "invalid Last-Event-ID")
+			return
+		}
+		if sub, err = s.cache.SubscribeFrom(prefix, after); err != nil {
+			WriteHTTPError(w, http.StatusGone, err.Error())
+			return
+		}
+	} else {
+		sub = s.cache.Subscribe(prefix)
+	}
+	defer sub.Close()
+
+	header := w.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	var reportedDrops int64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			// Tell the client it missed events so it can resynchronize
+			if dropped := sub.Dropped(); dropped > reportedDrops {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"count\":%d}\n\n", dropped-reportedDrops)
+				reportedDrops = dropped
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
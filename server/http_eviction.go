@@ -0,0 +1,52 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// handleExpiryForecast serves GET /api/v1/expiry/forecast, optionally for
+// the keys starting with the prefix query parameter
+func (s *HTTPServer) handleExpiryForecast(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	forecast := s.cache.ExpiryForecast(r.URL.Query().Get("prefix"))
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(forecast)
+}
+
+// handleEvictionSimulation serves POST /api/v1/eviction/simulate with a
+// JSON SimulationRequest, answering with a SimulationReport
+func (s *HTTPServer) handleEvictionSimulation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var req cache.SimulationRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+			WriteHTTPError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+
+	report, err := s.cache.SimulateEviction(req)
+	switch {
+	case errors.Is(err, cache.ErrNoAccessTrace):
+		WriteHTTPError(w, http.StatusConflict, err.Error())
+		return
+	case err != nil:
+		WriteHTTPError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(report)
+}
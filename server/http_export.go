@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// handleExport serves GET /api/v1/export, streaming the keyspace as NDJSON
+// or CSV. Query parameters: prefix limits the export to matching keys,
+// format is ndjson (the default) or csv, after resumes an interrupted
+// export after the last key received, rate caps the keys sent per second
+// and consistent=true exports a point-in-time view instead of the keys as
+// they are when each batch is read. A CSV export starts with a header row
+// unless it is resumed.
+func (s *HTTPServer) handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	query := r.URL.Query()
+	format, err := cache.ParseExportFormat(query.Get("format"))
+	if err != nil {
+		WriteHTTPError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts := cache.ExportOptions{Prefix: query.Get("prefix"), After: query.Get("after")}
+	if v := query.Get("rate"); v != "" {
+		if opts.Rate, err = strconv.Atoi(v); err != nil || opts.Rate < 0 {
+			WriteHTTPError(w, http.StatusBadRequest, "invalid rate")
+			return
+		}
+	}
+	if v := query.Get("consistent"); v != "" {
+		if opts.Consistent, err = strconv.ParseBool(v); err != nil {
+			WriteHTTPError(w, http.StatusBadRequest, "invalid consistent")
+			return
+		}
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteHTTPError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	header := w.Header()
+	header.Set("Cache-Control", "no-store")
+	header.Set("X-Accel-Buffering", "no")
+	var emit func([]cache.ExportRecord) error
+	if format == cache.ExportCSV {
+		header.Set("Content-Type", "text/csv; charset=utf-8")
+		cw := csv.NewWriter(w)
+		if opts.After == "" {
+			cw.Write(cache.ExportCSVHeader)
+		}
+		emit = func(records []cache.ExportRecord) error {
+			for _, rec := range records {
+				cw.Write(rec.CSVRow())
+			}
+			cw.Flush()
+			flusher.Flush()
+			return cw.Error()
+		}
+	} else {
+		header.Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		emit = func(records []cache.ExportRecord) error {
+			for _, rec := range records {
+				if err := enc.Encode(rec); err != nil {
+					return err
+				}
+			}
+			flusher.Flush()
+			return nil
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+
+	// The status is already sent, so a failure can only cut the stream
+	// short; clients resume from the last complete record
+	if err := s.cache.Export(r.Context(), opts, emit); err != nil && r.Context().Err() == nil {
+		s.logger.Printf("Export stopped: %v", err)
+	}
+}
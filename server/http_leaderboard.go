@@ -0,0 +1,208 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// Leaderboards are sorted sets served over HTTP, ranked from the highest
+// score. The leaderboard name is the sorted set's key, so RESP clients see
+// the same data through ZREVRANGE and friends.
+
+// Leaderboard page sizes
+const (
+	defaultLeaderboardLimit = 10
+	maxLeaderboardLimit     = 1000
+)
+
+// Score submission modes
+const (
+	scoreModeSet       = "set"
+	scoreModeIncrement = "increment"
+	// scoreModeBest keeps the higher of the old and new scores
+	scoreModeBest = "best"
+)
+
+// leaderboardEntry is a member's 1-based rank and score
+type leaderboardEntry struct {
+	Rank   int     `json:"rank"`
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// leaderboardPage is a page of a leaderboard's top entries
+type leaderboardPage struct {
+	Name    string             `json:"name"`
+	Total   int                `json:"total"`
+	Entries []leaderboardEntry `json:"entries"`
+	// NextOffset is the offset of the next page, if there is one
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+// handleLeaderboard serves the leaderboard API:
+//
+//	GET    /api/v1/leaderboards/{name}?offset=0&limit=10  top entries
+//	POST   /api/v1/leaderboards/{name}/scores             submit a score
+//	GET    /api/v1/leaderboards/{name}/members/{member}   a member's rank
+//	DELETE /api/v1/leaderboards/{name}/members/{member}   remove a member
+func (s *HTTPServer) handleLeaderboard(w http.ResponseWriter, r *http.Request) {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/leaderboards/"), "/")
+	if name == "" {
+		WriteHTTPError(w, http.StatusBadRequest, "missing leaderboard name")
+		return
+	}
+	action, member, _ := strings.Cut(rest, "/")
+	flags := cache.FlagWrite
+	if r.Method == http.MethodGet {
+		flags = cache.FlagReadOnly
+	}
+	if !s.authorize(w, r, flags, name) {
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		s.leaderboardTop(w, r, name)
+	case action == "scores" && member == "" && r.Method == http.MethodPost:
+		s.submitScore(w, r, name)
+	case action == "members" && member != "" && r.Method == http.MethodGet:
+		s.leaderboardMember(w, name, member)
+	case action == "members" && member != "" && r.Method == http.MethodDelete:
+		removed, err := s.cache.ZRem(r.Context(), name, member)
+		switch {
+		case err != nil:
+			writeLeaderboardError(w, err)
+		case removed == 0:
+			WriteHTTPError(w, http.StatusNotFound, "member not found")
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case action == "":
+		w.Header().Set("Allow", "GET")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+	case action == "scores" && member == "":
+		w.Header().Set("Allow", "POST")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+	case action == "members" && member != "":
+		w.Header().Set("Allow", "GET, DELETE")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		WriteHTTPError(w, http.StatusNotFound, "unknown leaderboard resource")
+	}
+}
+
+// leaderboardTop answers with a page of the highest ranked entries
+func (s *HTTPServer) leaderboardTop(w http.ResponseWriter, r *http.Request, name string) {
+	offset, limit := 0, defaultLeaderboardLimit
+	query := r.URL.Query()
+	if v := query.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			WriteHTTPError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+		offset = n
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLeaderboardLimit {
+			WriteHTTPError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxLeaderboardLimit))
+			return
+		}
+		limit = n
+	}
+
+	total, err := s.cache.ZCard(name)
+	if err != nil {
+		writeLeaderboardError(w, err)
+		return
+	}
+	members, err := s.cache.ZRange(name, offset, offset+limit-1, true)
+	if err != nil {
+		writeLeaderboardError(w, err)
+		return
+	}
+	page := leaderboardPage{Name: name, Total: total, Entries: make([]leaderboardEntry, len(members))}
+	for i, m := range members {
+		page.Entries[i] = leaderboardEntry{Rank: offset + i + 1, Member: m.Member, Score: m.Score}
+	}
+	if next := offset + limit; next < total {
+		page.NextOffset = &next
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(page)
+}
+
+// submitScore records a score from a JSON body of member, score and mode,
+// answering with the member's new entry
+func (s *HTTPServer) submitScore(w http.ResponseWriter, r *http.Request, name string) {
+	var req struct {
+		Member string  `json:"member"`
+		Score  float64 `json:"score"`
+		Mode   string  `json:"mode"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+		WriteHTTPError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Member == "" {
+		WriteHTTPError(w, http.StatusBadRequest, "missing member")
+		return
+	}
+
+	var err error
+	switch req.Mode {
+	case "", scoreModeSet:
+		_, _, err = s.cache.ZAdd(r.Context(), name, []cache.ScoredMember{{Member: req.Member, Score: req.Score}}, cache.ZAddOptions{})
+	case scoreModeBest:
+		_, _, err = s.cache.ZAdd(r.Context(), name, []cache.ScoredMember{{Member: req.Member, Score: req.Score}}, cache.ZAddOptions{GT: true})
+	case scoreModeIncrement:
+		_, err = s.cache.ZIncrBy(r.Context(), name, req.Member, req.Score)
+	default:
+		WriteHTTPError(w, http.StatusBadRequest, "mode must be set, increment or best")
+		return
+	}
+	if err != nil {
+		writeLeaderboardError(w, err)
+		return
+	}
+	s.leaderboardMember(w, name, req.Member)
+}
+
+// leaderboardMember answers with a member's rank and score
+func (s *HTTPServer) leaderboardMember(w http.ResponseWriter, name, member string) {
+	rank, score, ok, err := s.cache.ZRank(name, member, true)
+	if err != nil {
+		writeLeaderboardError(w, err)
+		return
+	}
+	if !ok {
+		WriteHTTPError(w, http.StatusNotFound, "member not found")
+		return
+	}
+	if math.IsInf(score, 0) {
+		WriteHTTPError(w, http.StatusUnprocessableEntity, "score is infinite")
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(leaderboardEntry{Rank: rank + 1, Member: member, Score: score})
+}
+
+// writeLeaderboardError answers 409 for a name holding another type, 507
+// when the cache is out of memory and 500 otherwise
+func writeLeaderboardError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, cache.ErrWrongType):
+		WriteHTTPError(w, http.StatusConflict, "key is not a leaderboard")
+	case errors.Is(err, cache.ErrOOM):
+		WriteHTTPError(w, http.StatusInsufficientStorage, err.Error())
+	default:
+		WriteHTTPError(w, http.StatusInternalServerError, err.Error())
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// handleSchedule serves GET /api/v1/schedule with every scheduled job and
+// its recent runs
+func (s *HTTPServer) handleSchedule(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	scheduler := s.schedulerOrError(w)
+	if scheduler == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	json.NewEncoder(w).Encode(scheduler.Jobs())
+}
+
+// handleScheduleJob serves GET /api/v1/schedule/{job} with one job's runs,
+// and POST /api/v1/schedule/{job}/run to run it now
+func (s *HTTPServer) handleScheduleJob(w http.ResponseWriter, r *http.Request) {
+	name, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/v1/schedule/"), "/")
+	scheduler := s.schedulerOrError(w)
+	if scheduler == nil {
+		return
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		job, err := scheduler.Job(name)
+		if err != nil {
+			WriteHTTPError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", contentTypeJSON)
+		json.NewEncoder(w).Encode(job)
+	case action == "run" && r.Method == http.MethodPost:
+		switch err := scheduler.Run(name); {
+		case errors.Is(err, cache.ErrUnknownJob):
+			WriteHTTPError(w, http.StatusNotFound, err.Error())
+		case err != nil:
+			WriteHTTPError(w, http.StatusConflict, err.Error())
+		default:
+			w.WriteHeader(http.StatusAccepted)
+		}
+	case action == "":
+		w.Header().Set("Allow", "GET")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+	case action == "run":
+		w.Header().Set("Allow", "POST")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		WriteHTTPError(w, http.StatusNotFound, "unknown job action")
+	}
+}
+
+// schedulerOrError returns the cache's scheduler, answering 503 if no jobs
+// are scheduled
+func (s *HTTPServer) schedulerOrError(w http.ResponseWriter) *cache.Scheduler {
+	scheduler := s.cache.Scheduler()
+	if scheduler == nil {
+		WriteHTTPError(w, http.StatusServiceUnavailable, "no jobs are scheduled")
+	}
+	return scheduler
+}
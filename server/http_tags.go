@@ -0,0 +1,33 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// handleTag serves DELETE /api/v1/tags/{tag}, invalidating every entry with
+// the tag
+func (s *HTTPServer) handleTag(w http.ResponseWriter, r *http.Request) {
+	tag := strings.TrimPrefix(r.URL.Path, "/api/v1/tags/")
+	if tag == "" {
+		WriteHTTPError(w, http.StatusBadRequest, "missing tag")
+		return
+	}
+	if r.Method != http.MethodDelete {
+		w.Header().Set("Allow", "DELETE")
+		WriteHTTPError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if !s.authorize(w, r, cache.FlagWrite) {
+		return
+	}
+
+	invalidated := s.cache.InvalidateTag(r.Context(), tag)
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]int{"invalidated": invalidated})
+}
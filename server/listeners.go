@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Front-end names, as used for environment variables and in logs
+const (
+	ListenerRESP      = "resp"
+	ListenerHTTP      = "http"
+	ListenerMetrics   = "metrics"
+	ListenerGRPC      = "grpc"
+	ListenerMemcached = "memcached"
+)
+
+// ListenerConfig enables one front-end and sets where it listens. An empty
+// Host listens on Config.Host. For the RESP, HTTP and metrics listeners a
+// zero Port falls back to Config.Port, HTTPPort and
+// metrics.Config.PrometheusPort, and without TLS of their own the RESP and
+// HTTP listeners use Config's TLS settings.
+type ListenerConfig struct {
+	Enabled     bool   `json:"enabled" toml:"enabled" yaml:"enabled"`
+	Host        string `json:"host" toml:"host" yaml:"host"`
+	Port        int    `json:"port" toml:"port" yaml:"port"`
+	EnableTLS   bool   `json:"enable_tls" toml:"enable_tls" yaml:"enable_tls"`
+	TLSCertFile string `json:"tls_cert_file" toml:"tls_cert_file" yaml:"tls_cert_file"`
+	TLSKeyFile  string `json:"tls_key_file" toml:"tls_key_file" yaml:"tls_key_file"`
+}
+
+// Addr returns the host:port the listener binds
+func (l ListenerConfig) Addr() string {
+	return net.JoinHostPort(l.Host, strconv.Itoa(l.Port))
+}
+
+// ListenersConfig configures each front-end
+type ListenersConfig struct {
+	RESP      ListenerConfig `json:"resp" toml:"resp" yaml:"resp"`
+	HTTP      ListenerConfig `json:"http" toml:"http" yaml:"http"`
+	Metrics   ListenerConfig `json:"metrics" toml:"metrics" yaml:"metrics"`
+	GRPC      ListenerConfig `json:"grpc" toml:"grpc" yaml:"grpc"`
+	Memcached ListenerConfig `json:"memcached" toml:"memcached" yaml:"memcached"`
+}
+
+// DefaultListenersConfig enables the RESP, HTTP and metrics listeners on
+// their usual ports and leaves gRPC and memcached off
+func DefaultListenersConfig() ListenersConfig {
+	return ListenersConfig{
+		RESP:      ListenerConfig{Enabled: true},
+		HTTP:      ListenerConfig{Enabled: true},
+		Metrics:   ListenerConfig{Enabled: true},
+		GRPC:      ListenerConfig{Port: 50051},
+		Memcached: ListenerConfig{Port: 11211},
+	}
+}
+
+// NamedListener is the resolved configuration of one front-end
+type NamedListener struct {
+	Name string
+	ListenerConfig
+}
+
+// Frontend is a server StartListeners can serve on a listener
+type Frontend interface {
+	Serve(listener net.Listener) error
+	Shutdown(ctx context.Context) error
+}
+
+// Frontends are the servers StartListeners may start. Enabling a listener
+// whose server is nil is an error.
+type Frontends struct {
+	RESP      Frontend
+	HTTP      Frontend
+	Metrics   Frontend
+	GRPC      Frontend
+	Memcached Frontend
+}
+
+// runningListener is a started front-end and how to stop it
+type runningListener struct {
+	name     string
+	shutdown func(context.Context) error
+}
+
+// Listeners are the front-ends StartListeners started
+type Listeners struct {
+	logger  *log.Logger
+	running []runningListener
+}
+
+// StartListeners binds every enabled listener and serves its front-end in
+// the background. TLS certificates are read through secrets, so rotated
+// ones are picked up, or straight from disk when secrets is nil. If any
+// listener fails to bind, those already started are stopped again.
+func StartListeners(ctx context.Context, listeners []NamedListener, frontends Frontends, secrets *SecretWatcher, logger *log.Logger) (*Listeners, error) {
+	ls := &Listeners{logger: logger}
+	for _, l := range listeners {
+		if !l.Enabled {
+			continue
+		}
+		if err := ls.start(ctx, l, frontends, secrets); err != nil {
+			stopCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			ls.Shutdown(stopCtx)
+			cancel()
+			return nil, fmt.Errorf("starting the %s listener: %w", l.Name, err)
+		}
+	}
+	return ls, nil
+}
+
+// start binds one listener and serves its front-end
+func (ls *Listeners) start(ctx context.Context, l NamedListener, frontends Frontends, secrets *SecretWatcher) error {
+	var frontend Frontend
+	switch l.Name {
+	case ListenerRESP:
+		frontend = frontends.RESP
+	case ListenerHTTP:
+		frontend = frontends.HTTP
+	case ListenerMetrics:
+		frontend = frontends.Metrics
+	case ListenerGRPC:
+		frontend = frontends.GRPC
+	case ListenerMemcached:
+		frontend = frontends.Memcached
+	}
+	if frontend == nil {
+		return errors.New("no server is available for it")
+	}
+
+	listener, err := net.Listen("tcp", l.Addr())
+	if err != nil {
+		return err
+	}
+	if l.EnableTLS {
+		tlsConfig, err := listenerTLSConfig(ctx, l.ListenerConfig, secrets)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	ls.logger.Printf("Starting %s listener on %s (TLS %t)", l.Name, l.Addr(), l.EnableTLS)
+	go func() {
+		if err := frontend.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+			ls.logger.Printf("%s listener failed: %v", l.Name, err)
+		}
+	}()
+	ls.running = append(ls.running, runningListener{name: l.Name, shutdown: frontend.Shutdown})
+	return nil
+}
+
+// listenerTLSConfig loads a listener's certificate and key
+func listenerTLSConfig(ctx context.Context, l ListenerConfig, secrets *SecretWatcher) (*tls.Config, error) {
+	if secrets != nil {
+		return secrets.TLSConfig(ctx, l.TLSCertFile, l.TLSKeyFile)
+	}
+	cert, err := tls.LoadX509KeyPair(l.TLSCertFile, l.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	return &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}, nil
+}
+
+// Shutdown stops every running front-end, in the reverse of the order they
+// were started, and returns the first error
+func (ls *Listeners) Shutdown(ctx context.Context) error {
+	var first error
+	for i := len(ls.running) - 1; i >= 0; i-- {
+		l := ls.running[i]
+		if err := l.shutdown(ctx); err != nil {
+			ls.logger.Printf("Stopping the %s listener: %v", l.name, err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	ls.running = nil
+	return first
+}
@@ -0,0 +1,525 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+const (
+	// memcachedMaxLine bounds a command line, which holds at most a
+	// storage command and a 250 byte key
+	memcachedMaxLine = 2048
+	// memcachedMaxKey and memcachedMaxItem are memcached's default limits
+	memcachedMaxKey  = 250
+	memcachedMaxItem = 1 << 20
+	// memcachedRelativeLimit is the largest exptime taken as seconds from
+	// now; larger ones are Unix times
+	memcachedRelativeLimit = 30 * 24 * 60 * 60
+)
+
+// Outcomes of memcached writes that store nothing, named by their reply
+var (
+	errMemcachedNotStored = errors.New("NOT_STORED")
+	errMemcachedExists    = errors.New("EXISTS")
+	errMemcachedNotFound  = errors.New("NOT_FOUND")
+)
+
+// errMemcachedLineTooLong closes connections sending an overlong line
+var errMemcachedLineTooLong = errors.New("CLIENT_ERROR line too long")
+
+// MemcachedServer serves the memcached text protocol: get, gets, set,
+// add, replace, append, prepend, cas, delete, incr, decr, touch, version,
+// verbosity and quit. Item flags are not stored, so only zero is accepted,
+// and the protocol has no authentication, so the server cannot be used
+// with ACLs. Writes are logged like those of the other front-ends.
+type MemcachedServer struct {
+	cache  *cache.Cache
+	logger *log.Logger
+
+	mu           sync.Mutex
+	listener     net.Listener
+	conns        map[*memcachedConn]struct{}
+	shuttingDown bool
+}
+
+// memcachedConn is one client connection
+type memcachedConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	// busy is held while a command runs, so Shutdown only closes idle
+	// connections
+	busy    sync.Mutex
+	closing atomic.Bool
+}
+
+// NewMemcachedServer creates a memcached protocol server for the given
+// cache
+func NewMemcachedServer(cache *cache.Cache, logger *log.Logger) *MemcachedServer {
+	return &MemcachedServer{
+		cache:  cache,
+		logger: logger,
+		conns:  make(map[*memcachedConn]struct{}),
+	}
+}
+
+// Serve accepts clients on listener, which may be a TLS listener, until
+// the server is shut down
+func (s *MemcachedServer) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+// Shutdown stops accepting clients and closes each connection once its
+// command is answered, closing the remaining ones when ctx is done
+func (s *MemcachedServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			for mc := range s.conns {
+				mc.closing.Store(true)
+				mc.conn.Close()
+			}
+			s.mu.Unlock()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns closes the connections not running a command, reporting
+// whether none are left
+func (s *MemcachedServer) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for mc := range s.conns {
+		if mc.closing.Load() || !mc.busy.TryLock() {
+			continue
+		}
+		mc.closing.Store(true)
+		mc.busy.Unlock()
+		mc.conn.Close()
+	}
+	return len(s.conns) == 0
+}
+
+// handleConnection runs a client's commands until it disconnects
+func (s *MemcachedServer) handleConnection(conn net.Conn) {
+	mc := &memcachedConn{
+		conn: conn,
+		r:    bufio.NewReaderSize(conn, memcachedMaxLine),
+		w:    bufio.NewWriter(conn),
+	}
+	s.mu.Lock()
+	if s.shuttingDown {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.conns[mc] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, mc)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		line, err := mc.readLine()
+		if err != nil {
+			if err == errMemcachedLineTooLong {
+				mc.w.WriteString(err.Error() + "\r\n")
+				mc.w.Flush()
+			} else if err != io.EOF && !mc.closing.Load() {
+				s.logger.Printf("Memcached connection %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		mc.busy.Lock()
+		if mc.closing.Load() {
+			mc.busy.Unlock()
+			return
+		}
+		quit, err := s.run(mc, fields)
+		// Pipelined commands are answered in one write
+		if err == nil && (quit || mc.r.Buffered() == 0) {
+			err = mc.w.Flush()
+		}
+		mc.busy.Unlock()
+		if err != nil || quit {
+			return
+		}
+	}
+}
+
+// readLine reads a command line without its line ending
+func (mc *memcachedConn) readLine() (string, error) {
+	line, err := mc.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", errMemcachedLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// reply writes a line of the reply
+func (mc *memcachedConn) reply(line string) {
+	mc.w.WriteString(line)
+	mc.w.WriteString("\r\n")
+}
+
+// run executes one command, reporting whether the client asked to quit.
+// Errors are those of the connection; the client's are answered.
+func (s *MemcachedServer) run(mc *memcachedConn, fields []string) (quit bool, err error) {
+	ctx := context.Background()
+	switch name := fields[0]; name {
+	case "get", "gets":
+		if len(fields) < 2 {
+			mc.reply("ERROR")
+			return false, nil
+		}
+		s.get(mc, fields[1:], name == "gets")
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return false, s.store(ctx, mc, name, fields[1:])
+	case "delete":
+		key, noreply, ok := s.keyArgs(mc, fields[1:], 0)
+		if !ok || !s.writable(mc, key) {
+			return false, nil
+		}
+		deleted, err := s.cache.Delete(ctx, key)
+		if err != nil {
+			mc.reply("SERVER_ERROR " + err.Error())
+		} else if deleted {
+			mc.replyUnless(noreply, "DELETED")
+		} else {
+			mc.replyUnless(noreply, "NOT_FOUND")
+		}
+	case "incr", "decr":
+		s.incr(ctx, mc, fields[1:], name == "decr")
+	case "touch":
+		s.touch(ctx, mc, fields[1:])
+	case "version":
+		mc.reply("VERSION " + strconv.Itoa(cache.ProtocolVersion))
+	case "verbosity":
+		mc.replyUnless(len(fields) > 2 && fields[2] == "noreply", "OK")
+	case "quit":
+		return true, nil
+	default:
+		mc.reply("ERROR")
+	}
+	return false, nil
+}
+
+// replyUnless writes line unless the client asked for no reply
+func (mc *memcachedConn) replyUnless(noreply bool, line string) {
+	if !noreply {
+		mc.reply(line)
+	}
+}
+
+// keyArgs checks a command naming a key followed by n arguments and an
+// optional noreply, answering CLIENT_ERROR if it is malformed
+func (s *MemcachedServer) keyArgs(mc *memcachedConn, args []string, n int) (key string, noreply, ok bool) {
+	if len(args) == n+2 && args[n+1] == "noreply" {
+		noreply = true
+	} else if len(args) != n+1 {
+		mc.reply("ERROR")
+		return "", false, false
+	}
+	key, err := s.normalizeKey(args[0])
+	if err != nil {
+		mc.reply("CLIENT_ERROR " + err.Error())
+		return "", false, false
+	}
+	return key, noreply, true
+}
+
+// writable answers SERVER_ERROR and returns false if a pending distributed
+// transaction holds key
+func (s *MemcachedServer) writable(mc *memcachedConn, key string) bool {
+	if err := s.cache.CheckKeyLocks(key); err != nil {
+		mc.reply("SERVER_ERROR " + err.Error())
+		return false
+	}
+	return true
+}
+
+// normalizeKey checks a memcached key and normalizes it as RESP keys are
+func (s *MemcachedServer) normalizeKey(key string) (string, error) {
+	if len(key) > memcachedMaxKey {
+		return "", errors.New("key too long")
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return "", errors.New("invalid key")
+		}
+	}
+	normalized, err := s.cache.NormalizeKey(key)
+	if err != nil {
+		return "", errors.New(strings.TrimPrefix(err.Error(), "ERR "))
+	}
+	return normalized, nil
+}
+
+// get answers get and gets, with each item's version as its cas unique
+func (s *MemcachedServer) get(mc *memcachedConn, keys []string, withCAS bool) {
+	for _, key := range keys {
+		normalized, err := s.normalizeKey(key)
+		if err != nil {
+			mc.reply("CLIENT_ERROR " + err.Error())
+			return
+		}
+		value, version, ok := s.cache.GetVersioned(normalized)
+		if !ok {
+			continue
+		}
+		if withCAS {
+			mc.reply(fmt.Sprintf("VALUE %s 0 %d %d", key, len(value), version))
+		} else {
+			mc.reply(fmt.Sprintf("VALUE %s 0 %d", key, len(value)))
+		}
+		mc.w.Write(value)
+		mc.reply("")
+	}
+	mc.reply("END")
+}
+
+// store answers the storage commands:
+// <command> key flags exptime bytes [cas-unique] [noreply], then the data
+func (s *MemcachedServer) store(ctx context.Context, mc *memcachedConn, name string, args []string) error {
+	n := 4
+	if name == "cas" {
+		n = 5
+	}
+	if len(args) != n && !(len(args) == n+1 && args[n] == "noreply") {
+		mc.reply("ERROR")
+		return nil
+	}
+	noreply := len(args) == n+1
+	flags, errFlags := strconv.ParseUint(args[1], 10, 32)
+	exptime, errExptime := strconv.ParseInt(args[2], 10, 64)
+	size, errSize := strconv.Atoi(args[3])
+	var unique uint64
+	var errUnique error
+	if name == "cas" {
+		unique, errUnique = strconv.ParseUint(args[4], 10, 64)
+	}
+	if errFlags != nil || errExptime != nil || errSize != nil || errUnique != nil || size < 0 {
+		mc.reply("CLIENT_ERROR bad command line format")
+		return nil
+	}
+	if size > memcachedMaxItem {
+		// Skip the data so the connection stays in sync
+		mc.reply("SERVER_ERROR object too large for cache")
+		_, err := mc.r.Discard(size + 2)
+		return err
+	}
+
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(mc.r, data); err != nil {
+		return err
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		mc.reply("CLIENT_ERROR bad data chunk")
+		return errors.New("bad data chunk")
+	}
+	value := data[:size]
+
+	key, err := s.normalizeKey(args[0])
+	if err != nil {
+		mc.reply("CLIENT_ERROR " + err.Error())
+		return nil
+	}
+	if flags != 0 {
+		mc.reply("CLIENT_ERROR nonzero flags are not supported")
+		return nil
+	}
+	if !s.writable(mc, key) {
+		return nil
+	}
+	expiresAt := memcachedExpiry(exptime, time.Now())
+
+	err = s.cache.StoreIf(ctx, key, func(old *cache.CacheEntry) ([]byte, cache.SetOptions, error) {
+		opts := cache.SetOptions{ExpiresAt: expiresAt}
+		switch {
+		case name == "add" && old != nil:
+			return nil, opts, errMemcachedNotStored
+		case (name == "replace" || name == "append" || name == "prepend") && old == nil:
+			return nil, opts, errMemcachedNotStored
+		case name == "cas" && old == nil:
+			return nil, opts, errMemcachedNotFound
+		case name == "cas" && old.Version() != unique:
+			return nil, opts, errMemcachedExists
+		case name == "append":
+			return append(append([]byte(nil), old.Value...), value...), keptOptions(old), nil
+		case name == "prepend":
+			return append(append([]byte(nil), value...), old.Value...), keptOptions(old), nil
+		}
+		return value, opts, nil
+	})
+	switch {
+	case err == nil:
+		mc.replyUnless(noreply, "STORED")
+	case err == errMemcachedNotStored || err == errMemcachedExists || err == errMemcachedNotFound:
+		mc.replyUnless(noreply, err.Error())
+	case err == cache.ErrOOM:
+		mc.reply("SERVER_ERROR out of memory storing object")
+	default:
+		mc.reply("SERVER_ERROR " + err.Error())
+	}
+	return nil
+}
+
+// incr answers incr and decr key delta [noreply]. As in memcached, incr
+// wraps around at 2^64 and decr stops at zero.
+func (s *MemcachedServer) incr(ctx context.Context, mc *memcachedConn, args []string, decr bool) {
+	key, noreply, ok := s.keyArgs(mc, args, 1)
+	if !ok || !s.writable(mc, key) {
+		return
+	}
+	delta, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		mc.reply("CLIENT_ERROR invalid numeric delta argument")
+		return
+	}
+
+	var result uint64
+	err = s.cache.StoreIf(ctx, key, func(old *cache.CacheEntry) ([]byte, cache.SetOptions, error) {
+		if old == nil {
+			return nil, cache.SetOptions{}, errMemcachedNotFound
+		}
+		n, err := strconv.ParseUint(string(old.Value), 10, 64)
+		if err != nil {
+			return nil, cache.SetOptions{}, errors.New("CLIENT_ERROR cannot increment or decrement non-numeric value")
+		}
+		switch {
+		case !decr:
+			result = n + delta
+		case delta > n:
+			result = 0
+		default:
+			result = n - delta
+		}
+		return []byte(strconv.FormatUint(result, 10)), keptOptions(old), nil
+	})
+	switch {
+	case err == nil:
+		mc.replyUnless(noreply, strconv.FormatUint(result, 10))
+	case err == errMemcachedNotFound:
+		mc.replyUnless(noreply, err.Error())
+	case strings.HasPrefix(err.Error(), "CLIENT_ERROR "):
+		mc.reply(err.Error())
+	default:
+		mc.reply("SERVER_ERROR " + err.Error())
+	}
+}
+
+// touch answers touch key exptime [noreply], changing only the expiry
+func (s *MemcachedServer) touch(ctx context.Context, mc *memcachedConn, args []string) {
+	key, noreply, ok := s.keyArgs(mc, args, 1)
+	if !ok || !s.writable(mc, key) {
+		return
+	}
+	exptime, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		mc.reply("CLIENT_ERROR invalid exptime argument")
+		return
+	}
+
+	expiresAt := memcachedExpiry(exptime, time.Now())
+	err = s.cache.StoreIf(ctx, key, func(old *cache.CacheEntry) ([]byte, cache.SetOptions, error) {
+		if old == nil {
+			return nil, cache.SetOptions{}, errMemcachedNotFound
+		}
+		opts := keptOptions(old)
+		opts.TTL, opts.ExpiresAt, opts.Sliding = nil, expiresAt, false
+		return old.Value, opts, nil
+	})
+	switch {
+	case err == nil:
+		mc.replyUnless(noreply, "TOUCHED")
+	case err == errMemcachedNotFound:
+		mc.replyUnless(noreply, err.Error())
+	default:
+		mc.reply("SERVER_ERROR " + err.Error())
+	}
+}
+
+// memcachedExpiry converts a memcached exptime to an expiry: zero never
+// expires, up to 30 days is seconds from now and larger values are Unix
+// times. A negative exptime has already passed.
+func memcachedExpiry(exptime int64, now time.Time) *time.Time {
+	var at time.Time
+	switch {
+	case exptime == 0:
+		return nil
+	case exptime < 0:
+		at = now.Add(-time.Second)
+	case exptime <= memcachedRelativeLimit:
+		at = now.Add(time.Duration(exptime) * time.Second)
+	default:
+		at = time.Unix(exptime, 0)
+	}
+	return &at
+}
+
+// keptOptions returns the options entry was stored with, for writes that
+// change only its value
+func keptOptions(entry *cache.CacheEntry) cache.SetOptions {
+	opts := cache.SetOptions{Tags: entry.Tags, Cost: entry.Cost, Origin: entry.Origin}
+	switch {
+	case entry.SlidingTTL > 0:
+		ttl := entry.SlidingTTL
+		opts.TTL, opts.Sliding = &ttl, true
+	case entry.ExpiresAt != nil:
+		at := *entry.ExpiresAt
+		opts.ExpiresAt = &at
+	}
+	return opts
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// Middleware wraps command execution. It may inspect or rewrite the
+// invocation, refuse it by returning an error without calling next, or
+// observe the outcome of calling next. ctx.Command is the command being
+// run; ctx.Client is nil for commands not sent by a client.
+//
+// Middleware runs after the command is looked up and its arity checked,
+// and before the checks of the node's state and the handler. For example,
+// enforcing a key naming convention:
+//
+//	tcp.Use(func(next cache.CommandHandler) cache.CommandHandler {
+//		return func(ctx *cache.CommandContext) error {
+//			if ctx.Command.Keys != nil {
+//				for _, key := range ctx.Command.Keys(ctx.Args) {
+//					if !strings.Contains(key, ":") {
+//						return errors.New("ERR keys must be namespaced")
+//					}
+//				}
+//			}
+//			return next(ctx)
+//		}
+//	})
+type Middleware func(next cache.CommandHandler) cache.CommandHandler
+
+// builtinMiddleware is the middleware every server runs first
+var builtinMiddleware = []Middleware{selectMiddleware, cache.KeyNormalizationMiddleware, aclMiddleware, rateLimitMiddleware, cache.PartitionMiddleware, txnLockMiddleware}
+
+// Use appends middleware to the chain commands run through, after the
+// selected namespace and key normalization, the ACL and rate limit checks,
+// forwarding to the keys' owner and the transaction lock checks. It
+// affects commands dispatched from then on.
+func (s *TCPServer) Use(middleware ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chain := make([]Middleware, 0, len(s.middleware)+len(middleware))
+	chain = append(chain, s.middleware...)
+	s.middleware = append(chain, middleware...)
+}
+
+// commandMiddleware returns the middleware chain, outermost first
+func (s *TCPServer) commandMiddleware() []Middleware {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.middleware
+}
+
+// dispatchCommand validates and runs a command through the client's
+// middleware chain, writing an error reply if it cannot be executed. After
+// MULTI it queues the command instead.
+func dispatchCommand(ctx *cache.CommandContext) {
+	if ctx.Context == nil {
+		ctx.Context = context.Background()
+	}
+	cc := clientOf(ctx)
+	name := string(ctx.Args[0])
+	cmd := cache.LookupCommand(name)
+	if cmd == nil {
+		queueCommand(cc, nil, ctx.Args, true)
+		ctx.Out.WriteError(fmt.Sprintf("ERR unknown command '%s'", name))
+		return
+	}
+
+	argc := len(ctx.Args)
+	if (cmd.Arity > 0 && argc != cmd.Arity) || (cmd.Arity < 0 && argc < -cmd.Arity) {
+		queueCommand(cc, cmd, ctx.Args, true)
+		ctx.Out.WriteError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd.Name)))
+		return
+	}
+	if queueCommand(cc, cmd, ctx.Args, false) {
+		ctx.Out.WriteSimpleString("QUEUED")
+		return
+	}
+	ctx.Command = cmd
+
+	middleware := builtinMiddleware
+	if cc != nil {
+		middleware = cc.server.commandMiddleware()
+	}
+	err := chainMiddleware(executeCommand, middleware)(ctx)
+	if errors.Is(err, context.DeadlineExceeded) {
+		if cc != nil {
+			cc.server.commandTimedOut(cc, cmd)
+		}
+		err = cache.ErrTimeout
+	}
+	if err != nil {
+		ctx.Out.WriteError(err.Error())
+	}
+}
+
+// chainMiddleware wraps handler in middleware, the first outermost
+func chainMiddleware(handler cache.CommandHandler, middleware []Middleware) cache.CommandHandler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// aclMiddleware enforces the client's ACL permissions
+func aclMiddleware(next cache.CommandHandler) cache.CommandHandler {
+	return func(ctx *cache.CommandContext) error {
+		if cc := clientOf(ctx); cc != nil {
+			check := cc.checkACL
+			if ctx.Forwarded {
+				check = func(cmd *cache.Command, args [][]byte) error {
+					return cc.checkForwardedACL(ctx.User, cmd, args)
+				}
+			}
+			if err := check(ctx.Command, ctx.Args); err != nil {
+				return err
+			}
+		}
+		return next(ctx)
+	}
+}
+
+// rateLimitMiddleware charges the command to the client's rate limit
+func rateLimitMiddleware(next cache.CommandHandler) cache.CommandHandler {
+	return func(ctx *cache.CommandContext) error {
+		if cc := clientOf(ctx); cc != nil {
+			if err := cc.checkRateLimit(ctx.Command); err != nil {
+				return err
+			}
+		}
+		return next(ctx)
+	}
+}
+
+// executeCommand is the innermost handler of the chain: it checks the node
+// can serve the command, then runs it
+func executeCommand(ctx *cache.CommandContext) error {
+	if err := ctx.Cache.CheckServable(ctx.Command); err != nil {
+		return err
+	}
+	if cc := clientOf(ctx); cc != nil {
+		cc.trackRead(ctx.Command, ctx.Args)
+	}
+	return cache.RunCommand(ctx)
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"strings"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// After MULTI, a connection's commands are queued and answered QUEUED
+// instead of running, until EXEC runs them in order and replies with an
+// array of their replies, or DISCARD drops them. A command that cannot be
+// queued, being unknown or given the wrong number of arguments, is
+// answered with its error and makes EXEC fail with EXECABORT.
+//
+// Unlike Redis, EXEC does not isolate the queued commands: commands of
+// other connections may run between them. CHECKMSET and the TXN commands
+// write several keys atomically.
+
+func init() {
+	cache.RegisterCommands(
+		&cache.Command{Name: "MULTI", Arity: 1, Flags: cache.FlagConnection, Handler: multiCommand},
+		&cache.Command{Name: "EXEC", Arity: 1, Flags: cache.FlagConnection, Handler: execCommand},
+		&cache.Command{Name: "DISCARD", Arity: 1, Flags: cache.FlagConnection, Handler: discardCommand},
+	)
+}
+
+// unqueued are the commands that run at once even after MULTI
+var unqueued = map[string]bool{"MULTI": true, "EXEC": true, "DISCARD": true, "QUIT": true, "RESET": true}
+
+// queueCommand queues a command sent after MULTI, reporting whether it did.
+// cmd is nil for an unknown command, and invalid is set if the command was
+// refused, which fails the transaction.
+func queueCommand(cc *clientConn, cmd *cache.Command, args [][]byte, invalid bool) bool {
+	if cc == nil || !cc.state.InMulti() {
+		return false
+	}
+	if cmd == nil || invalid {
+		cc.state.QueueFailed()
+		return false
+	}
+	if unqueued[strings.ToUpper(cmd.Name)] {
+		return false
+	}
+	cc.state.Queue(args)
+	return true
+}
+
+// multiCommand implements MULTI
+func multiCommand(ctx *cache.CommandContext) error {
+	cc := clientOf(ctx)
+	if cc == nil {
+		return errNoConnection
+	}
+	if err := cc.state.Multi(); err != nil {
+		return err
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// execCommand implements EXEC, running the queued commands as sent
+func execCommand(ctx *cache.CommandContext) error {
+	cc := clientOf(ctx)
+	if cc == nil {
+		return errNoConnection
+	}
+	queued, err := cc.state.Exec()
+	if err != nil {
+		return err
+	}
+	ctx.Out.WriteArrayHeader(len(queued))
+	for _, args := range queued {
+		dispatchCommand(&cache.CommandContext{
+			Context: ctx.Context,
+			Cache:   ctx.Cache,
+			Client:  ctx.Client,
+			Args:    args,
+			Out:     ctx.Out,
+		})
+	}
+	return nil
+}
+
+// discardCommand implements DISCARD
+func discardCommand(ctx *cache.CommandContext) error {
+	cc := clientOf(ctx)
+	if cc == nil {
+		return errNoConnection
+	}
+	if err := cc.state.Discard(); err != nil {
+		return err
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/hamisionesmus/distributed-cache/client"
+)
+
+// newConnClient returns a client sending every command on one connection,
+// as connection state needs
+func newConnClient(t *testing.T, addr string) *client.Client {
+	t.Helper()
+	cl, err := client.NewClient(&client.Options{Addresses: []string{addr}, Multiplex: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	return cl
+}
+
+func TestSelectPrefixesKeys(t *testing.T) {
+	ctx := context.Background()
+	c := newTxnCache(t)
+	cl := newConnClient(t, startTCPServer(t, c))
+
+	do(t, cl, "SELECT", "app")
+	do(t, cl, "SET", "k", "v")
+	if value, _ := c.Get(ctx, "app:k"); string(value) != "v" {
+		t.Errorf("app:k = %q after SET k in namespace app", value)
+	}
+	if _, ok := c.Get(ctx, "k"); ok {
+		t.Error("SET k in namespace app wrote k")
+	}
+	if value, err := cl.Get(ctx, "k"); value != "v" {
+		t.Errorf("GET k in namespace app = %q, %v", value, err)
+	}
+
+	do(t, cl, "SELECT", "0")
+	if _, err := cl.Get(ctx, "k"); err != client.ErrNil {
+		t.Errorf("GET k after SELECT 0 = %v, want nil", err)
+	}
+	do(t, cl, "SELECT", "app")
+	do(t, cl, "RESET")
+	if _, err := cl.Get(ctx, "k"); err != client.ErrNil {
+		t.Errorf("GET k after RESET = %v, want nil", err)
+	}
+}
+
+func TestMultiQueuesUntilExec(t *testing.T) {
+	ctx := context.Background()
+	c := newTxnCache(t)
+	cl := newConnClient(t, startTCPServer(t, c))
+
+	do(t, cl, "MULTI")
+	if reply := do(t, cl, "SET", "k", "v"); reply != "QUEUED" {
+		t.Errorf("SET after MULTI replied %v, want QUEUED", reply)
+	}
+	do(t, cl, "SELECT", "app")
+	do(t, cl, "SET", "k", "w")
+	if _, ok := c.Get(ctx, "k"); ok {
+		t.Fatal("a queued command ran before EXEC")
+	}
+	replies := do(t, cl, "EXEC")
+	if want := []interface{}{"OK", "OK", "OK"}; !reflect.DeepEqual(replies, want) {
+		t.Errorf("EXEC replied %v, want %v", replies, want)
+	}
+	for key, want := range map[string]string{"k": "v", "app:k": "w"} {
+		if value, _ := c.Get(ctx, key); string(value) != want {
+			t.Errorf("%s = %q after EXEC, want %q", key, value, want)
+		}
+	}
+
+	do(t, cl, "MULTI")
+	do(t, cl, "SET", "dropped", "v")
+	do(t, cl, "DISCARD")
+	if _, ok := c.Get(ctx, "app:dropped"); ok {
+		t.Error("DISCARD ran the queued command")
+	}
+}
+
+func TestExecAbortsAfterQueueError(t *testing.T) {
+	ctx := context.Background()
+	c := newTxnCache(t)
+	cl := newConnClient(t, startTCPServer(t, c))
+
+	do(t, cl, "MULTI")
+	do(t, cl, "SET", "k", "v")
+	if _, err := cl.Do(ctx, "SET", "k"); err == nil {
+		t.Error("SET with too few arguments was queued")
+	}
+	if _, err := cl.Do(ctx, "EXEC"); err == nil || err.Error() != "EXECABORT Transaction discarded because of previous errors." {
+		t.Errorf("EXEC after a queue error = %v, want EXECABORT", err)
+	}
+	if _, ok := c.Get(ctx, "k"); ok {
+		t.Error("an aborted transaction ran its commands")
+	}
+	if _, err := cl.Do(ctx, "EXEC"); err == nil {
+		t.Error("EXEC without MULTI succeeded")
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Output buffer limits bound how much output a client may leave pending
+// before it is disconnected, so a slow consumer cannot buffer the server
+// into running out of memory. As in Redis there are two classes: normal
+// replies, counted from the last complete flush to the client, and
+// pub/sub messages queued for a subscriber. A client is disconnected once
+// its pending output passes the hard limit, or stays over the soft limit
+// for longer than the soft period.
+
+// errOutputLimit is returned once a client's output passes its limit
+var errOutputLimit = errors.New("output buffer limit exceeded")
+
+// Output buffer classes, as named in logs and metrics
+const (
+	OutputClassNormal = "normal"
+	OutputClassPubSub = "pubsub"
+)
+
+// OutputBufferLimit bounds one class of pending output; zero disables a
+// limit
+type OutputBufferLimit struct {
+	HardBytes  int64         `json:"hard_bytes" toml:"hard_bytes" yaml:"hard_bytes"`
+	SoftBytes  int64         `json:"soft_bytes" toml:"soft_bytes" yaml:"soft_bytes"`
+	SoftPeriod time.Duration `json:"soft_period" toml:"soft_period" yaml:"soft_period"`
+}
+
+// OutputBufferLimits are the limits of each output class
+type OutputBufferLimits struct {
+	Normal OutputBufferLimit `json:"normal" toml:"normal" yaml:"normal"`
+	PubSub OutputBufferLimit `json:"pubsub" toml:"pubsub" yaml:"pubsub"`
+}
+
+// DefaultOutputBufferLimits leaves normal replies unlimited and follows
+// Redis's defaults for subscribers
+func DefaultOutputBufferLimits() OutputBufferLimits {
+	return OutputBufferLimits{
+		PubSub: OutputBufferLimit{HardBytes: 32 << 20, SoftBytes: 8 << 20, SoftPeriod: time.Minute},
+	}
+}
+
+// Validate checks that the limits are consistent
+func (l OutputBufferLimits) Validate() error {
+	for _, limit := range []OutputBufferLimit{l.Normal, l.PubSub} {
+		if limit.HardBytes < 0 || limit.SoftBytes < 0 || limit.SoftPeriod < 0 {
+			return errors.New("output buffer limits cannot be negative")
+		}
+		if limit.HardBytes > 0 && limit.SoftBytes > limit.HardBytes {
+			return errors.New("output buffer soft limit cannot exceed the hard limit")
+		}
+	}
+	return nil
+}
+
+// outputBuffer accounts for one class of a client's pending output
+type outputBuffer struct {
+	limit OutputBufferLimit
+
+	mu      sync.Mutex
+	pending int64
+	// overSoft is when pending last rose above the soft limit, or zero
+	overSoft time.Time
+}
+
+// add counts n more pending bytes, failing if that breaks the limit
+func (b *outputBuffer) add(n int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending += n
+	if b.limit.HardBytes > 0 && b.pending > b.limit.HardBytes {
+		return errOutputLimit
+	}
+	if b.limit.SoftBytes > 0 && b.pending > b.limit.SoftBytes {
+		now := time.Now()
+		if b.overSoft.IsZero() {
+			b.overSoft = now
+		} else if now.Sub(b.overSoft) > b.limit.SoftPeriod {
+			return errOutputLimit
+		}
+	}
+	return nil
+}
+
+// release counts n pending bytes as written
+func (b *outputBuffer) release(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending -= n
+	if b.pending <= b.limit.SoftBytes {
+		b.overSoft = time.Time{}
+	}
+}
+
+// reset counts all pending output as written
+func (b *outputBuffer) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending = 0
+	b.overSoft = time.Time{}
+}
+
+// limitedWriter counts replies against a client's normal output buffer,
+// failing the write that breaks the limit. The failure sticks in the
+// RESPWriter's buffer, so the connection is closed at the next flush.
+type limitedWriter struct {
+	w      io.Writer
+	client *clientConn
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if err := lw.client.replies.add(int64(len(p))); err != nil {
+		lw.client.server.outputLimitExceeded(lw.client, OutputClassNormal)
+		return 0, err
+	}
+	return lw.w.Write(p)
+}
+
+// flushReplies flushes the client's replies, after which none are pending.
+// The caller holds cc.mu.
+func (cc *clientConn) flushReplies() error {
+	if err := cc.writer.Flush(); err != nil {
+		return err
+	}
+	cc.replies.reset()
+	return nil
+}
+
+// pushSize estimates the bytes an invalidation message takes to write
+func pushSize(keys []string) int64 {
+	n := int64(64)
+	for _, key := range keys {
+		n += int64(len(key)) + 16
+	}
+	return n
+}
+
+// SetOutputBufferLimits sets the limits of clients connecting from now on
+func (s *TCPServer) SetOutputBufferLimits(limits OutputBufferLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outputLimits = limits
+}
+
+// outputLimitExceeded reports a client disconnected for exceeding its
+// output buffer limit
+func (s *TCPServer) outputLimitExceeded(client *clientConn, class string) {
+	if class == OutputClassPubSub {
+		atomic.AddUint64(&s.pubsubEvictions, 1)
+	} else {
+		atomic.AddUint64(&s.normalEvictions, 1)
+	}
+	s.logger.Printf("Connection %s: closing, %s output buffer limit exceeded", client.conn.RemoteAddr(), class)
+}
+
+// OutputBufferEvictions returns how many clients were disconnected for
+// exceeding their normal and pub/sub output buffer limits
+func (s *TCPServer) OutputBufferEvictions() (normal, pubsub uint64) {
+	return atomic.LoadUint64(&s.normalEvictions), atomic.LoadUint64(&s.pubsubEvictions)
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// errRateLimited is returned for commands over their identity's budget
+var errRateLimited = errors.New("RATELIMIT rate limit exceeded, try again later")
+
+// rateLimitIdleAfter is how long an identity's buckets are kept unused
+const rateLimitIdleAfter = time.Minute
+
+// RateBudget is the commands per minute an identity may run; zero means
+// unlimited
+type RateBudget struct {
+	ReadRPM  int `json:"read_rpm" toml:"read_rpm" yaml:"read_rpm"`
+	WriteRPM int `json:"write_rpm" toml:"write_rpm" yaml:"write_rpm"`
+}
+
+// RateLimiter limits the read and write commands of each identity: the
+// ACL user a client authenticated as, or its IP address if it has not.
+// Users may be given their own budget; everyone else gets the default.
+// Each budget is a token bucket allowing bursts of up to a second's worth.
+type RateLimiter struct {
+	defaults RateBudget
+	users    map[string]RateBudget
+
+	mu        sync.Mutex
+	buckets   map[string]*rateBuckets
+	lastSweep time.Time
+
+	limitedReads  uint64
+	limitedWrites uint64
+}
+
+// rateBuckets are one identity's read and write buckets
+type rateBuckets struct {
+	read, write tokenBucket
+	lastUsed    time.Time
+}
+
+// tokenBucket refills at rate tokens per second up to burst
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter giving users their listed budget and
+// everyone else defaults
+func NewRateLimiter(defaults RateBudget, users map[string]RateBudget) *RateLimiter {
+	return &RateLimiter{
+		defaults:  defaults,
+		users:     users,
+		buckets:   make(map[string]*rateBuckets),
+		lastSweep: time.Now(),
+	}
+}
+
+// NewRateLimiterFromConfig creates the limiter cfg describes, or returns
+// nil if rate limiting is off
+func NewRateLimiterFromConfig(cfg SecurityConfig) *RateLimiter {
+	if !cfg.EnableRateLimit {
+		return nil
+	}
+	defaults := RateBudget{ReadRPM: cfg.RateLimitRPM, WriteRPM: cfg.RateLimitWriteRPM}
+	if defaults.WriteRPM == 0 {
+		defaults.WriteRPM = cfg.RateLimitRPM
+	}
+	return NewRateLimiter(defaults, cfg.RateLimitUsers)
+}
+
+// Allow takes a token from the bucket of user, or of the IP of remoteAddr
+// if user is empty, reporting whether the command may run
+func (l *RateLimiter) Allow(user, remoteAddr string, write bool) bool {
+	budget, identity := l.defaults, "user:"+user
+	if user == "" {
+		identity = "ip:" + addrIP(remoteAddr)
+	} else if b, ok := l.users[user]; ok {
+		budget = b
+	}
+	rpm := budget.ReadRPM
+	if write {
+		rpm = budget.WriteRPM
+	}
+	if rpm <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) > rateLimitIdleAfter {
+		l.sweepLocked(now)
+	}
+	b := l.buckets[identity]
+	if b == nil {
+		b = &rateBuckets{}
+		l.buckets[identity] = b
+	}
+	b.lastUsed = now
+	bucket := &b.read
+	if write {
+		bucket = &b.write
+	}
+	ok := bucket.take(now, float64(rpm)/60)
+	l.mu.Unlock()
+
+	if !ok {
+		if write {
+			atomic.AddUint64(&l.limitedWrites, 1)
+		} else {
+			atomic.AddUint64(&l.limitedReads, 1)
+		}
+	}
+	return ok
+}
+
+// sweepLocked drops the buckets of identities idle long enough for them to
+// have refilled
+func (l *RateLimiter) sweepLocked(now time.Time) {
+	for identity, b := range l.buckets {
+		if now.Sub(b.lastUsed) > rateLimitIdleAfter {
+			delete(l.buckets, identity)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Limited returns how many read and write commands were refused
+func (l *RateLimiter) Limited() (reads, writes uint64) {
+	return atomic.LoadUint64(&l.limitedReads), atomic.LoadUint64(&l.limitedWrites)
+}
+
+// take refills the bucket and takes a token if there is one. A new bucket
+// starts full.
+func (b *tokenBucket) take(now time.Time, rate float64) bool {
+	burst := rate
+	if burst < 1 {
+		burst = 1
+	}
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens += now.Sub(b.last).Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// addrIP returns the IP of a host:port address, or the address itself
+func addrIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// checkRateLimit charges a read or write command to the connection's
+// identity. Admin commands and those neither reading nor writing keys are
+// not limited.
+func (cc *clientConn) checkRateLimit(cmd *cache.Command) error {
+	limiter := cc.server.rateLimiter()
+	if limiter == nil || cmd.Flags&cache.FlagAdmin != 0 || cmd.Flags&(cache.FlagReadOnly|cache.FlagWrite) == 0 {
+		return nil
+	}
+	if !limiter.Allow(cc.state.User(), cc.conn.RemoteAddr().String(), cmd.Flags&cache.FlagWrite != 0) {
+		return errRateLimited
+	}
+	return nil
+}
+
+// SetRateLimiter limits clients' commands with limiter, or not at all if
+// nil
+func (s *TCPServer) SetRateLimiter(limiter *RateLimiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limiter = limiter
+}
+
+// rateLimiter returns the rate limiter in force, if any
+func (s *TCPServer) rateLimiter() *RateLimiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.limiter
+}
+
+// SetRateLimiter limits requests to the API with limiter, or not at all if
+// nil
+func (s *HTTPServer) SetRateLimiter(limiter *RateLimiter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.limiter = limiter
+}
@@ -0,0 +1,349 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Secret references let config files name where a secret lives instead of
+// holding it:
+//
+//	file:///run/secrets/jwt        the file's contents, less a final newline
+//	env://JWT_SECRET               an environment variable
+//	vault://kv/cache#jwt_secret    a field of a Vault KV v2 secret
+//
+// Any other value is the secret itself.
+const (
+	secretSchemeFile  = "file://"
+	secretSchemeEnv   = "env://"
+	secretSchemeVault = "vault://"
+)
+
+// defaultSecretRefreshInterval is how often referenced secrets are re-read
+const defaultSecretRefreshInterval = 5 * time.Minute
+
+// vaultTimeout bounds a request to Vault
+const vaultTimeout = 10 * time.Second
+
+// ErrSecretNotFound is returned when a reference names a missing secret
+var ErrSecretNotFound = errors.New("secret not found")
+
+// IsSecretRef reports whether value refers to a secret rather than holding
+// it
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, secretSchemeFile) ||
+		strings.HasPrefix(value, secretSchemeEnv) ||
+		strings.HasPrefix(value, secretSchemeVault)
+}
+
+// ValidateSecretRef checks the syntax of a secret reference. Values that
+// are not references are always valid.
+func ValidateSecretRef(ref string) error {
+	switch {
+	case strings.HasPrefix(ref, secretSchemeFile):
+		if strings.TrimPrefix(ref, secretSchemeFile) == "" {
+			return fmt.Errorf("secret reference %q has no path", ref)
+		}
+	case strings.HasPrefix(ref, secretSchemeEnv):
+		if strings.TrimPrefix(ref, secretSchemeEnv) == "" {
+			return fmt.Errorf("secret reference %q has no variable", ref)
+		}
+	case strings.HasPrefix(ref, secretSchemeVault):
+		if _, _, _, err := parseVaultRef(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseVaultRef splits vault://mount/path#field
+func parseVaultRef(ref string) (mount, path, field string, err error) {
+	rest := strings.TrimPrefix(ref, secretSchemeVault)
+	rest, field, _ = strings.Cut(rest, "#")
+	mount, path, _ = strings.Cut(rest, "/")
+	if mount == "" || path == "" || field == "" {
+		return "", "", "", fmt.Errorf("secret reference %q must be vault://mount/path#field", ref)
+	}
+	return mount, path, field, nil
+}
+
+// SecretResolver reads the secrets references point to
+type SecretResolver struct {
+	vaultAddress string
+	// vaultToken is itself a file:// or env:// reference, or the token
+	vaultToken string
+	client     *http.Client
+}
+
+// NewSecretResolver creates a resolver using cfg's Vault settings
+func NewSecretResolver(cfg SecurityConfig) *SecretResolver {
+	return &SecretResolver{
+		vaultAddress: strings.TrimSuffix(cfg.VaultAddress, "/"),
+		vaultToken:   cfg.VaultToken,
+		client:       &http.Client{Timeout: vaultTimeout},
+	}
+}
+
+// Resolve returns the secret ref points to, or ref itself if it is not a
+// reference
+func (r *SecretResolver) Resolve(ctx context.Context, ref string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(ref, secretSchemeFile):
+		data, err := os.ReadFile(strings.TrimPrefix(ref, secretSchemeFile))
+		if err != nil {
+			return nil, err
+		}
+		return bytes.TrimSuffix(bytes.TrimSuffix(data, []byte("\n")), []byte("\r")), nil
+	case strings.HasPrefix(ref, secretSchemeEnv):
+		name := strings.TrimPrefix(ref, secretSchemeEnv)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: environment variable %s is not set", ErrSecretNotFound, name)
+		}
+		return []byte(value), nil
+	case strings.HasPrefix(ref, secretSchemeVault):
+		return r.resolveVault(ctx, ref)
+	default:
+		return []byte(ref), nil
+	}
+}
+
+// resolveVault reads a field of a KV v2 secret
+func (r *SecretResolver) resolveVault(ctx context.Context, ref string) ([]byte, error) {
+	mount, path, field, err := parseVaultRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	if r.vaultAddress == "" {
+		return nil, fmt.Errorf("resolving %s: no Vault address configured", ref)
+	}
+	if strings.HasPrefix(r.vaultToken, secretSchemeVault) {
+		return nil, fmt.Errorf("the Vault token cannot itself be stored in Vault")
+	}
+	token, err := r.Resolve(ctx, r.vaultToken)
+	if err != nil {
+		return nil, fmt.Errorf("reading Vault token: %w", err)
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/%s/data/%s", r.vaultAddress, url.PathEscape(mount), path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", string(token))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", ref, err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, ref)
+	case resp.StatusCode != http.StatusOK:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("resolving %s: unexpected status %s: %s", ref, resp.Status, bytes.TrimSpace(body))
+	}
+
+	var secret struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", ref, err)
+	}
+	value, ok := secret.Data.Data[field].(string)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has no string field %q", ErrSecretNotFound, ref, field)
+	}
+	return []byte(value), nil
+}
+
+// Secret is the current value of a secret reference, kept up to date by a
+// SecretWatcher
+type Secret struct {
+	ref string
+
+	mu    sync.RWMutex
+	value []byte
+}
+
+// Value returns the secret as last read
+func (s *Secret) Value() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.value
+}
+
+// SecretWatcher re-reads the secrets it watches every refresh interval, so
+// rotated secrets take effect without a restart. A failed refresh keeps
+// the previous value.
+type SecretWatcher struct {
+	resolver *SecretResolver
+	interval time.Duration
+	logger   *log.Logger
+
+	mu      sync.Mutex
+	secrets []*Secret
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// StartSecretWatcher starts refreshing secrets every interval, or
+// defaultSecretRefreshInterval if it is zero
+func StartSecretWatcher(resolver *SecretResolver, interval time.Duration, logger *log.Logger) *SecretWatcher {
+	if interval <= 0 {
+		interval = defaultSecretRefreshInterval
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &SecretWatcher{
+		resolver: resolver,
+		interval: interval,
+		logger:   logger,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go w.run(ctx)
+	return w
+}
+
+// Watch reads the secret ref points to and keeps it refreshed. It fails
+// if the first read does.
+func (w *SecretWatcher) Watch(ctx context.Context, ref string) (*Secret, error) {
+	value, err := w.resolver.Resolve(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	s := &Secret{ref: ref, value: value}
+	if IsSecretRef(ref) {
+		w.mu.Lock()
+		w.secrets = append(w.secrets, s)
+		w.mu.Unlock()
+	}
+	return s, nil
+}
+
+// Close stops refreshing secrets
+func (w *SecretWatcher) Close() {
+	w.cancel()
+	<-w.done
+}
+
+func (w *SecretWatcher) run(ctx context.Context) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.refresh(ctx)
+		}
+	}
+}
+
+// refresh re-reads every watched secret
+func (w *SecretWatcher) refresh(ctx context.Context) {
+	w.mu.Lock()
+	secrets := append([]*Secret(nil), w.secrets...)
+	w.mu.Unlock()
+
+	for _, s := range secrets {
+		value, err := w.resolver.Resolve(ctx, s.ref)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.logger.Printf("Refreshing secret %s failed, keeping the previous value: %v", s.ref, err)
+			}
+			continue
+		}
+		s.mu.Lock()
+		changed := !bytes.Equal(s.value, value)
+		s.value = value
+		s.mu.Unlock()
+		if changed {
+			w.logger.Printf("Secret %s changed", s.ref)
+		}
+	}
+}
+
+// TLSConfig returns a TLS config serving the certificate and key the
+// references point to. Plain values are file paths, as in
+// SecurityConfig.TLSCertFile. A rotated certificate is served from the
+// next handshake.
+func (w *SecretWatcher) TLSConfig(ctx context.Context, certRef, keyRef string) (*tls.Config, error) {
+	if !IsSecretRef(certRef) {
+		certRef = secretSchemeFile + certRef
+	}
+	if !IsSecretRef(keyRef) {
+		keyRef = secretSchemeFile + keyRef
+	}
+	cert, err := w.Watch(ctx, certRef)
+	if err != nil {
+		return nil, fmt.Errorf("reading TLS certificate: %w", err)
+	}
+	key, err := w.Watch(ctx, keyRef)
+	if err != nil {
+		return nil, fmt.Errorf("reading TLS key: %w", err)
+	}
+
+	pair := &tlsKeyPair{cert: cert, key: key}
+	if _, err := pair.certificate(); err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return pair.certificate()
+		},
+	}, nil
+}
+
+// tlsKeyPair parses a certificate and key again whenever either changes
+type tlsKeyPair struct {
+	cert, key *Secret
+
+	mu      sync.Mutex
+	certPEM []byte
+	keyPEM  []byte
+	parsed  *tls.Certificate
+}
+
+// certificate returns the parsed key pair. While a rotation is half done,
+// with only one of the two updated, the previous pair is served.
+func (p *tlsKeyPair) certificate() (*tls.Certificate, error) {
+	certPEM, keyPEM := p.cert.Value(), p.key.Value()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.parsed != nil && bytes.Equal(certPEM, p.certPEM) && bytes.Equal(keyPEM, p.keyPEM) {
+		return p.parsed, nil
+	}
+	parsed, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		if p.parsed != nil {
+			return p.parsed, nil
+		}
+		return nil, fmt.Errorf("loading TLS key pair: %w", err)
+	}
+	p.certPEM, p.keyPEM, p.parsed = certPEM, keyPEM, &parsed
+	return p.parsed, nil
+}
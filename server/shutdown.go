@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// SHUTDOWN stops the server over the protocol, the way a SIGTERM does. The
+// command only persists and asks for the shutdown; the process's signal
+// wait picks the request up and runs the same graceful sequence as for a
+// signal, so there is one way out however it is asked for.
+
+// shutdownRequests carries the reason for a SHUTDOWN to the signal wait.
+// It holds one request: a second SHUTDOWN finds it full and is refused.
+var shutdownRequests = make(chan string, 1)
+
+// errShutdownPending is returned when a shutdown is already under way
+var errShutdownPending = errors.New("ERR shutdown already in progress")
+
+// RequestShutdown asks the process to shut down as if it had received a
+// SIGTERM. It reports false when a shutdown was already requested.
+func RequestShutdown(reason string) bool {
+	select {
+	case shutdownRequests <- reason:
+		return true
+	default:
+		return false
+	}
+}
+
+// WaitForShutdownRequest blocks until the process receives SIGINT or
+// SIGTERM or a client runs SHUTDOWN, and returns why
+func WaitForShutdownRequest() string {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	select {
+	case sig := <-sigChan:
+		// Take the slot so a SHUTDOWN arriving now is refused
+		RequestShutdown(sig.String())
+		return sig.String()
+	case reason := <-shutdownRequests:
+		return reason
+	}
+}
+
+func init() {
+	cache.RegisterCommands(
+		&cache.Command{Name: "SHUTDOWN", Arity: -1, Flags: cache.FlagAdmin, Handler: shutdownCommand},
+	)
+}
+
+// shutdownCommand implements SHUTDOWN [NOSAVE|SAVE]. By default it saves
+// a snapshot when snapshot storage is configured; SAVE insists on one and
+// NOSAVE skips it. The AOF is synced either way. A failure to persist
+// leaves the server running.
+func shutdownCommand(ctx *cache.CommandContext) error {
+	save := ctx.Cache.SnapshotsEnabled()
+
+	switch len(ctx.Args) {
+	case 1:
+	case 2:
+		switch strings.ToUpper(string(ctx.Args[1])) {
+		case "SAVE":
+			save = true
+		case "NOSAVE":
+			save = false
+		default:
+			return cache.ErrSyntax
+		}
+	default:
+		return cache.ErrSyntax
+	}
+
+	if len(shutdownRequests) > 0 {
+		return errShutdownPending
+	}
+	if err := ctx.Cache.PersistForShutdown(ctx.Context, save); err != nil {
+		return fmt.Errorf("ERR Errors trying to SHUTDOWN: %v", err)
+	}
+
+	reason := "SHUTDOWN"
+	if cc := clientOf(ctx); cc != nil {
+		reason = fmt.Sprintf("SHUTDOWN from client %d (%s)", cc.id, cc.conn.RemoteAddr())
+	}
+	if !RequestShutdown(reason) {
+		return errShutdownPending
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
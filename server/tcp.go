@@ -0,0 +1,417 @@
+// Package server serves a cache over RESP, HTTP, gRPC and the memcached
+// protocol
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// shutdownPollInterval is how often Shutdown looks for connections that
+// went idle
+const shutdownPollInterval = 50 * time.Millisecond
+
+// errShuttingDown is sent to clients whose connection is closed by
+// Shutdown. Like errDraining it asks them to retry elsewhere; the command
+// it answers, if any, was not run.
+var errShuttingDown = errors.New("LOADING server is shutting down, connect to another node")
+
+// TCPServer serves the Redis-compatible protocol
+type TCPServer struct {
+	cache  *cache.Cache
+	logger *log.Logger
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*clientConn]struct{}
+	ids      map[uint64]*clientConn
+	nextID   uint64
+	tracker  *tracker
+	// draining refuses new connections while existing ones are served
+	draining bool
+	// shuttingDown is set by Shutdown; commands run under ctx, which is
+	// cancelled once Shutdown gives up waiting for them
+	shuttingDown bool
+	ctx          context.Context
+	cancel       context.CancelFunc
+	// commandTimeout bounds each command's execution; zero disables it
+	commandTimeout time.Duration
+	timeouts       uint64
+	// acl, when set, restricts what each connection's user may run
+	acl *ACL
+	// limiter, when set, bounds each identity's command rate
+	limiter *RateLimiter
+	// outputLimits bound the output clients may leave pending
+	outputLimits    OutputBufferLimits
+	normalEvictions uint64
+	pubsubEvictions uint64
+	// requestLimits bound the commands clients may send
+	requestLimits  cache.RequestLimits
+	protocolErrors map[string]uint64
+	// middleware wraps every command, outermost first
+	middleware []Middleware
+	// txns holds prepared distributed transactions
+	txns *txnTable
+}
+
+// clientConn holds the state of a single client connection
+type clientConn struct {
+	id     uint64
+	server *TCPServer
+	conn   net.Conn
+	reader *cache.RESPReader
+	// mu serializes replies with invalidation messages
+	mu     sync.Mutex
+	writer *cache.RESPWriter
+	done   chan struct{}
+
+	// state is changed by the connection's commands
+	state ClientState
+
+	// pushMu guards pushes and state's subscription
+	pushMu sync.Mutex
+	pushes chan []string
+	// closing is set once Shutdown told the client the server is going
+	// away; commands read after that are not run
+	closing atomic.Bool
+
+	// replies and pushesOut account for pending output
+	replies   *outputBuffer
+	pushesOut *outputBuffer
+}
+
+// ID returns the connection's ID, as CLIENT ID replies
+func (cc *clientConn) ID() uint64 {
+	return cc.id
+}
+
+// User returns the ACL user the connection is signed in as
+func (cc *clientConn) User() string {
+	return cc.state.User()
+}
+
+// clientOf returns the connection ctx's command was sent on, nil if it
+// was not sent by a client
+func clientOf(ctx *cache.CommandContext) *clientConn {
+	cc, _ := ctx.Client.(*clientConn)
+	return cc
+}
+
+// NewTCPServer creates a new protocol server for the given cache
+func NewTCPServer(c *cache.Cache, logger *log.Logger) *TCPServer {
+	s := &TCPServer{
+		cache:  c,
+		logger: logger,
+		conns:  make(map[*clientConn]struct{}),
+		ids:    make(map[uint64]*clientConn),
+
+		outputLimits:   DefaultOutputBufferLimits(),
+		requestLimits:  cache.DefaultRequestLimits(),
+		protocolErrors: make(map[string]uint64),
+		middleware:     builtinMiddleware,
+		txns:           newTxnTable(c),
+	}
+	s.tracker = newTracker(s)
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// Start listens on addr and serves clients until the server is shut down
+func (s *TCPServer) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve accepts clients on listener, which may be a TLS listener, until
+// the server is shut down
+func (s *TCPServer) Serve(listener net.Listener) error {
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+	defer listener.Close()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		draining, shuttingDown := s.draining, s.shuttingDown
+		s.mu.Unlock()
+		if draining || shuttingDown {
+			go refuseConnection(conn)
+			continue
+		}
+		go s.handleConnection(conn)
+	}
+}
+
+// handleConnection reads commands from a client and dispatches them until
+// the client disconnects
+func (s *TCPServer) handleConnection(conn net.Conn) {
+	client := &clientConn{
+		server: s,
+		conn:   conn,
+		done:   make(chan struct{}),
+	}
+	client.writer = cache.NewRESPWriter(&limitedWriter{w: conn, client: client})
+
+	s.mu.Lock()
+	if s.shuttingDown {
+		s.mu.Unlock()
+		refuseConnection(conn)
+		return
+	}
+	client.reader = cache.NewRESPReader(conn, s.requestLimits)
+	client.replies = &outputBuffer{limit: s.outputLimits.Normal}
+	client.pushesOut = &outputBuffer{limit: s.outputLimits.PubSub}
+	s.nextID++
+	client.id = s.nextID
+	s.conns[client] = struct{}{}
+	s.ids[client.id] = client
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, client)
+		delete(s.ids, client.id)
+		s.mu.Unlock()
+		s.tracker.forget(client.id)
+		close(client.done)
+		conn.Close()
+	}()
+
+	for {
+		args, err := client.reader.ReadCommand()
+		if err != nil {
+			if client.closing.Load() {
+				return
+			}
+			if reason, ok := cache.ProtocolErrorReasons[err]; ok {
+				s.protocolError(client, reason, err)
+				client.mu.Lock()
+				client.writer.WriteError(err.Error())
+				client.writer.Flush()
+				client.mu.Unlock()
+			} else if err != io.EOF {
+				s.logger.Printf("Connection %s: %v", conn.RemoteAddr(), err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		cmdCtx, cancel := s.commandContext()
+		client.mu.Lock()
+		if client.closing.Load() {
+			client.mu.Unlock()
+			cancel()
+			return
+		}
+		dispatchCommand(&cache.CommandContext{
+			Context: cmdCtx,
+			Cache:   s.cache,
+			Client:  client,
+			Args:    args,
+			Out:     client.writer,
+		})
+		cancel()
+
+		// Pipelined commands are answered in one write
+		var flushErr error
+		if !client.reader.Buffered() {
+			flushErr = client.flushReplies()
+		}
+		quit := client.state.Quitting()
+		if quit && flushErr == nil {
+			flushErr = client.flushReplies()
+		}
+		client.mu.Unlock()
+		if flushErr != nil || quit {
+			return
+		}
+	}
+}
+
+// SetRequestLimits sets the limits on commands from clients connecting
+// from now on
+func (s *TCPServer) SetRequestLimits(limits cache.RequestLimits) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requestLimits = limits
+}
+
+// protocolError counts a client disconnected for a protocol error, logging
+// those caused by a request limit
+func (s *TCPServer) protocolError(client *clientConn, reason string, err error) {
+	s.mu.Lock()
+	s.protocolErrors[reason]++
+	s.mu.Unlock()
+
+	if err != cache.ErrProtocol {
+		s.logger.Printf("Connection %s: closing, %v", client.conn.RemoteAddr(), err)
+	}
+}
+
+// ProtocolErrors returns how many clients were disconnected for each kind
+// of protocol error
+func (s *TCPServer) ProtocolErrors() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[string]uint64, len(s.protocolErrors))
+	for reason, n := range s.protocolErrors {
+		counts[reason] = n
+	}
+	return counts
+}
+
+// SetACL makes the server enforce acl, or no access control if nil
+func (s *TCPServer) SetACL(acl *ACL) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.acl = acl
+}
+
+// accessList returns the ACL in force, if any
+func (s *TCPServer) accessList() *ACL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.acl
+}
+
+// SetCommandTimeout bounds how long a command may run before it is
+// aborted with a TIMEOUT error. Zero disables the limit.
+func (s *TCPServer) SetCommandTimeout(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.commandTimeout = d
+}
+
+// commandContext returns the context a command runs under
+func (s *TCPServer) commandContext() (context.Context, context.CancelFunc) {
+	s.mu.Lock()
+	timeout := s.commandTimeout
+	s.mu.Unlock()
+
+	if timeout <= 0 {
+		return context.WithCancel(s.ctx)
+	}
+	return context.WithTimeout(s.ctx, timeout)
+}
+
+// commandTimedOut reports a command aborted at its deadline
+func (s *TCPServer) commandTimedOut(client *clientConn, cmd *cache.Command) {
+	atomic.AddUint64(&s.timeouts, 1)
+	s.logger.Printf("Connection %s: %s aborted after exceeding its execution deadline", client.conn.RemoteAddr(), cmd.Name)
+}
+
+// CommandTimeouts returns how many commands were aborted at their deadline
+func (s *TCPServer) CommandTimeouts() uint64 {
+	return atomic.LoadUint64(&s.timeouts)
+}
+
+// connByID returns the connected client with the given CLIENT ID
+func (s *TCPServer) connByID(id uint64) *clientConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ids[id]
+}
+
+// refuseConnection tells a client the server is draining and hangs up
+func refuseConnection(conn net.Conn) {
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	w := cache.NewRESPWriter(conn)
+	w.WriteError(errDraining.Error())
+	w.Flush()
+	conn.Close()
+}
+
+// SetDraining makes the server refuse new connections, or accept them
+// again, without affecting connected clients
+func (s *TCPServer) SetDraining(draining bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.draining = draining
+}
+
+// ConnectionCount returns the number of connected clients
+func (s *TCPServer) ConnectionCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+// Shutdown stops accepting new connections and closes the existing ones
+// as they go idle, telling each client the server is going away. Commands
+// already running are finished and answered first. Once ctx is done, the
+// remaining connections are closed and their commands cancelled, and
+// ctx's error is returned.
+func (s *TCPServer) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for {
+		if s.closeIdleConns() {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			s.cancel()
+			s.mu.Lock()
+			for client := range s.conns {
+				client.closing.Store(true)
+				client.conn.Close()
+			}
+			s.mu.Unlock()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// closeIdleConns closes the connections not running a command, reporting
+// whether none are left
+func (s *TCPServer) closeIdleConns() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for client := range s.conns {
+		if client.closing.Load() || !client.mu.TryLock() {
+			continue
+		}
+		client.closing.Store(true)
+		client.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		client.writer.WriteError(errShuttingDown.Error())
+		client.writer.Flush()
+		client.mu.Unlock()
+		client.conn.Close()
+	}
+	return len(s.conns) == 0
+}
@@ -0,0 +1,513 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// Client tracking tells connections when keys they may have cached locally
+// change. In the default mode the server remembers which keys each
+// connection read, optionally only those it opted in or did not opt out
+// of. In BCAST mode it remembers nothing and announces every change to
+// keys under the connection's prefixes. Invalidations are published on
+// invalidateChannel to the connection named by REDIRECT.
+
+// invalidateChannel is the channel invalidation messages are published on
+const invalidateChannel = "__redis__:invalidate"
+
+// defaultTrackingTableMaxKeys caps the keys remembered for default mode
+// tracking
+const defaultTrackingTableMaxKeys = 1000000
+
+// pushQueueSize bounds the invalidation messages waiting to be written to
+// a subscribed connection. A subscriber falling further behind is
+// disconnected, as its clients can no longer trust their local copies.
+const pushQueueSize = 1024
+
+// Values of ClientState.caching
+const (
+	cachingDefault = iota
+	cachingYes
+	cachingNo
+)
+
+var errNoConnection = errors.New("ERR this command needs a client connection")
+
+func init() {
+	cache.RegisterCommands(
+		&cache.Command{Name: "CLIENT", Arity: -2, Flags: cache.FlagAdmin, Handler: clientCommand},
+		&cache.Command{Name: "SUBSCRIBE", Arity: -2, Flags: cache.FlagPubSub, Handler: subscribeCommand},
+		&cache.Command{Name: "UNSUBSCRIBE", Arity: -1, Flags: cache.FlagPubSub, Handler: unsubscribeCommand},
+	)
+}
+
+// trackingOptions are the arguments of CLIENT TRACKING ON
+type trackingOptions struct {
+	redirect uint64
+	bcast    bool
+	prefixes []string
+	optin    bool
+	optout   bool
+}
+
+// tracker holds the tracking tables of a TCPServer and turns keyspace
+// events into invalidation messages
+type tracker struct {
+	server *TCPServer
+
+	mu      sync.Mutex
+	maxKeys int
+	clients map[uint64]trackingOptions
+	// keys maps each key read in default mode to the connections that
+	// read it, and byClient is the reverse, used to forget a connection
+	keys     map[string]map[uint64]struct{}
+	byClient map[uint64]map[string]struct{}
+	// prefixes maps each BCAST prefix to the connections registering it
+	prefixes map[string]map[uint64]struct{}
+	sub      *cache.KeyEventSubscription
+}
+
+func newTracker(server *TCPServer) *tracker {
+	return &tracker{
+		server:   server,
+		maxKeys:  defaultTrackingTableMaxKeys,
+		clients:  make(map[uint64]trackingOptions),
+		keys:     make(map[string]map[uint64]struct{}),
+		byClient: make(map[uint64]map[string]struct{}),
+		prefixes: make(map[string]map[uint64]struct{}),
+	}
+}
+
+// SetTrackingTableMaxKeys caps the keys remembered for connections
+// tracking in default mode. Beyond it, keys are invalidated and forgotten.
+// Zero means no limit.
+func (s *TCPServer) SetTrackingTableMaxKeys(n int) {
+	s.tracker.mu.Lock()
+	defer s.tracker.mu.Unlock()
+
+	s.tracker.maxKeys = n
+}
+
+// enable starts tracking for connection id, replacing earlier options
+func (t *tracker) enable(id uint64, opts trackingOptions) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.forgetLocked(id)
+	t.clients[id] = opts
+	if opts.bcast {
+		prefixes := opts.prefixes
+		if len(prefixes) == 0 {
+			prefixes = []string{""}
+		}
+		for _, prefix := range prefixes {
+			if t.prefixes[prefix] == nil {
+				t.prefixes[prefix] = make(map[uint64]struct{})
+			}
+			t.prefixes[prefix][id] = struct{}{}
+		}
+	}
+	if t.sub == nil {
+		t.sub = t.server.cache.Subscribe("")
+		go t.run(t.sub)
+	}
+}
+
+// forget drops the tracking state of connection id
+func (t *tracker) forget(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.forgetLocked(id)
+}
+
+func (t *tracker) forgetLocked(id uint64) {
+	for key := range t.byClient[id] {
+		if readers := t.keys[key]; readers != nil {
+			delete(readers, id)
+			if len(readers) == 0 {
+				delete(t.keys, key)
+			}
+		}
+	}
+	delete(t.byClient, id)
+	if opts, ok := t.clients[id]; ok && opts.bcast {
+		for prefix, ids := range t.prefixes {
+			delete(ids, id)
+			if len(ids) == 0 {
+				delete(t.prefixes, prefix)
+			}
+		}
+	}
+	delete(t.clients, id)
+}
+
+// track remembers that connection id read keys, invalidating and
+// forgetting other keys when the table is full
+func (t *tracker) track(id uint64, keys []string) {
+	t.mu.Lock()
+	if _, ok := t.clients[id]; !ok {
+		t.mu.Unlock()
+		return
+	}
+	if t.byClient[id] == nil {
+		t.byClient[id] = make(map[string]struct{})
+	}
+	for _, key := range keys {
+		if t.keys[key] == nil {
+			t.keys[key] = make(map[uint64]struct{})
+		}
+		t.keys[key][id] = struct{}{}
+		t.byClient[id][key] = struct{}{}
+	}
+
+	var evicted []invalidation
+	if t.maxKeys > 0 {
+		for key := range t.keys {
+			if len(t.keys) <= t.maxKeys {
+				break
+			}
+			evicted = append(evicted, t.takeReadersLocked(key)...)
+		}
+	}
+	t.mu.Unlock()
+
+	t.deliver(evicted)
+}
+
+// run invalidates keys as they change. Lost events cannot be replayed, so
+// every tracking connection is told to flush instead.
+func (t *tracker) run(sub *cache.KeyEventSubscription) {
+	var last uint64
+	for event := range sub.C {
+		gap := last != 0 && event.Seq != last+1
+		last = event.Seq
+		if gap || event.Type == cache.KeyEventFlush {
+			t.flushAll()
+			continue
+		}
+		t.invalidate(event.Key)
+	}
+}
+
+// invalidation is a message to publish to the connection with ID redirect.
+// Nil keys means every key.
+type invalidation struct {
+	redirect uint64
+	keys     []string
+}
+
+// takeReadersLocked forgets key and returns the invalidations for the
+// connections that read it
+func (t *tracker) takeReadersLocked(key string) []invalidation {
+	var targets []invalidation
+	for id := range t.keys[key] {
+		delete(t.byClient[id], key)
+		targets = append(targets, invalidation{redirect: t.clients[id].redirect, keys: []string{key}})
+	}
+	delete(t.keys, key)
+	return targets
+}
+
+// invalidate tells the connections that read key, or registered a prefix
+// of it, that it changed
+func (t *tracker) invalidate(key string) {
+	t.mu.Lock()
+	targets := t.takeReadersLocked(key)
+	for prefix, ids := range t.prefixes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for id := range ids {
+			targets = append(targets, invalidation{redirect: t.clients[id].redirect, keys: []string{key}})
+		}
+	}
+	t.mu.Unlock()
+
+	t.deliver(targets)
+}
+
+// flushAll tells every tracking connection to drop its local copies
+func (t *tracker) flushAll() {
+	t.mu.Lock()
+	targets := make([]invalidation, 0, len(t.clients))
+	for id, opts := range t.clients {
+		targets = append(targets, invalidation{redirect: opts.redirect})
+		delete(t.byClient, id)
+	}
+	t.keys = make(map[string]map[uint64]struct{})
+	t.mu.Unlock()
+
+	t.deliver(targets)
+}
+
+// deliver queues invalidations on the connections they redirect to
+func (t *tracker) deliver(targets []invalidation) {
+	for _, target := range targets {
+		if cc := t.server.connByID(target.redirect); cc != nil {
+			cc.push(target.keys)
+		}
+	}
+}
+
+// push queues an invalidation message if the connection is subscribed to
+// invalidateChannel, disconnecting it when it falls too far behind or its
+// queued messages pass the pub/sub output buffer limit
+func (cc *clientConn) push(keys []string) {
+	cc.pushMu.Lock()
+	defer cc.pushMu.Unlock()
+
+	if !cc.state.Subscribed() {
+		return
+	}
+	if err := cc.pushesOut.add(pushSize(keys)); err != nil {
+		cc.server.outputLimitExceeded(cc, OutputClassPubSub)
+		cc.conn.Close()
+		return
+	}
+	select {
+	case cc.pushes <- keys:
+	default:
+		cc.server.outputLimitExceeded(cc, OutputClassPubSub)
+		cc.conn.Close()
+	}
+}
+
+// writePushes writes queued invalidation messages between replies until
+// the connection closes
+func (cc *clientConn) writePushes(pushes <-chan []string) {
+	for {
+		select {
+		case <-cc.done:
+			return
+		case keys := <-pushes:
+			cc.mu.Lock()
+			cc.writer.WriteArrayHeader(3)
+			cc.writer.WriteBulkString("message")
+			cc.writer.WriteBulkString(invalidateChannel)
+			if keys == nil {
+				cc.writer.WriteNull()
+			} else {
+				cc.writer.WriteStringArray(keys)
+			}
+			err := cc.flushReplies()
+			cc.mu.Unlock()
+			cc.pushesOut.release(pushSize(keys))
+			if err != nil {
+				cc.conn.Close()
+				return
+			}
+		}
+	}
+}
+
+// trackRead records the keys a command is about to read for a connection
+// tracking in default mode, honoring CLIENT CACHING. Keys are tracked
+// before they are read so a concurrent write cannot go unannounced.
+func (cc *clientConn) trackRead(cmd *cache.Command, args [][]byte) {
+	caching := cc.state.takeCaching()
+
+	opts := cc.state.Tracking()
+	if opts == nil || opts.bcast || cmd.Keys == nil || cmd.Flags&cache.FlagReadOnly == 0 {
+		return
+	}
+	if (opts.optin && caching != cachingYes) || (opts.optout && caching == cachingNo) {
+		return
+	}
+	cc.server.tracker.track(cc.id, cmd.Keys(args))
+}
+
+// clientCommand implements CLIENT ID, TRACKING, CACHING, GETREDIR and
+// TRACKINGINFO
+func clientCommand(ctx *cache.CommandContext) error {
+	cc := clientOf(ctx)
+	if cc == nil {
+		return errNoConnection
+	}
+
+	switch strings.ToUpper(string(ctx.Args[1])) {
+	case "ID":
+		ctx.Out.WriteInteger(int64(cc.id))
+	case "TRACKING":
+		return clientTrackingCommand(ctx)
+	case "CACHING":
+		if len(ctx.Args) != 3 {
+			return cache.ErrSyntax
+		}
+		var yes bool
+		switch strings.ToLower(string(ctx.Args[2])) {
+		case "yes":
+			yes = true
+		case "no":
+		default:
+			return cache.ErrSyntax
+		}
+		if err := cc.state.SetCaching(yes); err != nil {
+			return err
+		}
+		ctx.Out.WriteSimpleString("OK")
+	case "GETREDIR":
+		if opts := cc.state.Tracking(); opts == nil {
+			ctx.Out.WriteInteger(-1)
+		} else {
+			ctx.Out.WriteInteger(int64(opts.redirect))
+		}
+	case "TRACKINGINFO":
+		var flags, prefixes []string
+		redirect := int64(-1)
+		if opts := cc.state.Tracking(); opts == nil {
+			flags = []string{"off"}
+		} else {
+			flags = []string{"on"}
+			if opts.bcast {
+				flags = append(flags, "bcast")
+			}
+			if opts.optin {
+				flags = append(flags, "optin")
+			}
+			if opts.optout {
+				flags = append(flags, "optout")
+			}
+			if caching := cc.state.Caching(); caching == cachingYes {
+				flags = append(flags, "caching-yes")
+			} else if caching == cachingNo {
+				flags = append(flags, "caching-no")
+			}
+			redirect = int64(opts.redirect)
+			prefixes = opts.prefixes
+		}
+		ctx.Out.WriteArrayHeader(6)
+		ctx.Out.WriteBulkString("flags")
+		ctx.Out.WriteStringArray(flags)
+		ctx.Out.WriteBulkString("redirect")
+		ctx.Out.WriteInteger(redirect)
+		ctx.Out.WriteBulkString("prefixes")
+		ctx.Out.WriteStringArray(prefixes)
+	default:
+		return fmt.Errorf("ERR unknown subcommand '%s'", ctx.Args[1])
+	}
+	return nil
+}
+
+// clientTrackingCommand implements CLIENT TRACKING ON|OFF [REDIRECT id]
+// [BCAST] [PREFIX prefix ...] [OPTIN] [OPTOUT]. Invalidations are only
+// published to another connection, so ON needs REDIRECT.
+func clientTrackingCommand(ctx *cache.CommandContext) error {
+	cc := clientOf(ctx)
+	if len(ctx.Args) < 3 {
+		return cache.ErrSyntax
+	}
+
+	switch strings.ToLower(string(ctx.Args[2])) {
+	case "off":
+		cc.state.DisableTracking()
+		cc.server.tracker.forget(cc.id)
+		ctx.Out.WriteSimpleString("OK")
+		return nil
+	case "on":
+	default:
+		return cache.ErrSyntax
+	}
+
+	var opts trackingOptions
+	redirect := false
+	for i := 3; i < len(ctx.Args); i++ {
+		switch strings.ToUpper(string(ctx.Args[i])) {
+		case "REDIRECT":
+			if i+1 >= len(ctx.Args) {
+				return cache.ErrSyntax
+			}
+			id, err := cache.ParseInt(ctx.Args[i+1])
+			if err != nil || id <= 0 {
+				return cache.ErrNotInteger
+			}
+			opts.redirect, redirect = uint64(id), true
+			i++
+		case "BCAST":
+			opts.bcast = true
+		case "PREFIX":
+			if i+1 >= len(ctx.Args) {
+				return cache.ErrSyntax
+			}
+			opts.prefixes = append(opts.prefixes, string(ctx.Args[i+1]))
+			i++
+		case "OPTIN":
+			opts.optin = true
+		case "OPTOUT":
+			opts.optout = true
+		default:
+			return cache.ErrSyntax
+		}
+	}
+
+	switch {
+	case !redirect:
+		return fmt.Errorf("ERR tracking needs REDIRECT to a connection subscribed to %s", invalidateChannel)
+	case cc.server.connByID(opts.redirect) == nil:
+		return errors.New("ERR The client ID you want redirect to does not exist")
+	case len(opts.prefixes) > 0 && !opts.bcast:
+		return errors.New("ERR PREFIX option requires BCAST mode to be enabled")
+	case opts.optin && opts.optout:
+		return errors.New("ERR You can't use OPTIN and OPTOUT at the same time")
+	case opts.bcast && (opts.optin || opts.optout):
+		return errors.New("ERR OPTIN and OPTOUT are not compatible with BCAST")
+	}
+
+	cc.state.EnableTracking(opts)
+	cc.server.tracker.enable(cc.id, opts)
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// subscribeCommand implements SUBSCRIBE for invalidateChannel, the only
+// channel this server publishes on
+func subscribeCommand(ctx *cache.CommandContext) error {
+	cc := clientOf(ctx)
+	if cc == nil {
+		return errNoConnection
+	}
+	for _, channel := range ctx.Args[1:] {
+		if string(channel) != invalidateChannel {
+			return fmt.Errorf("ERR only %s can be subscribed to", invalidateChannel)
+		}
+	}
+
+	cc.pushMu.Lock()
+	if cc.pushes == nil {
+		cc.pushes = make(chan []string, pushQueueSize)
+		go cc.writePushes(cc.pushes)
+	}
+	cc.state.Subscribe()
+	cc.pushMu.Unlock()
+
+	for range ctx.Args[1:] {
+		ctx.Out.WriteArrayHeader(3)
+		ctx.Out.WriteBulkString("subscribe")
+		ctx.Out.WriteBulkString(invalidateChannel)
+		ctx.Out.WriteInteger(1)
+	}
+	return nil
+}
+
+// unsubscribeCommand implements UNSUBSCRIBE. Invalidations already queued
+// are still written.
+func unsubscribeCommand(ctx *cache.CommandContext) error {
+	cc := clientOf(ctx)
+	if cc == nil {
+		return errNoConnection
+	}
+
+	cc.pushMu.Lock()
+	cc.state.Unsubscribe()
+	cc.pushMu.Unlock()
+
+	ctx.Out.WriteArrayHeader(3)
+	ctx.Out.WriteBulkString("unsubscribe")
+	ctx.Out.WriteBulkString(invalidateChannel)
+	ctx.Out.WriteInteger(0)
+	return nil
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+)
+
+// Distributed transactions let a coordinator, such as client.Ring's Tx,
+// apply writes to keys on several nodes with two-phase commit. In the
+// prepare phase each node checks its share of the writes and locks their
+// keys; other writes to locked keys are refused with TRYAGAIN until the
+// transaction commits or aborts. Once every node has prepared, commit runs
+// the staged writes. A node aborts a prepared transaction by itself when
+// its timeout passes, so a coordinator that goes away does not leave keys
+// locked, at the cost of a late commit failing on that node.
+//
+// The locks are kept in the cache, and every server checks them before
+// writing: RESP commands declaring their keys, the HTTP key, batch, bulk
+// and leaderboard APIs and memcached storage commands are refused while a
+// key is locked. Writes that do not name their keys, such as DELPATTERN,
+// FLUSHALL and tag invalidation, and Go code calling the Cache directly
+// are not.
+
+var errUnknownTxn = errors.New("ERR unknown or expired transaction")
+
+// txnTable holds the prepared transactions of a node. The keys they lock
+// are kept in the cache.
+type txnTable struct {
+	cache *cache.Cache
+	mu    sync.Mutex
+	txns  map[string]*preparedTxn
+}
+
+// preparedTxn is a transaction waiting for commit
+type preparedTxn struct {
+	id       string
+	commands [][][]byte
+	keys     []string
+	timer    *time.Timer
+}
+
+func newTxnTable(c *cache.Cache) *txnTable {
+	return &txnTable{cache: c, txns: make(map[string]*preparedTxn)}
+}
+
+// prepare locks keys for the transaction id, which will run commands on
+// commit, and aborts it after timeout. It fails if another transaction
+// holds any of the keys.
+func (t *txnTable) prepare(id string, keys []string, commands [][][]byte, timeout time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, exists := t.txns[id]; exists {
+		return errors.New("ERR transaction already prepared")
+	}
+	if err := t.cache.LockKeys(id, keys); err != nil {
+		return err
+	}
+	txn := &preparedTxn{id: id, commands: commands, keys: keys}
+	txn.timer = time.AfterFunc(timeout, func() { t.abort(id) })
+	t.txns[id] = txn
+	return nil
+}
+
+// take removes a prepared transaction for commit, leaving its keys locked
+// until release
+func (t *txnTable) take(id string) (*preparedTxn, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	txn, ok := t.txns[id]
+	if ok {
+		txn.timer.Stop()
+		delete(t.txns, id)
+	}
+	return txn, ok
+}
+
+// release unlocks the keys of a committed transaction
+func (t *txnTable) release(txn *preparedTxn) {
+	t.cache.UnlockKeys(txn.id, txn.keys)
+}
+
+// abort drops a prepared transaction and unlocks its keys, reporting
+// whether it was pending
+func (t *txnTable) abort(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	txn, ok := t.txns[id]
+	if !ok {
+		return false
+	}
+	txn.timer.Stop()
+	delete(t.txns, id)
+	t.release(txn)
+	return true
+}
+
+// txnLockMiddleware refuses writes to keys locked by prepared transactions
+func txnLockMiddleware(next cache.CommandHandler) cache.CommandHandler {
+	return func(ctx *cache.CommandContext) error {
+		cmd := ctx.Command
+		if cmd.Flags&cache.FlagWrite != 0 && cmd.Keys != nil {
+			if err := ctx.Cache.CheckKeyLocks(cmd.Keys(ctx.Args)...); err != nil {
+				return err
+			}
+		}
+		return next(ctx)
+	}
+}
+
+func init() {
+	cache.RegisterCommands(
+		&cache.Command{Name: "TXN.PREPARE", Arity: -5, Flags: cache.FlagWrite | cache.FlagSelfLogged, Handler: txnPrepareCommand},
+		&cache.Command{Name: "TXN.COMMIT", Arity: 2, Flags: cache.FlagWrite | cache.FlagSelfLogged, Handler: txnCommitCommand},
+		&cache.Command{Name: "TXN.ABORT", Arity: 2, Flags: cache.FlagWrite | cache.FlagSelfLogged, Handler: txnAbortCommand},
+	)
+}
+
+// txnPrepareCommand implements
+// TXN.PREPARE txid timeout-ms argc arg [arg ...] [argc arg [arg ...] ...],
+// staging each write command given as its argument count and arguments.
+// Every command must write keys it declares, and pass the client's ACL.
+// Keys are prefixed with the selected namespace and normalized as those of
+// commands sent directly are, so the transaction locks and writes the keys
+// those would.
+func txnPrepareCommand(ctx *cache.CommandContext) error {
+	cc := clientOf(ctx)
+	if cc == nil {
+		return errNoConnection
+	}
+	id := string(ctx.Args[1])
+	timeoutMs, err := cache.ParseInt(ctx.Args[2])
+	if err != nil || timeoutMs <= 0 {
+		return errors.New("ERR invalid transaction timeout")
+	}
+
+	var commands [][][]byte
+	var keys []string
+	for i := 3; i < len(ctx.Args); {
+		argc, err := cache.ParseInt(ctx.Args[i])
+		if err != nil || argc < 1 || argc > int64(len(ctx.Args)-i-1) {
+			return cache.ErrSyntax
+		}
+		args := make([][]byte, argc)
+		for j := range args {
+			args[j] = append([]byte(nil), ctx.Args[i+1+j]...)
+		}
+		i += 1 + int(argc)
+
+		cmd := cache.LookupCommand(string(args[0]))
+		if cmd == nil {
+			return fmt.Errorf("ERR unknown command '%s'", args[0])
+		}
+		if (cmd.Arity > 0 && len(args) != cmd.Arity) || (cmd.Arity < 0 && len(args) < -cmd.Arity) {
+			return fmt.Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(cmd.Name))
+		}
+		if cmd.Flags&cache.FlagWrite == 0 || cmd.Flags&cache.FlagAdmin != 0 || cmd.Keys == nil {
+			return fmt.Errorf("ERR '%s' cannot run in a distributed transaction", strings.ToLower(cmd.Name))
+		}
+		cc.selectedKeys(cmd, args)
+		if err := ctx.Cache.NormalizeArgs(cmd, args); err != nil {
+			return err
+		}
+		if err := cc.checkACL(cmd, args); err != nil {
+			return err
+		}
+		commands = append(commands, args)
+		keys = append(keys, cmd.Keys(args)...)
+	}
+
+	if err := cc.server.txns.prepare(id, keys, commands, time.Duration(timeoutMs)*time.Millisecond); err != nil {
+		return err
+	}
+	ctx.Out.WriteSimpleString("OK")
+	return nil
+}
+
+// txnCommitCommand implements TXN.COMMIT txid, running the staged commands
+// in order and replying with an array of their replies. Each command is
+// logged for replication as it runs.
+func txnCommitCommand(ctx *cache.CommandContext) error {
+	cc := clientOf(ctx)
+	if cc == nil {
+		return errNoConnection
+	}
+	txn, ok := cc.server.txns.take(string(ctx.Args[1]))
+	if !ok {
+		return errUnknownTxn
+	}
+	defer cc.server.txns.release(txn)
+
+	ctx.Out.WriteArrayHeader(len(txn.commands))
+	for _, args := range txn.commands {
+		staged := &cache.CommandContext{
+			Context: ctx.Context,
+			Cache:   ctx.Cache,
+			Command: cache.LookupCommand(string(args[0])),
+			Args:    args,
+			Out:     ctx.Out,
+		}
+		if err := executeCommand(staged); err != nil {
+			ctx.Out.WriteError(err.Error())
+		}
+	}
+	return nil
+}
+
+// txnAbortCommand implements TXN.ABORT txid, replying 1 if the transaction
+// was pending
+func txnAbortCommand(ctx *cache.CommandContext) error {
+	cc := clientOf(ctx)
+	if cc == nil {
+		return errNoConnection
+	}
+	ctx.Out.WriteInteger(cache.BoolInt(cc.server.txns.abort(string(ctx.Args[1]))))
+	return nil
+}
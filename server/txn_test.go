@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hamisionesmus/distributed-cache/cache"
+	"github.com/hamisionesmus/distributed-cache/client"
+)
+
+// startTCPServer serves c over RESP on a local port until the test ends
+func startTCPServer(t *testing.T, c *cache.Cache) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewTCPServer(c, log.New(io.Discard, "", 0))
+	go s.Serve(listener)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	})
+	return listener.Addr().String()
+}
+
+// newTxnClient returns a client of a new server for c
+func newTxnClient(t *testing.T, c *cache.Cache) *client.Client {
+	t.Helper()
+	cl, err := client.NewClient(&client.Options{Addresses: []string{startTCPServer(t, c)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cl.Close() })
+	return cl
+}
+
+func newTxnCache(t *testing.T) *cache.Cache {
+	t.Helper()
+	c, err := cache.NewCacheFromConfig(cache.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// do runs a command that must succeed
+func do(t *testing.T, cl *client.Client, args ...interface{}) interface{} {
+	t.Helper()
+	reply, err := cl.Do(context.Background(), args...)
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	return reply
+}
+
+// wantLocked fails unless err is the reply to a write of a locked key
+func wantLocked(t *testing.T, what string, err error) {
+	t.Helper()
+	if err == nil || !strings.HasPrefix(err.Error(), "TRYAGAIN") {
+		t.Errorf("%s = %v, want TRYAGAIN", what, err)
+	}
+}
+
+// TestTxnPrepareNormalizesAndLocksKeys prepares a write to a key spelled
+// differently than it is stored, and checks that every server refuses
+// writes to the stored key until the transaction commits
+func TestTxnPrepareNormalizesAndLocksKeys(t *testing.T) {
+	ctx := context.Background()
+	c := newTxnCache(t)
+	if err := c.SetNamespaceOptions("user", cache.NamespaceOptions{Keys: cache.KeyNormalization{FoldCase: true}}); err != nil {
+		t.Fatal(err)
+	}
+	cl := newTxnClient(t, c)
+
+	if reply := do(t, cl, "TXN.PREPARE", "tx1", 5000, 3, "SET", "USER:1", "staged"); reply != "OK" {
+		t.Fatalf("TXN.PREPARE replied %v", reply)
+	}
+
+	_, err := cl.Do(ctx, "SET", "user:1", "direct")
+	wantLocked(t, "SET user:1", err)
+
+	h := NewHTTPServer(c, log.New(io.Discard, "", 0))
+	w := httptest.NewRecorder()
+	h.server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/v1/keys/user:1", strings.NewReader("direct")))
+	if w.Code != http.StatusConflict {
+		t.Errorf("HTTP PUT of a locked key answered %d, want %d", w.Code, http.StatusConflict)
+	}
+
+	if reply := memcachedCommand(t, c, "set user:1 0 0 6\r\ndirect\r\n"); !strings.HasPrefix(reply, "SERVER_ERROR TRYAGAIN") {
+		t.Errorf("memcached set of a locked key answered %q", reply)
+	}
+
+	replies, ok := do(t, cl, "TXN.COMMIT", "tx1").([]interface{})
+	if !ok || len(replies) != 1 || replies[0] != "OK" {
+		t.Fatalf("TXN.COMMIT replied %v", replies)
+	}
+	if value, _ := c.Get(ctx, "user:1"); string(value) != "staged" {
+		t.Errorf("user:1 = %q after commit, want staged", value)
+	}
+	do(t, cl, "SET", "user:1", "direct")
+}
+
+// memcachedCommand sends one command to a memcached server for c and
+// returns the first line of the reply
+func memcachedCommand(t *testing.T, c *cache.Cache, command string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewMemcachedServer(c, log.New(io.Discard, "", 0))
+	go s.Serve(listener)
+	defer s.Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err := io.WriteString(conn, command); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+func TestTxnAbortReleasesKeys(t *testing.T) {
+	ctx := context.Background()
+	c := newTxnCache(t)
+	cl := newTxnClient(t, c)
+
+	do(t, cl, "TXN.PREPARE", "tx1", 5000, 3, "SET", "k", "staged")
+	if reply := do(t, cl, "TXN.ABORT", "tx1"); reply != int64(1) {
+		t.Errorf("TXN.ABORT of a pending transaction replied %v, want 1", reply)
+	}
+	if reply := do(t, cl, "TXN.ABORT", "tx1"); reply != int64(0) {
+		t.Errorf("second TXN.ABORT replied %v, want 0", reply)
+	}
+	if _, err := cl.Do(ctx, "TXN.COMMIT", "tx1"); err == nil || err.Error() != errUnknownTxn.Error() {
+		t.Errorf("TXN.COMMIT after abort = %v, want %v", err, errUnknownTxn)
+	}
+	do(t, cl, "SET", "k", "direct")
+	if value, _ := c.Get(ctx, "k"); string(value) != "direct" {
+		t.Errorf("k = %q, want the write made after the abort", value)
+	}
+}
+
+func TestTxnTimeoutAbortsTransaction(t *testing.T) {
+	ctx := context.Background()
+	c := newTxnCache(t)
+	cl := newTxnClient(t, c)
+
+	do(t, cl, "TXN.PREPARE", "tx1", 20, 3, "SET", "k", "staged")
+	deadline := time.Now().Add(5 * time.Second)
+	for c.CheckKeyLocks("k") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the transaction still holds k after its timeout")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := cl.Do(ctx, "TXN.COMMIT", "tx1"); err == nil || err.Error() != errUnknownTxn.Error() {
+		t.Errorf("TXN.COMMIT after the timeout = %v, want %v", err, errUnknownTxn)
+	}
+	if _, ok := c.Get(ctx, "k"); ok {
+		t.Error("a timed out transaction applied its write")
+	}
+}
+
+func TestTxnPrepareConflicts(t *testing.T) {
+	ctx := context.Background()
+	c := newTxnCache(t)
+	cl := newTxnClient(t, c)
+
+	do(t, cl, "TXN.PREPARE", "tx1", 5000, 3, "SET", "a", "1", 3, "SET", "b", "1")
+	_, err := cl.Do(ctx, "TXN.PREPARE", "tx2", 5000, 3, "SET", "c", "2", 3, "SET", "b", "2")
+	wantLocked(t, "TXN.PREPARE of a locked key", err)
+	if _, err := cl.Do(ctx, "TXN.PREPARE", "tx1", 5000, 3, "SET", "d", "1"); err == nil {
+		t.Error("TXN.PREPARE reused the id of a pending transaction")
+	}
+	if _, err := cl.Do(ctx, "TXN.PREPARE", "tx3", 5000, 2, "GET", "d"); err == nil {
+		t.Error("TXN.PREPARE staged a read")
+	}
+
+	// The refused transaction locked none of its keys
+	do(t, cl, "SET", "c", "direct")
+	do(t, cl, "TXN.ABORT", "tx1")
+	do(t, cl, "TXN.PREPARE", "tx2", 5000, 3, "SET", "c", "2", 3, "SET", "b", "2")
+	do(t, cl, "TXN.COMMIT", "tx2")
+	if value, _ := c.Get(ctx, "b"); string(value) != "2" {
+		t.Errorf("b = %q, want the write of the second transaction", value)
+	}
+}